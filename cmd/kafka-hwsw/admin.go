@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+func newAdminCommand() *cobra.Command {
+	var (
+		topic             string
+		partitions        int
+		replicationFactor int
+		topicConfigs      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage topics and consumer groups without the Kafka shell scripts",
+	}
+	cmd.PersistentFlags().StringVarP(&topic, "topic", "t", "", "topic name")
+	bindEnv(cmd.PersistentFlags(), "topic", "KAFKA_TOPIC")
+
+	// needsTopic runs after the environment has been applied, so the topic
+	// may come from KAFKA_TOPIC as well as --topic.
+	needsTopic := func(cmd *cobra.Command, args []string) error {
+		if topic == "" {
+			return errors.New("--topic is required")
+		}
+		return nil
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List topics",
+		Args:  cobra.NoArgs,
+		Run: withAdmin("list", func(admin sarama.ClusterAdmin) error {
+			return listTopics(admin)
+		}),
+	}
+
+	create := &cobra.Command{
+		Use:     "create",
+		Short:   "Create a topic",
+		Example: "  kafka-hwsw admin create -t user-events --partitions 6 --topic-config retention.ms=3600000",
+		Args:    cobra.NoArgs,
+		PreRunE: needsTopic,
+		Run: withAdmin("create", func(admin sarama.ClusterAdmin) error {
+			return createTopic(admin, topic, int32(partitions), int16(replicationFactor), topicConfigs)
+		}),
+	}
+	create.Flags().IntVar(&partitions, "partitions", 3, "number of partitions")
+	create.Flags().IntVar(&replicationFactor, "replication-factor", 3, "replication factor")
+	create.Flags().StringVar(&topicConfigs, "topic-config", "", "comma-separated topic configs, e.g. retention.ms=3600000,cleanup.policy=compact")
+
+	del := &cobra.Command{
+		Use:     "delete",
+		Short:   "Delete a topic",
+		Args:    cobra.NoArgs,
+		PreRunE: needsTopic,
+		Run: withAdmin("delete", func(admin sarama.ClusterAdmin) error {
+			if err := admin.DeleteTopic(topic); err != nil {
+				return err
+			}
+			slog.Info("Deleted topic", "topic", topic)
+			return nil
+		}),
+	}
+
+	describe := &cobra.Command{
+		Use:     "describe",
+		Short:   "Show partitions, leaders, replicas, ISR and ongoing reassignments",
+		Args:    cobra.NoArgs,
+		PreRunE: needsTopic,
+		Run: withAdmin("describe", func(admin sarama.ClusterAdmin) error {
+			return describeTopic(admin, topic)
+		}),
+	}
+
+	alterPartitions := &cobra.Command{
+		Use:     "alter-partitions",
+		Short:   "Increase the partition count",
+		Args:    cobra.NoArgs,
+		PreRunE: needsTopic,
+		Run: withAdmin("alter-partitions", func(admin sarama.ClusterAdmin) error {
+			if err := admin.CreatePartitions(topic, int32(partitions), nil, false); err != nil {
+				return err
+			}
+			slog.Info("Increased partition count", "topic", topic, "partitions", partitions)
+			return nil
+		}),
+	}
+	alterPartitions.Flags().IntVar(&partitions, "partitions", 0, "new total number of partitions")
+	alterPartitions.MarkFlagRequired("partitions")
+
+	configs := &cobra.Command{
+		Use:     "configs",
+		Short:   "List the topic's configuration",
+		Args:    cobra.NoArgs,
+		PreRunE: needsTopic,
+		Run: withAdmin("configs", func(admin sarama.ClusterAdmin) error {
+			return listConfigs(admin, topic)
+		}),
+	}
+
+	cmd.AddCommand(list, create, del, describe, alterPartitions, configs,
+		newElectLeadersCommand(&topic), newReassignCommand(&topic), newAdminGroupsCommand(&topic))
+	return cmd
+}
+
+// withAdmin connects a cluster admin for the duration of fn.
+func withAdmin(command string, fn func(admin sarama.ClusterAdmin) error) func(*cobra.Command, []string) {
+	return func(*cobra.Command, []string) {
+		admin, err := kafka.NewClusterAdmin(brokers, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to connect", "error", err)
+		}
+		defer admin.Close()
+
+		if err := fn(admin); err != nil {
+			logging.Fatal("Command failed", "command", command, "error", err)
+		}
+	}
+}
+
+func listTopics(admin sarama.ClusterAdmin) error {
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TOPIC\tPARTITIONS\tREPLICATION\n")
+	for _, name := range names {
+		detail := topics[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, detail.NumPartitions, detail.ReplicationFactor)
+	}
+	return w.Flush()
+}
+
+func createTopic(admin sarama.ClusterAdmin, topic string, partitions int32, replicationFactor int16, configs string) error {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
+		ConfigEntries:     make(map[string]*string),
+	}
+
+	if configs != "" {
+		for _, entry := range strings.Split(configs, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return fmt.Errorf("invalid config %q, expected key=value", entry)
+			}
+			detail.ConfigEntries[key] = &value
+		}
+	}
+
+	if err := admin.CreateTopic(topic, detail, false); err != nil {
+		return err
+	}
+
+	slog.Info("Created topic", "topic", topic, "partitions", partitions, "replication_factor", replicationFactor)
+	return nil
+}
+
+func describeTopic(admin sarama.ClusterAdmin, topic string) error {
+	partitions, err := kafka.DescribeReplicas(admin, topic)
+	if err != nil {
+		return err
+	}
+	return printReplicas(topic, partitions)
+}
+
+func listConfigs(admin sarama.ClusterAdmin, topic string) error {
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tVALUE\tSOURCE\n")
+	for _, entry := range entries {
+		value := entry.Value
+		if entry.Sensitive {
+			value = "<sensitive>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Name, value, entry.Source)
+	}
+	return w.Flush()
+}
+
+func joinIDs(ids []int32) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type aggregateOptions struct {
+	topic              string
+	outputTopic        string
+	groupID            string
+	transactionalID    string
+	registryURL        string
+	checkpointEvery    int
+	checkpointInterval time.Duration
+	resilience         resilienceOptions
+}
+
+func newAggregateCommand() *cobra.Command {
+	var o aggregateOptions
+
+	cmd := &cobra.Command{
+		Use:   "aggregate",
+		Short: "Keep purchase totals per user and event counts per session, checkpointed with the offsets",
+		Long: `Aggregate user events into purchase totals per user and event counts per
+session. Changed aggregates are published to the output topic and the
+consumed offsets committed in the same transaction; the output topic also
+holds the state a partition is restored from when it is assigned.
+
+The output topic needs at least as many partitions as the input topic and
+should be compacted.`,
+		Example: "  kafka-hwsw admin create -t user-aggregates --partitions 3 --topic-config cleanup.policy=compact\n" +
+			"  kafka-hwsw aggregate -t test-topic --output-topic user-aggregates",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runAggregate(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to aggregate, keyed by user ID")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "user-aggregates", "compacted topic the aggregates are published to and restored from")
+	bindEnv(flags, "output-topic", "AGGREGATE_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "user-aggregator", "consumer group ID")
+	bindEnv(flags, "group", "AGGREGATE_GROUP_ID")
+	flags.StringVar(&o.transactionalID, "transactional-id", "user-aggregator", "transactional ID, unique per instance")
+	bindEnv(flags, "transactional-id", "AGGREGATE_TRANSACTIONAL_ID")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.IntVar(&o.checkpointEvery, "checkpoint-every", 100, "messages per partition between checkpoints")
+	bindEnv(flags, "checkpoint-every", "CHECKPOINT_EVERY")
+	flags.DurationVar(&o.checkpointInterval, "checkpoint-interval", 5*time.Second, "longest time between checkpoints")
+	bindEnv(flags, "checkpoint-interval", "CHECKPOINT_INTERVAL")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runAggregate(o aggregateOptions) {
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"transactional_id", o.transactionalID,
+		"checkpoint_every", o.checkpointEvery,
+		"checkpoint_interval", o.checkpointInterval,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Aggregator", settings...)
+
+	// Claims checkpoint once more on the way out, which only takes a
+	// transaction per partition.
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithCheckpoint(o.checkpointEvery, o.checkpointInterval),
+	)
+	opts = append(opts, o.resilience.options()...)
+
+	var aggregator *kafka.Aggregator
+	o.resilience.connect(ctx, "aggregator", func() (err error) {
+		aggregator, err = kafka.NewAggregator(brokers, o.topic, o.outputTopic, o.groupID, o.transactionalID, opts...)
+		return err
+	})
+	defer aggregator.Close()
+
+	if err := aggregator.Consume(ctx); err != nil {
+		logging.Fatal("Error aggregating messages", "error", err)
+	}
+
+	slog.Info("Aggregator stopped")
+}
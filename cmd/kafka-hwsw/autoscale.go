@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/generator"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type autoscaleOptions struct {
+	topic          string
+	groupID        string
+	minConsumers   int
+	maxConsumers   int
+	scaleUpLag     int64
+	scaleDownLag   int64
+	interval       time.Duration
+	cooldown       time.Duration
+	processingTime time.Duration
+	produceRate    int
+	produceFor     time.Duration
+	duration       time.Duration
+	events         eventOptions
+}
+
+func newAutoscaleCommand() *cobra.Command {
+	var o autoscaleOptions
+
+	cmd := &cobra.Command{
+		Use:   "autoscale",
+		Short: "Run a consumer group in-process and add or remove members as its lag changes",
+		Long: `Supervise a consumer group in one process: start --min-consumers members,
+check the group's lag every --interval, start another member whenever the
+total lag is above --scale-up-lag and stop one once it is back at or below
+--scale-down-lag, waiting --cooldown between steps so the rebalance each
+step causes can settle. The group never grows beyond --max-consumers, by
+default one member per partition.
+
+Every member sleeps --processing-time per message to stand in for real
+work, so a single member falls behind quickly. --produce-rate sends
+generated events to the topic from the same process, which makes the demo
+self-contained with --mode memory; against a cluster any producer will do.
+The members log their rebalances, and the supervisor logs every scaling
+step and how long the lag took to recover.`,
+		Example: "  kafka-hwsw --mode memory autoscale --produce-rate 120 --produce-for 30s --duration 1m\n" +
+			"  kafka-hwsw autoscale --topic user-events --scale-up-lag 5000 --max-consumers 6",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runAutoscale(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic the group consumes")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "autoscale-group", "consumer group to scale")
+	bindEnv(flags, "group", "AUTOSCALE_GROUP_ID")
+	flags.IntVar(&o.minConsumers, "min-consumers", 1, "members that always run")
+	bindEnv(flags, "min-consumers", "AUTOSCALE_MIN_CONSUMERS")
+	flags.IntVar(&o.maxConsumers, "max-consumers", 0, "most members to run, 0 is one per partition")
+	bindEnv(flags, "max-consumers", "AUTOSCALE_MAX_CONSUMERS")
+	flags.Int64Var(&o.scaleUpLag, "scale-up-lag", 500, "add a member while the total lag is above this")
+	bindEnv(flags, "scale-up-lag", "AUTOSCALE_SCALE_UP_LAG")
+	flags.Int64Var(&o.scaleDownLag, "scale-down-lag", 50, "remove a member while the total lag is at or below this")
+	bindEnv(flags, "scale-down-lag", "AUTOSCALE_SCALE_DOWN_LAG")
+	flags.DurationVar(&o.interval, "interval", 2*time.Second, "how often to check the lag")
+	bindEnv(flags, "interval", "AUTOSCALE_INTERVAL")
+	flags.DurationVar(&o.cooldown, "cooldown", 6*time.Second, "least time between two scaling steps")
+	bindEnv(flags, "cooldown", "AUTOSCALE_COOLDOWN")
+	flags.DurationVar(&o.processingTime, "processing-time", 20*time.Millisecond, "time each member spends on a message")
+	bindEnv(flags, "processing-time", "AUTOSCALE_PROCESSING_TIME")
+	flags.IntVar(&o.produceRate, "produce-rate", 0, "send this many generated events per second to the topic, 0 sends none")
+	bindEnv(flags, "produce-rate", "AUTOSCALE_PRODUCE_RATE")
+	flags.DurationVar(&o.produceFor, "produce-for", 0, "stop producing after this long so the group scales back down, 0 produces until the end")
+	bindEnv(flags, "produce-for", "AUTOSCALE_PRODUCE_FOR")
+	flags.DurationVar(&o.duration, "duration", 0, "stop after this long, 0 runs until interrupted")
+	bindEnv(flags, "duration", "AUTOSCALE_DURATION")
+	o.events.addFlags(cmd)
+	return cmd
+}
+
+func runAutoscale(o autoscaleOptions) {
+	if o.produceRate < 0 {
+		logging.Fatal("--produce-rate must not be negative")
+	}
+	config := kafka.AutoscaleConfig{
+		MinMembers:   o.minConsumers,
+		MaxMembers:   o.maxConsumers,
+		ScaleUpLag:   o.scaleUpLag,
+		ScaleDownLag: o.scaleDownLag,
+		Interval:     o.interval,
+		Cooldown:     o.cooldown,
+	}
+
+	settings := []any{
+		"mode", mode,
+		"topic", o.topic,
+		"group", o.groupID,
+		"min_consumers", o.minConsumers,
+		"max_consumers", o.maxConsumers,
+		"scale_up_lag", o.scaleUpLag,
+		"scale_down_lag", o.scaleDownLag,
+		"interval", o.interval,
+		"cooldown", o.cooldown,
+		"processing_time", o.processingTime,
+	}
+	if mode == modeKafka {
+		settings = append(settings, "brokers", brokers)
+	}
+	if o.produceRate > 0 {
+		settings = append(settings, "produce_rate", o.produceRate)
+		if o.produceFor > 0 {
+			settings = append(settings, "produce_for", o.produceFor)
+		}
+		settings = append(settings, o.events.settings()...)
+	}
+	if o.duration > 0 {
+		settings = append(settings, "duration", o.duration)
+	}
+	slog.Info("Starting consumer group autoscaler", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+	if o.duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, o.duration)
+		defer cancel()
+	}
+
+	var lag kafka.LagSource
+	if memoryBroker != nil {
+		lag = kafka.LagFunc(func() ([]kafka.PartitionLag, error) {
+			return memoryBroker.Lag(o.groupID, o.topic)
+		})
+	} else {
+		monitor, err := kafka.NewLagMonitor(brokers, o.groupID, o.topic, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to create lag monitor", "error", err)
+		}
+		defer monitor.Close()
+		lag = monitor
+	}
+
+	// The processing time is what makes a single member fall behind.
+	work := kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		select {
+		case <-time.After(o.processingTime):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	newMember := func(id int) (*kafka.Consumer, error) {
+		return kafka.NewConsumer(brokers, o.topic, o.groupID, append(clientOptions(),
+			kafka.WithClientID(fmt.Sprintf("autoscale-%d", id)),
+			kafka.WithHandler(work),
+			kafka.WithoutMessageLog(),
+		)...)
+	}
+
+	autoscaler, err := kafka.NewAutoscaler(lag, newMember, config)
+	if err != nil {
+		logging.Fatal("Invalid autoscaler settings", "error", err)
+	}
+
+	if o.produceRate > 0 {
+		gen, err := o.events.newGenerator()
+		if err != nil {
+			logging.Fatal("Invalid event generator settings", "error", err)
+		}
+		producer, err := kafka.NewProducer(brokers, o.topic, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to create producer", "error", err)
+		}
+		defer producer.Close()
+		go produceAtRate(ctx, producer, gen, o.produceRate, o.produceFor)
+	}
+
+	if err := autoscaler.Run(ctx); err != nil {
+		logging.Fatal("Autoscaler failed", "error", err)
+	}
+}
+
+// produceAtRate sends rate generated events per second until ctx is done or
+// produceFor has passed.
+func produceAtRate(ctx context.Context, producer *kafka.Producer, gen *generator.Generator, rate int, produceFor time.Duration) {
+	if produceFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, produceFor)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	sent := 0
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped producing", "sent", sent)
+			return
+		case <-ticker.C:
+			if _, _, err := producer.SendEvent(gen.Next()); err != nil {
+				slog.Error("Failed to send message", "topic", producer.Topic(), "error", err)
+				continue
+			}
+			sent++
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/auth"
+	"kafka-hwsw/internal/bridge"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// bridgeOptions are the options every bridge has for its Kafka side.
+type bridgeOptions struct {
+	groupID         string
+	maxRetries      int
+	shutdownTimeout time.Duration
+	resilience      resilienceOptions
+}
+
+func (o *bridgeOptions) addFlags(cmd *cobra.Command, groupEnv, defaultGroup string) {
+	flags := cmd.Flags()
+	flags.StringVarP(&o.groupID, "group", "g", defaultGroup, "consumer group of the Kafka topics published by the bridge")
+	bindEnv(flags, "group", groupEnv)
+	flags.IntVar(&o.maxRetries, "max-retries", 5, "retries of a Kafka message the other side didn't acknowledge before it is skipped")
+	bindEnv(flags, "max-retries", "MAX_RETRIES")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long messages being published may take on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+	o.resilience.addFlags(cmd)
+}
+
+// settings returns the Kafka settings worth logging at startup.
+func (o bridgeOptions) settings() []any {
+	settings := []any{"tls", tlsConfig.Enabled}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	return append(settings, o.resilience.settings()...)
+}
+
+// newProducer connects a producer of topic, exiting if it never does.
+func (o bridgeOptions) newProducer(ctx context.Context, topic string) *kafka.Producer {
+	var producer *kafka.Producer
+	o.resilience.connect(ctx, "producer", func() (err error) {
+		producer, err = kafka.NewProducer(brokers, topic, append(clientOptions(), o.resilience.options()...)...)
+		return err
+	})
+	return producer
+}
+
+// newConsumer connects a consumer running publish for every message of
+// topics, exiting if it never does.
+func (o bridgeOptions) newConsumer(ctx context.Context, topics []string, publish kafka.MessageHandler) *kafka.Consumer {
+	opts := append(clientOptions(), o.resilience.options()...)
+	opts = append(opts,
+		kafka.WithHandler(publish),
+		kafka.WithMaxRetries(o.maxRetries),
+		kafka.WithShutdownTimeout(o.shutdownTimeout),
+		kafka.WithoutMessageLog(),
+	)
+	var consumer *kafka.Consumer
+	o.resilience.connect(ctx, "consumer", func() (err error) {
+		consumer, err = kafka.NewMultiTopicConsumer(brokers, topics, o.groupID, opts...)
+		return err
+	})
+	return consumer
+}
+
+// runDirections runs the directions of a bridge until all of them have
+// stopped, and exits as soon as one fails. what names the bridge in the
+// logs, e.g. "MQTT bridge".
+func runDirections(what string, directions ...func() error) {
+	errs := make(chan error, len(directions))
+	for _, direction := range directions {
+		go func(direction func() error) {
+			errs <- direction()
+		}(direction)
+	}
+	for range directions {
+		if err := <-errs; err != nil {
+			logging.Fatal(what+" failed", "error", err)
+		}
+	}
+	slog.Info(what + " stopped")
+}
+
+// consumeDirection returns the direction publishing what consumer reads.
+func consumeDirection(ctx context.Context, consumer *kafka.Consumer, to string) func() error {
+	return func() error {
+		if err := consumer.Consume(ctx); err != nil {
+			return fmt.Errorf("error publishing to %s: %w", to, err)
+		}
+		return nil
+	}
+}
+
+func newBridgeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bridge",
+		Short: "Connect Kafka to other messaging systems",
+		Long: `Forward messages between Kafka and another messaging system, in one or both
+directions, with the producers and consumers the other commands use.`,
+	}
+	cmd.AddCommand(newMQTTBridgeCommand(), newNATSBridgeCommand(), newSQSBridgeCommand())
+	return cmd
+}
+
+type mqttBridgeOptions struct {
+	bridgeOptions
+	topic    string
+	outTopic string
+	qos      int
+	config   bridge.MQTTConfig
+}
+
+func newMQTTBridgeCommand() *cobra.Command {
+	var o mqttBridgeOptions
+
+	cmd := &cobra.Command{
+		Use:   "mqtt",
+		Short: "Forward MQTT topics to Kafka and, optionally, a Kafka topic back to MQTT",
+		Long: `Subscribe to MQTT topic filters and produce every message to a Kafka topic,
+keyed by its MQTT topic, or by one level of it with --key-level, so the
+readings of one device stay in order on one partition. The MQTT topic, QoS
+and retained flag go along as the mqtt-topic, mqtt-qos and mqtt-retained
+headers. The bridge keeps a persistent MQTT session and acknowledges a QoS 1
+or 2 message only once Kafka has stored it, so nothing is lost if it
+crashes in between.
+
+With --out-topic the messages of that Kafka topic are published to MQTT as
+well, to --out-prefix followed by their key. Messages that came from MQTT
+aren't published back, and MQTT messages under --out-prefix aren't
+forwarded, so both directions can share topics without looping.
+
+Credentials are read from MQTT_USERNAME and MQTT_PASSWORD.`,
+		Example: "  kafka-hwsw bridge mqtt --mqtt-topics 'sensors/#' -t sensor-readings --key-level 2\n" +
+			"  kafka-hwsw bridge mqtt --mqtt-topics 'sensors/#' -t sensor-readings --out-topic device-commands --out-prefix commands/\n" +
+			"  kafka-hwsw bridge mqtt --mqtt-topics '' --out-topic test-topic --out-prefix events/",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runMQTTBridge(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.config.URL, "mqtt-url", "tcp://localhost:1883", "URL of the MQTT broker")
+	bindEnv(flags, "mqtt-url", "MQTT_URL")
+	flags.StringVar(&o.config.ClientID, "client-id", "kafka-hwsw-bridge", "MQTT client ID, which names the persistent session")
+	bindEnv(flags, "client-id", "MQTT_CLIENT_ID")
+	flags.StringSliceVar(&o.config.Filters, "mqtt-topics", []string{"sensors/#"}, "MQTT topic filters to forward to Kafka; empty forwards nothing")
+	bindEnv(flags, "mqtt-topics", "MQTT_TOPICS")
+	flags.IntVar(&o.qos, "qos", 1, "QoS of the subscriptions and of the messages published to MQTT: 0, 1 or 2")
+	bindEnv(flags, "qos", "MQTT_QOS")
+	flags.IntVar(&o.config.KeyLevel, "key-level", 0, "level of the MQTT topic used as the Kafka key, counting from 1; 0 uses the whole topic")
+	bindEnv(flags, "key-level", "MQTT_KEY_LEVEL")
+	flags.StringVarP(&o.topic, "topic", "t", "mqtt-events", "Kafka topic the MQTT messages are produced to")
+	bindEnv(flags, "topic", "MQTT_KAFKA_TOPIC")
+	flags.StringVar(&o.outTopic, "out-topic", "", "Kafka topic to publish to MQTT; empty publishes nothing")
+	bindEnv(flags, "out-topic", "MQTT_OUT_TOPIC")
+	flags.StringVar(&o.config.OutPrefix, "out-prefix", "kafka/", "prefix of the MQTT topics Kafka messages are published to")
+	bindEnv(flags, "out-prefix", "MQTT_OUT_PREFIX")
+	o.addFlags(cmd, "MQTT_GROUP_ID", "kafka-hwsw-mqtt-bridge")
+	completeValues(cmd, "qos", "0", "1", "2")
+	return cmd
+}
+
+func runMQTTBridge(o mqttBridgeOptions) {
+	forward := len(o.config.Filters) > 0
+	publish := o.outTopic != ""
+	if !forward && !publish {
+		logging.Fatal("Nothing to bridge, set --mqtt-topics or --out-topic")
+	}
+	if o.qos < 0 || o.qos > 2 {
+		logging.Fatal("Invalid --qos", "qos", o.qos, "supported", []int{0, 1, 2})
+	}
+	o.config.QoS = byte(o.qos)
+
+	settings := []any{
+		"brokers", brokers,
+		"mqtt_url", o.config.URL,
+		"client_id", o.config.ClientID,
+		"qos", o.qos,
+	}
+	if forward {
+		settings = append(settings, "mqtt_topics", o.config.Filters, "topic", o.topic, "key_level", o.config.KeyLevel)
+	}
+	if publish {
+		settings = append(settings, "out_topic", o.outTopic, "out_prefix", o.config.OutPrefix, "group", o.groupID)
+	}
+	settings = append(settings, o.settings()...)
+	slog.Info("Starting MQTT bridge", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	o.config.Username = os.Getenv("MQTT_USERNAME")
+	o.config.Password = os.Getenv("MQTT_PASSWORD")
+	mqttBridge, err := bridge.NewMQTT(o.config)
+	if err != nil {
+		logging.Fatal("Failed to connect to MQTT", "error", err)
+	}
+	defer mqttBridge.Close()
+
+	var directions []func() error
+	if forward {
+		producer := o.newProducer(ctx, o.topic)
+		defer producer.Close()
+		directions = append(directions, func() error {
+			return mqttBridge.Forward(ctx, producer)
+		})
+	}
+	if publish {
+		consumer := o.newConsumer(ctx, []string{o.outTopic}, mqttBridge.Publisher())
+		defer consumer.Close()
+		directions = append(directions, consumeDirection(ctx, consumer, "MQTT"))
+	}
+	runDirections("MQTT bridge", directions...)
+}
+
+type natsBridgeOptions struct {
+	bridgeOptions
+	toKafka []string
+	toNATS  []string
+	config  bridge.NATSConfig
+}
+
+func newNATSBridgeCommand() *cobra.Command {
+	var o natsBridgeOptions
+
+	cmd := &cobra.Command{
+		Use:   "nats",
+		Short: "Forward NATS subjects to Kafka topics and Kafka topics to NATS subjects",
+		Long: `Forward messages between NATS and Kafka by routing rules. --to-kafka routes
+map a subject filter to a Kafka topic, e.g. orders.>=orders; the messages
+are keyed by their subject, or by one token of it with --key-token.
+--to-nats routes map a Kafka topic to a subject, e.g. commands=commands,
+and each message is published to that subject followed by .<key>.
+Headers are kept both ways, and messages that came from the other side
+aren't sent back, so routes may overlap without looping.
+
+With core NATS, messages are forwarded at most once; a publisher that
+sends a request gets the reply once Kafka has stored the message. With
+--jetstream every --to-kafka route is read by a durable consumer that only
+acknowledges a message once Kafka has stored it, and messages published
+to NATS are acknowledged by their stream before their offset is committed,
+so both ways are at least once. --stream creates a stream of the
+--to-kafka subjects if it doesn't exist.
+
+Credentials are read from NATS_TOKEN, or NATS_USER and NATS_PASSWORD.`,
+		Example: "  kafka-hwsw bridge nats --to-kafka 'orders.>=orders' --key-token 2\n" +
+			"  kafka-hwsw bridge nats --jetstream --stream ORDERS --to-kafka 'orders.>=orders' --to-nats 'shipments=shipments'\n" +
+			"  kafka-hwsw bridge nats --to-kafka '' --to-nats 'test-topic=events'",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runNATSBridge(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.config.URL, "nats-url", "nats://localhost:4222", "URL of the NATS server")
+	bindEnv(flags, "nats-url", "NATS_URL")
+	flags.StringVar(&o.config.Name, "name", "kafka-hwsw-bridge", "connection name, queue group and prefix of the durable consumers")
+	bindEnv(flags, "name", "NATS_NAME")
+	flags.StringSliceVar(&o.toKafka, "to-kafka", []string{"events.>=nats-events"}, "routes from subject filters to Kafka topics, e.g. orders.>=orders; empty forwards nothing")
+	bindEnv(flags, "to-kafka", "NATS_TO_KAFKA")
+	flags.StringSliceVar(&o.toNATS, "to-nats", nil, "routes from Kafka topics to subjects, e.g. shipments=shipments")
+	bindEnv(flags, "to-nats", "NATS_TO_NATS")
+	flags.IntVar(&o.config.KeyToken, "key-token", 0, "token of the subject used as the Kafka key, counting from 1; 0 uses the whole subject")
+	bindEnv(flags, "key-token", "NATS_KEY_TOKEN")
+	flags.BoolVar(&o.config.JetStream, "jetstream", false, "consume and publish through JetStream, at least once")
+	bindEnv(flags, "jetstream", "NATS_JETSTREAM")
+	flags.StringVar(&o.config.Stream, "stream", "", "JetStream stream created for the --to-kafka subjects if missing")
+	bindEnv(flags, "stream", "NATS_STREAM")
+	o.addFlags(cmd, "NATS_GROUP_ID", "kafka-hwsw-nats-bridge")
+	return cmd
+}
+
+func runNATSBridge(o natsBridgeOptions) {
+	var err error
+	if o.config.ToKafka, err = bridge.ParseRoutes(o.toKafka); err != nil {
+		logging.Fatal("Invalid --to-kafka", "error", err)
+	}
+	if o.config.ToNATS, err = bridge.ParseRoutes(o.toNATS); err != nil {
+		logging.Fatal("Invalid --to-nats", "error", err)
+	}
+	forward := len(o.config.ToKafka) > 0
+	publish := len(o.config.ToNATS) > 0
+	if !forward && !publish {
+		logging.Fatal("Nothing to bridge, set --to-kafka or --to-nats")
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"nats_url", o.config.URL,
+		"name", o.config.Name,
+		"jetstream", o.config.JetStream,
+	}
+	if forward {
+		settings = append(settings, "to_kafka", o.config.ToKafka, "key_token", o.config.KeyToken)
+		if o.config.Stream != "" {
+			settings = append(settings, "stream", o.config.Stream)
+		}
+	}
+	if publish {
+		settings = append(settings, "to_nats", o.config.ToNATS, "group", o.groupID)
+	}
+	settings = append(settings, o.settings()...)
+	slog.Info("Starting NATS bridge", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	o.config.Token = os.Getenv("NATS_TOKEN")
+	o.config.Username = os.Getenv("NATS_USER")
+	o.config.Password = os.Getenv("NATS_PASSWORD")
+	natsBridge, err := bridge.NewNATS(o.config)
+	if err != nil {
+		logging.Fatal("Failed to connect to NATS", "error", err)
+	}
+	defer natsBridge.Close()
+
+	var directions []func() error
+	if forward {
+		producers := make(map[string]*kafka.Producer)
+		for _, route := range o.config.ToKafka {
+			if _, ok := producers[route.To]; !ok {
+				producers[route.To] = o.newProducer(ctx, route.To)
+				defer producers[route.To].Close()
+			}
+		}
+		directions = append(directions, func() error {
+			return natsBridge.Forward(ctx, producers)
+		})
+	}
+	if publish {
+		topics := make([]string, 0, len(o.config.ToNATS))
+		for _, route := range o.config.ToNATS {
+			topics = append(topics, route.From)
+		}
+		consumer := o.newConsumer(ctx, topics, natsBridge.Publisher())
+		defer consumer.Close()
+		directions = append(directions, consumeDirection(ctx, consumer, "NATS"))
+	}
+	runDirections("NATS bridge", directions...)
+}
+
+type sqsBridgeOptions struct {
+	bridgeOptions
+	topic    string
+	outTopic string
+	config   bridge.SQSConfig
+}
+
+func newSQSBridgeCommand() *cobra.Command {
+	var o sqsBridgeOptions
+
+	cmd := &cobra.Command{
+		Use:   "sqs",
+		Short: "Drain an SQS queue into Kafka and send a Kafka topic to SQS or SNS",
+		Long: `Receive the messages of an SQS queue and produce them to a Kafka topic,
+deleting each from the queue once Kafka has stored it. Message attributes
+become headers, binary ones base64-encoded, and the message ID goes in the
+sqs-message-id header. The key is the --key-attribute attribute or, from a
+FIFO queue, the message group ID. Messages SNS delivered without raw message
+delivery are unwrapped, and their topic goes in the sns-topic-arn header.
+
+With --out-topic the messages of that Kafka topic are sent to the
+--out-queue-url queue, the --sns-topic-arn topic or both. Headers become
+string attributes, next to kafka-topic, kafka-partition, kafka-offset and
+the key; SQS takes 10 attributes, so the headers that don't fit are left
+out. FIFO queues and topics get the key as message group ID. Messages that
+came from the other side aren't sent back, so both ways can share a queue
+without looping.
+
+--endpoint points the bridge at LocalStack instead of AWS. Credentials are
+read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.`,
+		Example: "  kafka-hwsw bridge sqs --endpoint http://localhost:4566 --queue-url http://localhost:4566/000000000000/orders -t orders\n" +
+			"  kafka-hwsw bridge sqs --endpoint http://localhost:4566 --out-topic test-topic --sns-topic-arn arn:aws:sns:us-east-1:000000000000:events\n" +
+			"  kafka-hwsw bridge sqs --region eu-west-1 --queue-url https://sqs.eu-west-1.amazonaws.com/123456789012/orders -t orders",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSQSBridge(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.config.Region, "region", "us-east-1", "AWS region of the queues and topics")
+	bindEnv(flags, "region", "AWS_REGION")
+	flags.StringVar(&o.config.Endpoint, "endpoint", "", "endpoint replacing AWS, e.g. http://localhost:4566 for LocalStack")
+	bindEnv(flags, "endpoint", "AWS_ENDPOINT_URL")
+	flags.StringVar(&o.config.QueueURL, "queue-url", "", "SQS queue drained into Kafka; empty forwards nothing")
+	bindEnv(flags, "queue-url", "SQS_QUEUE_URL")
+	flags.StringVarP(&o.topic, "topic", "t", "sqs-events", "Kafka topic the SQS messages are produced to")
+	bindEnv(flags, "topic", "SQS_KAFKA_TOPIC")
+	flags.StringVar(&o.config.KeyAttribute, "key-attribute", "kafka-key", "message attribute carrying the Kafka key, both ways")
+	bindEnv(flags, "key-attribute", "SQS_KEY_ATTRIBUTE")
+	flags.StringVar(&o.outTopic, "out-topic", "", "Kafka topic sent to SQS or SNS; empty sends nothing")
+	bindEnv(flags, "out-topic", "SQS_OUT_TOPIC")
+	flags.StringVar(&o.config.OutQueueURL, "out-queue-url", "", "SQS queue the --out-topic messages are sent to")
+	bindEnv(flags, "out-queue-url", "SQS_OUT_QUEUE_URL")
+	flags.StringVar(&o.config.OutTopicARN, "sns-topic-arn", "", "SNS topic the --out-topic messages are published to")
+	bindEnv(flags, "sns-topic-arn", "SNS_TOPIC_ARN")
+	o.addFlags(cmd, "SQS_GROUP_ID", "kafka-hwsw-sqs-bridge")
+	return cmd
+}
+
+func runSQSBridge(o sqsBridgeOptions) {
+	forward := o.config.QueueURL != ""
+	publish := o.outTopic != ""
+	if !forward && !publish {
+		logging.Fatal("Nothing to bridge, set --queue-url or --out-topic")
+	}
+	if publish && o.config.OutQueueURL == "" && o.config.OutTopicARN == "" {
+		logging.Fatal("--out-topic needs --out-queue-url or --sns-topic-arn")
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"region", o.config.Region,
+	}
+	if o.config.Endpoint != "" {
+		settings = append(settings, "endpoint", o.config.Endpoint)
+	}
+	if forward {
+		settings = append(settings, "queue_url", o.config.QueueURL, "topic", o.topic)
+	}
+	if publish {
+		settings = append(settings, "out_topic", o.outTopic, "group", o.groupID)
+		if o.config.OutQueueURL != "" {
+			settings = append(settings, "out_queue_url", o.config.OutQueueURL)
+		}
+		if o.config.OutTopicARN != "" {
+			settings = append(settings, "sns_topic_arn", o.config.OutTopicARN)
+		}
+	}
+	settings = append(settings, "key_attribute", o.config.KeyAttribute)
+	settings = append(settings, o.settings()...)
+	slog.Info("Starting SQS bridge", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	o.config.Credentials = auth.AWS{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	sqsBridge, err := bridge.NewSQS(o.config)
+	if err != nil {
+		logging.Fatal("Failed to create SQS bridge", "error", err)
+	}
+	defer sqsBridge.Close()
+
+	var directions []func() error
+	if forward {
+		producer := o.newProducer(ctx, o.topic)
+		defer producer.Close()
+		directions = append(directions, func() error {
+			return sqsBridge.Forward(ctx, producer)
+		})
+	}
+	if publish {
+		consumer := o.newConsumer(ctx, []string{o.outTopic}, sqsBridge.Publisher())
+		defer consumer.Close()
+		directions = append(directions, consumeDirection(ctx, consumer, "SQS/SNS"))
+	}
+	runDirections("SQS bridge", directions...)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/sink"
+)
+
+type cacheOptions struct {
+	redisURL string
+	prefix   string
+}
+
+func newCacheCommand() *cobra.Command {
+	var o cacheOptions
+
+	cmd := &cobra.Command{
+		Use:   "cache <user-id>...",
+		Short: "Look up the latest event of users in the Redis cache",
+		Long: `Print the latest event of every user given, as kafka-hwsw sink --sink redis
+cached it, with the offset it was consumed at and how long it stays
+cached. All users are read from Redis in one pipelined round trip; users
+without a cached event are listed as not cached.`,
+		Example: "  kafka-hwsw cache user-123\n" +
+			"  kafka-hwsw cache user-123 user-456 --redis-prefix latest:",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := lookupCache(o, args); err != nil {
+				logging.Fatal("Cache lookup failed", "error", err)
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.redisURL, "redis-url", "redis://localhost:6379/0", "URL of the Redis sink")
+	bindEnv(flags, "redis-url", "SINK_REDIS_URL")
+	flags.StringVar(&o.prefix, "redis-prefix", "latest:", "prefix of the Redis sink's keys")
+	bindEnv(flags, "redis-prefix", "SINK_REDIS_PREFIX")
+	return cmd
+}
+
+func lookupCache(o cacheOptions, keys []string) error {
+	cache, err := sink.NewRedisSink(o.redisURL, o.prefix, 0)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	entries, err := cache.Lookup(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "USER\tTTL\tPARTITION\tOFFSET\tTIMESTAMP\tEVENT\n")
+	for _, entry := range entries {
+		if !entry.Found {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t(not cached)\n", entry.Key)
+			continue
+		}
+		ttl := "none"
+		if entry.TTL > 0 {
+			ttl = entry.TTL.Round(time.Second).String()
+		}
+		record := entry.Record
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", entry.Key, ttl, record.Partition, record.Offset,
+			record.Timestamp.Format(time.RFC3339), record.Value)
+	}
+	return w.Flush()
+}
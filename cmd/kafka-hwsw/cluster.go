@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/cluster"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type clusterOptions struct {
+	config     cluster.Config
+	timeout    time.Duration
+	topics     []string
+	partitions int
+}
+
+func newClusterCommand() *cobra.Command {
+	var o clusterOptions
+
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Start, stop and inspect a local 3-broker KRaft cluster in Docker",
+		Long: `Run a local Kafka cluster of three KRaft brokers, each also a controller,
+through the Docker Engine API: no ZooKeeper and no compose file. The brokers
+listen on localhost:9092, 9094 and 9096, the default --brokers of every
+command. DOCKER_HOST selects the daemon, unix:// and tcp:// are supported.`,
+	}
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&o.config.Image, "image", cluster.DefaultImage, "cp-kafka image of the brokers, 7.4 or later")
+	bindEnv(persistent, "image", "CLUSTER_IMAGE")
+	persistent.StringVar(&o.config.Network, "network", cluster.DefaultNetwork, "Docker network the brokers share")
+	bindEnv(persistent, "network", "CLUSTER_NETWORK")
+
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Create and start the brokers, wait until they answer and create the demo topics",
+		Example: "  kafka-hwsw cluster up\n" +
+			"  kafka-hwsw cluster up --topics user-events,orders --partitions 6",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterUp(o)
+		},
+	}
+	up.Flags().DurationVar(&o.timeout, "timeout", 2*time.Minute, "how long to wait for the brokers to answer")
+	bindEnv(up.Flags(), "timeout", "CLUSTER_TIMEOUT")
+	up.Flags().StringSliceVar(&o.topics, "topics", []string{"test-topic", "user-events"}, "topics to create once the cluster is up")
+	bindEnv(up.Flags(), "topics", "CLUSTER_TOPICS")
+	up.Flags().IntVar(&o.partitions, "partitions", 3, "partitions of each created topic")
+	bindEnv(up.Flags(), "partitions", "CLUSTER_PARTITIONS")
+
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Remove the brokers with their data and the network",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := newCluster(o.config)
+			if err := c.Down(context.Background()); err != nil {
+				logging.Fatal("Failed to stop cluster", "error", err)
+			}
+			slog.Info("Cluster removed")
+		},
+	}
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show the broker containers and whether Kafka answers",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := printClusterStatus(newCluster(o.config)); err != nil {
+				logging.Fatal("Failed to get cluster status", "error", err)
+			}
+		},
+	}
+
+	cmd.AddCommand(up, down, status)
+	return cmd
+}
+
+func newCluster(config cluster.Config) *cluster.Cluster {
+	c, err := cluster.New(config)
+	if err != nil {
+		logging.Fatal("Failed to connect to Docker", "error", err)
+	}
+	return c
+}
+
+func runClusterUp(o clusterOptions) {
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	c := newCluster(o.config)
+	slog.Info("Starting local KRaft cluster", "image", o.config.Image, "network", o.config.Network, "brokers", c.Brokers())
+	if err := c.Up(ctx); err != nil {
+		logging.Fatal("Failed to start cluster", "error", err)
+	}
+
+	if err := waitForCluster(ctx, c.Brokers(), o.timeout); err != nil {
+		logging.Fatal("Cluster didn't become ready", "timeout", o.timeout, "error", err,
+			"hint", "check the broker logs with docker logs kafka-hwsw-broker-1")
+	}
+	admin, err := kafka.NewAdmin(c.Brokers())
+	if err != nil {
+		logging.Fatal("Failed to connect to the cluster", "error", err)
+	}
+	defer admin.Close()
+
+	for _, topic := range o.topics {
+		created, err := kafka.EnsureTopic(admin, topic, int32(o.partitions), cluster.Size)
+		if err != nil {
+			logging.Fatal("Failed to create topic", "topic", topic, "error", err)
+		}
+		if created {
+			slog.Info("Created topic", "topic", topic, "partitions", o.partitions, "replication_factor", cluster.Size)
+		} else {
+			slog.Info("Topic already exists", "topic", topic)
+		}
+	}
+
+	slog.Info("Cluster ready", "brokers", strings.Join(c.Brokers(), ","))
+}
+
+// waitForCluster connects to brokers until every broker of the cluster is in
+// the metadata and a controller has been elected.
+func waitForCluster(ctx context.Context, brokers []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		admin, err := connectAdmin(brokers)
+		if err == nil {
+			var described []*sarama.Broker
+			var controller int32
+			described, controller, err = admin.DescribeCluster()
+			switch {
+			case err == nil && len(described) == cluster.Size && controller >= 0:
+				slog.Info("Brokers answering", "brokers", len(described), "controller", controller)
+				admin.Close()
+				return nil
+			case err == nil:
+				err = fmt.Errorf("%d of %d brokers in the metadata", len(described), cluster.Size)
+			}
+			admin.Close()
+		}
+		slog.Debug("Waiting for brokers", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// connectAdmin connects to the local cluster, which runs without TLS or
+// SASL, failing fast if it doesn't answer.
+func connectAdmin(brokers []string) (sarama.ClusterAdmin, error) {
+	return kafka.NewClusterAdmin(brokers, kafka.WithSaramaConfig(func(config *sarama.Config) {
+		config.Metadata.Retry.Max = 0
+		config.Net.DialTimeout = 2 * time.Second
+		config.Net.ReadTimeout = 5 * time.Second
+	}))
+}
+
+func printClusterStatus(c *cluster.Cluster) error {
+	nodes, err := c.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NODE\tCONTAINER\tADDRESS\tSTATE\tSTATUS\n")
+	running := 0
+	for _, node := range nodes {
+		if node.State == "running" {
+			running++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", node.ID, node.Container, node.Address, node.State, node.Status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if running == 0 {
+		fmt.Println("\nKafka: not running, start it with kafka-hwsw cluster up")
+		return nil
+	}
+	admin, err := connectAdmin(c.Brokers())
+	if err != nil {
+		fmt.Printf("\nKafka: not answering: %v\n", err)
+		return nil
+	}
+	defer admin.Close()
+	described, controller, err := admin.DescribeCluster()
+	if err != nil {
+		fmt.Printf("\nKafka: not answering: %v\n", err)
+		return nil
+	}
+	fmt.Printf("\nKafka: %d of %d brokers answering, controller is node %d\n", len(described), cluster.Size, controller)
+	return nil
+}
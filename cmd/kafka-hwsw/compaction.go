@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// compactionRollKey keys the tombstones that roll every partition's active
+// segment so the cleaner may compact the messages before them.
+const compactionRollKey = "compaction-demo-roll"
+
+// compactionPollInterval is how often the demo reads the topic again while
+// it waits for the log cleaner.
+const compactionPollInterval = 5 * time.Second
+
+type compactionOptions struct {
+	topic             string
+	keys              int
+	versions          int
+	deletes           int
+	partitions        int
+	replicationFactor int
+	segment           time.Duration
+	timeout           time.Duration
+}
+
+// sentKey is where the last message the demo sent for a key went, and how
+// many it sent.
+type sentKey struct {
+	partition int32
+	offset    int64
+	messages  int
+}
+
+func newCompactionCommand() *cobra.Command {
+	var o compactionOptions
+
+	cmd := &cobra.Command{
+		Use:   "compaction",
+		Short: "Produce several versions of every key and tombstones to a compacted topic and watch compaction keep the latest",
+		Long: `Run the log compaction demo: create a topic with cleanup.policy=compact
+unless it exists, send --versions messages for each of --keys user IDs and
+then tombstones for the last --deletes of them, and read the topic back
+from the beginning, where every version is still there.
+
+Kafka only compacts segments that are no longer written to, so after
+--segment the demo sends one more tombstone to every partition, which
+starts a new segment. Then it reads the topic from the beginning every few
+seconds until the log cleaner has left only the latest message of every
+key, or --timeout passes. Deleted keys keep their tombstone for the
+topic's delete.retention.ms before a later cleaning removes it too.`,
+		Example: "  kafka-hwsw compaction\n" +
+			"  kafka-hwsw compaction -t user-profiles --keys 10 --versions 5 --deletes 3 --segment 20s",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCompaction(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "compaction-demo", "compacted topic to create and produce to")
+	bindEnv(flags, "topic", "COMPACTION_TOPIC")
+	flags.IntVar(&o.keys, "keys", 5, "number of user IDs to send versions of")
+	bindEnv(flags, "keys", "COMPACTION_KEYS")
+	flags.IntVar(&o.versions, "versions", 4, "messages to send per key")
+	bindEnv(flags, "versions", "COMPACTION_VERSIONS")
+	flags.IntVar(&o.deletes, "deletes", 2, "keys to send a tombstone for after their versions")
+	bindEnv(flags, "deletes", "COMPACTION_DELETES")
+	flags.IntVar(&o.partitions, "partitions", 3, "partitions of the topic if it is created")
+	bindEnv(flags, "partitions", "TOPIC_PARTITIONS")
+	flags.IntVar(&o.replicationFactor, "replication-factor", 3, "replication factor of the topic if it is created")
+	bindEnv(flags, "replication-factor", "TOPIC_REPLICATION_FACTOR")
+	flags.DurationVar(&o.segment, "segment", 10*time.Second, "segment.ms of the topic if it is created, and how long to wait before rolling the segments")
+	bindEnv(flags, "segment", "COMPACTION_SEGMENT")
+	flags.DurationVar(&o.timeout, "timeout", 3*time.Minute, "how long to wait for the log cleaner")
+	bindEnv(flags, "timeout", "COMPACTION_TIMEOUT")
+	return cmd
+}
+
+func runCompaction(o compactionOptions) {
+	if o.keys < 1 || o.versions < 1 {
+		logging.Fatal("--keys and --versions must be at least 1", "keys", o.keys, "versions", o.versions)
+	}
+	if o.deletes < 0 || o.deletes > o.keys {
+		logging.Fatal("--deletes must be between 0 and --keys", "deletes", o.deletes, "keys", o.keys)
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"keys", o.keys,
+		"versions", o.versions,
+		"deletes", o.deletes,
+		"segment", o.segment,
+		"timeout", o.timeout,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	slog.Info("Starting log compaction demo", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	partitions, err := ensureCompactionTopic(o)
+	if err != nil {
+		logging.Fatal("Failed to create compacted topic", "topic", o.topic, "error", err)
+	}
+
+	sent, err := sendVersions(o)
+	if err != nil {
+		logging.Fatal("Failed to send message", "topic", o.topic, "error", err)
+	}
+
+	tailer, err := kafka.NewTailer(brokers, clientOptions()...)
+	if err != nil {
+		logging.Fatal("Failed to create consumer", "error", err)
+	}
+	defer tailer.Close()
+
+	messages, err := tailer.ReadAll(ctx, o.topic)
+	if err != nil {
+		logging.Fatal("Failed to read topic", "topic", o.topic, "error", err)
+	}
+	fmt.Println("Before compaction:")
+	printCompaction(sent, messages)
+
+	slog.Info("Waiting for the active segments to age before rolling them", "segment", o.segment)
+	select {
+	case <-time.After(o.segment + time.Second):
+	case <-ctx.Done():
+		slog.Info("Compaction demo stopped")
+		return
+	}
+	if err := rollSegments(o.topic, partitions); err != nil {
+		logging.Fatal("Failed to roll segments", "topic", o.topic, "error", err)
+	}
+
+	deadline := time.After(o.timeout)
+	ticker := time.NewTicker(compactionPollInterval)
+	defer ticker.Stop()
+	for {
+		messages, err = tailer.ReadAll(ctx, o.topic)
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.Info("Compaction demo stopped")
+				return
+			}
+			logging.Fatal("Failed to read topic", "topic", o.topic, "error", err)
+		}
+		superseded := supersededMessages(sent, messages)
+		if superseded == 0 {
+			break
+		}
+		slog.Info("Waiting for the log cleaner", "superseded_messages", superseded)
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			logging.Fatal("The log cleaner didn't compact the topic in time", "timeout", o.timeout,
+				"hint", "the cleaner runs every log.cleaner.backoff.ms and skips logs below min.cleanable.dirty.ratio")
+		case <-ctx.Done():
+			slog.Info("Compaction demo stopped")
+			return
+		}
+	}
+
+	fmt.Println("After compaction:")
+	printCompaction(sent, messages)
+	slog.Info("Only the latest message of every key is left", "keys", o.keys, "deleted", o.deletes,
+		"messages_sent", o.keys*o.versions+o.deletes)
+}
+
+// ensureCompactionTopic creates the topic, with segments and a dirty ratio
+// small enough for compaction to start within the demo, and returns its
+// partition count.
+func ensureCompactionTopic(o compactionOptions) (int, error) {
+	admin, err := kafka.NewAdmin(brokers, clientOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	defer admin.Close()
+
+	created, err := kafka.EnsureCompactedTopic(admin, o.topic, int32(o.partitions), int16(o.replicationFactor), map[string]string{
+		"segment.ms":                fmt.Sprint(o.segment.Milliseconds()),
+		"min.cleanable.dirty.ratio": "0.01",
+	})
+	if err != nil {
+		return 0, err
+	}
+	if created {
+		slog.Info("Created compacted topic", "topic", o.topic, "partitions", o.partitions, "segment", o.segment)
+	} else {
+		slog.Info("Using existing compacted topic", "topic", o.topic)
+	}
+
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe topic: %w", err)
+	}
+	detail, ok := topics[o.topic]
+	if !ok {
+		return 0, fmt.Errorf("failed to describe topic %s", o.topic)
+	}
+	return int(detail.Partitions), nil
+}
+
+// sendVersions sends the versions of every key, one round of all keys at a
+// time, then the tombstones.
+func sendVersions(o compactionOptions) (map[string]*sentKey, error) {
+	producer, err := kafka.NewProducer(brokers, o.topic, clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer producer.Close()
+
+	sent := make(map[string]*sentKey, o.keys)
+	record := func(key string, partition int32, offset int64) {
+		s, ok := sent[key]
+		if !ok {
+			s = &sentKey{}
+			sent[key] = s
+		}
+		s.partition, s.offset = partition, offset
+		s.messages++
+	}
+
+	for version := 1; version <= o.versions; version++ {
+		for i := 1; i <= o.keys; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			value := fmt.Sprintf(`{"user_id":%q,"version":%d,"updated_at":%q}`, key, version, time.Now().UTC().Format(time.RFC3339Nano))
+			partition, offset, err := producer.SendMessage(key, value)
+			if err != nil {
+				return nil, err
+			}
+			slog.Debug("Message sent", "key", key, "version", version, "partition", partition, "offset", offset)
+			record(key, partition, offset)
+		}
+	}
+	for i := o.keys - o.deletes + 1; i <= o.keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		partition, offset, err := producer.SendTombstone(key)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("Tombstone sent", "key", key, "partition", partition, "offset", offset)
+		record(key, partition, offset)
+	}
+	slog.Info("Sent versions and tombstones", "messages", o.keys*o.versions+o.deletes)
+	return sent, nil
+}
+
+// rollSegments sends a tombstone to every partition, which starts a new
+// active segment once the current one is older than segment.ms.
+func rollSegments(topic string, partitions int) error {
+	for partition := int32(0); partition < int32(partitions); partition++ {
+		producer, err := kafka.NewProducer(brokers, topic, append(clientOptions(), kafka.WithManualPartition(partition))...)
+		if err != nil {
+			return err
+		}
+		_, _, err = producer.SendTombstone(compactionRollKey)
+		producer.Close()
+		if err != nil {
+			return err
+		}
+	}
+	slog.Info("Rolled the active segments", "partitions", partitions)
+	return nil
+}
+
+// supersededMessages counts the messages of the demo's keys that aren't the
+// last one sent for their key, which compaction removes.
+func supersededMessages(sent map[string]*sentKey, messages []*kafka.Message) int {
+	superseded := 0
+	for _, message := range messages {
+		if s, ok := sent[string(message.Key)]; ok && (message.Partition != s.partition || message.Offset != s.offset) {
+			superseded++
+		}
+	}
+	return superseded
+}
+
+// printCompaction prints, for every key of the demo, how many messages were
+// sent, how many the topic holds and the latest one.
+func printCompaction(sent map[string]*sentKey, messages []*kafka.Message) {
+	inLog := make(map[string]int)
+	latest := make(map[string]*kafka.Message)
+	for _, message := range messages {
+		key := string(message.Key)
+		if _, ok := sent[key]; !ok {
+			continue
+		}
+		inLog[key]++
+		if l, ok := latest[key]; !ok || message.Offset > l.Offset {
+			latest[key] = message
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tPARTITION\tSENT\tIN-LOG\tLATEST\n")
+	for i := 1; i <= len(sent); i++ {
+		key := fmt.Sprintf("user-%d", i)
+		s := sent[key]
+		value := "-"
+		if message, ok := latest[key]; ok {
+			value = string(message.Value)
+			if message.Value == nil {
+				value = "(tombstone)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", key, s.partition, s.messages, inLog[key], value)
+	}
+	w.Flush()
+	fmt.Println()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IBM/sarama"
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type compressionBenchOptions struct {
+	topic             string
+	messageCount      int
+	codecs            []string
+	messageFormat     string
+	schemaRegistryURL string
+	events            eventOptions
+}
+
+func newCompressionBenchCommand() *cobra.Command {
+	var o compressionBenchOptions
+
+	cmd := &cobra.Command{
+		Use:   "compression-bench",
+		Short: "Produce the same events with every compression codec and compare them",
+		Long: `Generate one dataset and produce it once per compression codec with the
+async producer, then report the bytes sent to the brokers and the delivery
+latency of each codec. Combine --realistic and --payload-bytes to see how the
+codecs deal with different payloads.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCompressionBench(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to produce to")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.IntVarP(&o.messageCount, "count", "n", 10000, "messages to send with each codec")
+	bindEnv(flags, "count", "BENCH_MESSAGE_COUNT")
+	flags.StringSliceVar(&o.codecs, "codecs", kafka.CompressionCodecs, "codecs to compare")
+	bindEnv(flags, "codecs", "BENCH_CODECS")
+	flags.StringVar(&o.messageFormat, "format", serde.FormatJSON, "message format: json, avro or protobuf")
+	bindEnv(flags, "format", "MESSAGE_FORMAT")
+	flags.StringVar(&o.schemaRegistryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	o.events.addFlags(cmd)
+
+	completeValues(cmd, "codecs", kafka.CompressionCodecs...)
+	completeValues(cmd, "format", serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf)
+	return cmd
+}
+
+// codecResult is what one codec's run measured.
+type codecResult struct {
+	codec     string
+	delivered int64
+	failed    int64
+	wireBytes int64
+	ratio     float64
+	latencies []time.Duration
+	elapsed   time.Duration
+}
+
+func runCompressionBench(o compressionBenchOptions) {
+	if o.messageCount <= 0 {
+		logging.Fatal("--count must be positive", "count", o.messageCount)
+	}
+	gen, err := o.events.newGenerator()
+	if err != nil {
+		logging.Fatal("Invalid event generator settings", "error", err)
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"message_count", o.messageCount,
+		"codecs", o.codecs,
+		"message_format", o.messageFormat,
+		"seed", gen.Seed(),
+	}
+	settings = append(settings, o.events.settings()...)
+	slog.Info("Starting compression benchmark", settings...)
+
+	serializer, err := serde.New(o.messageFormat, o.topic, o.schemaRegistryURL)
+	if err != nil {
+		logging.Fatal("Failed to create serializer", "error", err)
+	}
+
+	// Every codec sends exactly these events.
+	events := gen.Generate(o.messageCount)
+	var rawBytes int64
+	for _, event := range events {
+		value, err := serializer.Serialize(event)
+		if err != nil {
+			logging.Fatal("Failed to serialize event", "error", err)
+		}
+		rawBytes += int64(len(event.UserID) + len(value))
+	}
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var results []codecResult
+	for _, codec := range o.codecs {
+		if ctx.Err() != nil {
+			break
+		}
+		result, err := benchCodec(ctx, o.topic, codec, serializer, events)
+		if err != nil {
+			logging.Fatal("Compression benchmark failed", "codec", codec, "error", err)
+		}
+		slog.Info("Codec done", "codec", codec, "delivered", result.delivered, "failed", result.failed,
+			"wire_bytes", result.wireBytes, "elapsed", result.elapsed.Round(time.Millisecond))
+		results = append(results, result)
+	}
+
+	printCompressionReport(rawBytes, results)
+}
+
+// benchCodec produces events with codec. The bytes on the wire come from a
+// metrics registry of its own, so codecs don't count each other's traffic.
+func benchCodec(ctx context.Context, topic, codec string, serializer kafka.Serializer, events []kafka.UserEvent) (codecResult, error) {
+	result := codecResult{codec: codec}
+	registry := gometrics.NewRegistry()
+
+	var mu sync.Mutex
+	producer, err := kafka.NewAsyncProducer(brokers, topic, func(d kafka.Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		if d.Err != nil {
+			result.failed++
+			return
+		}
+		result.delivered++
+		result.latencies = append(result.latencies, d.Latency)
+	}, append(clientOptions(),
+		kafka.WithSerializer(serializer),
+		kafka.WithCompression(codec),
+		kafka.WithSaramaConfig(func(config *sarama.Config) {
+			config.MetricRegistry = registry
+		}),
+	)...)
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	for _, event := range events {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := producer.SendEvent(event); err != nil {
+			mu.Lock()
+			result.failed++
+			mu.Unlock()
+		}
+	}
+	if err := producer.Close(); err != nil {
+		return result, err
+	}
+	result.elapsed = time.Since(start)
+
+	result.wireBytes = gometrics.GetOrRegisterMeter("outgoing-byte-rate", registry).Count()
+	// sarama records uncompressed/compressed size times 100 for every
+	// compressed batch, and nothing without compression.
+	if h, ok := registry.Get("compression-ratio").(gometrics.Histogram); ok && h.Count() > 0 {
+		result.ratio = h.Snapshot().Mean() / 100
+	}
+	return result, nil
+}
+
+func printCompressionReport(rawBytes int64, results []codecResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "CODEC\tMESSAGES\tRAW BYTES\tWIRE BYTES\tWIRE/RAW\tBATCH RATIO\tP50\tP99\tMSG/SEC\t\n")
+
+	for _, r := range results {
+		latencies := r.latencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		p50, p99 := "-", "-"
+		if len(latencies) > 0 {
+			p50 = percentile(latencies, 0.50).String()
+			p99 = percentile(latencies, 0.99).String()
+		}
+		ratio := "-"
+		if r.ratio > 0 {
+			ratio = fmt.Sprintf("%.2fx", r.ratio)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t\n",
+			r.codec, r.delivered, rawBytes, r.wireBytes, float64(r.wireBytes)/float64(rawBytes),
+			ratio, p50, p99, rate(r.delivered, r.elapsed))
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("WIRE BYTES counts every request sent to the brokers, including headers and protocol overhead.")
+	fmt.Println("BATCH RATIO is how many times smaller a record batch got on average, as measured by sarama.")
+}
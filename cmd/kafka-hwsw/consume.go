@@ -0,0 +1,852 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/dashboard"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/metrics"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/internal/transform"
+	"kafka-hwsw/internal/tui"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type consumeOptions struct {
+	topic           string
+	topics          []string
+	topicHandlers   string
+	topicPattern    string
+	topicRefresh    time.Duration
+	priorityTopics  string
+	groupID         string
+	rebalance       string
+	instanceID      string
+	maxMessages     int
+	messageFormat   string
+	registryURL     string
+	metricsPort     int
+	controlPort     int
+	healthPort      int
+	dashboardPort   int
+	tui             bool
+	tuiMessages     int
+	tailBackfill    int
+	tailRate        float64
+	readinessGrace  time.Duration
+	rebalanceDebug  bool
+	partitions      string
+	startOffset     string
+	startFrom       string
+	fromBeginning   bool
+	fromLatest      bool
+	dlqTopic        string
+	outputTopic     string
+	transactionalID string
+	maxRetries      int
+	retryLevels     string
+	shutdownTimeout time.Duration
+	concurrency     int
+	inFlight        int
+	handlers        string
+	commit          kafka.CommitStrategy
+	semantics       string
+	crashAfter      int
+	readerVersion   int
+	dedup           string
+	dedupTTL        time.Duration
+	dedupSize       int
+	dedupFile       string
+	filter          string
+	failRate        float64
+	failKeys        []string
+	poisonAttempts  int
+	poisonFile      string
+	verifyOrdering  bool
+	latencyReport   time.Duration
+	showTimestamps  bool
+	eventLateness   time.Duration
+	lateTopic       string
+	skewThreshold   float64
+	skewInterval    time.Duration
+	heatmapFile     string
+	heatmapInterval time.Duration
+	resilience      resilienceOptions
+}
+
+func newConsumeCommand() *cobra.Command {
+	var o consumeOptions
+
+	cmd := &cobra.Command{
+		Use:   "consume",
+		Short: "Consume user events and show which partition each key comes from",
+		Long: `Consume user events as part of a consumer group. --partitions switches to
+manual partition assignment and --output-topic to the exactly-once
+consume-transform-produce pipeline.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runConsume(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to consume from")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringSliceVar(&o.topics, "topics", nil, "consume several topics in one group, e.g. orders,payments,clicks (overrides --topic)")
+	bindEnv(flags, "topics", "KAFKA_TOPICS")
+	flags.StringVar(&o.topicHandlers, "topic-handlers", "", "per-topic handlers, e.g. orders=json-validate,log;payments=file:/tmp/payments.jsonl")
+	bindEnv(flags, "topic-handlers", "TOPIC_HANDLERS")
+	flags.StringVar(&o.topicPattern, "topic-pattern", "", "consume every topic matching this regular expression, e.g. ^events-.* (overrides --topic)")
+	bindEnv(flags, "topic-pattern", "KAFKA_TOPIC_PATTERN")
+	flags.DurationVar(&o.topicRefresh, "topic-refresh", 30*time.Second, "how often --topic-pattern looks for new matching topics")
+	bindEnv(flags, "topic-refresh", "TOPIC_REFRESH_INTERVAL")
+	flags.StringVar(&o.priorityTopics, "priority-topics", "", "consume these topics highest priority first with weighted turns, e.g. alerts:9,events:1 (overrides --topic)")
+	bindEnv(flags, "priority-topics", "PRIORITY_TOPICS")
+	flags.StringVarP(&o.groupID, "group", "g", "test-consumer-group", "consumer group ID")
+	bindEnv(flags, "group", "KAFKA_GROUP_ID")
+	flags.StringVar(&o.rebalance, "rebalance-strategy", kafka.RebalanceRoundRobin, "partition assignment: range, roundrobin, sticky or single-active")
+	bindEnv(flags, "rebalance-strategy", "KAFKA_REBALANCE_STRATEGY")
+	flags.StringVar(&o.instanceID, "group-instance-id", "", "join as a static member with this ID, so restarts don't trigger rebalances")
+	bindEnv(flags, "group-instance-id", "KAFKA_GROUP_INSTANCE_ID")
+	flags.IntVarP(&o.maxMessages, "max-messages", "n", 0, "stop after this many messages, 0 runs until interrupted")
+	bindEnv(flags, "max-messages", "MAX_MESSAGES")
+	flags.StringVar(&o.messageFormat, "format", serde.FormatJSON, "format of re-published messages: json, avro or protobuf")
+	bindEnv(flags, "format", "MESSAGE_FORMAT")
+	flags.IntVar(&o.readerVersion, "schema-reader-version", 0, "read JSON as this schema version like an old consumer would, 0 upcasts every version")
+	bindEnv(flags, "schema-reader-version", "SCHEMA_READER_VERSION")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.IntVar(&o.metricsPort, "metrics-port", 0, "serve Prometheus metrics on this port")
+	bindEnv(flags, "metrics-port", "METRICS_PORT")
+	flags.BoolVar(&o.rebalanceDebug, "debug-rebalances", false, "serve the rebalance history at /debug/rebalances on --metrics-port")
+	bindEnv(flags, "debug-rebalances", "DEBUG_REBALANCES")
+	flags.IntVar(&o.controlPort, "control-port", 0, "serve POST /pause and /resume on this port")
+	bindEnv(flags, "control-port", "CONTROL_PORT")
+	flags.IntVar(&o.healthPort, "health-port", 0, "serve /healthz and /readyz on this port")
+	bindEnv(flags, "health-port", "HEALTH_PORT")
+	flags.IntVar(&o.dashboardPort, "dashboard-port", 0, "serve a live web dashboard of partitions, lag and rebalances on this port")
+	bindEnv(flags, "dashboard-port", "DASHBOARD_PORT")
+	flags.BoolVar(&o.tui, "tui", false, "show a live terminal view of partitions, lag and the last messages instead of logging each message")
+	bindEnv(flags, "tui", "CONSUMER_TUI")
+	flags.IntVar(&o.tuiMessages, "tui-messages", 10, "how many of the last messages --tui shows")
+	bindEnv(flags, "tui-messages", "CONSUMER_TUI_MESSAGES")
+	flags.IntVar(&o.tailBackfill, "tail-backfill", 100, "last messages per topic the live tail at /ws/tail on --dashboard-port sends first")
+	bindEnv(flags, "tail-backfill", "TAIL_BACKFILL")
+	flags.Float64Var(&o.tailRate, "tail-rate", 50, "most messages per second the live tail sends to one client")
+	bindEnv(flags, "tail-rate", "TAIL_RATE")
+	flags.DurationVar(&o.readinessGrace, "readiness-grace", 30*time.Second, "how long a rebalance may take before /readyz fails")
+	bindEnv(flags, "readiness-grace", "READINESS_GRACE")
+	flags.StringVar(&o.partitions, "partitions", "", "consume these partitions without a group, e.g. 0,2")
+	bindEnv(flags, "partitions", "KAFKA_PARTITIONS")
+	flags.StringVar(&o.startOffset, "start-offset", "oldest", "where --partitions starts: oldest, newest or an offset")
+	bindEnv(flags, "start-offset", "KAFKA_START_OFFSET")
+	flags.StringVar(&o.startFrom, "start-from", "", "start from beginning, latest or an RFC3339 timestamp")
+	bindEnv(flags, "start-from", "START_FROM")
+	flags.BoolVar(&o.fromBeginning, "from-beginning", false, "start from the oldest offset, ignoring committed offsets")
+	flags.BoolVar(&o.fromLatest, "from-latest", false, "start from the newest offset, ignoring committed offsets")
+	flags.StringVar(&o.dlqTopic, "dlq-topic", "", "dead-letter topic for messages that keep failing")
+	bindEnv(flags, "dlq-topic", "DLQ_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "", "run the exactly-once pipeline into this topic")
+	bindEnv(flags, "output-topic", "OUTPUT_TOPIC")
+	flags.StringVar(&o.transactionalID, "transactional-id", "", "transactional ID for --output-topic")
+	bindEnv(flags, "transactional-id", "KAFKA_TRANSACTIONAL_ID")
+	flags.IntVar(&o.maxRetries, "max-retries", 3, "in-place retries before a message is moved on")
+	bindEnv(flags, "max-retries", "MAX_RETRIES")
+	flags.StringVar(&o.retryLevels, "retry-levels", "", "retry topic delays, e.g. 5s,1m,10m")
+	bindEnv(flags, "retry-levels", "RETRY_LEVELS")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long in-flight messages may take on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+	flags.IntVar(&o.concurrency, "concurrency", 1, "workers per partition, same-key messages stay ordered")
+	bindEnv(flags, "concurrency", "CONSUMER_CONCURRENCY")
+	flags.IntVar(&o.inFlight, "in-flight-buffer", 0, "buffer up to this many messages per partition and pause the partition while the buffer is full, 0 disables the buffer")
+	bindEnv(flags, "in-flight-buffer", "IN_FLIGHT_BUFFER")
+	flags.StringVar(&o.commit.Mode, "commit-mode", kafka.CommitAuto, "when offsets are committed: auto, manual, batch or interval")
+	bindEnv(flags, "commit-mode", "COMMIT_MODE")
+	flags.IntVar(&o.commit.Every, "commit-every", 100, "messages per commit in batch mode")
+	bindEnv(flags, "commit-every", "COMMIT_EVERY")
+	flags.DurationVar(&o.commit.Interval, "commit-interval", 5*time.Second, "time between commits in interval mode")
+	bindEnv(flags, "commit-interval", "COMMIT_INTERVAL")
+	flags.StringVar(&o.semantics, "delivery", kafka.DeliveryAtLeastOnce, "commit after (at-least-once) or before (at-most-once) processing")
+	bindEnv(flags, "delivery", "DELIVERY_SEMANTICS")
+	flags.IntVar(&o.crashAfter, "simulate-crash-after", 0, "pretend to crash once on this message to show what the delivery semantics lose or repeat")
+	bindEnv(flags, "simulate-crash-after", "SIMULATE_CRASH_AFTER")
+	flags.StringVar(&o.handlers, "handlers", "", "extra message handlers, e.g. json-validate,log,file:/tmp/events.jsonl")
+	bindEnv(flags, "handlers", "CONSUMER_HANDLERS")
+	flags.StringVar(&o.dedup, "dedup", "", "skip messages whose message-id was processed before, remembered in memory or bolt (a BoltDB file)")
+	bindEnv(flags, "dedup", "DEDUP")
+	flags.DurationVar(&o.dedupTTL, "dedup-ttl", time.Hour, "how long a message ID is remembered")
+	bindEnv(flags, "dedup-ttl", "DEDUP_TTL")
+	flags.IntVar(&o.dedupSize, "dedup-size", 100000, "message IDs the memory store holds before evicting the least recently used")
+	bindEnv(flags, "dedup-size", "DEDUP_SIZE")
+	flags.StringVar(&o.dedupFile, "dedup-file", "dedup.db", "BoltDB file of the bolt store, one per consumer")
+	bindEnv(flags, "dedup-file", "DEDUP_FILE")
+	flags.StringVar(&o.filter, "filter", "", `only log and handle messages matching this expression, e.g. 'event_type == "purchase"'`)
+	bindEnv(flags, "filter", "FILTER")
+	flags.Float64Var(&o.failRate, "fail-rate", 0, "fail this share of processing attempts on purpose, e.g. 0.05, to exercise retries and the DLQ")
+	bindEnv(flags, "fail-rate", "FAIL_RATE")
+	flags.StringSliceVar(&o.failKeys, "fail-keys", nil, "always fail messages with these keys, e.g. user-456, to simulate poison pills")
+	bindEnv(flags, "fail-keys", "FAIL_KEYS")
+	flags.IntVar(&o.poisonAttempts, "poison-pill-attempts", 0, "skip a message once it failed this many times, counting redeliveries, 0 never skips")
+	bindEnv(flags, "poison-pill-attempts", "POISON_PILL_ATTEMPTS")
+	flags.StringVar(&o.poisonFile, "poison-pill-file", "", "append skipped poison pills to this file as JSON lines")
+	bindEnv(flags, "poison-pill-file", "POISON_PILL_FILE")
+	flags.BoolVar(&o.verifyOrdering, "verify-ordering", false, "check the sequence numbers of produce --sequence-numbers and exit non-zero if a key's messages are missing or out of order")
+	bindEnv(flags, "verify-ordering", "VERIFY_ORDERING")
+	flags.DurationVar(&o.latencyReport, "latency-report", 0, "log p50/p95/p99/max end-to-end latency per partition this often, e.g. 30s, 0 disables the report")
+	bindEnv(flags, "latency-report", "LATENCY_REPORT_INTERVAL")
+	flags.BoolVar(&o.showTimestamps, "show-timestamps", false, "compare the event time, record timestamp and produced-at header of every message")
+	bindEnv(flags, "show-timestamps", "SHOW_TIMESTAMPS")
+	flags.DurationVar(&o.eventLateness, "allowed-lateness", 0, "track an event-time watermark per partition and treat events further behind the newest event time as late, 0 disables watermarks")
+	bindEnv(flags, "allowed-lateness", "EVENT_ALLOWED_LATENESS")
+	flags.StringVar(&o.lateTopic, "late-topic", "", "route late events to this topic instead of handling them")
+	bindEnv(flags, "late-topic", "LATE_EVENTS_TOPIC")
+	flags.Float64Var(&o.skewThreshold, "skew-threshold", 0, fmt.Sprintf("flag partitions read with more than this times the average messages or bytes, e.g. %g, 0 disables skew detection", kafka.DefaultSkewThreshold))
+	bindEnv(flags, "skew-threshold", "SKEW_THRESHOLD")
+	flags.DurationVar(&o.skewInterval, "skew-interval", time.Minute, "how often --skew-threshold checks the partitions")
+	bindEnv(flags, "skew-interval", "SKEW_CHECK_INTERVAL")
+	flags.StringVar(&o.heatmapFile, "heatmap-file", "", "write the messages per key and partition to this .csv or .json file on shutdown")
+	bindEnv(flags, "heatmap-file", "HEATMAP_FILE")
+	flags.DurationVar(&o.heatmapInterval, "heatmap-interval", 0, "also write --heatmap-file this often, 0 only writes it on shutdown")
+	bindEnv(flags, "heatmap-interval", "HEATMAP_INTERVAL")
+	o.resilience.addFlags(cmd)
+
+	cmd.MarkFlagsMutuallyExclusive("from-beginning", "from-latest", "start-from")
+	completeValues(cmd, "format", serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf)
+	completeValues(cmd, "rebalance-strategy", kafka.RebalanceStrategies...)
+	completeValues(cmd, "handlers", kafka.Handlers()...)
+	completeValues(cmd, "commit-mode", kafka.CommitAuto, kafka.CommitManual, kafka.CommitBatch, kafka.CommitInterval)
+	completeValues(cmd, "delivery", kafka.DeliveryAtLeastOnce, kafka.DeliveryAtMostOnce)
+	completeValues(cmd, "dedup", kafka.DedupMemory, kafka.DedupBolt)
+	return cmd
+}
+
+func runConsume(o consumeOptions) {
+	if o.fromBeginning {
+		o.startFrom = "beginning"
+	} else if o.fromLatest {
+		o.startFrom = "latest"
+	}
+
+	retryLevels, err := kafka.ParseRetryLevels(o.retryLevels)
+	if err != nil {
+		logging.Fatal("Invalid --retry-levels", "error", err)
+	}
+
+	priorities, err := kafka.ParseTopicPriorities(o.priorityTopics)
+	if err != nil {
+		logging.Fatal("Invalid --priority-topics", "error", err)
+	}
+
+	topics := o.topics
+	if len(topics) > 0 && o.topicPattern != "" {
+		logging.Fatal("--topics and --topic-pattern can't be combined")
+	}
+	if len(priorities) > 0 && (len(topics) > 0 || o.topicPattern != "") {
+		logging.Fatal("--priority-topics can't be combined with --topics or --topic-pattern")
+	}
+	if len(priorities) > 0 {
+		topics = kafka.PriorityTopics(priorities)
+	}
+	if (len(topics) > 0 || o.topicPattern != "") && (o.outputTopic != "" || o.partitions != "") {
+		logging.Fatal("--topics, --topic-pattern and --priority-topics only work in consumer group mode, not with --output-topic or --partitions")
+	}
+	if len(topics) == 0 {
+		topics = []string{o.topic}
+	}
+	o.topic = topics[0]
+	if o.rebalanceDebug && (o.metricsPort == 0 || o.partitions != "") {
+		logging.Fatal("--debug-rebalances needs --metrics-port and a consumer group, it can't be combined with --partitions")
+	}
+	if o.controlPort > 0 && o.partitions != "" {
+		logging.Fatal("--control-port needs a consumer group, it can't be combined with --partitions")
+	}
+	if o.dashboardPort > 0 && o.outputTopic != "" {
+		logging.Fatal("--dashboard-port can't be combined with --output-topic")
+	}
+	if o.tui && o.outputTopic != "" {
+		logging.Fatal("--tui can't be combined with --output-topic")
+	}
+	if o.dedup != "" && o.outputTopic != "" {
+		logging.Fatal("--dedup can't be combined with --output-topic, the pipeline's transactions already process each message once")
+	}
+	if o.filter != "" && o.outputTopic != "" {
+		logging.Fatal("--filter can't be combined with --output-topic, use the pipeline command to filter into another topic")
+	}
+	if (o.failRate > 0 || len(o.failKeys) > 0 || o.poisonAttempts > 0) && o.outputTopic != "" {
+		logging.Fatal("--fail-rate, --fail-keys and --poison-pill-attempts can't be combined with --output-topic")
+	}
+	if o.poisonFile != "" && o.poisonAttempts == 0 {
+		logging.Fatal("--poison-pill-file needs --poison-pill-attempts")
+	}
+	if o.latencyReport > 0 && o.outputTopic != "" {
+		logging.Fatal("--latency-report can't be combined with --output-topic")
+	}
+	if o.showTimestamps && o.outputTopic != "" {
+		logging.Fatal("--show-timestamps can't be combined with --output-topic")
+	}
+	if o.inFlight < 0 {
+		logging.Fatal("--in-flight-buffer must not be negative", "in_flight_buffer", o.inFlight)
+	}
+	if o.inFlight > 0 && (o.outputTopic != "" || o.partitions != "") {
+		logging.Fatal("--in-flight-buffer only works in consumer group mode, not with --output-topic or --partitions")
+	}
+	if o.eventLateness < 0 {
+		logging.Fatal("--allowed-lateness must not be negative", "allowed_lateness", o.eventLateness)
+	}
+	if o.eventLateness > 0 && o.outputTopic != "" {
+		logging.Fatal("--allowed-lateness can't be combined with --output-topic")
+	}
+	if o.lateTopic != "" && o.eventLateness == 0 {
+		logging.Fatal("--late-topic needs --allowed-lateness")
+	}
+	if o.skewThreshold != 0 && o.skewThreshold <= 1 {
+		logging.Fatal("--skew-threshold must be above 1, or 0 to disable it", "threshold", o.skewThreshold)
+	}
+	if o.skewThreshold > 0 && o.outputTopic != "" {
+		logging.Fatal("--skew-threshold can't be combined with --output-topic")
+	}
+	if o.heatmapFile != "" && o.outputTopic != "" {
+		logging.Fatal("--heatmap-file can't be combined with --output-topic")
+	}
+	if o.heatmapInterval > 0 && o.heatmapFile == "" {
+		logging.Fatal("--heatmap-interval needs --heatmap-file")
+	}
+	if o.verifyOrdering && (o.outputTopic != "" || o.partitions != "") {
+		logging.Fatal("--verify-ordering needs a consumer group, it can't be combined with --output-topic or --partitions")
+	}
+	if memoryBroker != nil && (o.outputTopic != "" || o.partitions != "" || o.topicPattern != "" || o.healthPort > 0) {
+		logging.Fatal("--output-topic, --partitions, --topic-pattern and --health-port need a Kafka cluster, they don't work with --mode memory")
+	}
+
+	settings := []any{"brokers", brokers}
+	if o.topicPattern != "" {
+		settings = append(settings, "topic_pattern", o.topicPattern, "topic_refresh", o.topicRefresh)
+	} else if len(priorities) > 0 {
+		settings = append(settings, "priority_topics", o.priorityTopics)
+	} else {
+		settings = append(settings, "topics", topics)
+	}
+	if o.outputTopic != "" {
+		settings = append(settings,
+			"mode", "transactional consume-transform-produce",
+			"output_topic", o.outputTopic,
+			"transactional_id", o.transactionalID,
+			"group", o.groupID,
+			"rebalance_strategy", o.rebalance)
+	} else if o.partitions != "" {
+		settings = append(settings,
+			"mode", "manual partition assignment",
+			"partitions", o.partitions,
+			"start_offset", o.startOffset)
+	} else {
+		settings = append(settings, "mode", "consumer group", "group", o.groupID, "rebalance_strategy", o.rebalance, "concurrency", o.concurrency,
+			"delivery", o.semantics, "commit_mode", o.commit.Mode)
+		switch o.commit.Mode {
+		case kafka.CommitBatch:
+			settings = append(settings, "commit_every", o.commit.Every)
+		case kafka.CommitInterval:
+			settings = append(settings, "commit_interval", o.commit.Interval)
+		}
+		if o.inFlight > 0 {
+			settings = append(settings, "in_flight_buffer", o.inFlight)
+		}
+		if o.crashAfter > 0 {
+			settings = append(settings, "simulate_crash_after", o.crashAfter)
+		}
+	}
+	if o.instanceID != "" {
+		settings = append(settings, "group_instance_id", o.instanceID)
+	}
+	if o.maxMessages > 0 {
+		settings = append(settings, "max_messages", o.maxMessages)
+	}
+	if o.startFrom != "" {
+		settings = append(settings, "start_from", o.startFrom)
+	}
+	settings = append(settings, "message_format", o.messageFormat)
+	if o.readerVersion > 0 {
+		settings = append(settings, "schema_reader_version", o.readerVersion)
+	}
+	if o.dlqTopic != "" {
+		settings = append(settings, "dlq_topic", o.dlqTopic, "max_retries", o.maxRetries)
+	}
+	if o.handlers != "" {
+		settings = append(settings, "handlers", o.handlers)
+	}
+	if o.topicHandlers != "" {
+		settings = append(settings, "topic_handlers", o.topicHandlers)
+	}
+	settings = append(settings, "tls", tlsConfig.Enabled, "shutdown_timeout", o.shutdownTimeout)
+	switch o.dedup {
+	case kafka.DedupMemory:
+		settings = append(settings, "dedup", o.dedup, "dedup_ttl", o.dedupTTL, "dedup_size", o.dedupSize)
+	case kafka.DedupBolt:
+		settings = append(settings, "dedup", o.dedup, "dedup_ttl", o.dedupTTL, "dedup_file", o.dedupFile)
+	}
+	if o.filter != "" {
+		settings = append(settings, "filter", o.filter)
+	}
+	if o.failRate > 0 || len(o.failKeys) > 0 {
+		settings = append(settings, "fail_rate", o.failRate, "fail_keys", o.failKeys)
+	}
+	if o.poisonAttempts > 0 {
+		settings = append(settings, "poison_pill_attempts", o.poisonAttempts)
+		if o.poisonFile != "" {
+			settings = append(settings, "poison_pill_file", o.poisonFile)
+		}
+	}
+	if o.verifyOrdering {
+		settings = append(settings, "verify_ordering", true)
+	}
+	if o.latencyReport > 0 {
+		settings = append(settings, "latency_report_interval", o.latencyReport)
+	}
+	if o.showTimestamps {
+		settings = append(settings, "show_timestamps", true)
+	}
+	if o.eventLateness > 0 {
+		settings = append(settings, "allowed_lateness", o.eventLateness)
+		if o.lateTopic != "" {
+			settings = append(settings, "late_topic", o.lateTopic)
+		}
+	}
+	if o.skewThreshold > 0 {
+		settings = append(settings, "skew_threshold", o.skewThreshold, "skew_interval", o.skewInterval)
+	}
+	if o.heatmapFile != "" {
+		settings = append(settings, "heatmap_file", o.heatmapFile, "heatmap_interval", o.heatmapInterval)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	slog.Info("Starting Kafka Consumer - Partition Routing Demo", settings...)
+	if o.topicPattern != "" {
+		for _, level := range retryLevels {
+			slog.Info("Retry topics configured", "retry_topic", kafka.RetryTopic("<topic>", level), "delay", level.Delay)
+		}
+	} else {
+		for _, topic := range topics {
+			for _, level := range retryLevels {
+				slog.Info("Retry topic configured", "retry_topic", kafka.RetryTopic(topic, level), "delay", level.Delay)
+			}
+		}
+	}
+	slog.Info("Messages with the same key (user ID) always come from the same partition")
+
+	serializer, err := serde.New(o.messageFormat, o.topic, o.registryURL)
+	if err != nil {
+		logging.Fatal("Failed to create serializer", "error", err)
+	}
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	var consumer messageConsumer
+
+	// Messages that can't be decoded as a UserEvent count as processing
+	// failures, so they are retried and dead-lettered.
+	decode := kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		_, err := consumer.DecodeEvent(message.Raw())
+		return err
+	})
+
+	extra, err := kafka.NewHandlers(o.handlers)
+	if err != nil {
+		logging.Fatal("Invalid --handlers", "error", err)
+	}
+
+	// Topics with their own handlers skip the UserEvent decoding, since
+	// they usually carry other payloads.
+	handler, err := kafka.NewTopicHandlers(o.topicHandlers, kafka.Chain{decode, extra})
+	if err != nil {
+		logging.Fatal("Invalid --topic-handlers", "error", err)
+	}
+
+	// The views come last, so that messages only show up once they are
+	// processed. Injected failures come first, so they fail a message
+	// before any handler has seen it.
+	messageHandler := kafka.Chain{handler}
+	if o.failRate > 0 || len(o.failKeys) > 0 {
+		injector, err := kafka.NewFailureInjector(o.failRate, o.failKeys)
+		if err != nil {
+			logging.Fatal("Invalid --fail-rate", "error", err)
+		}
+		messageHandler = kafka.Chain{injector, handler}
+	}
+	var view *tui.TUI
+	if o.tui {
+		view = tui.New(tui.Config{
+			Title:    tuiTitle(o, topics),
+			Messages: o.tuiMessages,
+			Format: func(message *kafka.Message) string {
+				if event, err := consumer.DecodeEvent(message.Raw()); err == nil {
+					if decoded, err := json.Marshal(event); err == nil {
+						return string(decoded)
+					}
+				}
+				return string(message.Value)
+			},
+		})
+		messageHandler = append(messageHandler, view.Handler())
+	}
+	var tail *dashboard.Tail
+	if o.dashboardPort > 0 {
+		tail = dashboard.NewTail(dashboard.TailConfig{
+			Backfill: o.tailBackfill,
+			Rate:     o.tailRate,
+			Decode: func(message *kafka.Message) (kafka.UserEvent, error) {
+				return consumer.DecodeEvent(message.Raw())
+			},
+			Filter: newMessageFilter,
+		})
+		defer tail.Close()
+		messageHandler = append(messageHandler, tail.Handler())
+	}
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithSerializer(serializer),
+		kafka.WithHandler(messageHandler),
+		kafka.WithMaxRetries(o.maxRetries),
+		kafka.WithRetryLevels(retryLevels...),
+		kafka.WithShutdownTimeout(o.shutdownTimeout),
+		kafka.WithConcurrency(o.concurrency),
+		kafka.WithCommitStrategy(o.commit),
+		kafka.WithDeliverySemantics(o.semantics),
+		kafka.WithSimulatedCrash(o.crashAfter),
+		kafka.WithTopicRefresh(o.topicRefresh),
+		kafka.WithSchemaReaderVersion(o.readerVersion),
+		kafka.WithRebalanceStrategy(o.rebalance),
+		kafka.WithGroupInstanceID(o.instanceID),
+	)
+	opts = append(opts, o.resilience.options()...)
+	if o.dlqTopic != "" {
+		opts = append(opts, kafka.WithDeadLetterQueue(o.dlqTopic))
+	}
+	if o.dedup != "" {
+		store, err := newDedupStore(o.dedup, o.dedupFile, o.dedupSize, o.dedupTTL)
+		if err != nil {
+			logging.Fatal("Invalid --dedup", "error", err)
+		}
+		defer store.Close()
+		opts = append(opts, kafka.WithDedup(store))
+	}
+	if o.filter != "" {
+		filter, err := newMessageFilter(o.filter)
+		if err != nil {
+			logging.Fatal("Invalid --filter", "error", err)
+		}
+		opts = append(opts, kafka.WithFilter(filter))
+	}
+	if o.poisonAttempts > 0 {
+		var recorder kafka.PoisonPillRecorder
+		if o.poisonFile != "" {
+			file, err := kafka.NewPoisonPillFile(o.poisonFile)
+			if err != nil {
+				logging.Fatal("Invalid --poison-pill-file", "error", err)
+			}
+			defer file.Close()
+			recorder = file
+		}
+		opts = append(opts, kafka.WithPoisonPillDetection(o.poisonAttempts, recorder))
+	}
+	var ordering *kafka.OrderingVerifier
+	if o.verifyOrdering {
+		ordering = kafka.NewOrderingVerifier()
+		opts = append(opts, kafka.WithOrderingVerifier(ordering))
+	}
+	if o.latencyReport > 0 {
+		opts = append(opts, kafka.WithLatencyReport(o.latencyReport))
+	}
+	if o.showTimestamps {
+		opts = append(opts, kafka.WithTimestampView())
+		if o.topicPattern == "" {
+			logTimestampTypes(topics)
+		}
+	}
+	if o.inFlight > 0 {
+		opts = append(opts, kafka.WithInFlightBuffer(o.inFlight))
+	}
+	if o.eventLateness > 0 {
+		opts = append(opts, kafka.WithEventTimeWatermarks(o.eventLateness, o.lateTopic))
+	}
+	if o.skewThreshold > 0 {
+		opts = append(opts, kafka.WithSkewDetection(o.skewThreshold, o.skewInterval))
+	}
+	if len(priorities) > 0 {
+		opts = append(opts, kafka.WithTopicPriorities(priorities...))
+	}
+	var rebalances *kafka.RebalanceHistory
+	if o.rebalanceDebug || ((o.healthPort > 0 || o.dashboardPort > 0) && o.partitions == "") {
+		rebalances = kafka.NewRebalanceHistory()
+		opts = append(opts, kafka.WithRebalanceHistory(rebalances))
+	}
+	if o.healthPort > 0 {
+		var healthTopics []string
+		if o.topicPattern == "" {
+			healthTopics = topics
+		}
+		var health *kafka.HealthCheck
+		o.resilience.connect(ctx, "health check", func() (err error) {
+			health, err = kafka.NewHealthCheck(brokers, healthTopics, clientOptions()...)
+			return err
+		})
+		defer health.Close()
+		if rebalances != nil {
+			health.AddReadiness("assignment", func() error { return rebalances.CheckAssigned(o.readinessGrace) })
+		}
+		server := serveHealth(o.healthPort, health)
+		defer server.Close()
+	}
+	if o.metricsPort > 0 {
+		m := metrics.New()
+		if o.rebalanceDebug {
+			m.Handle("/debug/rebalances", rebalances)
+			slog.Info("Rebalance history available", "url", fmt.Sprintf("http://localhost:%d/debug/rebalances", o.metricsPort))
+		}
+		server := m.Serve(o.metricsPort)
+		defer server.Close()
+		opts = append(opts, kafka.WithMetrics(m), kafka.WithConfigFunc(m.ConfigureSarama))
+		slog.Info("Metrics available", "url", fmt.Sprintf("http://localhost:%d/metrics", o.metricsPort))
+	}
+	if o.startFrom != "" {
+		startOpt, err := kafka.ParseStartFrom(o.startFrom)
+		if err != nil {
+			logging.Fatal("Invalid start position", "error", err)
+		}
+		opts = append(opts, startOpt)
+	}
+
+	if o.outputTopic != "" && o.transactionalID == "" {
+		logging.Fatal("--transactional-id is required with --output-topic")
+	}
+	o.resilience.connect(ctx, "consumer", func() (err error) {
+		if o.outputTopic != "" {
+			consumer, err = newPipeline(brokers, o.topic, o.outputTopic, o.groupID, o.transactionalID, serializer, opts)
+		} else if o.partitions != "" {
+			consumer, err = newPartitionConsumer(brokers, o.topic, o.partitions, o.startOffset, opts)
+		} else if o.topicPattern != "" {
+			consumer, err = kafka.NewPatternConsumer(brokers, o.topicPattern, o.groupID, opts...)
+		} else {
+			consumer, err = kafka.NewMultiTopicConsumer(brokers, topics, o.groupID, opts...)
+		}
+		return err
+	})
+	defer consumer.Close()
+
+	if o.controlPort > 0 {
+		server := serveHTTP("Control", o.controlPort, kafka.NewControlHandler(consumer.(kafka.Pauser)))
+		defer server.Close()
+		slog.Info("Control endpoints available", "pause", fmt.Sprintf("http://localhost:%d/pause", o.controlPort),
+			"resume", fmt.Sprintf("http://localhost:%d/resume", o.controlPort))
+	}
+	// The dashboard and the terminal view show the lag of the group, which
+	// the memory broker doesn't report.
+	var lag func() ([]kafka.PartitionLag, error)
+	if (o.dashboardPort > 0 || o.tui) && o.partitions == "" && memoryBroker == nil {
+		monitor, err := kafka.NewLagMonitor(brokers, o.groupID, "", clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to create lag monitor", "error", err)
+		}
+		defer monitor.Close()
+		lag = monitor.Lag
+	}
+	if o.dashboardPort > 0 {
+		d := dashboard.New(dashboard.Config{
+			Role:       "consumer",
+			Topic:      o.topic,
+			Trackers:   consumer.(kafka.TrackingConsumer).PartitionTrackers,
+			Lag:        lag,
+			Rebalances: rebalances,
+			Tail:       tail,
+		})
+		defer d.Close()
+		server := serveDashboard(o.dashboardPort, d)
+		defer server.Close()
+	}
+
+	var heatmap *kafka.HeatmapExporter
+	if o.heatmapFile != "" {
+		heatmap, err = kafka.NewHeatmapExporter(o.heatmapFile, consumer.(kafka.TrackingConsumer).PartitionTrackers, nil)
+		if err != nil {
+			logging.Fatal("Invalid --heatmap-file", "error", err)
+		}
+		if o.heatmapInterval > 0 {
+			go heatmap.Run(ctx, o.heatmapInterval)
+		}
+	}
+
+	slog.Info("Starting to consume messages...")
+	if view != nil {
+		view.SetLag(lag)
+		err = view.Run(ctx, cancel, consumer.Consume)
+	} else {
+		err = consumer.Consume(ctx)
+	}
+	if err != nil {
+		logging.Fatal("Error consuming messages", "error", err)
+	}
+	if heatmap != nil {
+		heatmap.LogWrite()
+	}
+	if ordering != nil {
+		if report := ordering.Report(); !report.OK() {
+			logging.Fatal("Per-key ordering verification failed", "reordered", report.Reordered, "missing", report.Missing)
+		}
+	}
+
+	slog.Info("Consumer stopped")
+}
+
+// tuiTitle describes what the consumer reads for the terminal view.
+func tuiTitle(o consumeOptions, topics []string) string {
+	switch {
+	case o.partitions != "":
+		return fmt.Sprintf("kafka-hwsw consume  topic %s  partitions %s", o.topic, o.partitions)
+	case o.topicPattern != "":
+		return fmt.Sprintf("kafka-hwsw consume  group %s  topics matching %s", o.groupID, o.topicPattern)
+	default:
+		return fmt.Sprintf("kafka-hwsw consume  group %s  topics %s", o.groupID, strings.Join(topics, ","))
+	}
+}
+
+// closeGrace is how long the consumer may take to commit offsets and leave
+// the group after in-flight processing has been given --shutdown-timeout.
+const closeGrace = 5 * time.Second
+
+type messageConsumer interface {
+	Consume(ctx context.Context) error
+	DecodeEvent(message *sarama.ConsumerMessage) (kafka.UserEvent, error)
+	Close() error
+}
+
+func newPartitionConsumer(brokers []string, topic, partitionsStr, startOffset string, opts []kafka.Option) (messageConsumer, error) {
+	partitions, err := kafka.ParsePartitions(partitionsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := kafka.ParseOffset(startOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	return kafka.NewPartitionConsumer(brokers, topic, partitions, offset, opts...)
+}
+
+// logTimestampTypes logs which timestamp each topic keeps, so the records
+// of --show-timestamps can be read right.
+func logTimestampTypes(topics []string) {
+	if memoryBroker != nil {
+		slog.Info("Topic timestamp type", "topics", topics, "type", "CreateTime", "note", "the in-memory broker keeps the timestamp the producer set")
+		return
+	}
+	admin, err := kafka.NewAdmin(brokers, clientOptions()...)
+	if err != nil {
+		slog.Warn("Failed to look up the topic timestamp type", "error", err)
+		return
+	}
+	defer admin.Close()
+	for _, topic := range topics {
+		timestampType, err := admin.TopicConfig(topic, "message.timestamp.type")
+		if err != nil {
+			slog.Warn("Failed to look up the topic timestamp type", "topic", topic, "error", err)
+			continue
+		}
+		note := "record timestamps are what the producer set, --record-timestamp on produce"
+		if timestampType == "LogAppendTime" {
+			note = "record timestamps are the time the broker appended the record, whatever the producer set"
+		}
+		slog.Info("Topic timestamp type", "topic", topic, "type", timestampType, "note", note)
+	}
+}
+
+func newDedupStore(kind, path string, size int, ttl time.Duration) (kafka.DedupStore, error) {
+	switch kind {
+	case kafka.DedupMemory:
+		return kafka.NewMemoryDedupStore(size, ttl)
+	case kafka.DedupBolt:
+		return kafka.NewBoltDedupStore(path, ttl)
+	default:
+		return nil, fmt.Errorf("unsupported dedup store: %s", kind)
+	}
+}
+
+// newMessageFilter evaluates expression against the decoded event in its
+// JSON form, plus the message's key and headers, e.g.
+// key == "user-123" or headers.schema-version >= 2.
+func newMessageFilter(expression string) (kafka.MessageFilter, error) {
+	expr, err := transform.ParseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	return func(message *kafka.Message, event *kafka.UserEvent) (bool, error) {
+		fields := make(map[string]any)
+		if event != nil {
+			var err error
+			if fields, err = eventFields(*event); err != nil {
+				return false, err
+			}
+		}
+		fields["key"] = string(message.Key)
+		headers := make(map[string]any, len(message.Headers))
+		for name, value := range message.Headers {
+			headers[name] = value
+		}
+		fields["headers"] = headers
+		return expr.Match(fields)
+	}, nil
+}
+
+// newPipeline creates the exactly-once demo pipeline, which stamps each
+// event with the time it was processed and forwards it to outputTopic.
+func newPipeline(brokers []string, topic, outputTopic, groupID, transactionalID string, serializer kafka.Serializer, opts []kafka.Option) (messageConsumer, error) {
+	var pipeline *kafka.Pipeline
+
+	transform := func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+		event, err := pipeline.DecodeEvent(message)
+		if err != nil {
+			return nil, err
+		}
+
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		event.Data["processed_at"] = time.Now().UTC().Format(time.RFC3339)
+
+		value, err := serializer.Serialize(event)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep the trace ID so the enriched event can be correlated with
+		// the one it was derived from.
+		extra := make(map[string]string)
+		if traceID, ok := kafka.MessageHeaders(message)[kafka.TraceIDHeader]; ok {
+			extra[kafka.TraceIDHeader] = traceID
+		}
+
+		return []*sarama.ProducerMessage{{
+			Key:     sarama.StringEncoder(event.UserID),
+			Value:   sarama.ByteEncoder(value),
+			Headers: kafka.EventHeaders(serializer, event, extra),
+		}}, nil
+	}
+
+	pipeline, err := kafka.NewPipeline(brokers, topic, outputTopic, groupID, transactionalID, transform, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
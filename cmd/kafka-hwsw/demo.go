@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type demoOptions struct {
+	topic           string
+	groupID         string
+	count           int
+	partitioner     string
+	keyStrategy     string
+	recordTimestamp string
+	showTimestamps  bool
+	timeout         time.Duration
+	events          eventOptions
+}
+
+func newDemoCommand() *cobra.Command {
+	var o demoOptions
+
+	cmd := &cobra.Command{
+		Use:   "demo",
+		Short: "Produce generated events, consume them back and check every key kept its partition",
+		Long: `Run the partition routing demo in one process: produce --count generated
+events, consume them back in a consumer group and compare the partitions
+each key was sent to and read from. Every event carries the sequence
+number of its key, so the consumer also checks that each key's events came
+back once and in the order they were sent. It exits non-zero if a key
+changed partitions, its events came back out of order, or they don't all
+come back within --timeout.
+
+With --mode memory it needs no cluster at all and, with a fixed --seed,
+produces the same output on every run, which suits CI. Against a cluster
+the consumer reads the topic from the beginning, so older messages show up
+in its summary too.`,
+		Example: "  kafka-hwsw demo --mode memory --seed 42\n" +
+			"  KAFKA_MODE=memory kafka-hwsw demo --partitioner murmur2 --count 100\n" +
+			"  kafka-hwsw demo --mode memory --partitioner roundrobin  # fails: keys lose their order",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runDemo(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to produce to and consume from")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "demo-group", "consumer group of the consuming side")
+	bindEnv(flags, "group", "DEMO_GROUP_ID")
+	flags.IntVarP(&o.count, "count", "n", 20, "number of events to send")
+	bindEnv(flags, "count", "MESSAGE_COUNT")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerHash, "partitioner: hash, murmur2, roundrobin, random or manual")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.StringVar(&o.keyStrategy, "key-strategy", kafka.KeyUserID, "message key: user_id, session_id, composite, null or uuid")
+	bindEnv(flags, "key-strategy", "KEY_STRATEGY")
+	flags.DurationVar(&o.timeout, "timeout", 30*time.Second, "how long to wait for the events to come back")
+	bindEnv(flags, "timeout", "DEMO_TIMEOUT")
+	flags.StringVar(&o.recordTimestamp, "record-timestamp", kafka.RecordTimestampSend, "record timestamp: send (the send time), event (the event's timestamp) or an RFC3339 time")
+	bindEnv(flags, "record-timestamp", "RECORD_TIMESTAMP")
+	flags.BoolVar(&o.showTimestamps, "show-timestamps", false, "compare the event time, record timestamp and produced-at header of every message read back")
+	bindEnv(flags, "show-timestamps", "SHOW_TIMESTAMPS")
+	o.events.addFlags(cmd)
+
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom, kafka.PartitionerManual)
+	completeValues(cmd, "key-strategy", kafka.KeyStrategies...)
+	completeValues(cmd, "record-timestamp", kafka.RecordTimestampSources...)
+	cmd.AddCommand(newFanoutDemoCommand())
+	return cmd
+}
+
+func runDemo(o demoOptions) {
+	gen, err := o.events.newGenerator()
+	if err != nil {
+		logging.Fatal("Invalid event generator settings", "error", err)
+	}
+	recordTimestamp, err := recordTimestampOption(o.recordTimestamp)
+	if err != nil {
+		logging.Fatal("Invalid --record-timestamp", "error", err)
+	}
+	settings := []any{
+		"mode", mode,
+		"topic", o.topic,
+		"group", o.groupID,
+		"message_count", o.count,
+		"partitioner", o.partitioner,
+		"key_strategy", o.keyStrategy,
+		"record_timestamp", o.recordTimestamp,
+		"seed", gen.Seed(),
+	}
+	if mode == modeKafka {
+		settings = append(settings, "brokers", brokers)
+	}
+	settings = append(settings, o.events.settings()...)
+	slog.Info("Starting partition routing demo", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	producer, err := kafka.NewProducer(brokers, o.topic, append(clientOptions(),
+		kafka.WithPartitioner(o.partitioner),
+		kafka.WithKeyStrategy(o.keyStrategy),
+		kafka.WithSequenceNumbers(),
+		recordTimestamp,
+	)...)
+	if err != nil {
+		logging.Fatal("Failed to create producer", "error", err)
+	}
+
+	sent := kafka.NewPartitionTracker()
+	sent.SetPartitioner(o.partitioner)
+	sent.SetKeyStrategy(o.keyStrategy)
+	// The consumer waits for exactly the messages sent here, whatever else
+	// the topic holds.
+	pending := make(map[int32]map[int64]bool)
+	for _, event := range gen.Generate(o.count) {
+		d := producer.Send(event, nil)
+		if d.Err != nil {
+			logging.Fatal("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+		}
+		slog.Info("Message sent", "topic", o.topic, "partition", d.Partition,
+			"offset", d.Offset, "key", d.Key, "event_type", event.EventType)
+		sent.Record(d.Key, d.Partition)
+		if pending[d.Partition] == nil {
+			pending[d.Partition] = make(map[int64]bool)
+		}
+		pending[d.Partition][d.Offset] = true
+	}
+	if err := producer.Close(); err != nil {
+		slog.Error("Failed to close producer", "error", err)
+	}
+	sent.LogSummary("went to")
+
+	var mu sync.Mutex
+	received := kafka.NewPartitionTracker()
+	ordering := kafka.NewOrderingVerifier()
+	remaining := o.count
+	consumeCtx, stop := context.WithTimeout(ctx, o.timeout)
+	defer stop()
+	collect := kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !pending[message.Partition][message.Offset] {
+			return nil
+		}
+		delete(pending[message.Partition], message.Offset)
+		received.Record(string(message.Key), message.Partition)
+		ordering.Record(message)
+		if remaining--; remaining == 0 {
+			stop()
+		}
+		return nil
+	})
+
+	consumerOpts := append(clientOptions(),
+		kafka.WithHandler(collect),
+		kafka.WithStartFromBeginning(),
+	)
+	if o.showTimestamps {
+		consumerOpts = append(consumerOpts, kafka.WithTimestampView())
+		logTimestampTypes([]string{o.topic})
+	}
+	consumer, err := kafka.NewConsumer(brokers, o.topic, o.groupID, consumerOpts...)
+	if err != nil {
+		logging.Fatal("Failed to create consumer", "error", err)
+	}
+	defer consumer.Close()
+
+	slog.Info("Consuming the messages back", "group", o.groupID, "timeout", o.timeout)
+	if err := consumer.Consume(consumeCtx); err != nil {
+		logging.Fatal("Error consuming messages", "error", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ctx.Err() != nil {
+		slog.Info("Demo stopped")
+		return
+	}
+	if remaining > 0 {
+		logging.Fatal("Not every message came back", "missing", remaining, "of", o.count, "timeout", o.timeout)
+	}
+
+	moved := 0
+	for _, key := range sent.Keys() {
+		if from, to := sent.UniquePartitions(key), received.UniquePartitions(key); !slices.Equal(from, to) {
+			slog.Error("Key was read from other partitions than it was sent to", "key", key, "sent_to", from, "read_from", to)
+			moved++
+		}
+	}
+	ordering.LogSummary()
+	report := ordering.Report()
+	if moved > 0 || !report.OK() {
+		logging.Fatal("Partition routing demo failed", "keys_moved", moved, "reordered", report.Reordered)
+	}
+	slog.Info("Every message came back in order from the partition it was sent to", "messages", o.count, "keys", len(sent.Keys()))
+}
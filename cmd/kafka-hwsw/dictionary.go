@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type dictionaryOptions struct {
+	topic             string
+	output            string
+	sampleCount       int
+	compareCount      int
+	maxSize           int
+	messageFormat     string
+	schemaRegistryURL string
+	events            eventOptions
+}
+
+func newDictionaryCommand() *cobra.Command {
+	var o dictionaryOptions
+
+	cmd := &cobra.Command{
+		Use:   "dictionary",
+		Short: "Train a zstd dictionary on sample events and compare payload sizes",
+		Long: `Generate sample events, train a zstd dictionary on them and write it to
+--output for --zstd-dictionary. Then compress another set of events one record
+at a time with each codec and with zstd and the dictionary, and report the
+sizes. Codecs that only see a single small event have little to work with; the
+dictionary brings what the events have in common. Nothing is sent to Kafka.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runDictionary(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic the events are serialized for, which names their schema subject")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.output, "output", "o", "events.dict", "file to write the dictionary to")
+	bindEnv(flags, "output", "DICTIONARY_OUTPUT")
+	flags.IntVarP(&o.sampleCount, "count", "n", 5000, "events to train the dictionary on")
+	bindEnv(flags, "count", "DICTIONARY_SAMPLES")
+	flags.IntVar(&o.compareCount, "compare-count", 1000, "other events to compare the sizes on, 0 skips the report")
+	bindEnv(flags, "compare-count", "DICTIONARY_COMPARE_COUNT")
+	flags.IntVar(&o.maxSize, "max-size", kafka.DefaultDictionarySize, "largest dictionary to build, in bytes")
+	bindEnv(flags, "max-size", "DICTIONARY_MAX_SIZE")
+	flags.StringVar(&o.messageFormat, "format", serde.FormatJSON, "message format: json, avro or protobuf")
+	bindEnv(flags, "format", "MESSAGE_FORMAT")
+	flags.StringVar(&o.schemaRegistryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	o.events.addFlags(cmd)
+
+	completeValues(cmd, "format", serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf)
+	return cmd
+}
+
+func runDictionary(o dictionaryOptions) {
+	if o.compareCount < 0 {
+		logging.Fatal("--compare-count must not be negative", "compare_count", o.compareCount)
+	}
+	gen, err := o.events.newGenerator()
+	if err != nil {
+		logging.Fatal("Invalid event generator settings", "error", err)
+	}
+
+	settings := []any{
+		"output", o.output,
+		"samples", o.sampleCount,
+		"compare_count", o.compareCount,
+		"max_size", o.maxSize,
+		"message_format", o.messageFormat,
+		"seed", gen.Seed(),
+	}
+	settings = append(settings, o.events.settings()...)
+	slog.Info("Training zstd dictionary", settings...)
+
+	serializer, err := serde.New(o.messageFormat, o.topic, o.schemaRegistryURL)
+	if err != nil {
+		logging.Fatal("Failed to create serializer", "error", err)
+	}
+	serialize := func(events []kafka.UserEvent) [][]byte {
+		values := make([][]byte, len(events))
+		for i, event := range events {
+			if values[i], err = serializer.Serialize(event); err != nil {
+				logging.Fatal("Failed to serialize event", "error", err)
+			}
+		}
+		return values
+	}
+
+	// The report uses events the dictionary hasn't seen, as it would in
+	// production.
+	samples := serialize(gen.Generate(o.sampleCount))
+	dictionary, err := kafka.TrainDictionary(samples, o.maxSize)
+	if err != nil {
+		logging.Fatal("Failed to train dictionary", "error", err)
+	}
+	id, err := kafka.DictionaryID(dictionary)
+	if err != nil {
+		logging.Fatal("Trained an invalid dictionary", "error", err)
+	}
+	if err := os.WriteFile(o.output, dictionary, 0o644); err != nil {
+		logging.Fatal("Failed to write dictionary", "path", o.output, "error", err)
+	}
+	slog.Info("Dictionary written", "path", o.output, "id", id, "bytes", len(dictionary))
+
+	if o.compareCount == 0 {
+		return
+	}
+	results, err := compareDictionary(dictionary, serialize(gen.Generate(o.compareCount)))
+	if err != nil {
+		logging.Fatal("Failed to compare sizes", "error", err)
+	}
+	printDictionaryReport(results)
+}
+
+// sizeResult is the size of a set of values compressed one at a time.
+type sizeResult struct {
+	codec string
+	count int
+	bytes int
+	raw   int
+}
+
+// compareDictionary compresses every value on its own with each codec, the
+// way WithDictionaryCompression does, as opposed to the batches of the
+// producer's compression.
+func compareDictionary(dictionary []byte, values [][]byte) ([]sizeResult, error) {
+	plain, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer plain.Close()
+	withDict, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dictionary), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer withDict.Close()
+
+	codecs := []struct {
+		name     string
+		compress func([]byte) ([]byte, error)
+	}{
+		{"none", func(v []byte) ([]byte, error) { return v, nil }},
+		{"gzip", gzipValue},
+		{"snappy", func(v []byte) ([]byte, error) { return snappy.Encode(nil, v), nil }},
+		{"zstd", func(v []byte) ([]byte, error) { return plain.EncodeAll(v, nil), nil }},
+		{"zstd+dict", func(v []byte) ([]byte, error) { return withDict.EncodeAll(v, nil), nil }},
+	}
+	var results []sizeResult
+	for _, codec := range codecs {
+		result := sizeResult{codec: codec.name, count: len(values)}
+		for _, value := range values {
+			compressed, err := codec.compress(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", codec.name, err)
+			}
+			result.raw += len(value)
+			result.bytes += len(compressed)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func gzipValue(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func printDictionaryReport(results []sizeResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "CODEC\tMESSAGES\tRAW BYTES\tBYTES\tAVG BYTES\tSIZE/RAW\t\n")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%.2f\t\n",
+			r.codec, r.count, r.raw, r.bytes, float64(r.bytes)/float64(r.count), float64(r.bytes)/float64(r.raw))
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("Every value is compressed on its own, as with --zstd-dictionary; the producer's --compression works on whole batches.")
+	fmt.Println("zstd+dict values also carry the compression and compression-dict-id headers, about 50 bytes.")
+}
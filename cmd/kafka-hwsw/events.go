@@ -0,0 +1,111 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/generator"
+)
+
+// eventOptions are the event generator flags shared by the commands that
+// produce generated events.
+type eventOptions struct {
+	seed           int64
+	users          int
+	eventWeights   string
+	scenario       string
+	scenarioFile   string
+	realistic      bool
+	payloadBytes   int
+	payloadPadding string
+	timeOffset     time.Duration
+	timeJitter     time.Duration
+}
+
+func (o *eventOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.Int64Var(&o.seed, "seed", 0, "seed for the event generator, the same seed replays the same events (0 picks one)")
+	bindEnv(flags, "seed", "SEED")
+	flags.IntVar(&o.users, "users", 0, "number of distinct users (0 uses the scenario's count, 3 without one)")
+	bindEnv(flags, "users", "USER_COUNT")
+	flags.StringVar(&o.eventWeights, "event-weights", "", "relative event type frequencies, e.g. login=5,purchase=1")
+	bindEnv(flags, "event-weights", "EVENT_WEIGHTS")
+	flags.StringVar(&o.scenario, "scenario", "", "named traffic scenario, e.g. checkout-funnel or login-storm")
+	bindEnv(flags, "scenario", "SCENARIO")
+	flags.StringVar(&o.scenarioFile, "scenario-file", "", "YAML file with additional scenarios")
+	bindEnv(flags, "scenario-file", "SCENARIO_FILE")
+	flags.BoolVar(&o.realistic, "realistic", false, "fake but plausible users, locations, browsers and products instead of the demo payloads")
+	bindEnv(flags, "realistic", "REALISTIC_EVENTS")
+	flags.IntVar(&o.payloadBytes, "payload-bytes", 0, "pad every event to about this many bytes, 0 disables")
+	bindEnv(flags, "payload-bytes", "PAYLOAD_BYTES")
+	flags.StringVar(&o.payloadPadding, "payload-padding", generator.PaddingRandom, "padding for --payload-bytes: random (incompressible) or repeat (compressible)")
+	bindEnv(flags, "payload-padding", "PAYLOAD_PADDING")
+	flags.DurationVar(&o.timeOffset, "event-time-offset", 0, "shift event timestamps, e.g. -24h for yesterday's events or 1h for future ones")
+	bindEnv(flags, "event-time-offset", "EVENT_TIME_OFFSET")
+	flags.DurationVar(&o.timeJitter, "event-time-jitter", 0, "move every event timestamp by a random amount of up to this much either way, so events arrive out of order")
+	bindEnv(flags, "event-time-jitter", "EVENT_TIME_JITTER")
+
+	completeValues(cmd, "scenario", generator.BuiltinScenarios()...)
+	completeValues(cmd, "payload-padding", generator.PaddingRandom, generator.PaddingRepeat)
+}
+
+// settings returns the options worth logging at startup.
+func (o eventOptions) settings() []any {
+	var settings []any
+	if o.scenario != "" {
+		settings = append(settings, "scenario", o.scenario)
+	}
+	if o.users > 0 {
+		settings = append(settings, "users", o.users)
+	}
+	if o.eventWeights != "" {
+		settings = append(settings, "event_weights", o.eventWeights)
+	}
+	if o.realistic {
+		settings = append(settings, "realistic", true)
+	}
+	if o.payloadBytes > 0 {
+		settings = append(settings, "payload_bytes", o.payloadBytes, "payload_padding", o.payloadPadding)
+	}
+	if o.timeOffset != 0 {
+		settings = append(settings, "event_time_offset", o.timeOffset)
+	}
+	if o.timeJitter > 0 {
+		settings = append(settings, "event_time_jitter", o.timeJitter)
+	}
+	return settings
+}
+
+// newGenerator builds the event generator the flags describe.
+func (o eventOptions) newGenerator() (*generator.Generator, error) {
+	cfg := generator.Config{
+		Seed:         o.seed,
+		Users:        o.users,
+		Realistic:    o.realistic,
+		PayloadBytes: o.payloadBytes,
+		Padding:      o.payloadPadding,
+		TimeOffset:   o.timeOffset,
+		TimeJitter:   o.timeJitter,
+	}
+
+	if o.eventWeights != "" {
+		weights, err := generator.ParseWeights(o.eventWeights)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Weights = weights
+	}
+
+	if o.scenario != "" {
+		scenarios, err := generator.LoadScenarios(o.scenarioFile)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Scenario, err = scenarios.Get(o.scenario); err != nil {
+			return nil, err
+		}
+	}
+
+	return generator.New(cfg)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type fanoutOptions struct {
+	topic       string
+	groupPrefix string
+	groups      int
+	members     int
+	count       int
+	partitioner string
+	intervalMS  int
+	timeout     time.Duration
+	events      eventOptions
+}
+
+func newFanoutDemoCommand() *cobra.Command {
+	var o fanoutOptions
+
+	cmd := &cobra.Command{
+		Use:   "fanout",
+		Short: "Show that every consumer group gets every message while a group's members share them",
+		Long: `Run one producer and --groups consumer groups of --members members each
+concurrently in one process. Once every group has assigned its partitions
+the producer sends --count generated events, and the members consume them
+as they arrive. When every group has all of them, the demo logs what each
+member read: every group received every message, like subscriptions of a
+Pub/Sub topic, while within a group each partition, and so each message,
+went to one member only.
+
+It exits non-zero if a group misses messages within --timeout or two
+members of a group read the same message. The events are spread round
+robin, so every partition gets some; members beyond the partition count
+get none, which the summary shows as well.`,
+		Example: "  kafka-hwsw demo fanout --mode memory --seed 42\n" +
+			"  kafka-hwsw demo fanout --mode memory --groups 2 --members 4 --count 60\n" +
+			"  MEMORY_PARTITIONS=2 kafka-hwsw demo fanout --mode memory --members 3  # one member idles",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runFanoutDemo(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to produce to and consume from")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.groupPrefix, "group", "g", "demo-group", "prefix of the consumer groups, which are named <prefix>-1, <prefix>-2 and so on")
+	bindEnv(flags, "group", "DEMO_GROUP_ID")
+	flags.IntVar(&o.groups, "groups", 3, "number of consumer groups")
+	bindEnv(flags, "groups", "DEMO_GROUPS")
+	flags.IntVar(&o.members, "members", 2, "number of members of each group")
+	bindEnv(flags, "members", "DEMO_MEMBERS")
+	flags.IntVarP(&o.count, "count", "n", 20, "number of events to send")
+	bindEnv(flags, "count", "MESSAGE_COUNT")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerRoundRobin, "partitioner: hash, murmur2, roundrobin, random or manual; roundrobin gives every member messages")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.IntVar(&o.intervalMS, "interval-ms", 10, "delay between messages in milliseconds, 0 sends as fast as possible")
+	bindEnv(flags, "interval-ms", "MESSAGE_INTERVAL_MS")
+	flags.DurationVar(&o.timeout, "timeout", 30*time.Second, "how long to wait for the groups to assign their partitions and receive the events")
+	bindEnv(flags, "timeout", "DEMO_TIMEOUT")
+	o.events.addFlags(cmd)
+
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom, kafka.PartitionerManual)
+	return cmd
+}
+
+// fanoutDelivery is a message read by a member of a group, by its index.
+type fanoutDelivery struct {
+	group, member int
+	partition     int32
+	offset        int64
+}
+
+func runFanoutDemo(o fanoutOptions) {
+	if o.groups < 1 || o.members < 1 || o.count < 1 {
+		logging.Fatal("Invalid fan-out demo", "groups", o.groups, "members", o.members, "count", o.count)
+	}
+	gen, err := o.events.newGenerator()
+	if err != nil {
+		logging.Fatal("Invalid event generator settings", "error", err)
+	}
+	settings := []any{
+		"mode", mode,
+		"topic", o.topic,
+		"groups", o.groups,
+		"members", o.members,
+		"message_count", o.count,
+		"partitioner", o.partitioner,
+		"seed", gen.Seed(),
+	}
+	if mode == modeKafka {
+		settings = append(settings, "brokers", brokers)
+	}
+	settings = append(settings, o.events.settings()...)
+	slog.Info("Starting fan-out demo", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+	demoCtx, stop := context.WithTimeout(ctx, o.timeout)
+	defer stop()
+
+	var (
+		mu        sync.Mutex
+		sent      = make(map[int32]map[int64]bool)
+		sentAll   bool
+		delivered []fanoutDelivery
+		// received holds the offsets each group has read, by partition.
+		received = make([]map[int32]map[int64]bool, o.groups)
+	)
+	// complete reports whether every group has read every sent message.
+	complete := func() bool {
+		if !sentAll {
+			return false
+		}
+		for _, group := range received {
+			for partition, offsets := range sent {
+				for offset := range offsets {
+					if !group[partition][offset] {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+
+	groupIDs := make([]string, o.groups)
+	histories := make([][]*kafka.RebalanceHistory, o.groups)
+	var consumers sync.WaitGroup
+	for g := range groupIDs {
+		groupIDs[g] = fmt.Sprintf("%s-%d", o.groupPrefix, g+1)
+		received[g] = make(map[int32]map[int64]bool)
+		for m := 0; m < o.members; m++ {
+			g, m := g, m
+			collect := kafka.HandlerFunc(func(_ context.Context, message *kafka.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				delivered = append(delivered, fanoutDelivery{g, m, message.Partition, message.Offset})
+				if received[g][message.Partition] == nil {
+					received[g][message.Partition] = make(map[int64]bool)
+				}
+				received[g][message.Partition][message.Offset] = true
+				if complete() {
+					stop()
+				}
+				return nil
+			})
+			history := kafka.NewRebalanceHistory()
+			histories[g] = append(histories[g], history)
+			consumer, err := kafka.NewConsumer(brokers, o.topic, groupIDs[g], append(clientOptions(),
+				kafka.WithHandler(collect),
+				kafka.WithStartFromBeginning(),
+				kafka.WithRebalanceHistory(history),
+				kafka.WithoutMessageLog(),
+			)...)
+			if err != nil {
+				logging.Fatal("Failed to create consumer", "group", groupIDs[g], "member", m+1, "error", err)
+			}
+			defer consumer.Close()
+
+			consumers.Add(1)
+			go func() {
+				defer consumers.Done()
+				if err := consumer.Consume(demoCtx); err != nil {
+					logging.Fatal("Error consuming messages", "group", groupIDs[g], "member", m+1, "error", err)
+				}
+			}()
+		}
+	}
+
+	slog.Info("Waiting for the groups to assign their partitions", "groups", groupIDs)
+	assignments := waitForGroups(demoCtx, histories)
+	if demoCtx.Err() != nil {
+		consumers.Wait()
+		if ctx.Err() != nil {
+			slog.Info("Demo stopped")
+			return
+		}
+		logging.Fatal("The groups didn't assign their partitions in time", "timeout", o.timeout)
+	}
+	for g, members := range assignments {
+		for m, partitions := range members {
+			slog.Info("Member assigned", "group", groupIDs[g], "member", m+1, "partitions", partitions)
+		}
+	}
+
+	producer, err := kafka.NewProducer(brokers, o.topic, append(clientOptions(), kafka.WithPartitioner(o.partitioner))...)
+	if err != nil {
+		logging.Fatal("Failed to create producer", "error", err)
+	}
+	for i, event := range gen.Generate(o.count) {
+		if i > 0 && o.intervalMS > 0 {
+			select {
+			case <-time.After(time.Duration(o.intervalMS) * time.Millisecond):
+			case <-demoCtx.Done():
+			}
+		}
+		if demoCtx.Err() != nil {
+			break
+		}
+		d := producer.Send(event, nil)
+		if d.Err != nil {
+			logging.Fatal("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+		}
+		slog.Info("Message sent", "topic", o.topic, "partition", d.Partition, "offset", d.Offset, "key", d.Key)
+		mu.Lock()
+		if sent[d.Partition] == nil {
+			sent[d.Partition] = make(map[int64]bool)
+		}
+		sent[d.Partition][d.Offset] = true
+		mu.Unlock()
+	}
+	if err := producer.Close(); err != nil {
+		slog.Error("Failed to close producer", "error", err)
+	}
+	mu.Lock()
+	sentAll = true
+	if complete() {
+		stop()
+	}
+	mu.Unlock()
+
+	consumers.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if ctx.Err() != nil {
+		slog.Info("Demo stopped")
+		return
+	}
+
+	// Count what every member read of the messages sent here, whatever else
+	// the topic holds.
+	type memberStats struct {
+		messages   int
+		partitions []int32
+	}
+	stats := make([][]memberStats, o.groups)
+	readBy := make([]map[int32]map[int64]int, o.groups)
+	for g := range stats {
+		stats[g] = make([]memberStats, o.members)
+		readBy[g] = make(map[int32]map[int64]int)
+	}
+	duplicates := 0
+	for _, d := range delivered {
+		if !sent[d.partition][d.offset] {
+			continue
+		}
+		if readBy[d.group][d.partition] == nil {
+			readBy[d.group][d.partition] = make(map[int64]int)
+		}
+		if m, ok := readBy[d.group][d.partition][d.offset]; ok {
+			if m != d.member {
+				slog.Error("Message read by two members of a group", "group", groupIDs[d.group], "partition", d.partition,
+					"offset", d.offset, "members", []int{m + 1, d.member + 1})
+				duplicates++
+			}
+			continue
+		}
+		readBy[d.group][d.partition][d.offset] = d.member
+		s := &stats[d.group][d.member]
+		s.messages++
+		if !slices.Contains(s.partitions, d.partition) {
+			s.partitions = append(s.partitions, d.partition)
+		}
+	}
+
+	missing := 0
+	for g, members := range stats {
+		total := 0
+		for m, s := range members {
+			slices.Sort(s.partitions)
+			slog.Info("Member summary", "group", groupIDs[g], "member", m+1, "messages", s.messages, "partitions", s.partitions)
+			total += s.messages
+		}
+		if total < o.count {
+			slog.Error("Group missed messages", "group", groupIDs[g], "received", total, "sent", o.count)
+			missing += o.count - total
+		} else {
+			slog.Info("Group received every message", "group", groupIDs[g], "messages", total, "members", o.members)
+		}
+	}
+	if missing > 0 || duplicates > 0 {
+		logging.Fatal("Fan-out demo failed", "missing", missing, "read_twice", duplicates, "timeout", o.timeout)
+	}
+	slog.Info("Every group received every message, and within each group every message went to one member",
+		"groups", o.groups, "members", o.members, "messages", o.count)
+}
+
+// waitForGroups waits until every member of every group has set up a
+// session of the same generation as the other members of its group, and
+// returns the partitions each member was assigned then. It returns early,
+// with whatever it has, once ctx is done.
+func waitForGroups(ctx context.Context, histories [][]*kafka.RebalanceHistory) [][][]int32 {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		assignments := make([][][]int32, len(histories))
+		stable := true
+		for g, members := range histories {
+			generation := int32(-1)
+			for _, history := range members {
+				events := history.Events()
+				if len(events) == 0 || events[len(events)-1].Phase != kafka.RebalanceSetup {
+					stable = false
+					assignments[g] = append(assignments[g], nil)
+					continue
+				}
+				last := events[len(events)-1]
+				if generation != -1 && last.GenerationID != generation {
+					stable = false
+				}
+				generation = last.GenerationID
+				var partitions []int32
+				for _, claimed := range last.Claims {
+					partitions = append(partitions, claimed...)
+				}
+				slices.Sort(partitions)
+				assignments[g] = append(assignments[g], partitions)
+			}
+		}
+		if stable {
+			return assignments
+		}
+		select {
+		case <-ctx.Done():
+			return assignments
+		case <-ticker.C:
+		}
+	}
+}
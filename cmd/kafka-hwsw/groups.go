@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// newAdminGroupsCommand returns the admin subcommands for consumer groups.
+// topic is the admin command's --topic.
+func newAdminGroupsCommand(topic *string) *cobra.Command {
+	var (
+		group      string
+		to         string
+		partitions string
+		execute    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "groups",
+		Short: "List, describe and delete consumer groups and reset their offsets",
+	}
+	cmd.PersistentFlags().StringVarP(&group, "group", "g", "", "consumer group ID")
+	bindEnv(cmd.PersistentFlags(), "group", "KAFKA_GROUP_ID")
+
+	needsGroup := func(cmd *cobra.Command, args []string) error {
+		if group == "" {
+			return errors.New("--group is required")
+		}
+		return nil
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List consumer groups with their state and member count",
+		Args:  cobra.NoArgs,
+		Run: withGroupAdmin("groups list", func(admin *kafka.GroupAdmin) error {
+			return listGroups(admin)
+		}),
+	}
+
+	describe := &cobra.Command{
+		Use:     "describe",
+		Short:   "Show a group's members, their assignments and the group's offsets and lag",
+		Args:    cobra.NoArgs,
+		PreRunE: needsGroup,
+		Run: withGroupAdmin("groups describe", func(admin *kafka.GroupAdmin) error {
+			return describeGroup(admin, group)
+		}),
+	}
+
+	reset := &cobra.Command{
+		Use:   "reset-offsets",
+		Short: "Move a group's committed offsets on a topic",
+		Long: `Move a group's committed offsets on a topic to the oldest or newest
+offset, an absolute offset or the first message at or after an RFC3339
+timestamp. Without --execute the new offsets are only shown. The group must
+have no active members, since they would overwrite the offsets with their
+own commits.`,
+		Example: "  kafka-hwsw admin groups reset-offsets -g test-consumer-group -t test-topic --to earliest\n" +
+			"  kafka-hwsw admin groups reset-offsets -g test-consumer-group -t test-topic --to 2024-05-01T10:00:00Z --execute\n" +
+			"  kafka-hwsw admin groups reset-offsets -g test-consumer-group -t test-topic --partitions 0,2 --to 1500 --execute",
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := needsGroup(cmd, args); err != nil {
+				return err
+			}
+			if *topic == "" {
+				return errors.New("--topic is required")
+			}
+			return nil
+		},
+		Run: withGroupAdmin("groups reset-offsets", func(admin *kafka.GroupAdmin) error {
+			return resetOffsets(admin, group, *topic, partitions, to, execute)
+		}),
+	}
+	reset.Flags().StringVar(&to, "to", "", "earliest, latest, an offset or an RFC3339 timestamp")
+	reset.Flags().StringVar(&partitions, "partitions", "", "comma-separated partitions to reset, e.g. 0,2; all if empty")
+	reset.Flags().BoolVar(&execute, "execute", false, "commit the new offsets instead of only showing them")
+	reset.MarkFlagRequired("to")
+
+	del := &cobra.Command{
+		Use:     "delete",
+		Short:   "Delete a group and its committed offsets",
+		Args:    cobra.NoArgs,
+		PreRunE: needsGroup,
+		Run: withGroupAdmin("groups delete", func(admin *kafka.GroupAdmin) error {
+			if err := admin.DeleteGroup(group); err != nil {
+				return err
+			}
+			slog.Info("Deleted consumer group", "group", group)
+			return nil
+		}),
+	}
+
+	cmd.AddCommand(list, describe, reset, del)
+	return cmd
+}
+
+// withGroupAdmin connects a group admin for the duration of fn.
+func withGroupAdmin(command string, fn func(admin *kafka.GroupAdmin) error) func(*cobra.Command, []string) {
+	return func(*cobra.Command, []string) {
+		admin, err := kafka.NewGroupAdmin(brokers, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to connect", "error", err)
+		}
+		defer admin.Close()
+
+		if err := fn(admin); err != nil {
+			logging.Fatal("Command failed", "command", command, "error", err)
+		}
+	}
+}
+
+func listGroups(admin *kafka.GroupAdmin) error {
+	groups, err := admin.ListGroups()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "GROUP\tSTATE\tMEMBERS\tPROTOCOL-TYPE\n")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", g.GroupID, g.State, g.Members, g.ProtocolType)
+	}
+	return w.Flush()
+}
+
+func describeGroup(admin *kafka.GroupAdmin, group string) error {
+	description, err := admin.DescribeGroup(group)
+	if err != nil {
+		return err
+	}
+	lags, err := admin.Lag(group)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Group: %s\tState: %s\tAssignor: %s\tMembers: %d\n\n",
+		group, description.State, description.Protocol, len(description.Members))
+
+	if len(description.Members) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "MEMBER\tINSTANCE\tCLIENT\tHOST\tASSIGNMENT\n")
+		for _, m := range description.Members {
+			instance := m.InstanceID
+			if instance == "" {
+				instance = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.MemberID, instance, m.ClientID, m.Host, formatAssignment(m.Assignment))
+		}
+		w.Flush()
+		fmt.Println()
+	}
+
+	if len(lags) == 0 {
+		fmt.Printf("Group %s has no committed offsets\n", group)
+		return nil
+	}
+	printLag(group, lags)
+	return nil
+}
+
+// formatAssignment formats an assignment as "orders[0,2] payments[1]".
+func formatAssignment(assignment map[string][]int32) string {
+	if len(assignment) == 0 {
+		return "-"
+	}
+	topics := make([]string, 0, len(assignment))
+	for topic := range assignment {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	parts := make([]string, len(topics))
+	for i, topic := range topics {
+		partitions := append([]int32(nil), assignment[topic]...)
+		sort.Slice(partitions, func(a, b int) bool { return partitions[a] < partitions[b] })
+		parts[i] = topic + "[" + joinIDs(partitions) + "]"
+	}
+	return strings.Join(parts, " ")
+}
+
+func resetOffsets(admin *kafka.GroupAdmin, group, topic, partitionList, to string, execute bool) error {
+	position, err := kafka.ParsePosition(to)
+	if err != nil {
+		return err
+	}
+	partitions, err := kafka.ParsePartitions(partitionList)
+	if err != nil {
+		return err
+	}
+
+	changes, err := admin.PlanReset(group, topic, partitions, position)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "GROUP\tTOPIC\tPARTITION\tCURRENT\tNEW\t\n")
+	for _, c := range changes {
+		current := "-"
+		if c.Current >= 0 {
+			current = strconv.FormatInt(c.Current, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t\n", group, c.Topic, c.Partition, current, c.New)
+	}
+	w.Flush()
+
+	if !execute {
+		fmt.Println("\nNothing changed, run again with --execute to commit the new offsets.")
+		return nil
+	}
+	if err := admin.ApplyReset(group, changes); err != nil {
+		return err
+	}
+	slog.Info("Reset consumer group offsets", "group", group, "topic", topic, "partitions", len(changes), "to", to)
+	return nil
+}
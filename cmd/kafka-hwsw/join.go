@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Join types: a left join forwards events without a profile as they are,
+// an inner join drops them.
+const (
+	joinLeft  = "left"
+	joinInner = "inner"
+)
+
+type joinOptions struct {
+	topic           string
+	profilesTopic   string
+	outputTopic     string
+	groupID         string
+	transactionalID string
+	delivery        string
+	joinType        string
+	profileWait     time.Duration
+	registryURL     string
+	resilience      resilienceOptions
+}
+
+func newJoinCommand() *cobra.Command {
+	var o joinOptions
+
+	cmd := &cobra.Command{
+		Use:   "join",
+		Short: "Enrich events with the user profiles of a compacted topic",
+		Long: `Join a stream with a table: keep the compacted profiles topic in memory,
+keyed by user ID, and produce every event of the input topic to the output
+topic with its user's latest profile under data.profile. The profiles are
+restored from the topic before the first event is joined, and updates are
+applied as they arrive, so each event gets the profile as it was when the
+event was processed.
+
+A profile may arrive after the first events of its user. An event whose
+user has no profile yet waits up to --profile-wait for it. If none arrives,
+a left join forwards the event without a profile and an inner join drops
+it; later events of that user don't wait again until their profile shows
+up. Waiting holds up the event's partition.
+
+--delivery works like the pipeline's.`,
+		Example: "  kafka-hwsw join -t user-events --profiles-topic user-profiles --output-topic enriched-events\n" +
+			"  kafka-hwsw join --join-type inner --profile-wait 5s --delivery at-least-once",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runJoin(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic of the events to enrich")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.profilesTopic, "profiles-topic", "user-profiles", "compacted topic of user profiles keyed by user ID")
+	bindEnv(flags, "profiles-topic", "JOIN_PROFILES_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "enriched-events", "topic the enriched events are produced to")
+	bindEnv(flags, "output-topic", "JOIN_OUTPUT_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "event-join", "consumer group ID")
+	bindEnv(flags, "group", "JOIN_GROUP_ID")
+	flags.StringVar(&o.transactionalID, "transactional-id", "event-join", "transactional ID for exactly-once delivery, unique per instance")
+	bindEnv(flags, "transactional-id", "JOIN_TRANSACTIONAL_ID")
+	flags.StringVar(&o.delivery, "delivery", deliveryExactlyOnce, "exactly-once, at-least-once or at-most-once")
+	bindEnv(flags, "delivery", "JOIN_DELIVERY")
+	flags.StringVar(&o.joinType, "join-type", joinLeft, "left forwards events without a profile, inner drops them")
+	bindEnv(flags, "join-type", "JOIN_TYPE")
+	flags.DurationVar(&o.profileWait, "profile-wait", 2*time.Second, "how long an event waits for a profile that hasn't arrived yet, 0 doesn't wait")
+	bindEnv(flags, "profile-wait", "JOIN_PROFILE_WAIT")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro events")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	o.resilience.addFlags(cmd)
+
+	completeValues(cmd, "delivery", deliveryExactlyOnce, kafka.DeliveryAtLeastOnce, kafka.DeliveryAtMostOnce)
+	completeValues(cmd, "join-type", joinLeft, joinInner)
+	return cmd
+}
+
+func runJoin(o joinOptions) {
+	if o.joinType != joinLeft && o.joinType != joinInner {
+		logging.Fatal("Unknown join type, want left or inner", "join_type", o.joinType)
+	}
+	transactionalID, semantics := pipelineDelivery(o.delivery, o.transactionalID)
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"profiles_topic", o.profilesTopic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"delivery", o.delivery,
+	}
+	if transactionalID != "" {
+		settings = append(settings, "transactional_id", transactionalID)
+	}
+	settings = append(settings, "join_type", o.joinType, "profile_wait", o.profileWait, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Stream-Table Join", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	var profiles *kafka.Table
+	o.resilience.connect(ctx, "profile table", func() (err error) {
+		profiles, err = kafka.NewTable(brokers, o.profilesTopic, clientOptions()...)
+		return err
+	})
+	defer profiles.Close()
+
+	go func() {
+		if err := profiles.Run(ctx); err != nil {
+			logging.Fatal("Error materializing profiles", "topic", o.profilesTopic, "error", err)
+		}
+	}()
+	if err := profiles.WaitRestored(ctx); err != nil {
+		slog.Info("Join stopped")
+		return
+	}
+
+	opts := append(clientOptions(), kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...))
+	opts = append(opts, semantics...)
+	opts = append(opts, o.resilience.options()...)
+
+	join := &profileJoin{
+		profiles: profiles,
+		inner:    o.joinType == joinInner,
+		wait:     o.profileWait,
+		missing:  make(map[string]bool),
+	}
+	o.resilience.connect(ctx, "pipeline", func() (err error) {
+		join.pipeline, err = kafka.NewPipeline(brokers, o.topic, o.outputTopic, o.groupID, transactionalID, join.transform, opts...)
+		return err
+	})
+	defer join.pipeline.Close()
+
+	if err := join.pipeline.Consume(ctx); err != nil {
+		logging.Fatal("Error running join", "error", err)
+	}
+
+	join.logSummary()
+	slog.Info("Join stopped")
+}
+
+// profileJoin enriches the events of a pipeline with the profiles of a
+// table. Claims call transform concurrently.
+type profileJoin struct {
+	pipeline *kafka.Pipeline
+	profiles *kafka.Table
+	inner    bool
+	wait     time.Duration
+
+	mu sync.Mutex
+	// missing holds the users whose profile didn't arrive within the wait,
+	// so that their next events don't wait again.
+	missing                 map[string]bool
+	matched, late, unjoined int
+}
+
+func (j *profileJoin) transform(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+	event, err := j.pipeline.DecodeEvent(message)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, ok := j.profiles.Get(event.UserID)
+	late := false
+	if !ok && j.wait > 0 && !j.isMissing(event.UserID) {
+		profile, ok = j.profiles.Await(ctx, event.UserID, j.wait)
+		late = ok
+		if late {
+			slog.Info("Profile arrived late", "user_id", event.UserID, "topic", message.Topic,
+				"partition", message.Partition, "offset", message.Offset)
+		}
+	}
+	j.record(event.UserID, ok, late)
+
+	if ok {
+		var fields any
+		if err := json.Unmarshal(profile.Value, &fields); err != nil {
+			return nil, err
+		}
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		event.Data["profile"] = fields
+	} else if j.inner {
+		slog.Debug("Event without a profile dropped", "user_id", event.UserID, "topic", message.Topic,
+			"partition", message.Partition, "offset", message.Offset)
+		return nil, nil
+	}
+
+	serializer := kafka.JSONSerializer{}
+	value, err := serializer.Serialize(event)
+	if err != nil {
+		return nil, err
+	}
+	extra := make(map[string]string)
+	if traceID, ok := kafka.MessageHeaders(message)[kafka.TraceIDHeader]; ok {
+		extra[kafka.TraceIDHeader] = traceID
+	}
+	return []*sarama.ProducerMessage{{
+		Key:     sarama.StringEncoder(event.UserID),
+		Value:   sarama.ByteEncoder(value),
+		Headers: kafka.EventHeaders(serializer, event, extra),
+	}}, nil
+}
+
+func (j *profileJoin) isMissing(userID string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.missing[userID]
+}
+
+// record counts the outcome of one event and remembers whether its user
+// has a profile.
+func (j *profileJoin) record(userID string, matched, late bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case late:
+		j.late++
+	case matched:
+		j.matched++
+	default:
+		j.unjoined++
+	}
+	if matched {
+		delete(j.missing, userID)
+	} else {
+		j.missing[userID] = true
+	}
+}
+
+func (j *profileJoin) logSummary() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	slog.Info("Join summary", "matched", j.matched, "matched_late", j.late, "without_profile", j.unjoined,
+		"users_without_profile", len(j.missing), "profiles", j.profiles.Len())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/metrics"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type lagOptions struct {
+	topic       string
+	groupID     string
+	intervalMS  int
+	metricsPort int
+}
+
+func newLagCommand() *cobra.Command {
+	var o lagOptions
+
+	cmd := &cobra.Command{
+		Use:   "lag",
+		Short: "Print a consumer group's lag per partition periodically",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runLag(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to report lag for")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "test-consumer-group", "consumer group to report lag for")
+	bindEnv(flags, "group", "KAFKA_GROUP_ID")
+	flags.IntVar(&o.intervalMS, "interval-ms", 5000, "milliseconds between reports")
+	bindEnv(flags, "interval-ms", "LAG_INTERVAL_MS")
+	flags.IntVar(&o.metricsPort, "metrics-port", 0, "serve the lag as Prometheus metrics on this port")
+	bindEnv(flags, "metrics-port", "METRICS_PORT")
+	return cmd
+}
+
+func runLag(o lagOptions) {
+	interval := time.Duration(o.intervalMS) * time.Millisecond
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"group", o.groupID,
+		"interval", interval,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	slog.Info("Starting Kafka Consumer Lag Monitor", settings...)
+
+	opts := clientOptions()
+
+	var m *metrics.Metrics
+	if o.metricsPort > 0 {
+		m = metrics.New()
+		server := m.Serve(o.metricsPort)
+		defer server.Close()
+		opts = append(opts, kafka.WithConfigFunc(m.ConfigureSarama))
+		slog.Info("Metrics available", "url", fmt.Sprintf("http://localhost:%d/metrics", o.metricsPort))
+	}
+
+	monitor, err := kafka.NewLagMonitor(brokers, o.groupID, o.topic, opts...)
+	if err != nil {
+		logging.Fatal("Failed to create lag monitor", "error", err)
+	}
+	defer monitor.Close()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		lags, err := monitor.Lag()
+		if err != nil {
+			slog.Error("Failed to compute lag", "topic", o.topic, "group", o.groupID, "error", err)
+		} else {
+			printLag(o.groupID, lags)
+			if m != nil {
+				for _, lag := range lags {
+					m.GroupLag(o.groupID, lag.Topic, lag.Partition, lag.Lag)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigChan:
+			slog.Info("Received shutdown signal, stopping lag monitor...")
+			return
+		}
+	}
+}
+
+func printLag(groupID string, lags []kafka.PartitionLag) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "GROUP\tTOPIC\tPARTITION\tCOMMITTED\tLOG-END\tLAG\t\n")
+
+	var total int64
+	for _, lag := range lags {
+		committed := "-"
+		if lag.Committed >= 0 {
+			committed = strconv.FormatInt(lag.Committed, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t\n", groupID, lag.Topic, lag.Partition, committed, lag.LogEnd, lag.Lag)
+		total += lag.Lag
+	}
+	fmt.Fprintf(w, "\t\t\t\tTOTAL\t%d\t\n", total)
+	w.Flush()
+	fmt.Println()
+}
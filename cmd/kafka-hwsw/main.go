@@ -0,0 +1,350 @@
+// Command kafka-hwsw produces, consumes and administers the user-events demo
+// topics. Every flag falls back to an environment variable, which can also
+// come from .env or config.yaml, so existing env-based setups keep working.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"kafka-hwsw/internal/auth"
+	"kafka-hwsw/internal/chaos"
+	"kafka-hwsw/internal/config"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Settings shared by every subcommand.
+var (
+	configFile string
+	logLevel   string
+	logFormat  string
+	brokers    []string
+	tlsConfig  config.TLS
+	saslConfig auth.SASL
+
+	encryptionKeys  string
+	dataKeyMessages int
+	dataKeyLifetime time.Duration
+	localKeys       *kafka.LocalKeys
+
+	zstdDictionaries []string
+	dictionaries     [][]byte
+
+	largeMessages     string
+	largeMessageBytes int
+	claimCheckDir     string
+
+	mode             string
+	clientLibrary    string
+	memoryPartitions int
+	memoryBroker     *kafka.MemoryBroker
+	// backend is what --mode and --client picked every command to connect
+	// with.
+	backend kafka.Backend = kafka.SaramaBackend{}
+
+	chaosConfig   chaos.Config
+	chaosInjector *chaos.Injector
+)
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "kafka-hwsw",
+		Short: "Kafka partition routing demo: produce, consume, administer and monitor topics",
+		Long: `kafka-hwsw produces and consumes user events to show how Kafka routes keys
+to partitions, and ships the topic admin and consumer lag tools alongside.
+
+Every flag can also be set through the environment variable shown in its
+help, through .env, or through config.yaml (see config.example.yaml). Flags
+win over the environment, which wins over the config file.`,
+		SilenceUsage:      true,
+		PersistentPreRunE: setup,
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&configFile, "config", "", "YAML config file (default config.yaml if present)")
+	bindEnv(flags, "config", "CONFIG_FILE")
+	flags.StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	bindEnv(flags, "log-level", "LOG_LEVEL")
+	flags.StringVar(&logFormat, "log-format", logging.FormatText, "log format: text or json")
+	bindEnv(flags, "log-format", "LOG_FORMAT")
+	flags.StringSliceVar(&brokers, "brokers", []string{"localhost:9092", "localhost:9094", "localhost:9096"}, "Kafka broker addresses")
+	bindEnv(flags, "brokers", "KAFKA_BROKERS")
+	flags.StringVar(&mode, "mode", modeKafka, "kafka, or memory for an in-process broker that needs no cluster (produce, consume, demo, demo fanout, autoscale and dictionary only)")
+	bindEnv(flags, "mode", "KAFKA_MODE")
+	flags.StringVar(&clientLibrary, "client", clientSarama, "client library of --mode kafka: sarama, or franz-go for producers, consumers and processors; transactions, async producing and the admin, groups, replicas, watermarks, shell and rest-proxy commands need sarama")
+	bindEnv(flags, "client", "KAFKA_CLIENT")
+	flags.IntVar(&memoryPartitions, "memory-partitions", 3, "partitions of the topics of --mode memory")
+	bindEnv(flags, "memory-partitions", "MEMORY_PARTITIONS")
+
+	flags.BoolVar(&tlsConfig.Enabled, "tls", false, "connect to the brokers over TLS")
+	bindEnv(flags, "tls", "KAFKA_TLS_ENABLED")
+	flags.StringVar(&tlsConfig.CAFile, "tls-ca-file", "", "CA certificate used to verify the brokers")
+	bindEnv(flags, "tls-ca-file", "KAFKA_TLS_CA_FILE")
+	flags.StringVar(&tlsConfig.CertFile, "tls-cert-file", "", "client certificate for mutual TLS")
+	bindEnv(flags, "tls-cert-file", "KAFKA_TLS_CERT_FILE")
+	flags.StringVar(&tlsConfig.KeyFile, "tls-key-file", "", "client key for mutual TLS")
+	bindEnv(flags, "tls-key-file", "KAFKA_TLS_KEY_FILE")
+	flags.BoolVar(&tlsConfig.InsecureSkipVerify, "tls-insecure-skip-verify", false, "skip broker certificate verification")
+	bindEnv(flags, "tls-insecure-skip-verify", "KAFKA_TLS_INSECURE_SKIP_VERIFY")
+
+	flags.StringVar(&saslConfig.Mechanism, "sasl-mechanism", "", "SASL mechanism: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+	bindEnv(flags, "sasl-mechanism", "KAFKA_SASL_MECHANISM")
+	flags.StringVar(&saslConfig.Username, "sasl-username", "", "SASL username")
+	bindEnv(flags, "sasl-username", "KAFKA_SASL_USERNAME")
+	flags.StringVar(&saslConfig.Password, "sasl-password", "", "SASL password")
+	bindEnv(flags, "sasl-password", "KAFKA_SASL_PASSWORD")
+
+	flags.StringVar(&encryptionKeys, "encryption-keys", "", "encrypt message values end to end with these id=base64-key AES-256 keys, the first one for new messages")
+	bindEnv(flags, "encryption-keys", "ENCRYPTION_KEYS")
+	flags.IntVar(&dataKeyMessages, "encryption-data-key-messages", kafka.DefaultDataKeyMessages, "messages a producer encrypts with one data key before it switches to a new one")
+	bindEnv(flags, "encryption-data-key-messages", "ENCRYPTION_DATA_KEY_MESSAGES")
+	flags.DurationVar(&dataKeyLifetime, "encryption-data-key-lifetime", kafka.DefaultDataKeyLifetime, "how long a producer encrypts with one data key before it switches to a new one")
+	bindEnv(flags, "encryption-data-key-lifetime", "ENCRYPTION_DATA_KEY_LIFETIME")
+	flags.StringSliceVar(&zstdDictionaries, "zstd-dictionary", nil, "compress message values with these zstd dictionary files, the first one for new messages; train one with the dictionary command")
+	bindEnv(flags, "zstd-dictionary", "ZSTD_DICTIONARY")
+
+	flags.StringVar(&largeMessages, "large-messages", kafka.LargeMessageNone, "how to send values over --large-message-bytes: none, chunk or claim-check")
+	bindEnv(flags, "large-messages", "LARGE_MESSAGE_STRATEGY")
+	flags.IntVar(&largeMessageBytes, "large-message-bytes", kafka.DefaultMaxValueBytes, "largest value sent as a single record, keep it below the topic's max.message.bytes")
+	bindEnv(flags, "large-message-bytes", "LARGE_MESSAGE_BYTES")
+	flags.StringVar(&claimCheckDir, "claim-check-dir", "claim-checks", "directory the values of claim checks are written to and read from")
+	bindEnv(flags, "claim-check-dir", "CLAIM_CHECK_DIR")
+
+	flags.Float64Var(&chaosConfig.DropRate, "chaos-drop-rate", 0, "probability that a request drops its broker connection, e.g. 0.01")
+	bindEnv(flags, "chaos-drop-rate", "CHAOS_DROP_RATE")
+	flags.DurationVar(&chaosConfig.Latency, "chaos-latency", 0, "delay added to every broker response")
+	bindEnv(flags, "chaos-latency", "CHAOS_LATENCY")
+	flags.DurationVar(&chaosConfig.Jitter, "chaos-jitter", 0, "random extra delay of up to this much per response")
+	bindEnv(flags, "chaos-jitter", "CHAOS_JITTER")
+	flags.StringSliceVar(&chaosConfig.Blackhole, "chaos-blackhole", nil, "broker addresses that never answer, e.g. localhost:9094")
+	bindEnv(flags, "chaos-blackhole", "CHAOS_BLACKHOLE")
+	flags.DurationVar(&chaosConfig.BlackholeEvery, "chaos-blackhole-every", 0, "make a random broker stop answering this often, 0 disables it")
+	bindEnv(flags, "chaos-blackhole-every", "CHAOS_BLACKHOLE_EVERY")
+	flags.DurationVar(&chaosConfig.BlackholeFor, "chaos-blackhole-for", 30*time.Second, "how long a broker picked by --chaos-blackhole-every stays silent")
+	bindEnv(flags, "chaos-blackhole-for", "CHAOS_BLACKHOLE_FOR")
+
+	completeValues(root, "log-level", "debug", "info", "warn", "error")
+	completeValues(root, "log-format", logging.FormatText, logging.FormatJSON)
+	completeValues(root, "mode", modeKafka, modeMemory)
+	completeValues(root, "client", clientSarama, clientFranz)
+	completeValues(root, "large-messages", kafka.LargeMessageStrategies...)
+	completeValues(root, "sasl-mechanism", auth.MechanismPlain, auth.MechanismSCRAMSHA256, auth.MechanismSCRAMSHA512)
+
+	root.AddCommand(
+		newProduceCommand(),
+		newConsumeCommand(),
+		newDemoCommand(),
+		newCompactionCommand(),
+		newAdminCommand(),
+		newLagCommand(),
+		newAutoscaleCommand(),
+		newWatermarksCommand(),
+		newAggregateCommand(),
+		newWindowCommand(),
+		newSessionCommand(),
+		newPipelineCommand(),
+		newJoinCommand(),
+		newStreamJoinCommand(),
+		newSinkCommand(),
+		newTableCommand(),
+		newCacheCommand(),
+		newShellCommand(),
+		newRestProxyCommand(),
+		newReplayCommand(),
+		newMirrorCommand(),
+		newBridgeCommand(),
+		newSchedulerCommand(),
+		newOutboxCommand(),
+		newCompressionBenchCommand(),
+		newDictionaryCommand(),
+		newClusterCommand(),
+	)
+	return root
+}
+
+// setup runs before every subcommand: it loads .env and the config file into
+// the environment, fills in flags that weren't given from it and configures
+// logging.
+func setup(cmd *cobra.Command, args []string) error {
+	envErr := godotenv.Load()
+
+	// The config file path itself may come from .env, so it is resolved
+	// before the other flags.
+	if !cmd.Flags().Changed("config") {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if err := config.Load(configFile); err != nil {
+		return err
+	}
+	if err := applyEnv(cmd.Flags()); err != nil {
+		return err
+	}
+	if err := logging.Setup(logLevel, logFormat); err != nil {
+		return err
+	}
+
+	if envErr != nil {
+		slog.Info("No .env file found, using default values")
+	}
+	saslConfig.Mechanism = strings.ToUpper(strings.TrimSpace(saslConfig.Mechanism))
+
+	if encryptionKeys != "" {
+		var err error
+		if localKeys, err = kafka.ParseLocalKeys(encryptionKeys); err != nil {
+			return err
+		}
+	}
+
+	// The dictionary command writes the file the others read, so it doesn't
+	// need it to exist yet.
+	if cmd.Name() != "dictionary" {
+		for _, path := range zstdDictionaries {
+			dictionary, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read zstd dictionary: %w", err)
+			}
+			if _, err := kafka.DictionaryID(dictionary); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			dictionaries = append(dictionaries, dictionary)
+		}
+	}
+
+	if !slices.Contains(kafka.LargeMessageStrategies, largeMessages) {
+		return fmt.Errorf("invalid --large-messages %q: expected one of %s", largeMessages, strings.Join(kafka.LargeMessageStrategies, ", "))
+	}
+
+	if chaosConfig.Enabled() {
+		var err error
+		if chaosInjector, err = chaos.New(chaosConfig); err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case modeKafka:
+		switch clientLibrary {
+		case clientSarama:
+			backend = kafka.SaramaBackend{}
+		case clientFranz:
+			backend = kafka.FranzBackend{}
+		default:
+			return fmt.Errorf("invalid --client %q: expected %s or %s", clientLibrary, clientSarama, clientFranz)
+		}
+	case modeMemory:
+		if builtinCommand(cmd) {
+			break
+		}
+		if cmd.Annotations[memoryAnnotation] == "" {
+			return fmt.Errorf("%s needs a Kafka cluster, --mode memory only works with produce, consume, demo, autoscale and dictionary", cmd.CommandPath())
+		}
+		memoryBroker = kafka.NewMemoryBroker(int32(memoryPartitions))
+		backend = memoryBroker
+		slog.Info("Using the in-memory broker, nothing leaves this process", "partitions", memoryPartitions)
+	default:
+		return fmt.Errorf("invalid --mode %q: expected %s or %s", mode, modeKafka, modeMemory)
+	}
+	return nil
+}
+
+// Values of --mode.
+const (
+	modeKafka  = "kafka"
+	modeMemory = "memory"
+)
+
+// Values of --client.
+const (
+	clientSarama = "sarama"
+	clientFranz  = "franz-go"
+)
+
+// memoryAnnotation marks the commands that run against the in-memory
+// broker of --mode memory.
+const memoryAnnotation = "memory"
+
+// builtinCommand reports whether cmd is cobra's help or completion command,
+// which work in any mode.
+func builtinCommand(cmd *cobra.Command) bool {
+	for ; cmd.HasParent(); cmd = cmd.Parent() {
+		if cmd.Parent() == cmd.Root() && (cmd.Name() == "help" || cmd.Name() == "completion") {
+			return true
+		}
+	}
+	return false
+}
+
+// envAnnotation holds the environment variable a flag falls back to.
+const envAnnotation = "env"
+
+// bindEnv makes the flag fall back to the environment variable env when it
+// isn't given on the command line.
+func bindEnv(flags *pflag.FlagSet, name, env string) {
+	flags.SetAnnotation(name, envAnnotation, []string{env})
+	flag := flags.Lookup(name)
+	flag.Usage = fmt.Sprintf("%s (env %s)", flag.Usage, env)
+}
+
+func applyEnv(flags *pflag.FlagSet) error {
+	var errs []error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		env, ok := flag.Annotations[envAnnotation]
+		if !ok || flag.Changed {
+			return
+		}
+		value := os.Getenv(env[0])
+		if value == "" {
+			return
+		}
+		if err := flag.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", env[0], value, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// completeValues registers a fixed set of shell completions for a flag.
+func completeValues(cmd *cobra.Command, flag string, values ...string) {
+	cmd.RegisterFlagCompletionFunc(flag, func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// clientOptions returns the connection options shared by every command.
+func clientOptions() []kafka.Option {
+	opts := []kafka.Option{
+		kafka.WithConfigFunc(tlsConfig.Apply),
+		kafka.WithConfigFunc(saslConfig.Apply),
+	}
+	if chaosInjector != nil {
+		opts = append(opts, kafka.WithConfigFunc(chaosInjector.Apply))
+	}
+	opts = append(opts, kafka.WithBackend(backend))
+	if localKeys != nil {
+		opts = append(opts, kafka.WithEncryption(localKeys), kafka.WithDataKeyRotation(dataKeyMessages, dataKeyLifetime))
+	}
+	if len(dictionaries) > 0 {
+		opts = append(opts, kafka.WithDictionaryCompression(dictionaries...))
+	}
+	opts = append(opts,
+		kafka.WithLargeMessages(largeMessages, largeMessageBytes),
+		kafka.WithBlobStore(kafka.FileBlobStore{Dir: claimCheckDir}),
+	)
+	return opts
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type mirrorOptions struct {
+	topic           string
+	topics          []string
+	targetBrokers   []string
+	groupID         string
+	topicPrefix     string
+	keepPartitions  bool
+	createTopics    bool
+	partitioner     string
+	compression     string
+	shutdownTimeout time.Duration
+	resilience      resilienceOptions
+}
+
+func newMirrorCommand() *cobra.Command {
+	var o mirrorOptions
+
+	cmd := &cobra.Command{
+		Use:   "mirror",
+		Short: "Continuously copy topics from one cluster to another",
+		Long: `Consume topics from the cluster in --brokers and produce every message to
+the cluster in --target-brokers, with the same key, value, headers and
+timestamp, like a minimal MirrorMaker. Target topics are named like their
+source topics with --topic-prefix in front, and are created with the same
+partition count if they don't exist, so that with the same partitioner
+every key lands on the partition it has in the source. --keep-partitions
+keeps the partition numbers instead.
+
+Offsets are committed in the source cluster once the target has
+acknowledged a batch, so a restart copies the last batches again but never
+loses messages. Both clusters use the same TLS and SASL settings.`,
+		Example: "  kafka-hwsw mirror -t test-topic --target-brokers localhost:9192\n" +
+			"  kafka-hwsw mirror --topics orders,payments --target-brokers dr-1:9092,dr-2:9092 --topic-prefix primary.\n" +
+			"  kafka-hwsw mirror -t test-topic --target-brokers localhost:9092 --topic-prefix mirror.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runMirror(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to mirror")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringSliceVar(&o.topics, "topics", nil, "mirror several topics, e.g. orders,payments (overrides --topic)")
+	bindEnv(flags, "topics", "KAFKA_TOPICS")
+	flags.StringSliceVar(&o.targetBrokers, "target-brokers", nil, "broker addresses of the cluster to mirror to")
+	bindEnv(flags, "target-brokers", "MIRROR_TARGET_BROKERS")
+	flags.StringVarP(&o.groupID, "group", "g", "kafka-hwsw-mirror", "consumer group ID in the source cluster")
+	bindEnv(flags, "group", "MIRROR_GROUP_ID")
+	flags.StringVar(&o.topicPrefix, "topic-prefix", "", "prefix of the target topic names, e.g. primary.")
+	bindEnv(flags, "topic-prefix", "MIRROR_TOPIC_PREFIX")
+	flags.BoolVar(&o.keepPartitions, "keep-partitions", false, "send every message to the partition number it came from instead of partitioning by key")
+	bindEnv(flags, "keep-partitions", "MIRROR_KEEP_PARTITIONS")
+	flags.BoolVar(&o.createTopics, "create-topics", true, "create missing target topics with the partition count of their source")
+	bindEnv(flags, "create-topics", "MIRROR_CREATE_TOPICS")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerHash, "partitioner for the target topics: hash, murmur2, roundrobin or random")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.StringVar(&o.compression, "compression", "snappy", "compression codec: none, gzip, snappy, lz4 or zstd")
+	bindEnv(flags, "compression", "KAFKA_COMPRESSION")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long the batches being sent may take on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+	o.resilience.addFlags(cmd)
+
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom)
+	completeValues(cmd, "compression", kafka.CompressionCodecs...)
+	return cmd
+}
+
+func runMirror(o mirrorOptions) {
+	topics := o.topics
+	if len(topics) == 0 {
+		topics = []string{o.topic}
+	}
+	if len(o.targetBrokers) == 0 {
+		logging.Fatal("--target-brokers is required")
+	}
+	if o.topicPrefix == "" && sameBrokers(brokers, o.targetBrokers) {
+		logging.Fatal("Mirroring a cluster into itself needs --topic-prefix, otherwise every message is copied forever")
+	}
+	if o.partitioner == kafka.PartitionerManual {
+		logging.Fatal("--partitioner manual isn't supported, use --keep-partitions to keep the partitions")
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"target_brokers", o.targetBrokers,
+		"topics", topics,
+		"group", o.groupID,
+	}
+	if o.topicPrefix != "" {
+		settings = append(settings, "topic_prefix", o.topicPrefix)
+	}
+	settings = append(settings, "keep_partitions", o.keepPartitions)
+	if !o.keepPartitions {
+		settings = append(settings, "partitioner", o.partitioner)
+	}
+	settings = append(settings, "create_topics", o.createTopics, "compression", o.compression, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Mirror", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithPartitioner(o.partitioner),
+		kafka.WithCompression(o.compression),
+		kafka.WithShutdownTimeout(o.shutdownTimeout),
+	)
+	opts = append(opts, o.resilience.options()...)
+
+	var mirror *kafka.Mirror
+	o.resilience.connect(ctx, "mirror", func() (err error) {
+		mirror, err = kafka.NewMirror(brokers, o.targetBrokers, o.groupID, kafka.MirrorConfig{
+			Topics:         topics,
+			TopicPrefix:    o.topicPrefix,
+			KeepPartitions: o.keepPartitions,
+			CreateTopics:   o.createTopics,
+		}, opts...)
+		return err
+	})
+	defer mirror.Close()
+
+	if err := mirror.Consume(ctx); err != nil {
+		logging.Fatal("Error mirroring messages", "error", err)
+	}
+
+	slog.Info("Mirror stopped")
+}
+
+// sameBrokers reports whether two broker lists name the same addresses.
+func sameBrokers(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
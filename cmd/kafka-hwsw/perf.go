@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"kafka-hwsw/internal/generator"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// perfStats collects what the read-back consumer sees during a perf run.
+type perfStats struct {
+	mu         sync.Mutex
+	latencies  []time.Duration
+	partitions map[int32]*partitionStats
+	received   int64
+	expected   int64
+	last       time.Time
+	done       chan struct{}
+}
+
+type partitionStats struct {
+	messages int64
+	bytes    int64
+}
+
+func newPerfStats() *perfStats {
+	return &perfStats{
+		partitions: make(map[int32]*partitionStats),
+		expected:   math.MaxInt64,
+		done:       make(chan struct{}),
+	}
+}
+
+// record adds a message read back from the topic. The end-to-end latency is
+// measured from the produced-at header set when the message was built.
+func (s *perfStats) record(message *sarama.ConsumerMessage, receivedAt time.Time) {
+	producedAt, err := time.Parse(time.RFC3339Nano, kafka.MessageHeaders(message)[kafka.ProducedAtHeader])
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, receivedAt.Sub(producedAt))
+	ps, ok := s.partitions[message.Partition]
+	if !ok {
+		ps = &partitionStats{}
+		s.partitions[message.Partition] = ps
+	}
+	ps.messages++
+	ps.bytes += int64(len(message.Key) + len(message.Value))
+
+	s.received++
+	s.last = receivedAt
+	if s.received == s.expected {
+		close(s.done)
+	}
+}
+
+// expect sets how many messages the run waits for, once the producer knows
+// how many were delivered.
+func (s *perfStats) expect(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expected = n
+	if s.received >= n {
+		close(s.done)
+	}
+}
+
+// runPerf produces count messages as fast as possible while a consumer
+// reads them back from the end of every partition, then reports end-to-end
+// latency percentiles and per-partition throughput.
+func runPerf(ctx context.Context, brokers []string, topic string, count int, timeout time.Duration, gen *generator.Generator, headers map[string]string, opts []kafka.Option) error {
+	client, err := kafka.Connect(brokers, opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	consumer, err := client.NewReader()
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions for topic %s: %w", topic, err)
+	}
+
+	var pcs []kafka.PartitionReader
+	var wg sync.WaitGroup
+	stopReaders := func() {
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+		wg.Wait()
+	}
+
+	stats := newPerfStats()
+	for _, partition := range partitions {
+		// Starting at the newest offset before producing means only this
+		// run's messages are read back.
+		pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			stopReaders()
+			return fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+		pcs = append(pcs, pc)
+
+		wg.Add(1)
+		go func(pc kafka.PartitionReader) {
+			defer wg.Done()
+			for message := range pc.Messages() {
+				stats.record(message, time.Now())
+			}
+		}(pc)
+	}
+
+	var failed atomic.Int64
+	producer, err := kafka.NewAsyncProducer(brokers, topic, func(d kafka.Delivery) {
+		if d.Err != nil {
+			failed.Add(1)
+		}
+	}, opts...)
+	if err != nil {
+		stopReaders()
+		return err
+	}
+
+	slog.Info("Perf run started", "topic", topic, "messages", count, "partitions", len(partitions))
+
+	start := time.Now()
+	sent := 0
+	for _, event := range gen.Generate(count) {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := producer.SendEventWithHeaders(event, headers); err != nil {
+			failed.Add(1)
+			continue
+		}
+		sent++
+	}
+	if err := producer.Close(); err != nil {
+		stopReaders()
+		return err
+	}
+	produceElapsed := time.Since(start)
+
+	delivered := int64(sent) - failed.Load()
+	stats.expect(delivered)
+
+	select {
+	case <-stats.done:
+	case <-time.After(timeout):
+		slog.Warn("Timed out waiting for messages to be read back", "timeout", timeout)
+	case <-ctx.Done():
+	}
+	stopReaders()
+
+	// Throughput is measured over the window in which messages arrived, so
+	// waiting for stragglers doesn't dilute it.
+	elapsed := time.Since(start)
+	if !stats.last.IsZero() {
+		elapsed = stats.last.Sub(start)
+	}
+
+	logPerfSummary(stats, delivered, failed.Load(), produceElapsed, elapsed)
+	if stats.received < delivered {
+		return errors.New("not every delivered message was read back")
+	}
+	return nil
+}
+
+func logPerfSummary(stats *perfStats, delivered, failed int64, produceElapsed, elapsed time.Duration) {
+	latencies := stats.latencies
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	summary := []any{
+		"delivered", delivered,
+		"failed", failed,
+		"received", stats.received,
+		"produce_elapsed", produceElapsed.Round(time.Millisecond),
+		"produce_msg_per_sec", rate(delivered, produceElapsed),
+	}
+	if len(latencies) > 0 {
+		summary = append(summary,
+			"p50", percentile(latencies, 0.50),
+			"p95", percentile(latencies, 0.95),
+			"p99", percentile(latencies, 0.99),
+			"max", latencies[len(latencies)-1],
+		)
+	}
+	slog.Info("Perf summary", summary...)
+
+	ids := make([]int32, 0, len(stats.partitions))
+	for id := range stats.partitions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		ps := stats.partitions[id]
+		slog.Info("Partition throughput", "partition", id, "messages", ps.messages, "bytes", ps.bytes,
+			"msg_per_sec", rate(ps.messages, elapsed), "bytes_per_sec", rate(ps.bytes, elapsed))
+	}
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank].Round(time.Microsecond)
+}
+
+func rate(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.1f", float64(n)/elapsed.Seconds())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/pii"
+)
+
+// piiOptions are the flags of the commands that can hide personal fields
+// before producing.
+type piiOptions struct {
+	mode      string
+	fields    []string
+	vaultFile string
+}
+
+func (o *piiOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&o.mode, "pii-mode", "", "hide personal fields before producing: mask, tokenize or reveal; empty disables it")
+	bindEnv(flags, "pii-mode", "PII_MODE")
+	flags.StringSliceVar(&o.fields, "pii-fields", pii.DefaultFields, "dotted paths of the personal fields in the event")
+	bindEnv(flags, "pii-fields", "PII_FIELDS")
+	flags.StringVar(&o.vaultFile, "pii-vault", "pii-vault.jsonl", "file the tokens of --pii-mode tokenize are kept in and reveal reads")
+	bindEnv(flags, "pii-vault", "PII_VAULT_FILE")
+
+	completeValues(cmd, "pii-mode", pii.Modes...)
+}
+
+// settings returns the options worth logging at startup.
+func (o piiOptions) settings() []any {
+	if o.mode == "" {
+		return nil
+	}
+	settings := []any{"pii_mode", o.mode, "pii_fields", o.fields}
+	if o.mode != pii.ModeMask {
+		settings = append(settings, "pii_vault", o.vaultFile)
+	}
+	return settings
+}
+
+// newMasker builds the masker the flags describe, nil if --pii-mode is
+// empty, and opens its vault, nil for --pii-mode mask. The caller closes
+// the vault.
+func (o piiOptions) newMasker() (*pii.Masker, *pii.Vault, error) {
+	if o.mode == "" {
+		return nil, nil, nil
+	}
+
+	var vault *pii.Vault
+	if o.mode != pii.ModeMask {
+		var err error
+		if vault, err = pii.OpenVault(o.vaultFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	masker, err := pii.New(o.mode, o.fields, vault)
+	if err != nil {
+		if vault != nil {
+			vault.Close()
+		}
+		return nil, nil, err
+	}
+	return masker, vault, nil
+}
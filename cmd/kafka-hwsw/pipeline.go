@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/pii"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/internal/transform"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// deliveryExactlyOnce runs the pipeline in transactions.
+const deliveryExactlyOnce = "exactly-once"
+
+type pipelineOptions struct {
+	topic           string
+	outputTopic     string
+	groupID         string
+	transactionalID string
+	delivery        string
+	transform       string
+	transformFile   string
+	messageFormat   string
+	registryURL     string
+	pii             piiOptions
+	resilience      resilienceOptions
+}
+
+func newPipelineCommand() *cobra.Command {
+	var o pipelineOptions
+
+	cmd := &cobra.Command{
+		Use:   "pipeline",
+		Short: "Filter, mask and enrich events on their way from one topic to another",
+		Long: `Consume events from the input topic, run them through a transformation and
+produce the result to the output topic. A transformation is a list of
+statements, separated by semicolons or newlines:
+
+  filter <expression>        drop events the expression isn't true for
+  mask <field>, ...          hide all but the last four characters
+  set <field> = <expression> add or replace a field
+  drop <field>, ...          remove fields
+
+Fields are dotted paths into the event, such as user_id or data.amount.
+Expressions compare with == != < <= > >=, combine with && || !, add or
+concatenate with +, choose with cond ? a : b, and call now(), lower(s),
+upper(s), contains(s, substr) and hash(s).
+
+--pii-mode hides the personal fields named by --pii-fields after the
+transformation: mask for good, or tokenize with the tokens kept in the
+--pii-vault file, which a trusted pipeline can reveal again.
+
+--delivery chooses how outputs and input offsets are committed:
+exactly-once commits both in one transaction, at-least-once commits the
+offset after the outputs are acknowledged and at-most-once before they are
+produced.`,
+		Example: `  kafka-hwsw pipeline -t test-topic --output-topic purchases --transform 'filter event_type == "purchase"'
+  kafka-hwsw pipeline --transform 'mask user_id; set data.region = "eu"; drop data.ip' --delivery at-least-once
+  kafka-hwsw pipeline --transform-file transforms/pii.txt
+  kafka-hwsw pipeline -t user-events --output-topic user-events-tokenized --pii-mode tokenize`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runPipeline(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "input topic")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "transformed-events", "topic the transformed events are produced to")
+	bindEnv(flags, "output-topic", "PIPELINE_OUTPUT_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "event-pipeline", "consumer group ID")
+	bindEnv(flags, "group", "PIPELINE_GROUP_ID")
+	flags.StringVar(&o.transactionalID, "transactional-id", "event-pipeline", "transactional ID for exactly-once delivery, unique per instance")
+	bindEnv(flags, "transactional-id", "PIPELINE_TRANSACTIONAL_ID")
+	flags.StringVar(&o.delivery, "delivery", deliveryExactlyOnce, "exactly-once, at-least-once or at-most-once")
+	bindEnv(flags, "delivery", "PIPELINE_DELIVERY")
+	flags.StringVar(&o.transform, "transform", "", "transformation to apply; empty forwards events unchanged")
+	bindEnv(flags, "transform", "TRANSFORM")
+	flags.StringVar(&o.transformFile, "transform-file", "", "read the transformation from this file")
+	bindEnv(flags, "transform-file", "TRANSFORM_FILE")
+	flags.StringVar(&o.messageFormat, "format", serde.FormatJSON, "format of produced messages: json, avro or protobuf")
+	bindEnv(flags, "format", "MESSAGE_FORMAT")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	o.pii.addFlags(cmd)
+	o.resilience.addFlags(cmd)
+
+	cmd.MarkFlagsMutuallyExclusive("transform", "transform-file")
+	completeValues(cmd, "delivery", deliveryExactlyOnce, kafka.DeliveryAtLeastOnce, kafka.DeliveryAtMostOnce)
+	completeValues(cmd, "format", serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf)
+	return cmd
+}
+
+func runPipeline(o pipelineOptions) {
+	source := o.transform
+	if o.transformFile != "" {
+		data, err := os.ReadFile(o.transformFile)
+		if err != nil {
+			logging.Fatal("Failed to read transformation", "error", err)
+		}
+		source = string(data)
+	}
+	program, err := transform.Parse(source)
+	if err != nil {
+		logging.Fatal("Invalid transformation", "error", err)
+	}
+	masker, vault, err := o.pii.newMasker()
+	if err != nil {
+		logging.Fatal("Invalid PII settings", "error", err)
+	}
+	if vault != nil {
+		defer vault.Close()
+	}
+
+	transactionalID, semantics := pipelineDelivery(o.delivery, o.transactionalID)
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"delivery", o.delivery,
+	}
+	if transactionalID != "" {
+		settings = append(settings, "transactional_id", transactionalID)
+	}
+	settings = append(settings, "transform", program.String(), "message_format", o.messageFormat, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.pii.settings()...)
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Pipeline", settings...)
+
+	serializer, err := serde.New(o.messageFormat, o.outputTopic, o.registryURL)
+	if err != nil {
+		logging.Fatal("Failed to create serializer", "error", err)
+	}
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(), kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...))
+	opts = append(opts, semantics...)
+	opts = append(opts, o.resilience.options()...)
+
+	var pipeline *kafka.Pipeline
+	o.resilience.connect(ctx, "pipeline", func() (err error) {
+		pipeline, err = kafka.NewPipeline(brokers, o.topic, o.outputTopic, o.groupID, transactionalID,
+			transformEvents(&pipeline, program, masker, serializer), opts...)
+		return err
+	})
+	defer pipeline.Close()
+
+	if err := pipeline.Consume(ctx); err != nil {
+		logging.Fatal("Error running pipeline", "error", err)
+	}
+
+	slog.Info("Pipeline stopped")
+}
+
+// pipelineDelivery returns the transactional ID and options of a
+// kafka.Pipeline with delivery, exiting if delivery is unknown or
+// exactly-once lacks a transactional ID.
+func pipelineDelivery(delivery, transactionalID string) (string, []kafka.Option) {
+	switch delivery {
+	case deliveryExactlyOnce:
+		if transactionalID == "" {
+			logging.Fatal("--transactional-id is required for exactly-once delivery")
+		}
+		return transactionalID, nil
+	case kafka.DeliveryAtLeastOnce, kafka.DeliveryAtMostOnce:
+		return "", []kafka.Option{kafka.WithDeliverySemantics(delivery)}
+	default:
+		logging.Fatal("Unknown delivery, want exactly-once, at-least-once or at-most-once", "delivery", delivery)
+		return "", nil
+	}
+}
+
+// transformEvents runs program against each event decoded by the pipeline
+// behind pipeline, hides the personal fields of the ones it keeps with
+// masker, if there is one, and serializes them.
+func transformEvents(pipeline **kafka.Pipeline, program *transform.Program, masker *pii.Masker, serializer kafka.Serializer) kafka.TransformFunc {
+	return func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+		event, err := (*pipeline).DecodeEvent(message)
+		if err != nil {
+			return nil, err
+		}
+
+		if !program.Empty() || masker != nil {
+			// The program works on the JSON form of the event, so that
+			// fields are addressed the same way whatever the input format.
+			fields, err := eventFields(event)
+			if err != nil {
+				return nil, err
+			}
+			keep, err := program.Apply(fields)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				slog.Debug("Event filtered out", "topic", message.Topic, "partition", message.Partition,
+					"offset", message.Offset, "user_id", event.UserID)
+				return nil, nil
+			}
+			if masker != nil {
+				if err := masker.Apply(fields); err != nil {
+					return nil, err
+				}
+			}
+			if event, err = fieldsEvent(fields); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := serializer.Serialize(event)
+		if err != nil {
+			return nil, err
+		}
+
+		extra := make(map[string]string)
+		if traceID, ok := kafka.MessageHeaders(message)[kafka.TraceIDHeader]; ok {
+			extra[kafka.TraceIDHeader] = traceID
+		}
+
+		return []*sarama.ProducerMessage{{
+			Key:     sarama.StringEncoder(event.UserID),
+			Value:   sarama.ByteEncoder(value),
+			Headers: kafka.EventHeaders(serializer, event, extra),
+		}}, nil
+	}
+}
+
+func eventFields(event kafka.UserEvent) (map[string]any, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	return fields, json.Unmarshal(data, &fields)
+}
+
+func fieldsEvent(fields map[string]any) (kafka.UserEvent, error) {
+	var event kafka.UserEvent
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return event, err
+	}
+	return event, json.Unmarshal(data, &event)
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/dashboard"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/metrics"
+	"kafka-hwsw/internal/ratelimit"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/internal/source"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type produceOptions struct {
+	topic                  string
+	messageCount           int
+	messageIntervalMS      int
+	messagesPerSecond      float64
+	burst                  int
+	rampUp                 time.Duration
+	rampStartRate          float64
+	async                  bool
+	partitioner            string
+	keyStrategy            string
+	keyField               string
+	input                  string
+	inputFile              string
+	inputHTTPPort          int
+	manualPartition        int
+	transactionalID        string
+	idempotent             bool
+	sequenceNumbers        bool
+	txnBatchSize           int
+	batchSize              int
+	lingerMS               int
+	messageFormat          string
+	schemaRegistryURL      string
+	metricsPort            int
+	healthPort             int
+	dashboardPort          int
+	autoCreateTopic        bool
+	topicPartitions        int
+	topicReplicationFactor int
+	shutdownTimeout        time.Duration
+	perfMode               bool
+	perfTimeout            time.Duration
+	headers                string
+	recordTimestamp        string
+	events                 eventOptions
+	pii                    piiOptions
+	resilience             resilienceOptions
+	compression            string
+	schemaVersion          int
+	skewThreshold          float64
+	heatmapFile            string
+	heatmapInterval        time.Duration
+	standbyBrokers         []string
+	failoverThreshold      int
+	failbackAfter          time.Duration
+	deliverAfter           time.Duration
+	schedulerTopic         string
+}
+
+func newProduceCommand() *cobra.Command {
+	var o produceOptions
+
+	cmd := &cobra.Command{
+		Use:   "produce",
+		Short: "Produce generated user events",
+		Long: `Produce generated user events keyed by user ID (or --key-strategy) and
+report which partition each key went to. Sends are synchronous by default; --async, --batch-size and
+--transactional-id switch to the other producer modes, and --perf measures
+end-to-end latency instead.
+
+--input reads the events from a file or stdin with one JSON event per line,
+or from an HTTP endpoint they are POSTed to, instead of generating them.`,
+		Example: "  kafka-hwsw produce --count 1000 --interval-ms 0 --async\n" +
+			"  kafka-hwsw produce --input file --input-file events.jsonl --interval-ms 0 --key-field data.session_id\n" +
+			"  cat events.jsonl | kafka-hwsw produce --input stdin --interval-ms 0\n" +
+			"  kafka-hwsw produce --input http --input-http-port 8090 --interval-ms 0",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{memoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			runProduce(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to produce to")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.IntVarP(&o.messageCount, "count", "n", 20, "number of messages to send")
+	bindEnv(flags, "count", "MESSAGE_COUNT")
+	flags.IntVar(&o.messageIntervalMS, "interval-ms", 500, "delay between messages in milliseconds, 0 sends as fast as possible")
+	bindEnv(flags, "interval-ms", "MESSAGE_INTERVAL_MS")
+	flags.Float64Var(&o.messagesPerSecond, "rate", 0, "target messages per second, overrides --interval-ms")
+	bindEnv(flags, "rate", "MESSAGES_PER_SECOND")
+	flags.IntVar(&o.burst, "burst", 1, "messages that may be sent back to back at --rate")
+	bindEnv(flags, "burst", "BURST")
+	flags.DurationVar(&o.rampUp, "ramp-up", 0, "ramp the rate up to --rate over this duration")
+	bindEnv(flags, "ramp-up", "RAMP_UP")
+	flags.Float64Var(&o.rampStartRate, "ramp-start-rate", 1, "messages per second at the start of the ramp")
+	bindEnv(flags, "ramp-start-rate", "RAMP_START_RATE")
+	flags.BoolVar(&o.async, "async", false, "use the async producer")
+	bindEnv(flags, "async", "ASYNC")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerHash, "partitioner: hash, murmur2, roundrobin, random or manual")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.StringVar(&o.keyStrategy, "key-strategy", kafka.KeyUserID, "message key: user_id, session_id, composite, null or uuid")
+	bindEnv(flags, "key-strategy", "KEY_STRATEGY")
+	flags.StringVar(&o.keyField, "key-field", "", "key messages by the value at this JSON path in the event, e.g. user_id or $.data.order_id; overrides --key-strategy")
+	bindEnv(flags, "key-field", "KEY_FIELD")
+	flags.StringVar(&o.input, "input", source.KindGenerator, "where events come from: generator, file, stdin or http")
+	bindEnv(flags, "input", "PRODUCER_INPUT")
+	flags.StringVar(&o.inputFile, "input-file", "", "file with one JSON event per line for --input file")
+	bindEnv(flags, "input-file", "INPUT_FILE")
+	flags.IntVar(&o.inputHTTPPort, "input-http-port", 8090, "port --input http accepts POST /events on")
+	bindEnv(flags, "input-http-port", "INPUT_HTTP_PORT")
+	flags.IntVar(&o.manualPartition, "partition", 0, "partition used by the manual partitioner")
+	bindEnv(flags, "partition", "KAFKA_MANUAL_PARTITION")
+	flags.StringVar(&o.transactionalID, "transactional-id", "", "send in transactions with this ID")
+	bindEnv(flags, "transactional-id", "KAFKA_TRANSACTIONAL_ID")
+	flags.BoolVar(&o.idempotent, "idempotent", false, "enable the idempotent producer")
+	bindEnv(flags, "idempotent", "IDEMPOTENT")
+	flags.BoolVar(&o.sequenceNumbers, "sequence-numbers", false, "number the messages of each key so consume --verify-ordering can check them")
+	bindEnv(flags, "sequence-numbers", "SEQUENCE_NUMBERS")
+	flags.IntVar(&o.txnBatchSize, "txn-batch-size", 5, "messages per transaction")
+	bindEnv(flags, "txn-batch-size", "TXN_BATCH_SIZE")
+	flags.IntVar(&o.batchSize, "batch-size", 0, "send in batches of this size")
+	bindEnv(flags, "batch-size", "BATCH_SIZE")
+	flags.IntVar(&o.lingerMS, "linger-ms", 0, "flush a partial batch after this many milliseconds")
+	bindEnv(flags, "linger-ms", "LINGER_MS")
+	flags.StringVar(&o.messageFormat, "format", serde.FormatJSON, "message format: json, avro or protobuf")
+	bindEnv(flags, "format", "MESSAGE_FORMAT")
+	flags.IntVar(&o.schemaVersion, "schema-version", 1, fmt.Sprintf("JSON layout of the events, 1 to %d", kafka.LatestSchemaVersion))
+	bindEnv(flags, "schema-version", "SCHEMA_VERSION")
+	flags.StringVar(&o.schemaRegistryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.IntVar(&o.metricsPort, "metrics-port", 0, "serve Prometheus metrics on this port")
+	bindEnv(flags, "metrics-port", "METRICS_PORT")
+	flags.IntVar(&o.healthPort, "health-port", 0, "serve /healthz and /readyz on this port")
+	bindEnv(flags, "health-port", "HEALTH_PORT")
+	flags.IntVar(&o.dashboardPort, "dashboard-port", 0, "serve a live web dashboard of the partition distribution on this port")
+	bindEnv(flags, "dashboard-port", "DASHBOARD_PORT")
+	flags.BoolVar(&o.autoCreateTopic, "create-topic", false, "create the topic if it doesn't exist")
+	bindEnv(flags, "create-topic", "AUTO_CREATE_TOPIC")
+	flags.IntVar(&o.topicPartitions, "topic-partitions", 3, "partitions for --create-topic")
+	bindEnv(flags, "topic-partitions", "TOPIC_PARTITIONS")
+	flags.IntVar(&o.topicReplicationFactor, "topic-replication-factor", 3, "replication factor for --create-topic")
+	bindEnv(flags, "topic-replication-factor", "TOPIC_REPLICATION_FACTOR")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight messages on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+	flags.BoolVar(&o.perfMode, "perf", false, "measure end-to-end latency instead of running the demo")
+	bindEnv(flags, "perf", "PERF_MODE")
+	flags.DurationVar(&o.perfTimeout, "perf-timeout", 30*time.Second, "how long --perf waits for messages to come back")
+	bindEnv(flags, "perf-timeout", "PERF_TIMEOUT")
+	flags.StringVar(&o.headers, "headers", "", "extra message headers, e.g. source=demo,env=dev")
+	bindEnv(flags, "headers", "MESSAGE_HEADERS")
+	flags.StringVar(&o.recordTimestamp, "record-timestamp", kafka.RecordTimestampSend, "record timestamp: send (the send time), event (the event's timestamp) or an RFC3339 time")
+	bindEnv(flags, "record-timestamp", "RECORD_TIMESTAMP")
+	flags.Float64Var(&o.skewThreshold, "skew-threshold", kafka.DefaultSkewThreshold, "flag partitions that got more than this times the average messages or bytes, 0 disables")
+	bindEnv(flags, "skew-threshold", "SKEW_THRESHOLD")
+	flags.StringVar(&o.heatmapFile, "heatmap-file", "", "write the messages per key and partition to this .csv or .json file on shutdown")
+	bindEnv(flags, "heatmap-file", "HEATMAP_FILE")
+	flags.DurationVar(&o.heatmapInterval, "heatmap-interval", 0, "also write --heatmap-file this often, 0 only writes it on shutdown")
+	bindEnv(flags, "heatmap-interval", "HEATMAP_INTERVAL")
+	flags.StringSliceVar(&o.standbyBrokers, "standby-brokers", nil, "fail over to the cluster with these brokers when sends to --brokers keep failing")
+	bindEnv(flags, "standby-brokers", "KAFKA_STANDBY_BROKERS")
+	flags.IntVar(&o.failoverThreshold, "failover-threshold", 3, "failed sends in a row, after retries, that switch to the other cluster")
+	bindEnv(flags, "failover-threshold", "FAILOVER_THRESHOLD")
+	flags.DurationVar(&o.failbackAfter, "failback-after", time.Minute, "how long to stay on the standby before trying the primary again, 0 stays")
+	bindEnv(flags, "failback-after", "FAILBACK_AFTER")
+	flags.DurationVar(&o.deliverAfter, "deliver-after", 0, "hold every message back this long by sending it to --scheduler-topic for kafka-hwsw scheduler to deliver, e.g. 30s")
+	bindEnv(flags, "deliver-after", "DELIVER_AFTER")
+	flags.StringVar(&o.schedulerTopic, "scheduler-topic", "", "topic delayed messages wait in, default <topic>-scheduled")
+	bindEnv(flags, "scheduler-topic", "SCHEDULER_TOPIC")
+	o.events.addFlags(cmd)
+	o.pii.addFlags(cmd)
+	o.resilience.addFlags(cmd)
+	o.resilience.addBreakerFlags(cmd)
+	flags.StringVar(&o.compression, "compression", "snappy", "compression codec: none, gzip, snappy, lz4 or zstd")
+	bindEnv(flags, "compression", "KAFKA_COMPRESSION")
+
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom, kafka.PartitionerManual)
+	completeValues(cmd, "key-strategy", kafka.KeyStrategies...)
+	completeValues(cmd, "input", source.Kinds...)
+	completeValues(cmd, "format", serde.FormatJSON, serde.FormatAvro, serde.FormatProtobuf)
+	completeValues(cmd, "compression", kafka.CompressionCodecs...)
+	completeValues(cmd, "record-timestamp", kafka.RecordTimestampSources...)
+	return cmd
+}
+
+func runProduce(o produceOptions) {
+	idempotent := o.idempotent || o.transactionalID != ""
+	linger := time.Duration(o.lingerMS) * time.Millisecond
+	headers, err := kafka.ParseHeaders(o.headers)
+	if err != nil {
+		logging.Fatal("Invalid --headers", "error", err)
+	}
+	gen, err := o.events.newGenerator()
+	if err != nil {
+		logging.Fatal("Invalid event generator settings", "error", err)
+	}
+	switch o.input {
+	case source.KindGenerator, source.KindStdin, source.KindHTTP:
+	case source.KindFile:
+		if o.inputFile == "" {
+			logging.Fatal("--input file needs --input-file")
+		}
+	default:
+		logging.Fatal("Invalid --input", "input", o.input, "supported", source.Kinds)
+	}
+	if memoryBroker != nil && (o.async || o.perfMode || o.healthPort > 0) {
+		logging.Fatal("--async, --perf and --health-port need a Kafka cluster, they don't work with --mode memory")
+	}
+	if o.skewThreshold != 0 && o.skewThreshold <= 1 {
+		logging.Fatal("--skew-threshold must be above 1, or 0 to disable it", "threshold", o.skewThreshold)
+	}
+	if o.heatmapInterval > 0 && o.heatmapFile == "" {
+		logging.Fatal("--heatmap-interval needs --heatmap-file")
+	}
+	if len(o.standbyBrokers) > 0 {
+		if memoryBroker != nil {
+			logging.Fatal("--standby-brokers needs Kafka clusters, it doesn't work with --mode memory")
+		}
+		if o.async || o.batchSize > 0 || o.transactionalID != "" || o.perfMode {
+			logging.Fatal("--standby-brokers only works with synchronous sends, not with --async, --batch-size, --transactional-id or --perf")
+		}
+	}
+	if o.perfMode && o.input != source.KindGenerator {
+		logging.Fatal("--perf only works with generated events", "input", o.input)
+	}
+	if o.perfMode && o.pii.mode != "" {
+		logging.Fatal("--pii-mode doesn't apply to --perf")
+	}
+	recordTimestamp, err := recordTimestampOption(o.recordTimestamp)
+	if err != nil {
+		logging.Fatal("Invalid --record-timestamp", "error", err)
+	}
+	if o.deliverAfter < 0 {
+		logging.Fatal("--deliver-after must not be negative", "deliver_after", o.deliverAfter)
+	}
+	_, delayedHeader := headers[kafka.DeliverAfterHeader]
+	delayed := o.deliverAfter > 0 || delayedHeader
+	if delayed && o.perfMode {
+		logging.Fatal("--deliver-after doesn't apply to --perf")
+	}
+	schedulerTopic := o.schedulerTopic
+	if schedulerTopic == "" {
+		schedulerTopic = kafka.ScheduledTopic(o.topic)
+	}
+	masker, vault, err := o.pii.newMasker()
+	if err != nil {
+		logging.Fatal("Invalid PII settings", "error", err)
+	}
+	if vault != nil {
+		defer vault.Close()
+	}
+	keyStrategy := o.keyStrategy
+	if o.keyField != "" {
+		if _, err := kafka.ParseJSONPath(o.keyField); err != nil {
+			logging.Fatal("Invalid --key-field", "error", err)
+		}
+		keyStrategy = "field:" + o.keyField
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"input", o.input,
+	}
+	switch o.input {
+	case source.KindGenerator:
+		settings = append(settings, "message_count", o.messageCount)
+	case source.KindFile:
+		settings = append(settings, "input_file", o.inputFile)
+	case source.KindHTTP:
+		settings = append(settings, "input_http_port", o.inputHTTPPort)
+	}
+	settings = append(settings,
+		"async", o.async,
+		"partitioner", o.partitioner,
+		"key_strategy", keyStrategy,
+		"idempotent", idempotent,
+		"sequence_numbers", o.sequenceNumbers,
+		"message_format", o.messageFormat,
+		"schema_version", o.schemaVersion,
+		"compression", o.compression,
+		"record_timestamp", o.recordTimestamp,
+		"seed", gen.Seed(),
+		"tls", tlsConfig.Enabled,
+		"shutdown_timeout", o.shutdownTimeout,
+		"skew_threshold", o.skewThreshold,
+	)
+	if o.heatmapFile != "" {
+		settings = append(settings, "heatmap_file", o.heatmapFile, "heatmap_interval", o.heatmapInterval)
+	}
+	if len(o.standbyBrokers) > 0 {
+		settings = append(settings, "standby_brokers", o.standbyBrokers, "failover_threshold", o.failoverThreshold, "failback_after", o.failbackAfter)
+	}
+	if delayed {
+		settings = append(settings, "deliver_after", o.deliverAfter, "scheduler_topic", schedulerTopic)
+	}
+	if o.messagesPerSecond > 0 {
+		settings = append(settings, "messages_per_second", o.messagesPerSecond, "burst", o.burst)
+		if o.rampUp > 0 {
+			settings = append(settings, "ramp_up", o.rampUp, "ramp_start_rate", o.rampStartRate)
+		}
+	} else {
+		settings = append(settings, "message_interval_ms", o.messageIntervalMS)
+	}
+	if o.transactionalID != "" {
+		settings = append(settings, "transactional_id", o.transactionalID, "txn_batch_size", o.txnBatchSize)
+	}
+	if o.batchSize > 0 {
+		settings = append(settings, "batch_size", o.batchSize, "linger", linger)
+	}
+	if o.perfMode {
+		settings = append(settings, "perf_mode", true, "perf_timeout", o.perfTimeout)
+	}
+	if o.autoCreateTopic {
+		settings = append(settings, "topic_partitions", o.topicPartitions, "topic_replication_factor", o.topicReplicationFactor)
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	if len(headers) > 0 {
+		settings = append(settings, "headers", headers)
+	}
+	settings = append(settings, o.events.settings()...)
+	settings = append(settings, o.pii.settings()...)
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Producer - Partition Routing Demo", settings...)
+
+	// Stopping only ends the send loop; messages already handed to the
+	// producer are still flushed by closeProducer below.
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout)
+	defer cancel()
+
+	if o.autoCreateTopic {
+		if err := ensureTopic(o.topic, o.topicPartitions, o.topicReplicationFactor); err != nil {
+			logging.Fatal("Failed to create topic", "topic", o.topic, "error", err)
+		}
+		if delayed {
+			if err := ensureTopic(schedulerTopic, o.topicPartitions, o.topicReplicationFactor); err != nil {
+				logging.Fatal("Failed to create topic", "topic", schedulerTopic, "error", err)
+			}
+		}
+	}
+
+	serializer, err := serde.New(o.messageFormat, o.topic, o.schemaRegistryURL)
+	if err != nil {
+		logging.Fatal("Failed to create serializer", "error", err)
+	}
+	if o.schemaVersion < 1 || o.schemaVersion > kafka.LatestSchemaVersion {
+		logging.Fatal("Invalid --schema-version", "version", o.schemaVersion, "latest", kafka.LatestSchemaVersion)
+	}
+	if o.schemaVersion != 1 {
+		if _, ok := serializer.(kafka.JSONSerializer); !ok {
+			logging.Fatal("--schema-version only applies to the json format", "format", o.messageFormat)
+		}
+		serializer = kafka.JSONSerializer{Version: o.schemaVersion}
+	}
+
+	opts := append(clientOptions(),
+		kafka.WithSerializer(serializer),
+		kafka.WithPartitioner(o.partitioner),
+		kafka.WithKeyStrategy(o.keyStrategy),
+		kafka.WithCompression(o.compression),
+		recordTimestamp,
+	)
+	if o.keyField != "" {
+		opts = append(opts, kafka.WithKeyField(o.keyField))
+	}
+	opts = append(opts, o.resilience.options()...)
+	if o.metricsPort > 0 {
+		m := metrics.New()
+		server := m.Serve(o.metricsPort)
+		defer server.Close()
+		opts = append(opts, kafka.WithMetrics(m), kafka.WithConfigFunc(m.ConfigureSarama))
+		slog.Info("Metrics available", "url", fmt.Sprintf("http://localhost:%d/metrics", o.metricsPort))
+	}
+	if o.healthPort > 0 {
+		var health *kafka.HealthCheck
+		o.resilience.connect(ctx, "health check", func() (err error) {
+			health, err = kafka.NewHealthCheck(brokers, []string{o.topic}, clientOptions()...)
+			return err
+		})
+		defer health.Close()
+		server := serveHealth(o.healthPort, health)
+		defer server.Close()
+	}
+	if o.partitioner == kafka.PartitionerManual {
+		opts = append(opts, kafka.WithManualPartition(int32(o.manualPartition)))
+	}
+	if idempotent {
+		opts = append(opts, kafka.WithIdempotence())
+	}
+	if o.sequenceNumbers {
+		opts = append(opts, kafka.WithSequenceNumbers())
+	}
+	if delayed {
+		opts = append(opts, kafka.WithDelayedDelivery(o.deliverAfter, schedulerTopic))
+	}
+	kafka.LogProducerRetries(idempotent)
+
+	if o.perfMode {
+		if err := runPerf(ctx, brokers, o.topic, o.messageCount, o.perfTimeout, gen, headers, opts); err != nil {
+			logging.Fatal("Perf run failed", "error", err)
+		}
+		return
+	}
+
+	tracker := kafka.NewPartitionTracker()
+	tracker.SetTopic(o.topic)
+	tracker.SetPartitioner(o.partitioner)
+	tracker.SetKeyStrategy(keyStrategy)
+	if o.dashboardPort > 0 {
+		d := dashboard.New(dashboard.Config{
+			Role:     "producer",
+			Topic:    o.topic,
+			Trackers: func() []*kafka.PartitionTracker { return []*kafka.PartitionTracker{tracker} },
+		})
+		defer d.Close()
+		server := serveDashboard(o.dashboardPort, d)
+		defer server.Close()
+	}
+	// Looked up once, after the first messages created the topic if it
+	// didn't exist.
+	partitionCount := sync.OnceValue(func() int { return topicPartitionCount(o.topic) })
+	var heatmap *kafka.HeatmapExporter
+	if o.heatmapFile != "" {
+		heatmap, err = kafka.NewHeatmapExporter(o.heatmapFile,
+			func() []*kafka.PartitionTracker { return []*kafka.PartitionTracker{tracker} },
+			func(string) int { return partitionCount() })
+		if err != nil {
+			logging.Fatal("Invalid --heatmap-file", "error", err)
+		}
+		if o.heatmapInterval > 0 {
+			go heatmap.Run(ctx, o.heatmapInterval)
+		}
+	}
+	var delivered, failed atomic.Int64
+
+	var send func(event kafka.UserEvent)
+	var closeProducer func() error
+
+	if o.async && o.transactionalID != "" {
+		logging.Fatal("--async and --transactional-id can't be combined")
+	}
+	if o.batchSize > 0 && (o.async || o.transactionalID != "") {
+		logging.Fatal("--batch-size can't be combined with --async or --transactional-id")
+	}
+
+	mode := "sync"
+
+	if o.async {
+		var producer *kafka.AsyncProducer
+		onDelivery := func(d kafka.Delivery) {
+			if d.Err != nil {
+				failed.Add(1)
+				slog.Error("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+				return
+			}
+			delivered.Add(1)
+			slog.Info("Message delivered", "topic", o.topic, "partition", d.Partition,
+				"offset", d.Offset, "key", d.Key, "latency", d.Latency)
+			tracker.RecordSize(d.Key, d.Partition, d.Bytes)
+		}
+		o.resilience.connect(ctx, "producer", func() (err error) {
+			producer, err = kafka.NewAsyncProducer(brokers, o.topic, onDelivery, opts...)
+			return err
+		})
+
+		send = func(event kafka.UserEvent) {
+			if err := producer.SendEventWithHeaders(event, headers); err != nil {
+				failed.Add(1)
+				slog.Error("Failed to send message", "topic", o.topic, "user_id", event.UserID, "error", err)
+			}
+		}
+		closeProducer = producer.Close
+		mode = "async"
+	} else if o.transactionalID != "" {
+		var producer *kafka.Producer
+		o.resilience.connect(ctx, "producer", func() (err error) {
+			producer, err = kafka.NewProducer(brokers, o.topic, append(opts, kafka.WithTransactionalID(o.transactionalID))...)
+			return err
+		})
+
+		batcher := &txnBatcher{
+			producer:  producer,
+			batchSize: o.txnBatchSize,
+			headers:   headers,
+			tracker:   tracker,
+			delivered: &delivered,
+			failed:    &failed,
+		}
+		send = batcher.send
+		closeProducer = batcher.close
+		mode = "transactional"
+	} else if o.batchSize > 0 {
+		var producer *kafka.Producer
+		o.resilience.connect(ctx, "producer", func() (err error) {
+			producer, err = kafka.NewProducer(brokers, o.topic, opts...)
+			return err
+		})
+
+		batcher := kafka.NewBatcher(producer, o.batchSize, linger, headers, func(stats kafka.BatchStats, deliveries []kafka.Delivery) {
+			for _, d := range deliveries {
+				if d.Err != nil {
+					failed.Add(1)
+					slog.Error("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+					continue
+				}
+				delivered.Add(1)
+				slog.Debug("Message sent", "topic", o.topic, "partition", d.Partition, "offset", d.Offset, "key", d.Key)
+				tracker.RecordSize(d.Key, d.Partition, d.Bytes)
+			}
+			slog.Info("Batch sent", "topic", o.topic, "size", stats.Size, "failed", stats.Failed,
+				"latency", stats.Latency, "msg_per_sec", fmt.Sprintf("%.1f", stats.Throughput()))
+		})
+		send = batcher.Add
+		closeProducer = batcher.Close
+		mode = "batch"
+	} else if len(o.standbyBrokers) > 0 {
+		producer, err := kafka.NewFailoverProducer(kafka.FailoverCluster{Brokers: brokers}, kafka.FailoverCluster{Brokers: o.standbyBrokers},
+			o.topic, kafka.FailoverConfig{Threshold: o.failoverThreshold, FailbackAfter: o.failbackAfter}, opts...)
+		if err != nil {
+			logging.Fatal("Invalid failover settings", "error", err)
+		}
+
+		send = func(event kafka.UserEvent) {
+			d := producer.Send(event, headers)
+			if d.Err != nil {
+				failed.Add(1)
+				slog.Error("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+				return
+			}
+			delivered.Add(1)
+			slog.Info("Message sent", "topic", o.topic, "cluster", producer.Active(), "partition", d.Partition,
+				"offset", d.Offset, "key", d.Key, "event_type", event.EventType)
+			tracker.RecordSize(d.Key, d.Partition, d.Bytes)
+		}
+		closeProducer = producer.Close
+		mode = "failover"
+	} else {
+		var producer *kafka.Producer
+		o.resilience.connect(ctx, "producer", func() (err error) {
+			producer, err = kafka.NewProducer(brokers, o.topic, opts...)
+			return err
+		})
+
+		send = func(event kafka.UserEvent) {
+			d := producer.Send(event, headers)
+			if d.Err != nil {
+				failed.Add(1)
+				slog.Error("Failed to send message", "topic", o.topic, "key", d.Key, "error", d.Err)
+				return
+			}
+			delivered.Add(1)
+			slog.Info("Message sent", "topic", o.topic, "partition", d.Partition,
+				"offset", d.Offset, "key", d.Key, "event_type", event.EventType)
+			tracker.RecordSize(d.Key, d.Partition, d.Bytes)
+		}
+		closeProducer = producer.Close
+	}
+
+	// Generated events are limited by --count; the other inputs are sent
+	// until they are exhausted or the producer is stopped.
+	var input source.Source
+	limit := math.MaxInt
+	switch o.input {
+	case source.KindGenerator:
+		input = source.Events(gen.Generate(o.messageCount))
+		limit = o.messageCount
+	case source.KindFile:
+		if input, err = source.File(o.inputFile); err != nil {
+			logging.Fatal("Failed to open input", "error", err)
+		}
+	case source.KindStdin:
+		input = source.Stdin()
+	case source.KindHTTP:
+		endpoint, err := source.NewHTTP(o.inputHTTPPort)
+		if err != nil {
+			logging.Fatal("Failed to start event endpoint", "error", err)
+		}
+		slog.Info("Accepting events", "url", endpoint.URL())
+		input = endpoint
+	}
+
+	// --rate takes precedence over --interval-ms. Without either the
+	// producer sends as fast as possible, which is what makes the
+	// sync/async/batch throughput comparison meaningful.
+	ramping := o.messagesPerSecond > 0 && o.rampUp > 0
+	var limiter *ratelimit.Limiter
+	switch {
+	case ramping:
+		limiter = ratelimit.NewRamp(o.rampStartRate, o.messagesPerSecond, o.rampUp, o.burst)
+	case o.messagesPerSecond > 0:
+		limiter = ratelimit.New(o.messagesPerSecond, o.burst)
+	case o.messageIntervalMS > 0:
+		limiter = ratelimit.New(1000/float64(o.messageIntervalMS), 1)
+	}
+
+	start := time.Now()
+	lastRateLog := start
+	count := 0
+produce:
+	for count < limit {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		var event kafka.UserEvent
+		select {
+		case next, ok := <-input.Events():
+			if !ok {
+				break produce
+			}
+			event = next
+		case <-ctx.Done():
+			break produce
+		}
+
+		// Personal fields are hidden before the event gets near the
+		// producer; an event that can't be is dropped, not sent as is.
+		if masker != nil {
+			if event, err = masker.Event(event); err != nil {
+				failed.Add(1)
+				slog.Error("Failed to hide personal fields, event dropped", "user_id", event.UserID, "error", err)
+				count++
+				continue
+			}
+		}
+
+		send(event)
+		count++
+
+		if ramping && time.Since(lastRateLog) >= time.Second {
+			slog.Info("Ramping up", "target_msg_per_sec", fmt.Sprintf("%.1f", limiter.Rate()), "sent", count)
+			lastRateLog = time.Now()
+		}
+	}
+
+	if err := input.Close(); err != nil {
+		slog.Error("Failed to close input", "input", o.input, "error", err)
+	}
+
+	if mode == "async" || mode == "batch" {
+		slog.Info("Queued messages, waiting for in-flight deliveries...", "count", count)
+	}
+	if err := closeProducer(); err != nil {
+		slog.Error("Failed to close producer", "error", err)
+	}
+
+	if ctx.Err() != nil {
+		slog.Info("Producer stopped")
+	} else {
+		slog.Info("Sent all messages, stopping producer", "count", count)
+	}
+
+	tracker.LogSummary("went to")
+	if o.skewThreshold > 0 && tracker.Len() > 0 {
+		tracker.Skew(partitionCount(), o.skewThreshold).Log()
+	}
+	if heatmap != nil {
+		heatmap.LogWrite()
+	}
+	logThroughputSummary(mode, delivered.Load(), failed.Load(), time.Since(start))
+}
+
+// recordTimestampOption turns --record-timestamp into a producer option.
+func recordTimestampOption(value string) (kafka.Option, error) {
+	if slices.Contains(kafka.RecordTimestampSources, value) {
+		return kafka.WithRecordTimestamps(value), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("expected %s or an RFC3339 time, got %q", strings.Join(kafka.RecordTimestampSources, ", "), value)
+	}
+	return kafka.WithRecordTimestamp(t), nil
+}
+
+// topicPartitionCount returns the number of partitions of topic, so idle
+// partitions count towards the skew, or 0 if it can't be looked up.
+func topicPartitionCount(topic string) int {
+	if memoryBroker != nil {
+		return memoryBroker.Partitions(topic)
+	}
+	client, err := kafka.Connect(brokers, clientOptions()...)
+	if err != nil {
+		slog.Warn("Failed to look up partitions, skew only counts the ones that got messages", "topic", topic, "error", err)
+		return 0
+	}
+	defer client.Close()
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		slog.Warn("Failed to look up partitions, skew only counts the ones that got messages", "topic", topic, "error", err)
+		return 0
+	}
+	return len(partitions)
+}
+
+// ensureTopic creates the topic up front so it gets the requested partition
+// count instead of the broker's auto-create default of a single partition.
+func ensureTopic(topic string, partitions, replicationFactor int) error {
+	var created bool
+	if memoryBroker != nil {
+		created = memoryBroker.CreateTopic(topic, int32(partitions))
+	} else {
+		admin, err := kafka.NewAdmin(brokers, clientOptions()...)
+		if err != nil {
+			return err
+		}
+		defer admin.Close()
+
+		if created, err = kafka.EnsureTopic(admin, topic, int32(partitions), int16(replicationFactor)); err != nil {
+			return err
+		}
+	}
+
+	if created {
+		slog.Info("Created topic", "topic", topic, "partitions", partitions, "replication_factor", replicationFactor)
+	} else {
+		slog.Info("Topic already exists", "topic", topic)
+	}
+	return nil
+}
+
+func logThroughputSummary(mode string, delivered, failed int64, elapsed time.Duration) {
+	summary := []any{
+		"mode", mode,
+		"delivered", delivered,
+		"failed", failed,
+		"elapsed", elapsed.Round(time.Millisecond),
+	}
+	if elapsed > 0 {
+		summary = append(summary, "msg_per_sec", fmt.Sprintf("%.1f", float64(delivered)/elapsed.Seconds()))
+	}
+	slog.Info("Throughput summary", summary...)
+	slog.Info("Run again with --interval-ms=0 and --async or --batch-size=100 to compare modes")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type replayOptions struct {
+	topic          string
+	outputTopic    string
+	from           string
+	to             string
+	speed          string
+	keepPartitions bool
+	partitioner    string
+	compression    string
+	resilience     resilienceOptions
+}
+
+func newReplayCommand() *cobra.Command {
+	var o replayOptions
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Re-produce part of a topic's history into another topic",
+		Long: `Read the messages of a topic between two positions and produce them to
+another topic with the same keys, values and headers. --from and --to are
+oldest, newest, an offset of every partition or an RFC3339 timestamp; the
+message at --to itself is left out. --to is resolved when the replay
+starts, so the command finishes even while the topic keeps growing.
+
+--speed max sends as fast as the brokers take it. A factor keeps the
+original time between messages, divided by it: 1 replays a recorded
+minute in a minute, 10 in six seconds. Either way messages go out in
+timestamp order across partitions and in offset order within one, and get
+new timestamps.`,
+		Example: `  kafka-hwsw replay -t test-topic --output-topic test-topic-replay
+  kafka-hwsw replay --output-topic replay --from 2024-05-01T10:00:00Z --to 2024-05-01T11:00:00Z --speed 1
+  kafka-hwsw replay --output-topic replay --from 1000 --to 2000 --keep-partitions`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runReplay(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to replay")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "", "topic the messages are produced to")
+	bindEnv(flags, "output-topic", "REPLAY_OUTPUT_TOPIC")
+	flags.StringVar(&o.from, "from", "oldest", "where to start: oldest, newest, an offset or an RFC3339 timestamp")
+	bindEnv(flags, "from", "REPLAY_FROM")
+	flags.StringVar(&o.to, "to", "newest", "where to stop, exclusive: oldest, newest, an offset or an RFC3339 timestamp")
+	bindEnv(flags, "to", "REPLAY_TO")
+	flags.StringVar(&o.speed, "speed", "max", "max, or a factor applied to the original timing such as 1 or 2")
+	bindEnv(flags, "speed", "REPLAY_SPEED")
+	flags.BoolVar(&o.keepPartitions, "keep-partitions", false, "send every message to the partition number it came from instead of partitioning by key")
+	bindEnv(flags, "keep-partitions", "REPLAY_KEEP_PARTITIONS")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerHash, "partitioner for the output topic: hash, murmur2, roundrobin or random")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.StringVar(&o.compression, "compression", "snappy", "compression codec: none, gzip, snappy, lz4 or zstd")
+	bindEnv(flags, "compression", "KAFKA_COMPRESSION")
+	o.resilience.addFlags(cmd)
+
+	completeValues(cmd, "from", "oldest", "newest")
+	completeValues(cmd, "to", "oldest", "newest")
+	completeValues(cmd, "speed", "max", "1")
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom)
+	completeValues(cmd, "compression", kafka.CompressionCodecs...)
+	return cmd
+}
+
+func runReplay(o replayOptions) {
+	switch o.outputTopic {
+	case "":
+		logging.Fatal("--output-topic is required")
+	case o.topic:
+		logging.Fatal("--output-topic must differ from --topic", "topic", o.topic)
+	}
+	if o.partitioner == kafka.PartitionerManual {
+		logging.Fatal("--partitioner manual isn't supported, use --keep-partitions to keep the partitions")
+	}
+
+	from, err := kafka.ParsePosition(o.from)
+	if err != nil {
+		logging.Fatal("Invalid --from", "error", err)
+	}
+	to, err := kafka.ParsePosition(o.to)
+	if err != nil {
+		logging.Fatal("Invalid --to", "error", err)
+	}
+	speed, err := kafka.ParseSpeed(o.speed)
+	if err != nil {
+		logging.Fatal("Invalid --speed", "error", err)
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"from", o.from,
+		"to", o.to,
+		"speed", o.speed,
+		"keep_partitions", o.keepPartitions,
+	}
+	if !o.keepPartitions {
+		settings = append(settings, "partitioner", o.partitioner)
+	}
+	settings = append(settings, "compression", o.compression, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Replay", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithPartitioner(o.partitioner),
+		kafka.WithCompression(o.compression),
+	)
+	var replayer *kafka.Replayer
+	o.resilience.connect(ctx, "replayer", func() (err error) {
+		replayer, err = kafka.NewReplayer(brokers, opts...)
+		return err
+	})
+	defer replayer.Close()
+
+	start := time.Now()
+	sent, err := replayer.Replay(ctx, o.topic, o.outputTopic, kafka.ReplayConfig{
+		From:           from,
+		To:             to,
+		Speed:          speed,
+		KeepPartitions: o.keepPartitions,
+	})
+	if err != nil {
+		logging.Fatal("Replay failed", "messages", sent, "error", err)
+	}
+
+	if ctx.Err() != nil {
+		slog.Info("Replay stopped", "messages", sent, "duration", time.Since(start).Round(time.Millisecond))
+		return
+	}
+	slog.Info("Replay finished", "messages", sent, "duration", time.Since(start).Round(time.Millisecond))
+}
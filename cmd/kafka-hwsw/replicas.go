@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// newElectLeadersCommand returns the admin subcommand that moves leadership
+// back to the preferred replicas. topic is the admin command's --topic.
+func newElectLeadersCommand(topic *string) *cobra.Command {
+	var partitions string
+
+	cmd := &cobra.Command{
+		Use:   "elect-leaders",
+		Short: "Move partition leadership back to the preferred replicas",
+		Long: `Run a preferred leader election: every partition whose leader isn't its
+first replica, e.g. because that broker was down for a while, is handed
+back to it. Without --topic every partition in the cluster is elected.`,
+		Example: "  kafka-hwsw admin elect-leaders -t test-topic\n" +
+			"  kafka-hwsw admin elect-leaders -t test-topic --partitions 0,2\n" +
+			"  kafka-hwsw admin elect-leaders",
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if partitions != "" && *topic == "" {
+				return errors.New("--partitions needs --topic")
+			}
+			return nil
+		},
+		Run: withReplicaAdmin("elect-leaders", func(ctx context.Context, admin *kafka.ReplicaAdmin) error {
+			ids, err := kafka.ParsePartitions(partitions)
+			if err != nil {
+				return err
+			}
+			results, err := admin.ElectPreferredLeaders(ctx, *topic, ids)
+			if err != nil {
+				return err
+			}
+			return printElection(results)
+		}),
+	}
+	cmd.Flags().StringVar(&partitions, "partitions", "", "comma-separated partitions to elect, e.g. 0,2; all if empty")
+	return cmd
+}
+
+// newReassignCommand returns the admin subcommand that moves partition
+// replicas between brokers. topic is the admin command's --topic.
+func newReassignCommand(topic *string) *cobra.Command {
+	var (
+		replicas   []string
+		partitions string
+		cancel     bool
+		wait       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reassign",
+		Short: "Move partition replicas between brokers",
+		Long: `Move the replicas of partitions to other brokers. Each --replicas names a
+partition and the brokers it should live on, the first of which becomes
+its preferred leader; the order alone can be changed too, followed by
+elect-leaders to move the leadership. The controller copies the data in
+the background; --wait follows it until every partition has moved.
+--cancel stops the ongoing reassignment of --partitions, or of all
+partitions of the topic, and moves them back.`,
+		Example: "  kafka-hwsw admin reassign -t test-topic --replicas 0=2,3 --replicas 1=3,1 --wait\n" +
+			"  kafka-hwsw admin reassign -t test-topic --replicas 2=1,2,3\n" +
+			"  kafka-hwsw admin reassign -t test-topic --cancel",
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if *topic == "" {
+				return errors.New("--topic is required")
+			}
+			if cancel == (len(replicas) > 0) {
+				return errors.New("exactly one of --replicas and --cancel is required")
+			}
+			if partitions != "" && !cancel {
+				return errors.New("--partitions only applies to --cancel")
+			}
+			return nil
+		},
+		Run: withReplicaAdmin("reassign", func(ctx context.Context, admin *kafka.ReplicaAdmin) error {
+			var ids []int32
+			if cancel {
+				var err error
+				if ids, err = kafka.ParsePartitions(partitions); err != nil {
+					return err
+				}
+				if err := admin.CancelReassignment(ctx, *topic, ids); err != nil {
+					return err
+				}
+				slog.Info("Cancelled reassignment", "topic", *topic, "partitions", partitions)
+			} else {
+				assignment, err := parseAssignment(replicas)
+				if err != nil {
+					return err
+				}
+				if err := admin.Reassign(ctx, *topic, assignment); err != nil {
+					return err
+				}
+				for id := range assignment {
+					ids = append(ids, id)
+				}
+				slog.Info("Started reassignment", "topic", *topic, "replicas", strings.Join(replicas, " "))
+			}
+			if !wait {
+				return nil
+			}
+
+			err := admin.WaitForReassignment(ctx, *topic, ids, func(remaining []int32) {
+				if len(remaining) > 0 {
+					slog.Info("Reassignment in progress", "topic", *topic, "partitions", joinIDs(remaining))
+				}
+			})
+			if err != nil {
+				return err
+			}
+			slog.Info("Reassignment finished", "topic", *topic)
+			described, err := admin.Describe(*topic)
+			if err != nil {
+				return err
+			}
+			return printReplicas(*topic, described)
+		}),
+	}
+	cmd.Flags().StringArrayVar(&replicas, "replicas", nil, "partition=broker,broker,... for each partition to move, e.g. 0=2,3,1")
+	cmd.Flags().StringVar(&partitions, "partitions", "", "comma-separated partitions whose reassignment --cancel stops; all if empty")
+	cmd.Flags().BoolVar(&cancel, "cancel", false, "stop ongoing reassignments instead of starting one")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait until the partitions have moved")
+	return cmd
+}
+
+// withReplicaAdmin connects a replica admin for the duration of fn. fn's
+// context is cancelled on SIGINT or SIGTERM.
+func withReplicaAdmin(command string, fn func(ctx context.Context, admin *kafka.ReplicaAdmin) error) func(*cobra.Command, []string) {
+	return func(*cobra.Command, []string) {
+		ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+		defer cancel()
+
+		admin, err := kafka.NewReplicaAdmin(brokers, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to connect", "error", err)
+		}
+		defer admin.Close()
+
+		if err := fn(ctx, admin); err != nil {
+			if ctx.Err() != nil {
+				slog.Info("Stopped waiting", "command", command)
+				return
+			}
+			logging.Fatal("Command failed", "command", command, "error", err)
+		}
+	}
+}
+
+// parseAssignment parses "partition=broker,broker,..." values.
+func parseAssignment(values []string) (map[int32][]int32, error) {
+	assignment := make(map[int32][]int32, len(values))
+	for _, value := range values {
+		partition, brokerList, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid replicas %q, expected partition=broker,broker,...", value)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(partition), 10, 32)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid partition in %q", value)
+		}
+		if _, ok := assignment[int32(id)]; ok {
+			return nil, fmt.Errorf("partition %d is given twice", id)
+		}
+		var replicas []int32
+		for _, broker := range strings.Split(brokerList, ",") {
+			b, err := strconv.ParseInt(strings.TrimSpace(broker), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid broker %q in %q", broker, value)
+			}
+			replicas = append(replicas, int32(b))
+		}
+		assignment[int32(id)] = replicas
+	}
+	return assignment, nil
+}
+
+// printReplicas prints the describe table: where each partition's replicas
+// are, whether its preferred replica leads and what is wrong with it.
+func printReplicas(topic string, partitions []kafka.PartitionReplicas) error {
+	fmt.Printf("Topic: %s\tPartitions: %d\n", topic, len(partitions))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "PARTITION\tLEADER\tPREFERRED\tREPLICAS\tISR\tSTATUS\n")
+	for _, p := range partitions {
+		preferred := "yes"
+		if p.Leader != p.Preferred() {
+			preferred = fmt.Sprintf("no (%d)", p.Preferred())
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", p.Partition, p.Leader, preferred,
+			joinIDs(p.Replicas), joinIDs(p.ISR), replicaStatus(p))
+	}
+	return w.Flush()
+}
+
+func replicaStatus(p kafka.PartitionReplicas) string {
+	var status []string
+	switch {
+	case p.Leader < 0:
+		status = append(status, "offline")
+	case p.UnderReplicated():
+		status = append(status, "under-replicated")
+	}
+	if len(p.Offline) > 0 {
+		status = append(status, "down: "+joinIDs(p.Offline))
+	}
+	if p.Reassigning() {
+		status = append(status, fmt.Sprintf("reassigning (adding %s, removing %s)", joinOrNone(p.Adding), joinOrNone(p.Removing)))
+	}
+	if len(status) == 0 {
+		return "ok"
+	}
+	return strings.Join(status, ", ")
+}
+
+func joinOrNone(ids []int32) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	return joinIDs(ids)
+}
+
+func printElection(results []kafka.ElectionResult) error {
+	if len(results) == 0 {
+		fmt.Println("Every partition is led by its preferred replica")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TOPIC\tPARTITION\tRESULT\n")
+	for _, r := range results {
+		result := "elected"
+		switch {
+		case errors.Is(r.Err, sarama.ErrElectionNotNeeded):
+			result = "already preferred"
+		case r.Err != nil:
+			result = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", r.Topic, r.Partition, result)
+	}
+	return w.Flush()
+}
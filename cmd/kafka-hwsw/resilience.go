@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// resilienceOptions are the flags shared by the clients that decide
+// how they ride out brokers that are down or unreachable. The circuit
+// breaker only guards sends, so only produce registers its flags.
+type resilienceOptions struct {
+	backoff          time.Duration
+	backoffMax       time.Duration
+	budget           int
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+func (o *resilienceOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.DurationVar(&o.backoff, "retry-backoff", kafka.DefaultBackoff.Initial, "delay before the first retry after a broker failure, doubled (with jitter) for every further one")
+	bindEnv(flags, "retry-backoff", "RETRY_BACKOFF")
+	flags.DurationVar(&o.backoffMax, "retry-backoff-max", kafka.DefaultBackoff.Max, "longest delay between retries")
+	bindEnv(flags, "retry-backoff-max", "RETRY_BACKOFF_MAX")
+	flags.IntVar(&o.budget, "retry-budget", kafka.DefaultBackoff.MaxRetries, "retries in a row before giving up, 0 fails on the first error")
+	bindEnv(flags, "retry-budget", "RETRY_BUDGET")
+}
+
+func (o *resilienceOptions) addBreakerFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.IntVar(&o.breakerThreshold, "breaker-threshold", 5, "failed sends in a row that open the circuit breaker, 0 disables it")
+	bindEnv(flags, "breaker-threshold", "BREAKER_THRESHOLD")
+	flags.DurationVar(&o.breakerCooldown, "breaker-cooldown", 30*time.Second, "how long an open circuit breaker rejects sends before trying one")
+	bindEnv(flags, "breaker-cooldown", "BREAKER_COOLDOWN")
+}
+
+func (o resilienceOptions) newBackoff() kafka.Backoff {
+	b := kafka.DefaultBackoff
+	b.Initial = o.backoff
+	b.Max = o.backoffMax
+	b.MaxRetries = o.budget
+	return b
+}
+
+// options returns the client options for the flags.
+func (o resilienceOptions) options() []kafka.Option {
+	return []kafka.Option{
+		kafka.WithBackoff(o.newBackoff()),
+		kafka.WithCircuitBreaker(o.breakerThreshold, o.breakerCooldown),
+	}
+}
+
+// settings returns the options worth logging at startup.
+func (o resilienceOptions) settings() []any {
+	settings := []any{"retry_backoff", o.backoff, "retry_backoff_max", o.backoffMax, "retry_budget", o.budget}
+	if o.breakerThreshold > 0 {
+		settings = append(settings, "breaker_threshold", o.breakerThreshold, "breaker_cooldown", o.breakerCooldown)
+	}
+	return settings
+}
+
+// connect calls create until it succeeds, retrying with the backoff while no
+// broker can be reached, and exits if it never does. what names the client in
+// the logs, e.g. "producer".
+func (o resilienceOptions) connect(ctx context.Context, what string, create func() error) {
+	err := o.newBackoff().Retry(ctx, "Connecting "+what, func() error {
+		err := create()
+		if err != nil && !errors.Is(err, sarama.ErrOutOfBrokers) {
+			// Invalid settings won't get any better by retrying.
+			return kafka.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		logging.Fatal("Failed to create "+what, "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/restproxy"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type restProxyOptions struct {
+	port        int
+	partitioner string
+	compression string
+	resilience  resilienceOptions
+}
+
+func newRestProxyCommand() *cobra.Command {
+	var o restProxyOptions
+
+	cmd := &cobra.Command{
+		Use:   "rest-proxy",
+		Short: "Produce over HTTP like a minimal Confluent REST Proxy",
+		Long: `Serve a small subset of the Confluent REST Proxy v2 API for local
+development, so tools and scripts written against it can produce without a
+Kafka client:
+
+  POST /topics/{topic}             produce one record or {"records": [...]}
+  GET  /topics/{topic}/partitions  partitions with leader and replicas
+  GET  /topics                     topic names
+
+Records are JSON ({"key": ..., "value": ..., "partition": ...}) or, with
+Content-Type application/vnd.kafka.binary.v2+json, base64 strings. String
+keys are sent as plain strings, so they land on the same partitions as the
+keys of the produce command. Every request gets the partition and offset of
+each record, or its error.`,
+		Example: "  kafka-hwsw rest-proxy --port 8082\n" +
+			"  curl -X POST localhost:8082/topics/test-topic -H 'Content-Type: application/vnd.kafka.json.v2+json' \\\n" +
+			"    -d '{\"records\":[{\"key\":\"user-1\",\"value\":{\"user_id\":\"user-1\",\"event_type\":\"login\"}}]}'",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runRestProxy(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&o.port, "port", 8082, "port to serve the API on")
+	bindEnv(flags, "port", "REST_PROXY_PORT")
+	flags.StringVar(&o.partitioner, "partitioner", kafka.PartitionerHash, "partitioner for records without a partition: hash, murmur2, roundrobin or random")
+	bindEnv(flags, "partitioner", "KAFKA_PARTITIONER")
+	flags.StringVar(&o.compression, "compression", "snappy", "compression codec: none, gzip, snappy, lz4 or zstd")
+	bindEnv(flags, "compression", "KAFKA_COMPRESSION")
+	o.resilience.addFlags(cmd)
+
+	completeValues(cmd, "partitioner", kafka.PartitionerHash, kafka.PartitionerMurmur2,
+		kafka.PartitionerRoundRobin, kafka.PartitionerRandom)
+	completeValues(cmd, "compression", kafka.CompressionCodecs...)
+	return cmd
+}
+
+func runRestProxy(o restProxyOptions) {
+	if o.partitioner == kafka.PartitionerManual {
+		logging.Fatal("--partitioner manual isn't supported, records pick their partition with the partition field")
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"port", o.port,
+		"partitioner", o.partitioner,
+		"compression", o.compression,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting REST Proxy", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithPartitioner(o.partitioner),
+		kafka.WithCompression(o.compression),
+	)
+	var producer *kafka.RecordProducer
+	o.resilience.connect(ctx, "producer", func() (err error) {
+		producer, err = kafka.NewRecordProducer(brokers, opts...)
+		return err
+	})
+	defer producer.Close()
+
+	var admin sarama.ClusterAdmin
+	o.resilience.connect(ctx, "cluster admin", func() (err error) {
+		admin, err = kafka.NewClusterAdmin(brokers, clientOptions()...)
+		return err
+	})
+	defer admin.Close()
+
+	server := serveHTTP("REST proxy", o.port, restproxy.New(producer, admin))
+	slog.Info("REST Proxy available", "url", fmt.Sprintf("http://localhost:%d/topics", o.port))
+
+	<-ctx.Done()
+	shutdownCtx, stop := context.WithTimeout(context.Background(), closeGrace)
+	defer stop()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to finish open requests", "error", err)
+	}
+	slog.Info("REST Proxy stopped")
+}
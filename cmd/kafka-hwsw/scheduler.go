@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type schedulerOptions struct {
+	topic           string
+	schedulerTopics []string
+	groupID         string
+	maxPending      int
+	shutdownTimeout time.Duration
+	resilience      resilienceOptions
+}
+
+func newSchedulerCommand() *cobra.Command {
+	var o schedulerOptions
+
+	cmd := &cobra.Command{
+		Use:   "scheduler",
+		Short: "Deliver delayed messages from a scheduler topic once they are due",
+		Long: `Consume the scheduler topics that produce --deliver-after writes to, hold
+every message until the time in its deliver-after header and then produce
+it to the topic in its deliver-to header, with the same key, value and
+headers. Together they emulate a delayed queue, which Kafka has none of.
+
+Messages are held in memory by due time, so they don't have to arrive in
+due order, and a partition's offset is only committed up to the oldest
+message not delivered yet. A restart delivers the messages due after that
+one again.`,
+		Example: "  kafka-hwsw scheduler -t test-topic\n" +
+			"  kafka-hwsw produce -t test-topic --deliver-after 30s\n" +
+			"  kafka-hwsw scheduler --scheduler-topics orders-scheduled,payments-scheduled",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runScheduler(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic whose scheduler topic <topic>-scheduled to serve")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringSliceVar(&o.schedulerTopics, "scheduler-topics", nil, "scheduler topics to serve (overrides --topic)")
+	bindEnv(flags, "scheduler-topics", "SCHEDULER_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "kafka-hwsw-scheduler", "consumer group ID")
+	bindEnv(flags, "group", "SCHEDULER_GROUP_ID")
+	flags.IntVar(&o.maxPending, "max-pending", kafka.DefaultSchedulerMaxPending, "messages one partition holds before the scheduler stops reading it until the first is due")
+	bindEnv(flags, "max-pending", "SCHEDULER_MAX_PENDING")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long the messages being delivered may take on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runScheduler(o schedulerOptions) {
+	topics := o.schedulerTopics
+	if len(topics) == 0 {
+		topics = []string{kafka.ScheduledTopic(o.topic)}
+	}
+	if o.maxPending < 1 {
+		logging.Fatal("--max-pending must be at least 1", "max_pending", o.maxPending)
+	}
+
+	settings := []any{
+		"brokers", brokers,
+		"scheduler_topics", topics,
+		"group", o.groupID,
+		"max_pending", o.maxPending,
+		"tls", tlsConfig.Enabled,
+		"shutdown_timeout", o.shutdownTimeout,
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Scheduler", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(), kafka.WithShutdownTimeout(o.shutdownTimeout))
+	opts = append(opts, o.resilience.options()...)
+
+	var scheduler *kafka.Scheduler
+	o.resilience.connect(ctx, "scheduler", func() (err error) {
+		scheduler, err = kafka.NewScheduler(brokers, o.groupID, kafka.SchedulerConfig{
+			Topics:     topics,
+			MaxPending: o.maxPending,
+		}, opts...)
+		return err
+	})
+	defer scheduler.Close()
+
+	if err := scheduler.Consume(ctx); err != nil {
+		logging.Fatal("Error scheduling messages", "error", err)
+	}
+
+	slog.Info("Scheduler stopped")
+}
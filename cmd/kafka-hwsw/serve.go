@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"kafka-hwsw/internal/dashboard"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// serveHTTP serves handler on port in the background. The returned server
+// should be shut down on exit.
+func serveHTTP(name string, port int, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(name+" server stopped", "error", err)
+		}
+	}()
+	return server
+}
+
+// serveHealth serves /healthz and /readyz for health on port.
+func serveHealth(port int, health *kafka.HealthCheck) *http.Server {
+	server := serveHTTP("Health", port, health.Handler())
+	slog.Info("Health endpoints available", "liveness", fmt.Sprintf("http://localhost:%d/healthz", port),
+		"readiness", fmt.Sprintf("http://localhost:%d/readyz", port))
+	return server
+}
+
+// serveDashboard serves the web dashboard d on port.
+func serveDashboard(port int, d *dashboard.Dashboard) *http.Server {
+	server := serveHTTP("Dashboard", port, d.Handler())
+	slog.Info("Dashboard available", "url", fmt.Sprintf("http://localhost:%d/", port))
+	return server
+}
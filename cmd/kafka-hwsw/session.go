@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type sessionOptions struct {
+	topic       string
+	outputTopic string
+	groupID     string
+	registryURL string
+	windows     kafka.SessionWindows
+	resilience  resilienceOptions
+}
+
+func newSessionCommand() *cobra.Command {
+	var o sessionOptions
+
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Group each user's events into sessions separated by inactivity",
+		Long: `Group each user's events into sessions by event time, using the timestamp
+in the payload: a session ends once --session-gap passes without an event
+of the user. When the watermark (the newest event time minus the allowed
+lateness) passes a session's last event plus the gap, a summary with its
+duration, event counts per type and purchase total is published to the
+output topic. An event that arrives out of order extends or merges the
+sessions it falls between; one whose session would have closed already is
+dropped.`,
+		Example: "  kafka-hwsw session -t test-topic --session-gap 30m\n" +
+			"  kafka-hwsw session --session-gap 5m --allowed-lateness 30s --output-topic user-sessions",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSession(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to sessionize, keyed by user ID")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "user-sessions", "topic the session summaries are published to")
+	bindEnv(flags, "output-topic", "SESSION_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "user-sessionizer", "consumer group ID")
+	bindEnv(flags, "group", "SESSION_GROUP_ID")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.DurationVar(&o.windows.Gap, "session-gap", kafka.DefaultSessionWindows.Gap, "inactivity after which a session ends")
+	bindEnv(flags, "session-gap", "SESSION_GAP")
+	flags.DurationVar(&o.windows.Lateness, "allowed-lateness", kafka.DefaultSessionWindows.Lateness, "how far behind the newest event time events may arrive")
+	bindEnv(flags, "allowed-lateness", "SESSION_LATENESS")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runSession(o sessionOptions) {
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"session_gap", o.windows.Gap,
+		"allowed_lateness", o.windows.Lateness,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Sessionizer", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithSessionWindows(o.windows),
+	)
+	opts = append(opts, o.resilience.options()...)
+
+	var sessionizer *kafka.Sessionizer
+	o.resilience.connect(ctx, "sessionizer", func() (err error) {
+		sessionizer, err = kafka.NewSessionizer(brokers, o.topic, o.outputTopic, o.groupID, opts...)
+		return err
+	})
+	defer sessionizer.Close()
+
+	if err := sessionizer.Consume(ctx); err != nil {
+		logging.Fatal("Error sessionizing events", "error", err)
+	}
+
+	slog.Info("Sessionizer stopped")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/pkg/kafka"
+)
+
+const shellHelp = `Commands:
+  send <key> <json>        send a message to the current topic; - sends it without a key
+  delete <key>             send a tombstone for key to the current topic
+  use <topic>              switch the current topic
+  tail [topic] [n]         print the last n messages of every partition (default 5), then follow until Ctrl+C
+  offsets <group>          committed offsets and lag of a consumer group
+  topics                   list topics
+  describe [topic]         partitions, leaders, replicas and ISR
+  help                     show this help
+  exit                     leave the shell`
+
+type shellOptions struct {
+	topic       string
+	registryURL string
+}
+
+func newShellCommand() *cobra.Command {
+	var o shellOptions
+
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Interactive prompt for sending, tailing and inspecting offsets",
+		Long: `Open an interactive prompt against the cluster for quick manual testing:
+send ad-hoc messages, tail topics and look at consumer group offsets.
+
+` + shellHelp + `
+
+Commands can also be piped in, one per line.`,
+		Example: "  kafka-hwsw shell -t user-events\n" +
+			"  echo 'send user-1 {\"user_id\":\"user-1\",\"event_type\":\"login\"}' | kafka-hwsw shell",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runShell(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic send and tail use until switched with use")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for reading avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	return cmd
+}
+
+// shell holds the connections of an interactive session. Producers are
+// created per topic the first time something is sent to it.
+type shell struct {
+	topic     string
+	admin     sarama.ClusterAdmin
+	tailer    *kafka.Tailer
+	producers map[string]*kafka.Producer
+	out       io.Writer
+}
+
+func runShell(o shellOptions) {
+	admin, err := kafka.NewClusterAdmin(brokers, clientOptions()...)
+	if err != nil {
+		logging.Fatal("Failed to connect", "brokers", brokers, "error", err)
+	}
+	tailer, err := kafka.NewTailer(brokers, append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...))...)
+	if err != nil {
+		admin.Close()
+		logging.Fatal("Failed to connect", "brokers", brokers, "error", err)
+	}
+
+	s := &shell{
+		topic:     o.topic,
+		admin:     admin,
+		tailer:    tailer,
+		producers: make(map[string]*kafka.Producer),
+		out:       os.Stdout,
+	}
+	defer s.close()
+
+	// Only prompt when a person is typing, not when commands are piped in.
+	interactive := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+		fmt.Fprintf(s.out, "Connected to %s. Type help for the commands.\n", strings.Join(brokers, ","))
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for {
+		if interactive {
+			fmt.Fprintf(s.out, "%s> ", s.topic)
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			return
+		}
+		if err := s.run(line); err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+	if interactive {
+		fmt.Fprintln(s.out)
+	}
+}
+
+// run executes one command line.
+func (s *shell) run(line string) error {
+	name, rest := cutField(line)
+	switch name {
+	case "help", "?":
+		fmt.Fprintln(s.out, shellHelp)
+		return nil
+
+	case "send":
+		key, value := cutField(rest)
+		if key == "" || value == "" {
+			return errors.New("usage: send <key> <json>")
+		}
+		return s.send(key, value)
+
+	case "delete":
+		key, extra := cutField(rest)
+		if key == "" || extra != "" {
+			return errors.New("usage: delete <key>")
+		}
+		return s.delete(key)
+
+	case "use":
+		topic, extra := cutField(rest)
+		if topic == "" || extra != "" {
+			return errors.New("usage: use <topic>")
+		}
+		s.topic = topic
+		return nil
+
+	case "tail":
+		topic, count := s.topic, int64(5)
+		for _, arg := range strings.Fields(rest) {
+			if n, err := strconv.ParseInt(arg, 10, 64); err == nil && n >= 0 {
+				count = n
+			} else {
+				topic = arg
+			}
+		}
+		return s.tail(topic, count)
+
+	case "offsets":
+		group, extra := cutField(rest)
+		if group == "" || extra != "" {
+			return errors.New("usage: offsets <group>")
+		}
+		return s.offsets(group)
+
+	case "topics":
+		return listTopics(s.admin)
+
+	case "describe":
+		topic, _ := cutField(rest)
+		if topic == "" {
+			topic = s.topic
+		}
+		return describeTopic(s.admin, topic)
+
+	default:
+		return fmt.Errorf("unknown command %q, type help for the commands", name)
+	}
+}
+
+func (s *shell) send(key, value string) error {
+	if !json.Valid([]byte(value)) {
+		return errors.New("the value is not valid JSON")
+	}
+
+	producer, err := s.producer()
+	if err != nil {
+		return err
+	}
+	if key == "-" {
+		key = ""
+	}
+	partition, offset, err := producer.SendMessage(key, value)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "sent to %s partition %d offset %d\n", s.topic, partition, offset)
+	return nil
+}
+
+func (s *shell) delete(key string) error {
+	producer, err := s.producer()
+	if err != nil {
+		return err
+	}
+	partition, offset, err := producer.SendTombstone(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "tombstone sent to %s partition %d offset %d\n", s.topic, partition, offset)
+	return nil
+}
+
+// producer returns the producer of the current topic, creating it on first
+// use.
+func (s *shell) producer() (*kafka.Producer, error) {
+	producer, ok := s.producers[s.topic]
+	if !ok {
+		var err error
+		producer, err = kafka.NewProducer(brokers, s.topic, append(clientOptions(),
+			kafka.WithConfigFunc(func(config *sarama.Config) error {
+				// Fail fast rather than retrying for minutes at the prompt.
+				config.Producer.Retry.Max = 1
+				return nil
+			}))...)
+		if err != nil {
+			return nil, err
+		}
+		s.producers[s.topic] = producer
+	}
+	return producer, nil
+}
+
+// tail prints messages until Ctrl+C, which ends the tail but not the shell.
+func (s *shell) tail(topic string, count int64) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(s.out, "Tailing %s, Ctrl+C to stop\n", topic)
+	return s.tailer.Tail(ctx, topic, count, func(message *kafka.Message) {
+		value := string(message.Value)
+		if event, err := s.tailer.DecodeEvent(message.Raw()); err == nil {
+			if decoded, err := json.Marshal(event); err == nil {
+				value = string(decoded)
+			}
+		}
+		if message.Value == nil {
+			value = "(tombstone)"
+		}
+		key := string(message.Key)
+		if message.Key == nil {
+			key = "-"
+		}
+		fmt.Fprintf(s.out, "%d/%d  %s  %s  %s\n", message.Partition, message.Offset,
+			message.Timestamp.Format(time.RFC3339), key, value)
+	})
+}
+
+func (s *shell) offsets(group string) error {
+	monitor, err := kafka.NewLagMonitor(brokers, group, "", clientOptions()...)
+	if err != nil {
+		return err
+	}
+	defer monitor.Close()
+
+	lags, err := monitor.Lag()
+	if err != nil {
+		return err
+	}
+	if len(lags) == 0 {
+		fmt.Fprintf(s.out, "Group %s has no committed offsets\n", group)
+		return nil
+	}
+	printLag(group, lags)
+	return nil
+}
+
+func (s *shell) close() {
+	for topic, producer := range s.producers {
+		if err := producer.Close(); err != nil {
+			fmt.Fprintf(s.out, "error: failed to close producer for %s: %v\n", topic, err)
+		}
+	}
+	s.tailer.Close()
+	s.admin.Close()
+}
+
+// cutField splits off the first whitespace-separated field of s.
+func cutField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/checkpoint"
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/internal/sink"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type sinkOptions struct {
+	topic           string
+	groupID         string
+	kind            string
+	registryURL     string
+	batchMessages   int
+	batchBytes      int
+	flushInterval   time.Duration
+	shutdownTimeout time.Duration
+	config          sink.Config
+	webhookDLQTopic string
+	checkpointStore string
+	checkpoint      checkpoint.Config
+	resilience      resilienceOptions
+}
+
+func newSinkCommand() *cobra.Command {
+	var o sinkOptions
+
+	cmd := &cobra.Command{
+		Use:   "sink",
+		Short: "Write a topic to newline-delimited JSON files, S3, Postgres, Redis, Elasticsearch, ClickHouse or a webhook",
+		Long: `Consume a topic and write its messages in batches to a sink: rotating
+newline-delimited JSON files, S3 objects or a Postgres table. Each partition
+is batched separately and a batch is written once it is full or old enough;
+its offsets are only committed after the sink has durably stored it, so a
+crash may write the last batches again but never loses messages.
+
+With --checkpoint-store the offsets are checkpointed in Redis or Postgres
+instead of being committed to Kafka, and each partition resumes from its
+checkpoint on startup. Postgres checkpoints default to the sink's database.
+
+The table sink materializes the topic instead: one row per key holding its
+latest message, which a tombstone deletes, like a compacted topic. The redis sink caches the latest message of every
+key with a TTL, to be read with kafka-hwsw cache. The elasticsearch sink
+bulk-indexes every message as a document into daily indices, which also
+works with OpenSearch. The clickhouse sink inserts events as rows for
+analytics, buffering the batches of all partitions into larger inserts.
+The webhook sink POSTs every message to a URL, a few at a time, retrying
+failed requests and dead-lettering messages the receiver rejects.
+
+S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+AWS_SESSION_TOKEN, and Elasticsearch and ClickHouse credentials, if
+needed, from ELASTICSEARCH_USERNAME, ELASTICSEARCH_PASSWORD,
+CLICKHOUSE_USER and CLICKHOUSE_PASSWORD. Webhook requests are signed
+with the secret in WEBHOOK_SECRET, if set.`,
+		Example: "  kafka-hwsw sink -t test-topic --sink file --file-dir ./data\n" +
+			"  kafka-hwsw sink -t test-topic --sink s3 --s3-bucket events --s3-endpoint http://localhost:9000\n" +
+			"  kafka-hwsw sink -t test-topic --sink postgres --postgres-dsn postgres://localhost/events?sslmode=disable\n" +
+			"  kafka-hwsw sink -t user-state -g user-state-table --sink table --postgres-dsn postgres://localhost/events?sslmode=disable\n" +
+			"  kafka-hwsw sink -t test-topic -g user-cache --sink redis --redis-ttl 1h\n" +
+			"  kafka-hwsw sink -t test-topic -g event-search --sink elasticsearch --elasticsearch-url http://localhost:9200\n" +
+			"  kafka-hwsw sink -t test-topic -g event-analytics --sink clickhouse --clickhouse-batch-size 50000\n" +
+			"  kafka-hwsw sink -t test-topic -g event-webhook --sink webhook --webhook-url http://localhost:8090/events --webhook-dlq-topic webhook-dlq\n" +
+			"  kafka-hwsw sink -t test-topic --sink file --checkpoint-store redis --checkpoint-redis-url redis://localhost:6379/0",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSink(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to write to the sink")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "event-sink", "consumer group ID")
+	bindEnv(flags, "group", "SINK_GROUP_ID")
+	flags.StringVar(&o.kind, "sink", sink.KindFile, "sink to write to: file, s3, postgres, table, redis, elasticsearch, clickhouse or webhook")
+	bindEnv(flags, "sink", "SINK")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.IntVar(&o.batchMessages, "batch-size", 500, "messages per batch")
+	bindEnv(flags, "batch-size", "SINK_BATCH_SIZE")
+	flags.IntVar(&o.batchBytes, "batch-bytes", 5<<20, "bytes of message values per batch")
+	bindEnv(flags, "batch-bytes", "SINK_BATCH_BYTES")
+	flags.DurationVar(&o.flushInterval, "flush-interval", 10*time.Second, "how long a batch may collect messages before it is written")
+	bindEnv(flags, "flush-interval", "SINK_FLUSH_INTERVAL")
+	flags.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long the open batches may take to be written on shutdown")
+	bindEnv(flags, "shutdown-timeout", "SHUTDOWN_TIMEOUT")
+
+	flags.StringVar(&o.config.FileDir, "file-dir", "sink", "directory the file sink writes to")
+	bindEnv(flags, "file-dir", "SINK_FILE_DIR")
+	flags.StringVar(&o.config.FilePrefix, "file-prefix", "events", "name prefix of the files")
+	bindEnv(flags, "file-prefix", "SINK_FILE_PREFIX")
+	flags.Int64Var(&o.config.FileMaxBytes, "file-max-bytes", 100<<20, "size after which a new file is started; 0 for no limit")
+	bindEnv(flags, "file-max-bytes", "SINK_FILE_MAX_BYTES")
+	flags.DurationVar(&o.config.FileMaxAge, "file-rotate-interval", time.Hour, "age after which a new file is started; 0 for no limit")
+	bindEnv(flags, "file-rotate-interval", "SINK_FILE_ROTATE_INTERVAL")
+
+	flags.StringVar(&o.config.S3Bucket, "s3-bucket", "", "bucket the S3 sink uploads to")
+	bindEnv(flags, "s3-bucket", "SINK_S3_BUCKET")
+	flags.StringVar(&o.config.S3Prefix, "s3-prefix", "", "key prefix of the uploaded objects")
+	bindEnv(flags, "s3-prefix", "SINK_S3_PREFIX")
+	flags.StringVar(&o.config.S3Region, "s3-region", "us-east-1", "bucket region")
+	bindEnv(flags, "s3-region", "SINK_S3_REGION")
+	flags.StringVar(&o.config.S3Endpoint, "s3-endpoint", "", "endpoint of an S3-compatible store such as MinIO")
+	bindEnv(flags, "s3-endpoint", "SINK_S3_ENDPOINT")
+
+	flags.StringVar(&o.config.PostgresDSN, "postgres-dsn", "", "connection string of the Postgres sink")
+	bindEnv(flags, "postgres-dsn", "SINK_POSTGRES_DSN")
+	flags.StringVar(&o.config.PostgresTable, "postgres-table", "events", "table the Postgres sink inserts into")
+	bindEnv(flags, "postgres-table", "SINK_POSTGRES_TABLE")
+	flags.StringVar(&o.config.MaterializedTable, "materialized-table", "user_state", "table the table sink keeps the latest message of every key in")
+	bindEnv(flags, "materialized-table", "SINK_MATERIALIZED_TABLE")
+	flags.StringVar(&o.config.RedisURL, "redis-url", "redis://localhost:6379/0", "URL of the Redis sink")
+	bindEnv(flags, "redis-url", "SINK_REDIS_URL")
+	flags.StringVar(&o.config.RedisPrefix, "redis-prefix", "latest:", "prefix of the Redis sink's keys")
+	bindEnv(flags, "redis-prefix", "SINK_REDIS_PREFIX")
+	flags.DurationVar(&o.config.RedisTTL, "redis-ttl", 24*time.Hour, "how long a key's latest message stays cached after it was written; 0 to keep it")
+	bindEnv(flags, "redis-ttl", "SINK_REDIS_TTL")
+	flags.StringVar(&o.config.ElasticsearchURL, "elasticsearch-url", "http://localhost:9200", "URL of the Elasticsearch or OpenSearch cluster")
+	bindEnv(flags, "elasticsearch-url", "SINK_ELASTICSEARCH_URL")
+	flags.StringVar(&o.config.ElasticsearchIndex, "elasticsearch-index", "events", "prefix of the daily indices and name of their index template")
+	bindEnv(flags, "elasticsearch-index", "SINK_ELASTICSEARCH_INDEX")
+	flags.StringVar(&o.config.ClickHouseURL, "clickhouse-url", "http://localhost:8123", "URL of the ClickHouse HTTP interface")
+	bindEnv(flags, "clickhouse-url", "SINK_CLICKHOUSE_URL")
+	flags.StringVar(&o.config.ClickHouseDatabase, "clickhouse-database", "default", "database of the ClickHouse table")
+	bindEnv(flags, "clickhouse-database", "SINK_CLICKHOUSE_DATABASE")
+	flags.StringVar(&o.config.ClickHouseTable, "clickhouse-table", "user_events", "table the ClickHouse sink inserts into")
+	bindEnv(flags, "clickhouse-table", "SINK_CLICKHOUSE_TABLE")
+	flags.IntVar(&o.config.ClickHouseBatchSize, "clickhouse-batch-size", 10000, "rows of all partitions buffered before an insert")
+	bindEnv(flags, "clickhouse-batch-size", "SINK_CLICKHOUSE_BATCH_SIZE")
+	flags.DurationVar(&o.config.ClickHouseFlushInterval, "clickhouse-flush-interval", time.Second, "longest time rows are buffered before an insert")
+	bindEnv(flags, "clickhouse-flush-interval", "SINK_CLICKHOUSE_FLUSH_INTERVAL")
+	flags.StringVar(&o.config.WebhookURL, "webhook-url", "", "URL the webhook sink POSTs every message to")
+	bindEnv(flags, "webhook-url", "SINK_WEBHOOK_URL")
+	flags.IntVar(&o.config.WebhookConcurrency, "webhook-concurrency", 8, "requests in flight at a time, across all partitions")
+	bindEnv(flags, "webhook-concurrency", "SINK_WEBHOOK_CONCURRENCY")
+	flags.DurationVar(&o.config.WebhookTimeout, "webhook-timeout", 10*time.Second, "how long a request may take")
+	bindEnv(flags, "webhook-timeout", "SINK_WEBHOOK_TIMEOUT")
+	flags.IntVar(&o.config.WebhookRetries, "webhook-retries", 5, "retries of a request that failed with a network error, 5xx, 408 or 429")
+	bindEnv(flags, "webhook-retries", "SINK_WEBHOOK_RETRIES")
+	flags.StringVar(&o.webhookDLQTopic, "webhook-dlq-topic", "", "dead-letter topic for messages the webhook rejects with a 4xx; dropped if empty")
+	bindEnv(flags, "webhook-dlq-topic", "SINK_WEBHOOK_DLQ_TOPIC")
+
+	flags.StringVar(&o.checkpointStore, "checkpoint-store", "", "checkpoint offsets in redis or postgres instead of committing them to Kafka")
+	bindEnv(flags, "checkpoint-store", "CHECKPOINT_STORE")
+	flags.StringVar(&o.checkpoint.RedisURL, "checkpoint-redis-url", "redis://localhost:6379/0", "URL of the Redis checkpoint store")
+	bindEnv(flags, "checkpoint-redis-url", "CHECKPOINT_REDIS_URL")
+	flags.StringVar(&o.checkpoint.PostgresDSN, "checkpoint-postgres-dsn", "", "connection string of the Postgres checkpoint store (default: --postgres-dsn)")
+	bindEnv(flags, "checkpoint-postgres-dsn", "CHECKPOINT_POSTGRES_DSN")
+	flags.StringVar(&o.checkpoint.PostgresTable, "checkpoint-table", "kafka_checkpoints", "table the Postgres checkpoint store keeps the offsets in")
+	bindEnv(flags, "checkpoint-table", "CHECKPOINT_TABLE")
+	completeValues(cmd, "sink", sink.Kinds...)
+	completeValues(cmd, "checkpoint-store", checkpoint.Kinds...)
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runSink(o sinkOptions) {
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"group", o.groupID,
+		"sink", o.kind,
+		"batch_size", o.batchMessages,
+		"batch_bytes", o.batchBytes,
+		"flush_interval", o.flushInterval,
+	}
+	switch o.kind {
+	case sink.KindFile:
+		settings = append(settings, "dir", o.config.FileDir, "max_bytes", o.config.FileMaxBytes, "rotate_interval", o.config.FileMaxAge)
+	case sink.KindS3:
+		settings = append(settings, "bucket", o.config.S3Bucket, "prefix", o.config.S3Prefix, "region", o.config.S3Region)
+		if o.config.S3Endpoint != "" {
+			settings = append(settings, "endpoint", o.config.S3Endpoint)
+		}
+	case sink.KindPostgres:
+		settings = append(settings, "table", o.config.PostgresTable)
+	case sink.KindTable:
+		settings = append(settings, "table", o.config.MaterializedTable)
+	case sink.KindRedis:
+		settings = append(settings, "prefix", o.config.RedisPrefix, "ttl", o.config.RedisTTL)
+	case sink.KindElasticsearch:
+		settings = append(settings, "elasticsearch_url", o.config.ElasticsearchURL, "index", o.config.ElasticsearchIndex)
+	case sink.KindClickHouse:
+		settings = append(settings, "clickhouse_url", o.config.ClickHouseURL, "table", o.config.ClickHouseDatabase+"."+o.config.ClickHouseTable,
+			"clickhouse_batch_size", o.config.ClickHouseBatchSize, "clickhouse_flush_interval", o.config.ClickHouseFlushInterval)
+	case sink.KindWebhook:
+		settings = append(settings, "webhook_concurrency", o.config.WebhookConcurrency, "webhook_timeout", o.config.WebhookTimeout,
+			"webhook_retries", o.config.WebhookRetries, "signed", os.Getenv("WEBHOOK_SECRET") != "")
+		if o.webhookDLQTopic != "" {
+			settings = append(settings, "dlq_topic", o.webhookDLQTopic)
+		}
+	}
+	if o.checkpoint.PostgresDSN == "" {
+		o.checkpoint.PostgresDSN = o.config.PostgresDSN
+	}
+	switch o.checkpointStore {
+	case checkpoint.KindRedis:
+		settings = append(settings, "checkpoint_store", o.checkpointStore)
+	case checkpoint.KindPostgres:
+		settings = append(settings, "checkpoint_store", o.checkpointStore, "checkpoint_table", o.checkpoint.PostgresTable)
+	}
+	settings = append(settings, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Sink", settings...)
+
+	o.config.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	o.config.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	o.config.S3SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	o.config.ElasticsearchUsername = os.Getenv("ELASTICSEARCH_USERNAME")
+	o.config.ElasticsearchPassword = os.Getenv("ELASTICSEARCH_PASSWORD")
+	o.config.ClickHouseUsername = os.Getenv("CLICKHOUSE_USER")
+	o.config.ClickHousePassword = os.Getenv("CLICKHOUSE_PASSWORD")
+	o.config.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if o.kind == sink.KindWebhook && o.webhookDLQTopic != "" {
+		producer, err := kafka.NewProducer(brokers, o.webhookDLQTopic, clientOptions()...)
+		if err != nil {
+			logging.Fatal("Failed to create dead-letter producer", "dlq_topic", o.webhookDLQTopic, "error", err)
+		}
+		defer producer.Close()
+		o.config.WebhookDeadLetter = producer
+	}
+	target, err := sink.New(o.kind, o.config)
+	if err != nil {
+		logging.Fatal("Failed to create sink", "sink", o.kind, "error", err)
+	}
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), o.shutdownTimeout+closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithSinkBatch(o.batchMessages, o.batchBytes, o.flushInterval),
+		kafka.WithShutdownTimeout(o.shutdownTimeout),
+	)
+	if o.checkpointStore != "" {
+		store, err := checkpoint.New(o.checkpointStore, o.checkpoint)
+		if err != nil {
+			logging.Fatal("Failed to create checkpoint store", "checkpoint_store", o.checkpointStore, "error", err)
+		}
+		opts = append(opts, kafka.WithCheckpointStore(store))
+	}
+	opts = append(opts, o.resilience.options()...)
+
+	var consumer *kafka.SinkConsumer
+	o.resilience.connect(ctx, "sink consumer", func() (err error) {
+		consumer, err = kafka.NewSinkConsumer(brokers, o.topic, o.groupID, target, opts...)
+		return err
+	})
+	defer consumer.Close()
+
+	if err := consumer.Consume(ctx); err != nil {
+		logging.Fatal("Error writing messages", "error", err)
+	}
+
+	slog.Info("Sink stopped")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type streamJoinOptions struct {
+	topic       string
+	outputTopic string
+	groupID     string
+	registryURL string
+	window      kafka.JoinWindow
+	resilience  resilienceOptions
+}
+
+func newStreamJoinCommand() *cobra.Command {
+	var o streamJoinOptions
+
+	cmd := &cobra.Command{
+		Use:   "stream-join",
+		Short: "Attribute purchases to the page views before them in an event-time window",
+		Long: `Join two streams: the page_view and purchase events of a topic, by user.
+Every page view that happened up to --join-window before a purchase, by the
+timestamp in the payload, is published to the output topic together with
+the purchase as an attribution record. A page view that arrives after its
+purchase still joins it.
+
+Both sides are buffered per partition until the watermark (the newest event
+time minus --grace) has passed them; events behind the watermark are
+dropped as late. A partition buffers at most --max-buffered events and
+evicts the oldest beyond that.`,
+		Example: "  kafka-hwsw stream-join -t user-events --output-topic purchase-attributions\n" +
+			"  kafka-hwsw stream-join --join-window 30m --grace 1m --max-buffered 50000",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runStreamJoin(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic of page views and purchases, keyed by user ID")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "purchase-attributions", "topic the attributions are published to")
+	bindEnv(flags, "output-topic", "ATTRIBUTION_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "purchase-attribution", "consumer group ID")
+	bindEnv(flags, "group", "ATTRIBUTION_GROUP_ID")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.DurationVar(&o.window.Size, "join-window", kafka.DefaultJoinWindow.Size, "how long before a purchase its page views count")
+	bindEnv(flags, "join-window", "ATTRIBUTION_WINDOW")
+	flags.DurationVar(&o.window.Grace, "grace", kafka.DefaultJoinWindow.Grace, "how far behind the newest event time events may arrive")
+	bindEnv(flags, "grace", "ATTRIBUTION_GRACE")
+	flags.IntVar(&o.window.MaxBuffered, "max-buffered", kafka.DefaultJoinWindow.MaxBuffered, "most events buffered per partition")
+	bindEnv(flags, "max-buffered", "ATTRIBUTION_MAX_BUFFERED")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runStreamJoin(o streamJoinOptions) {
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"join_window", o.window.Size,
+		"grace", o.window.Grace,
+		"max_buffered", o.window.MaxBuffered,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Stream-Stream Join", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithJoinWindow(o.window),
+	)
+	opts = append(opts, o.resilience.options()...)
+
+	var joiner *kafka.StreamJoiner
+	o.resilience.connect(ctx, "stream joiner", func() (err error) {
+		joiner, err = kafka.NewStreamJoiner(brokers, o.topic, o.outputTopic, o.groupID, opts...)
+		return err
+	})
+	defer joiner.Close()
+
+	if err := joiner.Consume(ctx); err != nil {
+		logging.Fatal("Error joining streams", "error", err)
+	}
+
+	slog.Info("Stream join stopped")
+}
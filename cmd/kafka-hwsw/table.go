@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type tableOptions struct {
+	topic      string
+	port       int
+	resilience resilienceOptions
+}
+
+func newTableCommand() *cobra.Command {
+	var o tableOptions
+
+	cmd := &cobra.Command{
+		Use:   "table",
+		Short: "Keep a compacted topic as an in-memory table and serve lookups over HTTP",
+		Long: `Materialize a compacted topic in memory like a Kafka Streams KTable: the
+latest value of every key, where a tombstone removes the key. On startup
+the table is restored by replaying every partition from its oldest offset,
+then it follows new messages. Nothing is committed, so a restart restores
+the table from the topic again.
+
+  GET /state/{key}  the latest value of key, 404 if the table doesn't hold it
+  GET /state        every key at one point in time
+
+Both answer 503 while the table is restoring.`,
+		Example: "  kafka-hwsw table -t user-state --port 8083\n" +
+			"  curl localhost:8083/state/user-123",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runTable(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "user-state", "compacted topic to materialize")
+	bindEnv(flags, "topic", "TABLE_TOPIC")
+	flags.IntVar(&o.port, "port", 8083, "port to serve the state queries on")
+	bindEnv(flags, "port", "TABLE_PORT")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runTable(o tableOptions) {
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"port", o.port,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Table", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	var table *kafka.Table
+	o.resilience.connect(ctx, "table", func() (err error) {
+		table, err = kafka.NewTable(brokers, o.topic, clientOptions()...)
+		return err
+	})
+	defer table.Close()
+
+	server := serveHTTP("State query", o.port, table.Handler())
+	slog.Info("State queries available", "url", fmt.Sprintf("http://localhost:%d/state/{key}", o.port))
+
+	if err := table.Run(ctx); err != nil {
+		logging.Fatal("Error materializing table", "error", err)
+	}
+
+	shutdownCtx, stop := context.WithTimeout(context.Background(), closeGrace)
+	defer stop()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to finish open requests", "error", err)
+	}
+	slog.Info("Table stopped", "keys", table.Len())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// txnBatcher groups sends into transactions of batchSize messages. Messages
+// only count as delivered once their transaction commits; a failed send
+// aborts the whole batch.
+type txnBatcher struct {
+	producer  *kafka.Producer
+	batchSize int
+	headers   map[string]string
+	tracker   *kafka.PartitionTracker
+	delivered *atomic.Int64
+	failed    *atomic.Int64
+
+	open    bool
+	pending []pendingSend
+	txnNum  int
+}
+
+type pendingSend struct {
+	key       string
+	partition int32
+	bytes     int
+}
+
+func (b *txnBatcher) send(event kafka.UserEvent) {
+	if !b.open {
+		if err := b.producer.BeginTxn(); err != nil {
+			b.failed.Add(1)
+			slog.Error("Failed to begin transaction", "error", err)
+			return
+		}
+		b.open = true
+		b.txnNum++
+	}
+
+	d := b.producer.Send(event, b.headers)
+	if d.Err != nil {
+		slog.Error("Failed to send message", "topic", b.producer.Topic(), "key", d.Key, "error", d.Err)
+		b.abort(1)
+		return
+	}
+
+	slog.Info("Message sent in transaction", "txn", b.txnNum, "topic", b.producer.Topic(),
+		"partition", d.Partition, "offset", d.Offset, "key", d.Key, "event_type", event.EventType)
+	b.pending = append(b.pending, pendingSend{key: d.Key, partition: d.Partition, bytes: d.Bytes})
+
+	if len(b.pending) >= b.batchSize {
+		b.commit()
+	}
+}
+
+func (b *txnBatcher) commit() {
+	if !b.open {
+		return
+	}
+	b.open = false
+
+	if err := b.producer.CommitTxn(); err != nil {
+		slog.Error("Failed to commit transaction", "txn", b.txnNum, "error", err)
+		b.abort(0)
+		return
+	}
+
+	for _, p := range b.pending {
+		b.tracker.RecordSize(p.key, p.partition, p.bytes)
+	}
+	b.delivered.Add(int64(len(b.pending)))
+	slog.Info("Transaction committed", "txn", b.txnNum, "messages", len(b.pending))
+	b.pending = b.pending[:0]
+}
+
+// abort discards the open transaction. extra counts sends that failed
+// before making it into pending.
+func (b *txnBatcher) abort(extra int) {
+	if err := b.producer.AbortTxn(); err != nil {
+		slog.Error("Failed to abort transaction", "txn", b.txnNum, "error", err)
+	}
+	b.failed.Add(int64(len(b.pending) + extra))
+	slog.Warn("Transaction aborted", "txn", b.txnNum, "discarded", len(b.pending)+extra)
+	b.open = false
+	b.pending = b.pending[:0]
+}
+
+func (b *txnBatcher) close() error {
+	b.commit()
+	return b.producer.Close()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type watermarksOptions struct {
+	topic      string
+	intervalMS int
+}
+
+func newWatermarksCommand() *cobra.Command {
+	var o watermarksOptions
+
+	cmd := &cobra.Command{
+		Use:   "watermarks",
+		Short: "Print the log start offset, high watermark and last stable offset per partition periodically",
+		Long: `Poll the offsets that bound each partition's log and print how they moved
+since the last poll: the high watermark grows as producers write and the
+in-sync replicas catch up, the log start offset jumps when retention
+deletes a segment, and the last stable offset trails the high watermark
+while a transaction is open.
+
+Columns:
+  LOG-START       oldest retained offset, +N when retention removed N offsets
+  HIGH-WATERMARK  offset after the last committed message, +N when N were written
+  LAST-STABLE     offset after the last message read_committed consumers see
+  RETAINED        offsets between the log start and the high watermark`,
+		Example: "  kafka-hwsw watermarks -t test-topic\n" +
+			"  kafka-hwsw watermarks -t user-events --interval-ms 500",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runWatermarks(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to inspect")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.IntVar(&o.intervalMS, "interval-ms", 2000, "milliseconds between polls")
+	bindEnv(flags, "interval-ms", "WATERMARKS_INTERVAL_MS")
+	return cmd
+}
+
+func runWatermarks(o watermarksOptions) {
+	interval := time.Duration(o.intervalMS) * time.Millisecond
+
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"interval", interval,
+		"tls", tlsConfig.Enabled,
+	}
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	slog.Info("Starting Kafka Watermark Inspector", settings...)
+
+	inspector, err := kafka.NewWatermarkInspector(brokers, o.topic, clientOptions()...)
+	if err != nil {
+		logging.Fatal("Failed to create watermark inspector", "error", err)
+	}
+	defer inspector.Close()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[int32]kafka.PartitionWatermarks
+	for {
+		watermarks, err := inspector.Watermarks()
+		if err != nil {
+			slog.Error("Failed to fetch watermarks", "topic", o.topic, "error", err)
+		} else {
+			printWatermarks(watermarks, previous)
+			previous = make(map[int32]kafka.PartitionWatermarks, len(watermarks))
+			for _, w := range watermarks {
+				previous[w.Partition] = w
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigChan:
+			slog.Info("Received shutdown signal, stopping watermark inspector...")
+			return
+		}
+	}
+}
+
+// printWatermarks prints the watermarks with how far each moved since
+// previous, which is nil on the first poll.
+func printWatermarks(watermarks []kafka.PartitionWatermarks, previous map[int32]kafka.PartitionWatermarks) {
+	if len(watermarks) > 0 {
+		fmt.Printf("%s  %s\n", watermarks[0].Time.Format("15:04:05.000"), watermarks[0].Topic)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "PARTITION\tLEADER\tLOG-START\t\tHIGH-WATERMARK\t\tLAST-STABLE\tRETAINED\t\n")
+
+	var written, removed, retained int64
+	var elapsed time.Duration
+	var notes []string
+	for _, wm := range watermarks {
+		startDelta, hwDelta := "", ""
+		if prev, ok := previous[wm.Partition]; ok {
+			startDelta = formatDelta(wm.LogStart - prev.LogStart)
+			hwDelta = formatDelta(wm.HighWatermark - prev.HighWatermark)
+			written += wm.HighWatermark - prev.HighWatermark
+			removed += wm.LogStart - prev.LogStart
+			elapsed = wm.Time.Sub(prev.Time)
+
+			if wm.LogStart > prev.LogStart {
+				notes = append(notes, fmt.Sprintf("partition %d: retention removed offsets %d-%d", wm.Partition, prev.LogStart, wm.LogStart-1))
+			}
+			if wm.Leader != prev.Leader {
+				notes = append(notes, fmt.Sprintf("partition %d: leader moved from broker %d to %d", wm.Partition, prev.Leader, wm.Leader))
+			}
+		}
+		if wm.Unstable() > 0 {
+			notes = append(notes, fmt.Sprintf("partition %d: %d offsets in open transactions", wm.Partition, wm.Unstable()))
+		}
+		retained += wm.Retained()
+
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t\n", wm.Partition, wm.Leader,
+			wm.LogStart, startDelta, wm.HighWatermark, hwDelta, wm.LastStable, wm.Retained())
+	}
+	fmt.Fprintf(w, "\t\t\t%s\t\t%s\tTOTAL\t%d\t\n", formatDelta(removed), formatDelta(written), retained)
+	w.Flush()
+
+	if elapsed > 0 && written > 0 {
+		notes = append(notes, fmt.Sprintf("%.1f messages/s written", float64(written)/elapsed.Seconds()))
+	}
+	if len(notes) > 0 {
+		fmt.Println(strings.Join(notes, "\n"))
+	}
+	fmt.Println()
+}
+
+// formatDelta formats how far an offset moved, or nothing if it didn't.
+func formatDelta(delta int64) string {
+	if delta == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+d", delta)
+}
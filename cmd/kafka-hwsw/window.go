@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/internal/serde"
+	"kafka-hwsw/internal/shutdown"
+	"kafka-hwsw/pkg/kafka"
+)
+
+type windowOptions struct {
+	topic       string
+	outputTopic string
+	groupID     string
+	registryURL string
+	windows     kafka.Windows
+	resilience  resilienceOptions
+}
+
+func newWindowCommand() *cobra.Command {
+	var o windowOptions
+
+	cmd := &cobra.Command{
+		Use:   "window",
+		Short: "Count events per user in tumbling or sliding event-time windows",
+		Long: `Count events per user in event-time windows, using the timestamp in the
+payload, and publish the counts of each window to the output topic once the
+watermark (the newest event time minus the allowed lateness) passes its end.
+Events that arrive after all their windows have closed are dropped.
+
+Without --slide the windows tumble; a slide shorter than the window size
+gives overlapping sliding windows.`,
+		Example: "  kafka-hwsw window -t test-topic --window-size 1m\n" +
+			"  kafka-hwsw window -t test-topic --window-size 5m --slide 1m --allowed-lateness 30s",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runWindow(o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.topic, "topic", "t", "test-topic", "topic to count, keyed by user ID")
+	bindEnv(flags, "topic", "KAFKA_TOPIC")
+	flags.StringVar(&o.outputTopic, "output-topic", "user-windows", "topic the window results are published to")
+	bindEnv(flags, "output-topic", "WINDOW_TOPIC")
+	flags.StringVarP(&o.groupID, "group", "g", "user-windower", "consumer group ID")
+	bindEnv(flags, "group", "WINDOW_GROUP_ID")
+	flags.StringVar(&o.registryURL, "schema-registry-url", "", "Schema Registry URL for avro and protobuf")
+	bindEnv(flags, "schema-registry-url", "SCHEMA_REGISTRY_URL")
+	flags.DurationVar(&o.windows.Size, "window-size", kafka.DefaultWindows.Size, "length of each window")
+	bindEnv(flags, "window-size", "WINDOW_SIZE")
+	flags.DurationVar(&o.windows.Slide, "slide", 0, "how often a window starts; 0 for tumbling windows")
+	bindEnv(flags, "slide", "SLIDE")
+	flags.DurationVar(&o.windows.Lateness, "allowed-lateness", kafka.DefaultWindows.Lateness, "how far behind the newest event time events may arrive")
+	bindEnv(flags, "allowed-lateness", "ALLOWED_LATENESS")
+	o.resilience.addFlags(cmd)
+	return cmd
+}
+
+func runWindow(o windowOptions) {
+	if o.windows.Slide == 0 {
+		o.windows.Slide = o.windows.Size
+	}
+	kind := "sliding"
+	if o.windows.Tumbling() {
+		kind = "tumbling"
+	}
+	settings := []any{
+		"brokers", brokers,
+		"topic", o.topic,
+		"output_topic", o.outputTopic,
+		"group", o.groupID,
+		"windows", kind,
+		"window_size", o.windows.Size,
+	}
+	if kind == "sliding" {
+		settings = append(settings, "slide", o.windows.Slide)
+	}
+	settings = append(settings, "allowed_lateness", o.windows.Lateness, "tls", tlsConfig.Enabled)
+	if saslConfig.Enabled() {
+		settings = append(settings, "sasl_mechanism", saslConfig.Mechanism)
+	}
+	settings = append(settings, o.resilience.settings()...)
+	slog.Info("Starting Kafka Windower", settings...)
+
+	ctx, cancel := shutdown.NotifyContext(context.Background(), closeGrace)
+	defer cancel()
+
+	opts := append(clientOptions(),
+		kafka.WithDeserializers(serde.Available(o.topic, o.registryURL)...),
+		kafka.WithWindows(o.windows),
+	)
+	opts = append(opts, o.resilience.options()...)
+
+	var windower *kafka.Windower
+	o.resilience.connect(ctx, "windower", func() (err error) {
+		windower, err = kafka.NewWindower(brokers, o.topic, o.outputTopic, o.groupID, opts...)
+		return err
+	})
+	defer windower.Close()
+
+	if err := windower.Consume(ctx); err != nil {
+		logging.Fatal("Error counting messages", "error", err)
+	}
+
+	slog.Info("Windower stopped")
+}
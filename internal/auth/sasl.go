@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	MechanismPlain       = "PLAIN"
+	MechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	MechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// SASL holds the credentials used to authenticate against the brokers.
+type SASL struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// Enabled reports whether a SASL mechanism has been configured.
+func (s SASL) Enabled() bool {
+	return s.Mechanism != ""
+}
+
+// Apply configures SASL authentication on the sarama config when it is turned on.
+func (s SASL) Apply(config *sarama.Config) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	if s.Username == "" || s.Password == "" {
+		return fmt.Errorf("KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD are required for %s", s.Mechanism)
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = s.Username
+	config.Net.SASL.Password = s.Password
+
+	switch s.Mechanism {
+	case MechanismPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case MechanismSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &SCRAMClient{HashGeneratorFcn: SHA256}
+		}
+	case MechanismSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &SCRAMClient{HashGeneratorFcn: SHA512}
+		}
+	default:
+		return fmt.Errorf("unsupported SASL mechanism: %s", s.Mechanism)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+// Package bridge connects Kafka to other messaging systems: messages
+// arriving there are produced to Kafka, and Kafka messages are published
+// there, on top of the producers and consumers of pkg/kafka.
+package bridge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Route maps a source, such as a subject filter, to a destination, such
+// as a Kafka topic.
+type Route struct {
+	From string
+	To   string
+}
+
+func (r Route) String() string {
+	return r.From + "=" + r.To
+}
+
+// ParseRoutes parses routes given as from=to, e.g. orders.>=orders.
+func ParseRoutes(specs []string) ([]Route, error) {
+	routes := make([]Route, 0, len(specs))
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid route %q (want from=to)", spec)
+		}
+		routes = append(routes, Route{From: from, To: to})
+	}
+	return routes, nil
+}
+
+// topicKey derives a Kafka key from a hierarchical topic or subject name:
+// the whole name with level 0, otherwise its level-th part, counting from
+// 1, or the whole name if it has fewer parts.
+func topicKey(name, separator string, level int) string {
+	if level <= 0 {
+		return name
+	}
+	parts := strings.Split(name, separator)
+	if level > len(parts) || parts[level-1] == "" {
+		return name
+	}
+	return parts[level-1]
+}
@@ -0,0 +1,224 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Headers of the messages the MQTT bridge produces to Kafka.
+const (
+	MQTTTopicHeader    = "mqtt-topic"
+	MQTTQoSHeader      = "mqtt-qos"
+	MQTTRetainedHeader = "mqtt-retained"
+)
+
+// mqttBuffer is how many MQTT messages wait to be produced before the
+// bridge stops reading from the MQTT broker.
+const mqttBuffer = 1000
+
+// MQTTConfig configures an MQTT bridge.
+type MQTTConfig struct {
+	// URL of the MQTT broker, e.g. tcp://localhost:1883.
+	URL      string
+	ClientID string
+	Username string
+	Password string
+	// QoS of the subscriptions and of the messages published to MQTT.
+	QoS byte
+	// Filters are the MQTT topic filters forwarded to Kafka, e.g.
+	// sensors/#.
+	Filters []string
+	// KeyLevel is the level of the MQTT topic that becomes the Kafka key,
+	// counting from 1, e.g. 2 for the device in sensors/<device>/temp; 0
+	// uses the whole topic.
+	KeyLevel int
+	// OutPrefix is put in front of the Kafka key to name the MQTT topic a
+	// Kafka message is published to.
+	OutPrefix string
+}
+
+// MQTT bridges an MQTT broker and Kafka. Forward produces the messages of
+// the subscribed MQTT topics to Kafka, keyed by their MQTT topic, and
+// Publisher publishes consumed Kafka messages to MQTT.
+//
+// Forwarding is at least once: the bridge keeps a persistent MQTT session
+// and only acknowledges a QoS 1 or 2 message once Kafka has stored it, so
+// the broker delivers whatever wasn't acknowledged again after a crash.
+// Messages that came from MQTT aren't published back, and MQTT messages
+// under OutPrefix aren't forwarded, so a bridge running both ways doesn't
+// loop.
+type MQTT struct {
+	client   mqtt.Client
+	config   MQTTConfig
+	messages chan mqtt.Message
+	done     chan struct{}
+
+	mu         sync.Mutex
+	subscribed bool
+}
+
+// NewMQTT connects to the MQTT broker. The subscriptions are only made by
+// Forward.
+func NewMQTT(config MQTTConfig) (*MQTT, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("no MQTT broker URL configured")
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d (want 0, 1 or 2)", config.QoS)
+	}
+
+	b := &MQTT{config: config, messages: make(chan mqtt.Message, mqttBuffer), done: make(chan struct{})}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.URL).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(2 * time.Second).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("MQTT connection lost, reconnecting", "broker", config.URL, "error", err)
+		})
+	b.client = mqtt.NewClient(opts)
+
+	token := b.client.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		b.client.Disconnect(0)
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", config.URL)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", config.URL, err)
+	}
+	return b, nil
+}
+
+// onConnect subscribes again after a reconnect, in case the broker lost
+// the session.
+func (b *MQTT) onConnect(client mqtt.Client) {
+	slog.Info("Connected to MQTT broker", "broker", b.config.URL, "client_id", b.config.ClientID)
+	b.mu.Lock()
+	subscribed := b.subscribed
+	b.mu.Unlock()
+	if subscribed {
+		go func() {
+			if err := b.subscribe(); err != nil {
+				slog.Error("Failed to subscribe again", "filters", b.config.Filters, "error", err)
+			}
+		}()
+	}
+}
+
+func (b *MQTT) subscribe() error {
+	filters := make(map[string]byte, len(b.config.Filters))
+	for _, filter := range b.config.Filters {
+		filters[filter] = b.config.QoS
+	}
+	token := b.client.SubscribeMultiple(filters, func(_ mqtt.Client, message mqtt.Message) {
+		select {
+		case b.messages <- message:
+		case <-b.done:
+		}
+	})
+	token.Wait()
+	return token.Error()
+}
+
+// Forward subscribes to the MQTT topic filters and produces every message
+// to Kafka until ctx is done. It returns an error if a message can't be
+// produced; that message and the ones after it stay unacknowledged.
+func (b *MQTT) Forward(ctx context.Context, producer *kafka.Producer) error {
+	if len(b.config.Filters) == 0 {
+		return fmt.Errorf("no MQTT topic filters configured")
+	}
+	if err := b.subscribe(); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", strings.Join(b.config.Filters, ","), err)
+	}
+	b.mu.Lock()
+	b.subscribed = true
+	b.mu.Unlock()
+	slog.Info("Forwarding MQTT messages", "filters", b.config.Filters, "kafka_topic", producer.Topic())
+
+	forwarded, skipped := 0, 0
+	defer func() {
+		slog.Info("MQTT forwarding stopped", "forwarded", forwarded, "skipped", skipped)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message := <-b.messages:
+			if b.config.OutPrefix != "" && strings.HasPrefix(message.Topic(), b.config.OutPrefix) {
+				// Published by the bridge itself.
+				message.Ack()
+				skipped++
+				continue
+			}
+
+			key := topicKey(message.Topic(), "/", b.config.KeyLevel)
+			headers := map[string]string{
+				MQTTTopicHeader: message.Topic(),
+				MQTTQoSHeader:   strconv.Itoa(int(message.Qos())),
+			}
+			if message.Retained() {
+				headers[MQTTRetainedHeader] = "true"
+			}
+			partition, offset, err := producer.SendMessageWithHeaders(key, string(message.Payload()), headers)
+			if err != nil {
+				return fmt.Errorf("failed to forward message of MQTT topic %s: %w", message.Topic(), err)
+			}
+			message.Ack()
+			forwarded++
+			slog.Debug("MQTT message forwarded", "mqtt_topic", message.Topic(), "key", key,
+				"partition", partition, "offset", offset, "bytes", len(message.Payload()))
+		}
+	}
+}
+
+// Publisher returns the handler that publishes consumed Kafka messages to
+// OutPrefix followed by their key, or their Kafka topic if they have none,
+// and waits for the MQTT broker to acknowledge them.
+func (b *MQTT) Publisher() kafka.MessageHandler {
+	return kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		if _, ok := message.Headers[MQTTTopicHeader]; ok {
+			return nil
+		}
+		name := string(message.Key)
+		if name == "" {
+			name = message.Topic
+		}
+		topic := b.config.OutPrefix + name
+
+		token := b.client.Publish(topic, b.config.QoS, false, message.Value)
+		select {
+		case <-token.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("failed to publish to MQTT topic %s: %w", topic, err)
+		}
+		slog.Debug("Kafka message published to MQTT", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "mqtt_topic", topic)
+		return nil
+	})
+}
+
+// Close disconnects from the MQTT broker. Messages received but not yet
+// forwarded stay unacknowledged.
+func (b *MQTT) Close() error {
+	close(b.done)
+	b.client.Disconnect(250)
+	return nil
+}
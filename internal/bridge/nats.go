@@ -0,0 +1,360 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// NATSSubjectHeader is the header of the messages the NATS bridge produces
+// to Kafka that holds their subject.
+const NATSSubjectHeader = "nats-subject"
+
+// Headers of the messages the NATS bridge publishes to NATS. A message
+// carrying KafkaTopicHeader came from Kafka and isn't forwarded back.
+const (
+	KafkaTopicHeader     = "Kafka-Topic"
+	KafkaPartitionHeader = "Kafka-Partition"
+	KafkaOffsetHeader    = "Kafka-Offset"
+)
+
+// natsFetch is how many JetStream messages are fetched at once, and
+// natsFetchWait how long a fetch waits for the first of them.
+// natsPublishTimeout is how long publishing a Kafka message may wait for
+// the server or stream to acknowledge it.
+const (
+	natsFetch          = 100
+	natsFetchWait      = 2 * time.Second
+	natsPublishTimeout = 10 * time.Second
+)
+
+// NATSConfig configures a NATS bridge.
+type NATSConfig struct {
+	// URL of the NATS server, e.g. nats://localhost:4222.
+	URL string
+	// Name is the connection name, the queue group of core subscriptions
+	// and the prefix of JetStream durable consumers.
+	Name     string
+	Token    string
+	Username string
+	Password string
+	// ToKafka maps subject filters, e.g. orders.>, to the Kafka topics
+	// their messages are produced to.
+	ToKafka []Route
+	// ToNATS maps Kafka topics to the subject their messages are published
+	// under, followed by .<key> if they have a key.
+	ToNATS []Route
+	// KeyToken is the token of the subject that becomes the Kafka key,
+	// counting from 1, e.g. 2 for the order ID in orders.<id>.created; 0
+	// uses the whole subject.
+	KeyToken int
+	// JetStream consumes and publishes through JetStream instead of core
+	// NATS.
+	JetStream bool
+	// Stream is created for the ToKafka subjects if it doesn't exist. If
+	// it is empty, every ToKafka subject needs an existing stream.
+	Stream string
+}
+
+// NATS bridges NATS subjects and Kafka topics. Forward produces the
+// messages of the ToKafka subjects to Kafka, and Publisher publishes
+// consumed Kafka messages to NATS. Messages keep their headers both ways;
+// messages that came from the other side aren't sent back, so the two
+// directions can share subjects and topics without looping.
+//
+// What a message may go through depends on how it travels:
+//
+//   - Core NATS to Kafka is at most once. NATS doesn't keep messages, so
+//     whatever arrives while the bridge is down or failing is lost. A
+//     publisher that needs to know sends a request instead: the bridge
+//     replies only once Kafka has stored the message.
+//   - JetStream to Kafka is at least once. A durable consumer per route
+//     fetches the messages, and each is acknowledged only once Kafka has
+//     stored it; a crash in between delivers it again.
+//   - Kafka to core NATS is at most once to subscribers: an offset is
+//     committed once the server has the message, but a subscriber that
+//     isn't connected never gets it.
+//   - Kafka to JetStream is at least once: an offset is committed once the
+//     stream acknowledged the message, and the message ID
+//     <topic>-<partition>-<offset> lets the stream drop copies published
+//     again within its duplicate window.
+type NATS struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	config NATSConfig
+	out    map[string]string
+}
+
+// NewNATS connects to the NATS server and, with JetStream and a stream
+// name, creates the stream of the ToKafka subjects if it doesn't exist.
+func NewNATS(config NATSConfig) (*NATS, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("no NATS URL configured")
+	}
+
+	opts := []nats.Option{
+		nats.Name(config.Name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("NATS connection lost, reconnecting", "url", config.URL, "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("Reconnected to NATS", "server", conn.ConnectedUrlRedacted())
+		}),
+	}
+	if config.Token != "" {
+		opts = append(opts, nats.Token(config.Token))
+	}
+	if config.Username != "" {
+		opts = append(opts, nats.UserInfo(config.Username, config.Password))
+	}
+	conn, err := nats.Connect(config.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS %s: %w", config.URL, err)
+	}
+
+	b := &NATS{conn: conn, config: config, out: make(map[string]string, len(config.ToNATS))}
+	for _, route := range config.ToNATS {
+		b.out[route.From] = route.To
+	}
+	if config.JetStream {
+		if b.js, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to open JetStream: %w", err)
+		}
+		if config.Stream != "" && len(config.ToKafka) > 0 {
+			if err := b.ensureStream(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	slog.Info("Connected to NATS", "server", conn.ConnectedUrlRedacted(), "jetstream", config.JetStream)
+	return b, nil
+}
+
+func (b *NATS) ensureStream() error {
+	_, err := b.js.StreamInfo(b.config.Stream)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to look up stream %s: %w", b.config.Stream, err)
+	}
+
+	subjects := make([]string, 0, len(b.config.ToKafka))
+	for _, route := range b.config.ToKafka {
+		subjects = append(subjects, route.From)
+	}
+	if _, err := b.js.AddStream(&nats.StreamConfig{Name: b.config.Stream, Subjects: subjects}); err != nil {
+		return fmt.Errorf("failed to create stream %s: %w", b.config.Stream, err)
+	}
+	slog.Info("Stream created", "stream", b.config.Stream, "subjects", subjects)
+	return nil
+}
+
+// Forward subscribes to the ToKafka subjects and produces their messages
+// with the producer of each route's topic until ctx is done. It returns an
+// error if a message can't be produced; with JetStream that message and
+// the ones fetched with it are delivered again later.
+func (b *NATS) Forward(ctx context.Context, producers map[string]*kafka.Producer) error {
+	if len(b.config.ToKafka) == 0 {
+		return fmt.Errorf("no NATS to Kafka routes configured")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(b.config.ToKafka))
+	for _, route := range b.config.ToKafka {
+		producer, ok := producers[route.To]
+		if !ok {
+			return fmt.Errorf("no producer for topic %s", route.To)
+		}
+		if b.js != nil {
+			sub, err := b.pullSubscribe(route)
+			if err != nil {
+				return err
+			}
+			go func(route Route) {
+				errs <- b.fetch(ctx, route, sub, producer)
+			}(route)
+		} else {
+			sub, err := b.conn.QueueSubscribe(route.From, b.config.Name, func(msg *nats.Msg) {
+				if err := b.forward(msg, producer); err != nil {
+					// Without a reply the requester times out.
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+				if msg.Reply != "" {
+					if err := msg.Respond(nil); err != nil {
+						slog.Warn("Failed to reply to NATS request", "subject", msg.Subject, "error", err)
+					}
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("failed to subscribe to %s: %w", route.From, err)
+			}
+			defer sub.Unsubscribe()
+		}
+		slog.Info("Forwarding NATS messages", "subject", route.From, "kafka_topic", route.To, "jetstream", b.js != nil)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// pullSubscribe binds to the route's durable consumer, creating it if
+// needed. Binding keeps the consumer, and with it the position in the
+// stream, when the bridge stops.
+func (b *NATS) pullSubscribe(route Route) (*nats.Subscription, error) {
+	stream, err := b.js.StreamNameBySubject(route.From)
+	if err != nil {
+		return nil, fmt.Errorf("no stream captures %s, create one or set a stream name: %w", route.From, err)
+	}
+	durable := durableName(b.config.Name, route.From)
+	if _, err := b.js.ConsumerInfo(stream, durable); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = b.js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:       durable,
+			FilterSubject: route.From,
+			AckPolicy:     nats.AckExplicitPolicy,
+			DeliverPolicy: nats.DeliverAllPolicy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer %s on stream %s: %w", durable, stream, err)
+		}
+		slog.Info("Durable consumer created", "stream", stream, "consumer", durable, "subject", route.From)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up consumer %s on stream %s: %w", durable, stream, err)
+	}
+	sub, err := b.js.PullSubscribe(route.From, durable, nats.Bind(stream, durable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind to consumer %s on stream %s: %w", durable, stream, err)
+	}
+	return sub, nil
+}
+
+// fetch forwards the messages of a JetStream consumer in order, each
+// acknowledged once Kafka has stored it.
+func (b *NATS) fetch(ctx context.Context, route Route, sub *nats.Subscription, producer *kafka.Producer) error {
+	for ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, natsFetchWait)
+		msgs, err := sub.Fetch(natsFetch, nats.Context(fetchCtx))
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch from %s: %w", route.From, err)
+		}
+		for i, msg := range msgs {
+			if err := b.forward(msg, producer); err != nil {
+				// Redelivered later, after the ones not forwarded yet.
+				for _, left := range msgs[i:] {
+					_ = left.Nak()
+				}
+				return err
+			}
+			if err := msg.Ack(); err != nil {
+				slog.Warn("Failed to acknowledge NATS message, it will come again", "subject", msg.Subject, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// forward produces msg to Kafka unless it came from Kafka.
+func (b *NATS) forward(msg *nats.Msg, producer *kafka.Producer) error {
+	if msg.Header.Get(KafkaTopicHeader) != "" {
+		return nil
+	}
+
+	key := topicKey(msg.Subject, ".", b.config.KeyToken)
+	headers := make(map[string]string, len(msg.Header)+1)
+	for name := range msg.Header {
+		headers[name] = msg.Header.Get(name)
+	}
+	headers[NATSSubjectHeader] = msg.Subject
+
+	partition, offset, err := producer.SendMessageWithHeaders(key, string(msg.Data), headers)
+	if err != nil {
+		slog.Error("Failed to forward NATS message", "subject", msg.Subject, "kafka_topic", producer.Topic(), "error", err)
+		return fmt.Errorf("failed to forward message of subject %s: %w", msg.Subject, err)
+	}
+	slog.Debug("NATS message forwarded", "subject", msg.Subject, "key", key, "kafka_topic", producer.Topic(),
+		"partition", partition, "offset", offset, "bytes", len(msg.Data))
+	return nil
+}
+
+// Publisher returns the handler that publishes consumed Kafka messages to
+// the subject of their topic's ToNATS route, and waits for the server, or
+// with JetStream the stream, to acknowledge them.
+func (b *NATS) Publisher() kafka.MessageHandler {
+	return kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		if _, ok := message.Headers[NATSSubjectHeader]; ok {
+			return nil
+		}
+		subject, ok := b.out[message.Topic]
+		if !ok {
+			return nil
+		}
+		if len(message.Key) > 0 {
+			subject += "." + string(message.Key)
+		}
+
+		msg := nats.NewMsg(subject)
+		msg.Data = message.Value
+		for name, value := range message.Headers {
+			msg.Header.Set(name, value)
+		}
+		msg.Header.Set(KafkaTopicHeader, message.Topic)
+		msg.Header.Set(KafkaPartitionHeader, strconv.Itoa(int(message.Partition)))
+		msg.Header.Set(KafkaOffsetHeader, strconv.FormatInt(message.Offset, 10))
+
+		ctx, cancel := context.WithTimeout(ctx, natsPublishTimeout)
+		defer cancel()
+		var err error
+		if b.js != nil {
+			id := fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset)
+			_, err = b.js.PublishMsg(msg, nats.Context(ctx), nats.MsgId(id))
+		} else if err = b.conn.PublishMsg(msg); err == nil {
+			err = b.conn.FlushWithContext(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to publish to NATS subject %s: %w", subject, err)
+		}
+		slog.Debug("Kafka message published to NATS", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "subject", subject)
+		return nil
+	})
+}
+
+// Close disconnects from NATS. JetStream messages fetched but not forwarded
+// yet are delivered again.
+func (b *NATS) Close() error {
+	b.conn.Close()
+	return nil
+}
+
+// durableName names the durable consumer of a subject filter, which may
+// not contain the filter's dots and wildcards.
+func durableName(prefix, filter string) string {
+	name := strings.NewReplacer(".", "_", "*", "any", ">", "all").Replace(filter)
+	return prefix + "_" + name
+}
@@ -0,0 +1,465 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka-hwsw/internal/auth"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Headers of the messages the SQS bridge produces to Kafka, besides one per
+// message attribute. A message carrying SQSMessageIDHeader came from SQS
+// and isn't sent back.
+const (
+	SQSMessageIDHeader = "sqs-message-id"
+	SNSTopicARNHeader  = "sns-topic-arn"
+)
+
+// Attributes of the messages the SQS bridge sends to SQS and SNS, besides
+// one per Kafka header. A message carrying KafkaTopicAttribute came from
+// Kafka and isn't forwarded back.
+const (
+	KafkaTopicAttribute     = "kafka-topic"
+	KafkaPartitionAttribute = "kafka-partition"
+	KafkaOffsetAttribute    = "kafka-offset"
+)
+
+// sqsMaxAttributes is how many message attributes SQS accepts, and SNS
+// passes on to SQS. sqsBatch is how many messages are received at once, and
+// sqsWaitSeconds how long a receive waits for the first of them.
+const (
+	sqsMaxAttributes = 10
+	sqsBatch         = 10
+	sqsWaitSeconds   = 20
+)
+
+// SQSConfig configures an SQS bridge.
+type SQSConfig struct {
+	// Region of the queues and topics, e.g. us-east-1.
+	Region string
+	// Endpoint replaces the AWS endpoints of SQS and SNS, e.g.
+	// http://localhost:4566 for LocalStack.
+	Endpoint    string
+	Credentials auth.AWS
+	// QueueURL is the queue drained into Kafka.
+	QueueURL string
+	// KeyAttribute is the message attribute that carries the Kafka key,
+	// both ways. Messages without it are keyed by their message group ID,
+	// if they come from a FIFO queue.
+	KeyAttribute string
+	// OutQueueURL and OutTopicARN are the SQS queue and the SNS topic
+	// consumed Kafka messages are sent to; either may be empty.
+	OutQueueURL string
+	OutTopicARN string
+}
+
+// SQS bridges Amazon SQS and SNS, or LocalStack, and Kafka. Forward drains
+// a queue into Kafka, and Publisher sends consumed Kafka messages to a
+// queue, an SNS topic or both. Message attributes become headers and
+// headers become string attributes, up to the 10 SQS takes.
+//
+// Both ways are at least once: a message is only deleted from the queue
+// once Kafka has stored it, so one caught by a crash in between is
+// received again after its visibility timeout, and a Kafka message is
+// committed once SQS or SNS has accepted it. Messages that came from the
+// other side aren't sent back, so both ways can share a queue without
+// looping.
+type SQS struct {
+	httpClient  *http.Client
+	config      SQSConfig
+	sqsEndpoint string
+	snsEndpoint string
+	backoff     kafka.Backoff
+}
+
+// NewSQS creates an SQS bridge. It doesn't contact AWS until it is used.
+func NewSQS(config SQSConfig) (*SQS, error) {
+	if config.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured")
+	}
+	if !config.Credentials.Enabled() {
+		return nil, fmt.Errorf("no AWS credentials configured")
+	}
+
+	b := &SQS{
+		// Receives wait up to sqsWaitSeconds for messages.
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		config:     config,
+		backoff:    kafka.DefaultBackoff,
+	}
+	if endpoint := strings.TrimSuffix(config.Endpoint, "/"); endpoint != "" {
+		if _, err := url.Parse(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid AWS endpoint %s: %w", endpoint, err)
+		}
+		b.sqsEndpoint, b.snsEndpoint = endpoint, endpoint
+	} else {
+		b.sqsEndpoint = fmt.Sprintf("https://sqs.%s.amazonaws.com", config.Region)
+		b.snsEndpoint = fmt.Sprintf("https://sns.%s.amazonaws.com", config.Region)
+	}
+	return b, nil
+}
+
+// sqsMessage is a message received from SQS.
+type sqsMessage struct {
+	MessageID         string                  `json:"MessageId"`
+	ReceiptHandle     string                  `json:"ReceiptHandle"`
+	Body              string                  `json:"Body"`
+	Attributes        map[string]string       `json:"Attributes"`
+	MessageAttributes map[string]sqsAttribute `json:"MessageAttributes"`
+}
+
+// sqsAttribute is a message attribute. A binary value stays base64-encoded.
+type sqsAttribute struct {
+	DataType    string `json:"DataType"`
+	StringValue string `json:"StringValue,omitempty"`
+	BinaryValue string `json:"BinaryValue,omitempty"`
+}
+
+// snsNotification is the envelope SNS wraps messages in when it delivers
+// them to a queue without raw message delivery.
+type snsNotification struct {
+	Type              string `json:"Type"`
+	TopicArn          string `json:"TopicArn"`
+	Message           string `json:"Message"`
+	MessageAttributes map[string]struct {
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+}
+
+// Forward receives the messages of the queue and produces them to Kafka
+// until ctx is done. It returns an error if a message can't be produced, or
+// SQS can't be reached after retries; the messages not deleted by then are
+// received again once their visibility timeout has passed.
+func (b *SQS) Forward(ctx context.Context, producer *kafka.Producer) error {
+	if b.config.QueueURL == "" {
+		return fmt.Errorf("no SQS queue configured")
+	}
+	slog.Info("Forwarding SQS messages", "queue", b.config.QueueURL, "kafka_topic", producer.Topic())
+
+	forwarded, skipped := 0, 0
+	defer func() {
+		slog.Info("SQS forwarding stopped", "forwarded", forwarded, "skipped", skipped)
+	}()
+	for {
+		var received struct {
+			Messages []sqsMessage `json:"Messages"`
+		}
+		err := b.backoff.Retry(ctx, "SQS receive", func() error {
+			return b.call(ctx, "ReceiveMessage", map[string]any{
+				"QueueUrl":              b.config.QueueURL,
+				"MaxNumberOfMessages":   sqsBatch,
+				"WaitTimeSeconds":       sqsWaitSeconds,
+				"AttributeNames":        []string{"MessageGroupId"},
+				"MessageAttributeNames": []string{"All"},
+			}, &received)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive from %s: %w", b.config.QueueURL, err)
+		}
+
+		done := make([]sqsMessage, 0, len(received.Messages))
+		for _, message := range received.Messages {
+			ok, err := b.forward(message, producer)
+			if err != nil {
+				b.delete(ctx, done)
+				return err
+			}
+			if ok {
+				forwarded++
+			} else {
+				skipped++
+			}
+			done = append(done, message)
+		}
+		if err := b.delete(ctx, done); err != nil {
+			return err
+		}
+	}
+}
+
+// forward produces message to Kafka, reporting false if it came from Kafka
+// and was skipped.
+func (b *SQS) forward(message sqsMessage, producer *kafka.Producer) (bool, error) {
+	value := message.Body
+	headers := map[string]string{SQSMessageIDHeader: message.MessageID}
+	for name, attribute := range message.MessageAttributes {
+		if attribute.BinaryValue != "" {
+			headers[name] = attribute.BinaryValue
+		} else {
+			headers[name] = attribute.StringValue
+		}
+	}
+
+	var notification snsNotification
+	if strings.HasPrefix(value, "{") && json.Unmarshal([]byte(value), &notification) == nil &&
+		notification.Type == "Notification" && notification.TopicArn != "" {
+		value = notification.Message
+		headers[SNSTopicARNHeader] = notification.TopicArn
+		for name, attribute := range notification.MessageAttributes {
+			headers[name] = attribute.Value
+		}
+	}
+	if _, ok := headers[KafkaTopicAttribute]; ok {
+		return false, nil
+	}
+
+	key := message.Attributes["MessageGroupId"]
+	if k, ok := headers[b.config.KeyAttribute]; ok && b.config.KeyAttribute != "" {
+		key = k
+		delete(headers, b.config.KeyAttribute)
+	}
+	partition, offset, err := producer.SendMessageWithHeaders(key, value, headers)
+	if err != nil {
+		return false, fmt.Errorf("failed to forward SQS message %s: %w", message.MessageID, err)
+	}
+	slog.Debug("SQS message forwarded", "message_id", message.MessageID, "key", key,
+		"partition", partition, "offset", offset, "bytes", len(value))
+	return true, nil
+}
+
+// delete removes messages that were forwarded or skipped from the queue.
+func (b *SQS) delete(ctx context.Context, messages []sqsMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	entries := make([]map[string]string, len(messages))
+	for i, message := range messages {
+		entries[i] = map[string]string{"Id": strconv.Itoa(i), "ReceiptHandle": message.ReceiptHandle}
+	}
+	var result struct {
+		Failed []struct {
+			ID      string `json:"Id"`
+			Message string `json:"Message"`
+		} `json:"Failed"`
+	}
+	// A cancelled ctx mustn't keep forwarded messages in the queue.
+	ctx = context.WithoutCancel(ctx)
+	err := b.backoff.Retry(ctx, "SQS delete", func() error {
+		return b.call(ctx, "DeleteMessageBatch", map[string]any{
+			"QueueUrl": b.config.QueueURL,
+			"Entries":  entries,
+		}, &result)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete forwarded messages from %s: %w", b.config.QueueURL, err)
+	}
+	if len(result.Failed) > 0 {
+		// They are received and forwarded again.
+		slog.Warn("Failed to delete forwarded SQS messages", "queue", b.config.QueueURL,
+			"failed", len(result.Failed), "error", result.Failed[0].Message)
+	}
+	return nil
+}
+
+// Publisher returns the handler that sends consumed Kafka messages to the
+// out queue and the SNS topic, and waits for them to accept them. Messages
+// without a value are skipped, as SQS and SNS don't take empty messages.
+func (b *SQS) Publisher() kafka.MessageHandler {
+	return kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		if _, ok := message.Headers[SQSMessageIDHeader]; ok {
+			return nil
+		}
+		if len(message.Value) == 0 {
+			slog.Debug("Skipping empty message", "topic", message.Topic, "partition", message.Partition, "offset", message.Offset)
+			return nil
+		}
+		attributes := b.attributes(message)
+		// FIFO queues and topics keep the order of a Kafka key, or of a
+		// partition for messages without one.
+		group := string(message.Key)
+		if group == "" {
+			group = fmt.Sprintf("%s-%d", message.Topic, message.Partition)
+		}
+		id := fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset)
+
+		if b.config.OutQueueURL != "" {
+			input := map[string]any{
+				"QueueUrl":          b.config.OutQueueURL,
+				"MessageBody":       string(message.Value),
+				"MessageAttributes": attributes,
+			}
+			if strings.HasSuffix(b.config.OutQueueURL, ".fifo") {
+				input["MessageGroupId"] = group
+				input["MessageDeduplicationId"] = id
+			}
+			if err := b.call(ctx, "SendMessage", input, nil); err != nil {
+				return fmt.Errorf("failed to send to SQS queue %s: %w", b.config.OutQueueURL, err)
+			}
+		}
+		if b.config.OutTopicARN != "" {
+			if err := b.publish(ctx, string(message.Value), attributes, group, id); err != nil {
+				return fmt.Errorf("failed to publish to SNS topic %s: %w", b.config.OutTopicARN, err)
+			}
+		}
+		slog.Debug("Kafka message sent to SQS/SNS", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "attributes", len(attributes))
+		return nil
+	})
+}
+
+// attributes returns the message attributes of a Kafka message: its topic,
+// partition and offset, its key and as many of its headers as fit, in name
+// order. Headers SQS can't take as attributes are left out.
+func (b *SQS) attributes(message *kafka.Message) map[string]sqsAttribute {
+	attributes := map[string]sqsAttribute{
+		KafkaTopicAttribute:     {DataType: "String", StringValue: message.Topic},
+		KafkaPartitionAttribute: {DataType: "Number", StringValue: strconv.Itoa(int(message.Partition))},
+		KafkaOffsetAttribute:    {DataType: "Number", StringValue: strconv.FormatInt(message.Offset, 10)},
+	}
+	if len(message.Key) > 0 && b.config.KeyAttribute != "" {
+		attributes[b.config.KeyAttribute] = sqsAttribute{DataType: "String", StringValue: string(message.Key)}
+	}
+
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var dropped []string
+	for _, name := range names {
+		if _, ok := attributes[name]; ok {
+			continue
+		}
+		value := message.Headers[name]
+		if len(attributes) == sqsMaxAttributes || value == "" || !validAttributeName(name) {
+			dropped = append(dropped, name)
+			continue
+		}
+		attributes[name] = sqsAttribute{DataType: "String", StringValue: value}
+	}
+	if len(dropped) > 0 {
+		slog.Debug("Headers not sent as message attributes", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "headers", dropped)
+	}
+	return attributes
+}
+
+// validAttributeName reports whether SQS takes name as a message attribute
+// name.
+func validAttributeName(name string) bool {
+	if name == "" || len(name) > 256 || name[0] == '.' || name[len(name)-1] == '.' || strings.Contains(name, "..") {
+		return false
+	}
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// call runs an SQS action with the JSON protocol, decoding its result into
+// out unless it is nil.
+func (b *SQS) call(ctx context.Context, action string, input, out any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.sqsEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	b.config.Credentials.Sign(req, body, b.config.Region, "sqs", time.Now().UTC())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &awsErr) == nil && awsErr.Type != "" {
+			msg = []byte(awsErr.Type + ": " + awsErr.Message)
+		}
+		err := fmt.Errorf("sqs %s returned %s: %s", action, resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return kafka.Permanent(err)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode sqs %s result: %w", action, err)
+	}
+	return nil
+}
+
+// publish publishes a message to the SNS topic with the query protocol,
+// the one SNS speaks.
+func (b *SQS) publish(ctx context.Context, message string, attributes map[string]sqsAttribute, group, id string) error {
+	form := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {b.config.OutTopicARN},
+		"Message":  {message},
+	}
+	if strings.HasSuffix(b.config.OutTopicARN, ".fifo") {
+		form.Set("MessageGroupId", group)
+		form.Set("MessageDeduplicationId", id)
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", i+1)
+		form.Set(prefix+"Name", name)
+		form.Set(prefix+"Value.DataType", attributes[name].DataType)
+		form.Set(prefix+"Value.StringValue", attributes[name].StringValue)
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.snsEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	b.config.Credentials.Sign(req, body, b.config.Region, "sns", time.Now().UTC())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("sns returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close closes idle connections. Messages received but not yet forwarded
+// are received again once their visibility timeout has passed.
+func (b *SQS) Close() error {
+	b.httpClient.CloseIdleConnections()
+	return nil
+}
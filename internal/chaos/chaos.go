@@ -0,0 +1,308 @@
+// Package chaos injects broker connection failures into sarama clients:
+// dropped connections, slow responses and brokers that stop answering, so
+// the retries, rebalances and ISR changes they cause can be watched without
+// touching the cluster.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrDropped is returned by a write on a connection the injector dropped.
+var ErrDropped = errors.New("chaos: connection dropped")
+
+// Config holds the faults to inject.
+type Config struct {
+	// DropRate is the probability that a request closes its connection
+	// instead of being sent.
+	DropRate float64
+	// Latency is added to every response, plus up to Jitter more.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Blackhole lists broker addresses that never answer: connecting to
+	// them times out.
+	Blackhole []string
+	// BlackholeEvery makes one random broker the client talks to stop
+	// answering for BlackholeFor, every BlackholeEvery.
+	BlackholeEvery time.Duration
+	BlackholeFor   time.Duration
+}
+
+// Enabled reports whether any fault is configured.
+func (c Config) Enabled() bool {
+	return c.DropRate > 0 || c.Latency > 0 || c.Jitter > 0 || len(c.Blackhole) > 0 || c.BlackholeEvery > 0
+}
+
+// Validate checks the settings.
+func (c Config) Validate() error {
+	if c.DropRate < 0 || c.DropRate > 1 {
+		return fmt.Errorf("chaos drop rate must be between 0 and 1, got %g", c.DropRate)
+	}
+	if c.Latency < 0 || c.Jitter < 0 {
+		return errors.New("chaos latency and jitter can't be negative")
+	}
+	if c.BlackholeEvery > 0 && c.BlackholeFor <= 0 {
+		return errors.New("chaos blackhole duration must be positive")
+	}
+	if c.BlackholeEvery > 0 && c.BlackholeFor >= c.BlackholeEvery {
+		return fmt.Errorf("chaos blackhole duration %s must be shorter than its interval %s", c.BlackholeFor, c.BlackholeEvery)
+	}
+	return nil
+}
+
+// Injector applies a Config to every connection of the sarama clients it
+// is applied to. One injector should be shared by all clients of a process,
+// so they all see the same broker go dark.
+type Injector struct {
+	config Config
+
+	mu         sync.Mutex
+	known      map[string]bool
+	blackholed map[string]time.Time
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// New returns an injector for config and starts blackholing brokers if
+// BlackholeEvery is set.
+func New(config Config) (*Injector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	i := &Injector{
+		config:     config,
+		known:      make(map[string]bool),
+		blackholed: make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+	for _, addr := range config.Blackhole {
+		i.blackholed[addr] = time.Time{}
+	}
+
+	slog.Warn("Chaos mode enabled, broker connections will fail on purpose",
+		"drop_rate", config.DropRate, "latency", config.Latency, "jitter", config.Jitter,
+		"blackhole", config.Blackhole, "blackhole_every", config.BlackholeEvery, "blackhole_for", config.BlackholeFor)
+
+	if config.BlackholeEvery > 0 {
+		go i.schedule()
+	}
+	return i, nil
+}
+
+// Apply routes the connections of the sarama config through the injector.
+func (i *Injector) Apply(config *sarama.Config) error {
+	config.Net.Proxy.Enable = true
+	config.Net.Proxy.Dialer = &dialer{
+		injector: i,
+		net: &net.Dialer{
+			Timeout:   config.Net.DialTimeout,
+			KeepAlive: config.Net.KeepAlive,
+			LocalAddr: config.Net.LocalAddr,
+		},
+	}
+	return nil
+}
+
+// Stop ends the blackhole schedule. Brokers that are blackholed stay so.
+func (i *Injector) Stop() {
+	i.stopOnce.Do(func() { close(i.stop) })
+}
+
+// schedule blackholes a random known broker every BlackholeEvery.
+func (i *Injector) schedule() {
+	ticker := time.NewTicker(i.config.BlackholeEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+		}
+
+		i.mu.Lock()
+		var candidates []string
+		for addr := range i.known {
+			if _, ok := i.blackholed[addr]; !ok {
+				candidates = append(candidates, addr)
+			}
+		}
+		if len(candidates) == 0 {
+			i.mu.Unlock()
+			continue
+		}
+		sort.Strings(candidates)
+		addr := candidates[rand.Intn(len(candidates))]
+		i.blackholed[addr] = time.Now().Add(i.config.BlackholeFor)
+		i.mu.Unlock()
+
+		slog.Warn("Chaos: broker stops answering", "broker", addr, "for", i.config.BlackholeFor)
+		time.AfterFunc(i.config.BlackholeFor, func() {
+			i.mu.Lock()
+			delete(i.blackholed, addr)
+			i.mu.Unlock()
+			slog.Warn("Chaos: broker answers again", "broker", addr)
+		})
+	}
+}
+
+// isBlackholed reports whether addr currently doesn't answer.
+func (i *Injector) isBlackholed(addr string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, ok := i.blackholed[addr]
+	return ok
+}
+
+func (i *Injector) remember(addr string) {
+	i.mu.Lock()
+	i.known[addr] = true
+	i.mu.Unlock()
+}
+
+// delay returns how long the next response is held back.
+func (i *Injector) delay() time.Duration {
+	d := i.config.Latency
+	if i.config.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(i.config.Jitter)))
+	}
+	return d
+}
+
+// dialer implements sarama's proxy dialer.
+type dialer struct {
+	injector *Injector
+	net      *net.Dialer
+}
+
+func (d *dialer) Dial(network, addr string) (net.Conn, error) {
+	d.injector.remember(addr)
+	if d.injector.isBlackholed(addr) {
+		// Nothing answers the SYN, so the dial runs into its timeout.
+		timeout := d.net.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		time.Sleep(timeout)
+		return nil, &net.OpError{Op: "dial", Net: network, Err: os.ErrDeadlineExceeded}
+	}
+
+	conn, err := d.net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{Conn: conn, injector: d.injector, addr: addr, closed: make(chan struct{})}, nil
+}
+
+// faultyConn injects the faults into one broker connection.
+type faultyConn struct {
+	net.Conn
+	injector *Injector
+	addr     string
+
+	// pending counts requests whose responses haven't been delayed yet.
+	pending atomic.Int32
+	// swallowed is set once a request was lost to a blackhole; no response
+	// will ever come for it, so the connection only waits for its deadline.
+	swallowed    atomic.Bool
+	readDeadline atomic.Int64
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+func (c *faultyConn) Write(p []byte) (int, error) {
+	if c.injector.config.DropRate > 0 && rand.Float64() < c.injector.config.DropRate {
+		slog.Warn("Chaos: dropping connection", "broker", c.addr)
+		c.Close()
+		return 0, ErrDropped
+	}
+	if c.injector.isBlackholed(c.addr) {
+		c.swallowed.Store(true)
+		return len(p), nil
+	}
+	c.pending.Add(1)
+	return c.Conn.Write(p)
+}
+
+func (c *faultyConn) Read(p []byte) (int, error) {
+	if c.swallowed.Load() {
+		return 0, c.waitForDeadline()
+	}
+	if c.pending.Swap(0) > 0 {
+		if d := c.injector.delay(); d > 0 {
+			if err := c.sleep(d); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+// sleep waits for d, or fails like a read would if the read deadline comes
+// first.
+func (c *faultyConn) sleep(d time.Duration) error {
+	if deadline := c.readDeadline.Load(); deadline > 0 {
+		if remaining := time.Until(time.Unix(0, deadline)); remaining < d {
+			c.wait(remaining)
+			return os.ErrDeadlineExceeded
+		}
+	}
+	c.wait(d)
+	return nil
+}
+
+func (c *faultyConn) waitForDeadline() error {
+	if deadline := c.readDeadline.Load(); deadline > 0 {
+		c.wait(time.Until(time.Unix(0, deadline)))
+		return os.ErrDeadlineExceeded
+	}
+	<-c.closed
+	return net.ErrClosed
+}
+
+// wait sleeps for d or until the connection is closed.
+func (c *faultyConn) wait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.closed:
+	}
+}
+
+func (c *faultyConn) SetDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultyConn) SetReadDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *faultyConn) storeReadDeadline(t time.Time) {
+	if t.IsZero() {
+		c.readDeadline.Store(0)
+	} else {
+		c.readDeadline.Store(t.UnixNano())
+	}
+}
+
+func (c *faultyConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
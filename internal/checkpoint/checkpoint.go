@@ -0,0 +1,39 @@
+// Package checkpoint implements kafka.CheckpointStore for Redis and
+// Postgres, keeping a consumer group's offsets outside Kafka.
+package checkpoint
+
+import (
+	"fmt"
+	"strings"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Store kinds.
+const (
+	KindRedis    = "redis"
+	KindPostgres = "postgres"
+)
+
+// Kinds lists the store kinds New accepts.
+var Kinds = []string{KindRedis, KindPostgres}
+
+// Config holds the settings of every kind of store; each only reads its own.
+type Config struct {
+	RedisURL string
+
+	PostgresDSN   string
+	PostgresTable string
+}
+
+// New returns the store of kind.
+func New(kind string, config Config) (kafka.CheckpointStore, error) {
+	switch strings.ToLower(kind) {
+	case KindRedis:
+		return NewRedisStore(config.RedisURL)
+	case KindPostgres:
+		return NewPostgresStore(config.PostgresDSN, config.PostgresTable)
+	default:
+		return nil, fmt.Errorf("unsupported checkpoint store: %s", kind)
+	}
+}
@@ -0,0 +1,99 @@
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	"kafka-hwsw/internal/pgsql"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PostgresStore keeps one row per group, topic and partition in a table,
+// creating it if needed. Pointed at the database of the Postgres sink, the
+// checkpoints live next to the records they cover.
+type PostgresStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresStore connects to dsn and creates table if it doesn't exist.
+func NewPostgresStore(dsn, table string) (*PostgresStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("no Postgres DSN configured")
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid Postgres table name: %q", table)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	s := &PostgresStore{db: db, table: pgsql.QuoteTable(table)}
+	if _, err := db.ExecContext(ctx, s.createTable()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table %s: %w", table, err)
+	}
+	return s, nil
+}
+
+func (s *PostgresStore) createTable() string {
+	return `CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+	"group_id" TEXT NOT NULL,
+	"topic" TEXT NOT NULL,
+	"partition" INTEGER NOT NULL,
+	"next_offset" BIGINT NOT NULL,
+	"updated_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY ("group_id", "topic", "partition")
+)`
+}
+
+func (s *PostgresStore) Load(ctx context.Context, group, topic string) (map[int32]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT "partition", "next_offset" FROM `+s.table+
+		` WHERE "group_id" = $1 AND "topic" = $2`, group, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[int32]int64)
+	for rows.Next() {
+		var partition int32
+		var next int64
+		if err := rows.Scan(&partition, &next); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		checkpoints[partition] = next
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+func (s *PostgresStore) Save(ctx context.Context, group, topic string, partition int32, next int64) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+s.table+` ("group_id", "topic", "partition", "next_offset")
+VALUES ($1, $2, $3, $4)
+ON CONFLICT ("group_id", "topic", "partition") DO UPDATE SET "next_offset" = EXCLUDED."next_offset", "updated_at" = now()`,
+		group, topic, partition, next)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,73 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the checkpoints of each group and topic in a hash named
+// checkpoint:<group>:<topic>, with one field per partition.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore connects to the Redis server at url, e.g.
+// redis://localhost:6379/0.
+func NewRedisStore(url string) (*RedisStore, error) {
+	if url == "" {
+		return nil, fmt.Errorf("no Redis URL configured")
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) Load(ctx context.Context, group, topic string) (map[int32]int64, error) {
+	fields, err := s.client.HGetAll(ctx, redisKey(group, topic)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+
+	checkpoints := make(map[int32]int64, len(fields))
+	for field, value := range fields {
+		partition, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint partition %q: %w", field, err)
+		}
+		next, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint of partition %d: %q", partition, value)
+		}
+		checkpoints[int32(partition)] = next
+	}
+	return checkpoints, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, group, topic string, partition int32, next int64) error {
+	if err := s.client.HSet(ctx, redisKey(group, topic), strconv.Itoa(int(partition)), next).Err(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func redisKey(group, topic string) string {
+	return "checkpoint:" + group + ":" + topic
+}
@@ -0,0 +1,245 @@
+// Package cluster runs a local Kafka cluster of three KRaft brokers in
+// Docker, so the demos can start from nothing but a Docker daemon. It talks
+// to the Docker Engine API directly instead of going through a compose
+// file.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults for Config.
+const (
+	DefaultImage   = "confluentinc/cp-kafka:7.6.1"
+	DefaultNetwork = "kafka-hwsw"
+)
+
+// Size is the number of brokers. Every broker is also a KRaft controller,
+// so the cluster survives losing one of them.
+const Size = 3
+
+// label marks the containers and network of the cluster, so status and down
+// find them even if the config changed.
+const label = "kafka-hwsw.cluster"
+
+// clusterID is the KRaft cluster ID every broker is formatted with. It only
+// has to be the same on all of them.
+const clusterID = "a2Fma2EtaHdzdy1sb2NhbA"
+
+// Config describes the cluster.
+type Config struct {
+	// Image is the cp-kafka image the brokers run, 7.4 or later for KRaft.
+	Image string
+	// Network is the Docker network the brokers talk to each other on.
+	Network string
+}
+
+// Node is a broker container.
+type Node struct {
+	ID        int
+	Container string
+	// Address is where clients on the host reach the broker.
+	Address string
+	// State is the container state, e.g. running or exited, or missing if
+	// there is no container.
+	State string
+	// Status is Docker's description of the state, e.g. "Up 2 minutes".
+	Status string
+}
+
+// Cluster manages the broker containers.
+type Cluster struct {
+	config Config
+	docker *dockerClient
+}
+
+// New connects to the Docker daemon.
+func New(config Config) (*Cluster, error) {
+	if config.Image == "" {
+		config.Image = DefaultImage
+	}
+	if config.Network == "" {
+		config.Network = DefaultNetwork
+	}
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{config: config, docker: docker}, nil
+}
+
+// Brokers returns the addresses clients on the host connect to, which are
+// the default --brokers of every command.
+func (c *Cluster) Brokers() []string {
+	brokers := make([]string, Size)
+	for id := 1; id <= Size; id++ {
+		brokers[id-1] = fmt.Sprintf("localhost:%d", port(id))
+	}
+	return brokers
+}
+
+// Up creates the network and the brokers and starts them. Brokers that exist
+// already are started if they were stopped and otherwise left alone.
+func (c *Cluster) Up(ctx context.Context) error {
+	if err := c.docker.ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon not available: %w", err)
+	}
+
+	slog.Info("Checking image", "image", c.config.Image)
+	pulled, err := c.docker.pullImage(ctx, c.config.Image)
+	if err != nil {
+		return err
+	}
+	if pulled {
+		slog.Info("Image pulled", "image", c.config.Image)
+	}
+
+	if err := c.docker.ensureNetwork(ctx, c.config.Network, map[string]string{label: c.config.Network}); err != nil {
+		return fmt.Errorf("failed to create network %s: %w", c.config.Network, err)
+	}
+
+	for id := 1; id <= Size; id++ {
+		name := containerName(id)
+		state, err := c.docker.inspectContainer(ctx, name)
+		switch {
+		case err == nil && state.Running:
+			slog.Info("Broker already running", "broker", id, "container", name)
+			continue
+		case err == nil:
+			slog.Info("Starting stopped broker", "broker", id, "container", name, "state", state.Status)
+		case errors.Is(err, errNotFound):
+			if err := c.docker.createContainer(ctx, name, c.containerSpec(id)); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			slog.Info("Broker container created", "broker", id, "container", name, "address", c.Brokers()[id-1])
+		default:
+			return fmt.Errorf("failed to inspect %s: %w", name, err)
+		}
+		if err := c.docker.startContainer(ctx, name); err != nil {
+			return fmt.Errorf("failed to start %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Down removes the brokers, their data and the network.
+func (c *Cluster) Down(ctx context.Context) error {
+	containers, err := c.docker.listContainers(ctx, label)
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		name := strings.TrimPrefix(firstName(container.Names), "/")
+		if err := c.docker.removeContainer(ctx, container.ID); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+		slog.Info("Broker container removed", "container", name)
+	}
+	if err := c.docker.removeNetwork(ctx, c.config.Network); err != nil {
+		return fmt.Errorf("failed to remove network %s: %w", c.config.Network, err)
+	}
+	return nil
+}
+
+// Status returns every broker, including the ones without a container.
+func (c *Cluster) Status(ctx context.Context) ([]Node, error) {
+	containers, err := c.docker.listContainers(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int]Node, Size)
+	for id := 1; id <= Size; id++ {
+		nodes[id] = Node{ID: id, Container: containerName(id), Address: c.Brokers()[id-1], State: "missing"}
+	}
+	for _, container := range containers {
+		id, err := strconv.Atoi(container.Labels[label+".node"])
+		if err != nil {
+			continue
+		}
+		node := nodes[id]
+		node.Container = strings.TrimPrefix(firstName(container.Names), "/")
+		node.State = container.State
+		node.Status = container.Status
+		nodes[id] = node
+	}
+
+	result := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, node)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].ID < result[b].ID })
+	return result, nil
+}
+
+// containerSpec is the create request for broker id. Each broker runs as
+// broker and controller, with three listeners: INTERNAL for replication,
+// CONTROLLER for the KRaft quorum and EXTERNAL, published on the same port
+// on the host, for clients.
+func (c *Cluster) containerSpec(id int) map[string]any {
+	name := containerName(id)
+	external := strconv.Itoa(port(id))
+
+	voters := make([]string, Size)
+	for n := 1; n <= Size; n++ {
+		voters[n-1] = fmt.Sprintf("%d@%s:29093", n, containerName(n))
+	}
+
+	env := []string{
+		"CLUSTER_ID=" + clusterID,
+		"KAFKA_NODE_ID=" + strconv.Itoa(id),
+		"KAFKA_PROCESS_ROLES=broker,controller",
+		"KAFKA_CONTROLLER_QUORUM_VOTERS=" + strings.Join(voters, ","),
+		"KAFKA_LISTENERS=INTERNAL://0.0.0.0:29092,CONTROLLER://0.0.0.0:29093,EXTERNAL://0.0.0.0:" + external,
+		"KAFKA_ADVERTISED_LISTENERS=INTERNAL://" + name + ":29092,EXTERNAL://localhost:" + external,
+		"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=INTERNAL:PLAINTEXT,CONTROLLER:PLAINTEXT,EXTERNAL:PLAINTEXT",
+		"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+		"KAFKA_INTER_BROKER_LISTENER_NAME=INTERNAL",
+		"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=3",
+		"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR=3",
+		"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR=2",
+		"KAFKA_DEFAULT_REPLICATION_FACTOR=3",
+		"KAFKA_MIN_INSYNC_REPLICAS=2",
+		"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS=0",
+	}
+
+	return map[string]any{
+		"Image":        c.config.Image,
+		"Hostname":     name,
+		"Env":          env,
+		"Labels":       map[string]string{label: c.config.Network, label + ".node": strconv.Itoa(id)},
+		"ExposedPorts": map[string]any{external + "/tcp": struct{}{}},
+		"HostConfig": map[string]any{
+			"NetworkMode":  c.config.Network,
+			"PortBindings": map[string]any{external + "/tcp": []map[string]string{{"HostPort": external}}},
+		},
+		"NetworkingConfig": map[string]any{
+			"EndpointsConfig": map[string]any{c.config.Network: map[string]any{"Aliases": []string{name}}},
+		},
+	}
+}
+
+// containerName is the name of broker id's container, which is also its
+// host name on the network.
+func containerName(id int) string {
+	return fmt.Sprintf("kafka-hwsw-broker-%d", id)
+}
+
+// port is the port clients reach broker id on: 9092, 9094 and 9096, like
+// the brokers of docker-compose.yml.
+func port(id int) int {
+	return 9090 + 2*id
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,236 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dockerAPIVersion is the Engine API version requests are sent with. 1.41
+// is served by Docker 20.10 and later.
+const dockerAPIVersion = "v1.41"
+
+// errNotFound is returned for requests on containers, images or networks
+// that don't exist.
+var errNotFound = errors.New("not found")
+
+// dockerClient sends requests to the Docker Engine API. It only covers the
+// few endpoints the cluster needs, which saves pulling in the Docker SDK.
+type dockerClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+// newDockerClient connects to DOCKER_HOST, or to the local socket if it
+// isn't set. Only unix:// and plain tcp:// hosts are supported.
+func newDockerClient() (*dockerClient, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+
+	scheme, addr, ok := strings.Cut(host, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q", host)
+	}
+	switch scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+			},
+		}
+		return &dockerClient{http: &http.Client{Transport: transport}, baseURL: "http://docker/" + dockerAPIVersion}, nil
+	case "tcp":
+		return &dockerClient{http: &http.Client{}, baseURL: "http://" + addr + "/" + dockerAPIVersion}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST scheme %q, use unix:// or tcp://", scheme)
+	}
+}
+
+// do sends a request with body encoded as JSON and decodes the response into
+// out, unless out is nil. 304 Not Modified, which Docker answers when a
+// container already is in the requested state, counts as success.
+func (d *dockerClient) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := d.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request and checks its status, leaving the body to the
+// caller.
+func (d *dockerClient) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	u := d.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Docker: %w", err)
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var message struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&message)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, message.Message)
+	}
+	return nil, fmt.Errorf("docker returned %s: %s", resp.Status, message.Message)
+}
+
+// ping checks that the daemon answers.
+func (d *dockerClient) ping(ctx context.Context) error {
+	return d.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// pullImage pulls image unless it is present already.
+func (d *dockerClient) pullImage(ctx context.Context, image string) (pulled bool, err error) {
+	err = d.do(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, errNotFound) {
+		return false, err
+	}
+
+	// The tag follows the last colon, unless that one belongs to a registry
+	// port, as in localhost:5000/kafka.
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	resp, err := d.send(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to pull %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+
+	// The progress stream ends when the pull is done; failures show up in
+	// it rather than in the status code.
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to pull %s: %w", image, err)
+		}
+		if progress.Error != "" {
+			return false, fmt.Errorf("failed to pull %s: %s", image, progress.Error)
+		}
+	}
+}
+
+type containerState struct {
+	Status  string `json:"Status"`
+	Running bool   `json:"Running"`
+}
+
+type containerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// inspectContainer returns the state of the container called name.
+func (d *dockerClient) inspectContainer(ctx context.Context, name string) (containerState, error) {
+	var container struct {
+		State containerState `json:"State"`
+	}
+	err := d.do(ctx, http.MethodGet, "/containers/"+name+"/json", nil, nil, &container)
+	return container.State, err
+}
+
+// listContainers returns every container, running or not, with label.
+func (d *dockerClient) listContainers(ctx context.Context, label string) ([]containerSummary, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {label}})
+	if err != nil {
+		return nil, err
+	}
+	var containers []containerSummary
+	err = d.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"true"}, "filters": {string(filters)}}, nil, &containers)
+	return containers, err
+}
+
+func (d *dockerClient) createContainer(ctx context.Context, name string, spec any) error {
+	return d.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, spec, nil)
+}
+
+func (d *dockerClient) startContainer(ctx context.Context, name string) error {
+	return d.do(ctx, http.MethodPost, "/containers/"+name+"/start", nil, nil, nil)
+}
+
+// removeContainer stops and removes a container with its anonymous volumes.
+func (d *dockerClient) removeContainer(ctx context.Context, name string) error {
+	if err := d.do(ctx, http.MethodPost, "/containers/"+name+"/stop", url.Values{"t": {"10"}}, nil, nil); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	err := d.do(ctx, http.MethodDelete, "/containers/"+name, url.Values{"force": {"true"}, "v": {"true"}}, nil, nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ensureNetwork creates a bridge network called name unless it exists.
+func (d *dockerClient) ensureNetwork(ctx context.Context, name string, labels map[string]string) error {
+	err := d.do(ctx, http.MethodGet, "/networks/"+name, nil, nil, nil)
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+	return d.do(ctx, http.MethodPost, "/networks/create", nil, map[string]any{
+		"Name":   name,
+		"Driver": "bridge",
+		"Labels": labels,
+	}, nil)
+}
+
+func (d *dockerClient) removeNetwork(ctx context.Context, name string) error {
+	err := d.do(ctx, http.MethodDelete, "/networks/"+name, nil, nil, nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
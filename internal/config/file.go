@@ -0,0 +1,514 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is loaded when CONFIG_FILE is not set. It is optional.
+const DefaultFile = "config.yaml"
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindBool
+	kindFloat
+	kindDuration
+)
+
+type key struct {
+	env  string
+	kind kind
+}
+
+// keys maps every setting the config file accepts to the environment
+// variable it fills in.
+var keys = map[string]key{
+	"brokers":             {"KAFKA_BROKERS", kindString},
+	"mode":                {"KAFKA_MODE", kindString},
+	"client":              {"KAFKA_CLIENT", kindString},
+	"memory_partitions":   {"MEMORY_PARTITIONS", kindInt},
+	"schema_registry_url": {"SCHEMA_REGISTRY_URL", kindString},
+	"message_format":      {"MESSAGE_FORMAT", kindString},
+	"transactional_id":    {"KAFKA_TRANSACTIONAL_ID", kindString},
+	"metrics_port":        {"METRICS_PORT", kindInt},
+	"health_port":         {"HEALTH_PORT", kindInt},
+	"dashboard_port":      {"DASHBOARD_PORT", kindInt},
+	"shutdown_timeout":    {"SHUTDOWN_TIMEOUT", kindDuration},
+
+	"log.level":  {"LOG_LEVEL", kindString},
+	"log.format": {"LOG_FORMAT", kindString},
+
+	"retry.backoff":     {"RETRY_BACKOFF", kindDuration},
+	"retry.backoff_max": {"RETRY_BACKOFF_MAX", kindDuration},
+	"retry.budget":      {"RETRY_BUDGET", kindInt},
+
+	"breaker.threshold": {"BREAKER_THRESHOLD", kindInt},
+	"breaker.cooldown":  {"BREAKER_COOLDOWN", kindDuration},
+
+	"tls.enabled":              {"KAFKA_TLS_ENABLED", kindBool},
+	"tls.cert_file":            {"KAFKA_TLS_CERT_FILE", kindString},
+	"tls.key_file":             {"KAFKA_TLS_KEY_FILE", kindString},
+	"tls.ca_file":              {"KAFKA_TLS_CA_FILE", kindString},
+	"tls.insecure_skip_verify": {"KAFKA_TLS_INSECURE_SKIP_VERIFY", kindBool},
+
+	"sasl.mechanism": {"KAFKA_SASL_MECHANISM", kindString},
+	"sasl.username":  {"KAFKA_SASL_USERNAME", kindString},
+	"sasl.password":  {"KAFKA_SASL_PASSWORD", kindString},
+
+	"encryption.keys":              {"ENCRYPTION_KEYS", kindString},
+	"encryption.data_key_messages": {"ENCRYPTION_DATA_KEY_MESSAGES", kindInt},
+	"encryption.data_key_lifetime": {"ENCRYPTION_DATA_KEY_LIFETIME", kindDuration},
+
+	"compression.zstd_dictionary": {"ZSTD_DICTIONARY", kindString},
+
+	"large_messages.strategy":        {"LARGE_MESSAGE_STRATEGY", kindString},
+	"large_messages.max_bytes":       {"LARGE_MESSAGE_BYTES", kindInt},
+	"large_messages.claim_check_dir": {"CLAIM_CHECK_DIR", kindString},
+
+	"pii.mode":       {"PII_MODE", kindString},
+	"pii.fields":     {"PII_FIELDS", kindString},
+	"pii.vault_file": {"PII_VAULT_FILE", kindString},
+
+	"chaos.drop_rate":       {"CHAOS_DROP_RATE", kindFloat},
+	"chaos.latency":         {"CHAOS_LATENCY", kindDuration},
+	"chaos.jitter":          {"CHAOS_JITTER", kindDuration},
+	"chaos.blackhole":       {"CHAOS_BLACKHOLE", kindString},
+	"chaos.blackhole_every": {"CHAOS_BLACKHOLE_EVERY", kindDuration},
+	"chaos.blackhole_for":   {"CHAOS_BLACKHOLE_FOR", kindDuration},
+
+	"skew.threshold":      {"SKEW_THRESHOLD", kindFloat},
+	"skew.check_interval": {"SKEW_CHECK_INTERVAL", kindDuration},
+
+	"heatmap.file":     {"HEATMAP_FILE", kindString},
+	"heatmap.interval": {"HEATMAP_INTERVAL", kindDuration},
+
+	"topics.name":               {"KAFKA_TOPIC", kindString},
+	"topics.names":              {"KAFKA_TOPICS", kindString},
+	"topics.handlers":           {"TOPIC_HANDLERS", kindString},
+	"topics.pattern":            {"KAFKA_TOPIC_PATTERN", kindString},
+	"topics.refresh_interval":   {"TOPIC_REFRESH_INTERVAL", kindDuration},
+	"topics.priorities":         {"PRIORITY_TOPICS", kindString},
+	"topics.auto_create":        {"AUTO_CREATE_TOPIC", kindBool},
+	"topics.partitions":         {"TOPIC_PARTITIONS", kindInt},
+	"topics.replication_factor": {"TOPIC_REPLICATION_FACTOR", kindInt},
+	"topics.dlq":                {"DLQ_TOPIC", kindString},
+	"topics.retry_levels":       {"RETRY_LEVELS", kindString},
+	"topics.output":             {"OUTPUT_TOPIC", kindString},
+
+	"producer.message_count":       {"MESSAGE_COUNT", kindInt},
+	"producer.message_interval_ms": {"MESSAGE_INTERVAL_MS", kindInt},
+	"producer.messages_per_second": {"MESSAGES_PER_SECOND", kindFloat},
+	"producer.burst":               {"BURST", kindInt},
+	"producer.ramp_up":             {"RAMP_UP", kindDuration},
+	"producer.ramp_start_rate":     {"RAMP_START_RATE", kindFloat},
+	"producer.async":               {"ASYNC", kindBool},
+	"producer.partitioner":         {"KAFKA_PARTITIONER", kindString},
+	"producer.manual_partition":    {"KAFKA_MANUAL_PARTITION", kindInt},
+	"producer.key_strategy":        {"KEY_STRATEGY", kindString},
+	"producer.key_field":           {"KEY_FIELD", kindString},
+	"producer.input":               {"PRODUCER_INPUT", kindString},
+	"producer.input_file":          {"INPUT_FILE", kindString},
+	"producer.input_http_port":     {"INPUT_HTTP_PORT", kindInt},
+	"producer.idempotent":          {"IDEMPOTENT", kindBool},
+	"producer.sequence_numbers":    {"SEQUENCE_NUMBERS", kindBool},
+	"producer.txn_batch_size":      {"TXN_BATCH_SIZE", kindInt},
+	"producer.batch_size":          {"BATCH_SIZE", kindInt},
+	"producer.linger_ms":           {"LINGER_MS", kindInt},
+	"producer.headers":             {"MESSAGE_HEADERS", kindString},
+	"producer.perf_mode":           {"PERF_MODE", kindBool},
+	"producer.perf_timeout":        {"PERF_TIMEOUT", kindDuration},
+	"producer.seed":                {"SEED", kindInt},
+	"producer.users":               {"USER_COUNT", kindInt},
+	"producer.event_weights":       {"EVENT_WEIGHTS", kindString},
+	"producer.scenario":            {"SCENARIO", kindString},
+	"producer.scenario_file":       {"SCENARIO_FILE", kindString},
+	"producer.realistic":           {"REALISTIC_EVENTS", kindBool},
+	"producer.payload_bytes":       {"PAYLOAD_BYTES", kindInt},
+	"producer.payload_padding":     {"PAYLOAD_PADDING", kindString},
+	"producer.schema_version":      {"SCHEMA_VERSION", kindInt},
+	"producer.compression":         {"KAFKA_COMPRESSION", kindString},
+
+	"producer.record_timestamp":  {"RECORD_TIMESTAMP", kindString},
+	"producer.deliver_after":     {"DELIVER_AFTER", kindDuration},
+	"producer.event_time_offset": {"EVENT_TIME_OFFSET", kindDuration},
+	"producer.event_time_jitter": {"EVENT_TIME_JITTER", kindDuration},
+
+	"consumer.group_id":              {"KAFKA_GROUP_ID", kindString},
+	"consumer.rebalance_strategy":    {"KAFKA_REBALANCE_STRATEGY", kindString},
+	"consumer.group_instance_id":     {"KAFKA_GROUP_INSTANCE_ID", kindString},
+	"consumer.max_messages":          {"MAX_MESSAGES", kindInt},
+	"consumer.partitions":            {"KAFKA_PARTITIONS", kindString},
+	"consumer.start_offset":          {"KAFKA_START_OFFSET", kindString},
+	"consumer.start_from":            {"START_FROM", kindString},
+	"consumer.max_retries":           {"MAX_RETRIES", kindInt},
+	"consumer.concurrency":           {"CONSUMER_CONCURRENCY", kindInt},
+	"consumer.in_flight_buffer":      {"IN_FLIGHT_BUFFER", kindInt},
+	"consumer.handlers":              {"CONSUMER_HANDLERS", kindString},
+	"consumer.commit_mode":           {"COMMIT_MODE", kindString},
+	"consumer.commit_every":          {"COMMIT_EVERY", kindInt},
+	"consumer.commit_interval":       {"COMMIT_INTERVAL", kindDuration},
+	"consumer.delivery":              {"DELIVERY_SEMANTICS", kindString},
+	"consumer.simulate_crash_after":  {"SIMULATE_CRASH_AFTER", kindInt},
+	"consumer.schema_reader_version": {"SCHEMA_READER_VERSION", kindInt},
+	"consumer.debug_rebalances":      {"DEBUG_REBALANCES", kindBool},
+	"consumer.control_port":          {"CONTROL_PORT", kindInt},
+	"consumer.readiness_grace":       {"READINESS_GRACE", kindDuration},
+	"consumer.dedup":                 {"DEDUP", kindString},
+	"consumer.dedup_ttl":             {"DEDUP_TTL", kindDuration},
+	"consumer.dedup_size":            {"DEDUP_SIZE", kindInt},
+	"consumer.dedup_file":            {"DEDUP_FILE", kindString},
+	"consumer.filter":                {"FILTER", kindString},
+	"consumer.fail_rate":             {"FAIL_RATE", kindFloat},
+	"consumer.fail_keys":             {"FAIL_KEYS", kindString},
+	"consumer.poison_pill_attempts":  {"POISON_PILL_ATTEMPTS", kindInt},
+	"consumer.poison_pill_file":      {"POISON_PILL_FILE", kindString},
+	"consumer.verify_ordering":       {"VERIFY_ORDERING", kindBool},
+	"consumer.latency_report":        {"LATENCY_REPORT_INTERVAL", kindDuration},
+	"consumer.show_timestamps":       {"SHOW_TIMESTAMPS", kindBool},
+	"consumer.allowed_lateness":      {"EVENT_ALLOWED_LATENESS", kindDuration},
+	"consumer.late_topic":            {"LATE_EVENTS_TOPIC", kindString},
+	"consumer.tui":                   {"CONSUMER_TUI", kindBool},
+	"consumer.tui_messages":          {"CONSUMER_TUI_MESSAGES", kindInt},
+	"consumer.tail_backfill":         {"TAIL_BACKFILL", kindInt},
+	"consumer.tail_rate":             {"TAIL_RATE", kindFloat},
+
+	"lag.interval_ms": {"LAG_INTERVAL_MS", kindInt},
+
+	"watermarks.interval_ms": {"WATERMARKS_INTERVAL_MS", kindInt},
+
+	"aggregate.output_topic":        {"AGGREGATE_TOPIC", kindString},
+	"aggregate.group_id":            {"AGGREGATE_GROUP_ID", kindString},
+	"aggregate.transactional_id":    {"AGGREGATE_TRANSACTIONAL_ID", kindString},
+	"aggregate.checkpoint_every":    {"CHECKPOINT_EVERY", kindInt},
+	"aggregate.checkpoint_interval": {"CHECKPOINT_INTERVAL", kindDuration},
+
+	"window.output_topic":     {"WINDOW_TOPIC", kindString},
+	"window.group_id":         {"WINDOW_GROUP_ID", kindString},
+	"window.size":             {"WINDOW_SIZE", kindDuration},
+	"window.slide":            {"SLIDE", kindDuration},
+	"window.allowed_lateness": {"ALLOWED_LATENESS", kindDuration},
+
+	"session.output_topic":     {"SESSION_TOPIC", kindString},
+	"session.group_id":         {"SESSION_GROUP_ID", kindString},
+	"session.gap":              {"SESSION_GAP", kindDuration},
+	"session.allowed_lateness": {"SESSION_LATENESS", kindDuration},
+
+	"pipeline.output_topic":     {"PIPELINE_OUTPUT_TOPIC", kindString},
+	"pipeline.group_id":         {"PIPELINE_GROUP_ID", kindString},
+	"pipeline.transactional_id": {"PIPELINE_TRANSACTIONAL_ID", kindString},
+	"pipeline.delivery":         {"PIPELINE_DELIVERY", kindString},
+	"pipeline.transform":        {"TRANSFORM", kindString},
+	"pipeline.transform_file":   {"TRANSFORM_FILE", kindString},
+
+	"join.profiles_topic":   {"JOIN_PROFILES_TOPIC", kindString},
+	"join.output_topic":     {"JOIN_OUTPUT_TOPIC", kindString},
+	"join.group_id":         {"JOIN_GROUP_ID", kindString},
+	"join.transactional_id": {"JOIN_TRANSACTIONAL_ID", kindString},
+	"join.delivery":         {"JOIN_DELIVERY", kindString},
+	"join.type":             {"JOIN_TYPE", kindString},
+	"join.profile_wait":     {"JOIN_PROFILE_WAIT", kindDuration},
+
+	"attribution.topic":        {"ATTRIBUTION_TOPIC", kindString},
+	"attribution.group_id":     {"ATTRIBUTION_GROUP_ID", kindString},
+	"attribution.window":       {"ATTRIBUTION_WINDOW", kindDuration},
+	"attribution.grace":        {"ATTRIBUTION_GRACE", kindDuration},
+	"attribution.max_buffered": {"ATTRIBUTION_MAX_BUFFERED", kindInt},
+
+	"sink.kind":                      {"SINK", kindString},
+	"sink.group_id":                  {"SINK_GROUP_ID", kindString},
+	"sink.batch_size":                {"SINK_BATCH_SIZE", kindInt},
+	"sink.batch_bytes":               {"SINK_BATCH_BYTES", kindInt},
+	"sink.flush_interval":            {"SINK_FLUSH_INTERVAL", kindDuration},
+	"sink.file.dir":                  {"SINK_FILE_DIR", kindString},
+	"sink.file.prefix":               {"SINK_FILE_PREFIX", kindString},
+	"sink.file.max_bytes":            {"SINK_FILE_MAX_BYTES", kindInt},
+	"sink.file.rotate_interval":      {"SINK_FILE_ROTATE_INTERVAL", kindDuration},
+	"sink.s3.bucket":                 {"SINK_S3_BUCKET", kindString},
+	"sink.s3.prefix":                 {"SINK_S3_PREFIX", kindString},
+	"sink.s3.region":                 {"SINK_S3_REGION", kindString},
+	"sink.s3.endpoint":               {"SINK_S3_ENDPOINT", kindString},
+	"sink.postgres.dsn":              {"SINK_POSTGRES_DSN", kindString},
+	"sink.postgres.table":            {"SINK_POSTGRES_TABLE", kindString},
+	"sink.materialized_table":        {"SINK_MATERIALIZED_TABLE", kindString},
+	"sink.redis.url":                 {"SINK_REDIS_URL", kindString},
+	"sink.redis.prefix":              {"SINK_REDIS_PREFIX", kindString},
+	"sink.redis.ttl":                 {"SINK_REDIS_TTL", kindDuration},
+	"sink.elasticsearch.url":         {"SINK_ELASTICSEARCH_URL", kindString},
+	"sink.elasticsearch.index":       {"SINK_ELASTICSEARCH_INDEX", kindString},
+	"sink.clickhouse.url":            {"SINK_CLICKHOUSE_URL", kindString},
+	"sink.clickhouse.database":       {"SINK_CLICKHOUSE_DATABASE", kindString},
+	"sink.clickhouse.table":          {"SINK_CLICKHOUSE_TABLE", kindString},
+	"sink.clickhouse.batch_size":     {"SINK_CLICKHOUSE_BATCH_SIZE", kindInt},
+	"sink.clickhouse.flush_interval": {"SINK_CLICKHOUSE_FLUSH_INTERVAL", kindDuration},
+	"sink.webhook.url":               {"SINK_WEBHOOK_URL", kindString},
+	"sink.webhook.concurrency":       {"SINK_WEBHOOK_CONCURRENCY", kindInt},
+	"sink.webhook.timeout":           {"SINK_WEBHOOK_TIMEOUT", kindDuration},
+	"sink.webhook.retries":           {"SINK_WEBHOOK_RETRIES", kindInt},
+	"sink.webhook.dlq_topic":         {"SINK_WEBHOOK_DLQ_TOPIC", kindString},
+	"sink.checkpoint.store":          {"CHECKPOINT_STORE", kindString},
+	"sink.checkpoint.redis_url":      {"CHECKPOINT_REDIS_URL", kindString},
+	"sink.checkpoint.postgres_dsn":   {"CHECKPOINT_POSTGRES_DSN", kindString},
+	"sink.checkpoint.table":          {"CHECKPOINT_TABLE", kindString},
+
+	"table.topic": {"TABLE_TOPIC", kindString},
+	"table.port":  {"TABLE_PORT", kindInt},
+
+	"rest_proxy.port": {"REST_PROXY_PORT", kindInt},
+
+	"replay.output_topic":    {"REPLAY_OUTPUT_TOPIC", kindString},
+	"replay.from":            {"REPLAY_FROM", kindString},
+	"replay.to":              {"REPLAY_TO", kindString},
+	"replay.speed":           {"REPLAY_SPEED", kindString},
+	"replay.keep_partitions": {"REPLAY_KEEP_PARTITIONS", kindBool},
+
+	"mirror.target_brokers":  {"MIRROR_TARGET_BROKERS", kindString},
+	"mirror.group_id":        {"MIRROR_GROUP_ID", kindString},
+	"mirror.topic_prefix":    {"MIRROR_TOPIC_PREFIX", kindString},
+	"mirror.keep_partitions": {"MIRROR_KEEP_PARTITIONS", kindBool},
+	"mirror.create_topics":   {"MIRROR_CREATE_TOPICS", kindBool},
+
+	"bridge.mqtt.url":          {"MQTT_URL", kindString},
+	"bridge.mqtt.client_id":    {"MQTT_CLIENT_ID", kindString},
+	"bridge.mqtt.topics":       {"MQTT_TOPICS", kindString},
+	"bridge.mqtt.qos":          {"MQTT_QOS", kindInt},
+	"bridge.mqtt.key_level":    {"MQTT_KEY_LEVEL", kindInt},
+	"bridge.mqtt.kafka_topic":  {"MQTT_KAFKA_TOPIC", kindString},
+	"bridge.mqtt.out_topic":    {"MQTT_OUT_TOPIC", kindString},
+	"bridge.mqtt.out_prefix":   {"MQTT_OUT_PREFIX", kindString},
+	"bridge.mqtt.group_id":     {"MQTT_GROUP_ID", kindString},
+	"bridge.nats.url":          {"NATS_URL", kindString},
+	"bridge.nats.name":         {"NATS_NAME", kindString},
+	"bridge.nats.to_kafka":     {"NATS_TO_KAFKA", kindString},
+	"bridge.nats.to_nats":      {"NATS_TO_NATS", kindString},
+	"bridge.nats.key_token":    {"NATS_KEY_TOKEN", kindInt},
+	"bridge.nats.jetstream":    {"NATS_JETSTREAM", kindBool},
+	"bridge.nats.stream":       {"NATS_STREAM", kindString},
+	"bridge.nats.group_id":     {"NATS_GROUP_ID", kindString},
+	"bridge.sqs.region":        {"AWS_REGION", kindString},
+	"bridge.sqs.endpoint":      {"AWS_ENDPOINT_URL", kindString},
+	"bridge.sqs.queue_url":     {"SQS_QUEUE_URL", kindString},
+	"bridge.sqs.kafka_topic":   {"SQS_KAFKA_TOPIC", kindString},
+	"bridge.sqs.key_attribute": {"SQS_KEY_ATTRIBUTE", kindString},
+	"bridge.sqs.out_topic":     {"SQS_OUT_TOPIC", kindString},
+	"bridge.sqs.out_queue_url": {"SQS_OUT_QUEUE_URL", kindString},
+	"bridge.sqs.sns_topic_arn": {"SNS_TOPIC_ARN", kindString},
+	"bridge.sqs.group_id":      {"SQS_GROUP_ID", kindString},
+
+	"failover.standby_brokers": {"KAFKA_STANDBY_BROKERS", kindString},
+	"failover.threshold":       {"FAILOVER_THRESHOLD", kindInt},
+	"failover.failback_after":  {"FAILBACK_AFTER", kindDuration},
+
+	"scheduler.topic":       {"SCHEDULER_TOPIC", kindString},
+	"scheduler.group_id":    {"SCHEDULER_GROUP_ID", kindString},
+	"scheduler.max_pending": {"SCHEDULER_MAX_PENDING", kindInt},
+
+	"outbox.postgres_dsn":   {"OUTBOX_POSTGRES_DSN", kindString},
+	"outbox.table":          {"OUTBOX_TABLE", kindString},
+	"outbox.activity_table": {"OUTBOX_ACTIVITY_TABLE", kindString},
+	"outbox.batch_size":     {"OUTBOX_BATCH_SIZE", kindInt},
+	"outbox.poll_interval":  {"OUTBOX_POLL_INTERVAL", kindDuration},
+
+	"bench.message_count": {"BENCH_MESSAGE_COUNT", kindInt},
+	"bench.codecs":        {"BENCH_CODECS", kindString},
+
+	"dictionary.output":        {"DICTIONARY_OUTPUT", kindString},
+	"dictionary.samples":       {"DICTIONARY_SAMPLES", kindInt},
+	"dictionary.compare_count": {"DICTIONARY_COMPARE_COUNT", kindInt},
+	"dictionary.max_size":      {"DICTIONARY_MAX_SIZE", kindInt},
+
+	"cluster.image":      {"CLUSTER_IMAGE", kindString},
+	"cluster.network":    {"CLUSTER_NETWORK", kindString},
+	"cluster.timeout":    {"CLUSTER_TIMEOUT", kindDuration},
+	"cluster.topics":     {"CLUSTER_TOPICS", kindString},
+	"cluster.partitions": {"CLUSTER_PARTITIONS", kindInt},
+
+	"demo.group_id": {"DEMO_GROUP_ID", kindString},
+	"demo.timeout":  {"DEMO_TIMEOUT", kindDuration},
+	"demo.groups":   {"DEMO_GROUPS", kindInt},
+	"demo.members":  {"DEMO_MEMBERS", kindInt},
+
+	"compaction.topic":    {"COMPACTION_TOPIC", kindString},
+	"compaction.keys":     {"COMPACTION_KEYS", kindInt},
+	"compaction.versions": {"COMPACTION_VERSIONS", kindInt},
+	"compaction.deletes":  {"COMPACTION_DELETES", kindInt},
+	"compaction.segment":  {"COMPACTION_SEGMENT", kindDuration},
+	"compaction.timeout":  {"COMPACTION_TIMEOUT", kindDuration},
+
+	"autoscale.group_id":        {"AUTOSCALE_GROUP_ID", kindString},
+	"autoscale.min_consumers":   {"AUTOSCALE_MIN_CONSUMERS", kindInt},
+	"autoscale.max_consumers":   {"AUTOSCALE_MAX_CONSUMERS", kindInt},
+	"autoscale.scale_up_lag":    {"AUTOSCALE_SCALE_UP_LAG", kindInt},
+	"autoscale.scale_down_lag":  {"AUTOSCALE_SCALE_DOWN_LAG", kindInt},
+	"autoscale.interval":        {"AUTOSCALE_INTERVAL", kindDuration},
+	"autoscale.cooldown":        {"AUTOSCALE_COOLDOWN", kindDuration},
+	"autoscale.processing_time": {"AUTOSCALE_PROCESSING_TIME", kindDuration},
+	"autoscale.produce_rate":    {"AUTOSCALE_PRODUCE_RATE", kindInt},
+	"autoscale.produce_for":     {"AUTOSCALE_PRODUCE_FOR", kindDuration},
+	"autoscale.duration":        {"AUTOSCALE_DURATION", kindDuration},
+}
+
+// Load reads a YAML config file and exports its settings as environment
+// variables, so everything that reads the environment picks them up.
+// Variables that are already set win over the file, which makes the
+// environment (and .env) an override layer on top of it. An empty path loads
+// DefaultFile if it exists.
+func Load(path string) error {
+	optional := path == ""
+	if optional {
+		path = DefaultFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	for name, value := range values {
+		env := keys[name].env
+		if _, set := os.LookupEnv(env); set {
+			continue
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// parse flattens the YAML document into dotted keys and checks every key
+// against the known settings. Lists of scalars are joined with commas, so
+// brokers and retry levels can be written either way.
+func parse(data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+
+	var unknown, invalid []string
+	var walk func(prefix string, node *yaml.Node)
+	walk = func(prefix string, node *yaml.Node) {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			value := node.Content[i+1]
+
+			if value.Kind == yaml.MappingNode {
+				walk(name, value)
+				continue
+			}
+
+			k, ok := keys[name]
+			if !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+
+			str, err := scalar(value)
+			if err == nil {
+				err = k.validate(str)
+			}
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			values[name] = str
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("top level must be a mapping")
+	}
+	walk("", root)
+
+	var errs []error
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		errs = append(errs, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", ")))
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		errs = append(errs, fmt.Errorf("invalid values: %s", strings.Join(invalid, "; ")))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return values, nil
+}
+
+func scalar(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("lists may only contain plain values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("expected a value")
+	}
+}
+
+func (k key) validate(value string) error {
+	var err error
+	switch k.kind {
+	case kindInt:
+		_, err = strconv.Atoi(value)
+	case kindBool:
+		_, err = strconv.ParseBool(value)
+	case kindFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case kindDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, k.kind)
+	}
+	return nil
+}
+
+func (k kind) String() string {
+	switch k {
+	case kindInt:
+		return "integer"
+	case kindBool:
+		return "boolean"
+	case kindFloat:
+		return "number"
+	case kindDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
@@ -0,0 +1,159 @@
+(function () {
+  'use strict';
+
+  var colors = ['#2186eb', '#3ebd93', '#f0b429', '#e12d39', '#9446ed', '#0fb5ba', '#f368e0', '#7b8794'];
+
+  function partitionColor(partition) {
+    return colors[partition % colors.length];
+  }
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (name) {
+      if (name === 'text') {
+        node.textContent = attrs[name];
+      } else if (name === 'style') {
+        node.style.cssText = attrs[name];
+      } else {
+        node.setAttribute(name, attrs[name]);
+      }
+    });
+    (children || []).forEach(function (child) { node.appendChild(child); });
+    return node;
+  }
+
+  function bar(label, value, max, extraClass) {
+    var width = max > 0 ? (100 * value / max) : 0;
+    return el('div', { class: 'bar' }, [
+      el('span', { class: 'label', text: label }),
+      el('div', { class: 'track' }, [el('div', { class: 'fill ' + (extraClass || ''), style: 'width:' + width + '%' })]),
+      el('span', { class: 'value', text: String(value) })
+    ]);
+  }
+
+  function partitionBadge(partition) {
+    return el('span', { class: 'partition', style: 'background:' + partitionColor(partition), text: String(partition) });
+  }
+
+  function renderTopic(topic) {
+    var section = document.getElementById('topic-template').content.firstElementChild.cloneNode(true);
+    var partitions = Object.keys(topic.partitions || {}).map(Number).sort(function (a, b) { return a - b; });
+    var counts = partitions.map(function (p) { return topic.partitions[p]; });
+    var total = counts.reduce(function (sum, n) { return sum + n; }, 0);
+    var max = Math.max.apply(null, counts.concat([0]));
+
+    section.querySelector('h2').textContent = topic.topic;
+    section.querySelector('.summary').textContent =
+      total + ' messages, ' + topic.key_count + ' keys, ' + partitions.length + ' partitions';
+
+    var bars = section.querySelector('.bars');
+    partitions.forEach(function (p, i) {
+      bars.appendChild(bar('partition ' + p, counts[i], max));
+    });
+
+    var note = section.querySelector('.note');
+    var body = section.querySelector('.keys tbody');
+    if (!topic.keys) {
+      note.textContent = topic.key_count > 0
+        ? 'Too many keys to list; showing the totals per partition only.'
+        : 'No messages yet.';
+      section.querySelector('.keys').hidden = true;
+      return section;
+    }
+    note.textContent = 'Keys that landed on more than one partition are highlighted.';
+    topic.keys.forEach(function (key) {
+      var row = el('tr', key.partitions.length > 1 ? { class: 'split' } : {}, [
+        el('td', { text: key.key === '' ? '(no key)' : key.key }),
+        el('td', { text: String(key.messages) }),
+        el('td', {}, key.partitions.map(partitionBadge))
+      ]);
+      body.appendChild(row);
+    });
+    return section;
+  }
+
+  function renderLag(snapshot) {
+    var section = document.getElementById('lag-section');
+    var lag = snapshot.lag || [];
+    var error = document.getElementById('lag-error');
+    section.hidden = lag.length === 0 && !snapshot.lag_error;
+    error.hidden = !snapshot.lag_error;
+    error.textContent = snapshot.lag_error || '';
+
+    var max = Math.max.apply(null, lag.map(function (l) { return l.lag; }).concat([0]));
+    var body = document.querySelector('#lag tbody');
+    body.textContent = '';
+    lag.forEach(function (l) {
+      body.appendChild(el('tr', {}, [
+        el('td', { text: l.topic }),
+        el('td', { text: String(l.partition) }),
+        el('td', { text: l.committed < 0 ? '-' : String(l.committed) }),
+        el('td', { text: String(l.log_end) }),
+        el('td', { text: String(l.lag) }),
+        el('td', { class: 'lag-bar' }, [bar('', l.lag, max, 'lag')])
+      ]));
+    });
+  }
+
+  function formatClaims(claims) {
+    if (!claims) {
+      return '';
+    }
+    return Object.keys(claims).sort().map(function (topic) {
+      return topic + ' [' + claims[topic].join(', ') + ']';
+    }).join('; ');
+  }
+
+  function renderRebalances(snapshot) {
+    var events = snapshot.rebalances || [];
+    document.getElementById('rebalance-section').hidden = events.length === 0;
+
+    var body = document.querySelector('#rebalances tbody');
+    body.textContent = '';
+    events.slice().reverse().forEach(function (e) {
+      body.appendChild(el('tr', {}, [
+        el('td', { text: new Date(e.time).toLocaleTimeString() }),
+        el('td', { text: e.phase }),
+        el('td', { text: String(e.generation_id) }),
+        el('td', { text: formatClaims(e.claims) }),
+        el('td', { text: formatClaims(e.assigned) }),
+        el('td', { text: formatClaims(e.revoked) })
+      ]));
+    });
+  }
+
+  function render(snapshot) {
+    document.getElementById('role').textContent = snapshot.role || '';
+    var tailLink = document.getElementById('tail-link');
+    tailLink.hidden = !snapshot.tail;
+    if (snapshot.tail && snapshot.topics && snapshot.topics.length > 0) {
+      tailLink.href = 'tail.html?topic=' + encodeURIComponent(snapshot.topics[0].topic);
+    }
+    var topics = document.getElementById('topics');
+    topics.textContent = '';
+    (snapshot.topics || []).forEach(function (topic) {
+      topics.appendChild(renderTopic(topic));
+    });
+    renderLag(snapshot);
+    renderRebalances(snapshot);
+  }
+
+  function setStatus(connected) {
+    var status = document.getElementById('status');
+    status.textContent = connected ? 'live' : 'disconnected';
+    status.className = 'status ' + (connected ? 'connected' : 'disconnected');
+  }
+
+  function connect() {
+    var scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    var socket = new WebSocket(scheme + location.host + '/ws');
+    socket.onopen = function () { setStatus(true); };
+    socket.onmessage = function (message) { render(JSON.parse(message.data)); };
+    socket.onclose = function () {
+      setStatus(false);
+      setTimeout(connect, 2000);
+    };
+  }
+
+  connect();
+})();
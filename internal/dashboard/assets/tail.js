@@ -0,0 +1,108 @@
+(function () {
+  'use strict';
+
+  // maxRows keeps the page from growing without bound on busy topics.
+  var maxRows = 500;
+  var socket = null;
+  var paused = false;
+
+  var form = document.getElementById('tail-form');
+  var body = document.querySelector('#messages tbody');
+  var error = document.getElementById('error');
+
+  function setStatus(text, connected) {
+    var status = document.getElementById('status');
+    status.textContent = text;
+    status.className = 'status ' + (connected ? 'connected' : 'disconnected');
+  }
+
+  function cell(text, className) {
+    var td = document.createElement('td');
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function addRow(cells, className) {
+    var row = document.createElement('tr');
+    if (className) {
+      row.className = className;
+    }
+    cells.forEach(function (c) { row.appendChild(c); });
+    body.insertBefore(row, body.firstChild);
+    while (body.childNodes.length > maxRows) {
+      body.removeChild(body.lastChild);
+    }
+  }
+
+  function show(message) {
+    if (paused) {
+      return;
+    }
+    if (message.type === 'dropped') {
+      var note = cell(message.dropped + ' messages skipped to stay within the rate');
+      note.colSpan = 5;
+      addRow([note], 'dropped');
+      return;
+    }
+    addRow([
+      cell(new Date(message.timestamp).toLocaleTimeString()),
+      cell(String(message.partition)),
+      cell(String(message.offset)),
+      cell(message.key === null ? '-' : message.key),
+      cell(typeof message.value === 'string' ? message.value : JSON.stringify(message.value), 'value')
+    ]);
+  }
+
+  function follow(params) {
+    if (socket) {
+      socket.onclose = null;
+      socket.close();
+    }
+    body.textContent = '';
+    error.hidden = true;
+
+    var scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    socket = new WebSocket(scheme + location.host + '/ws/tail?' + params.toString());
+    socket.onopen = function () { setStatus('following ' + params.get('topic'), true); };
+    socket.onmessage = function (event) { show(JSON.parse(event.data)); };
+    socket.onclose = function (event) {
+      setStatus('disconnected', false);
+      if (event.code === 1006 && body.childNodes.length === 0) {
+        // A rejected upgrade, e.g. an invalid filter, closes without a reason.
+        error.textContent = 'Connection failed, check the topic and the filter.';
+        error.hidden = false;
+      }
+    };
+  }
+
+  form.addEventListener('submit', function (event) {
+    event.preventDefault();
+    var params = new URLSearchParams();
+    new FormData(form).forEach(function (value, name) {
+      if (value !== '') {
+        params.set(name, value);
+      }
+    });
+    history.replaceState(null, '', '?' + params.toString());
+    follow(params);
+  });
+
+  document.getElementById('pause').addEventListener('click', function (event) {
+    paused = !paused;
+    event.target.textContent = paused ? 'Resume' : 'Pause';
+  });
+
+  // Follow right away when opened with a topic, e.g. tail.html?topic=test-topic.
+  var initial = new URLSearchParams(location.search);
+  initial.forEach(function (value, name) {
+    if (form.elements[name]) {
+      form.elements[name].value = value;
+    }
+  });
+  if (initial.get('topic')) {
+    follow(initial);
+  }
+})();
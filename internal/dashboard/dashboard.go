@@ -0,0 +1,282 @@
+// Package dashboard serves a small web UI that shows where the messages of
+// a producer or consumer went: messages per partition, which partitions each
+// key landed on, consumer lag and the rebalance history. The page is
+// embedded in the binary and updated over a WebSocket.
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// maxKeys caps the keys sent to the page. With more, e.g. uuid keys, the
+// page only shows the totals per partition.
+const maxKeys = 200
+
+// Config describes what a dashboard shows. Only Role and Trackers are
+// required.
+type Config struct {
+	// Role is shown in the title, e.g. producer or consumer.
+	Role string
+	// Topic labels trackers that don't know their topic.
+	Topic string
+	// Trackers returns the partition trackers to show, one per topic.
+	Trackers func() []*kafka.PartitionTracker
+	// Lag returns the consumer group lag, if there is a group.
+	Lag func() ([]kafka.PartitionLag, error)
+	// LagInterval is how often Lag is called. Defaults to five seconds.
+	LagInterval time.Duration
+	// Rebalances is the history of a consumer group member.
+	Rebalances *kafka.RebalanceHistory
+	// Interval is how often the page is updated. Defaults to one second.
+	Interval time.Duration
+	// Tail, if set, is served at /ws/tail and linked from the page.
+	Tail *Tail
+}
+
+// Snapshot is the state sent to the page on every update.
+type Snapshot struct {
+	Role       string                 `json:"role"`
+	Time       time.Time              `json:"time"`
+	Topics     []TopicSnapshot        `json:"topics"`
+	Lag        []kafka.PartitionLag   `json:"lag,omitempty"`
+	LagError   string                 `json:"lag_error,omitempty"`
+	Rebalances []kafka.RebalanceEvent `json:"rebalances,omitempty"`
+	Tail       bool                   `json:"tail,omitempty"`
+}
+
+// TopicSnapshot is the partition distribution of one topic.
+type TopicSnapshot struct {
+	Topic      string        `json:"topic"`
+	Partitions map[int32]int `json:"partitions"`
+	KeyCount   int           `json:"key_count"`
+	Keys       []KeySnapshot `json:"keys,omitempty"`
+}
+
+// KeySnapshot is where the messages of one key went. An empty key stands
+// for messages without a key.
+type KeySnapshot struct {
+	Key        string  `json:"key"`
+	Messages   int     `json:"messages"`
+	Partitions []int32 `json:"partitions"`
+}
+
+// Dashboard builds snapshots and pushes them to every connected page.
+type Dashboard struct {
+	config   Config
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+
+	lagMu      sync.Mutex
+	lag        []kafka.PartitionLag
+	lagErr     error
+	lagFetched time.Time
+
+	done chan struct{}
+	once sync.Once
+}
+
+// New creates a dashboard and starts sending updates.
+func New(config Config) *Dashboard {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.LagInterval <= 0 {
+		config.LagInterval = 5 * time.Second
+	}
+	d := &Dashboard{
+		config:      config,
+		subscribers: make(map[chan []byte]struct{}),
+		done:        make(chan struct{}),
+	}
+	go d.broadcast()
+	return d
+}
+
+// Handler serves the page at /, the feed at /ws, the current snapshot at
+// /api/snapshot and the live tail at /ws/tail.
+func (d *Dashboard) Handler() http.Handler {
+	static, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/ws", d.serveWebSocket)
+	if d.config.Tail != nil {
+		mux.Handle("/ws/tail", d.config.Tail)
+	}
+	mux.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.Snapshot()); err != nil {
+			slog.Error("Failed to write dashboard snapshot", "error", err)
+		}
+	})
+	return mux
+}
+
+// Snapshot returns the current state.
+func (d *Dashboard) Snapshot() Snapshot {
+	snapshot := Snapshot{Role: d.config.Role, Time: time.Now(), Tail: d.config.Tail != nil}
+
+	if d.config.Trackers != nil {
+		for _, tracker := range d.config.Trackers() {
+			snapshot.Topics = append(snapshot.Topics, d.topicSnapshot(tracker))
+		}
+	}
+	if d.config.Lag != nil {
+		lag, err := d.currentLag()
+		snapshot.Lag = lag
+		if err != nil {
+			snapshot.LagError = err.Error()
+		}
+	}
+	if d.config.Rebalances != nil {
+		snapshot.Rebalances = d.config.Rebalances.Events()
+	}
+	return snapshot
+}
+
+func (d *Dashboard) topicSnapshot(tracker *kafka.PartitionTracker) TopicSnapshot {
+	topic := tracker.Topic()
+	if topic == "" {
+		topic = d.config.Topic
+	}
+	keys := tracker.Keys()
+	snapshot := TopicSnapshot{
+		Topic:      topic,
+		Partitions: tracker.PartitionCounts(),
+		KeyCount:   len(keys),
+	}
+	if len(keys) > maxKeys {
+		return snapshot
+	}
+	for _, key := range keys {
+		snapshot.Keys = append(snapshot.Keys, KeySnapshot{
+			Key:        key,
+			Messages:   tracker.Count(key),
+			Partitions: tracker.UniquePartitions(key),
+		})
+	}
+	sort.SliceStable(snapshot.Keys, func(i, j int) bool { return snapshot.Keys[i].Messages > snapshot.Keys[j].Messages })
+	return snapshot
+}
+
+// currentLag returns the lag, fetching it again once it is LagInterval old.
+func (d *Dashboard) currentLag() ([]kafka.PartitionLag, error) {
+	d.lagMu.Lock()
+	defer d.lagMu.Unlock()
+	if time.Since(d.lagFetched) >= d.config.LagInterval {
+		d.lag, d.lagErr = d.config.Lag()
+		d.lagFetched = time.Now()
+	}
+	return d.lag, d.lagErr
+}
+
+// broadcast sends a snapshot to every subscriber each interval. Subscribers
+// that haven't taken the previous one yet skip an update.
+func (d *Dashboard) broadcast() {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+
+		d.mu.Lock()
+		idle := len(d.subscribers) == 0
+		d.mu.Unlock()
+		if idle {
+			continue
+		}
+
+		data, err := json.Marshal(d.Snapshot())
+		if err != nil {
+			slog.Error("Failed to encode dashboard snapshot", "error", err)
+			continue
+		}
+		d.mu.Lock()
+		for ch := range d.subscribers {
+			select {
+			case ch <- data:
+			default:
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Dashboard) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := d.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		return
+	}
+	defer conn.Close()
+
+	updates := make(chan []byte, 1)
+	d.mu.Lock()
+	d.subscribers[updates] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.subscribers, updates)
+		d.mu.Unlock()
+	}()
+
+	// The page never sends anything; reading notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Send the current state right away rather than at the next tick.
+	if first, err := json.Marshal(d.Snapshot()); err == nil {
+		select {
+		case updates <- first:
+		default:
+		}
+	}
+	for {
+		select {
+		case data := <-updates:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-d.done:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"))
+			return
+		}
+	}
+}
+
+// Close stops the updates and closes the open feeds.
+func (d *Dashboard) Close() {
+	d.once.Do(func() { close(d.done) })
+}
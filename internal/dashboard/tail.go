@@ -0,0 +1,323 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"kafka-hwsw/internal/ratelimit"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// TailConfig describes a live tail. Every field is optional.
+type TailConfig struct {
+	// Backfill is how many of the last messages of each topic a new client
+	// gets first. Defaults to 100; a client may ask for fewer.
+	Backfill int
+	// Rate is the most messages per second sent to one client. Defaults to
+	// 50; a client may ask for less.
+	Rate float64
+	// Decode decodes a message as a UserEvent. Messages it fails on are
+	// sent with their raw value.
+	Decode func(message *kafka.Message) (kafka.UserEvent, error)
+	// Filter parses the filter query parameter. Without it, requests with a
+	// filter are rejected.
+	Filter func(expression string) (kafka.MessageFilter, error)
+}
+
+// TailMessage is what a client receives per message. Value is the decoded
+// event, the JSON value or else the value as a string.
+type TailMessage struct {
+	Type      string            `json:"type"`
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       *string           `json:"key"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     any               `json:"value"`
+}
+
+// tailDropped tells a client how many messages it missed because it was
+// sent more than its rate allows.
+type tailDropped struct {
+	Type    string `json:"type"`
+	Dropped int    `json:"dropped"`
+}
+
+// tailEntry is a consumed message, encoded once for every client.
+type tailEntry struct {
+	message *kafka.Message
+	event   *kafka.UserEvent
+	data    []byte
+}
+
+// tailClient is one open /ws/tail connection.
+type tailClient struct {
+	topic   string
+	filter  kafka.MessageFilter
+	entries chan tailEntry
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Tail streams consumed messages to WebSocket clients at /ws/tail.
+type Tail struct {
+	config   TailConfig
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	recent  map[string][]tailEntry
+	clients map[*tailClient]struct{}
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewTail creates a live tail. Add its Handler to the consumer's handlers
+// and pass it to the dashboard as Config.Tail.
+func NewTail(config TailConfig) *Tail {
+	if config.Backfill <= 0 {
+		config.Backfill = 100
+	}
+	if config.Rate <= 0 {
+		config.Rate = 50
+	}
+	return &Tail{
+		config:  config,
+		recent:  make(map[string][]tailEntry),
+		clients: make(map[*tailClient]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Close closes the open connections.
+func (t *Tail) Close() {
+	t.once.Do(func() { close(t.done) })
+}
+
+// Handler records every message it is given and sends it to the clients
+// following its topic. Put it after the handlers that process the message
+// so that only processed messages are sent.
+func (t *Tail) Handler() kafka.MessageHandler {
+	return kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		entry := t.entry(message)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		recent := append(t.recent[message.Topic], entry)
+		if len(recent) > t.config.Backfill {
+			recent = recent[len(recent)-t.config.Backfill:]
+		}
+		t.recent[message.Topic] = recent
+
+		for client := range t.clients {
+			if client.topic == message.Topic {
+				client.offer(entry)
+			}
+		}
+		return nil
+	})
+}
+
+func (t *Tail) entry(message *kafka.Message) tailEntry {
+	out := TailMessage{
+		Type:      "message",
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+		Headers:   message.Headers,
+	}
+	if message.Key != nil {
+		key := string(message.Key)
+		out.Key = &key
+	}
+
+	entry := tailEntry{message: message}
+	if t.config.Decode != nil {
+		if event, err := t.config.Decode(message); err == nil {
+			entry.event = &event
+			out.Value = event
+		}
+	}
+	if out.Value == nil {
+		if json.Valid(message.Value) {
+			out.Value = json.RawMessage(message.Value)
+		} else {
+			out.Value = string(message.Value)
+		}
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		slog.Error("Failed to encode message for the live tail", "topic", message.Topic,
+			"partition", message.Partition, "offset", message.Offset, "error", err)
+	}
+	entry.data = data
+	return entry
+}
+
+// offer queues entry for the client unless its filter rejects it. A client
+// that is behind misses the message and is told so later.
+func (c *tailClient) offer(entry tailEntry) {
+	if entry.data == nil || !c.accepts(entry) {
+		return
+	}
+	select {
+	case c.entries <- entry:
+	default:
+		c.mu.Lock()
+		c.dropped++
+		c.mu.Unlock()
+	}
+}
+
+func (c *tailClient) accepts(entry tailEntry) bool {
+	if c.filter == nil {
+		return true
+	}
+	ok, err := c.filter(entry.message, entry.event)
+	return err == nil && ok
+}
+
+// takeDropped returns how many messages were dropped since it was last
+// called.
+func (c *tailClient) takeDropped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := c.dropped
+	c.dropped = 0
+	return dropped
+}
+
+// ServeHTTP upgrades to a WebSocket that sends the topic's messages as
+// TailMessage objects. Query parameters:
+//
+//   - topic: the topic to follow, required
+//   - filter: an expression as for --filter, e.g. event_type == "purchase"
+//   - backfill: how many of the last messages to send first
+//   - rate: the most messages per second to send
+func (t *Tail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	client := &tailClient{topic: query.Get("topic")}
+	if client.topic == "" {
+		http.Error(w, "the topic parameter is required", http.StatusBadRequest)
+		return
+	}
+	if expression := query.Get("filter"); expression != "" {
+		if t.config.Filter == nil {
+			http.Error(w, "filters aren't supported", http.StatusBadRequest)
+			return
+		}
+		filter, err := t.config.Filter(expression)
+		if err != nil {
+			http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		client.filter = filter
+	}
+	backfill, err := queryNumber(query.Get("backfill"), float64(t.config.Backfill))
+	if err != nil {
+		http.Error(w, "invalid backfill: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate, err := queryNumber(query.Get("rate"), t.config.Rate)
+	if err == nil && rate == 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		http.Error(w, "invalid rate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe and take the backfill in one go, so that no message is
+	// missed or sent twice in between.
+	client.entries = make(chan tailEntry, t.config.Backfill+256)
+	t.mu.Lock()
+	recent := t.recent[client.topic]
+	for _, entry := range recent[len(recent)-min(int(backfill), len(recent)):] {
+		client.offer(entry)
+	}
+	t.clients[client] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.clients, client)
+		t.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(time.Second))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// The client never sends anything; reading notices when it goes away.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	limiter := ratelimit.New(rate, int(rate)+1)
+	for {
+		var entry tailEntry
+		select {
+		case entry = <-client.entries:
+		case <-ctx.Done():
+			return
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if dropped := client.takeDropped(); dropped > 0 {
+			if err := conn.WriteJSON(tailDropped{Type: "dropped", Dropped: dropped}); err != nil {
+				return
+			}
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, entry.data); err != nil {
+			return
+		}
+	}
+}
+
+// queryNumber parses a query parameter that may lower a limit. It defaults
+// to limit, and larger values are capped at it.
+func queryNumber(value string, limit float64) (float64, error) {
+	if value == "" {
+		return limit, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		n = 0
+	}
+	return min(n, limit), nil
+}
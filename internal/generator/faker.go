@@ -0,0 +1,165 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/brianvoe/gofakeit/v6"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Padding modes for Config.PayloadBytes.
+const (
+	// PaddingRandom pads with random characters, which barely compress.
+	PaddingRandom = "random"
+	// PaddingRepeat pads with a repeated pattern, which compresses to
+	// almost nothing.
+	PaddingRepeat = "repeat"
+)
+
+// PaddingField is the Data key that holds the padding.
+const PaddingField = "padding"
+
+// catalogSize is the number of products realistic purchases pick from.
+const catalogSize = 50
+
+// profile is what stays the same across a realistic user's events.
+type profile struct {
+	sessionID string
+	email     string
+	username  string
+	ip        string
+	userAgent string
+	country   string
+	city      string
+	latitude  float64
+	longitude float64
+}
+
+type product struct {
+	id       string
+	name     string
+	category string
+	price    float64
+}
+
+// realistic holds the fake users and product catalog. It draws from its own
+// random source seeded like the generator's.
+type realistic struct {
+	faker    *gofakeit.Faker
+	profiles map[string]*profile
+	catalog  []product
+}
+
+func newRealistic(seed int64, users int) (*realistic, []string) {
+	r := &realistic{
+		faker:    gofakeit.New(seed),
+		profiles: make(map[string]*profile, users),
+	}
+
+	ids := make([]string, users)
+	for i := range ids {
+		ids[i] = r.faker.UUID()
+		address := r.faker.Address()
+		r.profiles[ids[i]] = &profile{
+			sessionID: r.faker.UUID(),
+			email:     r.faker.Email(),
+			username:  r.faker.Username(),
+			ip:        r.faker.IPv4Address(),
+			userAgent: r.faker.UserAgent(),
+			country:   address.Country,
+			city:      address.City,
+			latitude:  round(address.Latitude, 4),
+			longitude: round(address.Longitude, 4),
+		}
+	}
+
+	r.catalog = make([]product, catalogSize)
+	for i := range r.catalog {
+		r.catalog[i] = product{
+			id:       fmt.Sprintf("prod-%04d", i+1),
+			name:     r.faker.ProductName(),
+			category: r.faker.ProductCategory(),
+			price:    round(r.faker.Price(1, 500), 2),
+		}
+	}
+	return r, ids
+}
+
+// data builds the payload of one event. A login starts a new session.
+func (r *realistic) data(userID, eventType string) map[string]interface{} {
+	p := r.profiles[userID]
+	if eventType == "login" {
+		p.sessionID = r.faker.UUID()
+	}
+
+	data := map[string]interface{}{
+		"session_id": p.sessionID,
+		"email":      p.email,
+		"username":   p.username,
+		"ip_address": p.ip,
+		"user_agent": p.userAgent,
+		"country":    p.country,
+		"city":       p.city,
+		"latitude":   p.latitude,
+		"longitude":  p.longitude,
+	}
+
+	item := r.catalog[r.faker.Number(0, len(r.catalog)-1)]
+	switch eventType {
+	case "purchase", "add_to_cart":
+		quantity := r.faker.Number(1, 3)
+		data["product_id"] = item.id
+		data["product_name"] = item.name
+		data["category"] = item.category
+		data["price"] = fmt.Sprintf("%.2f", item.price)
+		data["quantity"] = quantity
+		if eventType == "purchase" {
+			data["amount"] = fmt.Sprintf("%.2f", item.price*float64(quantity))
+		}
+	case "page_view":
+		data["url"] = "/products/" + item.id
+	case "search":
+		words := strings.Fields(strings.ToLower(item.name))
+		data["query"] = words[r.faker.Number(0, len(words)-1)]
+	}
+	return data
+}
+
+// pad grows event's JSON encoding to size bytes with a padding field. Events
+// that are already that large are left alone. Other formats encode the same
+// fields, so they end up close to size as well.
+func pad(rng *rand.Rand, event *kafka.UserEvent, size int, mode string) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	// ,"padding":"" adds this much before any padding characters.
+	overhead := len(`,"":""`) + len(PaddingField)
+	n := size - len(encoded) - overhead
+	if n <= 0 {
+		return
+	}
+
+	if mode == PaddingRepeat {
+		event.Data[PaddingField] = strings.Repeat("x", n)
+		return
+	}
+
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	padding := make([]byte, n)
+	for i := range padding {
+		padding[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	event.Data[PaddingField] = string(padding)
+}
+
+func round(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,301 @@
+// Package generator produces the user events sent by the producer. A seeded
+// generator always produces the same sequence of users, event types and
+// payloads, so a run can be replayed exactly; only the timestamps follow the
+// wall clock.
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// DefaultUsers is the number of distinct users when neither the config nor
+// the scenario sets one. Few users make the key-to-partition routing easy to
+// follow in the logs.
+const DefaultUsers = 3
+
+// EventTypes are the event types generated when no weights are given, all
+// equally likely.
+var EventTypes = []string{"page_view", "purchase", "login", "logout", "search", "add_to_cart"}
+
+// Config controls what a Generator produces.
+type Config struct {
+	// Seed makes the run reproducible. Zero picks a seed from the clock,
+	// which Generator.Seed reports so the run can be replayed.
+	Seed int64
+	// Users overrides the number of distinct users of the scenario.
+	Users int
+	// Weights sets the relative frequency of each event type, overriding
+	// the scenario. See ParseWeights.
+	Weights map[string]float64
+	// Scenario describes the traffic to generate, nil for uniformly
+	// distributed event types.
+	Scenario *Scenario
+	// Realistic replaces the demo user IDs and payloads with fake but
+	// plausible ones: UUID user IDs with an email, location and browser,
+	// and purchases from a product catalog.
+	Realistic bool
+	// PayloadBytes pads every event to about this many bytes of JSON, zero
+	// leaves events as they are.
+	PayloadBytes int
+	// Padding is PaddingRandom (the default) or PaddingRepeat.
+	Padding string
+	// TimeOffset shifts the event timestamps into the past, if negative, or
+	// the future.
+	TimeOffset time.Duration
+	// TimeJitter moves every event timestamp by a random amount of up to
+	// this much either way, so events arrive out of event-time order.
+	TimeJitter time.Duration
+}
+
+// Generator builds user events according to a Config. It is not safe for
+// concurrent use.
+type Generator struct {
+	seed  int64
+	rng   *rand.Rand
+	users []string
+	start time.Time
+	count int
+
+	// Weighted event types, sorted by name so a seed always picks the same
+	// ones regardless of map order.
+	types      []string
+	cumulative []float64
+
+	funnel   []Step
+	progress map[string]int
+
+	realistic *realistic
+
+	// Padding has its own random source, so a seed produces the same
+	// events with and without padding.
+	payloadBytes int
+	padding      string
+	padRng       *rand.Rand
+
+	// Jitter has its own random source too.
+	timeJitter time.Duration
+	timeRng    *rand.Rand
+}
+
+// New creates a generator for cfg.
+func New(cfg Config) (*Generator, error) {
+	if cfg.Users < 0 {
+		return nil, fmt.Errorf("user count must not be negative, got %d", cfg.Users)
+	}
+	if cfg.PayloadBytes < 0 {
+		return nil, fmt.Errorf("payload size must not be negative, got %d", cfg.PayloadBytes)
+	}
+	if cfg.TimeJitter < 0 {
+		return nil, fmt.Errorf("time jitter must not be negative, got %s", cfg.TimeJitter)
+	}
+	switch cfg.Padding {
+	case "":
+		cfg.Padding = PaddingRandom
+	case PaddingRandom, PaddingRepeat:
+	default:
+		return nil, fmt.Errorf("unknown padding %q (want %s or %s)", cfg.Padding, PaddingRandom, PaddingRepeat)
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	g := &Generator{
+		seed:  seed,
+		rng:   rand.New(rand.NewSource(seed)),
+		start: time.Now().Add(cfg.TimeOffset),
+
+		payloadBytes: cfg.PayloadBytes,
+		padding:      cfg.Padding,
+		padRng:       rand.New(rand.NewSource(seed)),
+
+		timeJitter: cfg.TimeJitter,
+		timeRng:    rand.New(rand.NewSource(seed)),
+	}
+
+	users := DefaultUsers
+	weights := cfg.Weights
+	if s := cfg.Scenario; s != nil {
+		if s.Users > 0 {
+			users = s.Users
+		}
+		if len(weights) == 0 {
+			weights = s.Weights
+		}
+		if len(cfg.Weights) == 0 {
+			g.funnel = s.Funnel
+		}
+	}
+	if cfg.Users > 0 {
+		users = cfg.Users
+	}
+
+	if cfg.Realistic {
+		g.realistic, g.users = newRealistic(seed, users)
+	} else {
+		g.users = make([]string, users)
+		for i := range g.users {
+			g.users[i] = fmt.Sprintf("user-%d", 123+i*333)
+		}
+	}
+
+	if len(g.funnel) > 0 {
+		g.progress = make(map[string]int)
+		return g, nil
+	}
+
+	if len(weights) == 0 {
+		weights = make(map[string]float64, len(EventTypes))
+		for _, eventType := range EventTypes {
+			weights[eventType] = 1
+		}
+	}
+	if err := g.setWeights(weights); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Generator) setWeights(weights map[string]float64) error {
+	for eventType := range weights {
+		g.types = append(g.types, eventType)
+	}
+	sort.Strings(g.types)
+
+	total := 0.0
+	for _, eventType := range g.types {
+		weight := weights[eventType]
+		if weight <= 0 {
+			return fmt.Errorf("weight of %s must be positive, got %g", eventType, weight)
+		}
+		total += weight
+		g.cumulative = append(g.cumulative, total)
+	}
+	return nil
+}
+
+// Seed returns the seed in use, which replays the run when passed back in
+// Config.Seed.
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// Next returns the next event. Consecutive events are one second apart,
+// starting when the generator was created plus Config.TimeOffset, give or
+// take Config.TimeJitter.
+func (g *Generator) Next() kafka.UserEvent {
+	userID := g.users[g.rng.Intn(len(g.users))]
+
+	var eventType string
+	if g.funnel != nil {
+		eventType = g.advance(userID)
+	} else {
+		eventType = g.pick()
+	}
+
+	event := kafka.UserEvent{
+		UserID:    userID,
+		EventType: eventType,
+		Timestamp: g.start.Add(time.Duration(g.count)*time.Second + g.jitter()),
+	}
+	if g.realistic != nil {
+		event.Data = g.realistic.data(userID, eventType)
+	} else {
+		event.Data = g.demoData(eventType)
+	}
+	if g.payloadBytes > 0 {
+		pad(g.padRng, &event, g.payloadBytes, g.padding)
+	}
+
+	g.count++
+	return event
+}
+
+// jitter returns a random duration between -TimeJitter and TimeJitter.
+func (g *Generator) jitter() time.Duration {
+	if g.timeJitter == 0 {
+		return 0
+	}
+	return time.Duration(g.timeRng.Int63n(int64(2*g.timeJitter)+1)) - g.timeJitter
+}
+
+func (g *Generator) demoData(eventType string) map[string]interface{} {
+	data := map[string]interface{}{
+		"session_id": fmt.Sprintf("session-%d", g.count),
+		"ip_address": fmt.Sprintf("192.168.1.%d", g.rng.Intn(254)+1),
+		"user_agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)",
+	}
+
+	switch eventType {
+	case "purchase":
+		data["amount"] = fmt.Sprintf("%.2f", float64(g.rng.Intn(100000)+1)/100)
+		data["product_id"] = fmt.Sprintf("prod-%d", g.rng.Intn(100)+1)
+	case "add_to_cart":
+		data["product_id"] = fmt.Sprintf("prod-%d", g.rng.Intn(100)+1)
+	case "search":
+		data["query"] = fmt.Sprintf("search term %d", g.rng.Intn(1000)+1)
+	}
+	return data
+}
+
+// Generate returns the next count events.
+func (g *Generator) Generate(count int) []kafka.UserEvent {
+	events := make([]kafka.UserEvent, 0, count)
+	for i := 0; i < count; i++ {
+		events = append(events, g.Next())
+	}
+	return events
+}
+
+func (g *Generator) pick() string {
+	r := g.rng.Float64() * g.cumulative[len(g.cumulative)-1]
+	i := sort.SearchFloat64s(g.cumulative, r)
+	if i == len(g.types) {
+		i--
+	}
+	return g.types[i]
+}
+
+// advance emits the funnel step userID is at and moves the user on to the
+// next step, or back to the first one if they drop off or finish.
+func (g *Generator) advance(userID string) string {
+	i := g.progress[userID]
+	step := g.funnel[i]
+
+	if i+1 < len(g.funnel) && g.rng.Float64() < step.Continue {
+		g.progress[userID] = i + 1
+	} else {
+		g.progress[userID] = 0
+	}
+	return step.Event
+}
+
+// ParseWeights parses event type weights such as "login=5,purchase=1,search=2".
+func ParseWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eventType, weight, ok := strings.Cut(part, "=")
+		eventType = strings.TrimSpace(eventType)
+		if !ok || eventType == "" {
+			return nil, fmt.Errorf("invalid event weight %q, want type=weight", part)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", eventType, weight)
+		}
+		weights[eventType] = w
+	}
+	return weights, nil
+}
@@ -0,0 +1,148 @@
+package generator
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario describes a traffic shape. Events are drawn either from Weights
+// or, for a funnel, by walking each user through the Funnel steps in order.
+type Scenario struct {
+	Name        string             `yaml:"-"`
+	Description string             `yaml:"description"`
+	Users       int                `yaml:"users"`
+	Weights     map[string]float64 `yaml:"weights"`
+	Funnel      []Step             `yaml:"funnel"`
+}
+
+// Step is one stage of a funnel scenario.
+type Step struct {
+	Event string `yaml:"event"`
+	// Continue is the probability that a user moves on to the next step
+	// rather than dropping off and starting over.
+	Continue float64 `yaml:"continue"`
+}
+
+//go:embed scenarios.yaml
+var builtinScenarios []byte
+
+// Scenarios holds named scenarios.
+type Scenarios map[string]*Scenario
+
+// LoadScenarios returns the built-in scenarios, extended or overridden by
+// the ones in path if it is not empty.
+func LoadScenarios(path string) (Scenarios, error) {
+	scenarios, err := parseScenarios(builtinScenarios)
+	if err != nil {
+		return nil, fmt.Errorf("invalid built-in scenarios: %w", err)
+	}
+	if path == "" {
+		return scenarios, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	custom, err := parseScenarios(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario file %s: %w", path, err)
+	}
+	for name, scenario := range custom {
+		scenarios[name] = scenario
+	}
+	return scenarios, nil
+}
+
+// BuiltinScenarios returns the names of the scenarios that ship with the
+// package.
+func BuiltinScenarios() []string {
+	scenarios, err := parseScenarios(builtinScenarios)
+	if err != nil {
+		return nil
+	}
+	return scenarios.Names()
+}
+
+// Get looks up a scenario by name.
+func (s Scenarios) Get(name string) (*Scenario, error) {
+	scenario, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario %q (available: %v)", name, s.Names())
+	}
+	return scenario, nil
+}
+
+// Names returns the scenario names in sorted order.
+func (s Scenarios) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseScenarios(data []byte) (Scenarios, error) {
+	var doc struct {
+		Scenarios Scenarios `yaml:"scenarios"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var errs []error
+	for _, name := range doc.Scenarios.Names() {
+		scenario := doc.Scenarios[name]
+		if scenario == nil {
+			scenario = &Scenario{}
+			doc.Scenarios[name] = scenario
+		}
+		scenario.Name = name
+		if err := scenario.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("scenario %s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if doc.Scenarios == nil {
+		doc.Scenarios = make(Scenarios)
+	}
+	return doc.Scenarios, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.Users < 0 {
+		return fmt.Errorf("users must not be negative, got %d", s.Users)
+	}
+	if len(s.Weights) > 0 && len(s.Funnel) > 0 {
+		return errors.New("weights and funnel can't be combined")
+	}
+	if len(s.Weights) == 0 && len(s.Funnel) == 0 {
+		return errors.New("needs either weights or a funnel")
+	}
+	for eventType, weight := range s.Weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of %s must be positive, got %g", eventType, weight)
+		}
+	}
+	for i, step := range s.Funnel {
+		if step.Event == "" {
+			return fmt.Errorf("funnel step %d has no event", i+1)
+		}
+		if step.Continue < 0 || step.Continue > 1 {
+			return fmt.Errorf("funnel step %s: continue must be between 0 and 1, got %g", step.Event, step.Continue)
+		}
+	}
+	return nil
+}
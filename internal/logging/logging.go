@@ -0,0 +1,89 @@
+// Package logging configures the process-wide slog logger so every command
+// logs the same way.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// level and format are what Setup installed, so Redirect can reinstall them.
+var (
+	level  slog.Level
+	format string
+)
+
+// Setup installs a default slog logger at the given level (debug, info, warn
+// or error) and format (text or json). The standard log package is routed
+// through it too.
+func Setup(levelName, formatName string) error {
+	parsed, err := parseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	handler, err := newHandler(os.Stderr, formatName, parsed)
+	if err != nil {
+		return err
+	}
+
+	level, format = parsed, formatName
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Redirect sends the log to w instead of stderr, dropping records below min
+// as well as below the configured level, until the returned function is
+// called. Full-screen views use it to keep the log off the terminal.
+func Redirect(w io.Writer, min slog.Level) (restore func()) {
+	previous := slog.Default()
+	handler, err := newHandler(w, format, max(level, min))
+	if err != nil {
+		// Setup has already accepted the format.
+		panic(err)
+	}
+	slog.SetDefault(slog.New(handler))
+	return func() { slog.SetDefault(previous) }
+}
+
+// Fatal logs msg at error level and exits, replacing log.Fatalf.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func parseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", value)
+	}
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want %s or %s)", format, FormatText, FormatJSON)
+	}
+}
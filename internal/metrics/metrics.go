@@ -0,0 +1,258 @@
+// Package metrics exposes producer and consumer activity, plus sarama's own
+// client metrics, in the Prometheus format.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// Metrics implements kafka.Metrics with Prometheus collectors.
+type Metrics struct {
+	registry       *prometheus.Registry
+	mux            *http.ServeMux
+	saramaRegistry gometrics.Registry
+
+	messagesSent     *prometheus.CounterVec
+	messagesConsumed *prometheus.CounterVec
+	errors           *prometheus.CounterVec
+	duplicates       *prometheus.CounterVec
+	poisonPills      *prometheus.CounterVec
+	filtered         *prometheus.CounterVec
+	lateEvents       *prometheus.CounterVec
+	sendLatency      *prometheus.HistogramVec
+	endToEndLatency  *prometheus.HistogramVec
+	consumerLag      *prometheus.GaugeVec
+	partitionSkew    *prometheus.GaugeVec
+	watermarkLag     *prometheus.GaugeVec
+	bufferMessages   *prometheus.GaugeVec
+	bufferOccupancy  *prometheus.GaugeVec
+	backpressure     *prometheus.CounterVec
+	rebalances       *prometheus.CounterVec
+	groupLag         *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry:       prometheus.NewRegistry(),
+		mux:            http.NewServeMux(),
+		saramaRegistry: gometrics.NewRegistry(),
+
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_messages_sent_total",
+			Help: "Messages successfully sent, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		messagesConsumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_messages_consumed_total",
+			Help: "Messages consumed, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_errors_total",
+			Help: "Send and processing errors, by topic and kind.",
+		}, []string{"topic", "kind"}),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_duplicates_skipped_total",
+			Help: "Messages skipped because their message ID was processed before, by topic.",
+		}, []string{"topic"}),
+		poisonPills: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_poison_pills_total",
+			Help: "Messages skipped because they failed too often, by topic.",
+		}, []string{"topic"}),
+		filtered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_filter_messages_total",
+			Help: "Messages checked against the consumer filter, by topic and result (passed or filtered).",
+		}, []string{"topic", "result"}),
+		lateEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_late_events_total",
+			Help: "Events behind their partition's event-time watermark, by topic.",
+		}, []string{"topic"}),
+		sendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kafka_send_latency_seconds",
+			Help:    "Time from send until the broker acknowledged the message.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"topic"}),
+		endToEndLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kafka_end_to_end_latency_seconds",
+			Help:    "Time from producing a message until the consumer read it, by topic and partition.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"topic", "partition"}),
+		partitionSkew: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_partition_skew_ratio",
+			Help: "Messages or bytes a consumer read from a partition over the average of its partitions, by topic, partition and unit.",
+		}, []string{"topic", "partition", "unit"}),
+		watermarkLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_event_time_watermark_lag_seconds",
+			Help: "How far a partition's event-time watermark was behind the wall clock at its last message, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		bufferMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_buffer_messages",
+			Help: "Messages waiting in a claim's in-flight buffer, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		bufferOccupancy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_buffer_occupancy_ratio",
+			Help: "Share of a claim's in-flight buffer in use, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		backpressure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_backpressure_pauses_total",
+			Help: "Times a partition was paused because its in-flight buffer was full, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_lag",
+			Help: "Messages between the last consumed offset and the high watermark.",
+		}, []string{"topic", "partition"}),
+		rebalances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_rebalances_total",
+			Help: "Partition assignments received by this consumer group member.",
+		}, []string{"group"}),
+		groupLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_group_lag",
+			Help: "Messages between the group's committed offset and the log-end offset.",
+		}, []string{"group", "topic", "partition"}),
+	}
+
+	m.registry.MustRegister(
+		m.messagesSent,
+		m.messagesConsumed,
+		m.errors,
+		m.duplicates,
+		m.poisonPills,
+		m.filtered,
+		m.lateEvents,
+		m.sendLatency,
+		m.endToEndLatency,
+		m.consumerLag,
+		m.partitionSkew,
+		m.watermarkLag,
+		m.bufferMessages,
+		m.bufferOccupancy,
+		m.backpressure,
+		m.rebalances,
+		m.groupLag,
+		newSaramaCollector(m.saramaRegistry),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m.mux.Handle("/metrics", m.Handler())
+	return m
+}
+
+// ConfigureSarama points the sarama client metrics at the registry exported
+// by this package. Use it with kafka.WithConfigFunc.
+func (m *Metrics) ConfigureSarama(config *sarama.Config) error {
+	config.MetricRegistry = m.saramaRegistry
+	return nil
+}
+
+func (m *Metrics) MessageSent(topic string, partition int32, latency time.Duration) {
+	m.messagesSent.WithLabelValues(topic, partitionLabel(partition)).Inc()
+	m.sendLatency.WithLabelValues(topic).Observe(latency.Seconds())
+}
+
+func (m *Metrics) SendFailed(topic string) {
+	m.errors.WithLabelValues(topic, "send").Inc()
+}
+
+func (m *Metrics) MessageConsumed(topic string, partition int32, lag int64) {
+	m.messagesConsumed.WithLabelValues(topic, partitionLabel(partition)).Inc()
+	m.consumerLag.WithLabelValues(topic, partitionLabel(partition)).Set(float64(lag))
+}
+
+func (m *Metrics) EndToEndLatency(topic string, partition int32, latency time.Duration) {
+	m.endToEndLatency.WithLabelValues(topic, partitionLabel(partition)).Observe(latency.Seconds())
+}
+
+func (m *Metrics) ProcessingFailed(topic string) {
+	m.errors.WithLabelValues(topic, "processing").Inc()
+}
+
+func (m *Metrics) DuplicateSkipped(topic string) {
+	m.duplicates.WithLabelValues(topic).Inc()
+}
+
+func (m *Metrics) PoisonPillSkipped(topic string) {
+	m.poisonPills.WithLabelValues(topic).Inc()
+}
+
+func (m *Metrics) MessageFiltered(topic string, passed bool) {
+	result := "filtered"
+	if passed {
+		result = "passed"
+	}
+	m.filtered.WithLabelValues(topic, result).Inc()
+}
+
+func (m *Metrics) WatermarkLag(topic string, partition int32, lag time.Duration) {
+	m.watermarkLag.WithLabelValues(topic, partitionLabel(partition)).Set(lag.Seconds())
+}
+
+func (m *Metrics) LateEvent(topic string) {
+	m.lateEvents.WithLabelValues(topic).Inc()
+}
+
+func (m *Metrics) InFlightBuffer(topic string, partition int32, messages, capacity int) {
+	m.bufferMessages.WithLabelValues(topic, partitionLabel(partition)).Set(float64(messages))
+	m.bufferOccupancy.WithLabelValues(topic, partitionLabel(partition)).Set(float64(messages) / float64(capacity))
+}
+
+func (m *Metrics) BackpressurePaused(topic string, partition int32) {
+	m.backpressure.WithLabelValues(topic, partitionLabel(partition)).Inc()
+}
+
+func (m *Metrics) PartitionSkew(topic string, partition int32, messageRatio, byteRatio float64) {
+	m.partitionSkew.WithLabelValues(topic, partitionLabel(partition), "messages").Set(messageRatio)
+	m.partitionSkew.WithLabelValues(topic, partitionLabel(partition), "bytes").Set(byteRatio)
+}
+
+func (m *Metrics) Rebalanced(groupID string) {
+	m.rebalances.WithLabelValues(groupID).Inc()
+}
+
+// GroupLag records the lag of a consumer group as measured from the broker,
+// independently of any running consumer.
+func (m *Metrics) GroupLag(groupID, topic string, partition int32, lag int64) {
+	m.groupLag.WithLabelValues(groupID, topic, partitionLabel(partition)).Set(float64(lag))
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Handle serves handler at pattern next to /metrics, e.g. debug endpoints.
+// Call it before Serve.
+func (m *Metrics) Handle(pattern string, handler http.Handler) {
+	m.mux.Handle(pattern, handler)
+}
+
+// Serve exposes /metrics and anything added with Handle on port in the
+// background. The returned server should be shut down on exit.
+func (m *Metrics) Serve(port int) *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           m.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	return server
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.Itoa(int(partition))
+}
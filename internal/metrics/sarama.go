@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// saramaCollector exports the go-metrics registry sarama records its client
+// metrics in (request rates, batch sizes, latencies per broker and topic).
+// The metric names are only known at runtime, so it is an unchecked collector.
+type saramaCollector struct {
+	registry gometrics.Registry
+}
+
+func newSaramaCollector(registry gometrics.Registry) prometheus.Collector {
+	return &saramaCollector{registry: registry}
+}
+
+func (c *saramaCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *saramaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.registry.Each(func(name string, metric interface{}) {
+		base := "sarama_" + invalidNameChars.ReplaceAllString(name, "_")
+
+		switch m := metric.(type) {
+		case gometrics.Counter:
+			gauge(ch, base, float64(m.Count()))
+		case gometrics.Gauge:
+			gauge(ch, base, float64(m.Value()))
+		case gometrics.GaugeFloat64:
+			gauge(ch, base, m.Value())
+		case gometrics.Meter:
+			s := m.Snapshot()
+			gauge(ch, base+"_count", float64(s.Count()))
+			gauge(ch, base+"_rate1m", s.Rate1())
+		case gometrics.Histogram:
+			s := m.Snapshot()
+			gauge(ch, base+"_count", float64(s.Count()))
+			gauge(ch, base+"_mean", s.Mean())
+			gauge(ch, base+"_p50", s.Percentile(0.5))
+			gauge(ch, base+"_p99", s.Percentile(0.99))
+		}
+	})
+}
+
+func gauge(ch chan<- prometheus.Metric, name string, value float64) {
+	desc := prometheus.NewDesc(name, "sarama client metric "+name, nil, nil)
+	if m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value); err == nil {
+		ch <- m
+	}
+}
@@ -0,0 +1,193 @@
+// Package outbox demonstrates the transactional outbox pattern on Postgres:
+// a business write and the event describing it are committed in one
+// transaction, and a relay publishes the event to Kafka afterwards.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kafka-hwsw/internal/pgsql"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Defaults of Config.
+const (
+	DefaultTable         = "outbox"
+	DefaultActivityTable = "user_activity"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Config describes the tables of a Store.
+type Config struct {
+	DSN string
+	// Table is the outbox table, DefaultTable if empty.
+	Table string
+	// ActivityTable is the business table the demo writes to,
+	// DefaultActivityTable if empty: one row per user with its latest
+	// event and an event count.
+	ActivityTable string
+}
+
+// Store writes events to the outbox table together with the business write
+// they belong to, and reads them back for a Relay. Both tables are created
+// if they don't exist.
+type Store struct {
+	db       *sql.DB
+	table    string
+	index    string
+	activity string
+}
+
+// NewStore connects to config.DSN and creates the tables.
+func NewStore(config Config) (*Store, error) {
+	if config.DSN == "" {
+		return nil, fmt.Errorf("no Postgres DSN configured")
+	}
+	if config.Table == "" {
+		config.Table = DefaultTable
+	}
+	if config.ActivityTable == "" {
+		config.ActivityTable = DefaultActivityTable
+	}
+	for _, table := range []string{config.Table, config.ActivityTable} {
+		if !tableName.MatchString(table) {
+			return nil, fmt.Errorf("invalid Postgres table name: %q", table)
+		}
+	}
+
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	name := config.Table[strings.LastIndex(config.Table, ".")+1:]
+	s := &Store{
+		db:       db,
+		table:    pgsql.QuoteTable(config.Table),
+		index:    pq.QuoteIdentifier(name + "_unpublished"),
+		activity: pgsql.QuoteTable(config.ActivityTable),
+	}
+	for _, statement := range s.createTables() {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create outbox tables: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) createTables() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+	"id" BIGSERIAL PRIMARY KEY,
+	"topic" TEXT NOT NULL,
+	"aggregate_id" TEXT NOT NULL,
+	"event_type" TEXT NOT NULL,
+	"payload" JSONB NOT NULL,
+	"headers" JSONB NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+	"published_at" TIMESTAMPTZ
+)`,
+		// The relay only ever looks for unpublished rows, which stay few
+		// however long the table grows.
+		`CREATE INDEX IF NOT EXISTS ` + s.index + ` ON ` + s.table + ` ("topic", "id") WHERE "published_at" IS NULL`,
+		`CREATE TABLE IF NOT EXISTS ` + s.activity + ` (
+	"user_id" TEXT PRIMARY KEY,
+	"last_event_type" TEXT NOT NULL,
+	"last_seen" TIMESTAMPTZ NOT NULL,
+	"events" BIGINT NOT NULL
+)`,
+	}
+}
+
+// Write records event in the activity table and adds it to the outbox for
+// topic, in one transaction: either both happen or neither does. The
+// outbox row gets a message-id and trace-id header of its own, which stay
+// the same however often the row is published. It returns the ID of the
+// outbox row.
+func (s *Store) Write(ctx context.Context, topic string, event kafka.UserEvent) (int64, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode event: %w", err)
+	}
+	headers, err := json.Marshal(map[string]string{
+		kafka.MessageIDHeader: kafka.NewUUID(),
+		kafka.TraceIDHeader:   kafka.NewTraceID(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+s.activity+` AS "a" ("user_id", "last_event_type", "last_seen", "events")
+VALUES ($1, $2, $3, 1)
+ON CONFLICT ("user_id") DO UPDATE SET "last_event_type" = EXCLUDED."last_event_type",
+	"last_seen" = EXCLUDED."last_seen", "events" = "a"."events" + 1`,
+		event.UserID, event.EventType, event.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", s.activity, err)
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO `+s.table+` ("topic", "aggregate_id", "event_type", "payload", "headers")
+VALUES ($1, $2, $3, $4, $5) RETURNING "id"`,
+		topic, event.UserID, event.EventType, string(payload), string(headers)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert into %s: %w", s.table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return id, nil
+}
+
+// Status counts the rows of topic's outbox.
+type Status struct {
+	Pending   int64
+	Published int64
+	// OldestPending is when the oldest unpublished row was written, zero
+	// if there is none.
+	OldestPending time.Time
+}
+
+// Status returns the Status of topic.
+func (s *Store) Status(ctx context.Context, topic string) (Status, error) {
+	var status Status
+	var oldest sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT
+	count(*) FILTER (WHERE "published_at" IS NULL),
+	count(*) FILTER (WHERE "published_at" IS NOT NULL),
+	min("created_at") FILTER (WHERE "published_at" IS NULL)
+FROM `+s.table+` WHERE "topic" = $1`, topic).Scan(&status.Pending, &status.Published, &oldest)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to read %s: %w", s.table, err)
+	}
+	status.OldestPending = oldest.Time
+	return status, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
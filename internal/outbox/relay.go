@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// IDHeader carries the ID of the outbox row a message was published from.
+const IDHeader = "outbox-id"
+
+// Defaults of RelayConfig.
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+)
+
+// RelayConfig controls how a Relay polls the outbox.
+type RelayConfig struct {
+	// BatchSize is the most rows published per transaction,
+	// DefaultBatchSize if 0.
+	BatchSize int
+	// PollInterval is how long the relay waits after finding no rows, or
+	// failing, before it looks again, DefaultPollInterval if 0.
+	PollInterval time.Duration
+}
+
+// Relay publishes the outbox rows of its producer's topic to Kafka, oldest
+// first, and marks them published. Each batch is locked, sent and marked in
+// one transaction, so a row is only marked once Kafka acknowledged it. A
+// crash between the two publishes the row again, with the message-id it
+// got when it was written, so consumers deduplicating on it process every
+// event exactly once. Rows locked by another relay are skipped, which lets
+// several relays share the outbox, but then batches may be published out of
+// order.
+type Relay struct {
+	store    *Store
+	producer *kafka.Producer
+	config   RelayConfig
+
+	published int
+	batches   int
+	delay     time.Duration
+	maxDelay  time.Duration
+}
+
+// NewRelay creates a relay publishing the rows of store with producer.
+func NewRelay(store *Store, producer *kafka.Producer, config RelayConfig) *Relay {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	return &Relay{store: store, producer: producer, config: config}
+}
+
+// Run polls the outbox until ctx is done. Failed batches are logged and
+// tried again after the poll interval.
+func (r *Relay) Run(ctx context.Context) error {
+	defer r.logSummary()
+	for {
+		n, err := r.relay(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			slog.Error("Failed to relay outbox rows", "topic", r.producer.Topic(), "published", n, "error", err)
+		}
+		if n == r.config.BatchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+type outboxRow struct {
+	id        int64
+	payload   []byte
+	headers   []byte
+	createdAt time.Time
+}
+
+// relay publishes one batch and returns how many rows it marked published.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	s := r.store
+	// Shutting down mustn't roll back rows Kafka already acknowledged, or
+	// they would be published again: the transaction outlives ctx, which
+	// only stops the sending.
+	txCtx := context.WithoutCancel(ctx)
+	tx, err := s.db.BeginTx(txCtx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := r.lock(txCtx, tx)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	var published []int64
+	var sendErr error
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		if sendErr = r.publish(row); sendErr != nil {
+			break
+		}
+		published = append(published, row.id)
+	}
+	if len(published) == 0 {
+		return 0, sendErr
+	}
+
+	_, err = tx.ExecContext(txCtx, `UPDATE `+s.table+` SET "published_at" = now() WHERE "id" = ANY($1)`, pq.Array(published))
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark rows published: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	r.batches++
+	slog.Info("Relayed outbox rows", "topic", r.producer.Topic(), "rows", len(published),
+		"first_id", published[0], "last_id", published[len(published)-1])
+	return len(published), sendErr
+}
+
+// lock selects and locks the oldest unpublished rows that no other relay
+// holds.
+func (r *Relay) lock(ctx context.Context, tx *sql.Tx) ([]outboxRow, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT "id", "payload", "headers", "created_at" FROM `+r.store.table+`
+WHERE "topic" = $1 AND "published_at" IS NULL ORDER BY "id" LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		r.producer.Topic(), r.config.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.store.table, err)
+	}
+	defer rows.Close()
+
+	var batch []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.id, &row.payload, &row.headers, &row.createdAt); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", r.store.table, err)
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// publish sends the event of row, waiting for Kafka to acknowledge it.
+func (r *Relay) publish(row outboxRow) error {
+	var event kafka.UserEvent
+	if err := json.Unmarshal(row.payload, &event); err != nil {
+		return fmt.Errorf("failed to decode outbox row %d: %w", row.id, err)
+	}
+	headers := make(map[string]string)
+	if err := json.Unmarshal(row.headers, &headers); err != nil {
+		return fmt.Errorf("failed to decode headers of outbox row %d: %w", row.id, err)
+	}
+	headers[IDHeader] = strconv.FormatInt(row.id, 10)
+
+	d := r.producer.Send(event, headers)
+	if d.Err != nil {
+		return fmt.Errorf("failed to publish outbox row %d: %w", row.id, d.Err)
+	}
+	delay := time.Since(row.createdAt)
+	r.published++
+	r.delay += delay
+	r.maxDelay = max(r.maxDelay, delay)
+	slog.Debug("Outbox row published", "id", row.id, "topic", r.producer.Topic(), "partition", d.Partition,
+		"offset", d.Offset, "key", d.Key, "event_type", event.EventType, "delay", delay)
+	return nil
+}
+
+// logSummary logs the rows published and how long they waited in the
+// outbox, from their commit to Kafka's acknowledgement.
+func (r *Relay) logSummary() {
+	var avgDelay time.Duration
+	if r.published > 0 {
+		avgDelay = r.delay / time.Duration(r.published)
+	}
+	slog.Info("Outbox relay summary", "topic", r.producer.Topic(), "published", r.published, "batches", r.batches,
+		"avg_delay", avgDelay, "max_delay", r.maxDelay)
+}
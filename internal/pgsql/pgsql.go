@@ -0,0 +1,18 @@
+// Package pgsql holds the helpers the PostgreSQL sink, checkpoint store and
+// outbox share. Importing it registers the lib/pq driver.
+package pgsql
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// QuoteTable quotes a table name, which may be qualified by a schema.
+func QuoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
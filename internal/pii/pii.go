@@ -0,0 +1,168 @@
+// Package pii hides personal data in events before they are produced, so
+// the topics hold no more of it than the consumers need. Fields are either
+// masked, which can't be undone, or replaced with tokens whose values are
+// kept in a Vault outside of Kafka, so a trusted consumer can reveal them
+// again and erasing a person only means deleting their vault entries.
+package pii
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Modes of a Masker.
+const (
+	// ModeMask hides all but the last four characters of each field.
+	ModeMask = "mask"
+	// ModeTokenize replaces each field with a token from the vault.
+	ModeTokenize = "tokenize"
+	// ModeReveal replaces the tokens in each field with their values.
+	ModeReveal = "reveal"
+)
+
+// Modes lists the modes New accepts.
+var Modes = []string{ModeMask, ModeTokenize, ModeReveal}
+
+// DefaultFields are the personal fields of the generated events.
+var DefaultFields = []string{"data.ip_address", "data.email"}
+
+// Masker applies one mode to a fixed set of fields. It is safe for
+// concurrent use.
+type Masker struct {
+	mode   string
+	fields []string
+	vault  *Vault
+}
+
+// New returns a Masker for fields, dotted paths into the JSON form of an
+// event such as data.email. Tokenizing and revealing need a vault.
+func New(mode string, fields []string, vault *Vault) (*Masker, error) {
+	switch mode {
+	case ModeMask:
+	case ModeTokenize, ModeReveal:
+		if vault == nil {
+			return nil, fmt.Errorf("pii mode %s needs a vault", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown pii mode %q (want one of %s)", mode, strings.Join(Modes, ", "))
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no pii fields")
+	}
+	for _, field := range fields {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("invalid pii field %q", field)
+		}
+	}
+	return &Masker{mode: mode, fields: fields, vault: vault}, nil
+}
+
+// Mode returns the mode the Masker applies.
+func (m *Masker) Mode() string {
+	return m.mode
+}
+
+// Fields returns the fields the Masker applies to.
+func (m *Masker) Fields() []string {
+	return m.fields
+}
+
+// Apply changes the fields of doc, the JSON form of an event, in place.
+// Missing and null fields are left alone, and so are values that aren't
+// tokens when revealing. Other values than strings are tokenized in their
+// JSON encoding and revealed as strings.
+func (m *Masker) Apply(doc map[string]any) error {
+	for _, field := range m.fields {
+		parent, key := parentOf(doc, field)
+		if parent == nil {
+			continue
+		}
+		value, ok := parent[key]
+		if !ok || value == nil {
+			continue
+		}
+
+		switch m.mode {
+		case ModeMask:
+			parent[key] = mask(value)
+		case ModeTokenize:
+			token, err := m.vault.Tokenize(text(value))
+			if err != nil {
+				return err
+			}
+			parent[key] = token
+		case ModeReveal:
+			if s, ok := value.(string); ok {
+				if original, ok := m.vault.Reveal(s); ok {
+					parent[key] = original
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Event applies the Masker to event through its JSON form.
+func (m *Masker) Event(event kafka.UserEvent) (kafka.UserEvent, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return event, err
+	}
+	if err := m.Apply(doc); err != nil {
+		return event, err
+	}
+
+	var masked kafka.UserEvent
+	if data, err = json.Marshal(doc); err != nil {
+		return event, err
+	}
+	return masked, json.Unmarshal(data, &masked)
+}
+
+// maskKeep is how many trailing characters mask leaves readable, the same
+// as the mask statement of the pipeline transformations.
+const maskKeep = 4
+
+// mask hides all of value but its last characters, or all of it if it is
+// short or not a string.
+func mask(value any) string {
+	s, ok := value.(string)
+	if !ok || len([]rune(s)) <= maskKeep {
+		return strings.Repeat("*", maskKeep)
+	}
+	runes := []rune(s)
+	return strings.Repeat("*", len(runes)-maskKeep) + string(runes[len(runes)-maskKeep:])
+}
+
+func text(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// parentOf returns the object holding the last field of path, or nil if
+// there is none.
+func parentOf(doc map[string]any, path string) (map[string]any, string) {
+	fields := strings.Split(path, ".")
+	current := doc
+	for _, field := range fields[:len(fields)-1] {
+		object, ok := current[field].(map[string]any)
+		if !ok {
+			return nil, ""
+		}
+		current = object
+	}
+	return current, fields[len(fields)-1]
+}
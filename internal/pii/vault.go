@@ -0,0 +1,117 @@
+package pii
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TokenPrefix starts every token, so tokens are told apart from the values
+// they replace.
+const TokenPrefix = "tok_"
+
+// Vault maps tokens to the values they replace. It is kept in a file of one
+// JSON object per line, appended to as new values come in, so nothing is
+// lost if the process dies. The same value always gets the same token, so
+// tokenized fields can still be counted and joined on. The file is as
+// sensitive as the data itself and is created readable by its owner only.
+type Vault struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File
+	tokens map[string]string // token to value
+	values map[string]string // value to token
+}
+
+type vaultEntry struct {
+	Token string `json:"token"`
+	Value string `json:"value"`
+}
+
+// OpenVault loads the vault at path, creating the file if it doesn't exist.
+func OpenVault(path string) (*Vault, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+
+	v := &Vault{path: path, file: file, tokens: make(map[string]string), values: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry vaultEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s:%d: invalid vault entry: %w", path, line, err)
+		}
+		v.tokens[entry.Token] = entry.Value
+		v.values[entry.Value] = entry.Token
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+	return v, nil
+}
+
+// Path returns the file the vault is kept in.
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// Len returns the number of tokens in the vault.
+func (v *Vault) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.tokens)
+}
+
+// Tokenize returns the token of value, adding one to the vault if value is
+// new.
+func (v *Vault) Tokenize(value string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if token, ok := v.values[value]; ok {
+		return token, nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := TokenPrefix + hex.EncodeToString(b)
+	line, err := json.Marshal(vaultEntry{Token: token, Value: value})
+	if err != nil {
+		return "", err
+	}
+	if _, err := v.file.Write(append(line, '\n')); err != nil {
+		return "", fmt.Errorf("failed to write vault: %w", err)
+	}
+	v.tokens[token] = value
+	v.values[value] = token
+	return token, nil
+}
+
+// Reveal returns the value token replaced.
+func (v *Vault) Reveal(token string) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.tokens[token]
+	return value, ok
+}
+
+// Close syncs the vault to disk and closes its file.
+func (v *Vault) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return errors.Join(v.file.Sync(), v.file.Close())
+}
@@ -0,0 +1,97 @@
+// Package ratelimit provides the token bucket the producer uses to drive a
+// precise, optionally increasing, message rate.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket. Tokens refill at the current rate up to burst,
+// and every Wait takes one.
+type Limiter struct {
+	rateAt func(elapsed time.Duration) float64
+	burst  float64
+
+	mu     sync.Mutex
+	start  time.Time
+	last   time.Time
+	tokens float64
+}
+
+// New returns a limiter allowing rate events per second with bursts of up
+// to burst events.
+func New(rate float64, burst int) *Limiter {
+	return newLimiter(func(time.Duration) float64 { return rate }, burst)
+}
+
+// NewRamp returns a limiter whose rate grows linearly from `from` to `to`
+// events per second over the given duration and then stays at `to`.
+func NewRamp(from, to float64, over time.Duration, burst int) *Limiter {
+	return newLimiter(func(elapsed time.Duration) float64 {
+		if over <= 0 || elapsed >= over {
+			return to
+		}
+		return from + (to-from)*elapsed.Seconds()/over.Seconds()
+	}, burst)
+}
+
+func newLimiter(rateAt func(time.Duration) float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	return &Limiter{
+		rateAt: rateAt,
+		burst:  float64(burst),
+		start:  now,
+		last:   now,
+		tokens: float64(burst),
+	}
+}
+
+// Rate returns the current rate in events per second.
+func (l *Limiter) Rate() float64 {
+	return l.rateAt(time.Since(l.start))
+}
+
+// Wait blocks until an event is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if one is available and returns zero, or returns
+// how long until the next token is due.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := l.rateAt(now.Sub(l.start))
+	l.tokens = math.Min(l.burst, l.tokens+rate*now.Sub(l.last).Seconds())
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	if rate <= 0 {
+		return time.Second
+	}
+	return time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
@@ -0,0 +1,347 @@
+// Package restproxy is a minimal stand-in for the Confluent REST Proxy
+// (API v2) for local development: producing JSON or base64 records with
+// POST /topics/{topic} and listing partitions with GET
+// /topics/{topic}/partitions. Consumers, schemas and the admin endpoints are
+// not implemented.
+package restproxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/IBM/sarama"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// maxRequestBytes bounds the body of one POST.
+const maxRequestBytes = 10 << 20
+
+// Content types of the v2 API. Plain application/json is read as JSON
+// records too.
+const (
+	contentTypeJSON   = "application/vnd.kafka.json.v2+json"
+	contentTypeBinary = "application/vnd.kafka.binary.v2+json"
+	contentTypeV2     = "application/vnd.kafka.v2+json"
+)
+
+// Error codes of the REST Proxy, returned in the error_code field.
+const (
+	errorCodeTopicNotFound     = 40401
+	errorCodeMethodNotAllowed  = 40501
+	errorCodeUnsupportedFormat = 41501
+	errorCodeInvalidRequest    = 42201
+	errorCodeKafka             = 50001
+	errorCodeRecordNotRetried  = 1
+	errorCodeRecordRetriable   = 2
+)
+
+// Proxy serves the endpoints. It is an http.Handler.
+type Proxy struct {
+	producer *kafka.RecordProducer
+	admin    sarama.ClusterAdmin
+	mux      *http.ServeMux
+}
+
+// New creates a proxy sending with producer and describing topics with
+// admin.
+func New(producer *kafka.RecordProducer, admin sarama.ClusterAdmin) *Proxy {
+	p := &Proxy{producer: producer, admin: admin, mux: http.NewServeMux()}
+	p.mux.HandleFunc("/topics", p.topics)
+	p.mux.HandleFunc("/topics/", p.topic)
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// request is the body of a produce request. A body without records is read
+// as a single record.
+type request struct {
+	Records []record `json:"records"`
+}
+
+type record struct {
+	Key       json.RawMessage `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value"`
+	Partition *int32          `json:"partition,omitempty"`
+}
+
+type offset struct {
+	Partition *int32  `json:"partition"`
+	Offset    *int64  `json:"offset"`
+	ErrorCode *int    `json:"error_code"`
+	Error     *string `json:"error"`
+}
+
+type produceResponse struct {
+	KeySchemaID   *int     `json:"key_schema_id"`
+	ValueSchemaID *int     `json:"value_schema_id"`
+	Offsets       []offset `json:"offsets"`
+}
+
+type partitionResponse struct {
+	Partition int32             `json:"partition"`
+	Leader    int32             `json:"leader"`
+	Replicas  []replicaResponse `json:"replicas"`
+}
+
+type replicaResponse struct {
+	Broker int32 `json:"broker"`
+	Leader bool  `json:"leader"`
+	InSync bool  `json:"in_sync"`
+}
+
+// topics lists the topic names, like GET /topics of the REST Proxy.
+func (p *Proxy) topics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	topics, err := p.admin.ListTopics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errorCodeKafka, err.Error())
+		return
+	}
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		if !strings.HasPrefix(name, "_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+// topic routes /topics/{topic} and /topics/{topic}/partitions.
+func (p *Proxy) topic(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/topics/"), "/")
+	switch {
+	case name == "":
+		http.NotFound(w, r)
+	case rest == "":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		p.produce(w, r, name)
+	case rest == "partitions":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		p.partitions(w, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Proxy) produce(w http.ResponseWriter, r *http.Request, topic string) {
+	binary, err := binaryFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, errorCodeUnsupportedFormat, err.Error())
+		return
+	}
+
+	records, err := decodeRecords(http.MaxBytesReader(w, r.Body, maxRequestBytes), binary)
+	if err != nil {
+		slog.Warn("Rejected produce request", "topic", topic, "remote", r.RemoteAddr, "error", err)
+		writeError(w, http.StatusUnprocessableEntity, errorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// Answer 404 for unknown topics like the REST Proxy rather than leaving
+	// them to the brokers, which may create them.
+	if _, err := p.describe(topic); err != nil {
+		writeTopicError(w, err)
+		return
+	}
+
+	deliveries := p.producer.SendRecords(topic, records)
+	response := produceResponse{Offsets: make([]offset, len(deliveries))}
+	failed := 0
+	for i, d := range deliveries {
+		if d.Err != nil {
+			failed++
+			code, message := errorCodeRecordRetriable, d.Err.Error()
+			if errors.Is(d.Err, kafka.ErrInvalidPartition) {
+				code = errorCodeRecordNotRetried
+			}
+			response.Offsets[i] = offset{ErrorCode: &code, Error: &message}
+			continue
+		}
+		response.Offsets[i] = offset{Partition: &d.Partition, Offset: &d.Offset}
+	}
+	slog.Debug("Produced records", "topic", topic, "records", len(records), "failed", failed)
+	if failed > 0 {
+		slog.Warn("Some records failed", "topic", topic, "records", len(records), "failed", failed)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// binaryFormat reports whether records are base64 encoded, from the
+// embedded format of the content type.
+func binaryFormat(contentType string) (bool, error) {
+	if contentType == "" {
+		return false, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, fmt.Errorf("invalid content type: %w", err)
+	}
+	switch mediaType {
+	case contentTypeJSON, "application/json":
+		return false, nil
+	case contentTypeBinary:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported content type %s, want %s or %s", mediaType, contentTypeJSON, contentTypeBinary)
+	}
+}
+
+// decodeRecords reads a body with a records array, or a single record.
+// JSON string keys are sent as the plain string so they partition like the
+// keys of the produce command; other keys and all values are sent as JSON.
+func decodeRecords(body io.Reader, binary bool) ([]kafka.Record, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	var records []record
+	if _, ok := fields["records"]; ok {
+		var batch request
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, fmt.Errorf("invalid records: %w", err)
+		}
+		if len(batch.Records) == 0 {
+			return nil, errors.New("no records in request")
+		}
+		records = batch.Records
+	} else {
+		var single record
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("invalid record: %w", err)
+		}
+		records = []record{single}
+	}
+
+	result := make([]kafka.Record, len(records))
+	for i, r := range records {
+		if len(r.Value) == 0 {
+			return nil, fmt.Errorf("record %d has no value", i+1)
+		}
+		key, err := decodeField(r.Key, binary, true)
+		if err != nil {
+			return nil, fmt.Errorf("record %d key: %w", i+1, err)
+		}
+		value, err := decodeField(r.Value, binary, false)
+		if err != nil {
+			return nil, fmt.Errorf("record %d value: %w", i+1, err)
+		}
+		result[i] = kafka.Record{Key: key, Value: value, Partition: r.Partition}
+	}
+	return result, nil
+}
+
+// decodeField turns a key or value of a request into message bytes. A
+// missing or null field is nil, i.e. no key or a tombstone.
+func decodeField(field json.RawMessage, binary, key bool) ([]byte, error) {
+	if len(field) == 0 || string(field) == "null" {
+		return nil, nil
+	}
+	if binary {
+		var encoded string
+		if err := json.Unmarshal(field, &encoded); err != nil {
+			return nil, errors.New("binary records need base64 strings")
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	if key {
+		var s string
+		if err := json.Unmarshal(field, &s); err == nil {
+			return []byte(s), nil
+		}
+	}
+	return field, nil
+}
+
+func (p *Proxy) partitions(w http.ResponseWriter, topic string) {
+	metadata, err := p.describe(topic)
+	if err != nil {
+		writeTopicError(w, err)
+		return
+	}
+
+	partitions := metadata.Partitions
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+	response := make([]partitionResponse, len(partitions))
+	for i, partition := range partitions {
+		inSync := make(map[int32]bool, len(partition.Isr))
+		for _, id := range partition.Isr {
+			inSync[id] = true
+		}
+		replicas := make([]replicaResponse, len(partition.Replicas))
+		for j, id := range partition.Replicas {
+			replicas[j] = replicaResponse{Broker: id, Leader: id == partition.Leader, InSync: inSync[id]}
+		}
+		response[i] = partitionResponse{Partition: partition.ID, Leader: partition.Leader, Replicas: replicas}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// errTopicNotFound is returned by describe for a topic the brokers don't
+// know.
+var errTopicNotFound = errors.New("topic not found")
+
+func (p *Proxy) describe(topic string) (*sarama.TopicMetadata, error) {
+	metadata, err := p.admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 || metadata[0].Err == sarama.ErrUnknownTopicOrPartition {
+		return nil, fmt.Errorf("%w: %s", errTopicNotFound, topic)
+	}
+	if metadata[0].Err != sarama.ErrNoError {
+		return nil, metadata[0].Err
+	}
+	return metadata[0], nil
+}
+
+func writeTopicError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTopicNotFound) {
+		writeError(w, http.StatusNotFound, errorCodeTopicNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, errorCodeKafka, err.Error())
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{"error_code": code, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", contentTypeV2)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package serde
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// UserEventSchema is the Avro schema registered for UserEvent values.
+const UserEventSchema = `{
+  "type": "record",
+  "name": "UserEvent",
+  "namespace": "com.hwsw.events",
+  "fields": [
+    {"name": "user_id", "type": "string"},
+    {"name": "event_type", "type": "string"},
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "data", "type": {"type": "map", "values": "string"}, "default": {}}
+  ]
+}`
+
+// magicByte prefixes every message in the Confluent wire format, followed by
+// a 4 byte big-endian schema ID and the Avro binary payload.
+const magicByte = 0x0
+
+// AvroSerializer encodes events in the Confluent Schema Registry wire format
+// so they can be read by Kafka Connect, ksqlDB and other registry-aware tools.
+type AvroSerializer struct {
+	registry *RegistryClient
+	subject  string
+
+	mu     sync.Mutex
+	codecs map[int]*goavro.Codec
+}
+
+// NewAvroSerializer creates a serializer that registers UserEventSchema under
+// the subject for topic, following the default TopicNameStrategy.
+func NewAvroSerializer(registry *RegistryClient, topic string) *AvroSerializer {
+	return &AvroSerializer{
+		registry: registry,
+		subject:  topic + "-value",
+		codecs:   make(map[int]*goavro.Codec),
+	}
+}
+
+func (s *AvroSerializer) ContentType() string {
+	return "application/avro"
+}
+
+func (s *AvroSerializer) Serialize(event kafka.UserEvent) ([]byte, error) {
+	id, err := s.registry.Register(s.subject, UserEventSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := s.codec(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{}, len(event.Data))
+	for k, v := range event.Data {
+		data[k] = fmt.Sprint(v)
+	}
+
+	header := make([]byte, 5)
+	header[0] = magicByte
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+
+	payload, err := codec.BinaryFromNative(header, map[string]interface{}{
+		"user_id":    event.UserID,
+		"event_type": event.EventType,
+		"timestamp":  event.Timestamp,
+		"data":       data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode avro event: %w", err)
+	}
+	return payload, nil
+}
+
+func (s *AvroSerializer) Deserialize(payload []byte) (kafka.UserEvent, error) {
+	if len(payload) < 5 || payload[0] != magicByte {
+		return kafka.UserEvent{}, fmt.Errorf("payload is not in schema registry wire format")
+	}
+
+	id := int(binary.BigEndian.Uint32(payload[1:5]))
+	codec, err := s.codec(id)
+	if err != nil {
+		return kafka.UserEvent{}, err
+	}
+
+	native, _, err := codec.NativeFromBinary(payload[5:])
+	if err != nil {
+		return kafka.UserEvent{}, fmt.Errorf("failed to decode avro event: %w", err)
+	}
+
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return kafka.UserEvent{}, fmt.Errorf("unexpected avro value %T", native)
+	}
+
+	event := kafka.UserEvent{}
+	event.UserID, _ = record["user_id"].(string)
+	event.EventType, _ = record["event_type"].(string)
+	if ts, ok := record["timestamp"].(time.Time); ok {
+		event.Timestamp = ts
+	}
+	if data, ok := record["data"].(map[string]interface{}); ok && len(data) > 0 {
+		event.Data = data
+	}
+	return event, nil
+}
+
+func (s *AvroSerializer) codec(id int) (*goavro.Codec, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if codec, ok := s.codecs[id]; ok {
+		return codec, nil
+	}
+
+	schema, err := s.registry.Schema(id)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %d: %w", id, err)
+	}
+	s.codecs[id] = codec
+	return codec, nil
+}
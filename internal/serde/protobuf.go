@@ -0,0 +1,56 @@
+package serde
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	eventsv1 "kafka-hwsw/api/events/v1"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// ProtobufSerializer encodes events using the generated eventsv1.UserEvent message.
+type ProtobufSerializer struct{}
+
+func (ProtobufSerializer) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (ProtobufSerializer) Serialize(event kafka.UserEvent) ([]byte, error) {
+	msg := &eventsv1.UserEvent{
+		UserId:    event.UserID,
+		EventType: event.EventType,
+		Timestamp: timestamppb.New(event.Timestamp),
+		Data:      make(map[string]string, len(event.Data)),
+	}
+	for k, v := range event.Data {
+		msg.Data[k] = fmt.Sprint(v)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal protobuf event: %w", err)
+	}
+	return data, nil
+}
+
+func (ProtobufSerializer) Deserialize(data []byte) (kafka.UserEvent, error) {
+	var msg eventsv1.UserEvent
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return kafka.UserEvent{}, fmt.Errorf("failed to unmarshal protobuf event: %w", err)
+	}
+
+	event := kafka.UserEvent{
+		UserID:    msg.GetUserId(),
+		EventType: msg.GetEventType(),
+		Timestamp: msg.GetTimestamp().AsTime(),
+	}
+	if len(msg.GetData()) > 0 {
+		event.Data = make(map[string]interface{}, len(msg.GetData()))
+		for k, v := range msg.GetData() {
+			event.Data[k] = v
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,111 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryClient talks to a Confluent-compatible Schema Registry and caches
+// schema IDs and definitions so each is only fetched once.
+type RegistryClient struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	ids     map[string]int
+	schemas map[int]string
+}
+
+func NewRegistryClient(baseURL string) *RegistryClient {
+	return &RegistryClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		ids:        make(map[string]int),
+		schemas:    make(map[int]string),
+	}
+}
+
+// Register registers schema under subject, returning its global ID. The
+// registry returns the existing ID if the schema is already registered.
+func (c *RegistryClient) Register(subject, schema string) (int, error) {
+	cacheKey := subject + "\x00" + schema
+
+	c.mu.Lock()
+	if id, ok := c.ids[cacheKey]; ok {
+		c.mu.Unlock()
+		return id, nil
+	}
+	c.mu.Unlock()
+
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode schema: %w", err)
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	url := fmt.Sprintf("%s/subjects/%s/versions", c.baseURL, subject)
+	if err := c.do(http.MethodPost, url, body, &resp); err != nil {
+		return 0, fmt.Errorf("failed to register schema for subject %s: %w", subject, err)
+	}
+
+	c.mu.Lock()
+	c.ids[cacheKey] = resp.ID
+	c.schemas[resp.ID] = schema
+	c.mu.Unlock()
+
+	return resp.ID, nil
+}
+
+// Schema returns the schema definition registered under id.
+func (c *RegistryClient) Schema(id int) (string, error) {
+	c.mu.Lock()
+	if schema, ok := c.schemas[id]; ok {
+		c.mu.Unlock()
+		return schema, nil
+	}
+	c.mu.Unlock()
+
+	var resp struct {
+		Schema string `json:"schema"`
+	}
+	url := fmt.Sprintf("%s/schemas/ids/%d", c.baseURL, id)
+	if err := c.do(http.MethodGet, url, nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = resp.Schema
+	c.mu.Unlock()
+
+	return resp.Schema, nil
+}
+
+func (c *RegistryClient) do(method, url string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("schema registry returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
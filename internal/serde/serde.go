@@ -0,0 +1,43 @@
+// Package serde selects the wire format used for UserEvent message values.
+package serde
+
+import (
+	"fmt"
+	"strings"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// New returns the serializer for format. registryURL is only used by
+// formats that depend on a Schema Registry.
+func New(format, topic, registryURL string) (kafka.Serializer, error) {
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return kafka.JSONSerializer{}, nil
+	case FormatAvro:
+		if registryURL == "" {
+			return nil, fmt.Errorf("SCHEMA_REGISTRY_URL is required for the avro format")
+		}
+		return NewAvroSerializer(NewRegistryClient(registryURL), topic), nil
+	case FormatProtobuf:
+		return ProtobufSerializer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported message format: %s", format)
+	}
+}
+
+// Available returns every serializer that can be used with the given
+// settings, for consumers that pick the format from the content-type header.
+func Available(topic, registryURL string) []kafka.Serializer {
+	serializers := []kafka.Serializer{kafka.JSONSerializer{}, ProtobufSerializer{}}
+	if registryURL != "" {
+		serializers = append(serializers, NewAvroSerializer(NewRegistryClient(registryURL), topic))
+	}
+	return serializers
+}
@@ -0,0 +1,44 @@
+// Package shutdown turns SIGINT and SIGTERM into context cancellation with a
+// hard deadline, so the binaries can drain in-flight work before exiting.
+package shutdown
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"kafka-hwsw/internal/logging"
+)
+
+// NotifyContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM. If the process is still running timeout after that, or a second
+// signal arrives, it exits immediately.
+func NotifyContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigChan:
+			slog.Info("Received shutdown signal, draining in-flight work...", "signal", sig.String(), "timeout", timeout)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sigChan)
+			return
+		}
+
+		select {
+		case <-sigChan:
+			logging.Fatal("Received second shutdown signal, exiting immediately")
+		case <-time.After(timeout):
+			logging.Fatal("Shutdown timed out, exiting", "timeout", timeout)
+		}
+	}()
+
+	return ctx, cancel
+}
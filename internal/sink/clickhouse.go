@@ -0,0 +1,277 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// ClickHouseSink inserts records as UserEvent rows into a ClickHouse table
+// over its HTTP interface, creating the table if needed. ClickHouse prefers
+// few large inserts to many small ones, so the batches of all partitions
+// are buffered and inserted together once batchSize rows are waiting or
+// flushInterval after the first of them. Write only returns once its rows
+// were inserted, so offsets are still committed after the rows are stored,
+// at least once.
+//
+// The table is a ReplacingMergeTree whose sorting key ends with the message
+// coordinates: rows inserted again after a crash are merged away in the
+// background, and queries with FINAL never count them twice.
+type ClickHouseSink struct {
+	httpClient    *http.Client
+	url           string
+	database      string
+	table         string
+	username      string
+	password      string
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	rows    bytes.Buffer
+	count   int
+	waiters []chan error
+	timer   *time.Timer
+}
+
+// clickHouseRow is a record as it is inserted.
+type clickHouseRow struct {
+	Topic          string  `json:"topic"`
+	Partition      int32   `json:"partition"`
+	Offset         int64   `json:"offset"`
+	Key            string  `json:"key"`
+	UserID         string  `json:"user_id"`
+	EventType      string  `json:"event_type"`
+	EventTime      string  `json:"event_time"`
+	Amount         float64 `json:"amount"`
+	Data           string  `json:"data"`
+	KafkaTimestamp string  `json:"kafka_timestamp"`
+}
+
+// NewClickHouseSink connects to the server at rawURL, e.g.
+// http://localhost:8123, and creates table in database if it doesn't exist.
+func NewClickHouseSink(rawURL, database, table, username, password string, batchSize int, flushInterval time.Duration) (*ClickHouseSink, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no ClickHouse URL configured")
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse URL %s: %w", rawURL, err)
+	}
+	if !tableName.MatchString(database) || strings.Contains(database, ".") {
+		return nil, fmt.Errorf("invalid ClickHouse database name: %q", database)
+	}
+	if !tableName.MatchString(table) || strings.Contains(table, ".") {
+		return nil, fmt.Errorf("invalid ClickHouse table name: %q", table)
+	}
+	if batchSize < 1 || flushInterval <= 0 {
+		return nil, fmt.Errorf("invalid ClickHouse batch: %d rows, interval %s", batchSize, flushInterval)
+	}
+
+	s := &ClickHouseSink{
+		httpClient:    &http.Client{Timeout: 60 * time.Second},
+		url:           strings.TrimSuffix(rawURL, "/"),
+		database:      database,
+		table:         table,
+		username:      username,
+		password:      password,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.exec(ctx, s.createTable(), nil); err != nil {
+		return nil, fmt.Errorf("failed to create table %s.%s: %w", database, table, err)
+	}
+	return s, nil
+}
+
+func (s *ClickHouseSink) qualifiedTable() string {
+	return "`" + s.database + "`.`" + s.table + "`"
+}
+
+func (s *ClickHouseSink) createTable() string {
+	return `CREATE TABLE IF NOT EXISTS ` + s.qualifiedTable() + ` (
+	topic LowCardinality(String),
+	partition UInt32,
+	offset UInt64,
+	key String,
+	user_id String,
+	event_type LowCardinality(String),
+	event_time DateTime64(3, 'UTC'),
+	amount Float64,
+	data String,
+	kafka_timestamp DateTime64(3, 'UTC'),
+	inserted_at DateTime DEFAULT now()
+)
+ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY (event_type, event_time, topic, partition, offset)`
+}
+
+// Write buffers the rows of records and waits until they were inserted.
+func (s *ClickHouseSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	var rows bytes.Buffer
+	count := 0
+	encoder := json.NewEncoder(&rows)
+	for _, record := range records {
+		if record.Tombstone {
+			continue
+		}
+		if err := encoder.Encode(clickHouseRowOf(record)); err != nil {
+			return fmt.Errorf("failed to encode record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	s.mu.Lock()
+	s.rows.Write(rows.Bytes())
+	s.count += count
+	s.waiters = append(s.waiters, done)
+	if s.count >= s.batchSize {
+		s.flushLocked()
+	} else if s.timer == nil {
+		s.timer = time.AfterFunc(s.flushInterval, s.flush)
+	}
+	s.mu.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// The rows may still be inserted; without their offsets committed
+		// they are inserted again later.
+		return ctx.Err()
+	}
+}
+
+func (s *ClickHouseSink) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+// flushLocked inserts the buffered rows in the background and tells every
+// Write waiting for them how it went.
+func (s *ClickHouseSink) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.count == 0 {
+		return
+	}
+	body := bytes.Clone(s.rows.Bytes())
+	count, waiters := s.count, s.waiters
+	s.rows.Reset()
+	s.count = 0
+	s.waiters = nil
+
+	go func() {
+		start := time.Now()
+		err := s.exec(context.Background(), "INSERT INTO "+s.qualifiedTable()+" FORMAT JSONEachRow", body)
+		if err != nil {
+			err = fmt.Errorf("failed to insert %d rows into %s.%s: %w", count, s.database, s.table, err)
+		} else {
+			slog.Debug("Rows inserted", "table", s.database+"."+s.table, "rows", count, "batches", len(waiters),
+				"took", time.Since(start).Round(time.Millisecond))
+		}
+		for _, waiter := range waiters {
+			waiter <- err
+		}
+	}()
+}
+
+// exec runs query, with body as its data if it has one.
+func (s *ClickHouseSink) exec(ctx context.Context, query string, body []byte) error {
+	params := url.Values{
+		"database":               {s.database},
+		"date_time_input_format": {"best_effort"},
+	}
+	var reader io.Reader = strings.NewReader(query)
+	if body != nil {
+		params.Set("query", query)
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/?"+params.Encode(), reader)
+	if err != nil {
+		return err
+	}
+	if s.username != "" {
+		req.Header.Set("X-ClickHouse-User", s.username)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("clickhouse returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close inserts the rows still buffered, without waiting for them.
+func (s *ClickHouseSink) Close() error {
+	s.flush()
+	return nil
+}
+
+// clickHouseRowOf turns record into a row, reading the UserEvent fields from
+// its value. A value that isn't one keeps only the coordinates, with the key
+// as the user ID and the record time as the event time.
+func clickHouseRowOf(record kafka.SinkRecord) clickHouseRow {
+	row := clickHouseRow{
+		Topic:          record.Topic,
+		Partition:      record.Partition,
+		Offset:         record.Offset,
+		Key:            record.Key,
+		UserID:         record.Key,
+		EventTime:      record.Timestamp.UTC().Format(time.RFC3339Nano),
+		KafkaTimestamp: record.Timestamp.UTC().Format(time.RFC3339Nano),
+		Data:           string(record.Value),
+	}
+
+	var event kafka.UserEvent
+	if json.Unmarshal(record.Value, &event) != nil || event.EventType == "" {
+		return row
+	}
+	if event.UserID != "" {
+		row.UserID = event.UserID
+	}
+	row.EventType = event.EventType
+	if !event.Timestamp.IsZero() {
+		row.EventTime = event.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	row.Data = "{}"
+	if len(event.Data) > 0 {
+		data, _ := json.Marshal(event.Data)
+		row.Data = string(data)
+	}
+	switch amount := event.Data["amount"].(type) {
+	case string:
+		row.Amount, _ = strconv.ParseFloat(amount, 64)
+	case float64:
+		row.Amount = amount
+	}
+	return row
+}
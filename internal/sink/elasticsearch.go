@@ -0,0 +1,308 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// elasticsearchRetries is how often the documents Elasticsearch rejected
+// with 429 Too Many Requests are sent again within one Write, waiting
+// twice as long each time, starting at elasticsearchRetryBackoff.
+const (
+	elasticsearchRetries      = 5
+	elasticsearchRetryBackoff = 500 * time.Millisecond
+)
+
+var indexName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ElasticsearchSink bulk-indexes records into Elasticsearch or OpenSearch,
+// one index per day of event time, <index>-yyyy.mm.dd, all covered by an
+// index template the sink installs on startup. Every record becomes a
+// document with the ID <topic>-<partition>-<offset>, so a batch indexed
+// again after a crash replaces its documents instead of duplicating them.
+// Tombstones are skipped.
+//
+// Write returns once every document was acknowledged. Documents rejected
+// with 429 Too Many Requests, or a bulk request rejected as a whole, are
+// sent again with backoff; documents rejected for good, e.g. because they
+// don't fit the mapping, are logged and left out.
+type ElasticsearchSink struct {
+	httpClient *http.Client
+	url        string
+	index      string
+	username   string
+	password   string
+}
+
+// NewElasticsearchSink connects to the cluster at rawURL and installs the
+// index template of index, replacing an older version of it.
+func NewElasticsearchSink(rawURL, index, username, password string) (*ElasticsearchSink, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no Elasticsearch URL configured")
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %s: %w", rawURL, err)
+	}
+	if !indexName.MatchString(index) {
+		return nil, fmt.Errorf("invalid Elasticsearch index name: %q", index)
+	}
+
+	s := &ElasticsearchSink{
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		url:        strings.TrimSuffix(rawURL, "/"),
+		index:      index,
+		username:   username,
+		password:   password,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.putTemplate(ctx); err != nil {
+		return nil, fmt.Errorf("failed to install index template %s: %w", s.templateName(), err)
+	}
+	return s, nil
+}
+
+func (s *ElasticsearchSink) templateName() string {
+	return s.index + "-template"
+}
+
+// putTemplate installs the index template: event time as @timestamp, the
+// message coordinates and the identifying event fields as exact values,
+// and the rest of the event mapped dynamically.
+func (s *ElasticsearchSink) putTemplate(ctx context.Context) error {
+	template := map[string]any{
+		"index_patterns": []string{s.index + "-*"},
+		"priority":       100,
+		"template": map[string]any{
+			"mappings": map[string]any{
+				"properties": map[string]any{
+					"@timestamp": map[string]any{"type": "date"},
+					"topic":      map[string]any{"type": "keyword"},
+					"partition":  map[string]any{"type": "integer"},
+					"offset":     map[string]any{"type": "long"},
+					"key":        map[string]any{"type": "keyword"},
+					"headers":    map[string]any{"type": "object", "dynamic": true},
+					"event": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"user_id":    map[string]any{"type": "keyword"},
+							"event_type": map[string]any{"type": "keyword"},
+							"timestamp":  map[string]any{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, "/_index_template/"+s.templateName(), "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return elasticsearchError(resp)
+	}
+	slog.Debug("Index template installed", "template", s.templateName(), "pattern", s.index+"-*")
+	return nil
+}
+
+// elasticsearchDocument is a record as it is indexed.
+type elasticsearchDocument struct {
+	Timestamp time.Time         `json:"@timestamp"`
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Event     json.RawMessage   `json:"event"`
+}
+
+// bulkItem is one action of a bulk request: where the document goes and
+// the document itself.
+type bulkItem struct {
+	index    string
+	id       string
+	document []byte
+}
+
+// bulkResponse is the part of a bulk response the sink reads.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []struct {
+		Index struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"index"`
+	} `json:"items"`
+}
+
+func (s *ElasticsearchSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	items := make([]bulkItem, 0, len(records))
+	tombstones := 0
+	for _, record := range records {
+		if record.Tombstone {
+			tombstones++
+			continue
+		}
+		item, err := s.item(record)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	if tombstones > 0 {
+		slog.Debug("Skipped tombstones", "index", s.index, "records", tombstones)
+	}
+
+	indexed, rejected := 0, 0
+	backoff := elasticsearchRetryBackoff
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > elasticsearchRetries {
+				return fmt.Errorf("%d documents still rejected with 429 after %d retries", len(items), elasticsearchRetries)
+			}
+			slog.Warn("Elasticsearch is overloaded, retrying documents", "index", s.index, "documents", len(items),
+				"attempt", attempt, "backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		retry, done, failed, wait, err := s.bulk(ctx, items)
+		if err != nil {
+			return err
+		}
+		indexed += done
+		rejected += failed
+		items = retry
+		if wait > backoff {
+			backoff = wait
+		}
+	}
+	slog.Debug("Documents indexed", "index", s.index, "records", len(records), "indexed", indexed, "rejected", rejected)
+	return nil
+}
+
+// item turns record into the bulk action indexing it.
+func (s *ElasticsearchSink) item(record kafka.SinkRecord) (bulkItem, error) {
+	document, err := json.Marshal(elasticsearchDocument{
+		Timestamp: record.Timestamp,
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Headers:   record.Headers,
+		Event:     record.Value,
+	})
+	if err != nil {
+		return bulkItem{}, fmt.Errorf("failed to encode record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+	}
+	return bulkItem{
+		index:    s.index + "-" + record.Timestamp.UTC().Format("2006.01.02"),
+		id:       fmt.Sprintf("%s-%d-%d", record.Topic, record.Partition, record.Offset),
+		document: document,
+	}, nil
+}
+
+// bulk sends items in one bulk request. It returns the items to send again
+// because they were rejected with 429, how many were indexed, how many were
+// rejected for good and how long the cluster asked to wait, if it did.
+func (s *ElasticsearchSink) bulk(ctx context.Context, items []bulkItem) (retry []bulkItem, indexed, rejected int, wait time.Duration, err error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		action, err := json.Marshal(map[string]any{"index": map[string]string{"_index": item.index, "_id": item.id}})
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(item.document)
+		body.WriteByte('\n')
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("bulk request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return items, 0, 0, time.Duration(seconds) * time.Second, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, 0, 0, fmt.Errorf("bulk request failed: %w", elasticsearchError(resp))
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if len(result.Items) != len(items) {
+		return nil, 0, 0, 0, fmt.Errorf("bulk response has %d items, sent %d", len(result.Items), len(items))
+	}
+	if !result.Errors {
+		return nil, len(items), 0, 0, nil
+	}
+
+	for i, item := range result.Items {
+		switch status := item.Index.Status; {
+		case status == http.StatusTooManyRequests:
+			retry = append(retry, items[i])
+		case item.Index.Error != nil:
+			rejected++
+			slog.Error("Document rejected", "index", items[i].index, "id", items[i].id, "status", status,
+				"type", item.Index.Error.Type, "reason", item.Index.Error.Reason)
+		default:
+			indexed++
+		}
+	}
+	return retry, indexed, rejected, 0, nil
+}
+
+func (s *ElasticsearchSink) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.httpClient.Do(req)
+}
+
+func (s *ElasticsearchSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// elasticsearchError describes an unexpected response.
+func elasticsearchError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("elasticsearch returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// FileSink appends records as newline-delimited JSON to a file in dir, and
+// starts a new file once the current one reaches maxBytes or maxAge. Files
+// are named after the prefix and the time they were started, so they sort
+// in the order they were written. Every batch is synced to disk before
+// Write returns.
+type FileSink struct {
+	dir      string
+	prefix   string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// NewFileSink writes to dir, creating it if needed. A maxBytes or maxAge of
+// zero doesn't rotate by size or age.
+func NewFileSink(dir, prefix string, maxBytes int64, maxAge time.Duration) (*FileSink, error) {
+	if maxBytes < 0 || maxAge < 0 {
+		return nil, fmt.Errorf("invalid file rotation: %d bytes, age %s", maxBytes, maxAge)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory %s: %w", dir, err)
+	}
+	return &FileSink{dir: dir, prefix: prefix, maxBytes: maxBytes, maxAge: maxAge}, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	data, err := ndjson(records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotate(int64(len(data))); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.file.Name(), err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", s.file.Name(), err)
+	}
+	s.size += int64(len(data))
+	return nil
+}
+
+// rotate starts a new file if there is none yet, or if the current one is
+// too old or would grow past maxBytes with n more bytes. A batch always goes
+// into one file, even if it alone is larger than maxBytes.
+func (s *FileSink) rotate(n int64) error {
+	if s.file != nil {
+		full := s.maxBytes > 0 && s.size > 0 && s.size+n > s.maxBytes
+		old := s.maxAge > 0 && time.Since(s.started) >= s.maxAge
+		if !full && !old {
+			return nil
+		}
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	name := filepath.Join(s.dir, fmt.Sprintf("%s-%s.ndjson", s.prefix, now.Format("20060102T150405.000Z")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	s.file = file
+	s.size = 0
+	s.started = now
+	slog.Info("Sink file opened", "file", name)
+	return nil
+}
+
+func (s *FileSink) closeFile() error {
+	name := s.file.Name()
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+	slog.Info("Sink file closed", "file", name, "bytes", s.size)
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.closeFile()
+}
@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"kafka-hwsw/internal/pgsql"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// postgresRowsPerInsert bounds the rows of one INSERT statement, keeping it
+// well below the 65535 parameters Postgres accepts.
+const postgresRowsPerInsert = 1000
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PostgresSink inserts records into a table, creating it if needed. A batch
+// is inserted in one transaction, and records already in the table are
+// skipped, so batches written again after a crash don't duplicate rows.
+type PostgresSink struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresSink connects to dsn and creates table if it doesn't exist.
+func NewPostgresSink(dsn, table string) (*PostgresSink, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("no Postgres DSN configured")
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid Postgres table name: %q", table)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	s := &PostgresSink{db: db, table: pgsql.QuoteTable(table)}
+	if _, err := db.ExecContext(ctx, s.createTable()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table %s: %w", table, err)
+	}
+	return s, nil
+}
+
+func (s *PostgresSink) createTable() string {
+	return `CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+	"topic" TEXT NOT NULL,
+	"partition" INTEGER NOT NULL,
+	"offset" BIGINT NOT NULL,
+	"key" TEXT NOT NULL,
+	"timestamp" TIMESTAMPTZ NOT NULL,
+	"headers" JSONB,
+	"value" JSONB NOT NULL,
+	"written_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY ("topic", "partition", "offset")
+)`
+}
+
+func (s *PostgresSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(records); start += postgresRowsPerInsert {
+		end := start + postgresRowsPerInsert
+		if end > len(records) {
+			end = len(records)
+		}
+		if err := s.insert(ctx, tx, records[start:end]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresSink) insert(ctx context.Context, tx *sql.Tx, records []kafka.SinkRecord) error {
+	const columns = 7
+
+	var query strings.Builder
+	query.WriteString(`INSERT INTO ` + s.table +
+		` ("topic", "partition", "offset", "key", "timestamp", "headers", "value") VALUES `)
+	args := make([]any, 0, len(records)*columns)
+	for i, record := range records {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * columns
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+
+		var headers any
+		if len(record.Headers) > 0 {
+			data, err := json.Marshal(record.Headers)
+			if err != nil {
+				return fmt.Errorf("failed to encode headers of %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+			}
+			headers = string(data)
+		}
+		args = append(args, record.Topic, record.Partition, record.Offset, record.Key, record.Timestamp,
+			headers, string(record.Value))
+	}
+	query.WriteString(` ON CONFLICT ("topic", "partition", "offset") DO NOTHING`)
+
+	if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", s.table, err)
+	}
+	return nil
+}
+
+func (s *PostgresSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,136 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// redisCommandsPerPipeline bounds the commands sent in one pipeline, so a
+// large batch doesn't build one huge request.
+const redisCommandsPerPipeline = 1000
+
+// RedisSink caches the latest record of every key in Redis, the user ID
+// with the default key strategy, under prefix+key with a TTL: a record
+// replaces its key's entry and a tombstone deletes it. A record without a
+// key uses the user_id of its event instead. Every batch is written in
+// pipelines rather than one round trip per key.
+type RedisSink struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// CacheEntry is the cached record of a key, as Lookup returns it.
+type CacheEntry struct {
+	Key    string
+	Record kafka.SinkRecord
+	// TTL is how long the entry has left, 0 if it doesn't expire.
+	TTL time.Duration
+	// Found is false if the key isn't cached.
+	Found bool
+}
+
+// NewRedisSink connects to the Redis server at url, e.g.
+// redis://localhost:6379/0. Entries expire ttl after they were last
+// written; with a ttl of 0 they are kept until replaced or deleted.
+func NewRedisSink(url, prefix string, ttl time.Duration) (*RedisSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("no Redis URL configured")
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("Redis TTL must not be negative, got %s", ttl)
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &RedisSink{client: client, prefix: prefix, ttl: ttl}, nil
+}
+
+// Write caches the last record of every key in records.
+func (s *RedisSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	latest, skipped := latestByKey(records)
+	if skipped > 0 {
+		slog.Warn("Skipped records without a key or user ID", "prefix", s.prefix, "records", skipped)
+	}
+
+	cached, deleted := 0, 0
+	for start := 0; start < len(latest); start += redisCommandsPerPipeline {
+		end := min(start+redisCommandsPerPipeline, len(latest))
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, record := range latest[start:end] {
+				if record.Tombstone {
+					pipe.Del(ctx, s.prefix+record.Key)
+					deleted++
+					continue
+				}
+				data, err := json.Marshal(record)
+				if err != nil {
+					return fmt.Errorf("failed to encode record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+				}
+				pipe.Set(ctx, s.prefix+record.Key, data, s.ttl)
+				cached++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write to Redis: %w", err)
+		}
+	}
+	slog.Debug("Cache updated", "prefix", s.prefix, "records", len(records), "cached", cached, "deleted", deleted)
+	return nil
+}
+
+// Lookup returns the cached entry of every key, in one pipeline.
+func (s *RedisSink) Lookup(ctx context.Context, keys []string) ([]CacheEntry, error) {
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, s.prefix+key)
+			ttls[i] = pipe.PTTL(ctx, s.prefix+key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read from Redis: %w", err)
+	}
+
+	entries := make([]CacheEntry, len(keys))
+	for i, key := range keys {
+		entries[i].Key = key
+		data, err := gets[i].Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", s.prefix+key, err)
+		}
+		if err := json.Unmarshal(data, &entries[i].Record); err != nil {
+			return nil, fmt.Errorf("invalid cache entry %s: %w", s.prefix+key, err)
+		}
+		entries[i].Found = true
+		entries[i].TTL = max(ttls[i].Val(), 0)
+	}
+	return entries, nil
+}
+
+func (s *RedisSink) Close() error {
+	return s.client.Close()
+}
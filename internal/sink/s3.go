@@ -0,0 +1,132 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kafka-hwsw/internal/auth"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// S3Sink uploads every batch as one newline-delimited JSON object, keyed
+// by topic, partition and offset range:
+//
+//	<prefix>/<topic>/partition=<n>/<first offset>-<last offset>.ndjson
+//
+// A batch written again after a crash replaces the same object, unless the
+// batch boundaries moved. Requests are signed with AWS Signature Version 4;
+// an endpoint can be set for S3-compatible stores such as MinIO, which are
+// addressed path-style.
+type S3Sink struct {
+	httpClient  *http.Client
+	endpoint    string
+	pathStyle   bool
+	bucket      string
+	prefix      string
+	region      string
+	credentials auth.AWS
+}
+
+// NewS3Sink creates a sink uploading to the bucket in config.
+func NewS3Sink(config Config) (*S3Sink, error) {
+	if config.S3Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket configured")
+	}
+	if config.S3Region == "" {
+		return nil, fmt.Errorf("no S3 region configured")
+	}
+	credentials := auth.AWS{AccessKey: config.S3AccessKey, SecretKey: config.S3SecretKey, SessionToken: config.S3SessionToken}
+	if !credentials.Enabled() {
+		return nil, fmt.Errorf("no S3 credentials configured")
+	}
+
+	s := &S3Sink{
+		httpClient:  &http.Client{Timeout: 60 * time.Second},
+		endpoint:    strings.TrimSuffix(config.S3Endpoint, "/"),
+		bucket:      config.S3Bucket,
+		prefix:      strings.Trim(config.S3Prefix, "/"),
+		region:      config.S3Region,
+		credentials: credentials,
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+	} else {
+		s.pathStyle = true
+		if _, err := url.Parse(s.endpoint); err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint %s: %w", s.endpoint, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *S3Sink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	body, err := ndjson(records)
+	if err != nil {
+		return err
+	}
+
+	first, last := records[0], records[len(records)-1]
+	key := fmt.Sprintf("%s/partition=%d/%020d-%020d.ndjson", first.Topic, first.Partition, first.Offset, last.Offset)
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	if err := s.put(ctx, key, body); err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+func (s *S3Sink) put(ctx context.Context, key string, body []byte) error {
+	path := "/" + escapePath(key)
+	if s.pathStyle {
+		path = "/" + escapePath(s.bucket) + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.credentials.Sign(req, body, s.region, "s3", time.Now().UTC())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("s3 returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *S3Sink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// escapePath percent-encodes an object key the way Signature Version 4
+// expects: everything but unreserved characters and slashes.
+func escapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+// Package sink implements kafka.Sink for newline-delimited JSON files, S3
+// and Postgres, either as a log of the records or as a table of the latest
+// record of every key, for Redis as a cache of the latest record of every
+// key, for Elasticsearch and OpenSearch as searchable documents, for
+// ClickHouse as rows to analyze and for webhooks as one request per record.
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Sink kinds.
+const (
+	KindFile     = "file"
+	KindS3       = "s3"
+	KindPostgres = "postgres"
+	KindTable    = "table"
+	KindRedis    = "redis"
+
+	KindElasticsearch = "elasticsearch"
+	KindClickHouse    = "clickhouse"
+	KindWebhook       = "webhook"
+)
+
+// Kinds lists the sink kinds New accepts.
+var Kinds = []string{KindFile, KindS3, KindPostgres, KindTable, KindRedis, KindElasticsearch, KindClickHouse, KindWebhook}
+
+// Config holds the settings of every kind of sink; each only reads its own.
+type Config struct {
+	FileDir      string
+	FilePrefix   string
+	FileMaxBytes int64
+	FileMaxAge   time.Duration
+
+	S3Bucket       string
+	S3Prefix       string
+	S3Region       string
+	S3Endpoint     string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3SessionToken string
+
+	PostgresDSN   string
+	PostgresTable string
+
+	// MaterializedTable is the table of the table sink, which shares
+	// PostgresDSN.
+	MaterializedTable string
+
+	RedisURL    string
+	RedisPrefix string
+	RedisTTL    time.Duration
+
+	ElasticsearchURL      string
+	ElasticsearchIndex    string
+	ElasticsearchUsername string
+	ElasticsearchPassword string
+
+	ClickHouseURL           string
+	ClickHouseDatabase      string
+	ClickHouseTable         string
+	ClickHouseUsername      string
+	ClickHousePassword      string
+	ClickHouseBatchSize     int
+	ClickHouseFlushInterval time.Duration
+
+	WebhookURL         string
+	WebhookSecret      string
+	WebhookConcurrency int
+	WebhookTimeout     time.Duration
+	WebhookRetries     int
+	// WebhookDeadLetter receives the records the webhook rejects for good;
+	// nil drops them.
+	WebhookDeadLetter *kafka.Producer
+}
+
+// New returns the sink of kind.
+func New(kind string, config Config) (kafka.Sink, error) {
+	switch strings.ToLower(kind) {
+	case KindFile:
+		return NewFileSink(config.FileDir, config.FilePrefix, config.FileMaxBytes, config.FileMaxAge)
+	case KindS3:
+		return NewS3Sink(config)
+	case KindPostgres:
+		return NewPostgresSink(config.PostgresDSN, config.PostgresTable)
+	case KindTable:
+		return NewTableSink(config.PostgresDSN, config.MaterializedTable)
+	case KindRedis:
+		return NewRedisSink(config.RedisURL, config.RedisPrefix, config.RedisTTL)
+	case KindElasticsearch:
+		return NewElasticsearchSink(config.ElasticsearchURL, config.ElasticsearchIndex,
+			config.ElasticsearchUsername, config.ElasticsearchPassword)
+	case KindClickHouse:
+		return NewClickHouseSink(config.ClickHouseURL, config.ClickHouseDatabase, config.ClickHouseTable,
+			config.ClickHouseUsername, config.ClickHousePassword, config.ClickHouseBatchSize, config.ClickHouseFlushInterval)
+	case KindWebhook:
+		return NewWebhookSink(config.WebhookURL, config.WebhookSecret, config.WebhookConcurrency, config.WebhookTimeout,
+			config.WebhookRetries, config.WebhookDeadLetter)
+	default:
+		return nil, fmt.Errorf("unsupported sink: %s", kind)
+	}
+}
+
+// ndjson encodes records as newline-delimited JSON.
+func ndjson(records []kafka.SinkRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, fmt.Errorf("failed to encode record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
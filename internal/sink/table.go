@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"kafka-hwsw/internal/pgsql"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// TableSink materializes a topic as a Postgres table with one row per key,
+// the user ID with the default key strategy: a record replaces its key's
+// row and a tombstone deletes it, which is what a compacted topic keeps. A
+// record without a key uses the user_id of its event instead. Replaying
+// records leaves the table as it was, since a row is only replaced by a
+// later offset of its partition.
+type TableSink struct {
+	db    *sql.DB
+	table string
+}
+
+// NewTableSink connects to dsn and creates table if it doesn't exist.
+func NewTableSink(dsn, table string) (*TableSink, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("no Postgres DSN configured")
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid Postgres table name: %q", table)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	s := &TableSink{db: db, table: pgsql.QuoteTable(table)}
+	if _, err := db.ExecContext(ctx, s.createTable()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table %s: %w", table, err)
+	}
+	return s, nil
+}
+
+func (s *TableSink) createTable() string {
+	return `CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+	"key" TEXT PRIMARY KEY,
+	"value" JSONB NOT NULL,
+	"topic" TEXT NOT NULL,
+	"partition" INTEGER NOT NULL,
+	"offset" BIGINT NOT NULL,
+	"timestamp" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+}
+
+// Write applies the last record of every key in records, in one
+// transaction.
+func (s *TableSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	latest, skipped := latestByKey(records)
+	var upserts []kafka.SinkRecord
+	var deletes []kafka.SinkRecord
+	for _, record := range latest {
+		if record.Tombstone {
+			deletes = append(deletes, record)
+		} else {
+			upserts = append(upserts, record)
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Skipped records without a key or user ID", "table", s.table, "records", skipped)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(upserts); start += postgresRowsPerInsert {
+		end := min(start+postgresRowsPerInsert, len(upserts))
+		if err := s.upsert(ctx, tx, upserts[start:end]); err != nil {
+			return err
+		}
+	}
+	for _, record := range deletes {
+		if err := s.delete(ctx, tx, record); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	slog.Debug("Table updated", "table", s.table, "records", len(records), "upserted", len(upserts), "deleted", len(deletes))
+	return nil
+}
+
+// upsert inserts or replaces the rows of records, whose keys are distinct.
+func (s *TableSink) upsert(ctx context.Context, tx *sql.Tx, records []kafka.SinkRecord) error {
+	const columns = 6
+
+	var query strings.Builder
+	query.WriteString(`INSERT INTO ` + s.table + ` AS "t" ("key", "value", "topic", "partition", "offset", "timestamp") VALUES `)
+	args := make([]any, 0, len(records)*columns)
+	for i, record := range records {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * columns
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+		args = append(args, record.Key, string(record.Value), record.Topic, record.Partition, record.Offset, record.Timestamp)
+	}
+	query.WriteString(` ON CONFLICT ("key") DO UPDATE SET "value" = EXCLUDED."value", "topic" = EXCLUDED."topic",
+	"partition" = EXCLUDED."partition", "offset" = EXCLUDED."offset", "timestamp" = EXCLUDED."timestamp", "updated_at" = now()
+WHERE "t"."topic" <> EXCLUDED."topic" OR "t"."partition" <> EXCLUDED."partition" OR "t"."offset" < EXCLUDED."offset"`)
+
+	if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to upsert into %s: %w", s.table, err)
+	}
+	return nil
+}
+
+// delete removes the row of a tombstone's key, unless it holds a later
+// offset of the same partition.
+func (s *TableSink) delete(ctx context.Context, tx *sql.Tx, record kafka.SinkRecord) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE "key" = $1
+	AND NOT ("topic" = $2 AND "partition" = $3 AND "offset" > $4)`,
+		record.Key, record.Topic, record.Partition, record.Offset)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", s.table, err)
+	}
+	return nil
+}
+
+func (s *TableSink) Close() error {
+	return s.db.Close()
+}
+
+// latestByKey returns the last record of every key, in the order of those
+// records, the way compaction would leave them, and how many records had no
+// key. A record without a key is keyed by the user_id of its event.
+func latestByKey(records []kafka.SinkRecord) ([]kafka.SinkRecord, int) {
+	last := make(map[string]int, len(records))
+	keyed := make([]kafka.SinkRecord, len(records))
+	skipped := 0
+	for i, record := range records {
+		if record.Key == "" && !record.Tombstone {
+			var event struct {
+				UserID string `json:"user_id"`
+			}
+			if json.Unmarshal(record.Value, &event) == nil {
+				record.Key = event.UserID
+			}
+		}
+		if record.Key == "" {
+			skipped++
+			continue
+		}
+		keyed[i] = record
+		last[record.Key] = i
+	}
+
+	latest := make([]kafka.SinkRecord, 0, len(last))
+	for i, record := range keyed {
+		if record.Key != "" && last[record.Key] == i {
+			latest = append(latest, record)
+		}
+	}
+	return latest, skipped
+}
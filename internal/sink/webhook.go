@@ -0,0 +1,260 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Headers of every webhook request. The signature is only sent with a
+// secret: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">, so a receiver
+// can check both who sent a request and that it isn't an old one replayed.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookSink POSTs every record as JSON to a URL, like a minimal HTTP sink
+// connector. Records of different keys are delivered concurrently, at most
+// concurrency requests at a time across all partitions, while the records
+// of one key are delivered in order, each only after the one before it was
+// accepted.
+//
+// A request that fails with a network error, a 5xx, 408 or 429 is retried
+// according to the backoff. If it keeps failing, Write fails and the batch
+// is written again, so receivers see records at least once and should
+// deduplicate by the X-Webhook-Id header, <topic>-<partition>-<offset>.
+// Any other 4xx means the receiver will never accept the record: it goes
+// to the dead-letter producer, if there is one, and is logged and dropped
+// otherwise, so one bad record doesn't hold up the partition.
+type WebhookSink struct {
+	httpClient *http.Client
+	url        string
+	secret     []byte
+	backoff    kafka.Backoff
+	deadLetter *kafka.Producer
+	slots      chan struct{}
+}
+
+// NewWebhookSink delivers records to rawURL, signing them with secret
+// unless it is empty and retrying each up to retries times. Records the
+// receiver rejects for good are sent to deadLetter, which may be nil.
+func NewWebhookSink(rawURL, secret string, concurrency int, timeout time.Duration, retries int, deadLetter *kafka.Producer) (*WebhookSink, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no webhook URL configured")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook URL %s: want http or https", rawURL)
+	}
+	if concurrency < 1 || timeout <= 0 || retries < 0 {
+		return nil, fmt.Errorf("invalid webhook delivery: concurrency %d, timeout %s, %d retries", concurrency, timeout, retries)
+	}
+
+	backoff := kafka.DefaultBackoff
+	backoff.MaxRetries = retries
+	return &WebhookSink{
+		httpClient: &http.Client{Timeout: timeout},
+		url:        rawURL,
+		secret:     []byte(secret),
+		backoff:    backoff,
+		deadLetter: deadLetter,
+		slots:      make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Write delivers records, returning once every one was accepted, dead-
+// lettered or dropped.
+func (s *WebhookSink) Write(ctx context.Context, records []kafka.SinkRecord) error {
+	var keys []string
+	byKey := make(map[string][]kafka.SinkRecord)
+	for _, record := range records {
+		if _, ok := byKey[record.Key]; !ok {
+			keys = append(keys, record.Key)
+		}
+		byKey[record.Key] = append(byKey[record.Key], record)
+	}
+
+	var (
+		wg                               sync.WaitGroup
+		mu                               sync.Mutex
+		firstErr                         error
+		delivered, deadLettered, dropped int
+	)
+	for _, key := range keys {
+		wg.Add(1)
+		go func(records []kafka.SinkRecord) {
+			defer wg.Done()
+			for _, record := range records {
+				outcome, err := s.deliver(ctx, record)
+				mu.Lock()
+				switch {
+				case err != nil:
+					if firstErr == nil {
+						firstErr = err
+					}
+				case outcome == webhookDeadLettered:
+					deadLettered++
+				case outcome == webhookDropped:
+					dropped++
+				default:
+					delivered++
+				}
+				mu.Unlock()
+				if err != nil {
+					// The key's later records wait for the batch to be
+					// written again.
+					return
+				}
+			}
+		}(byKey[key])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	slog.Debug("Records delivered", "records", len(records), "delivered", delivered,
+		"dead_lettered", deadLettered, "dropped", dropped)
+	return nil
+}
+
+// Outcomes of delivering a record.
+const (
+	webhookDelivered = iota
+	webhookDeadLettered
+	webhookDropped
+)
+
+// deliver POSTs record with retries. An error means it may succeed later;
+// a record the receiver rejected for good is dead-lettered or dropped.
+func (s *WebhookSink) deliver(ctx context.Context, record kafka.SinkRecord) (int, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+	}
+	id := fmt.Sprintf("%s-%d-%d", record.Topic, record.Partition, record.Offset)
+
+	attempts := 0
+	err = s.backoff.Retry(ctx, "Webhook delivery", func() error {
+		attempts++
+		return s.post(ctx, id, body)
+	})
+	if err == nil {
+		return webhookDelivered, nil
+	}
+	var rejected webhookRejected
+	if !errors.As(err, &rejected) {
+		return 0, fmt.Errorf("failed to deliver record %s: %w", id, err)
+	}
+
+	if s.deadLetter == nil {
+		slog.Error("Giving up on record", "topic", record.Topic, "partition", record.Partition,
+			"offset", record.Offset, "attempts", attempts, "error", err)
+		return webhookDropped, nil
+	}
+	if err := s.deadLetterRecord(record, err, attempts); err != nil {
+		return 0, err
+	}
+	slog.Error("Record dead-lettered", "dlq_topic", s.deadLetter.Topic(), "topic", record.Topic,
+		"partition", record.Partition, "offset", record.Offset, "attempts", attempts, "error", err)
+	return webhookDeadLettered, nil
+}
+
+// webhookRejected is a response that retrying can't change.
+type webhookRejected struct {
+	status string
+	body   string
+}
+
+func (e webhookRejected) Error() string {
+	return fmt.Sprintf("webhook rejected record with %s: %s", e.status, e.body)
+}
+
+// post sends one request, waiting for a free slot first.
+func (s *WebhookSink) post(ctx context.Context, id string, body []byte) error {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, id)
+	if len(s.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(hmacSHA256(s.secret, timestamp+"."+string(body))))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return kafka.Permanent(webhookRejected{resp.Status, strings.TrimSpace(string(msg))})
+	default:
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// deadLetterRecord sends record to the dead-letter topic with the headers
+// the consumer's DLQ uses.
+func (s *WebhookSink) deadLetterRecord(record kafka.SinkRecord, cause error, attempts int) error {
+	headers := make(map[string]string, len(record.Headers)+6)
+	for k, v := range record.Headers {
+		headers[k] = v
+	}
+	headers[kafka.DLQErrorHeader] = cause.Error()
+	headers[kafka.DLQOriginalTopicHeader] = record.Topic
+	headers[kafka.DLQOriginalPartitionHeader] = strconv.Itoa(int(record.Partition))
+	headers[kafka.DLQOriginalOffsetHeader] = strconv.FormatInt(record.Offset, 10)
+	headers[kafka.DLQRetryCountHeader] = strconv.Itoa(attempts - 1)
+	headers[kafka.DLQFailedAtHeader] = time.Now().UTC().Format(time.RFC3339)
+
+	if _, _, err := s.deadLetter.SendMessageWithHeaders(record.Key, string(record.Value), headers); err != nil {
+		return fmt.Errorf("failed to dead-letter record %s/%d/%d: %w", record.Topic, record.Partition, record.Offset, err)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
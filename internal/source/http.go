@@ -0,0 +1,136 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// maxRequestBytes bounds the body of one POST.
+const maxRequestBytes = 10 << 20
+
+// HTTP accepts events POSTed to /events: a single JSON event, or several
+// separated by newlines. A request is accepted as a whole or rejected with
+// 400 if any event in it is invalid, and answered once its events have been
+// handed to the producer, not once Kafka has stored them. It is never
+// exhausted; the producer runs until it is stopped.
+type HTTP struct {
+	server *http.Server
+	port   int
+	events chan kafka.UserEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewHTTP listens on port and starts serving.
+func NewHTTP(port int) (*HTTP, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for events: %w", err)
+	}
+
+	h := &HTTP{
+		port:   listener.Addr().(*net.TCPAddr).Port,
+		events: make(chan kafka.UserEvent),
+		done:   make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", h.handle)
+	h.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := h.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Event endpoint stopped", "error", err)
+		}
+	}()
+	return h, nil
+}
+
+func (h *HTTP) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, err := decodeEvents(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		slog.Warn("Rejected events", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i, event := range events {
+		select {
+		case h.events <- event:
+		case <-r.Context().Done():
+			slog.Warn("Request cancelled before all events were accepted", "remote", r.RemoteAddr,
+				"accepted", i, "events", len(events))
+			return
+		case <-h.done:
+			http.Error(w, fmt.Sprintf("shutting down, accepted %d of %d events", i, len(events)), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(events)})
+}
+
+// decodeEvents reads all events of a request body before any is accepted.
+func decodeEvents(body io.Reader) ([]kafka.UserEvent, error) {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	var events []kafka.UserEvent
+	for {
+		var event kafka.UserEvent
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %d: %w", len(events)+1, err)
+		}
+		if err := validate(&event); err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no events in request")
+	}
+	return events, nil
+}
+
+func (h *HTTP) Events() <-chan kafka.UserEvent {
+	return h.events
+}
+
+// Close stops accepting events. Requests still waiting for the producer are
+// answered with 503.
+func (h *HTTP) Close() error {
+	var err error
+	h.once.Do(func() {
+		close(h.done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = h.server.Shutdown(ctx)
+	})
+	return err
+}
+
+// URL returns the address events are POSTed to.
+func (h *HTTP) URL() string {
+	return fmt.Sprintf("http://localhost:%d/events", h.port)
+}
@@ -0,0 +1,91 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// maxLineBytes is the longest line a Lines source accepts.
+const maxLineBytes = 16 << 20
+
+// Lines reads one JSON event per line from a file or stdin. Blank lines are
+// ignored, and lines that aren't valid events are logged and skipped.
+type Lines struct {
+	name   string
+	reader io.ReadCloser
+	events chan kafka.UserEvent
+	done   chan struct{}
+}
+
+// File returns a source reading path.
+func File(path string) (*Lines, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	return newLines(path, file), nil
+}
+
+// Stdin returns a source reading stdin until it is closed.
+func Stdin() *Lines {
+	return newLines("stdin", io.NopCloser(os.Stdin))
+}
+
+func newLines(name string, reader io.ReadCloser) *Lines {
+	l := &Lines{
+		name:   name,
+		reader: reader,
+		events: make(chan kafka.UserEvent),
+		done:   make(chan struct{}),
+	}
+	go l.read()
+	return l
+}
+
+func (l *Lines) read() {
+	defer close(l.events)
+
+	scanner := bufio.NewScanner(l.reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	line, skipped := 0, 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		event, err := Parse(data)
+		if err != nil {
+			skipped++
+			slog.Warn("Skipping input line", "input", l.name, "line", line, "error", err)
+			continue
+		}
+		select {
+		case l.events <- event:
+		case <-l.done:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("Failed to read input", "input", l.name, "line", line+1, "error", err)
+		return
+	}
+	slog.Info("Input exhausted", "input", l.name, "lines", line, "skipped", skipped)
+}
+
+func (l *Lines) Events() <-chan kafka.UserEvent {
+	return l.events
+}
+
+// Close stops reading. A read from stdin that is blocked waiting for input
+// is abandoned rather than interrupted.
+func (l *Lines) Close() error {
+	close(l.done)
+	return l.reader.Close()
+}
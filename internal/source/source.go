@@ -0,0 +1,79 @@
+// Package source reads the events the producer sends from somewhere other
+// than the generator: a file or stdin with one JSON event per line, or an
+// HTTP endpoint events are POSTed to.
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// Source kinds.
+const (
+	KindGenerator = "generator"
+	KindFile      = "file"
+	KindStdin     = "stdin"
+	KindHTTP      = "http"
+)
+
+// Kinds lists the source kinds the producer accepts.
+var Kinds = []string{KindGenerator, KindFile, KindStdin, KindHTTP}
+
+// Source delivers events to produce. A source that can be exhausted, like a
+// file, closes the channel once it is.
+type Source interface {
+	Events() <-chan kafka.UserEvent
+	Close() error
+}
+
+// Events returns a source that delivers events and is then exhausted.
+func Events(events []kafka.UserEvent) Source {
+	ch := make(chan kafka.UserEvent, len(events))
+	for _, event := range events {
+		ch <- event
+	}
+	close(ch)
+	return staticSource(ch)
+}
+
+type staticSource <-chan kafka.UserEvent
+
+func (s staticSource) Events() <-chan kafka.UserEvent { return s }
+func (s staticSource) Close() error                   { return nil }
+
+// Parse decodes one event in its JSON form. Fields other than user_id,
+// event_type, timestamp and data are rejected rather than silently dropped,
+// and a missing timestamp is set to now.
+func Parse(data []byte) (kafka.UserEvent, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var event kafka.UserEvent
+	if err := decoder.Decode(&event); err != nil {
+		return kafka.UserEvent{}, fmt.Errorf("invalid event: %w", err)
+	}
+	if decoder.More() {
+		return kafka.UserEvent{}, fmt.Errorf("invalid event: more than one JSON value")
+	}
+	if err := validate(&event); err != nil {
+		return kafka.UserEvent{}, err
+	}
+	return event, nil
+}
+
+func validate(event *kafka.UserEvent) error {
+	if event.UserID == "" {
+		return fmt.Errorf("invalid event: missing user_id")
+	}
+	if event.EventType == "" {
+		return fmt.Errorf("invalid event: missing event_type")
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return nil
+}
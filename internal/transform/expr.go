@@ -0,0 +1,565 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokSeparator
+	tokIdent
+	tokString
+	tokNumber
+	tokOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of program"
+	case tokSeparator:
+		return "end of statement"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+type lexer struct {
+	src []rune
+	pos int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: []rune(src)}
+}
+
+// operators are matched longest first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "=", "+", "(", ")", ",", "?", ":"}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\r') {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case c == ';' || c == '\n':
+		l.pos++
+		return token{kind: tokSeparator, text: string(c), pos: start}, nil
+
+	case c == '"' || c == '\'':
+		var b strings.Builder
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			switch l.src[l.pos] {
+			case c:
+				l.pos++
+				return token{kind: tokString, text: b.String(), pos: start}, nil
+			case '\\':
+				if l.pos+1 < len(l.src) {
+					l.pos++
+				}
+			}
+			b.WriteRune(l.src[l.pos])
+		}
+		return token{}, fmt.Errorf("at %d: unterminated string", start+1)
+
+	case unicode.IsDigit(c):
+		for l.pos < len(l.src) && (unicode.IsDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: string(l.src[start:l.pos]), pos: start}, nil
+
+	case unicode.IsLetter(c) || c == '_':
+		// Dashes are allowed so that header names such as content-type
+		// can be used; there is no subtraction to confuse them with.
+		for l.pos < len(l.src) && (unicode.IsLetter(l.src[l.pos]) || unicode.IsDigit(l.src[l.pos]) || strings.ContainsRune("_.-", l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: string(l.src[start:l.pos]), pos: start}, nil
+	}
+
+	rest := string(l.src[l.pos:])
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len([]rune(op))
+			return token{kind: tokOperator, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("at %d: unexpected character %q", start+1, c)
+}
+
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) is(op string) bool {
+	return p.tok.kind == tokOperator && p.tok.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.is(op) {
+		return p.errorf("expected %q, got %s", op, p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) path() (string, error) {
+	if p.tok.kind != tokIdent || !validPath(p.tok.text) {
+		return "", p.errorf("expected a field, got %s", p.tok)
+	}
+	path := p.tok.text
+	return path, p.next()
+}
+
+func validPath(path string) bool {
+	return !strings.HasPrefix(path, ".") && !strings.HasSuffix(path, ".") && !strings.Contains(path, "..")
+}
+
+func (p *parser) paths() ([]string, error) {
+	var paths []string
+	for {
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !p.is(",") {
+			return paths, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) statement() (statement, error) {
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected filter, mask, set or drop, got %s", p.tok)
+	}
+	keyword := p.tok
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	switch keyword.text {
+	case "filter":
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return filterStatement{cond}, nil
+	case "mask":
+		paths, err := p.paths()
+		if err != nil {
+			return nil, err
+		}
+		return maskStatement{paths}, nil
+	case "drop":
+		paths, err := p.paths()
+		if err != nil {
+			return nil, err
+		}
+		return dropStatement{paths}, nil
+	case "set":
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return setStatement{path, value}, nil
+	default:
+		return nil, fmt.Errorf("at %d: unknown statement %q, want filter, mask, set or drop", keyword.pos+1, keyword.text)
+	}
+}
+
+// expr parses, from lowest to highest precedence: the conditional
+// operator, ||, &&, comparisons, +, and unary !.
+func (p *parser) expr() (expr, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.is("?") {
+		return cond, err
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return conditional{cond, then, otherwise}, nil
+}
+
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+"},
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOperator && contains(precedence[level], p.tok.text) {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.is("!") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		return literal{tok.text}, p.next()
+
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		return literal{n}, p.next()
+
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, p.next()
+		case "false":
+			return literal{false}, p.next()
+		case "null":
+			return literal{nil}, p.next()
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.is("(") {
+			return p.call(tok)
+		}
+		if !validPath(tok.text) {
+			return nil, fmt.Errorf("at %d: invalid field %q", tok.pos+1, tok.text)
+		}
+		return field{tok.text}, nil
+
+	case tokOperator:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			inner, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		}
+	}
+	return nil, p.errorf("expected a value, got %s", tok)
+}
+
+// functions maps each function to its number of arguments.
+var functions = map[string]int{"now": 0, "lower": 1, "upper": 1, "contains": 2, "hash": 1}
+
+func (p *parser) call(name token) (expr, error) {
+	arity, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("at %d: unknown function %s", name.pos+1, name.text)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	for !p.is(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("at %d: %s takes %d arguments, got %d", name.pos+1, name.text, arity, len(args))
+	}
+	return call{name.text, args}, p.next()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type expr interface {
+	eval(event map[string]any) (any, error)
+}
+
+type literal struct{ value any }
+
+func (e literal) eval(map[string]any) (any, error) { return e.value, nil }
+
+type field struct{ path string }
+
+func (e field) eval(event map[string]any) (any, error) {
+	value, _ := lookup(event, e.path)
+	return value, nil
+}
+
+type not struct{ operand expr }
+
+func (e not) eval(event map[string]any) (any, error) {
+	value, err := e.operand.eval(event)
+	return !truthy(value), err
+}
+
+type conditional struct{ cond, then, otherwise expr }
+
+func (e conditional) eval(event map[string]any) (any, error) {
+	cond, err := e.cond.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return e.then.eval(event)
+	}
+	return e.otherwise.eval(event)
+}
+
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (e binary) eval(event map[string]any) (any, error) {
+	left, err := e.left.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	// && and || only evaluate their right side when it matters.
+	switch e.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := e.right.eval(event)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := e.right.eval(event)
+		return truthy(right), err
+	}
+
+	right, err := e.right.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "+":
+		l, lok := number(left)
+		r, rok := number(right)
+		if lok && rok {
+			return l + r, nil
+		}
+		return text(left) + text(right), nil
+	}
+
+	// Ordering comparisons involving null are false, like in SQL.
+	if left == nil || right == nil {
+		return false, nil
+	}
+	cmp := strings.Compare(text(left), text(right))
+	if l, lok := number(left); lok {
+		if r, rok := number(right); rok {
+			cmp = compareFloats(l, r)
+		}
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type call struct {
+	name string
+	args []expr
+}
+
+func (e call) eval(event map[string]any) (any, error) {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.eval(event)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	switch e.name {
+	case "now":
+		return time.Now().UTC().Format(time.RFC3339), nil
+	case "lower":
+		return strings.ToLower(text(args[0])), nil
+	case "upper":
+		return strings.ToUpper(text(args[0])), nil
+	case "contains":
+		return strings.Contains(text(args[0]), text(args[1])), nil
+	case "hash":
+		return hash(text(args[0])), nil
+	}
+	return nil, fmt.Errorf("unknown function %s", e.name)
+}
+
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+// number converts numbers and numeric strings to float64.
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil && !math.IsNaN(n)
+	default:
+		return 0, false
+	}
+}
+
+func text(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func equal(left, right any) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, lok := number(left); lok {
+		if r, rok := number(right); rok {
+			return l == r
+		}
+	}
+	if l, ok := left.(bool); ok {
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return text(left) == text(right)
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
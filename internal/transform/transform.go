@@ -0,0 +1,239 @@
+// Package transform implements the small language the pipeline command
+// uses to filter, mask and enrich events on their way to the output topic.
+// Consumer filters are single expressions of the same language.
+//
+// A program is a list of statements separated by semicolons or newlines,
+// run in order against the JSON form of an event:
+//
+//	filter event_type == "purchase" && data.amount > 100
+//	mask user_id, data.email
+//	set data.region = "eu"
+//	set data.tier = data.amount >= 500 ? "gold" : "standard"
+//	drop data.password
+//
+// filter drops the event unless its expression is true, mask hides the end
+// of string values but their last four characters, set adds or replaces a
+// field and drop removes one. Fields are addressed by dotted paths into the
+// event, such as user_id or data.amount, and may contain dashes; missing
+// fields are null.
+//
+// Expressions support string, number, boolean and null literals, ==, !=,
+// <, <=, >, >=, &&, ||, !, + (addition or string concatenation), the
+// conditional operator and parentheses, and the functions now(), lower(s),
+// upper(s), contains(s, substr) and hash(s). Strings that look like numbers
+// compare as numbers, since the generators write amounts as strings.
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Program is a parsed transformation. It is safe for concurrent use.
+type Program struct {
+	source     string
+	statements []statement
+}
+
+type statement interface {
+	// apply runs the statement against event and reports whether the event
+	// is kept.
+	apply(event map[string]any) (bool, error)
+}
+
+// Parse parses the statements in src. An empty program keeps every event
+// unchanged.
+func Parse(src string) (*Program, error) {
+	p := &parser{lexer: newLexer(src)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	program := &Program{source: strings.TrimSpace(src)}
+	for {
+		for p.tok.kind == tokSeparator {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if p.tok.kind == tokEOF {
+			return program, nil
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		program.statements = append(program.statements, stmt)
+		if p.tok.kind != tokSeparator && p.tok.kind != tokEOF {
+			return nil, p.errorf("expected end of statement, got %s", p.tok)
+		}
+	}
+}
+
+// Expr is a parsed expression, e.g. a consumer filter.
+type Expr struct {
+	source string
+	expr   expr
+}
+
+// ParseExpr parses a single expression in the language of filter
+// statements.
+func ParseExpr(src string) (*Expr, error) {
+	p := &parser{lexer: newLexer(src)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("expected end of expression, got %s", p.tok)
+	}
+	return &Expr{source: strings.TrimSpace(src), expr: e}, nil
+}
+
+// Match evaluates the expression against fields and reports whether the
+// result is true: not false, null, zero or empty.
+func (e *Expr) Match(fields map[string]any) (bool, error) {
+	value, err := e.expr.eval(fields)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Apply runs the program against event, changing it in place. It reports
+// false if a filter dropped the event.
+func (p *Program) Apply(event map[string]any) (bool, error) {
+	for _, stmt := range p.statements {
+		keep, err := stmt.apply(event)
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Empty reports whether the program has no statements.
+func (p *Program) Empty() bool {
+	return len(p.statements) == 0
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+type filterStatement struct{ cond expr }
+
+func (s filterStatement) apply(event map[string]any) (bool, error) {
+	value, err := s.cond.eval(event)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+type maskStatement struct{ paths []string }
+
+func (s maskStatement) apply(event map[string]any) (bool, error) {
+	for _, path := range s.paths {
+		if value, ok := lookup(event, path); ok && value != nil {
+			assign(event, path, mask(value))
+		}
+	}
+	return true, nil
+}
+
+type setStatement struct {
+	path  string
+	value expr
+}
+
+func (s setStatement) apply(event map[string]any) (bool, error) {
+	value, err := s.value.eval(event)
+	if err != nil {
+		return false, err
+	}
+	return true, assign(event, s.path, value)
+}
+
+type dropStatement struct{ paths []string }
+
+func (s dropStatement) apply(event map[string]any) (bool, error) {
+	for _, path := range s.paths {
+		parent, key := parentOf(event, path, false)
+		if parent != nil {
+			delete(parent, key)
+		}
+	}
+	return true, nil
+}
+
+// maskKeep is how many trailing characters mask leaves readable.
+const maskKeep = 4
+
+// mask hides all of value but its last characters, or all of it if it is
+// short or not a string.
+func mask(value any) string {
+	s, ok := value.(string)
+	if !ok || len([]rune(s)) <= maskKeep {
+		return strings.Repeat("*", maskKeep)
+	}
+	runes := []rune(s)
+	return strings.Repeat("*", len(runes)-maskKeep) + string(runes[len(runes)-maskKeep:])
+}
+
+// hash pseudonymises a value: equal inputs give equal outputs, so masked
+// IDs can still be joined on.
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+func lookup(event map[string]any, path string) (any, bool) {
+	parent, key := parentOf(event, path, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, ok := parent[key]
+	return value, ok
+}
+
+func assign(event map[string]any, path string, value any) error {
+	parent, key := parentOf(event, path, true)
+	if parent == nil {
+		return fmt.Errorf("can't set %s: a parent field is not an object", path)
+	}
+	parent[key] = value
+	return nil
+}
+
+// parentOf returns the object holding the last field of path, creating the
+// objects on the way if create is set.
+func parentOf(event map[string]any, path string, create bool) (map[string]any, string) {
+	fields := strings.Split(path, ".")
+	current := event
+	for _, field := range fields[:len(fields)-1] {
+		next, ok := current[field]
+		if !ok || next == nil {
+			if !create {
+				return nil, ""
+			}
+			next = make(map[string]any)
+			current[field] = next
+		}
+		object, ok := next.(map[string]any)
+		if !ok {
+			return nil, ""
+		}
+		current = object
+	}
+	return current, fields[len(fields)-1]
+}
@@ -0,0 +1,375 @@
+// Package tui is a full-screen terminal view of a consumer: a live table of
+// partitions with their offsets, lag and throughput, the last messages and
+// the last warnings, in place of a log line per message.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"kafka-hwsw/internal/logging"
+	"kafka-hwsw/pkg/kafka"
+)
+
+// logLines is how many log lines the view keeps.
+const logLines = 5
+
+// Config describes what the view shows. Every field is optional.
+type Config struct {
+	// Title is shown in the first line, e.g. the group and topics.
+	Title string
+	// Messages is how many of the last messages are shown. Defaults to 10.
+	Messages int
+	// Format renders a message value. Defaults to the raw value.
+	Format func(message *kafka.Message) string
+	// Lag returns the consumer group lag, if there is a group.
+	Lag func() ([]kafka.PartitionLag, error)
+	// LagInterval is how often Lag is called. Defaults to five seconds.
+	LagInterval time.Duration
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+type partitionStats struct {
+	messages int64
+	offset   int64
+}
+
+type recentMessage struct {
+	time      time.Time
+	topic     string
+	partition int32
+	offset    int64
+	key       string
+	value     string
+}
+
+// TUI collects the messages a consumer processed and draws them. Its
+// handler may be called from several workers at once.
+type TUI struct {
+	config Config
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionStats
+	recent     []recentMessage
+	total      int64
+	logs       []string
+}
+
+// New creates a view. Add its Handler to the consumer's handlers and call
+// Run to show it.
+func New(config Config) *TUI {
+	if config.Messages <= 0 {
+		config.Messages = 10
+	}
+	if config.Format == nil {
+		config.Format = func(message *kafka.Message) string { return string(message.Value) }
+	}
+	if config.LagInterval <= 0 {
+		config.LagInterval = 5 * time.Second
+	}
+	return &TUI{
+		config:     config,
+		partitions: make(map[partitionKey]*partitionStats),
+	}
+}
+
+// Handler records every message it is given. Put it after the handlers
+// that process the message so that only processed messages count.
+func (t *TUI) Handler() kafka.MessageHandler {
+	return kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		key := string(message.Key)
+		if message.Key == nil {
+			key = "-"
+		}
+		recent := recentMessage{
+			time:      message.Timestamp,
+			topic:     message.Topic,
+			partition: message.Partition,
+			offset:    message.Offset,
+			key:       key,
+			value:     t.config.Format(message),
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		stats, ok := t.partitions[partitionKey{message.Topic, message.Partition}]
+		if !ok {
+			stats = &partitionStats{}
+			t.partitions[partitionKey{message.Topic, message.Partition}] = stats
+		}
+		stats.messages++
+		if message.Offset > stats.offset {
+			stats.offset = message.Offset
+		}
+		t.total++
+		t.recent = append(t.recent, recent)
+		if len(t.recent) > t.config.Messages {
+			t.recent = t.recent[len(t.recent)-t.config.Messages:]
+		}
+		return nil
+	})
+}
+
+// SetLag sets where the lag comes from, for when it is only known after
+// the view was created. It must be called before Run.
+func (t *TUI) SetLag(lag func() ([]kafka.PartitionLag, error)) {
+	t.config.Lag = lag
+}
+
+// Write keeps the last log lines for the view.
+func (t *TUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logs = append(t.logs, line)
+	}
+	if len(t.logs) > logLines {
+		t.logs = t.logs[len(t.logs)-logLines:]
+	}
+	return len(p), nil
+}
+
+// Run shows the view while consume runs, with warnings and errors going to
+// the view instead of stderr. Pressing q or Ctrl+C calls stop, which should
+// make consume return. Run returns consume's error once it has, leaving
+// the last state of the view on stdout.
+func (t *TUI) Run(ctx context.Context, stop func(), consume func(ctx context.Context) error) error {
+	restore := logging.Redirect(t, slog.LevelWarn)
+
+	// Start consuming first: creating the program may wait for the
+	// terminal to answer a query for its colors.
+	consumed := make(chan error, 1)
+	go func() { consumed <- consume(ctx) }()
+
+	program := tea.NewProgram(&model{tui: t, started: time.Now(), rates: make(map[partitionKey]float64)}, tea.WithAltScreen())
+	done := make(chan error, 1)
+	go func() {
+		err := <-consumed
+		done <- err
+		program.Send(stoppedMsg{err})
+	}()
+
+	final, runErr := program.Run()
+	restore()
+	if m, ok := final.(*model); ok {
+		m.quitting = true
+		fmt.Fprintln(os.Stdout, m.View())
+	}
+	if runErr != nil {
+		slog.Error("Terminal view failed", "error", runErr)
+	}
+
+	stop()
+	return <-done
+}
+
+type tickMsg time.Time
+
+type lagMsg struct {
+	lags []kafka.PartitionLag
+	err  error
+}
+
+type stoppedMsg struct{ err error }
+
+// model is the bubbletea state. Counts come from the TUI, which the
+// handler updates concurrently; the rest is only touched by Update.
+type model struct {
+	tui      *TUI
+	started  time.Time
+	width    int
+	quitting bool
+	stopped  bool
+	err      error
+
+	lag    []kafka.PartitionLag
+	lagErr error
+
+	// previous counts per partition at the last tick, for the rates.
+	previous     map[partitionKey]int64
+	previousTime time.Time
+	rates        map[partitionKey]float64
+	rate         float64
+}
+
+func (m *model) Init() tea.Cmd {
+	return tea.Batch(tick(), m.fetchLag())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(now time.Time) tea.Msg { return tickMsg(now) })
+}
+
+func (m *model) fetchLag() tea.Cmd {
+	if m.tui.config.Lag == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		lags, err := m.tui.config.Lag()
+		return lagMsg{lags, err}
+	}
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			return m, tea.Quit
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+
+	case tickMsg:
+		m.updateRates(time.Time(msg))
+		return m, tick()
+
+	case lagMsg:
+		m.lag, m.lagErr = msg.lags, msg.err
+		return m, tea.Tick(m.tui.config.LagInterval, func(time.Time) tea.Msg { return m.fetchLag()() })
+
+	case stoppedMsg:
+		m.stopped, m.err = true, msg.err
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// updateRates works out the messages per second since the previous tick.
+func (m *model) updateRates(now time.Time) {
+	counts := make(map[partitionKey]int64)
+	var total int64
+	m.tui.mu.Lock()
+	for key, stats := range m.tui.partitions {
+		counts[key] = stats.messages
+		total += stats.messages
+	}
+	m.tui.mu.Unlock()
+
+	if m.previous != nil {
+		elapsed := now.Sub(m.previousTime).Seconds()
+		var previousTotal int64
+		for key, count := range counts {
+			m.rates[key] = float64(count-m.previous[key]) / elapsed
+			previousTotal += m.previous[key]
+		}
+		m.rate = float64(total-previousTotal) / elapsed
+	}
+	m.previous, m.previousTime = counts, now
+}
+
+func (m *model) View() string {
+	t := m.tui
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s   up %s\n", t.config.Title, time.Since(m.started).Round(time.Second))
+	status := "q to quit"
+	switch {
+	case m.stopped && m.err != nil:
+		status = "stopped: " + m.err.Error()
+	case m.stopped || m.quitting:
+		status = "stopped"
+	}
+	fmt.Fprintf(&b, "%d messages, %.1f/s   %s\n\n", t.total, m.rate, status)
+
+	m.writePartitions(&b)
+
+	fmt.Fprintf(&b, "\nLast %d messages\n", t.config.Messages)
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTOPIC/PARTITION/OFFSET\tKEY\tVALUE")
+	for i := len(t.recent) - 1; i >= 0; i-- {
+		r := t.recent[i]
+		fmt.Fprintf(w, "%s\t%s/%d/%d\t%s\t%s\n", r.time.Format("15:04:05"), r.topic, r.partition, r.offset,
+			r.key, strings.ReplaceAll(r.value, "\n", " "))
+	}
+	w.Flush()
+
+	if len(t.logs) > 0 {
+		b.WriteString("\nLog\n")
+		for _, line := range t.logs {
+			b.WriteString(line + "\n")
+		}
+	}
+	return m.truncate(b.String())
+}
+
+// writePartitions writes a row for every partition that either had
+// messages or has a lag.
+func (m *model) writePartitions(b io.Writer) {
+	t := m.tui
+	lags := make(map[partitionKey]kafka.PartitionLag)
+	keys := make([]partitionKey, 0, len(t.partitions))
+	for key := range t.partitions {
+		keys = append(keys, key)
+	}
+	for _, lag := range m.lag {
+		key := partitionKey{lag.Topic, lag.Partition}
+		if _, ok := t.partitions[key]; !ok {
+			keys = append(keys, key)
+		}
+		lags[key] = lag
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tMESSAGES\tOFFSET\tLAG\tRATE/S")
+	for _, key := range keys {
+		messages, offset := "0", "-"
+		if stats, ok := t.partitions[key]; ok {
+			messages, offset = fmt.Sprint(stats.messages), fmt.Sprint(stats.offset)
+		}
+		lag := "-"
+		if l, ok := lags[key]; ok {
+			lag = fmt.Sprint(l.Lag)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.1f\n", key.topic, key.partition, messages, offset, lag, m.rates[key])
+	}
+	w.Flush()
+	if m.lagErr != nil {
+		fmt.Fprintf(b, "lag: %v\n", m.lagErr)
+	}
+}
+
+// truncate cuts every line to the terminal width so long values don't wrap
+// and push the table off the screen.
+func (m *model) truncate(view string) string {
+	if m.width <= 0 {
+		return view
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(view, "\n") {
+		newline := strings.HasSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\n")
+		if utf8.RuneCountInString(line) > m.width {
+			line = string([]rune(line)[:m.width-1]) + "…"
+		}
+		b.WriteString(line)
+		if newline {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// Connect connects a Client of the backend picked with WithBackend, with the
+// same options as the other constructors, for tools that look up partitions
+// and offsets or read partitions directly.
+func Connect(brokers []string, opts ...Option) (Client, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client config: %w", err)
+	}
+	return o.connect(brokers)
+}
+
+// NewAdmin connects an Admin with the same options as the producer and
+// consumer, so topic management works against TLS and SASL clusters too.
+// Closing it closes its client.
+func NewAdmin(brokers []string, opts ...Option) (Admin, error) {
+	client, err := Connect(brokers, opts...)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	return closingAdmin{Admin: admin, client: client}, nil
+}
+
+type closingAdmin struct {
+	Admin
+	client Client
+}
+
+func (a closingAdmin) Close() error {
+	err := a.Admin.Close()
+	if cerr := a.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// NewClusterAdmin connects a sarama.ClusterAdmin, for the group, replica and
+// config management only SaramaBackend has. Other backends fail with
+// ErrUnsupported; NewAdmin works with every backend.
+func NewClusterAdmin(brokers []string, opts ...Option) (sarama.ClusterAdmin, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin config: %w", err)
+	}
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := saramaOf(client)
+	if !ok {
+		client.Close()
+		return nil, unsupported("sarama cluster admins are", o.backend)
+	}
+
+	// Closing the admin closes the client too.
+	admin, err := sarama.NewClusterAdminFromClient(sc)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	return admin, nil
+}
+
+// EnsureTopic creates topic unless it already exists and reports whether it
+// was created. An existing topic is left untouched, even if its partition
+// count or replication factor differ.
+func EnsureTopic(admin Admin, topic string, partitions int32, replicationFactor int16) (bool, error) {
+	err := admin.CreateTopic(topic, TopicDetail{Partitions: partitions, ReplicationFactor: replicationFactor})
+	if errors.Is(err, ErrTopicExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+	return true, nil
+}
+
+// EnsureCompactedTopic creates topic with cleanup.policy=compact and the
+// topic configs in configs unless it already exists, and reports whether it
+// was created. An existing topic is left untouched, but its cleanup policy
+// has to include compact.
+func EnsureCompactedTopic(admin Admin, topic string, partitions int32, replicationFactor int16, configs map[string]string) (bool, error) {
+	entries := map[string]string{}
+	for name, value := range configs {
+		entries[name] = value
+	}
+	const compact = "compact"
+	entries["cleanup.policy"] = compact
+
+	err := admin.CreateTopic(topic, TopicDetail{
+		Partitions:        partitions,
+		ReplicationFactor: replicationFactor,
+		Configs:           entries,
+	})
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, ErrTopicExists) {
+		return false, fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+
+	policy, err := admin.TopicConfig(topic, "cleanup.policy")
+	if err != nil {
+		return false, fmt.Errorf("failed to describe topic %s: %w", topic, err)
+	}
+	if !slices.Contains(strings.Split(policy, ","), compact) {
+		return false, fmt.Errorf("topic %s already exists with cleanup.policy=%s", topic, policy)
+	}
+	return false, nil
+}
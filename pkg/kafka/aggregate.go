@@ -0,0 +1,470 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Kinds of Aggregate.
+const (
+	AggregateUser    = "user"
+	AggregateSession = "session"
+)
+
+// restoreIdle is how long restoring waits for more aggregates before it
+// takes the rest of the partition to be transaction markers.
+const restoreIdle = time.Second
+
+// Aggregate is the running state of one user or session. Snapshots are
+// published keyed by kind and ID, e.g. "user:user-123", so a compacted
+// output topic keeps the latest one of each.
+type Aggregate struct {
+	Kind          string    `json:"kind"`
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id"`
+	Events        int64     `json:"events"`
+	Purchases     int64     `json:"purchases,omitempty"`
+	PurchaseTotal float64   `json:"purchase_total,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// WithCheckpoint makes an Aggregator checkpoint every partition after every
+// messages, or after interval if fewer arrive.
+func WithCheckpoint(every int, interval time.Duration) Option {
+	return func(o *options) error {
+		if every < 1 || interval <= 0 {
+			return fmt.Errorf("invalid checkpoint: every %d messages, interval %s", every, interval)
+		}
+		o.checkpointEvery = every
+		o.checkpointInterval = interval
+		return nil
+	}
+}
+
+// Aggregator is a stateful stream processor: it keeps purchase totals per
+// user and event counts per session for each partition it consumes, and
+// checkpoints them by publishing the changed aggregates to the output topic
+// and committing the consumed offsets in the same transaction. Snapshots
+// of input partition N go to output partition N, so the output topic doubles
+// as the changelog the state is restored from when a partition is assigned.
+// The input has to be keyed by user ID, which keeps each user, and each of
+// their sessions, on one partition.
+type Aggregator struct {
+	decoder
+	client             Client
+	consumer           ConsumerGroup
+	restorer           Reader
+	producer           SyncProducer
+	inputTopic         string
+	outputTopic        string
+	groupID            string
+	checkpointEvery    int
+	checkpointInterval time.Duration
+	rebalances         *RebalanceHistory
+	rebalanceStrategy  string
+	instanceID         string
+	backoff            Backoff
+
+	// A transactional producer can only have one open transaction, so
+	// claims take turns checkpointing.
+	mu sync.Mutex
+}
+
+// NewAggregator creates an aggregator from inputTopic to outputTopic, which
+// needs at least as many partitions as inputTopic and should be compacted.
+// Like a Pipeline it reads with read_committed isolation and only moves
+// offsets when a checkpoint commits.
+func NewAggregator(brokers []string, inputTopic, outputTopic, groupID, transactionalID string, opts ...Option) (*Aggregator, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumerConfig.Consumer.Offsets.AutoCommit.Enable = false
+	consumerConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.Retry.Max = 5
+	po, err := newOptions(producerConfig, append(opts[:len(opts):len(opts)], WithTransactionalID(transactionalID)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	// Snapshots pick their partition themselves.
+	producerConfig.Producer.Partitioner = sarama.NewManualPartitioner
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAligned(client, inputTopic, outputTopic); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	restorer, err := client.NewReader()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create restore consumer: %w", err)
+	}
+
+	consumer, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		restorer.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		consumer.Close()
+		restorer.Close()
+		client.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Aggregator{
+		decoder:            o.decoder(),
+		client:             client,
+		consumer:           consumer,
+		restorer:           restorer,
+		producer:           producer,
+		inputTopic:         inputTopic,
+		outputTopic:        outputTopic,
+		groupID:            groupID,
+		checkpointEvery:    o.checkpointEvery,
+		checkpointInterval: o.checkpointInterval,
+		rebalances:         rebalances,
+		rebalanceStrategy:  o.rebalanceStrategy,
+		instanceID:         consumerConfig.Consumer.Group.InstanceId,
+		backoff:            o.backoff,
+	}, nil
+}
+
+// checkAligned makes sure every input partition has an output partition of
+// the same number.
+func checkAligned(client Client, inputTopic, outputTopic string) error {
+	input, err := client.Partitions(inputTopic)
+	if err != nil {
+		return fmt.Errorf("failed to look up input topic %s: %w", inputTopic, err)
+	}
+	output, err := client.Partitions(outputTopic)
+	if err != nil {
+		return fmt.Errorf("failed to look up output topic %s: %w", outputTopic, err)
+	}
+	if len(output) < len(input) {
+		return fmt.Errorf("output topic %s has %d partitions, it needs at least the %d of %s", outputTopic, len(output), len(input), inputTopic)
+	}
+	return nil
+}
+
+// Consume runs the aggregator until ctx is cancelled. Every partition is
+// checkpointed once more before its claim ends.
+func (a *Aggregator) Consume(ctx context.Context) error {
+	defer a.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := a.consumer.Consume(ctx, []string{a.inputTopic}, a); err != nil {
+			failures++
+			if werr := a.backoff.wait(ctx, failures, "Aggregator group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (a *Aggregator) Setup(session GroupSession) error {
+	rebalance := a.rebalances.Record(a.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Aggregator setup completed", "input_topic", a.inputTopic, "output_topic", a.outputTopic, "group", a.groupID,
+		"strategy", a.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+func (a *Aggregator) Cleanup(session GroupSession) error {
+	rebalance := a.rebalances.Record(a.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Aggregator cleanup completed", "input_topic", a.inputTopic, "output_topic", a.outputTopic, "group", a.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (a *Aggregator) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: a.rebalanceStrategy, GroupID: a.groupID, InstanceID: a.instanceID}
+}
+
+func (a *Aggregator) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	// Another member may have moved the partition on since this one last
+	// held it, so its state always comes from the last checkpoint.
+	state, err := a.restore(session.Context(), claim.Partition())
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(a.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return a.checkpoint(state)
+			}
+			a.apply(state, message)
+			if state.pending >= a.checkpointEvery {
+				if err := a.checkpoint(state); err != nil {
+					return err
+				}
+			}
+
+		case <-ticker.C:
+			if err := a.checkpoint(state); err != nil {
+				return err
+			}
+
+		case <-session.Context().Done():
+			return a.checkpoint(state)
+		}
+	}
+}
+
+// partitionState holds the aggregates of one partition and what changed
+// since the last checkpoint.
+type partitionState struct {
+	partition  int32
+	aggregates map[string]*Aggregate
+	dirty      map[string]bool
+	last       *sarama.ConsumerMessage
+	pending    int
+}
+
+func newPartitionState(partition int32) *partitionState {
+	return &partitionState{partition: partition, aggregates: make(map[string]*Aggregate), dirty: make(map[string]bool)}
+}
+
+func (s *partitionState) get(kind, id string) *Aggregate {
+	key := kind + ":" + id
+	aggregate, ok := s.aggregates[key]
+	if !ok {
+		aggregate = &Aggregate{Kind: kind, ID: id}
+		s.aggregates[key] = aggregate
+	}
+	s.dirty[key] = true
+	return aggregate
+}
+
+// apply adds message to the aggregates. Messages that can't be decoded are
+// skipped, but still count towards the checkpoint so their offset moves on.
+func (a *Aggregator) apply(state *partitionState, message *sarama.ConsumerMessage) {
+	state.last = message
+	state.pending++
+
+	event, err := a.DecodeEvent(message)
+	if err != nil || event.UserID == "" {
+		slog.Warn("Skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	user := state.get(AggregateUser, event.UserID)
+	user.UserID = event.UserID
+	user.Events++
+	user.UpdatedAt = now
+	if event.EventType == "purchase" {
+		amount, err := purchaseAmount(event)
+		if err != nil {
+			slog.Warn("Purchase without a valid amount", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "user_id", event.UserID, "error", err)
+		}
+		user.Purchases++
+		user.PurchaseTotal = math.Round((user.PurchaseTotal+amount)*100) / 100
+	}
+
+	if sessionID, ok := event.Data["session_id"]; ok {
+		session := state.get(AggregateSession, fmt.Sprint(sessionID))
+		session.UserID = event.UserID
+		session.Events++
+		session.UpdatedAt = now
+	}
+}
+
+// purchaseAmount reads the amount of a purchase, which the generators write
+// as a decimal string.
+func purchaseAmount(event UserEvent) (float64, error) {
+	switch amount := event.Data["amount"].(type) {
+	case string:
+		return strconv.ParseFloat(amount, 64)
+	case float64:
+		return amount, nil
+	case nil:
+		return 0, fmt.Errorf("no amount")
+	default:
+		return 0, fmt.Errorf("unexpected amount %v", amount)
+	}
+}
+
+// checkpoint publishes the aggregates that changed since the last
+// checkpoint and commits the offset of the last message applied, in one
+// transaction. If it fails, the claim ends and the next session restores
+// the state of the previous checkpoint.
+func (a *Aggregator) checkpoint(state *partitionState) error {
+	if state.last == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(state.dirty))
+	for key := range state.dirty {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin checkpoint: %w", err)
+	}
+
+	for _, key := range keys {
+		value, err := json.Marshal(state.aggregates[key])
+		if err != nil {
+			return abortWith(a.producer, err)
+		}
+		_, _, err = a.producer.SendMessage(&sarama.ProducerMessage{
+			Topic:     a.outputTopic,
+			Partition: state.partition,
+			Key:       sarama.StringEncoder(key),
+			Value:     sarama.ByteEncoder(value),
+			Headers:   []sarama.RecordHeader{stringHeader(ContentTypeHeader, JSONSerializer{}.ContentType())},
+		})
+		if err != nil {
+			return abortWith(a.producer, fmt.Errorf("failed to publish %s: %w", key, err))
+		}
+	}
+
+	if err := a.producer.AddMessageToTxn(state.last, a.groupID, nil); err != nil {
+		return abortWith(a.producer, fmt.Errorf("failed to add offset to checkpoint: %w", err))
+	}
+	if err := a.producer.CommitTxn(); err != nil {
+		return abortWith(a.producer, fmt.Errorf("failed to commit checkpoint: %w", err))
+	}
+
+	slog.Info("Checkpoint committed", "topic", a.inputTopic, "partition", state.partition,
+		"offset", state.last.Offset, "messages", state.pending, "snapshots", len(keys))
+	for _, key := range keys {
+		aggregate := state.aggregates[key]
+		if aggregate.Kind == AggregateUser {
+			slog.Info("User aggregate", "user_id", aggregate.ID, "events", aggregate.Events,
+				"purchases", aggregate.Purchases, "purchase_total", fmt.Sprintf("%.2f", aggregate.PurchaseTotal))
+		} else {
+			slog.Debug("Session aggregate", "session_id", aggregate.ID, "user_id", aggregate.UserID, "events", aggregate.Events)
+		}
+	}
+
+	state.dirty = make(map[string]bool)
+	state.last = nil
+	state.pending = 0
+	return nil
+}
+
+// restore reads the latest snapshots of partition from the output topic.
+func (a *Aggregator) restore(ctx context.Context, partition int32) (*partitionState, error) {
+	state := newPartitionState(partition)
+
+	start := time.Now()
+	oldest, err := a.client.GetOffset(a.outputTopic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore partition %d: %w", partition, err)
+	}
+	end, err := a.client.GetOffset(a.outputTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore partition %d: %w", partition, err)
+	}
+	if end <= oldest {
+		slog.Info("No aggregates to restore", "topic", a.outputTopic, "partition", partition)
+		return state, nil
+	}
+
+	pc, err := a.restorer.ConsumePartition(a.outputTopic, partition, oldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore partition %d: %w", partition, err)
+	}
+	defer pc.Close()
+
+	records := 0
+	idle := time.NewTimer(restoreIdle)
+	defer idle.Stop()
+restore:
+	for {
+		select {
+		case message := <-pc.Messages():
+			var aggregate Aggregate
+			if err := json.Unmarshal(message.Value, &aggregate); err != nil {
+				slog.Warn("Skipping invalid aggregate", "topic", message.Topic, "partition", message.Partition,
+					"offset", message.Offset, "error", err)
+			} else {
+				state.aggregates[string(message.Key)] = &aggregate
+			}
+			records++
+			if message.Offset+1 >= end {
+				break restore
+			}
+			idle.Reset(restoreIdle)
+
+		case <-idle.C:
+			// Transaction markers and aborted snapshots take up offsets
+			// but are never delivered.
+			break restore
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	slog.Info("Aggregates restored", "topic", a.outputTopic, "partition", partition, "records", records,
+		"aggregates", len(state.aggregates), "took", time.Since(start).Round(time.Millisecond))
+	return state, nil
+}
+
+func (a *Aggregator) Close() error {
+	consumerErr := a.consumer.Close()
+	if err := a.restorer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := a.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := a.client.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
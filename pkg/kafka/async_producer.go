@@ -0,0 +1,294 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Delivery reports the outcome of a message sent by an AsyncProducer.
+// Bytes is the size of the key and value as sent, after compression and
+// encryption.
+type Delivery struct {
+	Key       string
+	Partition int32
+	Offset    int64
+	Bytes     int
+	Latency   time.Duration
+	Err       error
+}
+
+// DeliveryFunc is called once for every message sent by an AsyncProducer,
+// from the producer's own goroutines.
+type DeliveryFunc func(Delivery)
+
+// AsyncProducer sends messages without waiting for each acknowledgement and
+// reports the result of every send through a DeliveryFunc.
+type AsyncProducer struct {
+	producer    sarama.AsyncProducer
+	client      Client
+	topic       string
+	serializer  Serializer
+	partitioner string
+	partition   int32
+	keyFunc     KeyFunc
+	keyStrategy string
+	timestamp   func(UserEvent) time.Time
+	onDelivery  DeliveryFunc
+	metrics     Metrics
+	breaker     *CircuitBreaker
+	encryption  *envelope
+	dictionary  *dictionaryCodec
+	delay       *delayedDelivery
+	large       largeMessages
+	sequencer   *sequencer
+	wg          sync.WaitGroup
+}
+
+// NewAsyncProducer creates an asynchronous producer. onDelivery may be nil
+// if the caller does not care about the outcome of individual sends. Only
+// SaramaBackend has one.
+func NewAsyncProducer(brokers []string, topic string, onDelivery DeliveryFunc, opts ...Option) (*AsyncProducer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Compression = sarama.CompressionSnappy
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	// The chunks of a message have to be sent in order, one at a time.
+	if o.large.strategy == LargeMessageChunk {
+		return nil, fmt.Errorf("invalid producer config: large message strategy %s needs a synchronous producer", LargeMessageChunk)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := saramaOf(client)
+	if !ok {
+		client.Close()
+		return nil, unsupported("async producers are", o.backend)
+	}
+	producer, err := sarama.NewAsyncProducerFromClient(sc)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create async producer: %w", err)
+	}
+
+	if onDelivery == nil {
+		onDelivery = func(Delivery) {}
+	}
+
+	p := &AsyncProducer{
+		producer:    producer,
+		client:      client,
+		topic:       topic,
+		serializer:  o.serializer,
+		partitioner: o.partitioner,
+		partition:   o.partition,
+		keyFunc:     o.keyFunc,
+		timestamp:   o.recordTimestamp,
+		keyStrategy: o.keyStrategy,
+		onDelivery:  onDelivery,
+		metrics:     o.metrics,
+		breaker:     NewCircuitBreaker(topic, o.breakerThreshold, o.breakerCooldown),
+		encryption:  o.encryption,
+		dictionary:  o.dictionary,
+		delay:       o.delayedDelivery,
+		large:       o.large,
+		sequencer:   o.sequencer,
+	}
+
+	p.wg.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+
+	return p, nil
+}
+
+// Partitioner returns the name of the partitioning strategy in use.
+func (p *AsyncProducer) Partitioner() string {
+	return p.partitioner
+}
+
+// KeyStrategy returns the name of the key strategy in use.
+func (p *AsyncProducer) KeyStrategy() string {
+	return p.keyStrategy
+}
+
+// CircuitState returns the state of the circuit breaker, CircuitClosed if
+// there is none.
+func (p *AsyncProducer) CircuitState() string {
+	return p.breaker.State()
+}
+
+// Topic returns the topic the producer writes to.
+func (p *AsyncProducer) Topic() string {
+	return p.topic
+}
+
+// SendMessage queues a message for delivery. The result is reported to the
+// DeliveryFunc once the broker acknowledges it or sending fails. While the
+// circuit breaker is open the message is dropped and reported with
+// ErrCircuitOpen right away. An empty key sends the message without a key.
+func (p *AsyncProducer) SendMessage(key, value string) {
+	p.SendMessageWithHeaders(key, value, nil)
+}
+
+// SendMessageWithHeaders is SendMessage with the given record headers.
+func (p *AsyncProducer) SendMessageWithHeaders(key, value string, headers map[string]string) {
+	if err := p.breaker.Allow(); err != nil {
+		p.metrics.SendFailed(p.topic)
+		p.onDelivery(Delivery{Key: key, Err: err})
+		return
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Key:       stringKey(key),
+		Value:     sarama.StringEncoder(value),
+		Headers:   recordHeaders(headers),
+		Metadata:  time.Now(),
+	}
+	msg, err := p.prepare(msg)
+	if err != nil {
+		p.metrics.SendFailed(p.topic)
+		p.onDelivery(Delivery{Key: key, Err: err})
+		return
+	}
+	p.producer.Input() <- msg
+}
+
+// SendEvent serializes a user event and queues it with the same key and
+// headers as Producer.SendEvent.
+func (p *AsyncProducer) SendEvent(event UserEvent) error {
+	return p.SendEventWithHeaders(event, nil)
+}
+
+// SendEventWithHeaders is SendEvent with additional record headers, which
+// take precedence over the default ones. It returns ErrCircuitOpen without
+// queueing the event while the circuit breaker is open.
+func (p *AsyncProducer) SendEventWithHeaders(event UserEvent, headers map[string]string) error {
+	if err := p.breaker.Allow(); err != nil {
+		p.metrics.SendFailed(p.topic)
+		return err
+	}
+	value, err := p.serializer.Serialize(event)
+	if err != nil {
+		p.metrics.SendFailed(p.topic)
+		return err
+	}
+	key, _ := eventKey(p.keyFunc, event)
+	msg := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Key:       key,
+		Value:     sarama.ByteEncoder(value),
+		Headers:   EventHeaders(p.serializer, event, headers),
+		Timestamp: recordTimestamp(p.timestamp, event),
+		Metadata:  time.Now(),
+	}
+	if msg, err = p.prepare(msg); err != nil {
+		p.metrics.SendFailed(p.topic)
+		return err
+	}
+	p.producer.Input() <- msg
+	return nil
+}
+
+// prepare schedules, numbers, compresses and encrypts msg and replaces it
+// with a claim check if it is too large, see WithLargeMessages.
+func (p *AsyncProducer) prepare(msg *sarama.ProducerMessage) (*sarama.ProducerMessage, error) {
+	if err := p.delay.schedule(msg); err != nil {
+		return nil, err
+	}
+	if err := p.sequencer.stamp(msg); err != nil {
+		return nil, err
+	}
+	if err := p.dictionary.compress(msg); err != nil {
+		return nil, err
+	}
+	if err := p.encryption.seal(msg); err != nil {
+		return nil, err
+	}
+	prepared, err := p.large.prepare(msg)
+	if err != nil {
+		return nil, err
+	}
+	return prepared[0], nil
+}
+
+// Close stops accepting new messages and waits until every in-flight message
+// has been delivered or has failed.
+func (p *AsyncProducer) Close() error {
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	return p.client.Close()
+}
+
+func (p *AsyncProducer) handleSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		p.breaker.Success()
+		p.metrics.MessageSent(msg.Topic, msg.Partition, sinceQueued(msg))
+		p.onDelivery(Delivery{
+			Key:       messageKey(msg),
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Bytes:     messageSize(msg),
+			Latency:   sinceQueued(msg),
+		})
+	}
+}
+
+func (p *AsyncProducer) handleErrors() {
+	defer p.wg.Done()
+	for perr := range p.producer.Errors() {
+		p.breaker.Failure()
+		p.metrics.SendFailed(perr.Msg.Topic)
+		p.onDelivery(Delivery{
+			Key:       messageKey(perr.Msg),
+			Partition: perr.Msg.Partition,
+			Offset:    perr.Msg.Offset,
+			Latency:   sinceQueued(perr.Msg),
+			Err:       fmt.Errorf("failed to send message: %w", perr.Err),
+		})
+	}
+}
+
+func messageKey(msg *sarama.ProducerMessage) string {
+	if msg.Key == nil {
+		return ""
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return ""
+	}
+	return string(key)
+}
+
+// messageSize returns the size of the key and value of msg.
+func messageSize(msg *sarama.ProducerMessage) int {
+	size := 0
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	return size
+}
+
+func sinceQueued(msg *sarama.ProducerMessage) time.Duration {
+	if queuedAt, ok := msg.Metadata.(time.Time); ok {
+		return time.Since(queuedAt)
+	}
+	return 0
+}
@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LagSource reports how far a consumer group is behind, e.g. a LagMonitor.
+type LagSource interface {
+	Lag() ([]PartitionLag, error)
+}
+
+// LagFunc adapts a function to the LagSource interface.
+type LagFunc func() ([]PartitionLag, error)
+
+func (f LagFunc) Lag() ([]PartitionLag, error) {
+	return f()
+}
+
+// AutoscaleConfig sets when an Autoscaler adds and removes group members.
+type AutoscaleConfig struct {
+	// MinMembers always run. MaxMembers caps scaling up; 0 means one member
+	// per partition, since any more would sit idle.
+	MinMembers int
+	MaxMembers int
+	// ScaleUpLag is the total lag above which a member is added, and
+	// ScaleDownLag the one at or below which a member is removed.
+	ScaleUpLag   int64
+	ScaleDownLag int64
+	// Interval is how often the lag is checked. Cooldown is the least time
+	// between two scaling steps, so the group can settle after the
+	// rebalance each step causes before the lag is judged again.
+	Interval time.Duration
+	Cooldown time.Duration
+}
+
+func (c AutoscaleConfig) validate() error {
+	switch {
+	case c.MinMembers < 1:
+		return fmt.Errorf("autoscaler needs at least 1 member, got %d", c.MinMembers)
+	case c.MaxMembers != 0 && c.MaxMembers < c.MinMembers:
+		return fmt.Errorf("autoscaler max members %d is below min members %d", c.MaxMembers, c.MinMembers)
+	case c.ScaleDownLag < 0 || c.ScaleUpLag <= c.ScaleDownLag:
+		return fmt.Errorf("scale-up lag %d has to be above scale-down lag %d, which must not be negative", c.ScaleUpLag, c.ScaleDownLag)
+	case c.Interval <= 0:
+		return fmt.Errorf("autoscale interval must be positive, got %s", c.Interval)
+	case c.Cooldown < 0:
+		return fmt.Errorf("autoscale cooldown must not be negative, got %s", c.Cooldown)
+	}
+	return nil
+}
+
+// MemberFunc creates a member of the group an Autoscaler scales, numbered
+// from 1 in the order they are started.
+type MemberFunc func(id int) (*Consumer, error)
+
+// Autoscaler runs the members of a consumer group in-process and starts or
+// stops members as the group's lag crosses the thresholds of its config.
+// Every step rebalances the group, which the members log like any other
+// rebalance.
+type Autoscaler struct {
+	config    AutoscaleConfig
+	lag       LagSource
+	newMember MemberFunc
+
+	members    []*autoscaleMember
+	started    int
+	lastScaled time.Time
+
+	// backlogSince is when the lag last rose above ScaleUpLag, zero while
+	// it is back at or below ScaleDownLag.
+	backlogSince time.Time
+	peakLag      int64
+	warnedFull   bool
+
+	scaleUps    int
+	scaleDowns  int
+	peakMembers int
+}
+
+type autoscaleMember struct {
+	id       int
+	consumer *Consumer
+	stop     context.CancelFunc
+	done     chan error
+}
+
+func NewAutoscaler(lag LagSource, newMember MemberFunc, config AutoscaleConfig) (*Autoscaler, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Autoscaler{config: config, lag: lag, newMember: newMember}, nil
+}
+
+// Run starts MinMembers members, then checks the lag every Interval and
+// adds or removes one member at a time until ctx is cancelled. It stops
+// every member before it returns and logs what it did.
+func (a *Autoscaler) Run(ctx context.Context) error {
+	defer a.logSummary()
+	defer a.stopAll()
+
+	for len(a.members) < a.config.MinMembers {
+		if err := a.start(ctx); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.check(ctx)
+		}
+	}
+}
+
+// check compares the current lag with the thresholds and scales by one
+// member if the cooldown allows it.
+func (a *Autoscaler) check(ctx context.Context) {
+	a.reap()
+	// A member that stopped on its own is replaced right away.
+	for len(a.members) < a.config.MinMembers {
+		if err := a.start(ctx); err != nil {
+			slog.Error("Failed to replace group member", "error", err)
+			return
+		}
+	}
+
+	lags, err := a.lag.Lag()
+	if err != nil {
+		slog.Warn("Failed to fetch group lag, not scaling", "error", err)
+		return
+	}
+	var total int64
+	for _, lag := range lags {
+		total += lag.Lag
+	}
+	maxMembers := a.config.MaxMembers
+	if maxMembers == 0 {
+		maxMembers = max(len(lags), a.config.MinMembers)
+	}
+	slog.Info("Group lag", "lag", total, "members", len(a.members), "max_members", maxMembers, "partitions", len(lags))
+	a.trackBacklog(total)
+
+	if time.Since(a.lastScaled) < a.config.Cooldown {
+		return
+	}
+	switch {
+	case total > a.config.ScaleUpLag && len(a.members) < maxMembers:
+		slog.Info("Scaling up", "lag", total, "threshold", a.config.ScaleUpLag, "members", len(a.members)+1)
+		if err := a.start(ctx); err != nil {
+			slog.Error("Failed to start group member", "error", err)
+			return
+		}
+		a.scaleUps++
+	case total > a.config.ScaleUpLag && !a.warnedFull:
+		slog.Warn("Lag above the scale-up threshold, but the group is at its maximum size", "lag", total, "members", len(a.members))
+		a.warnedFull = true
+	case total <= a.config.ScaleDownLag && len(a.members) > a.config.MinMembers:
+		slog.Info("Scaling down", "lag", total, "threshold", a.config.ScaleDownLag, "members", len(a.members)-1)
+		a.stop(a.members[len(a.members)-1])
+		a.members = a.members[:len(a.members)-1]
+		a.lastScaled = time.Now()
+		a.scaleDowns++
+	}
+}
+
+// trackBacklog logs when the lag rises above ScaleUpLag and how long it
+// took to get back to ScaleDownLag.
+func (a *Autoscaler) trackBacklog(total int64) {
+	switch {
+	case a.backlogSince.IsZero() && total > a.config.ScaleUpLag:
+		a.backlogSince = time.Now()
+		a.peakLag = total
+		slog.Warn("Lag above the scale-up threshold", "lag", total, "threshold", a.config.ScaleUpLag, "members", len(a.members))
+	case a.backlogSince.IsZero():
+	case total <= a.config.ScaleDownLag:
+		slog.Info("Lag recovered", "lag", total, "after", time.Since(a.backlogSince).Round(time.Millisecond),
+			"peak_lag", a.peakLag, "members", len(a.members))
+		a.backlogSince = time.Time{}
+		a.warnedFull = false
+	default:
+		a.peakLag = max(a.peakLag, total)
+	}
+}
+
+// start creates a member and runs it until it is stopped.
+func (a *Autoscaler) start(ctx context.Context) error {
+	id := a.started + 1
+	consumer, err := a.newMember(id)
+	if err != nil {
+		return fmt.Errorf("failed to create group member %d: %w", id, err)
+	}
+	a.started = id
+
+	memberCtx, stop := context.WithCancel(ctx)
+	m := &autoscaleMember{id: id, consumer: consumer, stop: stop, done: make(chan error, 1)}
+	go func() {
+		m.done <- consumer.Consume(memberCtx)
+	}()
+	a.members = append(a.members, m)
+	a.lastScaled = time.Now()
+	a.peakMembers = max(a.peakMembers, len(a.members))
+	slog.Info("Group member started", "member", id, "members", len(a.members))
+	return nil
+}
+
+// stop stops m, waiting for its in-flight messages, and leaves the group.
+func (a *Autoscaler) stop(m *autoscaleMember) {
+	m.stop()
+	if err := <-m.done; err != nil {
+		slog.Error("Group member failed", "member", m.id, "error", err)
+	}
+	a.close(m)
+}
+
+func (a *Autoscaler) close(m *autoscaleMember) {
+	if err := m.consumer.Close(); err != nil {
+		slog.Error("Failed to close group member", "member", m.id, "error", err)
+	}
+	slog.Info("Group member stopped", "member", m.id)
+}
+
+// reap removes the members whose Consume returned on its own.
+func (a *Autoscaler) reap() {
+	running := a.members[:0]
+	for _, m := range a.members {
+		select {
+		case err := <-m.done:
+			slog.Error("Group member stopped unexpectedly", "member", m.id, "error", err)
+			m.stop()
+			a.close(m)
+		default:
+			running = append(running, m)
+		}
+	}
+	clear(a.members[len(running):])
+	a.members = running
+}
+
+// stopAll stops every member at once, so they drain in parallel.
+func (a *Autoscaler) stopAll() {
+	for _, m := range a.members {
+		m.stop()
+	}
+	for _, m := range a.members {
+		if err := <-m.done; err != nil {
+			slog.Error("Group member failed", "member", m.id, "error", err)
+		}
+	}
+	for _, m := range a.members {
+		a.close(m)
+	}
+	a.members = nil
+}
+
+func (a *Autoscaler) logSummary() {
+	slog.Info("Autoscaler stopped", "scale_ups", a.scaleUps, "scale_downs", a.scaleDowns,
+		"peak_members", a.peakMembers, "members_started", a.started)
+}
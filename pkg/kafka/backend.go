@@ -0,0 +1,264 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrUnsupported is returned for features the backend a constructor
+// connects through doesn't have, such as transactions on the memory broker.
+var ErrUnsupported = errors.New("not supported")
+
+// ErrTopicExists is returned by Admin.CreateTopic for a topic that already
+// exists.
+var ErrTopicExists = errors.New("topic already exists")
+
+// Backend is the client library every constructor reaches the cluster
+// through. It connects a Client with a ClientConfig, and producers,
+// consumers, processors and topic management only use the interfaces of
+// this file, so a backend adapts a client library, or an in-process
+// stand-in like MemoryBroker, to them. Messages are sarama's
+// ProducerMessage and ConsumerMessage, which are plain structs.
+// SaramaBackend is the default; FranzBackend uses franz-go, and WithBackend
+// picks one.
+type Backend interface {
+	// Name identifies the backend in logs and errors.
+	Name() string
+	// Connect connects a client to brokers with config.
+	Connect(brokers []string, config ClientConfig) (Client, error)
+}
+
+// Client is a connection to the cluster. Producers, group members, readers
+// and admins created from it share it and have to be closed before it.
+type Client interface {
+	// Partitions lists the partitions of topic.
+	Partitions(topic string) ([]int32, error)
+	// GetOffset resolves sarama.OffsetOldest, sarama.OffsetNewest or a
+	// timestamp in milliseconds to an offset of a partition, -1 for
+	// timestamps after the last message.
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+	// RefreshMetadata reloads the metadata of topics, or of every topic.
+	RefreshMetadata(topics ...string) error
+	// NewSyncProducer creates a producer.
+	NewSyncProducer() (SyncProducer, error)
+	// NewConsumerGroup creates a member of group groupID.
+	NewConsumerGroup(groupID string) (ConsumerGroup, error)
+	// NewReader creates a reader of single partitions.
+	NewReader() (Reader, error)
+	// NewAdmin creates a cluster admin.
+	NewAdmin() (Admin, error)
+	Close() error
+}
+
+// SyncProducer sends messages and waits for them to be acknowledged, like
+// sarama.SyncProducer. The transaction methods only work with a
+// transactional ID.
+type SyncProducer interface {
+	// SendMessage sends msg and fills in its partition and offset.
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+	// SendMessages sends msgs, returning sarama.ProducerErrors for the
+	// ones that failed.
+	SendMessages(msgs []*sarama.ProducerMessage) error
+	IsTransactional() bool
+	BeginTxn() error
+	CommitTxn() error
+	AbortTxn() error
+	// AddMessageToTxn commits the offset after msg for group groupID with
+	// the transaction.
+	AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error
+	Close() error
+}
+
+// ConsumerGroup is a member of a consumer group, like sarama.ConsumerGroup.
+type ConsumerGroup interface {
+	// Consume joins the group and runs one session of handler on the
+	// partitions assigned to the member. It returns when the session ends,
+	// because of a rebalance or because ctx is cancelled.
+	Consume(ctx context.Context, topics []string, handler GroupHandler) error
+	// Errors reports the errors of ConsumeClaim and of fetching.
+	Errors() <-chan error
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+	PauseAll()
+	ResumeAll()
+	// Close leaves the group.
+	Close() error
+}
+
+// GroupHandler handles the sessions of a ConsumerGroup. Setup runs before
+// the claims start, ConsumeClaim once per claimed partition in its own
+// goroutine and Cleanup after every ConsumeClaim returned, before the
+// marked offsets are committed for the last time.
+type GroupHandler interface {
+	Setup(GroupSession) error
+	Cleanup(GroupSession) error
+	ConsumeClaim(GroupSession, GroupClaim) error
+}
+
+// GroupSession is one generation of a group member, like
+// sarama.ConsumerGroupSession. MarkOffset only moves the next offset of a
+// partition forward and ResetOffset only rewinds it; called in Setup they
+// move where the claims start.
+type GroupSession interface {
+	Claims() map[string][]int32
+	MemberID() string
+	GenerationID() int32
+	MarkOffset(topic string, partition int32, offset int64, metadata string)
+	ResetOffset(topic string, partition int32, offset int64, metadata string)
+	MarkMessage(msg *sarama.ConsumerMessage, metadata string)
+	// Commit commits the marked offsets right away.
+	Commit()
+	// Context is cancelled when the session ends.
+	Context() context.Context
+}
+
+// GroupClaim is one partition of a GroupSession.
+type GroupClaim interface {
+	Topic() string
+	Partition() int32
+	InitialOffset() int64
+	HighWaterMarkOffset() int64
+	// Messages is closed when the session ends.
+	Messages() <-chan *sarama.ConsumerMessage
+}
+
+// Reader reads single partitions without a consumer group.
+type Reader interface {
+	// ConsumePartition reads partition of topic from offset, an absolute
+	// offset, sarama.OffsetOldest or sarama.OffsetNewest.
+	ConsumePartition(topic string, partition int32, offset int64) (PartitionReader, error)
+	Close() error
+}
+
+// PartitionReader is one partition of a Reader.
+type PartitionReader interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan *sarama.ConsumerError
+	// HighWaterMarkOffset is the offset the next message will get.
+	HighWaterMarkOffset() int64
+	// AsyncClose starts closing the reader; Messages and Errors are closed
+	// once it is done.
+	AsyncClose()
+	Close() error
+}
+
+// Admin manages topics and reads committed offsets.
+type Admin interface {
+	// ListTopics returns the topics of the cluster by name. Configs is
+	// left empty.
+	ListTopics() (map[string]TopicDetail, error)
+	// CreateTopic creates topic, failing with ErrTopicExists if it exists.
+	CreateTopic(topic string, detail TopicDetail) error
+	// TopicConfig returns the value of the topic config name of topic.
+	TopicConfig(topic, name string) (string, error)
+	// ListGroupOffsets returns the offsets group groupID committed on
+	// partitions, on every partition it committed to if partitions is nil.
+	// Partitions without one have offset -1.
+	ListGroupOffsets(groupID string, partitions map[string][]int32) (map[string]map[int32]GroupOffset, error)
+	// Brokers returns the number of brokers of the cluster.
+	Brokers() (int, error)
+	Close() error
+}
+
+// GroupOffset is an offset a group committed, with the metadata committed
+// along with it.
+type GroupOffset struct {
+	Offset   int64
+	Metadata string
+}
+
+// TopicDetail describes a topic.
+type TopicDetail struct {
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+// WithBackend makes every constructor connect through backend instead of
+// SaramaBackend.
+func WithBackend(backend Backend) Option {
+	return func(o *options) error {
+		if backend == nil {
+			return fmt.Errorf("backend must not be nil")
+		}
+		o.backend = backend
+		return nil
+	}
+}
+
+// connect connects a client of the options' backend to brokers.
+func (o *options) connect(brokers []string) (Client, error) {
+	client, err := o.backend.Connect(brokers, newClientConfig(o.config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client: %w", o.backend.Name(), err)
+	}
+	return client, nil
+}
+
+// unsupported is the error for a feature backend doesn't have, e.g.
+// unsupported("topic patterns are", backend).
+func unsupported(feature string, backend Backend) error {
+	if _, ok := backend.(*MemoryBroker); ok {
+		return fmt.Errorf("%s %w", feature, ErrMemoryUnsupported)
+	}
+	return fmt.Errorf("%s %w by the %s backend", feature, ErrUnsupported, backend.Name())
+}
+
+// newSyncProducer connects a producer with a client of its own, which it
+// closes when it is closed.
+func (o *options) newSyncProducer(brokers []string) (SyncProducer, error) {
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := client.NewSyncProducer()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+	return closingProducer{SyncProducer: producer, client: client}, nil
+}
+
+// newConsumerGroup connects a member of group groupID with a client of its
+// own, which it closes when it is closed.
+func (o *options) newConsumerGroup(brokers []string, groupID string) (ConsumerGroup, error) {
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	group, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	return closingGroup{ConsumerGroup: group, client: client}, nil
+}
+
+type closingProducer struct {
+	SyncProducer
+	client Client
+}
+
+func (p closingProducer) Close() error {
+	err := p.SyncProducer.Close()
+	if cerr := p.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type closingGroup struct {
+	ConsumerGroup
+	client Client
+}
+
+func (g closingGroup) Close() error {
+	err := g.ConsumerGroup.Close()
+	if cerr := g.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
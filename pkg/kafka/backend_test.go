@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+)
+
+// recordingBackend is a MemoryBroker that counts the clients it connected.
+type recordingBackend struct {
+	*MemoryBroker
+	clients int
+}
+
+func (b *recordingBackend) Name() string { return "recording" }
+
+func (b *recordingBackend) Connect(brokers []string, config ClientConfig) (Client, error) {
+	b.clients++
+	return b.MemoryBroker.Connect(brokers, config)
+}
+
+func TestWithBackend(t *testing.T) {
+	backend := &recordingBackend{MemoryBroker: NewMemoryBroker(3)}
+	brokers := []string{"unreachable:9092"}
+
+	producer, err := NewProducer(brokers, "events", WithBackend(backend))
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer producer.Close()
+	if _, _, err := producer.SendMessage("user-1", "hello"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	consumer, err := NewConsumer(brokers, "events", "group", WithBackend(backend), WithMaxRetries(0), WithDeadLetterQueue("events-dlq"))
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer consumer.Close()
+
+	// The consumer's retry producer shares the consumer's client.
+	if backend.clients != 2 {
+		t.Errorf("backend connected %d clients, want 2", backend.clients)
+	}
+	if backend.Partitions("events") != 3 {
+		t.Errorf("topic has %d partitions on the backend, want 3", backend.Partitions("events"))
+	}
+}
+
+func TestWithBackendErrors(t *testing.T) {
+	if _, err := NewProducer(nil, "events", WithBackend(nil)); err == nil {
+		t.Error("NewProducer accepted a nil backend")
+	}
+
+	_, err := NewPatternConsumer(nil, "^events", "group", WithMemoryBroker(NewMemoryBroker(1)))
+	if !errors.Is(err, ErrMemoryUnsupported) {
+		t.Errorf("NewPatternConsumer on the memory broker returned %v, want ErrMemoryUnsupported", err)
+	}
+}
+
+func TestBackendNames(t *testing.T) {
+	for backend, want := range map[Backend]string{SaramaBackend{}: "sarama", FranzBackend{}: "franz-go", NewMemoryBroker(1): "memory"} {
+		if got := backend.Name(); got != want {
+			t.Errorf("Name() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestFranzBalancers(t *testing.T) {
+	for _, name := range []string{RebalanceRange, RebalanceRoundRobin, RebalanceSticky} {
+		if _, err := franzBalancer(name); err != nil {
+			t.Errorf("franzBalancer(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{RebalanceCooperativeSticky, RebalanceSingleActive} {
+		if _, err := franzBalancer(name); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("franzBalancer(%q) returned %v, want ErrUnsupported", name, err)
+		}
+	}
+}
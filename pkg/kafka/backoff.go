@@ -0,0 +1,158 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Backoff spaces out attempts to reach the brokers: each delay is Multiplier
+// times the previous one, capped at Max, and varied by up to Jitter (a
+// fraction of the delay) either way so that many clients don't retry in
+// lockstep. MaxRetries is the retry budget; zero disables retrying.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	MaxRetries int
+}
+
+// DefaultBackoff retries ten times over about two and a half minutes.
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+	MaxRetries: 10,
+}
+
+// WithBackoff retries sends of a sync producer, and reconnects consumer
+// group sessions, according to b instead of failing on the first error.
+// Errors that a retry can't fix, such as a message that is too large, are
+// returned right away.
+func WithBackoff(b Backoff) Option {
+	return func(o *options) error {
+		if b.MaxRetries < 0 || b.Initial < 0 || b.Max < 0 || b.Jitter < 0 || b.Jitter > 1 {
+			return fmt.Errorf("invalid backoff %+v", b)
+		}
+		o.backoff = b
+		return nil
+	}
+}
+
+// Delay returns how long to wait before retry number attempt, counting
+// from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// Retry calls fn until it succeeds, fails permanently, the retry budget is
+// spent or ctx is done, and returns the last error. what names the
+// operation in the logs.
+func (b Backoff) Retry(ctx context.Context, what string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			if attempt > 0 {
+				slog.Info(what+" succeeded after retrying", "retries", attempt)
+			}
+			return nil
+		}
+		if err := b.wait(ctx, attempt+1, what, err); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps before retry number attempt after err. It returns err instead
+// if the error is permanent or the budget is spent, and ctx.Err if ctx is
+// done first.
+func (b Backoff) wait(ctx context.Context, attempt int, what string, err error) error {
+	if isPermanent(err) {
+		return err
+	}
+	if attempt > b.MaxRetries {
+		if b.MaxRetries > 0 {
+			slog.Error(what+" failed, retry budget spent", "retries", b.MaxRetries, "error", err)
+		}
+		return err
+	}
+
+	delay := b.Delay(attempt)
+	slog.Warn(what+" failed, retrying", "attempt", attempt, "max_retries", b.MaxRetries,
+		"delay", delay.Round(time.Millisecond), "error", err)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Permanent marks err as one Retry returns right away instead of retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// isPermanent reports whether retrying err can't help.
+func isPermanent(err error) bool {
+	if errors.As(err, new(permanentError)) {
+		return true
+	}
+	for _, permanent := range []error{
+		ErrCircuitOpen,
+		context.Canceled,
+		context.DeadlineExceeded,
+		sarama.ErrClosedClient,
+		sarama.ErrClosedConsumerGroup,
+		sarama.ErrMessageSizeTooLarge,
+		sarama.ErrMessageTooLarge,
+		sarama.ErrInvalidMessage,
+		sarama.ErrTopicAuthorizationFailed,
+		sarama.ErrClusterAuthorizationFailed,
+		sarama.ErrGroupAuthorizationFailed,
+		sarama.ErrTransactionalIDAuthorizationFailed,
+		kgo.ErrClientClosed,
+		kerr.MessageTooLarge,
+		kerr.RecordListTooLarge,
+		kerr.InvalidRecord,
+		kerr.TopicAuthorizationFailed,
+		kerr.ClusterAuthorizationFailed,
+		kerr.GroupAuthorizationFailed,
+		kerr.TransactionalIDAuthorizationFailed,
+	} {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// WithInFlightBuffer puts a buffer of up to capacity messages between
+// reading each claim and processing it. When a buffer fills up, its
+// partition is paused, so the consumer stops fetching from it instead of
+// piling messages up in sarama's channels, and once the buffer has drained
+// to half its capacity the partition is resumed. The occupancy of every
+// buffer and the pauses are reported through WithMetrics. Only consumer
+// groups have a buffer.
+func WithInFlightBuffer(capacity int) Option {
+	return func(o *options) error {
+		if capacity < 1 {
+			return fmt.Errorf("in-flight buffer capacity must be at least 1, got %d", capacity)
+		}
+		o.inFlight = capacity
+		return nil
+	}
+}
+
+// partitionPauser is the part of ConsumerGroup an inFlightBuffer
+// pauses its partition with.
+type partitionPauser interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+}
+
+// inFlightBuffer is the buffer of one claim. A nil buffer does nothing.
+type inFlightBuffer struct {
+	topic     string
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+	pauser    partitionPauser
+	metrics   Metrics
+	cancel    context.CancelFunc
+
+	mu        sync.Mutex
+	paused    bool
+	pausedAt  time.Time
+	pauses    int
+	pausedFor time.Duration
+}
+
+// newInFlightBuffer starts copying the messages of claim into a buffer of
+// capacity until the claim ends, ctx is done or the buffer is closed. With
+// a capacity of 0 it returns nil.
+func newInFlightBuffer(ctx context.Context, capacity int, claim GroupClaim, pauser partitionPauser, metrics Metrics) *inFlightBuffer {
+	if capacity == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	b := &inFlightBuffer{
+		topic:     claim.Topic(),
+		partition: claim.Partition(),
+		messages:  make(chan *sarama.ConsumerMessage, capacity),
+		pauser:    pauser,
+		metrics:   metrics,
+		cancel:    cancel,
+	}
+	go b.fill(ctx, claim.Messages())
+	return b
+}
+
+// fill copies messages from in into the buffer, pausing the partition
+// whenever the buffer is full. It closes the buffer when it stops.
+func (b *inFlightBuffer) fill(ctx context.Context, in <-chan *sarama.ConsumerMessage) {
+	defer close(b.messages)
+	for {
+		select {
+		case message, ok := <-in:
+			if !ok {
+				return
+			}
+			if len(b.messages) == cap(b.messages) {
+				b.pause()
+			}
+			select {
+			case b.messages <- message:
+				b.report()
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// taken is called for every message taken out of the buffer. It resumes
+// the partition once the buffer has drained to half its capacity.
+func (b *inFlightBuffer) taken() {
+	if b == nil {
+		return
+	}
+	b.report()
+	if len(b.messages) <= cap(b.messages)/2 {
+		b.resume()
+	}
+}
+
+func (b *inFlightBuffer) pause() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.paused {
+		return
+	}
+	b.pauser.Pause(map[string][]int32{b.topic: {b.partition}})
+	b.paused = true
+	b.pausedAt = time.Now()
+	b.pauses++
+	b.metrics.BackpressurePaused(b.topic, b.partition)
+	slog.Debug("In-flight buffer full, pausing partition", "topic", b.topic, "partition", b.partition,
+		"capacity", cap(b.messages))
+}
+
+func (b *inFlightBuffer) resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.paused {
+		return
+	}
+	b.pauser.Resume(map[string][]int32{b.topic: {b.partition}})
+	b.paused = false
+	pausedFor := time.Since(b.pausedAt)
+	b.pausedFor += pausedFor
+	slog.Debug("In-flight buffer drained, resuming partition", "topic", b.topic, "partition", b.partition,
+		"paused_for", pausedFor.Round(time.Millisecond))
+}
+
+func (b *inFlightBuffer) report() {
+	b.metrics.InFlightBuffer(b.topic, b.partition, len(b.messages), cap(b.messages))
+}
+
+// close stops filling the buffer, resumes the partition if it is paused and
+// logs how often it was.
+func (b *inFlightBuffer) close() {
+	if b == nil {
+		return
+	}
+	b.cancel()
+	b.resume()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pauses > 0 {
+		slog.Info("Backpressure summary", "topic", b.topic, "partition", b.partition, "capacity", cap(b.messages),
+			"pauses", b.pauses, "paused_for", b.pausedFor.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchStats describes one batch flushed by a Batcher.
+type BatchStats struct {
+	Size    int
+	Failed  int
+	Latency time.Duration
+}
+
+// Throughput returns the messages per second the batch was sent at.
+func (s BatchStats) Throughput() float64 {
+	if s.Latency <= 0 {
+		return 0
+	}
+	return float64(s.Size-s.Failed) / s.Latency.Seconds()
+}
+
+// BatchFunc is called after every flushed batch with its stats and one
+// Delivery per event.
+type BatchFunc func(BatchStats, []Delivery)
+
+// Batcher accumulates events and sends them with Producer.SendBatch once
+// size events are queued or linger has passed since the first one, trading
+// latency for throughput.
+type Batcher struct {
+	producer *Producer
+	size     int
+	linger   time.Duration
+	headers  map[string]string
+	onBatch  BatchFunc
+
+	mu      sync.Mutex
+	pending []UserEvent
+	timer   *time.Timer
+}
+
+// NewBatcher creates a batcher on top of producer. A linger of zero only
+// flushes full batches (and on Flush or Close). headers are added to every
+// message; onBatch may be nil.
+func NewBatcher(producer *Producer, size int, linger time.Duration, headers map[string]string, onBatch BatchFunc) *Batcher {
+	if size < 1 {
+		size = 1
+	}
+	if onBatch == nil {
+		onBatch = func(BatchStats, []Delivery) {}
+	}
+	return &Batcher{
+		producer: producer,
+		size:     size,
+		linger:   linger,
+		headers:  headers,
+		onBatch:  onBatch,
+		pending:  make([]UserEvent, 0, size),
+	}
+}
+
+// Add queues event, sending the batch if it is full. When the batch is sent
+// Add blocks until the broker has answered, which throttles the caller.
+func (b *Batcher) Add(event UserEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, event)
+	if len(b.pending) >= b.size {
+		b.flushLocked()
+		return
+	}
+	if len(b.pending) == 1 && b.linger > 0 {
+		b.timer = time.AfterFunc(b.linger, b.Flush)
+	}
+}
+
+// Flush sends whatever is queued.
+func (b *Batcher) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *Batcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+
+	events := b.pending
+	b.pending = make([]UserEvent, 0, b.size)
+
+	start := time.Now()
+	deliveries, err := b.producer.SendBatchWithHeaders(events, b.headers)
+	stats := BatchStats{Size: len(events), Latency: time.Since(start)}
+	if err != nil {
+		deliveries = make([]Delivery, len(events))
+		for i, event := range events {
+			_, key := eventKey(b.producer.keyFunc, event)
+			deliveries[i] = Delivery{Key: key, Err: err}
+		}
+	}
+	for _, d := range deliveries {
+		if d.Err != nil {
+			stats.Failed++
+		}
+	}
+
+	b.onBatch(stats, deliveries)
+}
+
+// Close flushes the remaining events and closes the producer.
+func (b *Batcher) Close() error {
+	b.Flush()
+	return b.producer.Close()
+}
@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// States of a CircuitBreaker.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned for sends rejected by an open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// WithCircuitBreaker stops producers from sending once threshold sends in a
+// row have failed. For cooldown every send fails right away with
+// ErrCircuitOpen, which sheds the load instead of queueing it up behind
+// unreachable brokers. Then a single trial send decides whether the circuit
+// closes again or stays open for another cooldown. A threshold of zero
+// disables the breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *options) error {
+		if threshold < 0 || cooldown < 0 {
+			return fmt.Errorf("invalid circuit breaker: threshold %d, cooldown %s", threshold, cooldown)
+		}
+		o.breakerThreshold = threshold
+		o.breakerCooldown = cooldown
+		return nil
+	}
+}
+
+// CircuitBreaker tracks consecutive failures. A nil *CircuitBreaker allows
+// everything, so callers don't have to check whether one is configured. It
+// is safe for concurrent use.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreaker creates a closed breaker. name identifies it in the
+// logs. It returns nil if threshold is zero.
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown, state: CircuitClosed}
+}
+
+// Allow returns ErrCircuitOpen while the breaker is open. Once the cooldown
+// has passed it lets one trial call through and keeps rejecting the others
+// until that call reports Success or Failure.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.transition(CircuitHalfOpen, "cooldown over, trying one call")
+		b.trial = true
+		return nil
+	case CircuitHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success records a call that worked, closing the breaker.
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+	if b.state != CircuitClosed {
+		b.transition(CircuitClosed, "call succeeded")
+	}
+}
+
+// Failure records a call that failed, opening the breaker after threshold
+// failures in a row or after a failed trial.
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	switch {
+	case b.state == CircuitHalfOpen:
+		b.open("trial call failed")
+	case b.state == CircuitClosed && b.failures >= b.threshold:
+		b.open(fmt.Sprintf("%d failures in a row", b.failures))
+	}
+}
+
+// State returns CircuitClosed, CircuitOpen or CircuitHalfOpen.
+func (b *CircuitBreaker) State() string {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) open(reason string) {
+	b.openedAt = time.Now()
+	b.transition(CircuitOpen, reason)
+}
+
+func (b *CircuitBreaker) transition(state, reason string) {
+	attrs := []any{"breaker", b.name, "from", b.state, "to", state, "reason", reason}
+	if state == CircuitOpen {
+		attrs = append(attrs, "retry_in", b.cooldown)
+		slog.Error("Circuit breaker state changed", attrs...)
+	} else {
+		slog.Warn("Circuit breaker state changed", attrs...)
+	}
+	b.state = state
+}
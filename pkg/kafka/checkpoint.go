@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// CheckpointStore keeps the offsets of a consumer group outside Kafka, in
+// place of __consumer_offsets. Implementations must be safe for concurrent
+// use; every partition saves its own checkpoints.
+type CheckpointStore interface {
+	// Load returns the offset to resume from of every partition of topic
+	// that group has a checkpoint for.
+	Load(ctx context.Context, group, topic string) (map[int32]int64, error)
+	// Save stores next as the offset partition resumes from.
+	Save(ctx context.Context, group, topic string, partition int32, next int64) error
+	Close() error
+}
+
+// WithCheckpointStore makes a SinkConsumer checkpoint its offsets in store
+// instead of committing them to Kafka. A batch's checkpoint is saved once
+// the sink has stored it, and claimed partitions resume from their
+// checkpoint; partitions without one start from the group's committed
+// offset, if it has any. The consumer closes store.
+func WithCheckpointStore(store CheckpointStore) Option {
+	return func(o *options) error {
+		o.checkpoints = store
+		o.config.Consumer.Offsets.AutoCommit.Enable = false
+		return nil
+	}
+}
+
+// resumeFromCheckpoints moves every partition of topic claimed in session
+// to its checkpoint in store, forward or back from the committed offset.
+func resumeFromCheckpoints(session GroupSession, store CheckpointStore, group, topic string) error {
+	checkpoints, err := store.Load(session.Context(), group, topic)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoints of group %s: %w", group, err)
+	}
+	for _, partition := range session.Claims()[topic] {
+		next, ok := checkpoints[partition]
+		if !ok {
+			slog.Info("No checkpoint, starting partition from the committed offset", "topic", topic,
+				"partition", partition, "group", group)
+			continue
+		}
+		seekOffset(session, topic, partition, next)
+		slog.Info("Resuming partition from checkpoint", "topic", topic, "partition", partition, "group", group,
+			"offset", next)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+)
+
+// mapCheckpoints is a CheckpointStore of one group and topic.
+type mapCheckpoints map[int32]int64
+
+func (m mapCheckpoints) Load(ctx context.Context, group, topic string) (map[int32]int64, error) {
+	return m, nil
+}
+
+func (m mapCheckpoints) Save(ctx context.Context, group, topic string, partition int32, next int64) error {
+	m[partition] = next
+	return nil
+}
+
+func (m mapCheckpoints) Close() error { return nil }
+
+func TestResumeFromCheckpoints(t *testing.T) {
+	// The sink stored batches past what was committed to Kafka on partition
+	// 0, and the committed offset ran ahead of the checkpoint on partition
+	// 1. Partition 2 has no checkpoint.
+	session := newSaramaSession("events", map[int32]int64{0: 20, 1: 80, 2: 40})
+	store := mapCheckpoints{0: 35, 1: 60}
+	if err := resumeFromCheckpoints(session, store, "group", "events"); err != nil {
+		t.Fatalf("resumeFromCheckpoints: %v", err)
+	}
+
+	for partition, want := range map[int32]int64{0: 35, 1: 60, 2: 40} {
+		if got := session.next[partition]; got != want {
+			t.Errorf("partition %d resumes from %d, want %d", partition, got, want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// claimPipeline processes the messages of one claimed partition. Every
+// pipeline has its own in-flight buffer, chunk assembler, worker pool and
+// offset tracker, so a partition that is slow to process only holds back
+// its own messages and offsets, never those of the other partitions.
+type claimPipeline struct {
+	c       *Consumer
+	session GroupSession
+	claim   GroupClaim
+
+	tracker  *PartitionTracker
+	offsets  *partitionOffsets
+	buffer   *inFlightBuffer
+	chunks   *chunkAssembler
+	pool     *workerPool
+	messages <-chan *sarama.ConsumerMessage
+	count    int
+}
+
+func newClaimPipeline(c *Consumer, session GroupSession, claim GroupClaim) *claimPipeline {
+	p := &claimPipeline{
+		c:        c,
+		session:  session,
+		claim:    claim,
+		tracker:  c.tracker(claim.Topic()),
+		offsets:  c.committer.claim(claim.Topic(), claim.Partition()),
+		chunks:   newChunkAssembler(),
+		messages: claim.Messages(),
+	}
+
+	// With a worker pool, returning waits for the queued messages so their
+	// offsets can still be marked before the session commits.
+	if c.concurrency > 1 {
+		p.pool = newWorkerPool(c.concurrency,
+			func(message *sarama.ConsumerMessage) error { return c.process(c.processCtx, message) },
+			p.mark)
+	}
+	p.buffer = newInFlightBuffer(session.Context(), c.inFlight, claim, c.consumer, c.metrics)
+	if p.buffer != nil {
+		p.messages = p.buffer.messages
+	}
+	return p
+}
+
+// close releases the partition's buffer and priority slot and logs its
+// offsets.
+func (p *claimPipeline) close() {
+	p.buffer.close()
+	p.c.priority.release(p.claim.Topic(), p.claim.Partition())
+	p.offsets.release()
+}
+
+// finish waits for the messages queued in the worker pool.
+func (p *claimPipeline) finish() error {
+	if p.pool == nil {
+		return nil
+	}
+	return p.pool.close()
+}
+
+// skip marks a message that is not processed, in offset order with the
+// messages queued before it.
+func (p *claimPipeline) skip(message *sarama.ConsumerMessage) {
+	if p.pool != nil {
+		p.pool.skip(message)
+		return
+	}
+	p.mark(message)
+}
+
+// mark marks message, but no further than the first chunk of a message
+// that is still missing chunks: committing past it would leave a consumer
+// that takes over with the rest of the chunks only, and no way to put them
+// together. A later mark moves the offset on once the message is complete.
+func (p *claimPipeline) mark(message *sarama.ConsumerMessage) {
+	if first, ok := p.chunks.first(); ok && first <= message.Offset {
+		message = &sarama.ConsumerMessage{Topic: message.Topic, Partition: message.Partition, Offset: first - 1}
+	}
+	p.offsets.mark(message)
+}
+
+func (p *claimPipeline) run() error {
+	c, session, claim := p.c, p.session, p.claim
+
+	for {
+		select {
+		case message := <-p.messages:
+			// Once the session ends no new message is started; anything not
+			// marked yet is redelivered to whoever owns the partition next.
+			if message == nil || session.Context().Err() != nil {
+				return p.finish()
+			}
+			p.buffer.taken()
+			// Chunks are skipped until the last one completes the message.
+			whole, complete := p.chunks.add(message)
+			if !complete {
+				p.skip(message)
+				continue
+			}
+			message = whole
+			if c.ordering != nil {
+				c.ordering.Record(newMessage(message))
+			}
+			c.latency.observe(c.metrics, message, time.Now())
+			c.timestamps.observe(message)
+
+			p.count++
+			userID := string(message.Key)
+
+			p.tracker.RecordSize(userID, message.Partition, len(message.Key)+len(message.Value))
+			c.metrics.MessageConsumed(message.Topic, message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
+
+			if eventTime, watermark, late := c.watermarks.observe(message); late && c.lateTopic != "" {
+				if err := c.routeLate(message, eventTime, watermark); err != nil {
+					if ferr := p.finish(); ferr != nil {
+						slog.Error("Failed to finish queued messages", "error", ferr)
+					}
+					return err
+				}
+				p.skip(message)
+				continue
+			}
+
+			if c.filtered(message) {
+				p.skip(message)
+				continue
+			}
+
+			// Messages of lower-priority topics wait here for their turn.
+			if err := c.priority.wait(session.Context(), message, claim.HighWaterMarkOffset()); err != nil {
+				return p.finish()
+			}
+
+			if !c.quiet {
+				slog.Info("Message received", "count", p.count, "topic", message.Topic,
+					"partition", message.Partition, "offset", message.Offset, "key", userID, "value", c.describeValue(message),
+					"headers", MessageHeaders(message))
+			}
+
+			if err := c.waitUntilDue(session.Context(), message); err != nil {
+				return p.finish()
+			}
+
+			// At-most-once gives up the message before touching it: once
+			// the commit is through, a crash can only lose it.
+			if c.semantics == DeliveryAtMostOnce {
+				session.MarkMessage(message, "")
+				session.Commit()
+				if c.crashes.crash(message, c.semantics) {
+					return errSimulatedCrash
+				}
+			}
+
+			if p.pool != nil {
+				if !p.pool.dispatch(message) {
+					return p.finish()
+				}
+				continue
+			}
+
+			if err := c.process(c.processCtx, message); err != nil {
+				return err
+			}
+			if c.semantics == DeliveryAtMostOnce {
+				continue
+			}
+
+			if c.crashes.crash(message, c.semantics) {
+				return errSimulatedCrash
+			}
+
+			// Mark message as processed
+			p.mark(message)
+
+		case <-session.Context().Done():
+			return p.finish()
+		}
+	}
+}
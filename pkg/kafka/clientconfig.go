@@ -0,0 +1,212 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"kafka-hwsw/internal/auth"
+)
+
+// ClientConfig is what a Backend connects with. The options of a
+// constructor fill it in, starting from the defaults of what the
+// constructor creates.
+type ClientConfig struct {
+	// ClientID is reported to the brokers.
+	ClientID string
+	// TLS is nil for plaintext connections.
+	TLS *tls.Config
+	// SASL is nil without authentication.
+	SASL *SASLConfig
+	// Dial replaces the dialer of the broker connections, e.g. with the
+	// failure injector of --chaos-*. Nil uses a net.Dialer.
+	Dial        func(ctx context.Context, network, address string) (net.Conn, error)
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+
+	Producer ProducerConfig
+	Consumer ConsumerConfig
+
+	// sarama is the sarama config the options were applied to, so that
+	// SaramaBackend keeps what only WithSaramaConfig reaches. It is nil for
+	// configs built by hand.
+	sarama *sarama.Config
+}
+
+// SASLConfig authenticates with Mechanism, one of PLAIN, SCRAM-SHA-256 and
+// SCRAM-SHA-512.
+type SASLConfig struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// ProducerConfig is the part of a ClientConfig producers use.
+type ProducerConfig struct {
+	// Acks is how many replicas acknowledge a message: 0 for none, 1 for
+	// the leader and -1 for every in-sync replica.
+	Acks int16
+	// Retries is how often a failed send is retried before giving up.
+	Retries int
+	// Compression is one of CompressionCodecs.
+	Compression     string
+	Idempotent      bool
+	TransactionalID string
+	// Partitioner picks the partition of each message.
+	Partitioner sarama.PartitionerConstructor
+}
+
+// ConsumerConfig is the part of a ClientConfig group members and readers
+// use.
+type ConsumerConfig struct {
+	// InitialOffset is where a group starts on partitions it has no
+	// committed offset for, sarama.OffsetOldest or sarama.OffsetNewest.
+	InitialOffset      int64
+	AutoCommit         bool
+	AutoCommitInterval time.Duration
+	// ReadCommitted skips the messages of aborted transactions and waits
+	// for open ones.
+	ReadCommitted bool
+	// Rebalance is the partition assignment strategy, one of
+	// RebalanceStrategies.
+	Rebalance string
+	// InstanceID makes the member a static one, see WithGroupInstanceID.
+	InstanceID string
+}
+
+// newClientConfig describes config, the sarama config options were applied
+// to, to any backend.
+func newClientConfig(config *sarama.Config) ClientConfig {
+	c := ClientConfig{
+		ClientID:    config.ClientID,
+		DialTimeout: config.Net.DialTimeout,
+		ReadTimeout: config.Net.ReadTimeout,
+		Producer: ProducerConfig{
+			Acks:            int16(config.Producer.RequiredAcks),
+			Retries:         config.Producer.Retry.Max,
+			Compression:     config.Producer.Compression.String(),
+			Idempotent:      config.Producer.Idempotent,
+			TransactionalID: config.Producer.Transaction.ID,
+			Partitioner:     config.Producer.Partitioner,
+		},
+		Consumer: ConsumerConfig{
+			InitialOffset:      config.Consumer.Offsets.Initial,
+			AutoCommit:         config.Consumer.Offsets.AutoCommit.Enable,
+			AutoCommitInterval: config.Consumer.Offsets.AutoCommit.Interval,
+			ReadCommitted:      config.Consumer.IsolationLevel == sarama.ReadCommitted,
+			Rebalance:          RebalanceRoundRobin,
+			InstanceID:         config.Consumer.Group.InstanceId,
+		},
+		sarama: config,
+	}
+	if config.Net.TLS.Enable {
+		c.TLS = config.Net.TLS.Config
+		if c.TLS == nil {
+			c.TLS = &tls.Config{}
+		}
+	}
+	if config.Net.SASL.Enable {
+		c.SASL = &SASLConfig{
+			Mechanism: string(config.Net.SASL.Mechanism),
+			Username:  config.Net.SASL.User,
+			Password:  config.Net.SASL.Password,
+		}
+		if c.SASL.Mechanism == "" {
+			c.SASL.Mechanism = auth.MechanismPlain
+		}
+	}
+	if config.Net.Proxy.Enable {
+		dialer := config.Net.Proxy.Dialer
+		c.Dial = func(_ context.Context, network, address string) (net.Conn, error) {
+			return dialer.Dial(network, address)
+		}
+	}
+	if strategies := config.Consumer.Group.Rebalance.GroupStrategies; len(strategies) > 0 {
+		c.Consumer.Rebalance = strategies[0].Name()
+	} else if config.Consumer.Group.Rebalance.Strategy != nil {
+		c.Consumer.Rebalance = config.Consumer.Group.Rebalance.Strategy.Name()
+	}
+	return c
+}
+
+// saramaConfig returns the sarama config c was made from, or builds one
+// from its fields for configs made by hand.
+func (c ClientConfig) saramaConfig() (*sarama.Config, error) {
+	if c.sarama != nil {
+		return c.sarama, nil
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	if c.ClientID != "" {
+		config.ClientID = c.ClientID
+	}
+	if c.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = c.TLS
+	}
+	if c.SASL != nil {
+		err := auth.SASL{Mechanism: c.SASL.Mechanism, Username: c.SASL.Username, Password: c.SASL.Password}.Apply(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Dial != nil {
+		config.Net.Proxy.Enable = true
+		config.Net.Proxy.Dialer = contextDialer(c.Dial)
+	}
+	if c.DialTimeout > 0 {
+		config.Net.DialTimeout = c.DialTimeout
+	}
+	if c.ReadTimeout > 0 {
+		config.Net.ReadTimeout = c.ReadTimeout
+	}
+
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.RequiredAcks(c.Producer.Acks)
+	config.Producer.Retry.Max = c.Producer.Retries
+	if err := config.Producer.Compression.UnmarshalText([]byte(c.Producer.Compression)); err != nil && c.Producer.Compression != "" {
+		return nil, err
+	}
+	config.Producer.Idempotent = c.Producer.Idempotent
+	if c.Producer.Idempotent {
+		config.Net.MaxOpenRequests = 1
+	}
+	config.Producer.Transaction.ID = c.Producer.TransactionalID
+	if c.Producer.Partitioner != nil {
+		config.Producer.Partitioner = c.Producer.Partitioner
+	}
+
+	if c.Consumer.InitialOffset != 0 {
+		config.Consumer.Offsets.Initial = c.Consumer.InitialOffset
+	}
+	config.Consumer.Offsets.AutoCommit.Enable = c.Consumer.AutoCommit
+	if c.Consumer.AutoCommitInterval > 0 {
+		config.Consumer.Offsets.AutoCommit.Interval = c.Consumer.AutoCommitInterval
+	}
+	if c.Consumer.ReadCommitted {
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	strategy, err := NewBalanceStrategy(c.Consumer.Rebalance)
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+	config.Consumer.Group.InstanceId = c.Consumer.InstanceID
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// contextDialer is a ClientConfig.Dial as the proxy dialer of a sarama
+// config.
+type contextDialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
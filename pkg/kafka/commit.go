@@ -0,0 +1,213 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Commit modes for consumer group offsets.
+const (
+	// CommitAuto lets sarama commit the marked offsets every second.
+	CommitAuto = "auto"
+	// CommitManual commits after every processed message: the fewest
+	// duplicates after a crash, at the cost of a round trip per message.
+	CommitManual = "manual"
+	// CommitBatch commits after every N processed messages.
+	CommitBatch = "batch"
+	// CommitInterval commits on a fixed timer.
+	CommitInterval = "interval"
+)
+
+// CommitStrategy controls when a consumer group commits its offsets. Every
+// only applies to CommitBatch and Interval only to CommitInterval.
+type CommitStrategy struct {
+	Mode     string
+	Every    int
+	Interval time.Duration
+}
+
+// WithCommitStrategy replaces auto-commit with the given strategy. Offsets
+// are always committed once more when partitions are released.
+func WithCommitStrategy(strategy CommitStrategy) Option {
+	return func(o *options) error {
+		strategy.Mode = strings.ToLower(strings.TrimSpace(strategy.Mode))
+
+		switch strategy.Mode {
+		case "", CommitAuto:
+			strategy.Mode = CommitAuto
+		case CommitManual:
+		case CommitBatch:
+			if strategy.Every <= 0 {
+				return fmt.Errorf("commit mode %s needs a positive batch size, got %d", CommitBatch, strategy.Every)
+			}
+		case CommitInterval:
+			if strategy.Interval <= 0 {
+				return fmt.Errorf("commit mode %s needs a positive interval, got %s", CommitInterval, strategy.Interval)
+			}
+		default:
+			return fmt.Errorf("unknown commit mode %q (want %s, %s, %s or %s)",
+				strategy.Mode, CommitAuto, CommitManual, CommitBatch, CommitInterval)
+		}
+
+		o.config.Consumer.Offsets.AutoCommit.Enable = strategy.Mode == CommitAuto
+		o.commit = strategy
+		return nil
+	}
+}
+
+// committer commits the offsets of one group session according to a
+// CommitStrategy. Every claim marks its messages through its own
+// partitionOffsets, so batches are counted per partition and a partition
+// that is slow to process neither holds back nor hurries the commits of
+// the others.
+type committer struct {
+	strategy CommitStrategy
+	session  GroupSession
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// partitionOffsets tracks the offsets of one claimed partition.
+type partitionOffsets struct {
+	committer *committer
+	topic     string
+	partition int32
+
+	mu sync.Mutex
+	// next is the offset after the last marked message, -1 before the
+	// first one.
+	next      int64
+	pending   int
+	processed int
+	committed int64
+}
+
+func newCommitter(strategy CommitStrategy, session GroupSession) *committer {
+	c := &committer{strategy: strategy, session: session, partitions: make(map[partitionKey]*partitionOffsets)}
+	if strategy.Mode == CommitInterval {
+		c.stop = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.run()
+	}
+	return c
+}
+
+// claim returns the offset tracker of a claimed partition.
+func (c *committer) claim(topic string, partition int32) *partitionOffsets {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tp := partitionKey{topic, partition}
+	p, ok := c.partitions[tp]
+	if !ok {
+		p = &partitionOffsets{committer: c, topic: topic, partition: partition, next: -1, committed: -1}
+		c.partitions[tp] = p
+	}
+	return p
+}
+
+// mark marks message as processed and commits if the strategy says so.
+// Offsets only move forward: a message at or behind an offset already
+// marked is ignored, so the partition's commit position never goes back.
+func (p *partitionOffsets) mark(message *sarama.ConsumerMessage) {
+	p.mu.Lock()
+	if message.Offset < p.next {
+		p.mu.Unlock()
+		slog.Debug("Ignoring mark behind the partition's position", "topic", p.topic, "partition", p.partition,
+			"offset", message.Offset, "next", p.next)
+		return
+	}
+	p.committer.session.MarkMessage(message, "")
+	p.next = message.Offset + 1
+	p.pending++
+	p.processed++
+	strategy := p.committer.strategy
+	due := strategy.Mode == CommitManual ||
+		(strategy.Mode == CommitBatch && p.pending >= strategy.Every)
+	p.mu.Unlock()
+
+	if due {
+		p.committer.commit()
+	}
+}
+
+// release logs what the claim of p processed and committed.
+func (p *partitionOffsets) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	attrs := []any{"topic", p.topic, "partition", p.partition, "processed", p.processed, "next", p.next}
+	if p.committer.strategy.Mode != CommitAuto {
+		attrs = append(attrs, "committed", p.committed)
+	}
+	slog.Debug("Partition offsets released", attrs...)
+}
+
+// commit commits the offsets marked on every partition. Sarama commits a
+// whole session at once, so every partition's pending count starts over;
+// marks that race with the commit stay pending for the next one.
+func (c *committer) commit() {
+	if c.strategy.Mode == CommitAuto {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type snapshot struct {
+		pending int
+		next    int64
+	}
+	snapshots := make(map[*partitionOffsets]snapshot, len(c.partitions))
+	pending := 0
+	for _, p := range c.partitions {
+		p.mu.Lock()
+		snapshots[p] = snapshot{p.pending, p.next}
+		pending += p.pending
+		p.mu.Unlock()
+	}
+	if pending == 0 {
+		return
+	}
+
+	c.session.Commit()
+	for p, s := range snapshots {
+		p.mu.Lock()
+		p.pending -= s.pending
+		p.committed = s.next
+		p.mu.Unlock()
+	}
+	slog.Debug("Committed offsets", "mode", c.strategy.Mode, "messages", pending)
+}
+
+func (c *committer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.strategy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.commit()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// close stops the interval timer. The final commit is left to Cleanup.
+func (c *committer) close() {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+	}
+}
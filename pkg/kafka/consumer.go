@@ -0,0 +1,369 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Consumer reads one or more topics as part of a consumer group and logs
+// where each message came from.
+type Consumer struct {
+	decoder
+	processor
+	// client is the connection of consumer, closed with it.
+	client   Client
+	consumer ConsumerGroup
+	topics   []string
+	groupID  string
+
+	pattern      *regexp.Regexp
+	admin        Admin
+	topicRefresh time.Duration
+
+	startPosition *int64
+	seekMu        sync.Mutex
+	seeked        map[string]map[int32]bool
+
+	trackerMu sync.Mutex
+	trackers  map[string]*PartitionTracker
+
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+
+	shutdownTimeout time.Duration
+	processCtx      context.Context
+	concurrency     int
+	inFlight        int
+
+	commitStrategy CommitStrategy
+	committer      *committer
+	semantics      string
+	crashes        *crashSimulator
+	ordering       *OrderingVerifier
+	latency        *latencyWindow
+	timestamps     *timestampView
+	watermarks     *eventWatermarks
+	skew           *skewDetector
+	priority       *priorityScheduler
+	quiet          bool
+}
+
+// NewConsumer creates a consumer group member that starts from the oldest
+// offset and auto-commits every second, unless WithCommitStrategy says
+// otherwise.
+func NewConsumer(brokers []string, topic, groupID string, opts ...Option) (*Consumer, error) {
+	return NewMultiTopicConsumer(brokers, []string{topic}, groupID, opts...)
+}
+
+// NewMultiTopicConsumer is like NewConsumer but subscribes to several topics
+// at once. Use a TopicRouter to handle each topic differently.
+func NewMultiTopicConsumer(brokers []string, topics []string, groupID string, opts ...Option) (*Consumer, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("at least one topic is required")
+	}
+	return newConsumer(brokers, topics, groupID, opts)
+}
+
+func newConsumer(brokers []string, topics []string, groupID string, opts []Option) (*Consumer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	config.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+	if o.semantics == DeliveryAtMostOnce && o.commit.Mode != CommitAuto {
+		return nil, fmt.Errorf("invalid consumer config: %s commits every message itself, commit mode %s doesn't apply", DeliveryAtMostOnce, o.commit.Mode)
+	}
+	if o.crashAfter > 0 && o.concurrency > 1 {
+		return nil, fmt.Errorf("invalid consumer config: simulated crashes need a concurrency of 1")
+	}
+	// Values put back together from chunks are chunked again on their way
+	// to a retry topic or the DLQ, and the chunks have to stay together.
+	if o.large.strategy == LargeMessageChunk {
+		config.Producer.Partitioner = pinChunks(config.Producer.Partitioner)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	proc, err := newProcessor(o, client.NewSyncProducer)
+	if err != nil {
+		consumer.Close()
+		client.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	c := &Consumer{
+		decoder:           o.decoder(),
+		processor:         proc,
+		client:            client,
+		consumer:          consumer,
+		topics:            topics,
+		groupID:           groupID,
+		startPosition:     o.startPosition,
+		seeked:            make(map[string]map[int32]bool),
+		trackers:          make(map[string]*PartitionTracker),
+		topicRefresh:      o.topicRefresh,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        config.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+
+		shutdownTimeout: o.shutdownTimeout,
+		concurrency:     o.concurrency,
+		inFlight:        o.inFlight,
+		commitStrategy:  o.commit,
+		semantics:       o.semantics,
+		crashes:         newCrashSimulator(o.crashAfter),
+		ordering:        o.ordering,
+		latency:         newLatencyWindow(o.latencyReport),
+		timestamps:      newTimestampView(o),
+		watermarks:      newEventWatermarks(o),
+		priority:        newPriorityScheduler(o),
+		quiet:           o.quiet,
+	}
+	c.skew = newSkewDetector(o, c.PartitionTrackers)
+	return c, nil
+}
+
+// Consume joins the group and processes messages until ctx is cancelled.
+// When retry levels are configured the retry topics are consumed as well.
+// After cancellation, messages already being processed get up to the
+// shutdown timeout to finish and their offsets are committed before Consume
+// returns nil. The partition distribution of every topic, the reports of
+// WithOrderingVerifier, WithLatencyReport, WithTimestampView,
+// WithEventTimeWatermarks, WithSkewDetection and WithTopicPriorities and
+// the rebalance history are logged on the way out.
+func (c *Consumer) Consume(ctx context.Context) error {
+	processCtx, cancel := drainContext(ctx, c.shutdownTimeout)
+	defer cancel()
+	c.processCtx = processCtx
+	defer c.rebalances.LogSummary()
+	defer c.logSummary()
+	go c.latency.run(ctx)
+	go c.skew.run(ctx)
+
+	failures := 0
+	for {
+		if c.pattern != nil {
+			topics, err := c.awaitTopics(ctx)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			c.topics = topics
+		}
+
+		if err := c.consumeSession(ctx); err != nil {
+			// A failed session, e.g. with the coordinator unreachable, is
+			// retried with the backoff of WithBackoff.
+			failures++
+			if werr := c.backoff.wait(ctx, failures, "Consumer group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// consumeSession runs one group session over the current topics. A pattern
+// consumer ends the session early when the matching topics change, so the
+// next one subscribes to the new set.
+func (c *Consumer) consumeSession(ctx context.Context) error {
+	topics := append([]string(nil), c.topics...)
+	for _, topic := range c.topics {
+		topics = append(topics, c.retryTopics(topic)...)
+	}
+
+	if c.pattern == nil {
+		return c.consumer.Consume(ctx, topics, c)
+	}
+
+	sessionCtx, rejoin := context.WithCancel(ctx)
+	defer rejoin()
+	go c.watchTopics(sessionCtx, c.topics, rejoin)
+
+	return c.consumer.Consume(sessionCtx, topics, c)
+}
+
+func (c *Consumer) Setup(session GroupSession) error {
+	c.metrics.Rebalanced(c.groupID)
+	// Cleanup runs even when Setup fails, so the committer has to exist
+	// before anything can return an error.
+	c.committer = newCommitter(c.commitStrategy, session)
+	if err := c.seek(session); err != nil {
+		return err
+	}
+	rebalance := c.rebalances.Record(c.rebalanceEvent(RebalanceSetup), session)
+	kept, _, _ := rebalance.Movement()
+	slog.Info("Consumer setup completed", "topics", c.topics, "group", c.groupID, "strategy", c.rebalanceStrategy,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "claims", rebalance.Claims,
+		"kept", kept, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked,
+		"semantics", c.semantics, "commit_mode", c.commitStrategy.Mode)
+	return nil
+}
+
+func (c *Consumer) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: c.rebalanceStrategy, GroupID: c.groupID, InstanceID: c.instanceID}
+}
+
+// seek moves newly claimed partitions to the configured start position.
+// Each partition is only moved the first time it is claimed so later
+// rebalances resume from the committed offset instead of rewinding again.
+func (c *Consumer) seek(session GroupSession) error {
+	if c.startPosition == nil {
+		return nil
+	}
+
+	c.seekMu.Lock()
+	defer c.seekMu.Unlock()
+
+	for _, topic := range c.topics {
+		if c.seeked[topic] == nil {
+			c.seeked[topic] = make(map[int32]bool)
+		}
+
+		for _, partition := range session.Claims()[topic] {
+			if c.seeked[topic][partition] {
+				continue
+			}
+
+			offset, err := resolveOffset(c.client, topic, partition, *c.startPosition)
+			if err != nil {
+				return err
+			}
+
+			seekOffset(session, topic, partition, offset)
+			c.seeked[topic][partition] = true
+			slog.Info("Starting partition from offset", "topic", topic, "partition", partition, "offset", offset)
+		}
+	}
+	return nil
+}
+
+// Cleanup commits the offsets marked so far before the partitions are
+// released, rather than leaving them to the next auto-commit tick.
+func (c *Consumer) Cleanup(session GroupSession) error {
+	c.committer.close()
+	session.Commit()
+	rebalance := c.rebalances.Record(c.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Consumer cleanup completed", "topics", c.topics, "group", c.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+// ConsumeClaim runs the pipeline of one claimed partition. Sarama calls it
+// in a goroutine per claim, so partitions are processed independently.
+func (c *Consumer) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	pipeline := newClaimPipeline(c, session, claim)
+	defer pipeline.close()
+	return pipeline.run()
+}
+
+// tracker returns the partition tracker shared by every claim of topic.
+func (c *Consumer) tracker(topic string) *PartitionTracker {
+	c.trackerMu.Lock()
+	defer c.trackerMu.Unlock()
+
+	tracker, ok := c.trackers[topic]
+	if !ok {
+		tracker = NewPartitionTracker()
+		tracker.SetTopic(topic)
+		c.trackers[topic] = tracker
+	}
+	return tracker
+}
+
+// PartitionTrackers returns the tracker of every topic seen so far, sorted
+// by topic.
+func (c *Consumer) PartitionTrackers() []*PartitionTracker {
+	c.trackerMu.Lock()
+	defer c.trackerMu.Unlock()
+
+	topics := make([]string, 0, len(c.trackers))
+	for topic := range c.trackers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	trackers := make([]*PartitionTracker, 0, len(topics))
+	for _, topic := range topics {
+		trackers = append(trackers, c.trackers[topic])
+	}
+	return trackers
+}
+
+// logSummary logs the partition distribution and skew of each topic seen so
+// far and the ordering, latency and timestamp reports.
+func (c *Consumer) logSummary() {
+	c.trackerMu.Lock()
+	topics := make([]string, 0, len(c.trackers))
+	for topic := range c.trackers {
+		topics = append(topics, topic)
+	}
+	c.trackerMu.Unlock()
+
+	sort.Strings(topics)
+	for _, topic := range topics {
+		if tracker := c.tracker(topic); tracker.Len() > 0 {
+			tracker.LogSummary("came from")
+		}
+	}
+	c.skew.logSummary()
+	if c.ordering != nil {
+		c.ordering.LogSummary()
+	}
+	c.latency.log()
+	c.timestamps.log()
+	c.watermarks.log()
+	c.priority.log()
+}
+
+func (c *Consumer) Close() error {
+	if err := c.processor.close(); err != nil {
+		slog.Error("Failed to close message processor", "error", err)
+	}
+	if c.admin != nil {
+		c.admin.Close()
+	}
+	err := c.consumer.Close()
+	if cerr := c.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
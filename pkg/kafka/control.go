@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Pauser stops and restarts fetching from partitions while the consumer
+// stays in its group, so pausing never triggers a rebalance. Consumer and
+// Pipeline implement it.
+type Pauser interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+	PauseAll()
+	ResumeAll()
+}
+
+// Pause stops fetching from partitions until they are resumed. Messages
+// already fetched are still processed. A rebalance resumes every partition
+// the member is assigned afterwards.
+func (c *Consumer) Pause(partitions map[string][]int32) {
+	c.consumer.Pause(partitions)
+	slog.Info("Paused partitions", "group", c.groupID, "partitions", partitions)
+}
+
+// Resume restarts fetching from partitions paused with Pause or PauseAll.
+func (c *Consumer) Resume(partitions map[string][]int32) {
+	c.consumer.Resume(partitions)
+	slog.Info("Resumed partitions", "group", c.groupID, "partitions", partitions)
+}
+
+// PauseAll stops fetching from every claimed partition.
+func (c *Consumer) PauseAll() {
+	c.consumer.PauseAll()
+	slog.Info("Paused all partitions", "group", c.groupID)
+}
+
+// ResumeAll restarts fetching from every paused partition.
+func (c *Consumer) ResumeAll() {
+	c.consumer.ResumeAll()
+	slog.Info("Resumed all partitions", "group", c.groupID)
+}
+
+// Pause is Consumer.Pause for the pipeline's input partitions.
+func (p *Pipeline) Pause(partitions map[string][]int32) {
+	p.consumer.Pause(partitions)
+	slog.Info("Paused partitions", "group", p.groupID, "partitions", partitions)
+}
+
+// Resume is Consumer.Resume for the pipeline's input partitions.
+func (p *Pipeline) Resume(partitions map[string][]int32) {
+	p.consumer.Resume(partitions)
+	slog.Info("Resumed partitions", "group", p.groupID, "partitions", partitions)
+}
+
+// PauseAll is Consumer.PauseAll for the pipeline.
+func (p *Pipeline) PauseAll() {
+	p.consumer.PauseAll()
+	slog.Info("Paused all partitions", "group", p.groupID)
+}
+
+// ResumeAll is Consumer.ResumeAll for the pipeline.
+func (p *Pipeline) ResumeAll() {
+	p.consumer.ResumeAll()
+	slog.Info("Resumed all partitions", "group", p.groupID)
+}
+
+// NewControlHandler returns an HTTP handler that pauses and resumes target:
+//
+//	POST /pause                          pause every partition
+//	POST /pause?topic=t&partitions=0,2   pause partitions 0 and 2 of t
+//	POST /resume                         resume every partition
+//	POST /resume?topic=t&partitions=0,2  resume partitions 0 and 2 of t
+//
+// Each request answers with the action taken as JSON.
+func NewControlHandler(target Pauser) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		control(w, r, "pause", target.PauseAll, target.Pause)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		control(w, r, "resume", target.ResumeAll, target.Resume)
+	})
+	return mux
+}
+
+type controlResponse struct {
+	Action     string             `json:"action"`
+	All        bool               `json:"all,omitempty"`
+	Partitions map[string][]int32 `json:"partitions,omitempty"`
+}
+
+func control(w http.ResponseWriter, r *http.Request, action string, all func(), some func(map[string][]int32)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	partitions, err := controlPartitions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := controlResponse{Action: action}
+	if partitions == nil {
+		all()
+		response.All = true
+	} else {
+		some(partitions)
+		response.Partitions = partitions
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to write control response", "error", err)
+	}
+}
+
+// controlPartitions reads the topic and partitions query parameters. It
+// returns nil when neither is set, which means every partition.
+func controlPartitions(r *http.Request) (map[string][]int32, error) {
+	query := r.URL.Query()
+	topic := strings.TrimSpace(query.Get("topic"))
+	value := query.Get("partitions")
+	if topic == "" && value == "" {
+		return nil, nil
+	}
+	if topic == "" || value == "" {
+		return nil, fmt.Errorf("topic and partitions go together, e.g. ?topic=orders&partitions=0,2")
+	}
+
+	partitions, err := ParsePartitions(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("no partitions given")
+	}
+	return map[string][]int32{topic: partitions}, nil
+}
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// decoder picks the serializer for a consumed message from its content-type header.
+type decoder struct {
+	serializer    Serializer
+	deserializers map[string]Serializer
+	readerVersion int
+	encryption    *envelope
+	dictionary    *dictionaryCodec
+	large         largeMessages
+}
+
+func (o *options) decoder() decoder {
+	return decoder{
+		serializer:    o.serializer,
+		deserializers: o.deserializers,
+		readerVersion: o.readerVersion,
+		encryption:    o.encryption,
+		dictionary:    o.dictionary,
+		large:         o.large,
+	}
+}
+
+// DecodeEvent decodes a message with the serializer named by its
+// content-type header, or the default serializer if there is none. JSON
+// payloads are read in the layout named by the schema-version header and
+// upcast to the latest one. Claim checks are fetched, encrypted payloads
+// decrypted and compressed ones decompressed first, see WithLargeMessages,
+// WithEncryption and WithDictionaryCompression.
+func (d decoder) DecodeEvent(message *sarama.ConsumerMessage) (UserEvent, error) {
+	value, err := d.payload(message)
+	if err != nil {
+		return UserEvent{}, err
+	}
+
+	serializer := d.serializer
+	for _, header := range message.Headers {
+		if string(header.Key) != ContentTypeHeader {
+			continue
+		}
+		s, ok := d.deserializers[string(header.Value)]
+		if !ok {
+			return UserEvent{}, fmt.Errorf("no serializer registered for content type %s", header.Value)
+		}
+		serializer = s
+		break
+	}
+
+	if versioned, ok := serializer.(versionedSerializer); ok {
+		if d.readerVersion > 0 {
+			return versioned.DeserializeVersion(value, d.readerVersion)
+		}
+		if header, ok := headerValue(message, SchemaVersionHeader); ok {
+			version, err := parseSchemaVersion(header)
+			if err != nil {
+				return UserEvent{}, err
+			}
+			return versioned.DeserializeVersion(value, version)
+		}
+	}
+	return serializer.Deserialize(value)
+}
+
+// payload returns the value of message as it was produced, fetched from
+// the blob store if it is a claim check, decrypted if it was encrypted and
+// decompressed if it was compressed with a dictionary.
+func (d decoder) payload(message *sarama.ConsumerMessage) ([]byte, error) {
+	value, err := d.large.fetch(message)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = d.encryption.open(message, value); err != nil {
+		return nil, err
+	}
+	return d.dictionary.decompress(message, value)
+}
+
+// resolved returns message as handlers see it, with the value payload
+// returns.
+func (d decoder) resolved(message *sarama.ConsumerMessage) (*Message, error) {
+	msg := newMessage(message)
+	value, err := d.payload(message)
+	if err != nil {
+		return msg, err
+	}
+	msg.Value = value
+	return msg, nil
+}
+
+// jsonValue returns the value of message as JSON: the decoded event, or the
+// raw value if it isn't one, as is if it is JSON and otherwise as a string.
+// A tombstone is null.
+func (d decoder) jsonValue(message *sarama.ConsumerMessage) json.RawMessage {
+	if message.Value == nil {
+		return json.RawMessage("null")
+	}
+	if event, err := d.DecodeEvent(message); err == nil {
+		if value, err := json.Marshal(event); err == nil {
+			return value
+		}
+	}
+	if json.Valid(message.Value) {
+		return json.RawMessage(message.Value)
+	}
+	value, _ := json.Marshal(string(message.Value))
+	return value
+}
+
+// describeValue decodes the value so binary formats are readable in the
+// logs, falling back to the payload, or the raw bytes if that fails too.
+func (d decoder) describeValue(message *sarama.ConsumerMessage) string {
+	event, err := d.DecodeEvent(message)
+	if err == nil {
+		if decoded, err := json.Marshal(event); err == nil {
+			return string(decoded)
+		}
+	}
+	if value, err := d.payload(message); err == nil {
+		return string(value)
+	}
+	return string(message.Value)
+}
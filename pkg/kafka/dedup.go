@@ -0,0 +1,246 @@
+package kafka
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	bolt "go.etcd.io/bbolt"
+)
+
+// MessageIDHeader carries an ID unique to each event. EventHeaders sets it
+// once per event, so a message sent twice, e.g. by a producer retry, carries
+// the same ID both times.
+const MessageIDHeader = "message-id"
+
+// Dedup store kinds.
+const (
+	DedupMemory = "memory"
+	DedupBolt   = "bolt"
+)
+
+// DedupStore remembers the IDs of processed messages for a while. It must be
+// safe for concurrent use.
+type DedupStore interface {
+	// Seen reports whether id was added and hasn't expired yet.
+	Seen(id string) (bool, error)
+	// Add remembers id.
+	Add(id string) error
+	Close() error
+}
+
+// WithDedup makes a consumer skip messages whose MessageIDHeader is already
+// in store, and add the ID of every message its handler processed. Together
+// with at-least-once delivery this processes each message effectively once,
+// as long as its ID is remembered: redeliveries after a crash or a
+// rebalance, and duplicates written by producer retries, are acknowledged
+// without running the handler again. Messages without the header are always
+// processed.
+func WithDedup(store DedupStore) Option {
+	return func(o *options) error {
+		o.dedup = store
+		return nil
+	}
+}
+
+// messageID returns the MessageIDHeader of message, or "" if it has none.
+func messageID(message *sarama.ConsumerMessage) string {
+	for _, h := range message.Headers {
+		if string(h.Key) == MessageIDHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// MemoryDedupStore keeps the most recently added IDs in memory, evicting the
+// least recently used ones beyond its size. Its contents are lost on
+// restart.
+type MemoryDedupStore struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+type dedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryDedupStore remembers up to size IDs for ttl each.
+func NewMemoryDedupStore(size int, ttl time.Duration) (*MemoryDedupStore, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("dedup store size must be at least 1, got %d", size)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("dedup TTL must be positive, got %s", ttl)
+	}
+	return &MemoryDedupStore{size: size, ttl: ttl, order: list.New(), ids: make(map[string]*list.Element)}, nil
+}
+
+func (s *MemoryDedupStore) Seen(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.ids[id]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(element.Value.(*dedupEntry).expires) {
+		s.order.Remove(element)
+		delete(s.ids, id)
+		return false, nil
+	}
+	s.order.MoveToFront(element)
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Add(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if element, ok := s.ids[id]; ok {
+		element.Value.(*dedupEntry).expires = expires
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.ids[id] = s.order.PushFront(&dedupEntry{id: id, expires: expires})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.ids, oldest.Value.(*dedupEntry).id)
+	}
+	return nil
+}
+
+// Len returns the number of IDs held, including expired ones not evicted
+// yet.
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) Close() error {
+	return nil
+}
+
+var dedupBucket = []byte("message-ids")
+
+// BoltDedupStore keeps IDs in a BoltDB file, so they survive restarts. Only
+// one process can open the file at a time. Expired IDs are deleted in the
+// background.
+type BoltDedupStore struct {
+	db   *bolt.DB
+	ttl  time.Duration
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBoltDedupStore opens or creates the BoltDB file at path and remembers
+// IDs for ttl each.
+func NewBoltDedupStore(path string, ttl time.Duration) (*BoltDedupStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("dedup TTL must be positive, got %s", ttl)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dedupBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open dedup store %s: %w", path, err)
+	}
+
+	s := &BoltDedupStore{db: db, ttl: ttl, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.expire()
+	return s, nil
+}
+
+func (s *BoltDedupStore) Seen(id string) (bool, error) {
+	var seen bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(dedupBucket).Get([]byte(id))
+		seen = len(value) == 8 && time.Now().UnixNano() < int64(binary.BigEndian.Uint64(value))
+		return nil
+	})
+	return seen, err
+}
+
+func (s *BoltDedupStore) Add(id string) error {
+	var expires [8]byte
+	binary.BigEndian.PutUint64(expires[:], uint64(time.Now().Add(s.ttl).UnixNano()))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dedupBucket).Put([]byte(id), expires[:])
+	})
+}
+
+// expire deletes expired IDs every tenth of the TTL, but at least once a
+// minute and at most once a second.
+func (s *BoltDedupStore) expire() {
+	defer close(s.done)
+
+	interval := s.ttl / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteExpired(); err != nil {
+				slog.Warn("Failed to delete expired message IDs", "error", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *BoltDedupStore) deleteExpired() error {
+	now := time.Now().UnixNano()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dedupBucket)
+		// Deleting through the cursor would skip the key after each
+		// deleted one, so collect them first.
+		var expired [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			if len(value) != 8 || int64(binary.BigEndian.Uint64(value)) <= now {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltDedupStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
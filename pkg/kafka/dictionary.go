@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Headers of values compressed with WithDictionaryCompression. The value is
+// a zstd frame compressed with the dictionary named in
+// CompressionDictIDHeader.
+const (
+	CompressionHeader       = "compression"
+	CompressionDictIDHeader = "compression-dict-id"
+)
+
+// CompressionZstdDict is the CompressionHeader of values compressed with
+// WithDictionaryCompression.
+const CompressionZstdDict = "zstd-dict"
+
+// ErrNoDictionary is returned when decoding a dictionary-compressed message
+// without WithDictionaryCompression.
+var ErrNoDictionary = errors.New("message is compressed with a zstd dictionary but no dictionaries are configured")
+
+// DefaultDictionarySize is the default size limit of TrainDictionary, plenty
+// for events of a few hundred bytes.
+const DefaultDictionarySize = 16 << 10
+
+// minDictionarySamples is the fewest samples TrainDictionary accepts; with
+// fewer the dictionary only learns those few values.
+const minDictionarySamples = 10
+
+// TrainDictionary builds a zstd dictionary of at most maxSize bytes, 0
+// meaning DefaultDictionarySize, from sample values. The samples should
+// look like the values it will compress, e.g. serialized events.
+func TrainDictionary(samples [][]byte, maxSize int) ([]byte, error) {
+	if len(samples) < minDictionarySamples {
+		return nil, fmt.Errorf("training a dictionary needs at least %d samples, got %d", minDictionarySamples, len(samples))
+	}
+	if maxSize == 0 {
+		maxSize = DefaultDictionarySize
+	}
+	if maxSize < 256 {
+		return nil, fmt.Errorf("dictionary size must be at least 256 bytes, got %d", maxSize)
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+}
+
+// DictionaryID returns the ID a zstd dictionary is known by in
+// CompressionDictIDHeader.
+func DictionaryID(dictionary []byte) (uint32, error) {
+	d, err := zstd.InspectDictionary(dictionary)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	return d.ID(), nil
+}
+
+// WithDictionaryCompression makes producers compress message values with
+// zstd and the first of dictionaries, and consumers decompress them with
+// whichever dictionary a message names before they reach the handler or
+// DecodeEvent. A small event has too little repetition for a per-record
+// codec to find, while the dictionary brings what events have in common,
+// such as field names. Values that don't get smaller are sent as they
+// are, without CompressionHeader. Compression happens before encryption,
+// since encrypted values don't compress. Rotating the dictionary means
+// putting the new one first and keeping the old one until no message
+// compressed with it is left to read.
+func WithDictionaryCompression(dictionaries ...[]byte) Option {
+	return func(o *options) error {
+		if len(dictionaries) == 0 {
+			return fmt.Errorf("dictionary compression needs a dictionary")
+		}
+		id, err := DictionaryID(dictionaries[0])
+		if err != nil {
+			return err
+		}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dictionaries[0]), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("invalid zstd dictionary: %w", err)
+		}
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dictionaries...), zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			return fmt.Errorf("invalid zstd dictionary: %w", err)
+		}
+		o.dictionary = &dictionaryCodec{id: strconv.FormatUint(uint64(id), 10), encoder: encoder, decoder: decoder}
+		return nil
+	}
+}
+
+// dictionaryCodec compresses and decompresses message values for one
+// producer or consumer. The zstd encoder and decoder are safe for
+// concurrent use.
+type dictionaryCodec struct {
+	id      string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// compress compresses the value of msg and adds the compression headers,
+// unless that doesn't make it smaller.
+func (c *dictionaryCodec) compress(msg *sarama.ProducerMessage) error {
+	if c == nil || msg.Value == nil {
+		return nil
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	compressed := c.encoder.EncodeAll(value, nil)
+	if len(compressed) >= len(value) {
+		return nil
+	}
+	msg.Value = sarama.ByteEncoder(compressed)
+	msg.Headers = append(msg.Headers,
+		stringHeader(CompressionHeader, CompressionZstdDict),
+		stringHeader(CompressionDictIDHeader, c.id),
+	)
+	return nil
+}
+
+// decompress returns the uncompressed value, the value of message, which is
+// value as it is unless message carries CompressionHeader.
+func (c *dictionaryCodec) decompress(message *sarama.ConsumerMessage, value []byte) ([]byte, error) {
+	algorithm, ok := headerValue(message, CompressionHeader)
+	if !ok {
+		return value, nil
+	}
+	if algorithm != CompressionZstdDict {
+		return nil, fmt.Errorf("unsupported compression %s", algorithm)
+	}
+	if c == nil {
+		return nil, ErrNoDictionary
+	}
+	decompressed, err := c.decoder.DecodeAll(value, nil)
+	if err != nil {
+		id, _ := headerValue(message, CompressionDictIDHeader)
+		return nil, fmt.Errorf("failed to decompress message with dictionary %s: %w", id, err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,284 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	DLQErrorHeader             = "dlq-error"
+	DLQOriginalTopicHeader     = "dlq-original-topic"
+	DLQOriginalPartitionHeader = "dlq-original-partition"
+	DLQOriginalOffsetHeader    = "dlq-original-offset"
+	DLQRetryCountHeader        = "dlq-retry-count"
+	DLQFailedAtHeader          = "dlq-failed-at"
+)
+
+const retryBackoff = 100 * time.Millisecond
+
+// processor runs the message handler with retries, routes messages that keep
+// failing through the retry topics and finally dead-letters them.
+type processor struct {
+	decoder
+	handler     MessageHandler
+	maxRetries  int
+	retryLevels []RetryLevel
+	dlqTopic    string
+	lateTopic   string
+	producer    SyncProducer
+	large       largeMessages
+	metrics     Metrics
+	dedup       DedupStore
+	filter      MessageFilter
+	poison      *poisonTracker
+}
+
+func newProcessor(o *options, newProducer func() (SyncProducer, error)) (processor, error) {
+	p := processor{
+		decoder:     o.decoder(),
+		handler:     o.handler,
+		maxRetries:  o.maxRetries,
+		retryLevels: o.retryLevels,
+		dlqTopic:    o.dlqTopic,
+		lateTopic:   o.lateTopic,
+		large:       o.large,
+		metrics:     o.metrics,
+		dedup:       o.dedup,
+		filter:      o.filter,
+		poison:      newPoisonTracker(o.poisonAttempts, o.poisonRecorder),
+	}
+
+	if p.dlqTopic != "" || p.lateTopic != "" || len(p.retryLevels) > 0 {
+		producer, err := newProducer()
+		if err != nil {
+			return processor{}, fmt.Errorf("failed to create retry producer: %w", err)
+		}
+		p.producer = producer
+	}
+
+	return p, nil
+}
+
+// process handles message, retrying up to maxRetries times. A message that
+// still fails moves to the next retry topic, or to the DLQ once every retry
+// level has been used. The returned error is only non-nil if the message
+// could not be forwarded either. With a dedup store, messages whose ID was
+// handled before are skipped, and with poison-pill detection messages that
+// failed too often. A message that can't be decrypted, or whose claim check
+// can't be fetched, fails like a handler error, so it ends up in the retry
+// topics or the DLQ as it was consumed.
+func (p processor) process(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if p.handler == nil {
+		return nil
+	}
+
+	id, skip := p.duplicateCheck(message)
+	if skip {
+		return nil
+	}
+
+	if total, last, poison := p.poison.exhausted(message); poison {
+		p.skipPoisonPill(message, total, last)
+		return nil
+	}
+
+	msg, openErr := p.resolved(message)
+
+	var err error
+	attempts := 0
+	for attempts <= p.maxRetries {
+		attempts++
+		if err = openErr; err == nil {
+			err = p.handle(ctx, msg)
+		}
+		if err == nil {
+			p.poison.forget(message)
+			p.remember(message, id)
+			return nil
+		}
+		p.metrics.ProcessingFailed(message.Topic)
+		if total, poison := p.poison.failed(message, err); poison {
+			p.skipPoisonPill(message, total, err)
+			return nil
+		}
+
+		if attempts <= p.maxRetries {
+			slog.Warn("Processing failed", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "attempt", attempts, "max_attempts", p.maxRetries+1, "error", err)
+
+			select {
+			case <-time.After(retryBackoff * time.Duration(attempts)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	if level, ok := p.nextRetryLevel(message); ok {
+		retryTopic, retryErr := p.sendToRetry(message, err, level)
+		if retryErr != nil {
+			return retryErr
+		}
+		p.poison.forget(message)
+		slog.Warn("Message routed to retry topic", "retry_topic", retryTopic, "topic", message.Topic,
+			"partition", message.Partition, "offset", message.Offset, "error", err)
+		return nil
+	}
+
+	if p.dlqTopic == "" {
+		p.poison.forget(message)
+		slog.Error("Giving up on message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "attempts", attempts, "error", err)
+		return nil
+	}
+
+	if dlqErr := p.deadLetter(message, err, attempts); dlqErr != nil {
+		return dlqErr
+	}
+	p.poison.forget(message)
+
+	slog.Error("Message dead-lettered", "dlq_topic", p.dlqTopic, "topic", message.Topic,
+		"partition", message.Partition, "offset", message.Offset, "attempts", attempts, "error", err)
+	return nil
+}
+
+// handle runs the handler on msg. With poison-pill detection, a panic in the
+// handler is returned as an error, so it counts as a failed attempt instead
+// of crashing the consumer.
+func (p processor) handle(ctx context.Context, msg *Message) (err error) {
+	if p.poison != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("Handler panicked", "topic", msg.Topic, "partition", msg.Partition,
+					"offset", msg.Offset, "panic", r, "stack", string(debug.Stack()))
+				err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			}
+		}()
+	}
+	return p.handler.Handle(ctx, msg)
+}
+
+// skipPoisonPill acknowledges message without handling it again.
+func (p processor) skipPoisonPill(message *sarama.ConsumerMessage, attempts int, cause error) {
+	p.metrics.PoisonPillSkipped(message.Topic)
+	p.poison.skip(message, attempts, cause)
+}
+
+// duplicateCheck returns the ID to remember message by, "" without a dedup
+// store or an ID, and whether to skip message because it was handled before.
+// If the store fails, the message is processed rather than risk losing it.
+func (p processor) duplicateCheck(message *sarama.ConsumerMessage) (id string, skip bool) {
+	if p.dedup == nil {
+		return "", false
+	}
+	id = messageID(message)
+	if id == "" {
+		return "", false
+	}
+
+	seen, err := p.dedup.Seen(id)
+	if err != nil {
+		slog.Warn("Dedup lookup failed, processing anyway", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "message_id", id, "error", err)
+		return id, false
+	}
+	if !seen {
+		return id, false
+	}
+
+	p.metrics.DuplicateSkipped(message.Topic)
+	slog.Info("Duplicate skipped", "topic", message.Topic, "partition", message.Partition,
+		"offset", message.Offset, "message_id", id)
+	return id, true
+}
+
+// remember adds id to the dedup store once its message has been handled.
+// Messages routed to a retry topic or the DLQ are not remembered, since
+// their copies there carry the same ID and still have to be handled.
+func (p processor) remember(message *sarama.ConsumerMessage, id string) {
+	if id == "" {
+		return
+	}
+	if err := p.dedup.Add(id); err != nil {
+		slog.Warn("Failed to remember message ID", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "message_id", id, "error", err)
+	}
+}
+
+func (p processor) deadLetter(message *sarama.ConsumerMessage, cause error, attempts int) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+6)
+	for _, h := range message.Headers {
+		if string(h.Key) == RetryDueAtHeader {
+			continue
+		}
+		headers = append(headers, *h)
+	}
+	headers = append(headers,
+		stringHeader(DLQErrorHeader, cause.Error()),
+		stringHeader(DLQOriginalTopicHeader, message.Topic),
+		stringHeader(DLQOriginalPartitionHeader, strconv.Itoa(int(message.Partition))),
+		stringHeader(DLQOriginalOffsetHeader, strconv.FormatInt(message.Offset, 10)),
+		stringHeader(DLQRetryCountHeader, strconv.Itoa(attempts-1)),
+		stringHeader(DLQFailedAtHeader, time.Now().UTC().Format(time.RFC3339)),
+	)
+
+	err := p.send(&sarama.ProducerMessage{
+		Topic:   p.dlqTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter message from partition %d offset %d: %w",
+			message.Partition, message.Offset, err)
+	}
+	return nil
+}
+
+// send produces msg to a retry topic, the DLQ or the late topic. A value
+// put back together from chunks is over the limit of WithLargeMessages, so
+// it is split or claim-checked again like a producer would, rather than
+// sent as one record the broker rejects.
+func (p processor) send(msg *sarama.ProducerMessage) error {
+	prepared, err := p.large.prepare(msg)
+	if err != nil {
+		return err
+	}
+	partition, _, err := p.producer.SendMessage(prepared[0])
+	if err != nil {
+		return err
+	}
+	for _, chunk := range prepared[1:] {
+		chunk.Partition = partition
+		chunk.Metadata = pinnedChunk{}
+		if _, _, err := p.producer.SendMessage(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p processor) close() error {
+	var err error
+	if closer, ok := p.handler.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if p.producer != nil {
+		if perr := p.producer.Close(); perr != nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func stringHeader(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
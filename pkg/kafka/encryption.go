@@ -0,0 +1,337 @@
+package kafka
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers of messages encrypted with WithEncryption. The value of such a
+// message is an AES-GCM nonce followed by the ciphertext, sealed with a data
+// key that travels along in EncryptionDataKeyHeader, wrapped by the master
+// key named in EncryptionKeyIDHeader.
+const (
+	EncryptionHeader        = "encryption"
+	EncryptionKeyIDHeader   = "encryption-key-id"
+	EncryptionDataKeyHeader = "encryption-data-key"
+)
+
+// EncryptionAESGCM is the EncryptionHeader of messages encrypted with
+// WithEncryption.
+const EncryptionAESGCM = "aes-256-gcm"
+
+// Defaults of WithDataKeyRotation. Random 96-bit GCM nonces only stay
+// unique with overwhelming probability for a limited number of messages per
+// key, and a data key that leaks exposes only what it sealed.
+const (
+	DefaultDataKeyMessages = 1 << 20
+	DefaultDataKeyLifetime = time.Hour
+)
+
+// ErrNoEncryptionKeys is returned when decoding an encrypted message without
+// WithEncryption.
+var ErrNoEncryptionKeys = errors.New("message is encrypted but no encryption keys are configured")
+
+// KeyWrapper wraps and unwraps the data keys message values are encrypted
+// with, so the master keys never travel with the messages. LocalKeys keeps
+// the master keys in memory; a KMS client can implement it to keep them out
+// of the process altogether.
+type KeyWrapper interface {
+	// WrapKey encrypts dataKey with the current master key and returns the
+	// ID of that key along with the result.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped by the master key keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// WithEncryption makes producers encrypt message values with AES-256-GCM
+// and consumers decrypt them before they reach the handler or DecodeEvent.
+// Each producer seals its messages with a random data key wrapped by keys,
+// and replaces it as WithDataKeyRotation says; consumers unwrap every data
+// key once and keep it. Keys and headers stay
+// readable, as do tombstones, so brokers can still route and compact.
+// Messages without EncryptionHeader are passed on as they are. The record
+// key is authenticated along with the value, so an encrypted value can't be
+// moved to another key. Retry topics and the DLQ get the encrypted value.
+func WithEncryption(keys KeyWrapper) Option {
+	return func(o *options) error {
+		if keys == nil {
+			return fmt.Errorf("encryption needs a key wrapper")
+		}
+		o.encryption = &envelope{keys: keys, opened: make(map[string]cipher.AEAD)}
+		return nil
+	}
+}
+
+// WithDataKeyRotation makes producers with WithEncryption switch to a new
+// data key once the current one has sealed messages messages or is older
+// than lifetime, whichever comes first. 0 means DefaultDataKeyMessages or
+// DefaultDataKeyLifetime. Every message carries its wrapped data key, so
+// consumers follow the rotation without being told.
+func WithDataKeyRotation(messages int, lifetime time.Duration) Option {
+	return func(o *options) error {
+		if messages < 0 {
+			return fmt.Errorf("data key message limit must not be negative, got %d", messages)
+		}
+		if lifetime < 0 {
+			return fmt.Errorf("data key lifetime must not be negative, got %s", lifetime)
+		}
+		if messages == 0 {
+			messages = DefaultDataKeyMessages
+		}
+		if lifetime == 0 {
+			lifetime = DefaultDataKeyLifetime
+		}
+		o.dataKeys = dataKeyRotation{messages: int64(messages), lifetime: lifetime}
+		return nil
+	}
+}
+
+// dataKeyRotation is when an envelope replaces its data key.
+type dataKeyRotation struct {
+	messages int64
+	lifetime time.Duration
+}
+
+// LocalKeys wraps data keys with AES-256 master keys held in memory. The
+// first key wraps new data keys and the others only unwrap, so rotating a
+// key means putting the new one first and keeping the old one until no
+// message sealed under it is left to read.
+type LocalKeys struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseLocalKeys parses a comma-separated list of id=key pairs, where key is
+// 32 random bytes in standard base64, e.g. the output of
+// "openssl rand -base64 32".
+func ParseLocalKeys(s string) (*LocalKeys, error) {
+	l := &LocalKeys{keys: make(map[string]cipher.AEAD)}
+	for _, pair := range strings.Split(s, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid encryption key %q, expected id=base64-key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+		if err := l.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Add adds a 32-byte master key. The first key added wraps new data keys.
+func (l *LocalKeys) Add(id string, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("encryption key %s is %d bytes, AES-256 needs 32", id, len(key))
+	}
+	if _, ok := l.keys[id]; ok {
+		return fmt.Errorf("duplicate encryption key %s", id)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if l.keys == nil {
+		l.keys = make(map[string]cipher.AEAD)
+	}
+	l.keys[id] = aead
+	if l.current == "" {
+		l.current = id
+	}
+	return nil
+}
+
+// CurrentKeyID returns the ID of the key new data keys are wrapped with.
+func (l *LocalKeys) CurrentKeyID() string {
+	return l.current
+}
+
+func (l *LocalKeys) WrapKey(dataKey []byte) (string, []byte, error) {
+	aead, ok := l.keys[l.current]
+	if !ok {
+		return "", nil, fmt.Errorf("no encryption keys")
+	}
+	wrapped, err := gcmSeal(aead, dataKey, []byte(l.current))
+	return l.current, wrapped, err
+}
+
+func (l *LocalKeys) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := l.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", keyID)
+	}
+	return gcmOpen(aead, wrapped, []byte(keyID))
+}
+
+// maxOpenedKeys bounds the data keys a consumer keeps unwrapped. Every
+// producer instance brings its own, so a long-running consumer would
+// otherwise collect them forever.
+const maxOpenedKeys = 1024
+
+// envelope seals and opens message values for one producer or consumer.
+type envelope struct {
+	keys     KeyWrapper
+	rotation dataKeyRotation
+
+	mu      sync.Mutex
+	sealer  cipher.AEAD
+	keyID   string
+	wrapped string
+	// sealed counts the messages sealed with sealer, which was created at
+	// created.
+	sealed  int64
+	created time.Time
+	opened  map[string]cipher.AEAD
+}
+
+// seal encrypts the value of msg and adds the encryption headers.
+func (e *envelope) seal(msg *sarama.ProducerMessage) error {
+	if e == nil || msg.Value == nil {
+		return nil
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	var key []byte
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return fmt.Errorf("failed to encode key: %w", err)
+		}
+	}
+
+	aead, keyID, wrapped, err := e.dataKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := gcmSeal(aead, value, key)
+	if err != nil {
+		return err
+	}
+	msg.Value = sarama.ByteEncoder(sealed)
+	msg.Headers = append(msg.Headers,
+		stringHeader(EncryptionHeader, EncryptionAESGCM),
+		stringHeader(EncryptionKeyIDHeader, keyID),
+		stringHeader(EncryptionDataKeyHeader, wrapped),
+	)
+	return nil
+}
+
+// dataKey returns the data key to seal the next message with, creating and
+// wrapping a new one on first use and once the current one is due for
+// rotation.
+func (e *envelope) dataKey() (cipher.AEAD, string, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sealer != nil && e.sealed < e.rotation.messages && time.Since(e.created) < e.rotation.lifetime {
+		e.sealed++
+		return e.sealer, e.keyID, e.wrapped, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	keyID, wrapped, err := e.keys.WrapKey(dataKey)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, "", "", err
+	}
+	e.sealer, e.keyID, e.wrapped = aead, keyID, base64.StdEncoding.EncodeToString(wrapped)
+	e.sealed, e.created = 1, time.Now()
+	return e.sealer, e.keyID, e.wrapped, nil
+}
+
+// open returns the plaintext of value, the value of message, which is value
+// as it is unless message carries EncryptionHeader.
+func (e *envelope) open(message *sarama.ConsumerMessage, value []byte) ([]byte, error) {
+	headers := MessageHeaders(message)
+	algorithm, ok := headers[EncryptionHeader]
+	if !ok {
+		return value, nil
+	}
+	if algorithm != EncryptionAESGCM {
+		return nil, fmt.Errorf("unsupported encryption %s", algorithm)
+	}
+	if e == nil {
+		return nil, ErrNoEncryptionKeys
+	}
+
+	aead, err := e.openKey(headers[EncryptionKeyIDHeader], headers[EncryptionDataKeyHeader])
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcmOpen(aead, value, message.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return plaintext, nil
+}
+
+// openKey unwraps the data key of a message, or returns it from the cache.
+func (e *envelope) openKey(keyID, wrapped string) (cipher.AEAD, error) {
+	id := keyID + "/" + wrapped
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, ok := e.opened[id]; ok {
+		return aead, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", EncryptionDataKeyHeader, err)
+	}
+	dataKey, err := e.keys.UnwrapKey(keyID, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.opened) >= maxOpenedKeys {
+		clear(e.opened)
+	}
+	e.opened[id] = aead
+	return aead, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AES key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmSeal encrypts plaintext under a random nonce, which it is prefixed with.
+func gcmSeal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func gcmOpen(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
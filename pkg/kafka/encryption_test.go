@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func testKeys(t *testing.T) *LocalKeys {
+	t.Helper()
+	keys := &LocalKeys{}
+	if err := keys.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	return keys
+}
+
+// sealValue seals value with e and returns it as a consumer would read it.
+func sealValue(t *testing.T, e *envelope, value string) *sarama.ConsumerMessage {
+	t.Helper()
+	msg := &sarama.ProducerMessage{Key: sarama.StringEncoder("user-1"), Value: sarama.StringEncoder(value)}
+	if err := e.seal(msg); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	sealed, _ := msg.Value.Encode()
+	message := &sarama.ConsumerMessage{Key: []byte("user-1"), Value: sealed}
+	for i := range msg.Headers {
+		message.Headers = append(message.Headers, &msg.Headers[i])
+	}
+	return message
+}
+
+func TestDataKeyRotation(t *testing.T) {
+	keys := testKeys(t)
+	producer := &envelope{keys: keys, rotation: dataKeyRotation{messages: 2, lifetime: time.Hour}}
+	consumer := &envelope{keys: keys, opened: make(map[string]cipher.AEAD)}
+
+	var dataKeys []string
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		message := sealValue(t, producer, value)
+		dataKeys = append(dataKeys, MessageHeaders(message)[EncryptionDataKeyHeader])
+		plaintext, err := consumer.open(message, message.Value)
+		if err != nil {
+			t.Fatalf("open %s: %v", value, err)
+		}
+		if string(plaintext) != value {
+			t.Errorf("opened %q, want %q", plaintext, value)
+		}
+	}
+
+	// Two messages per data key.
+	if dataKeys[0] != dataKeys[1] || dataKeys[1] == dataKeys[2] || dataKeys[2] != dataKeys[3] || dataKeys[3] == dataKeys[4] {
+		t.Errorf("data keys of the messages don't change every second message")
+	}
+	if len(consumer.opened) != 3 {
+		t.Errorf("consumer unwrapped %d data keys, want 3", len(consumer.opened))
+	}
+
+	// A key past its lifetime is replaced as well.
+	producer.rotation.messages = 100
+	producer.created = time.Now().Add(-2 * time.Hour)
+	if message := sealValue(t, producer, "f"); MessageHeaders(message)[EncryptionDataKeyHeader] == dataKeys[4] {
+		t.Error("data key past its lifetime was used again")
+	}
+}
+
+func TestWithDataKeyRotation(t *testing.T) {
+	o, err := newOptions(sarama.NewConfig(), []Option{WithDataKeyRotation(0, time.Minute), WithEncryption(testKeys(t))})
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if want := (dataKeyRotation{messages: DefaultDataKeyMessages, lifetime: time.Minute}); o.encryption.rotation != want {
+		t.Errorf("rotation = %+v, want %+v", o.encryption.rotation, want)
+	}
+	if _, err := newOptions(sarama.NewConfig(), []Option{WithDataKeyRotation(-1, 0)}); err == nil {
+		t.Error("negative message limit accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+)
+
+// UserEvent represents a user activity event
+type UserEvent struct {
+	UserID    string                 `json:"user_id"`
+	EventType string                 `json:"event_type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// GenerateUserEvents builds count events cycling through a fixed set of users
+// so the same keys show up repeatedly.
+func GenerateUserEvents(count int) []UserEvent {
+	users := []string{"user-123", "user-456", "user-789"}
+	eventTypes := []string{"page_view", "purchase", "login", "logout", "search", "add_to_cart"}
+
+	var events []UserEvent
+
+	for i := 0; i < count; i++ {
+		userID := users[i%len(users)]
+		eventType := eventTypes[i%len(eventTypes)]
+
+		event := UserEvent{
+			UserID:    userID,
+			EventType: eventType,
+			Timestamp: time.Now().Add(time.Duration(i) * time.Second),
+			Data: map[string]interface{}{
+				"session_id": fmt.Sprintf("session-%d", i),
+				"ip_address": fmt.Sprintf("192.168.1.%d", (i%254)+1),
+				"user_agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)",
+			},
+		}
+
+		if eventType == "purchase" {
+			event.Data["amount"] = fmt.Sprintf("%.2f", float64((i%1000)+1)/100)
+			event.Data["product_id"] = fmt.Sprintf("prod-%d", (i%100)+1)
+		} else if eventType == "search" {
+			event.Data["query"] = fmt.Sprintf("search term %d", i+1)
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers added to late events routed to the late topic of
+// WithEventTimeWatermarks.
+const (
+	LateEventTimeHeader         = "late-event-time"
+	LateWatermarkHeader         = "late-watermark"
+	LateOriginalTopicHeader     = "late-original-topic"
+	LateOriginalPartitionHeader = "late-original-partition"
+	LateOriginalOffsetHeader    = "late-original-offset"
+)
+
+// WithEventTimeWatermarks makes consumers track an event-time watermark per
+// partition: the newest event time seen minus lateness. Event time is the
+// timestamp in the payload, or the record timestamp for events without one.
+// An event behind the watermark is late; with a lateTopic it is produced
+// there as it was consumed, with headers saying how late it was, instead of
+// being handled, and without one it is only logged and counted. The lag of
+// each watermark behind the wall clock is reported through WithMetrics.
+func WithEventTimeWatermarks(lateness time.Duration, lateTopic string) Option {
+	return func(o *options) error {
+		if lateness < 0 {
+			return fmt.Errorf("allowed lateness must not be negative, got %s", lateness)
+		}
+		o.eventWatermarks = true
+		o.eventLateness = lateness
+		o.lateTopic = lateTopic
+		return nil
+	}
+}
+
+// eventWatermarks is the consumer side of WithEventTimeWatermarks. A nil
+// tracker does nothing.
+type eventWatermarks struct {
+	decoder  decoder
+	lateness time.Duration
+	metrics  Metrics
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*eventWatermark
+}
+
+type eventWatermark struct {
+	watermark time.Time
+	events    int
+	late      int
+	// maxBehind is how far the latest late event was behind the watermark.
+	maxBehind time.Duration
+}
+
+func newEventWatermarks(o *options) *eventWatermarks {
+	if !o.eventWatermarks {
+		return nil
+	}
+	return &eventWatermarks{decoder: o.decoder(), lateness: o.eventLateness, metrics: o.metrics,
+		partitions: make(map[partitionKey]*eventWatermark)}
+}
+
+// observe advances the watermark of message's partition and reports whether
+// message is late, with its event time and the watermark it is behind.
+func (w *eventWatermarks) observe(message *sarama.ConsumerMessage) (eventTime, watermark time.Time, late bool) {
+	if w == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	eventTime = message.Timestamp
+	if event, err := w.decoder.DecodeEvent(message); err == nil && !event.Timestamp.IsZero() {
+		eventTime = event.Timestamp
+	}
+
+	w.mu.Lock()
+	tp := partitionKey{message.Topic, message.Partition}
+	p, ok := w.partitions[tp]
+	if !ok {
+		p = &eventWatermark{}
+		w.partitions[tp] = p
+	}
+	p.events++
+	watermark = p.watermark
+	late = eventTime.Before(watermark)
+	if late {
+		p.late++
+		p.maxBehind = max(p.maxBehind, watermark.Sub(eventTime))
+	} else if next := eventTime.Add(-w.lateness); next.After(p.watermark) {
+		p.watermark = next
+	}
+	current := p.watermark
+	w.mu.Unlock()
+
+	w.metrics.WatermarkLag(message.Topic, message.Partition, time.Since(current))
+	if late {
+		w.metrics.LateEvent(message.Topic)
+		slog.Warn("Late event", "topic", message.Topic, "partition", message.Partition, "offset", message.Offset,
+			"key", string(message.Key), "event_time", eventTime, "watermark", watermark,
+			"behind_watermark", watermark.Sub(eventTime))
+	}
+	return eventTime, watermark, late
+}
+
+// log logs the watermark and late events of every partition, sorted by
+// topic and partition.
+func (w *eventWatermarks) log() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]partitionKey, 0, len(w.partitions))
+	for tp := range w.partitions {
+		keys = append(keys, tp)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+	for _, tp := range keys {
+		p := w.partitions[tp]
+		slog.Info("Watermark summary", "topic", tp.topic, "partition", tp.partition, "watermark", p.watermark,
+			"watermark_lag", time.Since(p.watermark).Round(time.Millisecond), "events", p.events, "late", p.late,
+			"max_behind_watermark", p.maxBehind)
+	}
+}
+
+// routeLate produces a late message to the late topic as it was consumed.
+func (p processor) routeLate(message *sarama.ConsumerMessage, eventTime, watermark time.Time) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+5)
+	for _, h := range message.Headers {
+		headers = append(headers, *h)
+	}
+	headers = append(headers,
+		stringHeader(LateEventTimeHeader, eventTime.UTC().Format(time.RFC3339Nano)),
+		stringHeader(LateWatermarkHeader, watermark.UTC().Format(time.RFC3339Nano)),
+		stringHeader(LateOriginalTopicHeader, message.Topic),
+		stringHeader(LateOriginalPartitionHeader, strconv.Itoa(int(message.Partition))),
+		stringHeader(LateOriginalOffsetHeader, strconv.FormatInt(message.Offset, 10)),
+	)
+
+	err := p.send(&sarama.ProducerMessage{
+		Topic:   p.lateTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to route late message from partition %d offset %d: %w",
+			message.Partition, message.Offset, err)
+	}
+	slog.Info("Late event routed", "late_topic", p.lateTopic, "topic", message.Topic,
+		"partition", message.Partition, "offset", message.Offset)
+	return nil
+}
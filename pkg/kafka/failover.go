@@ -0,0 +1,210 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// OriginClusterHeader names the cluster a FailoverProducer sent a message
+// to, so consumers of a mirrored topic can tell where it was written.
+const OriginClusterHeader = "origin-cluster"
+
+// Names of the clusters of a FailoverProducer.
+const (
+	ClusterPrimary = "primary"
+	ClusterStandby = "standby"
+)
+
+// FailoverCluster is one of the clusters a FailoverProducer sends to.
+// Options are added to the ones shared by both clusters, e.g. for
+// different credentials.
+type FailoverCluster struct {
+	Brokers []string
+	Options []Option
+}
+
+// FailoverConfig sets when a FailoverProducer switches clusters.
+type FailoverConfig struct {
+	// Threshold is the number of sends in a row that have to fail, after
+	// the producer's own retries, before the producer switches to the other
+	// cluster.
+	Threshold int
+	// FailbackAfter is how long the producer stays on the standby before it
+	// tries the primary again, with a single send. 0 never fails back on
+	// its own, only once the standby fails as well.
+	FailbackAfter time.Duration
+}
+
+func (c FailoverConfig) validate() error {
+	switch {
+	case c.Threshold < 1:
+		return fmt.Errorf("failover threshold must be at least 1, got %d", c.Threshold)
+	case c.FailbackAfter < 0:
+		return fmt.Errorf("failback delay must not be negative, got %s", c.FailbackAfter)
+	}
+	return nil
+}
+
+// FailoverProducer sends to a primary cluster and, once sends there keep
+// failing, to a standby cluster instead: simple active/passive disaster
+// recovery. Every message carries an OriginClusterHeader. The clusters are
+// connected on first use, so a producer starts even while the primary is
+// down. It is safe for concurrent use, although sends are serialized.
+type FailoverProducer struct {
+	topic    string
+	config   FailoverConfig
+	opts     []Option
+	clusters [2]*failoverCluster
+
+	mu         sync.Mutex
+	active     int
+	failures   int
+	switchedAt time.Time
+	failovers  int
+	failbacks  int
+}
+
+type failoverCluster struct {
+	name     string
+	brokers  []string
+	opts     []Option
+	producer *Producer
+	sent     int
+}
+
+func NewFailoverProducer(primary, standby FailoverCluster, topic string, config FailoverConfig, opts ...Option) (*FailoverProducer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if len(primary.Brokers) == 0 || len(standby.Brokers) == 0 {
+		return nil, errors.New("failover needs the brokers of a primary and a standby cluster")
+	}
+	return &FailoverProducer{
+		topic:  topic,
+		config: config,
+		opts:   opts,
+		clusters: [2]*failoverCluster{
+			{name: ClusterPrimary, brokers: primary.Brokers, opts: primary.Options},
+			{name: ClusterStandby, brokers: standby.Brokers, opts: standby.Options},
+		},
+	}, nil
+}
+
+// Topic returns the topic the producer writes to.
+func (p *FailoverProducer) Topic() string {
+	return p.topic
+}
+
+// Active returns the name of the cluster sends currently go to.
+func (p *FailoverProducer) Active() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clusters[p.active].name
+}
+
+// Send is Producer.Send on the active cluster. The send that reaches the
+// failover threshold is tried once more on the other cluster, which stays
+// active from then on. While the standby is active, the first send after
+// FailbackAfter goes to the primary, and the producer stays there if it
+// works.
+func (p *FailoverProducer) Send(event UserEvent, headers map[string]string) Delivery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == 1 && p.config.FailbackAfter > 0 && time.Since(p.switchedAt) >= p.config.FailbackAfter {
+		probe := p.sendTo(0, event, headers)
+		if probe.Err == nil {
+			p.switchTo(0, "primary is reachable again")
+			p.failbacks++
+			return probe
+		}
+		slog.Warn("Primary cluster still failing, staying on the standby", "topic", p.topic, "error", probe.Err)
+		p.switchedAt = time.Now()
+	}
+
+	d := p.sendTo(p.active, event, headers)
+	if d.Err == nil {
+		p.failures = 0
+		return d
+	}
+	p.failures++
+	if p.failures < p.config.Threshold {
+		return d
+	}
+
+	other := 1 - p.active
+	p.switchTo(other, fmt.Sprintf("%d sends in a row failed", p.failures))
+	if other == 1 {
+		p.failovers++
+	} else {
+		p.failbacks++
+	}
+	if retried := p.sendTo(other, event, headers); retried.Err == nil {
+		return retried
+	}
+	p.failures = 1
+	return d
+}
+
+// sendTo sends event to cluster i, connecting it first if needed. p.mu must
+// be held.
+func (p *FailoverProducer) sendTo(i int, event UserEvent, headers map[string]string) Delivery {
+	c := p.clusters[i]
+	if c.producer == nil {
+		producer, err := NewProducer(c.brokers, p.topic, append(append([]Option(nil), p.opts...), c.opts...)...)
+		if err != nil {
+			return Delivery{Err: fmt.Errorf("%s cluster: %w", c.name, err)}
+		}
+		c.producer = producer
+	}
+
+	withOrigin := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		withOrigin[key] = value
+	}
+	withOrigin[OriginClusterHeader] = c.name
+	d := c.producer.Send(event, withOrigin)
+	if d.Err != nil {
+		d.Err = fmt.Errorf("%s cluster: %w", c.name, d.Err)
+		return d
+	}
+	c.sent++
+	return d
+}
+
+// switchTo makes cluster i the active one. p.mu must be held.
+func (p *FailoverProducer) switchTo(i int, reason string) {
+	from, to := p.clusters[p.active], p.clusters[i]
+	attrs := []any{"topic", p.topic, "from", from.name, "to", to.name, "brokers", to.brokers, "reason", reason}
+	if i == 1 {
+		slog.Error("Failing over to the standby cluster", attrs...)
+	} else {
+		slog.Warn("Failing back to the primary cluster", attrs...)
+	}
+	p.active = i
+	p.failures = 0
+	p.switchedAt = time.Now()
+}
+
+// Close closes the producers of both clusters and logs how many messages
+// went to each.
+func (p *FailoverProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slog.Info("Failover summary", "topic", p.topic, "active", p.clusters[p.active].name,
+		"sent_primary", p.clusters[0].sent, "sent_standby", p.clusters[1].sent,
+		"failovers", p.failovers, "failbacks", p.failbacks)
+	var errs []error
+	for _, c := range p.clusters {
+		if c.producer != nil {
+			if err := c.producer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s cluster: %w", c.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
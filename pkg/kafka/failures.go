@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// ErrInjectedFailure is returned for messages a FailureInjector fails.
+var ErrInjectedFailure = errors.New("injected processing failure")
+
+// FailureInjector is a MessageHandler that fails messages on purpose, to
+// exercise the retry, retry topic and DLQ paths without a broken handler.
+// Put it in front of the real handlers with Chain.
+type FailureInjector struct {
+	rate float64
+	keys map[string]bool
+}
+
+// NewFailureInjector fails each attempt with probability rate, so retries
+// usually succeed, and every attempt of messages with one of keys, which
+// makes them poison pills.
+func NewFailureInjector(rate float64, keys []string) (*FailureInjector, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("failure rate must be between 0 and 1, got %g", rate)
+	}
+	f := &FailureInjector{rate: rate, keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		f.keys[key] = true
+	}
+	return f, nil
+}
+
+func (f *FailureInjector) Handle(ctx context.Context, message *Message) error {
+	if f.keys[string(message.Key)] {
+		return fmt.Errorf("%w: key %s always fails", ErrInjectedFailure, message.Key)
+	}
+	if f.rate > 0 && rand.Float64() < f.rate {
+		return ErrInjectedFailure
+	}
+	return nil
+}
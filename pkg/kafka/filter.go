@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"log/slog"
+
+	"github.com/IBM/sarama"
+)
+
+// MessageFilter decides whether a consumer handles message. event is the
+// decoded message, or nil if it couldn't be decoded.
+type MessageFilter func(message *Message, event *UserEvent) (bool, error)
+
+// WithFilter makes a consumer log and handle only the messages filter
+// accepts. The others count as processed right away, so their offsets are
+// committed along with the rest. Both outcomes are counted by
+// Metrics.MessageFiltered.
+func WithFilter(filter MessageFilter) Option {
+	return func(o *options) error {
+		o.filter = filter
+		return nil
+	}
+}
+
+// filtered reports whether the filter rejects message. A filter that fails
+// rejects the message too, since it can't be shown to match.
+func (p processor) filtered(message *sarama.ConsumerMessage) bool {
+	if p.filter == nil {
+		return false
+	}
+
+	var event *UserEvent
+	if decoded, err := p.DecodeEvent(message); err == nil {
+		event = &decoded
+	}
+
+	// A message that can't be resolved is filtered as it is and fails later.
+	msg, _ := p.resolved(message)
+	pass, err := p.filter(msg, event)
+	if err != nil {
+		slog.Warn("Filter failed, skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+	}
+	p.metrics.MessageFiltered(message.Topic, pass)
+	if !pass {
+		slog.Debug("Message filtered out", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset)
+	}
+	return !pass
+}
@@ -0,0 +1,660 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"kafka-hwsw/internal/auth"
+)
+
+// franzRequestTimeout bounds the metadata, offset and admin requests of
+// FranzBackend, which sarama bounds with its config.
+const franzRequestTimeout = 30 * time.Second
+
+// franzChannelBuffer is how many messages a claim or partition reader
+// reads ahead, and how many errors it keeps, sarama's default.
+const franzChannelBuffer = 256
+
+// FranzBackend connects to the cluster with franz-go. It has no
+// transactions, and of the rebalance strategies only range, roundrobin and
+// sticky. Messages are partitioned with the sarama partitioner of the
+// config, so a key lands on the same partition with either backend.
+type FranzBackend struct{}
+
+// Name returns "franz-go".
+func (FranzBackend) Name() string { return "franz-go" }
+
+// Connect connects a franz-go client to brokers and checks that one of
+// them answers, as sarama.NewClient does.
+func (FranzBackend) Connect(brokers []string, config ClientConfig) (Client, error) {
+	opts, err := franzOpts(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &franzClient{
+		config: config,
+		opts:   opts,
+		client: client,
+		admin:  kadm.NewClient(client),
+	}, nil
+}
+
+// franzOpts translates config into the options of a kgo client. Group
+// members and partition readers add their own to them.
+func franzOpts(brokers []string, config ClientConfig) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{kgo.SeedBrokers(brokers...)}
+	if config.ClientID != "" {
+		opts = append(opts, kgo.ClientID(config.ClientID))
+	}
+	if config.DialTimeout > 0 {
+		opts = append(opts, kgo.DialTimeout(config.DialTimeout))
+	}
+	if config.ReadTimeout > 0 {
+		opts = append(opts, kgo.RequestTimeoutOverhead(config.ReadTimeout))
+	}
+
+	// kgo takes either a dialer or a TLS config, so TLS over a custom
+	// dialer is done by the dialer.
+	switch dial, tlsConfig := config.Dial, config.TLS; {
+	case dial != nil && tlsConfig != nil:
+		opts = append(opts, kgo.Dialer(func(ctx context.Context, network, host string) (net.Conn, error) {
+			conn, err := dial(ctx, network, host)
+			if err != nil {
+				return nil, err
+			}
+			c := tlsConfig.Clone()
+			if c.ServerName == "" {
+				c.ServerName, _, _ = net.SplitHostPort(host)
+			}
+			return tls.Client(conn, c), nil
+		}))
+	case dial != nil:
+		opts = append(opts, kgo.Dialer(dial))
+	case tlsConfig != nil:
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	if s := config.SASL; s != nil {
+		switch s.Mechanism {
+		case auth.MechanismPlain:
+			opts = append(opts, kgo.SASL(plain.Auth{User: s.Username, Pass: s.Password}.AsMechanism()))
+		case auth.MechanismSCRAMSHA256:
+			opts = append(opts, kgo.SASL(scram.Auth{User: s.Username, Pass: s.Password}.AsSha256Mechanism()))
+		case auth.MechanismSCRAMSHA512:
+			opts = append(opts, kgo.SASL(scram.Auth{User: s.Username, Pass: s.Password}.AsSha512Mechanism()))
+		default:
+			return nil, fmt.Errorf("unsupported SASL mechanism: %s", s.Mechanism)
+		}
+	}
+
+	switch config.Producer.Acks {
+	case 0:
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	case 1:
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if !config.Producer.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+	opts = append(opts, kgo.RecordRetries(config.Producer.Retries))
+	codec, err := franzCodec(config.Producer.Compression)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, kgo.ProducerBatchCompression(codec))
+	partitioner := config.Producer.Partitioner
+	if partitioner == nil {
+		partitioner = sarama.NewHashPartitioner
+	}
+	opts = append(opts, kgo.RecordPartitioner(franzPartitioner{partitioner}))
+
+	if config.Consumer.ReadCommitted {
+		opts = append(opts, kgo.FetchIsolationLevel(kgo.ReadCommitted()))
+	}
+	return opts, nil
+}
+
+// franzCodec returns the kgo codec of one of CompressionCodecs.
+func franzCodec(name string) (kgo.CompressionCodec, error) {
+	switch name {
+	case "", "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.CompressionCodec{}, fmt.Errorf("unsupported compression codec: %s", name)
+	}
+}
+
+// franzClient is a Client over a kgo client, which its producers share.
+// Group members and partition readers get kgo clients of their own, since
+// kgo fixes what a client consumes when it is created.
+type franzClient struct {
+	config ClientConfig
+	opts   []kgo.Opt
+	client *kgo.Client
+	admin  *kadm.Client
+}
+
+func (c *franzClient) Partitions(topic string) ([]int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	topics, err := c.admin.ListTopics(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	detail, ok := topics[topic]
+	if !ok {
+		return nil, kerr.UnknownTopicOrPartition
+	}
+	if detail.Err != nil {
+		return nil, detail.Err
+	}
+	partitions := detail.Partitions.Numbers()
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions, nil
+}
+
+func (c *franzClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+
+	var listed kadm.ListedOffsets
+	var err error
+	switch time {
+	case sarama.OffsetOldest:
+		listed, err = c.admin.ListStartOffsets(ctx, topic)
+	case sarama.OffsetNewest:
+		listed, err = c.admin.ListEndOffsets(ctx, topic)
+	default:
+		listed, err = c.admin.ListOffsetsAfterMilli(ctx, time, topic)
+	}
+	if err != nil {
+		return 0, err
+	}
+	offset, err := listedOffset(listed, topic, partition)
+	if err != nil || time < 0 {
+		return offset, err
+	}
+
+	// kadm answers a timestamp after the last message with the log-end
+	// offset, where sarama answers -1.
+	end, err := c.admin.ListEndOffsets(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := listedOffset(end, topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	if offset >= newest {
+		return -1, nil
+	}
+	return offset, nil
+}
+
+func listedOffset(listed kadm.ListedOffsets, topic string, partition int32) (int64, error) {
+	o, ok := listed.Lookup(topic, partition)
+	if !ok {
+		return 0, kerr.UnknownTopicOrPartition
+	}
+	if o.Err != nil {
+		return 0, o.Err
+	}
+	return o.Offset, nil
+}
+
+func (c *franzClient) RefreshMetadata(topics ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	_, err := c.admin.Metadata(ctx, topics...)
+	return err
+}
+
+// NewSyncProducer fails for a transactional ID, franz-go transactions
+// don't fit the SyncProducer interface.
+func (c *franzClient) NewSyncProducer() (SyncProducer, error) {
+	if c.config.Producer.TransactionalID != "" {
+		return nil, unsupported("transactions are", FranzBackend{})
+	}
+	return franzProducer{c.client}, nil
+}
+
+func (c *franzClient) NewConsumerGroup(groupID string) (ConsumerGroup, error) {
+	balancer, err := franzBalancer(c.config.Consumer.Rebalance)
+	if err != nil {
+		return nil, err
+	}
+	return &franzGroup{
+		client:   c,
+		groupID:  groupID,
+		balancer: balancer,
+		errors:   make(chan error, franzChannelBuffer),
+		sessions: make(chan *franzSession, 1),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+func (c *franzClient) NewReader() (Reader, error) {
+	return &franzReader{client: c}, nil
+}
+
+func (c *franzClient) NewAdmin() (Admin, error) {
+	return franzAdmin{c.admin}, nil
+}
+
+func (c *franzClient) Close() error {
+	c.client.Close()
+	return nil
+}
+
+// franzMessageKey is the context key under which a record carries the
+// partitioning of the message it was made from.
+type franzMessageKey struct{}
+
+// franzPartitioning is the message a record was made from, for the sarama
+// partitioner, and the error the partitioner returned.
+type franzPartitioning struct {
+	msg *sarama.ProducerMessage
+	err error
+}
+
+// franzPartitioner partitions records with a sarama partitioner.
+type franzPartitioner struct {
+	constructor sarama.PartitionerConstructor
+}
+
+func (p franzPartitioner) ForTopic(topic string) kgo.TopicPartitioner {
+	return franzTopicPartitioner{p.constructor(topic)}
+}
+
+type franzTopicPartitioner struct {
+	partitioner sarama.Partitioner
+}
+
+func (p franzTopicPartitioner) RequiresConsistency(*kgo.Record) bool {
+	return p.partitioner.RequiresConsistency()
+}
+
+// Partition returns -1 when the partitioner fails, which fails the record;
+// SendMessage returns the partitioner's error instead of kgo's.
+func (p franzTopicPartitioner) Partition(r *kgo.Record, n int) int {
+	partitioning := r.Context.Value(franzMessageKey{}).(*franzPartitioning)
+	partition, err := p.partitioner.Partition(partitioning.msg, int32(n))
+	if err != nil {
+		partitioning.err = err
+		return -1
+	}
+	return int(partition)
+}
+
+// franzRecord converts msg into a record to produce.
+func franzRecord(msg *sarama.ProducerMessage) (*kgo.Record, *franzPartitioning, error) {
+	record := &kgo.Record{Topic: msg.Topic, Timestamp: msg.Timestamp}
+	var err error
+	if msg.Key != nil {
+		if record.Key, err = msg.Key.Encode(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if msg.Value != nil {
+		if record.Value, err = msg.Value.Encode(); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, h := range msg.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: string(h.Key), Value: h.Value})
+	}
+	partitioning := &franzPartitioning{msg: msg}
+	record.Context = context.WithValue(context.Background(), franzMessageKey{}, partitioning)
+	return record, partitioning, nil
+}
+
+// franzMessage converts a fetched record into the message consumers get.
+func franzMessage(r *kgo.Record) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Key:       r.Key,
+		Value:     r.Value,
+		Timestamp: r.Timestamp,
+	}
+	for _, h := range r.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+	}
+	return msg
+}
+
+// franzProducer sends through the client's kgo client.
+type franzProducer struct {
+	client *kgo.Client
+}
+
+func (p franzProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	record, partitioning, err := franzRecord(msg)
+	if err != nil {
+		return -1, -1, err
+	}
+	_, err = p.client.ProduceSync(context.Background(), record).First()
+	if partitioning.err != nil {
+		err = partitioning.err
+	}
+	if err != nil {
+		return -1, -1, err
+	}
+	msg.Partition, msg.Offset = record.Partition, record.Offset
+	return msg.Partition, msg.Offset, nil
+}
+
+func (p franzProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var failed sarama.ProducerErrors
+	records := make([]*kgo.Record, 0, len(msgs))
+	sent := make(map[*kgo.Record]*franzPartitioning, len(msgs))
+	for _, msg := range msgs {
+		record, partitioning, err := franzRecord(msg)
+		if err != nil {
+			failed = append(failed, &sarama.ProducerError{Msg: msg, Err: err})
+			continue
+		}
+		records = append(records, record)
+		sent[record] = partitioning
+	}
+	for _, result := range p.client.ProduceSync(context.Background(), records...) {
+		partitioning := sent[result.Record]
+		err := result.Err
+		if partitioning.err != nil {
+			err = partitioning.err
+		}
+		if err != nil {
+			failed = append(failed, &sarama.ProducerError{Msg: partitioning.msg, Err: err})
+			continue
+		}
+		partitioning.msg.Partition, partitioning.msg.Offset = result.Record.Partition, result.Record.Offset
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+func (p franzProducer) IsTransactional() bool { return false }
+
+func (p franzProducer) BeginTxn() error { return unsupported("transactions are", FranzBackend{}) }
+
+func (p franzProducer) CommitTxn() error { return unsupported("transactions are", FranzBackend{}) }
+
+func (p franzProducer) AbortTxn() error { return unsupported("transactions are", FranzBackend{}) }
+
+func (p franzProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return unsupported("transactions are", FranzBackend{})
+}
+
+// Close leaves the shared client open.
+func (p franzProducer) Close() error { return nil }
+
+// franzReader reads every partition with a kgo client of its own.
+type franzReader struct {
+	client *franzClient
+
+	mu      sync.Mutex
+	readers []*franzPartitionReader
+}
+
+func (r *franzReader) ConsumePartition(topic string, partition int32, offset int64) (PartitionReader, error) {
+	// Like sarama, fail for partitions that don't exist rather than wait
+	// for them.
+	partitions, err := r.client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	if i := sort.Search(len(partitions), func(i int) bool { return partitions[i] >= partition }); i == len(partitions) || partitions[i] != partition {
+		return nil, kerr.UnknownTopicOrPartition
+	}
+
+	at := kgo.NewOffset().At(offset)
+	switch offset {
+	case sarama.OffsetOldest:
+		at = kgo.NewOffset().AtStart()
+	case sarama.OffsetNewest:
+		at = kgo.NewOffset().AtEnd()
+	}
+	opts := append(append([]kgo.Opt(nil), r.client.opts...),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{topic: {partition: at}}))
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr := &franzPartitionReader{
+		client:   client,
+		cancel:   cancel,
+		messages: make(chan *sarama.ConsumerMessage, franzChannelBuffer),
+		errors:   make(chan *sarama.ConsumerError, franzChannelBuffer),
+		done:     make(chan struct{}),
+	}
+	go pr.run(ctx, topic, partition)
+
+	r.mu.Lock()
+	r.readers = append(r.readers, pr)
+	r.mu.Unlock()
+	return pr, nil
+}
+
+// Close closes the partition readers that are still open.
+func (r *franzReader) Close() error {
+	r.mu.Lock()
+	readers := r.readers
+	r.readers = nil
+	r.mu.Unlock()
+	for _, pr := range readers {
+		pr.AsyncClose()
+	}
+	for _, pr := range readers {
+		<-pr.done
+	}
+	return nil
+}
+
+type franzPartitionReader struct {
+	client   *kgo.Client
+	cancel   context.CancelFunc
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+	done     chan struct{}
+	hwm      atomic.Int64
+}
+
+// run polls the partition until the reader is closed. Errors are dropped
+// when nobody reads them, as sarama does without Return.Errors.
+func (r *franzPartitionReader) run(ctx context.Context, topic string, partition int32) {
+	defer close(r.done)
+	defer r.client.Close()
+	defer close(r.errors)
+	defer close(r.messages)
+
+	for {
+		fetches := r.client.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(_ string, _ int32, err error) {
+			select {
+			case r.errors <- &sarama.ConsumerError{Topic: topic, Partition: partition, Err: err}:
+			default:
+			}
+		})
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			r.hwm.Store(p.HighWatermark)
+			for _, record := range p.Records {
+				select {
+				case r.messages <- franzMessage(record):
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+}
+
+func (r *franzPartitionReader) Messages() <-chan *sarama.ConsumerMessage { return r.messages }
+
+func (r *franzPartitionReader) Errors() <-chan *sarama.ConsumerError { return r.errors }
+
+func (r *franzPartitionReader) HighWaterMarkOffset() int64 { return r.hwm.Load() }
+
+func (r *franzPartitionReader) AsyncClose() { r.cancel() }
+
+func (r *franzPartitionReader) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// franzAdmin is an Admin over the client's kadm client.
+type franzAdmin struct {
+	admin *kadm.Client
+}
+
+func (a franzAdmin) ListTopics() (map[string]TopicDetail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	details, err := a.admin.ListTopicsWithInternal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := details.Error(); err != nil {
+		return nil, err
+	}
+	topics := make(map[string]TopicDetail, len(details))
+	for name, detail := range details {
+		topics[name] = TopicDetail{
+			Partitions:        int32(len(detail.Partitions)),
+			ReplicationFactor: int16(detail.Partitions.NumReplicas()),
+		}
+	}
+	return topics, nil
+}
+
+func (a franzAdmin) CreateTopic(topic string, detail TopicDetail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	configs := make(map[string]*string, len(detail.Configs))
+	for name, value := range detail.Configs {
+		value := value
+		configs[name] = &value
+	}
+	_, err := a.admin.CreateTopic(ctx, detail.Partitions, detail.ReplicationFactor, configs, topic)
+	if errors.Is(err, kerr.TopicAlreadyExists) {
+		return fmt.Errorf("%w: %s", ErrTopicExists, topic)
+	}
+	return err
+}
+
+func (a franzAdmin) TopicConfig(topic, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	resources, err := a.admin.DescribeTopicConfigs(ctx, topic)
+	if err != nil {
+		return "", err
+	}
+	for _, resource := range resources {
+		if resource.Err != nil {
+			return "", resource.Err
+		}
+		for _, config := range resource.Configs {
+			if config.Key == name {
+				return config.MaybeValue(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("topic %s has no config %s", topic, name)
+}
+
+func (a franzAdmin) ListGroupOffsets(groupID string, partitions map[string][]int32) (map[string]map[int32]GroupOffset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+
+	var fetched kadm.OffsetResponses
+	var err error
+	if partitions == nil {
+		fetched, err = a.admin.FetchOffsets(ctx, groupID)
+	} else {
+		topics := make([]string, 0, len(partitions))
+		for topic := range partitions {
+			topics = append(topics, topic)
+		}
+		fetched, err = a.admin.FetchOffsetsForTopics(ctx, groupID, topics...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := fetched.Error(); err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int32]GroupOffset, len(fetched))
+	if partitions == nil {
+		for topic, responses := range fetched {
+			offsets[topic] = make(map[int32]GroupOffset, len(responses))
+			for partition, response := range responses {
+				offsets[topic][partition] = GroupOffset{Offset: response.At, Metadata: response.Metadata}
+			}
+		}
+		return offsets, nil
+	}
+	for topic, list := range partitions {
+		offsets[topic] = make(map[int32]GroupOffset, len(list))
+		for _, partition := range list {
+			offset := GroupOffset{Offset: -1}
+			if response, ok := fetched.Lookup(topic, partition); ok {
+				offset = GroupOffset{Offset: response.At, Metadata: response.Metadata}
+			}
+			offsets[topic][partition] = offset
+		}
+	}
+	return offsets, nil
+}
+
+func (a franzAdmin) Brokers() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	brokers, err := a.admin.ListBrokers(ctx)
+	return len(brokers), err
+}
+
+// Close leaves the shared client open.
+func (a franzAdmin) Close() error { return nil }
@@ -0,0 +1,641 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// franzBalancer returns the kgo balancer of a rebalance strategy. kgo's
+// cooperative-sticky needs incremental sessions, which GroupSession, made
+// after sarama's, doesn't have, and single-active is a sarama strategy.
+func franzBalancer(name string) (kgo.GroupBalancer, error) {
+	switch strings.ToLower(name) {
+	case RebalanceRange:
+		return kgo.RangeBalancer(), nil
+	case "", RebalanceRoundRobin:
+		return kgo.RoundRobinBalancer(), nil
+	case RebalanceSticky:
+		return kgo.StickyBalancer(), nil
+	case RebalanceCooperativeSticky, RebalanceSingleActive:
+		return nil, unsupported(fmt.Sprintf("the %s rebalance strategy is", name), FranzBackend{})
+	default:
+		return nil, fmt.Errorf("unsupported rebalance strategy: %s", name)
+	}
+}
+
+// franzGroup is a ConsumerGroup over a kgo client that Consume creates for
+// the topics it is given. kgo runs the membership in the background; its
+// callbacks turn every generation into a franzSession, which Consume runs
+// the handler on:
+//
+//   - OnPartitionsAssigned starts the session of a generation.
+//   - AdjustFetchOffsetsFn gets the committed offsets, hands the session to
+//     Consume and holds the fetch back until Setup has moved them.
+//   - OnPartitionsRevoked and OnPartitionsLost end the session and wait for
+//     Consume to commit it.
+//
+// kgo only starts a new session with a rebalance, so a session that ends
+// for any other reason, such as a cancelled context, leaves the group and
+// the next Consume joins it again, as sarama's does.
+type franzGroup struct {
+	client   *franzClient
+	groupID  string
+	balancer kgo.GroupBalancer
+	errors   chan error
+	// sessions holds the session waiting for a Consume.
+	sessions chan *franzSession
+
+	mu       sync.Mutex
+	consumer *kgo.Client
+	topics   []string
+	current  *franzSession
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (g *franzGroup) Consume(ctx context.Context, topics []string, handler GroupHandler) error {
+	select {
+	case <-g.closed:
+		return sarama.ErrClosedConsumerGroup
+	default:
+	}
+	if len(topics) == 0 {
+		return fmt.Errorf("no topics provided")
+	}
+
+	consumer, err := g.subscribe(topics)
+	if err != nil {
+		return err
+	}
+	session, err := g.nextSession(ctx)
+	if session == nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, session.cancel)
+	defer stop()
+
+	err = handler.Setup(session)
+	if err != nil {
+		session.cancel()
+	}
+	close(session.setup)
+
+	// As in sarama, the session ends as soon as the first claim returns.
+	var wg sync.WaitGroup
+	claims := make(map[string]map[int32]*franzClaim)
+	if err == nil {
+		for topic, partitions := range session.claims {
+			claims[topic] = make(map[int32]*franzClaim, len(partitions))
+			for _, partition := range partitions {
+				claim := &franzClaim{
+					topic:     topic,
+					partition: partition,
+					offset:    session.offset(topic, partition),
+					messages:  make(chan *sarama.ConsumerMessage, franzChannelBuffer),
+				}
+				claims[topic][partition] = claim
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer session.cancel()
+					if err := handler.ConsumeClaim(session, claim); err != nil {
+						g.sendError(err)
+					}
+				}()
+			}
+		}
+		if config := g.client.config.Consumer; config.AutoCommit {
+			go session.autoCommit(config.AutoCommitInterval)
+		}
+		g.fetch(consumer, session, claims)
+	}
+
+	<-session.ctx.Done()
+	for _, partitions := range claims {
+		for _, claim := range partitions {
+			close(claim.messages)
+		}
+	}
+	wg.Wait()
+	if cerr := handler.Cleanup(session); err == nil {
+		err = cerr
+	}
+	g.finish(consumer, session)
+	return err
+}
+
+// subscribe returns the kgo client consuming topics, replacing the one of
+// an earlier Consume with other topics.
+func (g *franzGroup) subscribe(topics []string) (*kgo.Client, error) {
+	g.mu.Lock()
+	consumer, subscribed := g.consumer, g.topics
+	g.mu.Unlock()
+	if consumer != nil && sameTopics(subscribed, topics) {
+		return consumer, nil
+	}
+	if consumer != nil {
+		g.leave(consumer)
+	}
+
+	reset := kgo.NewOffset().AtStart()
+	if g.client.config.Consumer.InitialOffset == sarama.OffsetNewest {
+		reset = kgo.NewOffset().AtEnd()
+	}
+	opts := append(append([]kgo.Opt(nil), g.client.opts...),
+		kgo.ConsumerGroup(g.groupID),
+		kgo.ConsumeTopics(topics...),
+		kgo.Balancers(g.balancer),
+		kgo.ConsumeResetOffset(reset),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsAssigned(g.assigned),
+		kgo.AdjustFetchOffsetsFn(g.adjust),
+		kgo.OnPartitionsRevoked(func(context.Context, *kgo.Client, map[string][]int32) { g.endSession(false) }),
+		kgo.OnPartitionsLost(func(context.Context, *kgo.Client, map[string][]int32) { g.endSession(true) }),
+	)
+	if id := g.client.config.Consumer.InstanceID; id != "" {
+		opts = append(opts, kgo.InstanceID(id))
+	}
+	consumer, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	g.consumer, g.topics = consumer, append([]string(nil), topics...)
+	g.mu.Unlock()
+	select {
+	case <-g.closed:
+		g.leave(consumer)
+		return nil, sarama.ErrClosedConsumerGroup
+	default:
+	}
+	return consumer, nil
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nextSession waits for the session of the next generation. It returns nil
+// when ctx is cancelled first.
+func (g *franzGroup) nextSession(ctx context.Context) (*franzSession, error) {
+	for ctx.Err() == nil {
+		select {
+		case s := <-g.sessions:
+			g.mu.Lock()
+			s.started = s.ctx.Err() == nil
+			g.mu.Unlock()
+			if s.started {
+				return s, nil
+			}
+		case <-ctx.Done():
+		case <-g.closed:
+			return nil, sarama.ErrClosedConsumerGroup
+		}
+	}
+	return nil, nil
+}
+
+// assigned starts the session of a new generation. A member without
+// partitions gets no AdjustFetchOffsetsFn call, so its session goes to
+// Consume right away.
+func (g *franzGroup) assigned(_ context.Context, consumer *kgo.Client, assigned map[string][]int32) {
+	memberID, generation := consumer.GroupMetadata()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &franzSession{
+		group:      g,
+		consumer:   consumer,
+		memberID:   memberID,
+		generation: generation,
+		claims:     sortedClaims(assigned),
+		ctx:        ctx,
+		cancel:     cancel,
+		setup:      make(chan struct{}),
+		done:       make(chan struct{}),
+		next:       make(map[string]map[int32]*franzMark),
+	}
+	g.mu.Lock()
+	g.current = s
+	g.mu.Unlock()
+	if countPartitions(assigned) == 0 {
+		g.offer(s)
+	}
+}
+
+// offer hands s to the next Consume, replacing a session of an earlier
+// generation nobody took. Only kgo's callbacks offer, one at a time.
+func (g *franzGroup) offer(s *franzSession) {
+	select {
+	case <-g.sessions:
+	default:
+	}
+	g.sessions <- s
+}
+
+// adjust starts the claims of the current session at the offsets committed
+// for them, or at the initial offset, hands the session to Consume and
+// waits for Setup, whose MarkOffset and ResetOffset calls move where the
+// claims start. kgo holds the revoke of the generation back until it
+// returns, so a member nobody calls Consume on stalls a rebalance, as with
+// sarama.
+func (g *franzGroup) adjust(ctx context.Context, offsets map[string]map[int32]kgo.Offset) (map[string]map[int32]kgo.Offset, error) {
+	g.mu.Lock()
+	s := g.current
+	g.mu.Unlock()
+	if s == nil {
+		return offsets, nil
+	}
+
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			next := offset.EpochOffset().Offset
+			if next < 0 {
+				// Uncommitted partitions start at the initial offset,
+				// resolved so the session knows where.
+				resolved, err := g.client.GetOffset(topic, partition, next)
+				if err != nil {
+					return nil, fmt.Errorf("failed to resolve the initial offset of partition %d of %s: %w", partition, topic, err)
+				}
+				next = resolved
+			}
+			s.start(topic, partition, next)
+		}
+	}
+
+	g.offer(s)
+	select {
+	case <-s.setup:
+	case <-s.ctx.Done():
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-g.closed:
+		return nil, sarama.ErrClosedConsumerGroup
+	}
+
+	adjusted := make(map[string]map[int32]kgo.Offset, len(offsets))
+	for topic, partitions := range offsets {
+		adjusted[topic] = make(map[int32]kgo.Offset, len(partitions))
+		for partition := range partitions {
+			adjusted[topic][partition] = kgo.NewOffset().At(s.offset(topic, partition))
+		}
+	}
+	return adjusted, nil
+}
+
+// endSession ends the current session and waits until Consume committed
+// it, or drops it if no Consume took it yet. lost skips the commit, since
+// the partitions already belong to another member.
+func (g *franzGroup) endSession(lost bool) {
+	g.mu.Lock()
+	s := g.current
+	g.current = nil
+	if s == nil {
+		g.mu.Unlock()
+		return
+	}
+	s.revoked, s.lost = true, lost
+	started := s.started
+	s.cancel()
+	g.mu.Unlock()
+
+	if !started {
+		select {
+		case <-g.sessions:
+		default:
+		}
+		return
+	}
+	<-s.done
+}
+
+// fetch feeds the claims of session until it ends.
+func (g *franzGroup) fetch(consumer *kgo.Client, session *franzSession, claims map[string]map[int32]*franzClaim) {
+	for {
+		fetches := consumer.PollFetches(session.ctx)
+		if session.ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			g.sendError(fmt.Errorf("failed to fetch partition %d of %s: %w", partition, topic, err))
+		})
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			claim := claims[p.Topic][p.Partition]
+			if claim == nil {
+				return
+			}
+			claim.hwm.Store(p.HighWatermark)
+			for _, record := range p.Records {
+				select {
+				case claim.messages <- franzMessage(record):
+				case <-session.ctx.Done():
+					return
+				}
+			}
+		})
+	}
+}
+
+// finish commits session, unless its partitions were lost, and leaves the
+// group unless a rebalance ended it.
+func (g *franzGroup) finish(consumer *kgo.Client, session *franzSession) {
+	g.mu.Lock()
+	lost := session.lost
+	g.mu.Unlock()
+	if !lost {
+		session.commit()
+	}
+
+	g.mu.Lock()
+	revoked := session.revoked
+	if g.current == session {
+		g.current = nil
+	}
+	g.mu.Unlock()
+	close(session.done)
+	if !revoked {
+		g.leave(consumer)
+	}
+}
+
+// leave closes consumer, which leaves the group, unless Close or another
+// Consume already replaced it.
+func (g *franzGroup) leave(consumer *kgo.Client) {
+	g.mu.Lock()
+	if g.consumer != consumer {
+		g.mu.Unlock()
+		return
+	}
+	g.consumer, g.topics = nil, nil
+	g.mu.Unlock()
+	consumer.Close()
+}
+
+// sendError drops err when nobody reads Errors, as sarama does without
+// Return.Errors.
+func (g *franzGroup) sendError(err error) {
+	select {
+	case g.errors <- err:
+	default:
+	}
+}
+
+func (g *franzGroup) Errors() <-chan error { return g.errors }
+
+func (g *franzGroup) Pause(partitions map[string][]int32) {
+	if consumer := g.consumerOf(); consumer != nil {
+		consumer.PauseFetchPartitions(partitions)
+	}
+}
+
+func (g *franzGroup) Resume(partitions map[string][]int32) {
+	if consumer := g.consumerOf(); consumer != nil {
+		consumer.ResumeFetchPartitions(partitions)
+	}
+}
+
+func (g *franzGroup) PauseAll() {
+	g.mu.Lock()
+	consumer, topics := g.consumer, g.topics
+	g.mu.Unlock()
+	if consumer != nil {
+		consumer.PauseFetchTopics(topics...)
+	}
+}
+
+func (g *franzGroup) ResumeAll() {
+	if consumer := g.consumerOf(); consumer != nil {
+		consumer.ResumeFetchTopics(consumer.PauseFetchTopics()...)
+		consumer.ResumeFetchPartitions(consumer.PauseFetchPartitions(nil))
+	}
+}
+
+func (g *franzGroup) consumerOf() *kgo.Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.consumer
+}
+
+// Close leaves the group, committing the running session first.
+func (g *franzGroup) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		g.mu.Lock()
+		consumer := g.consumer
+		g.consumer, g.topics = nil, nil
+		g.mu.Unlock()
+		if consumer != nil {
+			consumer.Close()
+		}
+	})
+	return nil
+}
+
+// franzSession is a GroupSession of FranzBackend. Marked offsets are
+// committed every AutoCommitInterval with auto-commit, otherwise on Commit,
+// and always when the session ends. Like sarama's, MarkOffset only moves an
+// offset forward and ResetOffset only rewinds it.
+type franzSession struct {
+	group      *franzGroup
+	consumer   *kgo.Client
+	memberID   string
+	generation int32
+	claims     map[string][]int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+	// setup is closed when Setup returned, done when the session committed
+	// for the last time.
+	setup chan struct{}
+	done  chan struct{}
+
+	// started, revoked and lost are guarded by group.mu.
+	started, revoked, lost bool
+
+	mu   sync.Mutex
+	next map[string]map[int32]*franzMark
+	// commitMu keeps commits in the order their offsets were taken.
+	commitMu sync.Mutex
+}
+
+// franzMark is the next offset of a claimed partition and the metadata to
+// commit with it; dirty ones haven't been committed yet.
+type franzMark struct {
+	offset   int64
+	metadata string
+	dirty    bool
+}
+
+func (s *franzSession) Claims() map[string][]int32 { return s.claims }
+
+func (s *franzSession) MemberID() string { return s.memberID }
+
+func (s *franzSession) GenerationID() int32 { return s.generation }
+
+func (s *franzSession) Context() context.Context { return s.ctx }
+
+func (s *franzSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mark := s.next[topic][partition]; mark != nil && offset > mark.offset {
+		*mark = franzMark{offset: offset, metadata: metadata, dirty: true}
+	}
+}
+
+func (s *franzSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mark := s.next[topic][partition]; mark != nil && offset <= mark.offset {
+		*mark = franzMark{offset: offset, metadata: metadata, dirty: true}
+	}
+}
+
+func (s *franzSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *franzSession) Commit() {
+	s.commit()
+}
+
+// start records where a claimed partition starts.
+func (s *franzSession) start(topic string, partition int32, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next[topic] == nil {
+		s.next[topic] = make(map[int32]*franzMark)
+	}
+	s.next[topic][partition] = &franzMark{offset: offset}
+}
+
+func (s *franzSession) offset(topic string, partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mark := s.next[topic][partition]; mark != nil {
+		return mark.offset
+	}
+	return -1
+}
+
+func (s *franzSession) autoCommit(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.commit()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// commit commits the offsets marked since the last commit, with their
+// metadata, and reports a failure on the group's Errors.
+func (s *franzSession) commit() {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+	metadata := make(map[string]map[int32]string)
+	s.mu.Lock()
+	for topic, partitions := range s.next {
+		for partition, mark := range partitions {
+			if !mark.dirty {
+				continue
+			}
+			if offsets[topic] == nil {
+				offsets[topic] = make(map[int32]kgo.EpochOffset)
+				metadata[topic] = make(map[int32]string)
+			}
+			offsets[topic][partition] = kgo.EpochOffset{Epoch: -1, Offset: mark.offset}
+			metadata[topic][partition] = mark.metadata
+			mark.dirty = false
+		}
+	}
+	s.mu.Unlock()
+	if len(offsets) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), franzRequestTimeout)
+	defer cancel()
+	ctx = kgo.PreCommitFnContext(ctx, func(req *kmsg.OffsetCommitRequest) error {
+		for i := range req.Topics {
+			topic := &req.Topics[i]
+			for j := range topic.Partitions {
+				partition := &topic.Partitions[j]
+				value := metadata[topic.Topic][partition.Partition]
+				partition.Metadata = &value
+			}
+		}
+		return nil
+	})
+	var err error
+	s.consumer.CommitOffsetsSync(ctx, offsets, func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, cerr error) {
+		if cerr != nil {
+			err = cerr
+			return
+		}
+		for _, topic := range resp.Topics {
+			for _, partition := range topic.Partitions {
+				if perr := kerr.ErrorForCode(partition.ErrorCode); perr != nil && err == nil {
+					err = fmt.Errorf("partition %d of %s: %w", partition.Partition, topic.Topic, perr)
+				}
+			}
+		}
+	})
+	if err == nil {
+		return
+	}
+
+	// Mark the offsets again, unless newer ones were marked meanwhile.
+	s.mu.Lock()
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			if mark := s.next[topic][partition]; !mark.dirty && mark.offset == offset.Offset {
+				mark.dirty = true
+			}
+		}
+	}
+	s.mu.Unlock()
+	s.group.sendError(fmt.Errorf("failed to commit offsets: %w", err))
+}
+
+type franzClaim struct {
+	topic     string
+	partition int32
+	offset    int64
+	hwm       atomic.Int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *franzClaim) Topic() string { return c.topic }
+
+func (c *franzClaim) Partition() int32 { return c.partition }
+
+func (c *franzClaim) InitialOffset() int64 { return c.offset }
+
+func (c *franzClaim) HighWaterMarkOffset() int64 { return c.hwm.Load() }
+
+func (c *franzClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
@@ -0,0 +1,270 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrGroupActive is returned when resetting the offsets of a group that
+// still has members, which would overwrite them with their own commits.
+var ErrGroupActive = errors.New("consumer group has active members")
+
+// GroupSummary is a consumer group as ListGroups reports it.
+type GroupSummary struct {
+	GroupID      string
+	State        string
+	ProtocolType string
+	Members      int
+}
+
+// GroupMember is a member of a consumer group and what it was assigned.
+type GroupMember struct {
+	MemberID   string
+	InstanceID string
+	ClientID   string
+	Host       string
+	Assignment map[string][]int32
+}
+
+// GroupDescription is a consumer group's state and members.
+type GroupDescription struct {
+	GroupID string
+	State   string
+	// Protocol is the partition assignor the members agreed on, e.g.
+	// roundrobin.
+	Protocol string
+	Members  []GroupMember
+}
+
+// OffsetChange is a new committed offset for one partition. Current is -1
+// if the group has no committed offset for it.
+type OffsetChange struct {
+	Topic     string
+	Partition int32
+	Current   int64
+	New       int64
+}
+
+// GroupAdmin lists, describes and deletes consumer groups and resets their
+// committed offsets.
+type GroupAdmin struct {
+	client Client
+	sarama sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// NewGroupAdmin connects a GroupAdmin. Only SaramaBackend has one.
+func NewGroupAdmin(brokers []string, opts ...Option) (*GroupAdmin, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := saramaOf(client)
+	if !ok {
+		client.Close()
+		return nil, unsupported("group admins are", o.backend)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(sc)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	return &GroupAdmin{client: client, sarama: sc, admin: admin}, nil
+}
+
+// ListGroups returns every consumer group with its state, sorted by ID.
+func (a *GroupAdmin) ListGroups() ([]GroupSummary, error) {
+	groups, err := a.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumer groups: %w", err)
+	}
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	descriptions, err := a.admin.DescribeConsumerGroups(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe consumer groups: %w", err)
+	}
+	states := make(map[string]*sarama.GroupDescription, len(descriptions))
+	for _, d := range descriptions {
+		states[d.GroupId] = d
+	}
+
+	summaries := make([]GroupSummary, len(ids))
+	for i, id := range ids {
+		summaries[i] = GroupSummary{GroupID: id, ProtocolType: groups[id]}
+		if d := states[id]; d != nil {
+			summaries[i].State = d.State
+			summaries[i].Members = len(d.Members)
+		}
+	}
+	return summaries, nil
+}
+
+// DescribeGroup returns a group's state, members and their assignments.
+func (a *GroupAdmin) DescribeGroup(group string) (GroupDescription, error) {
+	d, err := a.describe(group)
+	if err != nil {
+		return GroupDescription{}, err
+	}
+	if d.State == "Dead" {
+		return GroupDescription{}, fmt.Errorf("consumer group %s does not exist", group)
+	}
+
+	description := GroupDescription{GroupID: group, State: d.State, Protocol: d.Protocol}
+	for _, m := range d.Members {
+		member := GroupMember{MemberID: m.MemberId, ClientID: m.ClientId, Host: m.ClientHost}
+		if m.GroupInstanceId != nil {
+			member.InstanceID = *m.GroupInstanceId
+		}
+		// Members of other protocol types, e.g. Connect workers, have
+		// assignments that aren't partitions.
+		if d.ProtocolType == "consumer" {
+			if assignment, err := m.GetMemberAssignment(); err == nil && assignment != nil {
+				member.Assignment = assignment.Topics
+			}
+		}
+		description.Members = append(description.Members, member)
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].MemberID < description.Members[j].MemberID
+	})
+	return description, nil
+}
+
+func (a *GroupAdmin) describe(group string) (*sarama.GroupDescription, error) {
+	descriptions, err := a.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe consumer group %s: %w", group, err)
+	}
+	if len(descriptions) != 1 {
+		return nil, fmt.Errorf("consumer group %s not found", group)
+	}
+	if d := descriptions[0]; d.Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("failed to describe consumer group %s: %w", group, d.Err)
+	}
+	return descriptions[0], nil
+}
+
+// Lag returns the group's committed offsets and lag on every topic it has
+// committed offsets for, like a LagMonitor without a topic.
+func (a *GroupAdmin) Lag(group string) ([]PartitionLag, error) {
+	monitor := &LagMonitor{client: a.client, admin: saramaAdmin{a.admin}, groupID: group}
+	return monitor.Lag()
+}
+
+// PlanReset returns the offset changes that move group to position on the
+// given partitions of topic, or on all of them if partitions is empty.
+// Nothing is changed until ApplyReset.
+func (a *GroupAdmin) PlanReset(group, topic string, partitions []int32, position Position) ([]OffsetChange, error) {
+	if err := a.client.RefreshMetadata(topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	all, err := a.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+	if len(partitions) == 0 {
+		partitions = all
+	}
+	for _, partition := range partitions {
+		if partition < 0 || int(partition) >= len(all) {
+			return nil, fmt.Errorf("%w: %d of %s, which has %d", ErrInvalidPartition, partition, topic, len(all))
+		}
+	}
+
+	committed, err := a.admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets for group %s: %w", group, err)
+	}
+
+	changes := make([]OffsetChange, 0, len(partitions))
+	for _, partition := range partitions {
+		offset, err := resolvePosition(a.client, topic, partition, position)
+		if err != nil {
+			return nil, err
+		}
+		change := OffsetChange{Topic: topic, Partition: partition, Current: -1, New: offset}
+		if block := committed.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+			change.Current = block.Offset
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Partition < changes[j].Partition })
+	return changes, nil
+}
+
+// ApplyReset commits the planned offsets for group, which may not exist
+// yet. The group must have no active members, otherwise ErrGroupActive is
+// returned.
+func (a *GroupAdmin) ApplyReset(group string, changes []OffsetChange) error {
+	d, err := a.describe(group)
+	if err != nil {
+		return err
+	}
+	if len(d.Members) > 0 {
+		return fmt.Errorf("%w: %s is %s with %d members, stop them first", ErrGroupActive, group, d.State, len(d.Members))
+	}
+
+	coordinator, err := a.sarama.Coordinator(group)
+	if err != nil {
+		return fmt.Errorf("failed to find the coordinator of group %s: %w", group, err)
+	}
+
+	// Commits from outside the group carry no generation, which the
+	// coordinator only accepts while the group is empty.
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	for _, change := range changes {
+		request.AddBlock(change.Topic, change.Partition, change.New, 0, "")
+	}
+
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets for group %s: %w", group, err)
+	}
+	for topic, partitions := range response.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("failed to commit offset of %s partition %d for group %s: %w", topic, partition, group, kerr)
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteGroup deletes group and its committed offsets. The broker refuses
+// to delete a group that has active members.
+func (a *GroupAdmin) DeleteGroup(group string) error {
+	if err := a.admin.DeleteConsumerGroup(group); err != nil {
+		return fmt.Errorf("failed to delete consumer group %s: %w", group, err)
+	}
+	return nil
+}
+
+// Close shuts down the admin, which also closes the underlying client.
+func (a *GroupAdmin) Close() error {
+	return a.admin.Close()
+}
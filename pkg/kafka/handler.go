@@ -0,0 +1,90 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Message is a consumed record as seen by a MessageHandler.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+
+	raw *sarama.ConsumerMessage
+}
+
+func newMessage(message *sarama.ConsumerMessage) *Message {
+	return &Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   MessageHeaders(message),
+		Timestamp: message.Timestamp,
+		raw:       message,
+	}
+}
+
+// Raw returns the underlying sarama message, e.g. for DecodeEvent.
+func (m *Message) Raw() *sarama.ConsumerMessage {
+	return m.raw
+}
+
+// MessageHandler processes a consumed message. Returning an error marks the
+// message as failed so it is retried and eventually dead-lettered. Handlers
+// that also implement io.Closer are closed with the consumer.
+type MessageHandler interface {
+	Handle(ctx context.Context, message *Message) error
+}
+
+// HandlerFunc adapts a function to the MessageHandler interface.
+type HandlerFunc func(ctx context.Context, message *Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, message *Message) error {
+	return f(ctx, message)
+}
+
+// WithHandler sets the handler consumers run for every message.
+func WithHandler(handler MessageHandler) Option {
+	return func(o *options) error {
+		o.handler = handler
+		return nil
+	}
+}
+
+// WithoutMessageLog stops consumers from logging every message they
+// receive, e.g. when a load test would drown everything else in it.
+func WithoutMessageLog() Option {
+	return func(o *options) error {
+		o.quiet = true
+		return nil
+	}
+}
+
+// WithMaxRetries sets how many times a failed message is retried before it
+// is dead-lettered or skipped.
+func WithMaxRetries(maxRetries int) Option {
+	return func(o *options) error {
+		o.maxRetries = maxRetries
+		return nil
+	}
+}
+
+// WithDeadLetterQueue publishes messages that still fail after all retries
+// to topic, with headers describing the failure.
+func WithDeadLetterQueue(topic string) Option {
+	return func(o *options) error {
+		o.dlqTopic = topic
+		o.config.Producer.Return.Successes = true
+		o.config.Producer.RequiredAcks = sarama.WaitForAll
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandlerFactory builds a handler from the argument that follows the colon
+// in a handler spec, e.g. the path in "file:/tmp/events.jsonl".
+type HandlerFactory func(arg string) (MessageHandler, error)
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]HandlerFactory{
+		"log": func(string) (MessageHandler, error) {
+			return LogHandler(), nil
+		},
+		"json-validate": func(string) (MessageHandler, error) {
+			return JSONValidateHandler(), nil
+		},
+		"file": func(path string) (MessageHandler, error) {
+			if path == "" {
+				return nil, errors.New("file handler needs a path, e.g. file:/tmp/events.jsonl")
+			}
+			return NewFileSink(path)
+		},
+	}
+)
+
+// RegisterHandler makes a handler available to NewHandlers under name, so
+// programs can plug in their own processing without changing the consumer.
+// It panics if name is already registered.
+func RegisterHandler(name string, factory HandlerFactory) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	if _, dup := handlers[name]; dup {
+		panic("kafka: RegisterHandler called twice for handler " + name)
+	}
+	handlers[name] = factory
+}
+
+// Handlers returns the names of the registered handlers.
+func Handlers() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewHandlers builds a chain from a comma-separated spec such as
+// "json-validate,log,file:/tmp/events.jsonl". Handlers run in the order given.
+func NewHandlers(spec string) (MessageHandler, error) {
+	var chain Chain
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(entry, ":")
+
+		handlersMu.RLock()
+		factory, ok := handlers[name]
+		handlersMu.RUnlock()
+		if !ok {
+			chain.Close()
+			return nil, fmt.Errorf("unknown handler %q (available: %s)", name, strings.Join(Handlers(), ", "))
+		}
+
+		handler, err := factory(arg)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("failed to create handler %s: %w", name, err)
+		}
+		chain = append(chain, handler)
+	}
+	return chain, nil
+}
+
+// Chain runs handlers in order and stops at the first error.
+type Chain []MessageHandler
+
+func (c Chain) Handle(ctx context.Context, message *Message) error {
+	for _, handler := range c {
+		if err := handler.Handle(ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every handler in the chain that implements io.Closer.
+func (c Chain) Close() error {
+	var errs []error
+	for _, handler := range c {
+		if closer, ok := handler.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TopicRouter sends each message to the handler registered for its topic,
+// so one consumer can serve several streams. Messages read from a retry
+// topic are routed by the topic they originally came from.
+type TopicRouter struct {
+	routes   map[string]MessageHandler
+	fallback MessageHandler
+}
+
+// NewTopicRouter creates a router that hands messages for topics without a
+// route to fallback. A nil fallback accepts them without doing anything.
+func NewTopicRouter(fallback MessageHandler) *TopicRouter {
+	return &TopicRouter{routes: make(map[string]MessageHandler), fallback: fallback}
+}
+
+// Route registers handler for messages from topic.
+func (r *TopicRouter) Route(topic string, handler MessageHandler) {
+	r.routes[topic] = handler
+}
+
+func (r *TopicRouter) Handle(ctx context.Context, message *Message) error {
+	topic := message.Topic
+	if original, ok := message.Headers[RetryOriginalTopicHeader]; ok {
+		topic = original
+	}
+
+	handler, ok := r.routes[topic]
+	if !ok {
+		handler = r.fallback
+	}
+	if handler == nil {
+		return nil
+	}
+	return handler.Handle(ctx, message)
+}
+
+// Close closes the routed handlers and the fallback that implement io.Closer.
+func (r *TopicRouter) Close() error {
+	chain := Chain{r.fallback}
+	for _, handler := range r.routes {
+		chain = append(chain, handler)
+	}
+	return chain.Close()
+}
+
+// NewTopicHandlers builds a router from a semicolon-separated list of
+// topic=handlers entries, e.g. "orders=json-validate,log;payments=file:/tmp/payments.jsonl".
+// Each handler list uses the NewHandlers syntax.
+func NewTopicHandlers(spec string, fallback MessageHandler) (*TopicRouter, error) {
+	router := NewTopicRouter(fallback)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		topic, handlerSpec, ok := strings.Cut(entry, "=")
+		topic = strings.TrimSpace(topic)
+		if !ok || topic == "" {
+			router.Close()
+			return nil, fmt.Errorf("invalid topic handlers %q, expected topic=handler,...", entry)
+		}
+
+		handler, err := NewHandlers(handlerSpec)
+		if err != nil {
+			router.Close()
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+		router.Route(topic, handler)
+	}
+	return router, nil
+}
+
+// LogHandler logs every message it sees.
+func LogHandler() MessageHandler {
+	return HandlerFunc(func(ctx context.Context, message *Message) error {
+		slog.Info("Handled message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "key", string(message.Key), "bytes", len(message.Value))
+		return nil
+	})
+}
+
+// JSONValidateHandler fails messages whose value is not valid JSON. Values
+// announced as another content type are passed through.
+func JSONValidateHandler() MessageHandler {
+	jsonContentType := JSONSerializer{}.ContentType()
+	return HandlerFunc(func(ctx context.Context, message *Message) error {
+		if contentType, ok := message.Headers[ContentTypeHeader]; ok && contentType != jsonContentType {
+			return nil
+		}
+		if !json.Valid(message.Value) {
+			return errors.New("value is not valid JSON")
+		}
+		return nil
+	})
+}
+
+// FileSink appends every message to a file as one JSON object per line.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+type fileRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Value     json.RawMessage   `json:"value,omitempty"`
+	RawValue  []byte            `json:"raw_value,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// NewFileSink opens path for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &FileSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Handle writes message to the file. JSON values are embedded as is, other
+// values are base64 encoded in raw_value.
+func (s *FileSink) Handle(ctx context.Context, message *Message) error {
+	record := fileRecord{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Headers:   message.Headers,
+		Timestamp: message.Timestamp,
+	}
+	if json.Valid(message.Value) {
+		record.Value = message.Value
+	} else {
+		record.RawValue = message.Value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(record); err != nil {
+		return fmt.Errorf("failed to write message to %s: %w", s.file.Name(), err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,108 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers attached to every event sent with SendEvent.
+const (
+	TraceIDHeader       = "trace-id"
+	SchemaVersionHeader = "schema-version"
+	EventTypeHeader     = "event-type"
+	ProducedAtHeader    = "produced-at"
+)
+
+// UserEventSchemaVersion is the version of the UserEvent layout, matching
+// the events/v1 Protobuf package. JSONSerializer can write newer layouts, see
+// LatestSchemaVersion.
+const UserEventSchemaVersion = "1"
+
+// EventHeaders returns the content type, event metadata and a fresh trace and
+// message ID for event. Entries in extra are added afterwards and replace defaults with
+// the same key, so callers can propagate an existing trace ID. Use it when
+// building producer messages by hand, e.g. in a Pipeline transform.
+func EventHeaders(serializer Serializer, event UserEvent, extra map[string]string) []sarama.RecordHeader {
+	version := UserEventSchemaVersion
+	if versioned, ok := serializer.(versionedSerializer); ok {
+		version = strconv.Itoa(versioned.SchemaVersion())
+	}
+
+	headers := map[string]string{
+		ContentTypeHeader:   serializer.ContentType(),
+		TraceIDHeader:       NewTraceID(),
+		MessageIDHeader:     NewUUID(),
+		SchemaVersionHeader: version,
+		EventTypeHeader:     event.EventType,
+		ProducedAtHeader:    time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for key, value := range extra {
+		headers[key] = value
+	}
+	return recordHeaders(headers)
+}
+
+// recordHeaders converts a header map to record headers, sorted by key so
+// the order on the wire is stable.
+func recordHeaders(headers map[string]string) []sarama.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]sarama.RecordHeader, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, stringHeader(key, headers[key]))
+	}
+	return records
+}
+
+// MessageHeaders returns the record headers of message as a map. If a key is
+// repeated the last value wins.
+func MessageHeaders(message *sarama.ConsumerMessage) map[string]string {
+	headers := make(map[string]string, len(message.Headers))
+	for _, h := range message.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	return headers
+}
+
+// NewTraceID returns a random 16-byte trace ID, hex encoded as in the W3C
+// trace context format.
+func NewTraceID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// ParseHeaders parses a comma-separated list of key=value pairs such as
+// "source=demo,env=dev".
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return headers, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", pair)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
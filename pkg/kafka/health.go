@@ -0,0 +1,143 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// HealthCheck answers Kubernetes liveness and readiness probes. Both fetch
+// metadata from the brokers over a client of its own; readiness can add
+// conditions such as the consumer holding partitions.
+type HealthCheck struct {
+	client Client
+	admin  Admin
+	topics []string
+
+	mu        sync.Mutex
+	readiness []namedCheck
+	ready     *bool
+}
+
+type namedCheck struct {
+	name  string
+	check func() error
+}
+
+// NewHealthCheck connects to brokers with opts. Probes refresh the metadata
+// of topics, or of every topic if there are none.
+func NewHealthCheck(brokers []string, topics []string, opts ...Option) (*HealthCheck, error) {
+	config := sarama.NewConfig()
+	// A probe should answer quickly rather than retry for a minute.
+	config.Metadata.Retry.Max = 0
+	config.Net.DialTimeout = 5 * time.Second
+	config.Net.ReadTimeout = 5 * time.Second
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create health check admin: %w", err)
+	}
+	return &HealthCheck{client: client, admin: admin, topics: topics}, nil
+}
+
+// AddReadiness makes readiness also depend on check.
+func (h *HealthCheck) AddReadiness(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+// Live checks that the brokers answer a metadata request.
+func (h *HealthCheck) Live() error {
+	if err := h.client.RefreshMetadata(h.topics...); err != nil {
+		return fmt.Errorf("brokers: %w", err)
+	}
+	brokers, err := h.admin.Brokers()
+	if err != nil {
+		return fmt.Errorf("brokers: %w", err)
+	}
+	if brokers == 0 {
+		return errors.New("brokers: no broker in the metadata")
+	}
+	return nil
+}
+
+// Ready checks Live and every readiness condition. Changes between ready
+// and not ready are logged.
+func (h *HealthCheck) Ready() error {
+	err := h.Live()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		for _, c := range h.readiness {
+			if cerr := c.check(); cerr != nil {
+				err = fmt.Errorf("%s: %w", c.name, cerr)
+				break
+			}
+		}
+	}
+
+	ready := err == nil
+	if h.ready == nil || *h.ready != ready {
+		if ready {
+			slog.Info("Readiness changed", "ready", true)
+		} else {
+			slog.Warn("Readiness changed", "ready", false, "reason", err)
+		}
+		h.ready = &ready
+	}
+	return err
+}
+
+// Handler serves /healthz and /readyz. A failing probe answers 503 with the
+// reason.
+func (h *HealthCheck) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, h.Live())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, h.Ready())
+	})
+	return mux
+}
+
+func (h *HealthCheck) Close() error {
+	h.admin.Close()
+	return h.client.Close()
+}
+
+type probeResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeProbe(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	response := probeResponse{Status: "ok"}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		response = probeResponse{Status: "failing", Error: err.Error()}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to write probe response", "error", err)
+	}
+}
@@ -0,0 +1,209 @@
+package kafka
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Heatmap is the number of messages of every key on every partition of a
+// topic, for charting the key space in other tools.
+type Heatmap struct {
+	Topic string `json:"topic,omitempty"`
+	// Partitions is the number of partitions Fairness is taken over.
+	Partitions int `json:"partitions"`
+	Keys       int `json:"keys"`
+	Messages   int `json:"messages"`
+	// Fairness is Jain's fairness index of the messages per partition: 1
+	// when every partition got the same, 1/Partitions when one got all.
+	Fairness float64 `json:"fairness"`
+	// Cells lists the key and partition pairs that got messages, by key and
+	// partition.
+	Cells []HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell is the number of messages of one key on one partition.
+type HeatmapCell struct {
+	Key       string `json:"key"`
+	Partition int32  `json:"partition"`
+	Messages  int    `json:"messages"`
+}
+
+// Heatmap returns the messages recorded per key and partition. Fairness is
+// taken over partitions partitions, idle ones included; 0 only counts the
+// partitions that got messages.
+func (t *PartitionTracker) Heatmap(partitions int) Heatmap {
+	t.mu.Lock()
+	cells := make(map[HeatmapCell]int)
+	perPartition := make(map[int32]int)
+	for key, seen := range t.partitions {
+		for _, p := range seen {
+			cells[HeatmapCell{Key: key, Partition: p}]++
+			perPartition[p]++
+		}
+	}
+	h := Heatmap{Topic: t.topic, Keys: len(t.partitions)}
+	t.mu.Unlock()
+
+	h.Partitions = max(partitions, len(perPartition))
+	h.Cells = make([]HeatmapCell, 0, len(cells))
+	for cell, n := range cells {
+		cell.Messages = n
+		h.Messages += n
+		h.Cells = append(h.Cells, cell)
+	}
+	sort.Slice(h.Cells, func(i, j int) bool {
+		if h.Cells[i].Key != h.Cells[j].Key {
+			return h.Cells[i].Key < h.Cells[j].Key
+		}
+		return h.Cells[i].Partition < h.Cells[j].Partition
+	})
+	h.Fairness = jainFairness(perPartition, h.Partitions)
+	return h
+}
+
+// jainFairness returns Jain's fairness index (Σx)² / (n·Σx²) of the counts
+// over n partitions, the ones missing from counts being idle, rounded to
+// three decimals. It is 0 without messages.
+func jainFairness(counts map[int32]int, n int) float64 {
+	var sum, squares float64
+	for _, c := range counts {
+		sum += float64(c)
+		squares += float64(c) * float64(c)
+	}
+	if n == 0 || squares == 0 {
+		return 0
+	}
+	return math.Round(sum*sum/(float64(n)*squares)*1000) / 1000
+}
+
+// WriteHeatmaps writes heatmaps to path, as CSV if it ends in .csv and as a
+// JSON array if it ends in .json. The CSV has one row per key and partition
+// with the columns topic, key, partition, messages and fairness, the index
+// of the row's topic. The file is replaced in one step, so a reader never
+// sees half of it.
+func WriteHeatmaps(path string, heatmaps []Heatmap) error {
+	format, err := heatmapFormat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if format == "csv" {
+		err = writeHeatmapCSV(tmp, heatmaps)
+	} else {
+		enc := json.NewEncoder(tmp)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(heatmaps)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write heatmap %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func heatmapFormat(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv", ".json":
+		return ext[1:], nil
+	default:
+		return "", fmt.Errorf("heatmap file %q must end in .csv or .json", path)
+	}
+}
+
+func writeHeatmapCSV(f *os.File, heatmaps []Heatmap) error {
+	w := csv.NewWriter(f)
+	w.Write([]string{"topic", "key", "partition", "messages", "fairness"})
+	for _, h := range heatmaps {
+		fairness := strconv.FormatFloat(h.Fairness, 'f', -1, 64)
+		for _, cell := range h.Cells {
+			w.Write([]string{h.Topic, cell.Key, strconv.Itoa(int(cell.Partition)), strconv.Itoa(cell.Messages), fairness})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// HeatmapExporter writes the heatmaps of a set of trackers to a file.
+type HeatmapExporter struct {
+	path       string
+	trackers   func() []*PartitionTracker
+	partitions func(topic string) int
+}
+
+// NewHeatmapExporter creates an exporter writing the heatmap of every
+// tracker trackers returns to path, see WriteHeatmaps. partitions returns
+// the partition count of a topic so idle partitions lower the fairness; it
+// may be nil.
+func NewHeatmapExporter(path string, trackers func() []*PartitionTracker, partitions func(topic string) int) (*HeatmapExporter, error) {
+	if _, err := heatmapFormat(path); err != nil {
+		return nil, err
+	}
+	return &HeatmapExporter{path: path, trackers: trackers, partitions: partitions}, nil
+}
+
+// Write writes the current heatmaps, skipping trackers without messages.
+func (e *HeatmapExporter) Write() ([]Heatmap, error) {
+	var heatmaps []Heatmap
+	for _, t := range e.trackers() {
+		if t.Len() == 0 {
+			continue
+		}
+		partitions := 0
+		if e.partitions != nil {
+			partitions = e.partitions(t.Topic())
+		}
+		heatmaps = append(heatmaps, t.Heatmap(partitions))
+	}
+	return heatmaps, WriteHeatmaps(e.path, heatmaps)
+}
+
+// Run writes the heatmaps every interval until ctx is done.
+func (e *HeatmapExporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := e.Write(); err != nil {
+				slog.Error("Failed to export heatmap", "path", e.path, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LogWrite writes the heatmaps once more, e.g. on shutdown, and logs the
+// fairness of each topic.
+func (e *HeatmapExporter) LogWrite() {
+	heatmaps, err := e.Write()
+	if err != nil {
+		slog.Error("Failed to export heatmap", "path", e.path, "error", err)
+		return
+	}
+	for _, h := range heatmaps {
+		var scope []any
+		if h.Topic != "" {
+			scope = []any{"topic", h.Topic}
+		}
+		slog.Info("Heatmap exported", append(scope, "path", e.path, "keys", h.Keys, "partitions", h.Partitions,
+			"messages", h.Messages, "fairness", h.Fairness)...)
+	}
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// WithIdempotence enables the idempotent producer. The broker tracks a
+// producer ID and per-partition sequence numbers, so a batch that is resent
+// after a lost acknowledgement is written only once. Idempotence needs
+// acks=all and a single in-flight request per broker to keep ordering.
+func WithIdempotence() Option {
+	return WithSaramaConfig(func(config *sarama.Config) {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			config.Version = sarama.V0_11_0_0
+		}
+	})
+}
+
+// LogProducerRetries routes sarama's internal retry messages to the
+// standard logger, annotated with what each retry means for duplicates.
+// sarama only reports retries through its package-level logger, so this
+// affects every producer in the process.
+func LogProducerRetries(idempotent bool) {
+	sarama.Logger = retryLogger{idempotent: idempotent}
+}
+
+type retryLogger struct {
+	idempotent bool
+}
+
+func (l retryLogger) Print(v ...interface{}) {
+	l.log(fmt.Sprint(v...))
+}
+
+func (l retryLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+func (l retryLogger) Println(v ...interface{}) {
+	l.log(fmt.Sprintln(v...))
+}
+
+func (l retryLogger) log(msg string) {
+	msg = strings.TrimSpace(msg)
+	if !strings.Contains(strings.ToLower(msg), "retrying") {
+		return
+	}
+
+	if l.idempotent {
+		slog.Warn("Producer retry", "detail", msg, "idempotent", true,
+			"note", "the broker drops any duplicate using the producer ID and sequence number")
+	} else {
+		slog.Warn("Producer retry", "detail", msg, "idempotent", false,
+			"note", "if the first attempt was written, this retry creates a duplicate")
+	}
+}
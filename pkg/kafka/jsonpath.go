@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a parsed path into a JSON document. It supports the subset of
+// JSONPath that addresses a single value: the root $, child names (.name or
+// ['name']) and array indices ([0]). The leading $ may be left out, so
+// user_id and $.user_id are the same path.
+type JSONPath struct {
+	source string
+	steps  []pathStep
+}
+
+type pathStep struct {
+	name  string
+	index int // -1 for object members
+}
+
+// ParseJSONPath parses path.
+func ParseJSONPath(path string) (*JSONPath, error) {
+	src := strings.TrimSpace(path)
+	rest := strings.TrimPrefix(src, "$")
+	if rest != src && rest != "" && rest[0] != '.' && rest[0] != '[' {
+		return nil, fmt.Errorf("invalid JSON path %q: expected . or [ after $", path)
+	}
+	if rest == src && rest != "" && rest[0] != '[' {
+		rest = "." + rest
+	}
+
+	p := &JSONPath{source: src}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: empty field name", path)
+			}
+			p.steps = append(p.steps, pathStep{name: rest[:end], index: -1})
+			rest = rest[end:]
+
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ]", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, pathStep{name: inner[1 : len(inner)-1], index: -1})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: %q is neither a quoted name nor an index", path, inner)
+			}
+			p.steps = append(p.steps, pathStep{index: index})
+
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q: unexpected %q", path, rest[0])
+		}
+	}
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("invalid JSON path %q: no field", path)
+	}
+	return p, nil
+}
+
+// Lookup returns the value the path addresses in doc, a document decoded
+// into maps and slices. ok is false if it doesn't exist.
+func (p *JSONPath) Lookup(doc any) (value any, ok bool) {
+	value = doc
+	for _, step := range p.steps {
+		if step.index < 0 {
+			object, isObject := value.(map[string]any)
+			if !isObject {
+				return nil, false
+			}
+			if value, ok = object[step.name]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		array, isArray := value.([]any)
+		if !isArray || step.index >= len(array) {
+			return nil, false
+		}
+		value = array[step.index]
+	}
+	return value, true
+}
+
+func (p *JSONPath) String() string {
+	return p.source
+}
+
+// NewFieldKeyFunc returns a key strategy that keys events by the value at
+// path in their JSON form, e.g. user_id or $.data.order.id. Strings are used
+// as they are and other values in their JSON encoding; events without the
+// field, or with null in it, are sent without a key.
+func NewFieldKeyFunc(path string) (KeyFunc, error) {
+	p, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(event UserEvent) (string, bool) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return "", false
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var doc any
+		if err := decoder.Decode(&doc); err != nil {
+			return "", false
+		}
+
+		value, ok := p.Lookup(doc)
+		if !ok || value == nil {
+			return "", false
+		}
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		key, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(key), true
+	}, nil
+}
+
+// WithKeyField keys events by the value at a JSON path, see NewFieldKeyFunc.
+// It replaces the key strategy.
+func WithKeyField(path string) Option {
+	return func(o *options) error {
+		fn, err := NewFieldKeyFunc(path)
+		if err != nil {
+			return err
+		}
+		o.keyFunc = fn
+		o.keyStrategy = "field:" + strings.TrimSpace(path)
+		return nil
+	}
+}
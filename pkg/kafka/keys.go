@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	KeyUserID    = "user_id"
+	KeySessionID = "session_id"
+	KeyComposite = "composite"
+	KeyNull      = "null"
+	KeyUUID      = "uuid"
+)
+
+// KeyStrategies lists the names NewKeyFunc accepts.
+var KeyStrategies = []string{KeyUserID, KeySessionID, KeyComposite, KeyNull, KeyUUID}
+
+// KeyFunc picks the message key of an event. ok is false for messages that
+// are sent without a key.
+type KeyFunc func(event UserEvent) (key string, ok bool)
+
+// NewKeyFunc returns the key strategy called name.
+//
+//   - user_id: the user ID, so all events of a user stay in order
+//   - session_id: the session_id in the event data, so only events of one session stay in order
+//   - composite: user ID and session ID joined by a colon, one ordered stream per user session
+//   - null: no key, the partitioner spreads messages without regard to who sent them
+//   - uuid: a new random key per message, spread like null keys but still hashed
+//
+// session_id and composite fall back to the user ID for events without a
+// session.
+func NewKeyFunc(name string) (KeyFunc, error) {
+	switch strings.ToLower(name) {
+	case "", KeyUserID:
+		return userIDKey, nil
+	case KeySessionID:
+		return sessionIDKey, nil
+	case KeyComposite:
+		return compositeKey, nil
+	case KeyNull:
+		return nullKey, nil
+	case KeyUUID:
+		return uuidKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported key strategy: %s", name)
+	}
+}
+
+// WithKeyStrategy selects how SendEvent keys messages, see NewKeyFunc for the
+// supported values. Defaults to the user ID.
+func WithKeyStrategy(name string) Option {
+	return func(o *options) error {
+		fn, err := NewKeyFunc(name)
+		if err != nil {
+			return err
+		}
+		o.keyFunc = fn
+		o.keyStrategy = strings.ToLower(name)
+		return nil
+	}
+}
+
+func userIDKey(event UserEvent) (string, bool) {
+	return event.UserID, true
+}
+
+func sessionIDKey(event UserEvent) (string, bool) {
+	if session := sessionID(event); session != "" {
+		return session, true
+	}
+	return event.UserID, true
+}
+
+func compositeKey(event UserEvent) (string, bool) {
+	if session := sessionID(event); session != "" {
+		return event.UserID + ":" + session, true
+	}
+	return event.UserID, true
+}
+
+func nullKey(UserEvent) (string, bool) {
+	return "", false
+}
+
+func uuidKey(UserEvent) (string, bool) {
+	return NewUUID(), true
+}
+
+func sessionID(event UserEvent) string {
+	if session, ok := event.Data["session_id"]; ok && session != nil {
+		return fmt.Sprint(session)
+	}
+	return ""
+}
+
+// eventKey returns the key fn picks for event, both as a message key and as
+// the string reported in a Delivery, which is empty without a key.
+func eventKey(fn KeyFunc, event UserEvent) (sarama.Encoder, string) {
+	key, ok := fn(event)
+	if !ok {
+		return nil, ""
+	}
+	return sarama.StringEncoder(key), key
+}
+
+// stringKey encodes key as a message key, or no key if it is empty.
+func stringKey(key string) sarama.Encoder {
+	if key == "" {
+		return nil
+	}
+	return sarama.StringEncoder(key)
+}
+
+// NewUUID returns a random version 4 UUID.
+func NewUUID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
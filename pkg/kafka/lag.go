@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// PartitionLag describes how far a consumer group is behind on one partition.
+// Committed is -1 when the group has not committed an offset yet, in which
+// case the lag counts from the oldest retained message.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	LogEnd    int64  `json:"log_end"`
+	Lag       int64  `json:"lag"`
+}
+
+// LagMonitor compares a group's committed offsets with the log-end offsets
+// of a topic, or of every topic the group has committed offsets for if the
+// topic is empty.
+type LagMonitor struct {
+	client  Client
+	admin   Admin
+	groupID string
+	topic   string
+}
+
+func NewLagMonitor(brokers []string, groupID, topic string, opts ...Option) (*LagMonitor, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lag monitor config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	return &LagMonitor{
+		client:  client,
+		admin:   admin,
+		groupID: groupID,
+		topic:   topic,
+	}, nil
+}
+
+// Lag returns the current lag of every partition, sorted by topic and
+// partition.
+func (m *LagMonitor) Lag() ([]PartitionLag, error) {
+	topics := []string{m.topic}
+	if m.topic == "" {
+		// A nil partition map fetches the offsets of every topic.
+		all, err := m.admin.ListGroupOffsets(m.groupID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch committed offsets for group %s: %w", m.groupID, err)
+		}
+		topics = topics[:0]
+		for topic := range all {
+			topics = append(topics, topic)
+		}
+	}
+
+	var lags []PartitionLag
+	for _, topic := range topics {
+		topicLags, err := m.topicLag(topic)
+		if err != nil {
+			return nil, err
+		}
+		lags = append(lags, topicLags...)
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].Topic != lags[j].Topic {
+			return lags[i].Topic < lags[j].Topic
+		}
+		return lags[i].Partition < lags[j].Partition
+	})
+	return lags, nil
+}
+
+func (m *LagMonitor) topicLag(topic string) ([]PartitionLag, error) {
+	if err := m.client.RefreshMetadata(topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+
+	partitions, err := m.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+
+	committed, err := m.admin.ListGroupOffsets(m.groupID, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets for group %s: %w", m.groupID, err)
+	}
+
+	lags := make([]PartitionLag, 0, len(partitions))
+	for _, partition := range partitions {
+		logEnd, err := m.client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch log-end offset for partition %d: %w", partition, err)
+		}
+
+		lag := PartitionLag{
+			Topic:     topic,
+			Partition: partition,
+			Committed: -1,
+			LogEnd:    logEnd,
+		}
+
+		if block, ok := committed[topic][partition]; ok && block.Offset >= 0 {
+			lag.Committed = block.Offset
+			lag.Lag = logEnd - block.Offset
+		} else {
+			oldest, err := m.client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch oldest offset for partition %d: %w", partition, err)
+			}
+			lag.Lag = logEnd - oldest
+		}
+
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
+
+func (m *LagMonitor) Close() error {
+	err := m.admin.Close()
+	if cerr := m.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
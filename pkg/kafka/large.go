@@ -0,0 +1,320 @@
+package kafka
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// Strategies for values over the limit of WithLargeMessages.
+const (
+	// LargeMessageNone sends every value as it is, and the broker rejects
+	// the ones over its max.message.bytes.
+	LargeMessageNone = "none"
+	// LargeMessageChunk splits a value into records of the same partition
+	// that consumers put back together.
+	LargeMessageChunk = "chunk"
+	// LargeMessageClaimCheck writes the value to a BlobStore and sends a
+	// reference to it instead.
+	LargeMessageClaimCheck = "claim-check"
+)
+
+// LargeMessageStrategies lists the strategies WithLargeMessages accepts.
+var LargeMessageStrategies = []string{LargeMessageNone, LargeMessageChunk, LargeMessageClaimCheck}
+
+// DefaultMaxValueBytes is the default limit of WithLargeMessages, below the
+// broker's default max.message.bytes of 1 MB to leave room for the key and
+// headers.
+const DefaultMaxValueBytes = 900_000
+
+// Headers of chunked and claim-checked messages. Every chunk carries the
+// headers of the original message as well.
+const (
+	ChunkIDHeader    = "chunk-id"
+	ChunkIndexHeader = "chunk-index"
+	ChunkCountHeader = "chunk-count"
+	ClaimCheckHeader = "claim-check"
+)
+
+// ErrNoBlobStore is returned when decoding a claim check without
+// WithBlobStore.
+var ErrNoBlobStore = errors.New("message is a claim check but no blob store is configured")
+
+// BlobStore keeps the values of claim-checked messages.
+type BlobStore interface {
+	// Put stores data and returns the reference consumers get it back by.
+	Put(data []byte) (ref string, err error)
+	Get(ref string) ([]byte, error)
+}
+
+// WithLargeMessages makes producers handle values over maxBytes with
+// strategy, one of LargeMessageStrategies, instead of letting the broker
+// reject them. Chunks go to the partition of the first one, whatever the
+// partitioner, and only Producer can send them; claim checks need
+// WithBlobStore. maxBytes 0 means DefaultMaxValueBytes. Values are
+// encrypted, if WithEncryption is set, before they are split or stored.
+func WithLargeMessages(strategy string, maxBytes int) Option {
+	return func(o *options) error {
+		switch strategy {
+		case "", LargeMessageNone:
+			strategy = LargeMessageNone
+		case LargeMessageChunk, LargeMessageClaimCheck:
+		default:
+			return fmt.Errorf("unknown large message strategy %q (want one of %s)", strategy, strings.Join(LargeMessageStrategies, ", "))
+		}
+		if maxBytes < 0 {
+			return fmt.Errorf("large message limit must not be negative, got %d", maxBytes)
+		}
+		if maxBytes == 0 {
+			maxBytes = DefaultMaxValueBytes
+		}
+		o.large.strategy = strategy
+		o.large.maxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithBlobStore sets where producers write the values of claim checks and
+// consumers read them from.
+func WithBlobStore(store BlobStore) Option {
+	return func(o *options) error {
+		o.large.store = store
+		return nil
+	}
+}
+
+// largeMessages is the producer side of WithLargeMessages. The zero value
+// sends every message as it is.
+type largeMessages struct {
+	strategy string
+	maxBytes int
+	store    BlobStore
+}
+
+func (l largeMessages) validate() error {
+	if l.strategy == LargeMessageClaimCheck && l.store == nil {
+		return fmt.Errorf("large message strategy %s needs a blob store", LargeMessageClaimCheck)
+	}
+	return nil
+}
+
+// prepare returns the records to send for msg: msg itself if its value is
+// within the limit, a claim check or its chunks otherwise.
+func (l largeMessages) prepare(msg *sarama.ProducerMessage) ([]*sarama.ProducerMessage, error) {
+	if l.strategy == "" || l.strategy == LargeMessageNone || msg.Value == nil || msg.Value.Length() <= l.maxBytes {
+		return []*sarama.ProducerMessage{msg}, nil
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	if l.strategy == LargeMessageClaimCheck {
+		ref, err := l.store.Put(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store claim check: %w", err)
+		}
+		claim := *msg
+		claim.Value = sarama.ByteEncoder{}
+		claim.Headers = append(msg.Headers[:len(msg.Headers):len(msg.Headers)], stringHeader(ClaimCheckHeader, ref))
+		return []*sarama.ProducerMessage{&claim}, nil
+	}
+
+	id := NewUUID()
+	count := (len(value) + l.maxBytes - 1) / l.maxBytes
+	chunks := make([]*sarama.ProducerMessage, count)
+	for i := range chunks {
+		chunk := *msg
+		chunk.Value = sarama.ByteEncoder(value[i*l.maxBytes : min((i+1)*l.maxBytes, len(value))])
+		chunk.Headers = append(msg.Headers[:len(msg.Headers):len(msg.Headers)],
+			stringHeader(ChunkIDHeader, id),
+			stringHeader(ChunkIndexHeader, strconv.Itoa(i)),
+			stringHeader(ChunkCountHeader, strconv.Itoa(count)),
+		)
+		chunks[i] = &chunk
+	}
+	return chunks, nil
+}
+
+// fetch returns the value of message, read from store if it is a claim
+// check.
+func (l largeMessages) fetch(message *sarama.ConsumerMessage) ([]byte, error) {
+	ref, ok := headerValue(message, ClaimCheckHeader)
+	if !ok {
+		return message.Value, nil
+	}
+	if l.store == nil {
+		return nil, ErrNoBlobStore
+	}
+	value, err := l.store.Get(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch claim check %s: %w", ref, err)
+	}
+	return value, nil
+}
+
+// pinnedChunk marks the chunks after the first one, which have to go to
+// the partition the first one went to.
+type pinnedChunk struct{}
+
+// pinChunks wraps a partitioner so it leaves pinned chunks in the partition
+// they were given.
+func pinChunks(constructor sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return chunkPartitioner{constructor(topic)}
+	}
+}
+
+type chunkPartitioner struct {
+	sarama.Partitioner
+}
+
+func (p chunkPartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if _, ok := msg.Metadata.(pinnedChunk); ok {
+		return msg.Partition, nil
+	}
+	return p.Partitioner.Partition(msg, numPartitions)
+}
+
+// maxChunkGroups bounds the messages a chunkAssembler waits for the rest
+// of, so chunks whose producer died half way through don't pile up.
+const maxChunkGroups = 64
+
+// chunkAssembler puts the chunks of one partition back together. The
+// committed offset stays at the first chunk of a message until all of it
+// has arrived and been processed, see first, so a consumer that takes the
+// partition over in the middle of a message reads all of it again.
+type chunkAssembler struct {
+	mu     sync.Mutex
+	groups map[string]*chunkGroup
+}
+
+type chunkGroup struct {
+	parts    [][]byte
+	received int
+	// first is the offset of the first chunk received.
+	first int64
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{groups: make(map[string]*chunkGroup)}
+}
+
+// add returns message if it isn't a chunk, the whole message if it is the
+// last missing chunk of one, and false otherwise. The whole message has the
+// value of all chunks and the offset of the last one, which is where it is
+// committed. A last chunk whose predecessors weren't seen, e.g. because the
+// group started in the middle of the message, drops the message.
+func (a *chunkAssembler) add(message *sarama.ConsumerMessage) (*sarama.ConsumerMessage, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, ok := headerValue(message, ChunkIDHeader)
+	if !ok {
+		return message, true
+	}
+	index, indexErr := strconv.Atoi(headerOr(message, ChunkIndexHeader))
+	count, countErr := strconv.Atoi(headerOr(message, ChunkCountHeader))
+	if indexErr != nil || countErr != nil || count < 1 || index < 0 || index >= count {
+		slog.Warn("Invalid chunk headers, handling the chunk as a message of its own", "topic", message.Topic,
+			"partition", message.Partition, "offset", message.Offset, "chunk_id", id)
+		return message, true
+	}
+
+	group, ok := a.groups[id]
+	if !ok {
+		if len(a.groups) >= maxChunkGroups {
+			slog.Warn("Too many incomplete chunked messages, dropping them", "topic", message.Topic,
+				"partition", message.Partition, "messages", len(a.groups))
+			clear(a.groups)
+		}
+		group = &chunkGroup{parts: make([][]byte, count), first: message.Offset}
+		a.groups[id] = group
+	}
+	if index < len(group.parts) && group.parts[index] == nil {
+		group.parts[index] = message.Value
+		group.received++
+	}
+	if group.received < len(group.parts) {
+		if index == count-1 {
+			delete(a.groups, id)
+			slog.Warn("Incomplete chunked message dropped", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "chunk_id", id, "chunks", count, "received", group.received)
+		}
+		return nil, false
+	}
+	delete(a.groups, id)
+
+	whole := *message
+	whole.Value = bytes.Join(group.parts, nil)
+	whole.Headers = make([]*sarama.RecordHeader, 0, len(message.Headers))
+	for _, h := range message.Headers {
+		switch string(h.Key) {
+		case ChunkIDHeader, ChunkIndexHeader, ChunkCountHeader:
+		default:
+			whole.Headers = append(whole.Headers, h)
+		}
+	}
+	return &whole, true
+}
+
+// first returns the offset of the first chunk of the oldest message still
+// missing chunks, and false if there is none. Marks past it are held back
+// to it.
+func (a *chunkAssembler) first() (int64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	first, ok := int64(0), false
+	for _, group := range a.groups {
+		if !ok || group.first < first {
+			first, ok = group.first, true
+		}
+	}
+	return first, ok
+}
+
+func headerOr(message *sarama.ConsumerMessage, key string) string {
+	value, _ := headerValue(message, key)
+	return value
+}
+
+// FileBlobStore keeps claim checks as files in a directory, which every
+// producer and consumer has to see, e.g. on a shared volume. Nothing is
+// deleted; clean the directory up along with the topic's retention.
+type FileBlobStore struct {
+	Dir string
+}
+
+func (s FileBlobStore) Put(data []byte) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", err
+	}
+	ref := NewUUID()
+	// Written under a temporary name first, so a consumer never reads half
+	// of a blob.
+	tmp := filepath.Join(s.Dir, "."+ref)
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, ref)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s FileBlobStore) Get(ref string) ([]byte, error) {
+	if ref == "" || ref != filepath.Base(ref) || strings.HasPrefix(ref, ".") {
+		return nil, fmt.Errorf("invalid claim check reference %q", ref)
+	}
+	return os.ReadFile(filepath.Join(s.Dir, ref))
+}
@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func chunkMessage(offset int64, id string, index, count int, value string) *sarama.ConsumerMessage {
+	message := watermarkMessage(offset)
+	message.Value = []byte(value)
+	message.Headers = []*sarama.RecordHeader{
+		{Key: []byte(ChunkIDHeader), Value: []byte(id)},
+		{Key: []byte(ChunkIndexHeader), Value: []byte(strconv.Itoa(index))},
+		{Key: []byte(ChunkCountHeader), Value: []byte(strconv.Itoa(count))},
+	}
+	return message
+}
+
+func TestChunksHoldCommittedOffset(t *testing.T) {
+	session := newSaramaSession("events", map[int32]int64{0: 10})
+	p := &claimPipeline{
+		chunks:  newChunkAssembler(),
+		offsets: newCommitter(CommitStrategy{Mode: CommitAuto}, session).claim("events", 0),
+	}
+
+	// The chunks of a and b interleave with each other and with a message
+	// of another producer.
+	steps := []struct {
+		message *sarama.ConsumerMessage
+		want    int64
+	}{
+		{chunkMessage(10, "a", 0, 2, "a0"), 10},
+		{watermarkMessage(11), 10},
+		{chunkMessage(12, "b", 0, 2, "b0"), 10},
+		// a is complete, but b's first chunk still holds the offset.
+		{chunkMessage(13, "a", 1, 2, "a1"), 12},
+		{chunkMessage(14, "b", 1, 2, "b1"), 15},
+	}
+	for _, step := range steps {
+		if whole, complete := p.chunks.add(step.message); complete {
+			p.mark(whole)
+		} else {
+			p.skip(step.message)
+		}
+		if got := session.next[0]; got != step.want {
+			t.Fatalf("after offset %d the partition resumes from %d, want %d", step.message.Offset, got, step.want)
+		}
+	}
+}
+
+func TestProcessorChunksLargeValues(t *testing.T) {
+	broker := NewMemoryBroker(3)
+	config := sarama.NewConfig()
+	config.Producer.Partitioner = pinChunks(sarama.NewRoundRobinPartitioner)
+	client, err := broker.Connect(nil, newClientConfig(config))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	producer, err := client.NewSyncProducer()
+	if err != nil {
+		t.Fatalf("NewSyncProducer: %v", err)
+	}
+	p := processor{
+		dlqTopic: "events-dlq",
+		producer: producer,
+		large:    largeMessages{strategy: LargeMessageChunk, maxBytes: 4},
+	}
+
+	value := []byte("0123456789")
+	message := watermarkMessage(7)
+	message.Value = value
+	if err := p.deadLetter(message, errors.New("failed"), 1); err != nil {
+		t.Fatalf("deadLetter: %v", err)
+	}
+
+	for partition := int32(0); partition < 3; partition++ {
+		chunks, _ := broker.read("events-dlq", partition, 0)
+		if len(chunks) == 0 {
+			continue
+		}
+		if len(chunks) != 3 {
+			t.Fatalf("partition %d has %d of the chunks, want all 3", partition, len(chunks))
+		}
+		assembler := newChunkAssembler()
+		for _, chunk := range chunks {
+			if len(chunk.Value) > 4 {
+				t.Errorf("chunk at offset %d has %d bytes, over the limit of 4", chunk.Offset, len(chunk.Value))
+			}
+			if whole, complete := assembler.add(chunk); complete && !bytes.Equal(whole.Value, value) {
+				t.Errorf("dead letter reassembles to %q, want %q", whole.Value, value)
+			}
+		}
+		return
+	}
+	t.Fatal("nothing was dead-lettered")
+}
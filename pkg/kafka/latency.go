@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// WithLatencyReport makes consumers log the end-to-end latency of the
+// messages they read, from the produced-at header to the moment the message
+// arrived, as p50, p95, p99 and max per partition every interval and once
+// more when they stop. Messages without the header, i.e. not sent with
+// SendEvent, are measured from their record timestamp. Producer and
+// consumer clocks have to be in sync for the numbers to mean anything.
+// Independently of the report, every latency goes to Metrics.
+func WithLatencyReport(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("latency report interval must be positive, got %s", interval)
+		}
+		o.latencyReport = interval
+		return nil
+	}
+}
+
+// endToEndLatency returns how long message took from its producer to now.
+func endToEndLatency(message *sarama.ConsumerMessage, now time.Time) (time.Duration, bool) {
+	if value, ok := headerValue(message, ProducedAtHeader); ok {
+		if producedAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return now.Sub(producedAt), true
+		}
+	}
+	if message.Timestamp.IsZero() || message.Timestamp.Unix() <= 0 {
+		return 0, false
+	}
+	return now.Sub(message.Timestamp), true
+}
+
+// maxLatencySamples bounds the latencies a latencyWindow keeps per
+// partition. Past it, samples are replaced at random so the percentiles
+// still cover the whole interval.
+const maxLatencySamples = 100_000
+
+// latencyWindow collects the latencies of one report interval. A nil
+// window only feeds the metrics.
+type latencyWindow struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*latencySamples
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+type latencySamples struct {
+	count   int64
+	max     time.Duration
+	samples []time.Duration
+}
+
+// latencyStats is the summary of one partition in a report.
+type latencyStats struct {
+	topic              string
+	partition          int32
+	count              int64
+	p50, p95, p99, max time.Duration
+}
+
+func newLatencyWindow(interval time.Duration) *latencyWindow {
+	if interval <= 0 {
+		return nil
+	}
+	return &latencyWindow{interval: interval, partitions: make(map[partitionKey]*latencySamples)}
+}
+
+// observe measures the latency of message, which arrived now, and reports
+// it to metrics.
+func (w *latencyWindow) observe(metrics Metrics, message *sarama.ConsumerMessage, now time.Time) {
+	latency, ok := endToEndLatency(message, now)
+	if !ok {
+		return
+	}
+	metrics.EndToEndLatency(message.Topic, message.Partition, latency)
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tp := partitionKey{message.Topic, message.Partition}
+	s, ok := w.partitions[tp]
+	if !ok {
+		s = &latencySamples{}
+		w.partitions[tp] = s
+	}
+	s.count++
+	s.max = max(s.max, latency)
+	if len(s.samples) < maxLatencySamples {
+		s.samples = append(s.samples, latency)
+	} else if i := rand.Int63n(s.count); i < maxLatencySamples {
+		s.samples[i] = latency
+	}
+}
+
+// flush returns the stats of the interval so far, sorted by topic and
+// partition, and starts a new one.
+func (w *latencyWindow) flush() []latencyStats {
+	w.mu.Lock()
+	partitions := w.partitions
+	w.partitions = make(map[partitionKey]*latencySamples)
+	w.mu.Unlock()
+
+	stats := make([]latencyStats, 0, len(partitions))
+	for tp, s := range partitions {
+		sort.Slice(s.samples, func(i, j int) bool { return s.samples[i] < s.samples[j] })
+		stats = append(stats, latencyStats{
+			topic:     tp.topic,
+			partition: tp.partition,
+			count:     s.count,
+			p50:       latencyPercentile(s.samples, 0.50),
+			p95:       latencyPercentile(s.samples, 0.95),
+			p99:       latencyPercentile(s.samples, 0.99),
+			max:       s.max,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].topic != stats[j].topic {
+			return stats[i].topic < stats[j].topic
+		}
+		return stats[i].partition < stats[j].partition
+	})
+	return stats
+}
+
+// run logs a report every interval until ctx is done.
+func (w *latencyWindow) run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.log()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// log logs the report of the interval so far and starts a new one.
+func (w *latencyWindow) log() {
+	if w == nil {
+		return
+	}
+	for _, s := range w.flush() {
+		slog.Info("End-to-end latency", "topic", s.topic, "partition", s.partition, "messages", s.count,
+			"p50", s.p50, "p95", s.p95, "p99", s.p99, "max", s.max, "interval", w.interval)
+	}
+}
+
+// latencyPercentile returns the p-th percentile of sorted using the
+// nearest-rank method.
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank].Round(time.Microsecond)
+}
@@ -0,0 +1,757 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrMemoryUnsupported is returned for features the memory broker doesn't
+// have, such as transactions and topic patterns. It is an ErrUnsupported.
+var ErrMemoryUnsupported = fmt.Errorf("%w by the memory broker", ErrUnsupported)
+
+var errMemoryTransactions = fmt.Errorf("transactions are %w", ErrMemoryUnsupported)
+
+// MemoryBroker is an in-process stand-in for a Kafka cluster. Producers and
+// consumers created WithMemoryBroker write to and read from it instead of
+// connecting to brokers, so the demos run without Kafka or Docker: the same
+// partitioners pick the partitions, offsets are assigned per partition and
+// consumer groups split the partitions between their members and keep
+// committed offsets. Everything is lost when the process exits.
+type MemoryBroker struct {
+	partitions int32
+
+	mu     sync.Mutex
+	topics map[string][][]*sarama.ConsumerMessage
+	groups map[string]*memoryGroup
+	// appended is closed and replaced whenever a message is appended, which
+	// wakes up the claims waiting for new messages.
+	appended chan struct{}
+	members  int
+}
+
+// NewMemoryBroker creates an empty broker. Topics are created on first use
+// with partitions partitions, unless CreateTopic created them before.
+func NewMemoryBroker(partitions int32) *MemoryBroker {
+	if partitions < 1 {
+		partitions = 1
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     make(map[string][][]*sarama.ConsumerMessage),
+		groups:     make(map[string]*memoryGroup),
+		appended:   make(chan struct{}),
+	}
+}
+
+// WithMemoryBroker makes producers and consumers use broker instead of the
+// cluster; the broker addresses and connection settings are ignored. It is
+// WithBackend(broker).
+func WithMemoryBroker(broker *MemoryBroker) Option {
+	return WithBackend(broker)
+}
+
+// Name returns "memory".
+func (b *MemoryBroker) Name() string { return "memory" }
+
+// Connect returns a client of b; brokers and the connection settings of
+// config are ignored.
+func (b *MemoryBroker) Connect(_ []string, config ClientConfig) (Client, error) {
+	return &memoryClient{broker: b, config: config}, nil
+}
+
+// memoryClient is a Client of the memory broker. It has no readers or
+// admins.
+type memoryClient struct {
+	broker *MemoryBroker
+	config ClientConfig
+}
+
+func (c *memoryClient) Partitions(topic string) ([]int32, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	partitions := make([]int32, len(c.broker.topic(topic)))
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *memoryClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	return c.broker.GetOffset(topic, partition, time)
+}
+
+func (c *memoryClient) RefreshMetadata(...string) error { return nil }
+
+func (c *memoryClient) NewReader() (Reader, error) {
+	return nil, fmt.Errorf("partition readers are %w", ErrMemoryUnsupported)
+}
+
+func (c *memoryClient) NewAdmin() (Admin, error) {
+	return nil, fmt.Errorf("cluster admins are %w", ErrMemoryUnsupported)
+}
+
+func (c *memoryClient) Close() error { return nil }
+
+// CreateTopic creates topic unless it exists and reports whether it was
+// created.
+func (b *MemoryBroker) CreateTopic(topic string, partitions int32) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topic]; ok {
+		return false
+	}
+	b.topics[topic] = make([][]*sarama.ConsumerMessage, max(partitions, 1))
+	return true
+}
+
+// Partitions returns the number of partitions of topic, 0 if it doesn't
+// exist yet.
+func (b *MemoryBroker) Partitions(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic])
+}
+
+// topic returns the partitions of topic, creating it if needed. b.mu must
+// be held.
+func (b *MemoryBroker) topic(name string) [][]*sarama.ConsumerMessage {
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([][]*sarama.ConsumerMessage, b.partitions)
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+// GetOffset resolves sarama.OffsetOldest, sarama.OffsetNewest or a
+// timestamp in milliseconds like Client.GetOffset.
+func (b *MemoryBroker) GetOffset(topic string, partition int32, position int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	partitions, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(partitions) {
+		return 0, sarama.ErrUnknownTopicOrPartition
+	}
+	messages := partitions[partition]
+	switch position {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(messages)), nil
+	}
+	for _, message := range messages {
+		if message.Timestamp.UnixMilli() >= position {
+			return message.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// Lag returns the lag of group groupID on every partition of topic, like
+// LagMonitor.Lag does for a cluster.
+func (b *MemoryBroker) Lag(groupID, topic string) ([]PartitionLag, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var committed map[int32]int64
+	if group, ok := b.groups[groupID]; ok {
+		committed = group.offsets[topic]
+	}
+	partitions := b.topic(topic)
+	lags := make([]PartitionLag, len(partitions))
+	for i, messages := range partitions {
+		lag := PartitionLag{
+			Topic:     topic,
+			Partition: int32(i),
+			Committed: -1,
+			LogEnd:    int64(len(messages)),
+			Lag:       int64(len(messages)),
+		}
+		if offset, ok := committed[int32(i)]; ok {
+			lag.Committed = offset
+			lag.Lag -= offset
+		}
+		lags[i] = lag
+	}
+	return lags, nil
+}
+
+// append writes msg to the partition partitioner picks and fills in its
+// partition and offset.
+func (b *MemoryBroker) append(msg *sarama.ProducerMessage, partitioner sarama.Partitioner) error {
+	var key, value []byte
+	var err error
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+	headers := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		headers[i] = &msg.Headers[i]
+	}
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	// Kafka keeps milliseconds.
+	timestamp = timestamp.Truncate(time.Millisecond)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	partitions := b.topic(msg.Topic)
+	partition, err := partitioner.Partition(msg, int32(len(partitions)))
+	if err != nil {
+		return err
+	}
+	if partition < 0 || int(partition) >= len(partitions) {
+		return sarama.ErrInvalidPartition
+	}
+
+	offset := int64(len(partitions[partition]))
+	partitions[partition] = append(partitions[partition], &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: timestamp,
+	})
+	msg.Partition, msg.Offset = partition, offset
+
+	close(b.appended)
+	b.appended = make(chan struct{})
+	return nil
+}
+
+// read returns the messages of a partition from offset on, and a channel
+// that is closed when more arrive.
+func (b *MemoryBroker) read(topic string, partition int32, offset int64) ([]*sarama.ConsumerMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := b.topic(topic)[partition]
+	if offset >= int64(len(messages)) {
+		return nil, b.appended
+	}
+	return messages[max(offset, 0):], b.appended
+}
+
+// NewSyncProducer returns a producer that appends to the broker, choosing
+// partitions with the partitioner of the config.
+func (c *memoryClient) NewSyncProducer() (SyncProducer, error) {
+	if c.config.Producer.TransactionalID != "" {
+		return nil, errMemoryTransactions
+	}
+	newPartition := c.config.Producer.Partitioner
+	if newPartition == nil {
+		newPartition = sarama.NewHashPartitioner
+	}
+	return &memoryProducer{
+		broker:       c.broker,
+		newPartition: newPartition,
+		partitioners: make(map[string]sarama.Partitioner),
+	}, nil
+}
+
+type memoryProducer struct {
+	broker       *MemoryBroker
+	newPartition sarama.PartitionerConstructor
+
+	mu           sync.Mutex
+	partitioners map[string]sarama.Partitioner
+}
+
+func (p *memoryProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	partitioner, ok := p.partitioners[msg.Topic]
+	if !ok {
+		partitioner = p.newPartition(msg.Topic)
+		p.partitioners[msg.Topic] = partitioner
+	}
+	p.mu.Unlock()
+
+	if err := p.broker.append(msg, partitioner); err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+func (p *memoryProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *memoryProducer) Close() error { return nil }
+
+func (p *memoryProducer) IsTransactional() bool { return false }
+
+func (p *memoryProducer) BeginTxn() error { return errMemoryTransactions }
+
+func (p *memoryProducer) CommitTxn() error { return errMemoryTransactions }
+
+func (p *memoryProducer) AbortTxn() error { return errMemoryTransactions }
+
+func (p *memoryProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return errMemoryTransactions
+}
+
+// memoryGroup is a consumer group: its members in join order, the current
+// generation and the committed offsets. A new generation starts whenever a
+// member joins or leaves, and no session of it starts before every session
+// of the previous one ended, so a partition is never claimed twice.
+type memoryGroup struct {
+	members    []*memoryConsumerGroup
+	generation int32
+	offsets    map[string]map[int32]int64
+	// running holds the generation of every member's current session.
+	running map[*memoryConsumerGroup]int32
+	ended   *sync.Cond
+}
+
+// NewConsumerGroup returns a member of group groupID.
+func (c *memoryClient) NewConsumerGroup(groupID string) (ConsumerGroup, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.groups[groupID]; !ok {
+		b.groups[groupID] = &memoryGroup{
+			offsets: make(map[string]map[int32]int64),
+			running: make(map[*memoryConsumerGroup]int32),
+			ended:   sync.NewCond(&b.mu),
+		}
+	}
+	b.members++
+	return &memoryConsumerGroup{
+		broker:   b,
+		groupID:  groupID,
+		memberID: fmt.Sprintf("%s-memory-%d", c.config.ClientID, b.members),
+		config:   c.config.Consumer,
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+		paused:   make(map[string]map[int32]bool),
+	}, nil
+}
+
+type memoryConsumerGroup struct {
+	broker   *MemoryBroker
+	groupID  string
+	memberID string
+	config   ConsumerConfig
+	errors   chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	// rebalance is closed to end the current session when the group's
+	// membership changes. It is guarded by broker.mu.
+	rebalance chan struct{}
+
+	pauseMu sync.Mutex
+	paused  map[string]map[int32]bool
+}
+
+func (c *memoryConsumerGroup) Consume(ctx context.Context, topics []string, handler GroupHandler) error {
+	select {
+	case <-c.closed:
+		return sarama.ErrClosedConsumerGroup
+	default:
+	}
+	if len(topics) == 0 {
+		return fmt.Errorf("no topics provided")
+	}
+
+	session, err := c.join(ctx, topics)
+	if err != nil {
+		return err
+	}
+	defer c.leaveSession()
+
+	if err := handler.Setup(session); err != nil {
+		session.cancel()
+		handler.Cleanup(session)
+		session.commit()
+		return err
+	}
+
+	// As in sarama, the session ends as soon as the first claim returns.
+	var wg sync.WaitGroup
+	for topic, partitions := range session.claims {
+		for _, partition := range partitions {
+			claim := c.claim(session, topic, partition)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer session.cancel()
+				if err := handler.ConsumeClaim(session, claim); err != nil {
+					c.sendError(err)
+				}
+			}()
+		}
+	}
+
+	<-session.ctx.Done()
+	wg.Wait()
+	err = handler.Cleanup(session)
+	session.commit()
+	return err
+}
+
+// join waits for the previous generation to end and starts a session with
+// the partitions assigned to c in the current one.
+func (c *memoryConsumerGroup) join(ctx context.Context, topics []string) (*memorySession, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	group := b.groups[c.groupID]
+	member := false
+	for _, m := range group.members {
+		member = member || m == c
+	}
+	if !member {
+		group.members = append(group.members, c)
+		group.rebalanced()
+	}
+	for group.previousRunning() {
+		group.ended.Wait()
+	}
+
+	// Round robin over every partition of the subscribed topics, like
+	// sarama's default strategy. With RebalanceSingleActive the longest
+	// standing member gets them all, so the next one in line takes over
+	// when it leaves.
+	sorted := append([]string(nil), topics...)
+	sort.Strings(sorted)
+	index := 0
+	for i, m := range group.members {
+		if m == c {
+			index = i
+		}
+	}
+	singleActive := c.config.Rebalance == RebalanceSingleActive
+	claims := make(map[string][]int32)
+	n := 0
+	for _, topic := range sorted {
+		for partition := range b.topic(topic) {
+			if singleActive && index == 0 || !singleActive && n%len(group.members) == index {
+				claims[topic] = append(claims[topic], int32(partition))
+			}
+			n++
+		}
+	}
+
+	c.rebalance = make(chan struct{})
+	group.running[c] = group.generation
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	rebalance := c.rebalance
+	go func() {
+		select {
+		case <-rebalance:
+		case <-c.closed:
+		case <-sessionCtx.Done():
+		}
+		cancel()
+	}()
+
+	session := &memorySession{
+		group:      group,
+		broker:     b,
+		memberID:   c.memberID,
+		generation: group.generation,
+		claims:     claims,
+		ctx:        sessionCtx,
+		cancel:     cancel,
+		autoCommit: c.config.AutoCommit,
+		marked:     make(map[string]map[int32]int64),
+	}
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			offset, ok := group.offsets[topic][partition]
+			if !ok {
+				offset = 0
+				if c.config.InitialOffset == sarama.OffsetNewest {
+					offset = int64(len(b.topic(topic)[partition]))
+				}
+			}
+			session.setMarked(topic, partition, offset)
+		}
+	}
+	return session, nil
+}
+
+// leaveSession marks c's session as ended.
+func (c *memoryConsumerGroup) leaveSession() {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	group := c.broker.groups[c.groupID]
+	delete(group.running, c)
+	group.ended.Broadcast()
+}
+
+// rebalanced starts a new generation and ends the sessions of the old one.
+// broker.mu must be held.
+func (g *memoryGroup) rebalanced() {
+	g.generation++
+	for _, m := range g.members {
+		if m.rebalance != nil {
+			select {
+			case <-m.rebalance:
+			default:
+				close(m.rebalance)
+			}
+		}
+	}
+}
+
+// previousRunning reports whether a session of an earlier generation is
+// still running. broker.mu must be held.
+func (g *memoryGroup) previousRunning() bool {
+	for _, generation := range g.running {
+		if generation < g.generation {
+			return true
+		}
+	}
+	return false
+}
+
+// claim feeds the messages of a partition from the session's offset until
+// the session ends.
+func (c *memoryConsumerGroup) claim(session *memorySession, topic string, partition int32) *memoryClaim {
+	claim := &memoryClaim{
+		broker:    c.broker,
+		topic:     topic,
+		partition: partition,
+		offset:    session.offset(topic, partition),
+		messages:  make(chan *sarama.ConsumerMessage, memoryClaimBuffer),
+	}
+	go func() {
+		defer close(claim.messages)
+		offset := claim.offset
+		for {
+			var pending []*sarama.ConsumerMessage
+			var appended <-chan struct{}
+			var resume <-chan time.Time
+			if c.isPaused(topic, partition) {
+				resume = time.After(100 * time.Millisecond)
+			} else {
+				pending, appended = c.broker.read(topic, partition, offset)
+			}
+			for _, message := range pending {
+				select {
+				case claim.messages <- message:
+					offset = message.Offset + 1
+				case <-session.ctx.Done():
+					return
+				}
+			}
+			if len(pending) > 0 {
+				continue
+			}
+			select {
+			case <-appended:
+			case <-resume:
+			case <-session.ctx.Done():
+				return
+			}
+		}
+	}()
+	return claim
+}
+
+func (c *memoryConsumerGroup) sendError(err error) {
+	select {
+	case c.errors <- err:
+	case <-c.closed:
+	case <-time.After(time.Second):
+	}
+}
+
+func (c *memoryConsumerGroup) Errors() <-chan error { return c.errors }
+
+// Close leaves the group, which rebalances the other members.
+func (c *memoryConsumerGroup) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		b := c.broker
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		group := b.groups[c.groupID]
+		for i, m := range group.members {
+			if m == c {
+				group.members = append(group.members[:i], group.members[i+1:]...)
+				group.rebalanced()
+				break
+			}
+		}
+	})
+	return nil
+}
+
+func (c *memoryConsumerGroup) Pause(partitions map[string][]int32) {
+	c.setPaused(partitions, true)
+}
+
+func (c *memoryConsumerGroup) Resume(partitions map[string][]int32) {
+	c.setPaused(partitions, false)
+}
+
+func (c *memoryConsumerGroup) PauseAll() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	c.paused[""] = map[int32]bool{-1: true}
+}
+
+func (c *memoryConsumerGroup) ResumeAll() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	c.paused = make(map[string]map[int32]bool)
+}
+
+func (c *memoryConsumerGroup) setPaused(partitions map[string][]int32, paused bool) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	for topic, list := range partitions {
+		if c.paused[topic] == nil {
+			c.paused[topic] = make(map[int32]bool)
+		}
+		for _, partition := range list {
+			c.paused[topic][partition] = paused
+		}
+	}
+}
+
+func (c *memoryConsumerGroup) isPaused(topic string, partition int32) bool {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	return c.paused[""][-1] || c.paused[topic][partition]
+}
+
+// memorySession is a GroupSession of the memory broker.
+// Marked offsets are committed right away with auto-commit, otherwise on
+// Commit and when the session ends. Like sarama's, MarkOffset only moves an
+// offset forward and ResetOffset only rewinds it.
+type memorySession struct {
+	group      *memoryGroup
+	broker     *MemoryBroker
+	memberID   string
+	generation int32
+	claims     map[string][]int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+	autoCommit bool
+
+	mu     sync.Mutex
+	marked map[string]map[int32]int64
+}
+
+func (s *memorySession) Claims() map[string][]int32 { return s.claims }
+
+func (s *memorySession) MemberID() string { return s.memberID }
+
+func (s *memorySession) GenerationID() int32 { return s.generation }
+
+func (s *memorySession) Context() context.Context { return s.ctx }
+
+func (s *memorySession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	if offset > s.marked[topic][partition] {
+		s.setMarked(topic, partition, offset)
+	}
+	s.mu.Unlock()
+	if s.autoCommit {
+		s.commit()
+	}
+}
+
+func (s *memorySession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	if offset <= s.marked[topic][partition] {
+		s.setMarked(topic, partition, offset)
+	}
+	s.mu.Unlock()
+	if s.autoCommit {
+		s.commit()
+	}
+}
+
+func (s *memorySession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *memorySession) Commit() {
+	s.commit()
+}
+
+// setMarked records the next offset to read. s.mu must be held, or the
+// session not shared yet.
+func (s *memorySession) setMarked(topic string, partition int32, offset int64) {
+	if s.marked[topic] == nil {
+		s.marked[topic] = make(map[int32]int64)
+	}
+	s.marked[topic][partition] = offset
+}
+
+func (s *memorySession) offset(topic string, partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marked[topic][partition]
+}
+
+// commit stores the marked offsets as the group's committed offsets.
+func (s *memorySession) commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	for topic, partitions := range s.marked {
+		if s.group.offsets[topic] == nil {
+			s.group.offsets[topic] = make(map[int32]int64)
+		}
+		for partition, offset := range partitions {
+			s.group.offsets[topic][partition] = offset
+		}
+	}
+}
+
+// memoryClaimBuffer is how many messages a claim reads ahead, sarama's
+// default.
+const memoryClaimBuffer = 256
+
+type memoryClaim struct {
+	broker    *MemoryBroker
+	topic     string
+	partition int32
+	offset    int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *memoryClaim) Topic() string { return c.topic }
+
+func (c *memoryClaim) Partition() int32 { return c.partition }
+
+func (c *memoryClaim) InitialOffset() int64 { return c.offset }
+
+func (c *memoryClaim) HighWaterMarkOffset() int64 {
+	offset, _ := c.broker.GetOffset(c.topic, c.partition, sarama.OffsetNewest)
+	return offset
+}
+
+func (c *memoryClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
@@ -0,0 +1,47 @@
+package kafka
+
+import "time"
+
+// Metrics receives instrumentation events from producers and consumers.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	MessageSent(topic string, partition int32, latency time.Duration)
+	SendFailed(topic string)
+	MessageConsumed(topic string, partition int32, lag int64)
+	EndToEndLatency(topic string, partition int32, latency time.Duration)
+	PartitionSkew(topic string, partition int32, messageRatio, byteRatio float64)
+	ProcessingFailed(topic string)
+	DuplicateSkipped(topic string)
+	PoisonPillSkipped(topic string)
+	MessageFiltered(topic string, passed bool)
+	WatermarkLag(topic string, partition int32, lag time.Duration)
+	LateEvent(topic string)
+	InFlightBuffer(topic string, partition int32, messages, capacity int)
+	BackpressurePaused(topic string, partition int32)
+	Rebalanced(groupID string)
+}
+
+// WithMetrics reports producer and consumer activity to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) error {
+		o.metrics = m
+		return nil
+	}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) MessageSent(string, int32, time.Duration)      {}
+func (noopMetrics) SendFailed(string)                             {}
+func (noopMetrics) MessageConsumed(string, int32, int64)          {}
+func (noopMetrics) EndToEndLatency(string, int32, time.Duration)  {}
+func (noopMetrics) PartitionSkew(string, int32, float64, float64) {}
+func (noopMetrics) ProcessingFailed(string)                       {}
+func (noopMetrics) DuplicateSkipped(string)                       {}
+func (noopMetrics) PoisonPillSkipped(string)                      {}
+func (noopMetrics) MessageFiltered(string, bool)                  {}
+func (noopMetrics) WatermarkLag(string, int32, time.Duration)     {}
+func (noopMetrics) LateEvent(string)                              {}
+func (noopMetrics) InFlightBuffer(string, int32, int, int)        {}
+func (noopMetrics) BackpressurePaused(string, int32)              {}
+func (noopMetrics) Rebalanced(string)                             {}
@@ -0,0 +1,308 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// mirrorBatchSize is the most messages of one partition a Mirror sends in
+// one request.
+const mirrorBatchSize = 500
+
+// MirrorConfig describes what a Mirror copies.
+type MirrorConfig struct {
+	// Topics are the source topics.
+	Topics []string
+	// TopicPrefix is put in front of each source topic's name to name its
+	// target topic, e.g. "source." as MirrorMaker 2 does.
+	TopicPrefix string
+	// KeepPartitions sends every message to the partition number it was
+	// read from instead of letting the partitioner pick by key.
+	KeepPartitions bool
+	// CreateTopics creates missing target topics with the partition count
+	// and, as far as the target has brokers for it, the replication factor
+	// of their source topics.
+	CreateTopics bool
+}
+
+// Mirror continuously copies topics from one cluster to another, keeping
+// keys, values, headers and timestamps. It consumes the source in a
+// consumer group and only marks a batch's offsets once the target cluster
+// has acknowledged it, so a crash copies the last batches again but never
+// loses messages.
+type Mirror struct {
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	groupID           string
+	config            MirrorConfig
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+	metrics           Metrics
+
+	shutdownTimeout time.Duration
+	processCtx      context.Context
+}
+
+// NewMirror creates a mirror from the source to the target brokers. Both
+// clusters are reached with the same options, e.g. the same TLS and SASL
+// settings. A new group starts from the oldest messages. Messages are
+// produced with acks from all in-sync replicas and one request in flight
+// per broker, so each partition keeps its order. WithPartitioner picks
+// the target partitions unless MirrorConfig.KeepPartitions is set; with
+// the partitioner of the source producers and the same partition count
+// every key stays on the partition it has in the source.
+func NewMirror(source, target []string, groupID string, config MirrorConfig, opts ...Option) (*Mirror, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumerConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	producerConfig.Net.MaxOpenRequests = 1
+	po, err := newOptions(producerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	fallback := producerConfig.Producer.Partitioner
+	producerConfig.Producer.Partitioner = func(topic string) sarama.Partitioner {
+		return &explicitPartitioner{fallback: fallback(topic)}
+	}
+
+	// The admins are only needed to prepare the target topics.
+	sourceAdmin, err := NewAdmin(source, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer sourceAdmin.Close()
+	targetAdmin, err := NewAdmin(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer targetAdmin.Close()
+	if err := prepareMirrorTopics(sourceAdmin, targetAdmin, config); err != nil {
+		return nil, err
+	}
+
+	producer, err := po.newSyncProducer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := o.newConsumerGroup(source, groupID)
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Mirror{
+		consumer:          consumer,
+		producer:          producer,
+		groupID:           groupID,
+		config:            config,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+		metrics:           o.metrics,
+
+		shutdownTimeout: o.shutdownTimeout,
+	}, nil
+}
+
+// prepareMirrorTopics creates the missing target topics if asked to, and
+// checks that every target topic can take the source's partitioning.
+func prepareMirrorTopics(source, target Admin, config MirrorConfig) error {
+	brokers, err := target.Brokers()
+	if err != nil {
+		return fmt.Errorf("failed to describe target cluster: %w", err)
+	}
+	sourceTopics, err := source.ListTopics()
+	if err != nil {
+		return fmt.Errorf("failed to list source topics: %w", err)
+	}
+
+	for _, topic := range config.Topics {
+		detail, ok := sourceTopics[topic]
+		if !ok {
+			return fmt.Errorf("source topic %s doesn't exist", topic)
+		}
+		partitions := int(detail.Partitions)
+		targetTopic := config.TopicPrefix + topic
+
+		if config.CreateTopics {
+			replicationFactor := int16(max(min(int(detail.ReplicationFactor), brokers), 1))
+			created, err := EnsureTopic(target, targetTopic, detail.Partitions, replicationFactor)
+			if err != nil {
+				return err
+			}
+			if created {
+				slog.Info("Target topic created", "topic", targetTopic, "partitions", partitions,
+					"replication_factor", replicationFactor)
+			}
+		}
+
+		targetTopics, err := target.ListTopics()
+		if err != nil {
+			return fmt.Errorf("failed to describe target topic %s: %w", targetTopic, err)
+		}
+		targetDetail, ok := targetTopics[targetTopic]
+		if !ok {
+			return fmt.Errorf("target topic %s doesn't exist", targetTopic)
+		}
+		targetPartitions := int(targetDetail.Partitions)
+		switch {
+		case config.KeepPartitions && targetPartitions < partitions:
+			return fmt.Errorf("%w: %s has %d partitions, %s only %d", ErrInvalidPartition,
+				topic, partitions, targetTopic, targetPartitions)
+		case !config.KeepPartitions && targetPartitions != partitions:
+			slog.Warn("Target topic has a different partition count, keys won't land on the partitions they have in the source",
+				"topic", topic, "partitions", partitions, "target_topic", targetTopic, "target_partitions", targetPartitions)
+		}
+	}
+	return nil
+}
+
+// Consume mirrors until ctx is cancelled. The batches being sent at that
+// point get up to the shutdown timeout to be acknowledged.
+func (m *Mirror) Consume(ctx context.Context) error {
+	processCtx, cancel := drainContext(ctx, m.shutdownTimeout)
+	defer cancel()
+	m.processCtx = processCtx
+	defer m.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := m.consumer.Consume(ctx, m.config.Topics, m); err != nil {
+			failures++
+			if werr := m.backoff.wait(ctx, failures, "Mirror group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (m *Mirror) Setup(session GroupSession) error {
+	rebalance := m.rebalances.Record(m.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Mirror setup completed", "topics", m.config.Topics, "group", m.groupID,
+		"strategy", m.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+// Cleanup commits the offsets of the batches sent last before the
+// partitions are released.
+func (m *Mirror) Cleanup(session GroupSession) error {
+	session.Commit()
+	rebalance := m.rebalances.Record(m.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Mirror cleanup completed", "topics", m.config.Topics, "group", m.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (m *Mirror) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: m.rebalanceStrategy, GroupID: m.groupID, InstanceID: m.instanceID}
+}
+
+// ConsumeClaim sends whatever the claim has buffered as one batch, so a
+// busy partition is copied in large requests and a quiet one without
+// delay.
+func (m *Mirror) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	target := m.config.TopicPrefix + claim.Topic()
+	batch := make([]*sarama.ProducerMessage, 0, mirrorBatchSize)
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return nil
+			}
+			last := m.add(&batch, target, message, claim.HighWaterMarkOffset())
+		drain:
+			for len(batch) < mirrorBatchSize {
+				select {
+				case message := <-claim.Messages():
+					if message == nil {
+						break drain
+					}
+					last = m.add(&batch, target, message, claim.HighWaterMarkOffset())
+				default:
+					break drain
+				}
+			}
+			if err := m.send(session, target, batch, last); err != nil {
+				return err
+			}
+			batch = batch[:0]
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// add appends a copy of message to batch and returns message.
+func (m *Mirror) add(batch *[]*sarama.ProducerMessage, target string, message *sarama.ConsumerMessage, highWaterMark int64) *sarama.ConsumerMessage {
+	m.metrics.MessageConsumed(message.Topic, message.Partition, highWaterMark-message.Offset-1)
+	msg := copyMessage(target, message, m.config.KeepPartitions)
+	msg.Timestamp = message.Timestamp
+	*batch = append(*batch, msg)
+	return message
+}
+
+// send sends batch, retrying according to the backoff, and marks the offset
+// of last once the target has acknowledged it. If the target keeps failing
+// the claim ends, and the next session starts again from the last batch
+// that was sent.
+func (m *Mirror) send(session GroupSession, target string, batch []*sarama.ProducerMessage, last *sarama.ConsumerMessage) error {
+	err := m.backoff.Retry(m.processCtx, "Mirroring batch", func() error {
+		return sendCopies(m.producer, m.metrics, target, batch)
+	})
+	if err != nil {
+		slog.Error("Failed to mirror batch", "topic", last.Topic, "partition", last.Partition,
+			"last_offset", last.Offset, "messages", len(batch), "error", err)
+		return fmt.Errorf("failed to mirror batch of %s partition %d: %w", last.Topic, last.Partition, err)
+	}
+
+	session.MarkMessage(last, "")
+	slog.Debug("Batch mirrored", "topic", last.Topic, "partition", last.Partition, "target_topic", target,
+		"last_offset", last.Offset, "messages", len(batch))
+	return nil
+}
+
+// Close stops consuming and closes the producer.
+func (m *Mirror) Close() error {
+	consumerErr := m.consumer.Close()
+	if err := m.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
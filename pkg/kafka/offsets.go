@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// WithStartFromBeginning makes consumers start from the oldest available
+// offset the first time they are assigned a partition, even if the group
+// has committed offsets.
+func WithStartFromBeginning() Option {
+	return withStartPosition(sarama.OffsetOldest)
+}
+
+// WithStartFromLatest makes consumers skip to the end of each partition the
+// first time they are assigned it, ignoring committed offsets.
+func WithStartFromLatest() Option {
+	return withStartPosition(sarama.OffsetNewest)
+}
+
+// WithStartFrom makes consumers start from the first message at or after t
+// the first time they are assigned a partition.
+func WithStartFrom(t time.Time) Option {
+	return withStartPosition(t.UnixMilli())
+}
+
+// ParseStartFrom turns "beginning", "latest" or an RFC3339 timestamp into
+// the matching start option.
+func ParseStartFrom(value string) (Option, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "beginning", "earliest", "oldest":
+		return WithStartFromBeginning(), nil
+	case "latest", "newest":
+		return WithStartFromLatest(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start position %q: expected beginning, latest or an RFC3339 timestamp", value)
+	}
+	return WithStartFrom(t), nil
+}
+
+// Position is a place in every partition of a topic.
+type Position struct {
+	// Offset is sarama.OffsetOldest, sarama.OffsetNewest or an absolute
+	// offset. It is ignored if Time is set.
+	Offset int64
+	// Time is the position of the first message at or after it.
+	Time time.Time
+}
+
+// ParsePosition parses "oldest", "newest", an absolute offset or an RFC3339
+// timestamp.
+func ParsePosition(value string) (Position, error) {
+	if offset, err := ParseOffset(value); err == nil {
+		return Position{Offset: offset}, nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return Position{}, fmt.Errorf("invalid position %q: expected oldest, newest, an offset or an RFC3339 timestamp", value)
+	}
+	return Position{Time: t}, nil
+}
+
+// withStartPosition stores a position in the form accepted by
+// Client.GetOffset: OffsetOldest, OffsetNewest or a timestamp in ms.
+func withStartPosition(position int64) Option {
+	return func(o *options) error {
+		o.startPosition = &position
+		return nil
+	}
+}
+
+// resolveOffset converts a start position into a concrete offset for one
+// partition. Timestamps after the last message resolve to the log end.
+func resolveOffset(client Client, topic string, partition int32, position int64) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, position)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve offset for partition %d: %w", partition, err)
+	}
+	if offset == -1 {
+		return client.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+	return offset, nil
+}
+
+// resolvePosition converts a position into an offset of one partition,
+// between its oldest and its log-end offset.
+func resolvePosition(client Client, topic string, partition int32, position Position) (int64, error) {
+	if !position.Time.IsZero() {
+		return resolveOffset(client, topic, partition, position.Time.UnixMilli())
+	}
+	if position.Offset < 0 {
+		offset, err := client.GetOffset(topic, partition, position.Offset)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve offset for partition %d: %w", partition, err)
+		}
+		return offset, nil
+	}
+
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch oldest offset for partition %d: %w", partition, err)
+	}
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch log-end offset for partition %d: %w", partition, err)
+	}
+	return min(max(position.Offset, oldest), newest), nil
+}
+
+// seekOffset moves the next offset of a claimed partition to offset, in
+// either direction. Sarama's ResetOffset only rewinds and MarkOffset only
+// moves forward, so it calls both and only the one that points the right
+// way has an effect.
+func seekOffset(session GroupSession, topic string, partition int32, offset int64) {
+	session.ResetOffset(topic, partition, offset, "")
+	session.MarkOffset(topic, partition, offset, "")
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// saramaSession is a ConsumerGroupSession with the offset semantics of
+// sarama's: MarkOffset only moves an offset forward and ResetOffset only
+// rewinds it.
+type saramaSession struct {
+	claims map[string][]int32
+	next   map[int32]int64
+}
+
+func newSaramaSession(topic string, next map[int32]int64) *saramaSession {
+	s := &saramaSession{claims: map[string][]int32{}, next: next}
+	for partition := range next {
+		s.claims[topic] = append(s.claims[topic], partition)
+	}
+	return s
+}
+
+func (s *saramaSession) Claims() map[string][]int32 { return s.claims }
+func (s *saramaSession) MemberID() string           { return "member-1" }
+func (s *saramaSession) GenerationID() int32        { return 1 }
+func (s *saramaSession) Commit()                    {}
+func (s *saramaSession) Context() context.Context   { return context.Background() }
+
+func (s *saramaSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if offset > s.next[partition] {
+		s.next[partition] = offset
+	}
+}
+
+func (s *saramaSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	if offset <= s.next[partition] {
+		s.next[partition] = offset
+	}
+}
+
+func (s *saramaSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// fixedOffsets is a Client that resolves OffsetOldest and OffsetNewest of
+// every partition to oldest and newest.
+type fixedOffsets struct {
+	Client
+	oldest, newest int64
+}
+
+func (f fixedOffsets) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	if time == sarama.OffsetOldest {
+		return f.oldest, nil
+	}
+	return f.newest, nil
+}
+
+func TestConsumerSeek(t *testing.T) {
+	tests := []struct {
+		name     string
+		position int64
+		want     int64
+	}{
+		{"from beginning rewinds", sarama.OffsetOldest, 10},
+		{"from latest skips ahead", sarama.OffsetNewest, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			position := tt.position
+			c := &Consumer{
+				topics:        []string{"events"},
+				client:        fixedOffsets{oldest: 10, newest: 90},
+				startPosition: &position,
+				seeked:        make(map[string]map[int32]bool),
+			}
+			// Partition 0 committed offset 50, partition 1 nothing yet.
+			session := newSaramaSession("events", map[int32]int64{0: 50, 1: sarama.OffsetOldest})
+			if err := c.seek(session); err != nil {
+				t.Fatalf("seek: %v", err)
+			}
+			for partition, next := range session.next {
+				if next != tt.want {
+					t.Errorf("partition %d starts from %d, want %d", partition, next, tt.want)
+				}
+			}
+
+			// Later rebalances resume from the committed offset.
+			session.next[0] = 70
+			if err := c.seek(session); err != nil {
+				t.Fatalf("seek: %v", err)
+			}
+			if session.next[0] != 70 {
+				t.Errorf("second seek moved partition 0 to %d, want it left at 70", session.next[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetWatermark lets the messages of one partition complete out of order
+// while only marking the highest contiguous completed offset: a message is
+// marked once it and every message added before it have completed, so a
+// crash never skips an unprocessed offset.
+//
+// Offsets are ordered by when they were added rather than by arithmetic, so
+// the gaps that compaction and transaction markers leave in a partition
+// never hold the watermark back. A message at or behind an offset already
+// added, e.g. one redelivered after a restart, is refused, and a completion
+// of an offset that isn't in flight, e.g. one finishing after its partition
+// was released in a rebalance, is ignored.
+type offsetWatermark struct {
+	mu sync.Mutex
+	// pending holds the messages in flight, in the order they were added.
+	pending []*sarama.ConsumerMessage
+	// done says which offsets in pending have completed.
+	done map[int64]bool
+	// next is the offset after the last message added, -1 before the
+	// first one.
+	next int64
+	// marked is the offset after the last message marked, -1 before the
+	// first one.
+	marked int64
+	mark   func(*sarama.ConsumerMessage)
+}
+
+func newOffsetWatermark(mark func(*sarama.ConsumerMessage)) *offsetWatermark {
+	return &offsetWatermark{
+		done:   make(map[int64]bool),
+		next:   -1,
+		marked: -1,
+		mark:   mark,
+	}
+}
+
+// add puts message in flight. It returns false, and leaves message out, if
+// its offset is at or behind one already added.
+func (w *offsetWatermark) add(message *sarama.ConsumerMessage) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if message.Offset < w.next {
+		slog.Debug("Ignoring message behind the offset watermark", "topic", message.Topic,
+			"partition", message.Partition, "offset", message.Offset, "next", w.next)
+		return false
+	}
+	w.pending = append(w.pending, message)
+	w.done[message.Offset] = false
+	w.next = message.Offset + 1
+	return true
+}
+
+// complete records that offset has completed and marks the highest offset
+// now contiguous with the watermark, if it moved.
+func (w *offsetWatermark) complete(offset int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.done[offset]; !ok {
+		return
+	}
+	w.done[offset] = true
+
+	var last *sarama.ConsumerMessage
+	for len(w.pending) > 0 && w.done[w.pending[0].Offset] {
+		last = w.pending[0]
+		delete(w.done, last.Offset)
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+	if last != nil {
+		w.marked = last.Offset + 1
+		w.mark(last)
+	}
+}
+
+// release forgets every offset still in flight, so their completions are
+// ignored from now on, and logs how many were left unmarked.
+func (w *offsetWatermark) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 {
+		completed := 0
+		for _, done := range w.done {
+			if done {
+				completed++
+			}
+		}
+		slog.Debug("Offsets left unmarked", "topic", w.pending[0].Topic, "partition", w.pending[0].Partition,
+			"watermark", w.marked, "first_unmarked", w.pending[0].Offset, "in_flight", len(w.pending),
+			"completed_behind_gap", completed)
+	}
+	w.pending = nil
+	clear(w.done)
+}
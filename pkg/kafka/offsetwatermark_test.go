@@ -0,0 +1,180 @@
+package kafka
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// markRecorder collects the offsets an offsetWatermark or workerPool marks.
+type markRecorder struct {
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (r *markRecorder) mark(message *sarama.ConsumerMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.marked = append(r.marked, message.Offset)
+}
+
+func (r *markRecorder) offsets() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.marked...)
+}
+
+func watermarkMessage(offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{Topic: "events", Partition: 0, Offset: offset, Key: []byte("user-1")}
+}
+
+func addAll(t *testing.T, w *offsetWatermark, offsets ...int64) {
+	t.Helper()
+	for _, offset := range offsets {
+		if !w.add(watermarkMessage(offset)) {
+			t.Fatalf("add(%d) refused", offset)
+		}
+	}
+}
+
+func TestOffsetWatermarkOutOfOrder(t *testing.T) {
+	var r markRecorder
+	w := newOffsetWatermark(r.mark)
+	addAll(t, w, 10, 11, 12, 13, 14)
+
+	steps := []struct {
+		complete int64
+		want     []int64
+	}{
+		{12, nil},
+		{14, nil},
+		{10, []int64{10}},
+		// 11 fills the gap, so the watermark jumps past 12 in one mark.
+		{11, []int64{10, 12}},
+		{13, []int64{10, 12, 14}},
+	}
+	for _, step := range steps {
+		w.complete(step.complete)
+		if got := r.offsets(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("after completing %d marked %v, want %v", step.complete, got, step.want)
+		}
+	}
+	if w.marked != 15 || len(w.pending) != 0 || len(w.done) != 0 {
+		t.Errorf("watermark at %d with %d pending and %d tracked, want 15 with none", w.marked, len(w.pending), len(w.done))
+	}
+}
+
+func TestOffsetWatermarkCompactionGaps(t *testing.T) {
+	var r markRecorder
+	w := newOffsetWatermark(r.mark)
+	// Offsets 3, 4 and 6 to 9 were compacted away or hold transaction markers.
+	addAll(t, w, 2, 5, 10)
+
+	w.complete(5)
+	w.complete(2)
+	if got, want := r.offsets(), []int64{5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("marked %v, want %v", got, want)
+	}
+	w.complete(10)
+	if got, want := r.offsets(), []int64{5, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("marked %v, want %v", got, want)
+	}
+
+	// Completing an offset that was never added, such as one in a gap,
+	// changes nothing.
+	w.complete(7)
+	if got := r.offsets(); len(got) != 2 {
+		t.Errorf("completing an unknown offset marked %v", got)
+	}
+}
+
+func TestOffsetWatermarkRefusesOffsetsBehind(t *testing.T) {
+	var r markRecorder
+	w := newOffsetWatermark(r.mark)
+	addAll(t, w, 5, 6)
+
+	for _, offset := range []int64{6, 5, 0} {
+		if w.add(watermarkMessage(offset)) {
+			t.Errorf("add(%d) accepted an offset behind next %d", offset, w.next)
+		}
+	}
+	if len(w.pending) != 2 {
+		t.Errorf("%d messages pending, want 2", len(w.pending))
+	}
+
+	// A redelivery after the watermark moved on is refused as well.
+	w.complete(5)
+	w.complete(6)
+	if w.add(watermarkMessage(6)) {
+		t.Error("add(6) accepted a redelivered offset that was already marked")
+	}
+	addAll(t, w, 7)
+	w.complete(7)
+	if got, want := r.offsets(), []int64{5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("marked %v, want %v", got, want)
+	}
+}
+
+func TestOffsetWatermarkIgnoresCompletionsAfterRelease(t *testing.T) {
+	var r markRecorder
+	w := newOffsetWatermark(r.mark)
+	addAll(t, w, 0, 1, 2)
+	w.complete(1)
+	w.release()
+
+	w.complete(0)
+	w.complete(2)
+	if got := r.offsets(); len(got) != 0 {
+		t.Errorf("completions after release marked %v", got)
+	}
+	if len(w.pending) != 0 || len(w.done) != 0 {
+		t.Errorf("%d pending and %d tracked after release, want none", len(w.pending), len(w.done))
+	}
+	// The offsets released stay behind the watermark.
+	if w.add(watermarkMessage(2)) {
+		t.Error("add(2) accepted an offset released earlier")
+	}
+}
+
+func TestWorkerPoolSkip(t *testing.T) {
+	var r markRecorder
+	release := make(chan struct{})
+	var processed []int64
+	var mu sync.Mutex
+	pool := newWorkerPool(2, func(message *sarama.ConsumerMessage) error {
+		<-release
+		mu.Lock()
+		processed = append(processed, message.Offset)
+		mu.Unlock()
+		return nil
+	}, r.mark)
+
+	if !pool.dispatch(watermarkMessage(0)) {
+		t.Fatal("dispatch(0) failed")
+	}
+	// Skipped messages wait for the ones dispatched before them.
+	pool.skip(watermarkMessage(1))
+	if got := r.offsets(); len(got) != 0 {
+		t.Fatalf("skip marked %v before the earlier message was processed", got)
+	}
+	// Offsets add refuses, e.g. a redelivery, are neither completed nor
+	// processed again.
+	pool.skip(watermarkMessage(1))
+	pool.skip(watermarkMessage(0))
+	if !pool.dispatch(watermarkMessage(0)) {
+		t.Fatal("dispatch of a redelivered offset failed")
+	}
+
+	close(release)
+	if err := pool.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got, want := r.offsets(), []int64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("marked %v, want %v", got, want)
+	}
+	if want := []int64{0}; !reflect.DeepEqual(processed, want) {
+		t.Errorf("processed %v, want %v", processed, want)
+	}
+}
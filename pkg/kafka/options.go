@@ -0,0 +1,229 @@
+// Package kafka contains the producer, consumer and event helpers behind the
+// partition routing demo so they can be reused outside of the cmd binaries.
+package kafka
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"kafka-hwsw/internal/auth"
+)
+
+type options struct {
+	config        *sarama.Config
+	serializer    Serializer
+	deserializers map[string]Serializer
+	partitioner   string
+	partition     int32
+	keyFunc       KeyFunc
+	keyStrategy   string
+	startPosition *int64
+	handler       MessageHandler
+	quiet         bool
+	maxRetries    int
+	retryLevels   []RetryLevel
+	dlqTopic      string
+	metrics       Metrics
+
+	shutdownTimeout   time.Duration
+	concurrency       int
+	inFlight          int
+	commit            CommitStrategy
+	semantics         string
+	crashAfter        int
+	topicRefresh      time.Duration
+	readerVersion     int
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	backoff           Backoff
+	breakerThreshold  int
+	breakerCooldown   time.Duration
+	dedup             DedupStore
+	filter            MessageFilter
+	poisonAttempts    int
+	poisonRecorder    PoisonPillRecorder
+	backend           Backend
+	encryption        *envelope
+	dataKeys          dataKeyRotation
+	dictionary        *dictionaryCodec
+	delayedDelivery   *delayedDelivery
+	large             largeMessages
+	sequencer         *sequencer
+	ordering          *OrderingVerifier
+	latencyReport     time.Duration
+	timestampView     bool
+	eventWatermarks   bool
+	eventLateness     time.Duration
+	lateTopic         string
+	recordTimestamp   func(UserEvent) time.Time
+	skewThreshold     float64
+	skewInterval      time.Duration
+	priorities        []TopicPriority
+
+	checkpointEvery    int
+	checkpointInterval time.Duration
+	windows            Windows
+	joinWindow         JoinWindow
+	sessionWindows     SessionWindows
+
+	sinkBatchMessages int
+	sinkBatchBytes    int
+	sinkFlushInterval time.Duration
+	checkpoints       CheckpointStore
+}
+
+// Option customises a Producer or Consumer.
+type Option func(*options) error
+
+// WithConfigFunc applies fn to the underlying sarama config. It is the hook
+// used to plug in settings loaded elsewhere, such as TLS or SASL.
+func WithConfigFunc(fn func(*sarama.Config) error) Option {
+	return func(o *options) error {
+		return fn(o.config)
+	}
+}
+
+// WithSaramaConfig gives direct access to the sarama config for settings
+// that have no dedicated option.
+func WithSaramaConfig(fn func(*sarama.Config)) Option {
+	return func(o *options) error {
+		fn(o.config)
+		return nil
+	}
+}
+
+// WithTLS connects to the brokers over TLS using the given config.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return WithSaramaConfig(func(config *sarama.Config) {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	})
+}
+
+// WithSASL authenticates with the given mechanism (PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512).
+func WithSASL(mechanism, username, password string) Option {
+	return WithConfigFunc(auth.SASL{Mechanism: mechanism, Username: username, Password: password}.Apply)
+}
+
+// WithClientID sets the client ID reported to the brokers.
+func WithClientID(clientID string) Option {
+	return WithSaramaConfig(func(config *sarama.Config) {
+		config.ClientID = clientID
+	})
+}
+
+// CompressionCodecs lists the codec names WithCompression accepts.
+var CompressionCodecs = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+
+// WithCompression sets the codec producers compress batches with, one of
+// CompressionCodecs. Producers default to snappy. zstd raises the protocol
+// version to 2.1, the first one that supports it.
+func WithCompression(codec string) Option {
+	return func(o *options) error {
+		var c sarama.CompressionCodec
+		if err := c.UnmarshalText([]byte(strings.ToLower(strings.TrimSpace(codec)))); err != nil {
+			return fmt.Errorf("unknown compression %q (want one of %s)", codec, strings.Join(CompressionCodecs, ", "))
+		}
+		o.config.Producer.Compression = c
+		if c == sarama.CompressionZSTD && !o.config.Version.IsAtLeast(sarama.V2_1_0_0) {
+			o.config.Version = sarama.V2_1_0_0
+		}
+		return nil
+	}
+}
+
+// WithSerializer sets how events are encoded by SendEvent, and how consumers
+// decode messages without a content-type header. Defaults to JSON.
+func WithSerializer(serializer Serializer) Option {
+	return func(o *options) error {
+		o.serializer = serializer
+		o.deserializers[serializer.ContentType()] = serializer
+		return nil
+	}
+}
+
+// WithDeserializers registers additional serializers a consumer can pick
+// from based on the content-type header of each message.
+func WithDeserializers(serializers ...Serializer) Option {
+	return func(o *options) error {
+		for _, s := range serializers {
+			o.deserializers[s.ContentType()] = s
+		}
+		return nil
+	}
+}
+
+// WithPartitioner selects the partitioning strategy by name, see
+// NewPartitionerConstructor for the supported values.
+func WithPartitioner(name string) Option {
+	return func(o *options) error {
+		constructor, err := NewPartitionerConstructor(name)
+		if err != nil {
+			return err
+		}
+		o.config.Producer.Partitioner = constructor
+		o.partitioner = name
+		return nil
+	}
+}
+
+// WithManualPartition sends every message to partition, using the manual partitioner.
+func WithManualPartition(partition int32) Option {
+	return func(o *options) error {
+		o.config.Producer.Partitioner = sarama.NewManualPartitioner
+		o.partitioner = PartitionerManual
+		o.partition = partition
+		return nil
+	}
+}
+
+func newOptions(config *sarama.Config, opts []Option) (*options, error) {
+	o := &options{
+		config:        config,
+		serializer:    JSONSerializer{},
+		deserializers: map[string]Serializer{JSONSerializer{}.ContentType(): JSONSerializer{}},
+		partitioner:   PartitionerHash,
+		backend:       SaramaBackend{},
+		keyFunc:       userIDKey,
+		keyStrategy:   KeyUserID,
+		maxRetries:    3,
+		metrics:       noopMetrics{},
+
+		shutdownTimeout:   defaultShutdownTimeout,
+		concurrency:       1,
+		commit:            CommitStrategy{Mode: CommitAuto},
+		semantics:         DeliveryAtLeastOnce,
+		topicRefresh:      defaultTopicRefresh,
+		rebalanceStrategy: RebalanceRoundRobin,
+
+		checkpointEvery:    100,
+		checkpointInterval: 5 * time.Second,
+		windows:            DefaultWindows,
+		joinWindow:         DefaultJoinWindow,
+		sessionWindows:     DefaultSessionWindows,
+
+		sinkBatchMessages: 500,
+		sinkBatchBytes:    5 << 20,
+		sinkFlushInterval: 10 * time.Second,
+		dataKeys:          dataKeyRotation{messages: DefaultDataKeyMessages, lifetime: DefaultDataKeyLifetime},
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.large.validate(); err != nil {
+		return nil, err
+	}
+	if o.encryption != nil {
+		o.encryption.rotation = o.dataKeys
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+
+	"kafka-hwsw/internal/auth"
+	"kafka-hwsw/internal/config"
+)
+
+func TestNewOptionsDefaults(t *testing.T) {
+	o, err := newOptions(sarama.NewConfig(), nil)
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if _, ok := o.serializer.(JSONSerializer); !ok {
+		t.Errorf("serializer %T, want JSONSerializer", o.serializer)
+	}
+	if o.partitioner != PartitionerHash || o.keyStrategy != KeyUserID {
+		t.Errorf("partitioner %q, key strategy %q, want %q, %q", o.partitioner, o.keyStrategy, PartitionerHash, KeyUserID)
+	}
+	if o.maxRetries != 3 || o.concurrency != 1 {
+		t.Errorf("max retries %d, concurrency %d, want 3, 1", o.maxRetries, o.concurrency)
+	}
+	if o.config.Net.SASL.Enable || o.config.Net.TLS.Enable {
+		t.Errorf("SASL enabled %t, TLS enabled %t, want both off", o.config.Net.SASL.Enable, o.config.Net.TLS.Enable)
+	}
+}
+
+func TestNewOptionsErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opt  Option
+		want string
+	}{
+		{"SASL without password", WithSASL(auth.MechanismPlain, "user", ""), "KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD are required for PLAIN"},
+		{"SASL without username", WithSASL(auth.MechanismSCRAMSHA512, "", "secret"), "are required for SCRAM-SHA-512"},
+		{"unsupported SASL mechanism", WithSASL("GSSAPI", "user", "secret"), "unsupported SASL mechanism: GSSAPI"},
+		{"TLS CA file missing", WithConfigFunc(config.TLS{Enabled: true, CAFile: filepath.Join(dir, "missing.pem")}.Apply), "failed to read CA file"},
+		{"TLS CA file not PEM", WithConfigFunc(config.TLS{Enabled: true, CAFile: notPEM}.Apply), "failed to parse CA file"},
+		{"TLS cert without key", WithConfigFunc(config.TLS{Enabled: true, CertFile: notPEM}.Apply), "both KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set"},
+		{"TLS key pair unreadable", WithConfigFunc(config.TLS{Enabled: true, CertFile: notPEM, KeyFile: notPEM}.Apply), "failed to load client certificate"},
+		{"unknown compression", WithCompression("brotli"), `unknown compression "brotli"`},
+		{"unknown partitioner", WithPartitioner("sticky"), "unsupported partitioner: sticky"},
+		{"unknown large message strategy", WithLargeMessages("split", 0), `unknown large message strategy "split"`},
+		{"claim check without store", WithLargeMessages(LargeMessageClaimCheck, 0), "needs a blob store"},
+		{"invalid sarama config", WithSaramaConfig(func(c *sarama.Config) { c.Producer.Retry.Max = -1 }), "Producer.Retry.Max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := newOptions(sarama.NewConfig(), []Option{tt.opt})
+			if err == nil {
+				t.Fatalf("newOptions returned %+v, want an error", o)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("newOptions error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOptionsStopsAtFirstError(t *testing.T) {
+	applied := false
+	_, err := newOptions(sarama.NewConfig(), []Option{
+		WithPartitioner("sticky"),
+		func(*options) error { applied = true; return nil },
+	})
+	if err == nil {
+		t.Fatal("newOptions succeeded, want an error")
+	}
+	if applied {
+		t.Error("newOptions applied an option after a failing one")
+	}
+}
+
+func TestWithSASL(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		want      sarama.SASLMechanism
+		scram     bool
+	}{
+		{auth.MechanismPlain, sarama.SASLTypePlaintext, false},
+		{auth.MechanismSCRAMSHA256, sarama.SASLTypeSCRAMSHA256, true},
+		{auth.MechanismSCRAMSHA512, sarama.SASLTypeSCRAMSHA512, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			o, err := newOptions(sarama.NewConfig(), []Option{WithSASL(tt.mechanism, "user", "secret")})
+			if err != nil {
+				t.Fatalf("newOptions: %v", err)
+			}
+			sasl := o.config.Net.SASL
+			if !sasl.Enable || !sasl.Handshake || sasl.Mechanism != tt.want {
+				t.Errorf("SASL enabled %t, handshake %t, mechanism %q, want enabled with %q", sasl.Enable, sasl.Handshake, sasl.Mechanism, tt.want)
+			}
+			if sasl.User != "user" || sasl.Password != "secret" {
+				t.Errorf("SASL user %q, password %q, want user, secret", sasl.User, sasl.Password)
+			}
+			if got := sasl.SCRAMClientGeneratorFunc != nil; got != tt.scram {
+				t.Errorf("SCRAM client generator set %t, want %t", got, tt.scram)
+			}
+		})
+	}
+
+	o, err := newOptions(sarama.NewConfig(), []Option{WithSASL("", "", "")})
+	if err != nil {
+		t.Fatalf("newOptions without a mechanism: %v", err)
+	}
+	if o.config.Net.SASL.Enable {
+		t.Error("SASL enabled without a mechanism")
+	}
+}
+
+func TestWithTLS(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: "broker"}
+	o, err := newOptions(sarama.NewConfig(), []Option{WithTLS(tlsConfig)})
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if !o.config.Net.TLS.Enable || o.config.Net.TLS.Config != tlsConfig {
+		t.Errorf("TLS enabled %t with config %p, want enabled with %p", o.config.Net.TLS.Enable, o.config.Net.TLS.Config, tlsConfig)
+	}
+
+	o, err = newOptions(sarama.NewConfig(), []Option{WithConfigFunc(config.TLS{}.Apply)})
+	if err != nil {
+		t.Fatalf("newOptions with TLS off: %v", err)
+	}
+	if o.config.Net.TLS.Enable {
+		t.Error("TLS enabled although it is turned off")
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	tests := []struct {
+		codec   string
+		want    sarama.CompressionCodec
+		version sarama.KafkaVersion
+	}{
+		{"none", sarama.CompressionNone, sarama.NewConfig().Version},
+		{" GZIP ", sarama.CompressionGZIP, sarama.NewConfig().Version},
+		{"lz4", sarama.CompressionLZ4, sarama.NewConfig().Version},
+		{"zstd", sarama.CompressionZSTD, sarama.V2_1_0_0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec, func(t *testing.T) {
+			o, err := newOptions(sarama.NewConfig(), []Option{WithCompression(tt.codec)})
+			if err != nil {
+				t.Fatalf("newOptions: %v", err)
+			}
+			if o.config.Producer.Compression != tt.want {
+				t.Errorf("compression %s, want %s", o.config.Producer.Compression, tt.want)
+			}
+			if o.config.Version != tt.version {
+				t.Errorf("version %s, want %s", o.config.Version, tt.version)
+			}
+		})
+	}
+
+	// zstd must not lower a version that is already newer.
+	newer := sarama.NewConfig()
+	newer.Version = sarama.V3_0_0_0
+	o, err := newOptions(newer, []Option{WithCompression("zstd")})
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if o.config.Version != sarama.V3_0_0_0 {
+		t.Errorf("version %s, want %s", o.config.Version, sarama.V3_0_0_0)
+	}
+}
+
+func TestWithPartitioner(t *testing.T) {
+	o, err := newOptions(sarama.NewConfig(), []Option{WithPartitioner(PartitionerRoundRobin)})
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if o.partitioner != PartitionerRoundRobin || o.config.Producer.Partitioner == nil {
+		t.Errorf("partitioner %q, want %q with a constructor", o.partitioner, PartitionerRoundRobin)
+	}
+
+	o, err = newOptions(sarama.NewConfig(), []Option{WithManualPartition(2)})
+	if err != nil {
+		t.Fatalf("newOptions: %v", err)
+	}
+	if o.partitioner != PartitionerManual || o.partition != 2 {
+		t.Errorf("partitioner %q, partition %d, want %q, 2", o.partitioner, o.partition, PartitionerManual)
+	}
+}
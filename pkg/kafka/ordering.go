@@ -0,0 +1,267 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers of messages sent with WithSequenceNumbers. Sequence numbers count
+// the messages of each key from 1 and restart with every producer, which
+// SequenceSourceHeader tells apart.
+const (
+	SequenceHeader       = "sequence"
+	SequenceSourceHeader = "sequence-source"
+)
+
+// WithSequenceNumbers makes producers number the messages of each key, so an
+// OrderingVerifier on the consuming side can check that every key's messages
+// arrive once and in order. A message retried by WithBackoff keeps its
+// number, so a retry that duplicates it shows up as a duplicate. Messages
+// without a key aren't numbered, since nothing orders them.
+func WithSequenceNumbers() Option {
+	return func(o *options) error {
+		o.sequencer = &sequencer{source: NewUUID(), next: make(map[string]int64)}
+		return nil
+	}
+}
+
+// sequencer numbers the messages of one producer per key.
+type sequencer struct {
+	source string
+
+	mu   sync.Mutex
+	next map[string]int64
+}
+
+// stamp adds the next sequence number of the key of msg to its headers.
+func (s *sequencer) stamp(msg *sarama.ProducerMessage) error {
+	if s == nil || msg.Key == nil {
+		return nil
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	s.mu.Lock()
+	s.next[string(key)]++
+	seq := s.next[string(key)]
+	s.mu.Unlock()
+
+	msg.Headers = append(msg.Headers,
+		stringHeader(SequenceHeader, strconv.FormatInt(seq, 10)),
+		stringHeader(SequenceSourceHeader, s.source),
+	)
+	return nil
+}
+
+// WithOrderingVerifier makes a group consumer pass every message to v in the
+// order it reads them, redeliveries included, and log v's report when it
+// stops.
+func WithOrderingVerifier(v *OrderingVerifier) Option {
+	return func(o *options) error {
+		o.ordering = v
+		return nil
+	}
+}
+
+// maxOrderingWarnings caps the violations an OrderingVerifier logs one by
+// one; the rest only count towards the report.
+const maxOrderingWarnings = 50
+
+// maxTrackedGap is the largest gap whose sequence numbers are remembered
+// one by one, so a late one can be told apart from a duplicate. Messages of
+// larger gaps are reported missing, and duplicates if they turn up after all.
+const maxTrackedGap = 10_000
+
+// OrderingVerifier checks the sequence numbers of WithSequenceNumbers: per
+// topic, producer and key every number has to arrive once, in order. The
+// lowest number seen of a key is where its check starts, so a consumer that
+// starts in the middle of a topic doesn't report everything before as
+// missing. It is safe for concurrent use, but only sees the order of the
+// messages of one key if they are recorded from one goroutine, e.g. from a
+// single partition.
+type OrderingVerifier struct {
+	mu          sync.Mutex
+	streams     map[sequenceStream]*sequenceState
+	messages    int
+	unsequenced int
+	duplicates  int
+	reordered   int
+	untracked   int
+	warnings    int
+}
+
+type sequenceStream struct {
+	topic, source, key string
+}
+
+type sequenceState struct {
+	first   int64
+	next    int64
+	missing map[int64]bool
+}
+
+// OrderingReport sums up what an OrderingVerifier saw.
+type OrderingReport struct {
+	// Messages is the number of messages recorded, Unsequenced the ones
+	// among them without a sequence number.
+	Messages    int
+	Unsequenced int
+	// Keys is the number of keys checked, per topic and producer.
+	Keys int
+	// Duplicates arrived with a number that was seen before, e.g. because
+	// a producer retry stored a message twice or the consumer read it again
+	// after a rebalance, which at-least-once delivery allows.
+	Duplicates int
+	// Reordered arrived after a later message of the same key.
+	Reordered int
+	// Missing were skipped and haven't arrived yet.
+	Missing int
+}
+
+// OK reports whether every key's messages arrived in order and none is
+// missing. Duplicates don't count, at-least-once delivery allows them.
+func (r OrderingReport) OK() bool {
+	return r.Reordered == 0 && r.Missing == 0
+}
+
+func NewOrderingVerifier() *OrderingVerifier {
+	return &OrderingVerifier{streams: make(map[sequenceStream]*sequenceState)}
+}
+
+// Record checks the sequence number of message against the ones recorded
+// before and logs a warning if it is a duplicate, out of order or skips
+// some.
+func (v *OrderingVerifier) Record(message *Message) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.messages++
+	seq, err := strconv.ParseInt(message.Headers[SequenceHeader], 10, 64)
+	if err != nil || seq < 1 {
+		v.unsequenced++
+		return
+	}
+	stream := sequenceStream{topic: message.Topic, source: message.Headers[SequenceSourceHeader], key: string(message.Key)}
+	state, ok := v.streams[stream]
+	if !ok {
+		v.streams[stream] = &sequenceState{first: seq, next: seq + 1, missing: make(map[int64]bool)}
+		return
+	}
+
+	switch {
+	case seq == state.next:
+		state.next++
+	case seq > state.next:
+		v.skip(state, state.next, seq)
+		v.warn("Messages of a key were skipped", message, "sequence", seq, "expected", state.next, "skipped", seq-state.next)
+		state.next = seq + 1
+	case seq < state.first:
+		// The key started earlier than the check assumed, and whatever lies
+		// in between is missing too.
+		v.skip(state, seq+1, state.first)
+		state.first = seq
+		v.reordered++
+		v.warn("Message of a key arrived out of order", message, "sequence", seq, "latest", state.next-1)
+	case state.missing[seq]:
+		delete(state.missing, seq)
+		v.reordered++
+		v.warn("Message of a key arrived out of order", message, "sequence", seq, "latest", state.next-1)
+	default:
+		v.duplicates++
+		v.warn("Duplicate message of a key", message, "sequence", seq, "latest", state.next-1)
+	}
+}
+
+// skip records the sequence numbers from up to to as missing.
+func (v *OrderingVerifier) skip(state *sequenceState, from, to int64) {
+	if to-from > maxTrackedGap {
+		v.untracked += int(to - from)
+		return
+	}
+	for seq := from; seq < to; seq++ {
+		state.missing[seq] = true
+	}
+}
+
+func (v *OrderingVerifier) warn(msg string, message *Message, args ...any) {
+	v.warnings++
+	if v.warnings > maxOrderingWarnings {
+		return
+	}
+	slog.Warn(msg, append([]any{"topic", message.Topic, "partition", message.Partition, "offset", message.Offset,
+		"key", string(message.Key)}, args...)...)
+	if v.warnings == maxOrderingWarnings {
+		slog.Warn("Further ordering violations are only counted", "limit", maxOrderingWarnings)
+	}
+}
+
+// Report returns what the verifier saw so far.
+func (v *OrderingVerifier) Report() OrderingReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	missing := v.untracked
+	for _, state := range v.streams {
+		missing += len(state.missing)
+	}
+	return OrderingReport{
+		Messages:    v.messages,
+		Unsequenced: v.unsequenced,
+		Keys:        len(v.streams),
+		Duplicates:  v.duplicates,
+		Reordered:   v.reordered,
+		Missing:     missing,
+	}
+}
+
+// MissingKeys returns the keys some messages are missing of, sorted.
+func (v *OrderingVerifier) MissingKeys() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	for stream, state := range v.streams {
+		if len(state.missing) > 0 && !seen[stream.key] {
+			seen[stream.key] = true
+			keys = append(keys, stream.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LogSummary logs the report and what it means.
+func (v *OrderingVerifier) LogSummary() {
+	r := v.Report()
+	summary := []any{"messages", r.Messages, "keys", r.Keys, "duplicates", r.Duplicates,
+		"reordered", r.Reordered, "missing", r.Missing}
+	if r.Unsequenced > 0 {
+		summary = append(summary, "unsequenced", r.Unsequenced)
+	}
+
+	switch {
+	case r.Keys == 0:
+		slog.Warn("No sequence numbers to verify, the producer has to number the messages of each key", summary...)
+	case !r.OK():
+		keys := v.MissingKeys()
+		if len(keys) > maxSummaryKeys {
+			keys = keys[:maxSummaryKeys]
+		}
+		slog.Warn("Per-key ordering verification failed", append(summary, "missing_keys", keys)...)
+	case r.Duplicates > 0:
+		slog.Info("Per-key ordering verified, with duplicates that at-least-once delivery allows", summary...)
+	default:
+		slog.Info("Per-key ordering verified, every message arrived once and in order", summary...)
+	}
+}
@@ -0,0 +1,268 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// PartitionConsumer reads explicit partitions of a topic without joining a
+// consumer group, so it never triggers or waits for a rebalance. Offsets are
+// not committed.
+type PartitionConsumer struct {
+	decoder
+	processor
+	client     Client
+	consumer   Reader
+	topic      string
+	partitions []int32
+	offset     int64
+	tracker    *PartitionTracker
+	latency    *latencyWindow
+	timestamps *timestampView
+	watermarks *eventWatermarks
+	skew       *skewDetector
+	quiet      bool
+
+	startPosition   *int64
+	shutdownTimeout time.Duration
+}
+
+// NewPartitionConsumer creates a consumer for the given partitions, starting
+// at offset (or sarama.OffsetOldest / sarama.OffsetNewest). A start option
+// such as WithStartFrom takes precedence over offset. An empty partition
+// list reads every partition of the topic.
+func NewPartitionConsumer(brokers []string, topic string, partitions []int32, offset int64, opts ...Option) (*PartitionConsumer, error) {
+	config := sarama.NewConfig()
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+	// Values put back together from chunks are chunked again on their way
+	// to a retry topic or the DLQ, and the chunks have to stay together.
+	if o.large.strategy == LargeMessageChunk {
+		config.Producer.Partitioner = pinChunks(config.Producer.Partitioner)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := client.NewReader()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	if len(partitions) == 0 {
+		partitions, err = client.Partitions(topic)
+		if err != nil {
+			consumer.Close()
+			client.Close()
+			return nil, fmt.Errorf("failed to list partitions for topic %s: %w", topic, err)
+		}
+	}
+
+	proc, err := newProcessor(o, client.NewSyncProducer)
+	if err != nil {
+		consumer.Close()
+		client.Close()
+		return nil, err
+	}
+
+	c := &PartitionConsumer{
+		decoder:    o.decoder(),
+		processor:  proc,
+		client:     client,
+		consumer:   consumer,
+		topic:      topic,
+		partitions: partitions,
+		offset:     offset,
+		tracker:    NewPartitionTracker(),
+		latency:    newLatencyWindow(o.latencyReport),
+		timestamps: newTimestampView(o),
+		watermarks: newEventWatermarks(o),
+		quiet:      o.quiet,
+
+		startPosition:   o.startPosition,
+		shutdownTimeout: o.shutdownTimeout,
+	}
+	c.tracker.SetTopic(topic)
+	c.skew = newSkewDetector(o, c.PartitionTrackers)
+	return c, nil
+}
+
+// Partitions returns the partitions being consumed.
+func (c *PartitionConsumer) Partitions() []int32 {
+	return c.partitions
+}
+
+// Consume reads every assigned partition until ctx is cancelled, then gives
+// messages already being processed up to the shutdown timeout to finish.
+func (c *PartitionConsumer) Consume(ctx context.Context) error {
+	var pcs []PartitionReader
+	closeStarted := func() {
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+	}
+
+	for _, partition := range c.partitions {
+		offset := c.offset
+		if c.startPosition != nil {
+			resolved, err := resolveOffset(c.client, c.topic, partition, *c.startPosition)
+			if err != nil {
+				closeStarted()
+				return err
+			}
+			offset = resolved
+		}
+
+		pc, err := c.consumer.ConsumePartition(c.topic, partition, offset)
+		if err != nil {
+			closeStarted()
+			return fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+		pcs = append(pcs, pc)
+	}
+
+	processCtx, cancel := drainContext(ctx, c.shutdownTimeout)
+	defer cancel()
+
+	go c.latency.run(ctx)
+	go c.skew.run(ctx)
+
+	var wg sync.WaitGroup
+	for _, pc := range pcs {
+		wg.Add(1)
+		go func(pc PartitionReader) {
+			defer wg.Done()
+			c.consumePartition(ctx, processCtx, pc, c.tracker)
+		}(pc)
+	}
+
+	<-ctx.Done()
+	closeStarted()
+	wg.Wait()
+
+	if c.tracker.Len() > 0 {
+		c.tracker.LogSummary("came from")
+	}
+	c.skew.logSummary()
+	c.latency.log()
+	c.timestamps.log()
+	c.watermarks.log()
+	return nil
+}
+
+// PartitionTrackers returns the tracker of the consumed topic.
+func (c *PartitionConsumer) PartitionTrackers() []*PartitionTracker {
+	return []*PartitionTracker{c.tracker}
+}
+
+func (c *PartitionConsumer) consumePartition(ctx, processCtx context.Context, pc PartitionReader, tracker *PartitionTracker) {
+	messageCount := 0
+	for {
+		select {
+		case message, ok := <-pc.Messages():
+			if !ok || ctx.Err() != nil {
+				return
+			}
+
+			messageCount++
+			userID := string(message.Key)
+			tracker.RecordSize(userID, message.Partition, len(message.Key)+len(message.Value))
+			c.metrics.MessageConsumed(message.Topic, message.Partition, pc.HighWaterMarkOffset()-message.Offset-1)
+			c.latency.observe(c.metrics, message, time.Now())
+			c.timestamps.observe(message)
+
+			if eventTime, watermark, late := c.watermarks.observe(message); late && c.lateTopic != "" {
+				if err := c.routeLate(message, eventTime, watermark); err != nil {
+					slog.Error("Stopping partition", "topic", message.Topic, "partition", message.Partition, "error", err)
+					return
+				}
+				continue
+			}
+
+			if c.filtered(message) {
+				continue
+			}
+
+			if !c.quiet {
+				slog.Info("Message received", "count", messageCount, "topic", message.Topic,
+					"partition", message.Partition, "offset", message.Offset, "key", userID, "value", c.describeValue(message),
+					"headers", MessageHeaders(message))
+			}
+
+			if err := c.waitUntilDue(ctx, message); err != nil {
+				return
+			}
+
+			if err := c.process(processCtx, message); err != nil {
+				slog.Error("Stopping partition", "topic", message.Topic, "partition", message.Partition, "error", err)
+				return
+			}
+
+		case err, ok := <-pc.Errors():
+			if !ok {
+				return
+			}
+			slog.Error("Error consuming partition", "topic", err.Topic, "partition", err.Partition, "error", err.Err)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *PartitionConsumer) Close() error {
+	if err := c.processor.close(); err != nil {
+		slog.Error("Failed to close message processor", "error", err)
+	}
+	if err := c.consumer.Close(); err != nil {
+		c.client.Close()
+		return err
+	}
+	return c.client.Close()
+}
+
+// ParsePartitions parses a comma-separated list of partition numbers such as "0,2".
+func ParsePartitions(value string) ([]int32, error) {
+	var partitions []int32
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		p, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partition %q", part)
+		}
+		partitions = append(partitions, int32(p))
+	}
+	return partitions, nil
+}
+
+// ParseOffset parses "oldest", "newest" or an absolute offset.
+func ParseOffset(value string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "oldest", "earliest":
+		return sarama.OffsetOldest, nil
+	case "newest", "latest":
+		return sarama.OffsetNewest, nil
+	}
+
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	return offset, nil
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	PartitionerHash       = "hash"
+	PartitionerMurmur2    = "murmur2"
+	PartitionerRoundRobin = "roundrobin"
+	PartitionerManual     = "manual"
+	PartitionerRandom     = "random"
+)
+
+// NewPartitionerConstructor returns the sarama partitioner for name.
+//
+//   - hash: sarama's default FNV-1a hash of the key
+//   - murmur2: the Java client's default, so keys land on the same partitions as Java producers
+//   - roundrobin: ignore the key and cycle through partitions
+//   - manual: use the partition set on each message
+//   - random: ignore the key and pick a random partition
+func NewPartitionerConstructor(name string) (sarama.PartitionerConstructor, error) {
+	switch strings.ToLower(name) {
+	case "", PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+	case PartitionerMurmur2:
+		return NewMurmur2Partitioner, nil
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner, nil
+	case PartitionerManual:
+		return sarama.NewManualPartitioner, nil
+	case PartitionerRandom:
+		return sarama.NewRandomPartitioner, nil
+	default:
+		return nil, fmt.Errorf("unsupported partitioner: %s", name)
+	}
+}
+
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+// NewMurmur2Partitioner creates a partitioner compatible with the Java
+// client's DefaultPartitioner. Messages without a key are spread randomly.
+func NewMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+
+	return toPositive(murmur2(key)) % numPartitions, nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2 is a port of org.apache.kafka.common.utils.Utils.murmur2.
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
+
+func toPositive(n int32) int32 {
+	return n & 0x7fffffff
+}
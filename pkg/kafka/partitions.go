@@ -0,0 +1,215 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// PartitionTracker records which partitions each key was seen on, and with
+// RecordSize how many bytes each partition got. It is safe for concurrent
+// use.
+type PartitionTracker struct {
+	mu          sync.Mutex
+	partitions  map[string][]int32
+	bytes       map[int32]int64
+	partitioner string
+	keyStrategy string
+	topic       string
+}
+
+// TrackingConsumer is implemented by consumers that record which partitions
+// the keys of the consumed messages came from, one tracker per topic.
+type TrackingConsumer interface {
+	PartitionTrackers() []*PartitionTracker
+}
+
+func NewPartitionTracker() *PartitionTracker {
+	return &PartitionTracker{partitions: make(map[string][]int32), bytes: make(map[int32]int64)}
+}
+
+// SetPartitioner records the partitioning strategy so it is reported in the summary.
+func (t *PartitionTracker) SetPartitioner(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partitioner = name
+}
+
+// SetKeyStrategy records how the producer keyed messages so it is reported
+// in the summary.
+func (t *PartitionTracker) SetKeyStrategy(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keyStrategy = name
+}
+
+// SetTopic records the topic the keys belong to so it is reported in the summary.
+func (t *PartitionTracker) SetTopic(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.topic = topic
+}
+
+// Topic returns the topic set with SetTopic.
+func (t *PartitionTracker) Topic() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.topic
+}
+
+// Record notes that a message with the given key was seen on partition.
+func (t *PartitionTracker) Record(key string, partition int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partitions[key] = append(t.partitions[key], partition)
+}
+
+// RecordSize is Record for a message of size bytes.
+func (t *PartitionTracker) RecordSize(key string, partition int32, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partitions[key] = append(t.partitions[key], partition)
+	t.bytes[partition] += int64(size)
+}
+
+// Len returns the number of distinct keys recorded.
+func (t *PartitionTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.partitions)
+}
+
+// Count returns the number of messages recorded for key.
+func (t *PartitionTracker) Count(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.partitions[key])
+}
+
+// Keys returns the recorded keys in sorted order.
+func (t *PartitionTracker) Keys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.partitions))
+	for key := range t.partitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UniquePartitions returns the distinct partitions seen for key in sorted order.
+func (t *PartitionTracker) UniquePartitions(key string) []int32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[int32]bool)
+	var unique []int32
+	for _, p := range t.partitions[key] {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+	return unique
+}
+
+// maxSummaryKeys caps the per-key lines of the summary. With more keys,
+// e.g. uuid keys, only the totals per partition are logged.
+const maxSummaryKeys = 50
+
+// nullKeyLabel stands in for messages without a key in the summary.
+const nullKeyLabel = "<null>"
+
+// LogSummary prints the partition distribution per key and what it means for
+// ordering. verb describes the direction, e.g. "went to" for producers and
+// "came from" for consumers.
+func (t *PartitionTracker) LogSummary(verb string) {
+	t.mu.Lock()
+	partitioner, keyStrategy, topic := t.partitioner, t.keyStrategy, t.topic
+	t.mu.Unlock()
+
+	var scope []any
+	if topic != "" {
+		scope = []any{"topic", topic}
+	}
+
+	keys := t.Keys()
+	summary := append(scope, "keys", len(keys), "messages_per_partition", t.PartitionCounts())
+	if partitioner != "" {
+		summary = append(summary, "partitioner", partitioner)
+	}
+	if keyStrategy != "" {
+		summary = append(summary, "key_strategy", keyStrategy)
+	}
+	slog.Info("Partition distribution summary", summary...)
+
+	if len(keys) <= maxSummaryKeys {
+		for _, key := range keys {
+			label := key
+			if key == "" {
+				label = nullKeyLabel
+			}
+			slog.Info("Messages "+verb+" partitions", append(scope, "key", label,
+				"messages", t.Count(key), "partitions", t.UniquePartitions(key))...)
+		}
+	} else {
+		slog.Info("Too many keys to list each one", append(scope, "keys", len(keys), "limit", maxSummaryKeys)...)
+	}
+
+	for _, note := range t.explain(partitioner) {
+		slog.Info("Partition distribution explained", append(scope, "note", note)...)
+	}
+}
+
+// PartitionCounts returns the number of messages recorded per partition.
+func (t *PartitionTracker) PartitionCounts() map[int32]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[int32]int)
+	for _, partitions := range t.partitions {
+		for _, p := range partitions {
+			counts[p]++
+		}
+	}
+	return counts
+}
+
+// explain describes what the recorded distribution means for ordering.
+func (t *PartitionTracker) explain(partitioner string) []string {
+	var notes []string
+	keyed, split, single := 0, 0, 0
+	for _, key := range t.Keys() {
+		if key == "" {
+			notes = append(notes, fmt.Sprintf("%d messages had no key and were spread over partitions %v by the partitioner, so there is no ordering between them",
+				t.Count(key), t.UniquePartitions(key)))
+			continue
+		}
+		keyed++
+		switch {
+		case len(t.UniquePartitions(key)) > 1:
+			split++
+		case t.Count(key) == 1:
+			single++
+		}
+	}
+
+	switch {
+	case keyed == 0:
+	case split > 0:
+		reason := "the producer did not route them by key"
+		if partitioner != "" {
+			reason = fmt.Sprintf("the %s partitioner ignores keys", partitioner)
+		}
+		notes = append(notes, fmt.Sprintf("%d of %d keys were split over several partitions because %s, so their messages are not ordered", split, keyed, reason))
+	case single == keyed:
+		notes = append(notes, "Every key was used once, so the keys spread messages much like null keys and no two messages share an ordered stream")
+	default:
+		notes = append(notes, "Every key stayed on one partition, so the messages of each key are ordered; keys share partitions by hash, which is why some partitions get more messages")
+	}
+	return notes
+}
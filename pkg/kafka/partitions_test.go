@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// captureLogs makes the default logger write to the returned buffer until
+// the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestPartitionTrackerCounts(t *testing.T) {
+	tracker := NewPartitionTracker()
+	tracker.Record("user-2", 1)
+	tracker.Record("user-1", 0)
+	tracker.Record("user-1", 0)
+	tracker.Record("user-2", 2)
+	tracker.Record("user-2", 1)
+	tracker.Record("", 0)
+
+	if got := tracker.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+	if got, want := tracker.Keys(), []string{"", "user-1", "user-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %q, want %q", got, want)
+	}
+	for key, want := range map[string]int{"user-1": 2, "user-2": 3, "": 1, "user-3": 0} {
+		if got := tracker.Count(key); got != want {
+			t.Errorf("Count(%q) = %d, want %d", key, got, want)
+		}
+	}
+	if got, want := tracker.UniquePartitions("user-2"), []int32{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UniquePartitions(user-2) = %v, want %v", got, want)
+	}
+	if got := tracker.UniquePartitions("user-3"); len(got) != 0 {
+		t.Errorf("UniquePartitions(user-3) = %v, want none", got)
+	}
+	if got, want := tracker.PartitionCounts(), map[int32]int{0: 3, 1: 2, 2: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PartitionCounts() = %v, want %v", got, want)
+	}
+}
+
+func TestPartitionTrackerSizes(t *testing.T) {
+	tracker := NewPartitionTracker()
+	tracker.RecordSize("user-1", 0, 100)
+	tracker.RecordSize("user-1", 0, 50)
+	tracker.RecordSize("user-2", 1, 300)
+	tracker.Record("user-3", 2)
+
+	if got := tracker.Count("user-1"); got != 2 {
+		t.Errorf("Count(user-1) = %d, want 2", got)
+	}
+	skew := tracker.Skew(3, 0)
+	if skew.Messages != 4 || skew.Bytes != 450 {
+		t.Errorf("skew counts %d messages, %d bytes, want 4, 450", skew.Messages, skew.Bytes)
+	}
+	want := map[int32]int64{0: 150, 1: 300, 2: 0}
+	for _, load := range skew.Load {
+		if load.Bytes != want[load.Partition] {
+			t.Errorf("partition %d has %d bytes, want %d", load.Partition, load.Bytes, want[load.Partition])
+		}
+	}
+	if len(skew.Load) != len(want) {
+		t.Errorf("skew has %d partitions with messages, want %d", len(skew.Load), len(want))
+	}
+}
+
+func TestPartitionTrackerConcurrentRecord(t *testing.T) {
+	tracker := NewPartitionTracker()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tracker.RecordSize(fmt.Sprintf("user-%d", j%10), int32(i%3), 10)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := tracker.Len(); got != 10 {
+		t.Errorf("Len() = %d, want 10", got)
+	}
+	total := 0
+	for _, n := range tracker.PartitionCounts() {
+		total += n
+	}
+	if total != 800 {
+		t.Errorf("recorded %d messages, want 800", total)
+	}
+}
+
+func TestPartitionTrackerLogSummary(t *testing.T) {
+	logs := captureLogs(t)
+	tracker := NewPartitionTracker()
+	tracker.SetTopic("orders")
+	tracker.SetPartitioner(PartitionerHash)
+	tracker.SetKeyStrategy(KeyUserID)
+	tracker.Record("user-1", 0)
+	tracker.Record("user-1", 0)
+	tracker.Record("user-2", 1)
+	tracker.Record("", 0)
+	tracker.Record("", 2)
+	tracker.LogSummary("went to")
+
+	output := logs.String()
+	for _, want := range []string{
+		`msg="Partition distribution summary" topic=orders keys=3 messages_per_partition="map[0:3 1:1 2:1]" partitioner=hash key_strategy=user_id`,
+		`msg="Messages went to partitions" topic=orders key=<null> messages=2 partitions="[0 2]"`,
+		`msg="Messages went to partitions" topic=orders key=user-1 messages=2 partitions=[0]`,
+		`msg="Messages went to partitions" topic=orders key=user-2 messages=1 partitions=[1]`,
+		"2 messages had no key and were spread over partitions [0 2]",
+		"Every key stayed on one partition",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("summary does not contain %s:\n%s", want, output)
+		}
+	}
+}
+
+func TestPartitionTrackerLogSummaryNotes(t *testing.T) {
+	tests := []struct {
+		name        string
+		partitioner string
+		record      func(*PartitionTracker)
+		want        string
+	}{
+		{"split by partitioner", PartitionerRoundRobin, func(tr *PartitionTracker) {
+			tr.Record("user-1", 0)
+			tr.Record("user-1", 1)
+			tr.Record("user-2", 2)
+		}, "1 of 2 keys were split over several partitions because the roundrobin partitioner ignores keys"},
+		{"split without partitioner", "", func(tr *PartitionTracker) {
+			tr.Record("user-1", 0)
+			tr.Record("user-1", 1)
+		}, "because the producer did not route them by key"},
+		{"every key once", PartitionerHash, func(tr *PartitionTracker) {
+			tr.Record("user-1", 0)
+			tr.Record("user-2", 1)
+		}, "Every key was used once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			tracker := NewPartitionTracker()
+			tracker.SetPartitioner(tt.partitioner)
+			tt.record(tracker)
+			tracker.LogSummary("came from")
+			if !strings.Contains(logs.String(), tt.want) {
+				t.Errorf("summary does not contain %q:\n%s", tt.want, logs)
+			}
+			if strings.Contains(logs.String(), "topic=") {
+				t.Errorf("summary without a topic logs one:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestPartitionTrackerLogSummaryManyKeys(t *testing.T) {
+	logs := captureLogs(t)
+	tracker := NewPartitionTracker()
+	for i := 0; i <= maxSummaryKeys; i++ {
+		tracker.Record(fmt.Sprintf("key-%03d", i), int32(i%4))
+	}
+	tracker.LogSummary("went to")
+
+	output := logs.String()
+	if !strings.Contains(output, fmt.Sprintf(`msg="Too many keys to list each one" keys=%d limit=%d`, maxSummaryKeys+1, maxSummaryKeys)) {
+		t.Errorf("summary does not say there are too many keys:\n%s", output)
+	}
+	if strings.Contains(output, "Messages went to partitions") {
+		t.Errorf("summary lists keys although there are more than %d:\n%s", maxSummaryKeys, output)
+	}
+}
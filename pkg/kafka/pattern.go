@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTopicRefresh = 30 * time.Second
+
+// WithTopicRefresh sets how often a pattern consumer lists the cluster's
+// topics to look for new matches. Defaults to 30 seconds.
+func WithTopicRefresh(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("topic refresh interval must be positive, got %s", interval)
+		}
+		o.topicRefresh = interval
+		return nil
+	}
+}
+
+// NewPatternConsumer is like NewMultiTopicConsumer but subscribes to every
+// topic whose name matches pattern, e.g. "^events-.*". Not every backend
+// has a pattern subscription, so the consumer lists the topics through the
+// admin client every WithTopicRefresh interval and rejoins the group when
+// the set of matching topics changes, which rebalances the new topics onto
+// the group.
+func NewPatternConsumer(brokers []string, pattern, groupID string, opts ...Option) (*Consumer, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+	}
+
+	c, err := newConsumer(brokers, nil, groupID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The admin shares the consumer's client and is closed with the
+	// consumer.
+	admin, err := c.client.NewAdmin()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("topic patterns need a cluster admin: %w", err)
+	}
+	c.pattern = re
+	c.admin = admin
+	return c, nil
+}
+
+// matchingTopics lists the topics that match the consumer's pattern, sorted
+// by name. Internal topics and the consumer's own retry and dead-letter
+// topics are left out even if the pattern matches them.
+func (c *Consumer) matchingTopics() ([]string, error) {
+	details, err := c.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var topics []string
+	for topic := range details {
+		if strings.HasPrefix(topic, "__") || topic == c.dlqTopic || topic == c.lateTopic || !c.pattern.MatchString(topic) {
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	own := make(map[string]bool)
+	for _, topic := range topics {
+		for _, retryTopic := range c.retryTopics(topic) {
+			own[retryTopic] = true
+		}
+	}
+	matched := topics[:0]
+	for _, topic := range topics {
+		if !own[topic] {
+			matched = append(matched, topic)
+		}
+	}
+
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// awaitTopics lists the matching topics, retrying every refresh interval
+// until at least one exists or ctx is cancelled.
+func (c *Consumer) awaitTopics(ctx context.Context) ([]string, error) {
+	for {
+		topics, err := c.matchingTopics()
+		if err != nil {
+			return nil, err
+		}
+		if len(topics) > 0 {
+			return topics, nil
+		}
+
+		slog.Info("No topics match pattern yet, waiting", "pattern", c.pattern.String(), "refresh", c.topicRefresh)
+		select {
+		case <-time.After(c.topicRefresh):
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// watchTopics calls rejoin once the matching topics differ from current.
+// Failed listings are logged and retried on the next tick.
+func (c *Consumer) watchTopics(ctx context.Context, current []string, rejoin func()) {
+	ticker := time.NewTicker(c.topicRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			topics, err := c.matchingTopics()
+			if err != nil {
+				slog.Warn("Failed to refresh topics matching pattern", "pattern", c.pattern.String(), "error", err)
+				continue
+			}
+
+			added, removed := diffTopics(current, topics)
+			if len(added) == 0 && len(removed) == 0 {
+				continue
+			}
+			slog.Info("Topics matching pattern changed, rejoining group", "pattern", c.pattern.String(),
+				"added", added, "removed", removed)
+			rejoin()
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diffTopics returns the topics only in next and only in prev.
+func diffTopics(prev, next []string) (added, removed []string) {
+	seen := make(map[string]bool, len(prev))
+	for _, topic := range prev {
+		seen[topic] = true
+	}
+	for _, topic := range next {
+		if seen[topic] {
+			delete(seen, topic)
+		} else {
+			added = append(added, topic)
+		}
+	}
+	for topic := range seen {
+		removed = append(removed, topic)
+	}
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,278 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TransformFunc turns a consumed message into zero or more messages for the
+// output topic. Messages without a topic are sent to the pipeline's output topic.
+type TransformFunc func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error)
+
+// Pipeline is a consume-transform-produce loop. With a transactional ID it
+// has exactly-once semantics: the transformed messages and the consumed
+// offsets are committed in the same transaction, so a crash never produces
+// duplicates or loses input. Without one, outputs and offsets are committed
+// separately, in the order WithDeliverySemantics chooses.
+type Pipeline struct {
+	decoder
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	inputTopic        string
+	outputTopic       string
+	groupID           string
+	transform         TransformFunc
+	transactional     bool
+	semantics         string
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+
+	shutdownTimeout time.Duration
+	processCtx      context.Context
+
+	// A transactional producer can only have one open transaction, so
+	// claims take turns.
+	mu sync.Mutex
+}
+
+// NewPipeline creates a pipeline from inputTopic to outputTopic. The consumer
+// reads with read_committed isolation. If transactionalID is set it never
+// auto-commits, and offsets only move when a transaction commits; if it is
+// empty the pipeline commits offsets like a Consumer does.
+func NewPipeline(brokers []string, inputTopic, outputTopic, groupID, transactionalID string, transform TransformFunc, opts ...Option) (*Pipeline, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumerConfig.Consumer.Offsets.AutoCommit.Enable = transactionalID == ""
+	consumerConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	producerOpts := opts
+	if transactionalID != "" {
+		producerOpts = append(opts[:len(opts):len(opts)], WithTransactionalID(transactionalID))
+	}
+	po, err := newOptions(producerConfig, producerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := o.newConsumerGroup(brokers, groupID)
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Pipeline{
+		decoder:           o.decoder(),
+		consumer:          consumer,
+		producer:          producer,
+		inputTopic:        inputTopic,
+		outputTopic:       outputTopic,
+		groupID:           groupID,
+		transform:         transform,
+		transactional:     transactionalID != "",
+		semantics:         o.semantics,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+
+		shutdownTimeout: o.shutdownTimeout,
+	}, nil
+}
+
+// Consume runs the pipeline until ctx is cancelled. A transaction that is
+// open at that point gets up to the shutdown timeout to commit.
+func (p *Pipeline) Consume(ctx context.Context) error {
+	processCtx, cancel := drainContext(ctx, p.shutdownTimeout)
+	defer cancel()
+	p.processCtx = processCtx
+	defer p.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := p.consumer.Consume(ctx, []string{p.inputTopic}, p); err != nil {
+			failures++
+			if werr := p.backoff.wait(ctx, failures, "Pipeline group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (p *Pipeline) Setup(session GroupSession) error {
+	rebalance := p.rebalances.Record(p.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Pipeline setup completed", "input_topic", p.inputTopic, "output_topic", p.outputTopic, "group", p.groupID,
+		"strategy", p.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+func (p *Pipeline) Cleanup(session GroupSession) error {
+	rebalance := p.rebalances.Record(p.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Pipeline cleanup completed", "input_topic", p.inputTopic, "output_topic", p.outputTopic, "group", p.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (p *Pipeline) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: p.rebalanceStrategy, GroupID: p.groupID, InstanceID: p.instanceID}
+}
+
+func (p *Pipeline) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return nil
+			}
+
+			if !p.transactional {
+				if err := p.process(p.processCtx, session, message); err != nil {
+					slog.Error("Failed to produce outputs", "topic", message.Topic, "partition", message.Partition,
+						"offset", message.Offset, "error", err)
+					return err
+				}
+				continue
+			}
+
+			if err := p.processInTxn(p.processCtx, message); err != nil {
+				// Ending the claim ends the session; the group rejoins and
+				// resumes from the last committed offset.
+				slog.Error("Transaction aborted", "topic", message.Topic, "partition", message.Partition,
+					"offset", message.Offset, "error", err)
+				return err
+			}
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// outputs transforms message. A message that can't be transformed will
+// never succeed, so it has no outputs but its offset is still committed to
+// avoid blocking the partition.
+func (p *Pipeline) outputs(ctx context.Context, message *sarama.ConsumerMessage) []*sarama.ProducerMessage {
+	outputs, err := p.transform(ctx, message)
+	if err != nil {
+		slog.Warn("Skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		return nil
+	}
+	for _, out := range outputs {
+		if out.Topic == "" {
+			out.Topic = p.outputTopic
+		}
+	}
+	return outputs
+}
+
+// process produces the outputs of message without a transaction.
+// At-least-once marks the offset once every output is acknowledged, so a
+// crash in between produces them again. At-most-once commits the offset
+// first, so a crash in between, or an output that can't be produced, loses
+// them.
+func (p *Pipeline) process(ctx context.Context, session GroupSession, message *sarama.ConsumerMessage) error {
+	if p.semantics == DeliveryAtMostOnce {
+		session.MarkOffset(message.Topic, message.Partition, message.Offset+1, "")
+		session.Commit()
+	}
+
+	outputs := p.outputs(ctx, message)
+	for _, out := range outputs {
+		err := p.backoff.Retry(ctx, "Producing to "+out.Topic, func() error {
+			_, _, err := p.producer.SendMessage(resendable(out))
+			return err
+		})
+		if err == nil {
+			continue
+		}
+		if p.semantics == DeliveryAtMostOnce {
+			slog.Error("Output lost", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "output_topic", out.Topic, "error", err)
+			continue
+		}
+		return fmt.Errorf("failed to produce to %s: %w", out.Topic, err)
+	}
+
+	if p.semantics != DeliveryAtMostOnce {
+		session.MarkMessage(message, "")
+	}
+	slog.Info("Message processed", "topic", message.Topic, "partition", message.Partition,
+		"offset", message.Offset, "outputs", len(outputs), "semantics", p.semantics)
+	return nil
+}
+
+func (p *Pipeline) processInTxn(ctx context.Context, message *sarama.ConsumerMessage) error {
+	outputs := p.outputs(ctx, message)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, out := range outputs {
+		if _, _, err := p.producer.SendMessage(out); err != nil {
+			return abortWith(p.producer, fmt.Errorf("failed to produce to %s: %w", out.Topic, err))
+		}
+	}
+
+	if err := p.producer.AddMessageToTxn(message, p.groupID, nil); err != nil {
+		return abortWith(p.producer, fmt.Errorf("failed to add offset to transaction: %w", err))
+	}
+
+	if err := p.producer.CommitTxn(); err != nil {
+		return abortWith(p.producer, fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	slog.Info("Transaction committed", "topic", message.Topic, "partition", message.Partition,
+		"offset", message.Offset, "outputs", len(outputs))
+	return nil
+}
+
+func (p *Pipeline) Close() error {
+	consumerErr := p.consumer.Close()
+	if err := p.producer.Close(); err != nil {
+		return err
+	}
+	return consumerErr
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrHandlerPanic wraps the value a handler panicked with.
+var ErrHandlerPanic = errors.New("handler panicked")
+
+// PoisonPill is a message that was skipped because it kept failing.
+type PoisonPill struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error"`
+	SkippedAt time.Time         `json:"skipped_at"`
+}
+
+// PoisonPillRecorder keeps skipped messages for inspection. It must be safe
+// for concurrent use.
+type PoisonPillRecorder interface {
+	Record(pill PoisonPill) error
+}
+
+// WithPoisonPillDetection skips messages once their handler failed attempts
+// times, counting the in-place retries and every redelivery of the same
+// offset, e.g. after forwarding to the DLQ failed or the consumer was
+// restarted by a rebalance. Skipped messages are neither retried nor
+// dead-lettered; they are logged and passed to recorder, which may be nil.
+// A handler that panics counts as a failed attempt instead of crashing the
+// consumer.
+func WithPoisonPillDetection(attempts int, recorder PoisonPillRecorder) Option {
+	return func(o *options) error {
+		if attempts < 1 {
+			return fmt.Errorf("poison pill attempts must be at least 1, got %d", attempts)
+		}
+		o.poisonAttempts = attempts
+		o.poisonRecorder = recorder
+		return nil
+	}
+}
+
+type poisonKey struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// poisonTracker counts the failed attempts of messages that haven't been
+// handled or skipped yet.
+type poisonTracker struct {
+	limit    int
+	recorder PoisonPillRecorder
+
+	mu       sync.Mutex
+	failures map[poisonKey]poisonFailures
+}
+
+type poisonFailures struct {
+	attempts int
+	last     error
+}
+
+func newPoisonTracker(limit int, recorder PoisonPillRecorder) *poisonTracker {
+	if limit <= 0 {
+		return nil
+	}
+	return &poisonTracker{limit: limit, recorder: recorder, failures: make(map[poisonKey]poisonFailures)}
+}
+
+func poisonKeyOf(message *sarama.ConsumerMessage) poisonKey {
+	return poisonKey{topic: message.Topic, partition: message.Partition, offset: message.Offset}
+}
+
+// failed counts a failed attempt and reports whether message is now a
+// poison pill, along with the attempts so far.
+func (t *poisonTracker) failed(message *sarama.ConsumerMessage, cause error) (attempts int, poison bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := poisonKeyOf(message)
+	f := t.failures[key]
+	f.attempts++
+	f.last = cause
+	t.failures[key] = f
+	return f.attempts, f.attempts >= t.limit
+}
+
+// exhausted reports whether message already failed as often as allowed on an
+// earlier delivery, with the attempts and the last error.
+func (t *poisonTracker) exhausted(message *sarama.ConsumerMessage) (attempts int, last error, poison bool) {
+	if t == nil {
+		return 0, nil, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := t.failures[poisonKeyOf(message)]
+	return f.attempts, f.last, f.attempts >= t.limit
+}
+
+// forget drops the count of a message that was handled, forwarded or
+// skipped.
+func (t *poisonTracker) forget(message *sarama.ConsumerMessage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.failures, poisonKeyOf(message))
+	t.mu.Unlock()
+}
+
+// skip logs and records message as a poison pill.
+func (t *poisonTracker) skip(message *sarama.ConsumerMessage, attempts int, cause error) {
+	t.forget(message)
+
+	reason := cause.Error()
+	slog.Error("Poison pill skipped", "topic", message.Topic, "partition", message.Partition,
+		"offset", message.Offset, "key", string(message.Key), "attempts", attempts, "error", reason)
+
+	if t.recorder == nil {
+		return
+	}
+	pill := PoisonPill{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Value:     string(message.Value),
+		Headers:   MessageHeaders(message),
+		Attempts:  attempts,
+		Error:     reason,
+		SkippedAt: time.Now().UTC(),
+	}
+	if err := t.recorder.Record(pill); err != nil {
+		slog.Warn("Failed to record poison pill", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+	}
+}
+
+// PoisonPillFile appends poison pills to a file as JSON lines.
+type PoisonPillFile struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewPoisonPillFile opens path for appending, creating it if needed.
+func NewPoisonPillFile(path string) (*PoisonPillFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open poison pill file: %w", err)
+	}
+	return &PoisonPillFile{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (f *PoisonPillFile) Record(pill PoisonPill) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enc.Encode(pill)
+}
+
+func (f *PoisonPillFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,277 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// priorityStaleAfter is how long the backlog a partition reported counts
+// without a new message from it. It covers gaps that never deliver a
+// message, such as transaction markers, and partitions that stopped
+// fetching, e.g. paused ones.
+const priorityStaleAfter = time.Second
+
+// TopicPriority is a topic of WithTopicPriorities and its weight: how many
+// of its messages are processed in a round while other topics wait.
+type TopicPriority struct {
+	Topic  string
+	Weight int
+}
+
+// ParseTopicPriorities parses a comma-separated list of topics, highest
+// priority first, each with an optional weight, such as "alerts:9,events:1".
+// The weight defaults to 1.
+func ParseTopicPriorities(value string) ([]TopicPriority, error) {
+	var priorities []TopicPriority
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		p := TopicPriority{Topic: part, Weight: 1}
+		if topic, weight, ok := strings.Cut(part, ":"); ok {
+			n, err := strconv.Atoi(weight)
+			if err != nil || n < 0 || topic == "" {
+				return nil, fmt.Errorf("invalid topic priority %q, want topic:weight", part)
+			}
+			p = TopicPriority{Topic: topic, Weight: n}
+		}
+		priorities = append(priorities, p)
+	}
+	return priorities, nil
+}
+
+// PriorityTopics returns the topics of priorities, highest priority first.
+func PriorityTopics(priorities []TopicPriority) []string {
+	topics := make([]string, len(priorities))
+	for i, p := range priorities {
+		topics[i] = p.Topic
+	}
+	return topics
+}
+
+// WithTopicPriorities makes a multi-topic consumer process its topics by
+// priority, highest first. While a topic has a backlog, the topics below it
+// wait until it has had Weight messages in the current round; then each of
+// them gets its own Weight, and the round starts over once every topic with
+// a backlog had its turn. With "alerts:9,events:1" a backlog of events gets
+// one message for every nine alerts instead of starving, and weight 0 only
+// lets a topic through while every topic above it is drained. A topic
+// without a backlog never holds up the others. The backlog is what the
+// member's own partitions have left, so priorities only hold between topics
+// whose partitions the same member reads; messages of other topics, such as
+// retry topics, aren't held back at all.
+func WithTopicPriorities(priorities ...TopicPriority) Option {
+	return func(o *options) error {
+		seen := make(map[string]bool, len(priorities))
+		for _, p := range priorities {
+			if p.Weight < 0 {
+				return fmt.Errorf("weight of topic %s must not be negative, got %d", p.Topic, p.Weight)
+			}
+			if seen[p.Topic] {
+				return fmt.Errorf("topic %s is listed twice in the topic priorities", p.Topic)
+			}
+			seen[p.Topic] = true
+		}
+		o.priorities = priorities
+		return nil
+	}
+}
+
+// priorityScheduler is the consumer side of WithTopicPriorities. The claims
+// of every partition wait in it before they process a message. A nil
+// scheduler lets everything through.
+type priorityScheduler struct {
+	priorities []TopicPriority
+	levels     map[string]int
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever a waiting claim may have
+	// been let through.
+	changed chan struct{}
+	served  []int
+	waiting []int
+	backlog map[partitionKey]partitionBacklog
+	stats   []priorityStats
+}
+
+// partitionBacklog is the number of messages a partition had left after
+// the last one it let through.
+type partitionBacklog struct {
+	level int
+	lag   int64
+	at    time.Time
+}
+
+type priorityStats struct {
+	messages int
+	// outranked counts the messages let through while a higher-priority
+	// topic had a backlog, the turns that keep the topic from starving.
+	outranked int
+	waited    time.Duration
+	maxWait   time.Duration
+}
+
+func newPriorityScheduler(o *options) *priorityScheduler {
+	if len(o.priorities) == 0 {
+		return nil
+	}
+	s := &priorityScheduler{
+		priorities: o.priorities,
+		levels:     make(map[string]int, len(o.priorities)),
+		changed:    make(chan struct{}),
+		served:     make([]int, len(o.priorities)),
+		waiting:    make([]int, len(o.priorities)),
+		backlog:    make(map[partitionKey]partitionBacklog),
+		stats:      make([]priorityStats, len(o.priorities)),
+	}
+	for i, p := range o.priorities {
+		s.levels[p.Topic] = i
+	}
+	return s
+}
+
+// wait blocks until message may be processed, or ctx is done. hwm is the
+// high water mark of its partition.
+func (s *priorityScheduler) wait(ctx context.Context, message *sarama.ConsumerMessage, hwm int64) error {
+	if s == nil {
+		return nil
+	}
+	level, ok := s.levels[message.Topic]
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+
+	s.mu.Lock()
+	s.waiting[level]++
+	for !s.allowed(level, time.Now()) {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(priorityStaleAfter / 4):
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.waiting[level]--
+			s.broadcast()
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	s.waiting[level]--
+
+	now := time.Now()
+	outranked := s.outranked(level, now)
+	s.served[level]++
+	s.backlog[partitionKey{message.Topic, message.Partition}] = partitionBacklog{level: level, lag: hwm - message.Offset - 1, at: now}
+	waited := now.Sub(start)
+	stats := &s.stats[level]
+	stats.messages++
+	stats.waited += waited
+	stats.maxWait = max(stats.maxWait, waited)
+	if outranked {
+		stats.outranked++
+	}
+	s.broadcast()
+	s.mu.Unlock()
+
+	if outranked {
+		slog.Debug("Lower-priority topic got its turn", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "waited", waited)
+	}
+	return nil
+}
+
+// allowed reports whether a message of level may go now. s.mu must be held.
+func (s *priorityScheduler) allowed(level int, now time.Time) bool {
+	backlogged := s.backlogged(now)
+	for j := 0; j < level; j++ {
+		if backlogged[j] && s.served[j] < s.priorities[j].Weight {
+			return false
+		}
+	}
+	if s.served[level] < s.priorities[level].Weight {
+		return true
+	}
+	for k, b := range backlogged {
+		if k != level && b && s.served[k] < s.priorities[k].Weight {
+			return false
+		}
+	}
+	// Every topic with a backlog has had its turn: a new round starts.
+	clear(s.served)
+	return true
+}
+
+// outranked reports whether a topic above level has a backlog. s.mu must
+// be held.
+func (s *priorityScheduler) outranked(level int, now time.Time) bool {
+	backlogged := s.backlogged(now)
+	for j := 0; j < level; j++ {
+		if backlogged[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// backlogged reports for every level whether it has messages waiting, or a
+// partition that recently had some left. s.mu must be held.
+func (s *priorityScheduler) backlogged(now time.Time) []bool {
+	backlogged := make([]bool, len(s.priorities))
+	for level, n := range s.waiting {
+		backlogged[level] = n > 0
+	}
+	for _, b := range s.backlog {
+		if b.lag > 0 && now.Sub(b.at) < priorityStaleAfter {
+			backlogged[b.level] = true
+		}
+	}
+	return backlogged
+}
+
+// broadcast wakes every waiting claim. s.mu must be held.
+func (s *priorityScheduler) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// release forgets the backlog of a partition once its claim ends, so a
+// revoked partition doesn't hold up the others.
+func (s *priorityScheduler) release(topic string, partition int32) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.backlog, partitionKey{topic, partition})
+	s.broadcast()
+}
+
+// log logs the messages, the turns taken while outranked and the waits of
+// every topic, highest priority first.
+func (s *priorityScheduler) log() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for level, p := range s.priorities {
+		stats := s.stats[level]
+		var avgWait time.Duration
+		if stats.messages > 0 {
+			avgWait = stats.waited / time.Duration(stats.messages)
+		}
+		slog.Info("Priority summary", "topic", p.Topic, "priority", level+1, "weight", p.Weight,
+			"messages", stats.messages, "outranked", stats.outranked, "avg_wait", avgWait, "max_wait", stats.maxWait)
+	}
+}
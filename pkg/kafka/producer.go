@@ -0,0 +1,379 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Producer sends keyed messages to a single topic.
+type Producer struct {
+	producer    SyncProducer
+	topic       string
+	serializer  Serializer
+	partitioner string
+	partition   int32
+	keyFunc     KeyFunc
+	keyStrategy string
+	timestamp   func(UserEvent) time.Time
+	metrics     Metrics
+	backoff     Backoff
+	breaker     *CircuitBreaker
+	encryption  *envelope
+	dictionary  *dictionaryCodec
+	delay       *delayedDelivery
+	large       largeMessages
+	sequencer   *sequencer
+}
+
+// NewProducer creates a synchronous producer that waits for all in-sync
+// replicas to acknowledge each message.
+func NewProducer(brokers []string, topic string, opts ...Option) (*Producer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Compression = sarama.CompressionSnappy
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	// The chunks of a message have to stay together, whatever partition
+	// the partitioner would pick for the ones after the first.
+	if o.large.strategy == LargeMessageChunk {
+		config.Producer.Partitioner = pinChunks(config.Producer.Partitioner)
+	}
+
+	producer, err := o.newSyncProducer(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	// A transaction can't be resumed after a failed send, it has to be
+	// aborted, so only the caller can retry it.
+	backoff := o.backoff
+	if producer.IsTransactional() {
+		backoff.MaxRetries = 0
+	}
+
+	return &Producer{
+		producer:    producer,
+		topic:       topic,
+		serializer:  o.serializer,
+		partitioner: o.partitioner,
+		partition:   o.partition,
+		keyFunc:     o.keyFunc,
+		timestamp:   o.recordTimestamp,
+		keyStrategy: o.keyStrategy,
+		metrics:     o.metrics,
+		backoff:     backoff,
+		breaker:     NewCircuitBreaker(topic, o.breakerThreshold, o.breakerCooldown),
+		encryption:  o.encryption,
+		dictionary:  o.dictionary,
+		delay:       o.delayedDelivery,
+		large:       o.large,
+		sequencer:   o.sequencer,
+	}, nil
+}
+
+// Partitioner returns the name of the partitioning strategy in use.
+func (p *Producer) Partitioner() string {
+	return p.partitioner
+}
+
+// KeyStrategy returns the name of the key strategy in use.
+func (p *Producer) KeyStrategy() string {
+	return p.keyStrategy
+}
+
+// CircuitState returns the state of the circuit breaker, CircuitClosed if
+// there is none.
+func (p *Producer) CircuitState() string {
+	return p.breaker.State()
+}
+
+// Topic returns the topic the producer writes to.
+func (p *Producer) Topic() string {
+	return p.topic
+}
+
+// SendMessage sends a single message and returns the partition and offset it
+// was written to. An empty key sends the message without a key.
+func (p *Producer) SendMessage(key, value string) (int32, int64, error) {
+	return p.SendMessageWithHeaders(key, value, nil)
+}
+
+// SendMessageWithHeaders is SendMessage with the given record headers.
+func (p *Producer) SendMessageWithHeaders(key, value string, headers map[string]string) (int32, int64, error) {
+	return p.send(&sarama.ProducerMessage{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Key:       stringKey(key),
+		Value:     sarama.StringEncoder(value),
+		Headers:   recordHeaders(headers),
+	})
+}
+
+// SendTombstone sends a message with key and no value, which deletes the key
+// from compacted topics and from tables materialized from the topic.
+func (p *Producer) SendTombstone(key string) (int32, int64, error) {
+	return p.send(&sarama.ProducerMessage{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Key:       stringKey(key),
+	})
+}
+
+// SendEvent serializes a user event and sends it keyed by its user ID, or
+// whatever WithKeyStrategy picked. The message carries content type, trace
+// ID, schema version, event type and produced-at headers.
+func (p *Producer) SendEvent(event UserEvent) (int32, int64, error) {
+	return p.SendEventWithHeaders(event, nil)
+}
+
+// SendEventWithHeaders is SendEvent with additional record headers, which
+// take precedence over the default ones.
+func (p *Producer) SendEventWithHeaders(event UserEvent, headers map[string]string) (int32, int64, error) {
+	d := p.Send(event, headers)
+	return d.Partition, d.Offset, d.Err
+}
+
+// Send is SendEventWithHeaders reporting the outcome as a Delivery, which
+// also carries the key the message was sent with.
+func (p *Producer) Send(event UserEvent, headers map[string]string) Delivery {
+	key, keyString := eventKey(p.keyFunc, event)
+	value, err := p.serializer.Serialize(event)
+	if err != nil {
+		return Delivery{Key: keyString, Err: err}
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Key:       key,
+		Value:     sarama.ByteEncoder(value),
+		Headers:   EventHeaders(p.serializer, event, headers),
+		Timestamp: recordTimestamp(p.timestamp, event),
+	}
+	start := time.Now()
+	partition, offset, err := p.send(msg)
+	return Delivery{Key: keyString, Partition: partition, Offset: offset, Bytes: messageSize(msg), Latency: time.Since(start), Err: err}
+}
+
+// SendBatch serializes events and sends them with a single SendMessages
+// call, returning one Delivery per event in the same order. Failures of
+// individual messages are reported in Delivery.Err; the error is only
+// non-nil if an event can't be serialized, in which case nothing is sent.
+// Batches are not retried with the backoff of WithBackoff; while the
+// circuit breaker is open every delivery fails with ErrCircuitOpen. Events
+// chunked by WithLargeMessages are sent one by one after the batch, like
+// SendEvent.
+func (p *Producer) SendBatch(events []UserEvent) ([]Delivery, error) {
+	return p.SendBatchWithHeaders(events, nil)
+}
+
+// SendBatchWithHeaders is SendBatch with additional record headers on every
+// message.
+func (p *Producer) SendBatchWithHeaders(events []UserEvent, headers map[string]string) ([]Delivery, error) {
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	indexes := make([]int, 0, len(events)) // the event of each message
+	chunked := make([][]*sarama.ProducerMessage, len(events))
+	keys := make([]string, len(events))
+	sizes := make([]int, len(events))
+	for i, event := range events {
+		value, err := p.serializer.Serialize(event)
+		if err != nil {
+			return nil, err
+		}
+		var key sarama.Encoder
+		key, keys[i] = eventKey(p.keyFunc, event)
+		msg := &sarama.ProducerMessage{
+			Topic:     p.topic,
+			Partition: p.partition,
+			Key:       key,
+			Value:     sarama.ByteEncoder(value),
+			Headers:   EventHeaders(p.serializer, event, headers),
+			Timestamp: recordTimestamp(p.timestamp, event),
+		}
+		if err := p.delay.schedule(msg); err != nil {
+			return nil, err
+		}
+		if err := p.sequencer.stamp(msg); err != nil {
+			return nil, err
+		}
+		if err := p.dictionary.compress(msg); err != nil {
+			return nil, err
+		}
+		if err := p.encryption.seal(msg); err != nil {
+			return nil, err
+		}
+		sizes[i] = messageSize(msg)
+		prepared, err := p.large.prepare(msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(prepared) > 1 {
+			chunked[i] = prepared
+			continue
+		}
+		msgs = append(msgs, prepared[0])
+		indexes = append(indexes, i)
+	}
+
+	deliveries := make([]Delivery, len(events))
+	if len(msgs) > 0 {
+		p.sendBatch(msgs, indexes, keys, deliveries)
+	}
+	for i, chunks := range chunked {
+		if chunks == nil {
+			continue
+		}
+		start := time.Now()
+		partition, offset, err := p.sendChunks(chunks)
+		deliveries[i] = Delivery{Key: keys[i], Partition: partition, Offset: offset, Latency: time.Since(start), Err: err}
+	}
+	for i := range deliveries {
+		deliveries[i].Bytes = sizes[i]
+	}
+	return deliveries, nil
+}
+
+// sendBatch sends msgs with a single SendMessages call and reports the
+// outcome of msgs[j] in deliveries[indexes[j]].
+func (p *Producer) sendBatch(msgs []*sarama.ProducerMessage, indexes []int, keys []string, deliveries []Delivery) {
+	start := time.Now()
+	err := p.breaker.Allow()
+	if err == nil {
+		err = p.producer.SendMessages(msgs)
+	}
+	latency := time.Since(start)
+
+	failures := make(map[*sarama.ProducerMessage]error)
+	var perrs sarama.ProducerErrors
+	if errors.As(err, &perrs) {
+		for _, perr := range perrs {
+			failures[perr.Msg] = perr.Err
+		}
+	} else if err != nil {
+		for _, msg := range msgs {
+			failures[msg] = err
+		}
+	}
+
+	if !errors.Is(err, ErrCircuitOpen) {
+		if len(failures) == len(msgs) {
+			p.breaker.Failure()
+		} else {
+			p.breaker.Success()
+		}
+	}
+
+	for j, msg := range msgs {
+		i := indexes[j]
+		d := Delivery{Key: keys[i], Latency: latency}
+		if ferr, failed := failures[msg]; failed {
+			d.Err = fmt.Errorf("failed to send message: %w", ferr)
+			p.metrics.SendFailed(msg.Topic)
+		} else {
+			d.Partition = msg.Partition
+			d.Offset = msg.Offset
+			p.metrics.MessageSent(msg.Topic, msg.Partition, latency)
+		}
+		deliveries[i] = d
+	}
+}
+
+// send schedules, numbers, compresses and encrypts msg and sends it, or its
+// chunks or claim check if it is too large, see WithLargeMessages.
+func (p *Producer) send(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if err := p.delay.schedule(msg); err != nil {
+		return 0, 0, err
+	}
+	if err := p.sequencer.stamp(msg); err != nil {
+		return 0, 0, err
+	}
+	if err := p.dictionary.compress(msg); err != nil {
+		return 0, 0, err
+	}
+	if err := p.encryption.seal(msg); err != nil {
+		return 0, 0, err
+	}
+	prepared, err := p.large.prepare(msg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(prepared) > 1 {
+		return p.sendChunks(prepared)
+	}
+	return p.sendOne(prepared[0])
+}
+
+// sendChunks sends the chunks of a message in order, all to the partition
+// the first one went to, and returns the partition and offset of the last
+// one. A failed chunk leaves the ones before it on the topic, which
+// consumers drop once they see a newer message in their place.
+func (p *Producer) sendChunks(chunks []*sarama.ProducerMessage) (int32, int64, error) {
+	partition, offset, err := p.sendOne(chunks[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, chunk := range chunks[1:] {
+		chunk.Partition = partition
+		chunk.Metadata = pinnedChunk{}
+		if _, offset, err = p.sendOne(chunk); err != nil {
+			return 0, 0, err
+		}
+	}
+	return partition, offset, nil
+}
+
+// sendOne sends msg, retrying with the producer's backoff while the circuit
+// breaker allows it.
+func (p *Producer) sendOne(msg *sarama.ProducerMessage) (int32, int64, error) {
+	start := time.Now()
+	var partition int32
+	var offset int64
+	err := p.backoff.Retry(context.Background(), "Send to "+p.topic, func() error {
+		if err := p.breaker.Allow(); err != nil {
+			return err
+		}
+		var err error
+		// sarama counts its own retries on the message, so every attempt
+		// gets a fresh copy.
+		partition, offset, err = p.producer.SendMessage(resendable(msg))
+		if err != nil {
+			p.breaker.Failure()
+			return err
+		}
+		p.breaker.Success()
+		return nil
+	})
+	if err != nil {
+		p.metrics.SendFailed(msg.Topic)
+		return 0, 0, fmt.Errorf("failed to send message: %w", err)
+	}
+	p.metrics.MessageSent(msg.Topic, partition, time.Since(start))
+
+	return partition, offset, nil
+}
+
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
+
+func resendable(msg *sarama.ProducerMessage) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   msg.Headers,
+		Metadata:  msg.Metadata,
+		Partition: msg.Partition,
+		Timestamp: msg.Timestamp,
+	}
+}
@@ -0,0 +1,278 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Phases of a rebalance as seen by one group member.
+const (
+	RebalanceSetup   = "setup"
+	RebalanceCleanup = "cleanup"
+)
+
+// Partition assignment strategies a consumer group member can ask for.
+const (
+	RebalanceRange             = "range"
+	RebalanceRoundRobin        = "roundrobin"
+	RebalanceSticky            = "sticky"
+	RebalanceCooperativeSticky = "cooperative-sticky"
+)
+
+// RebalanceStrategies lists the names WithRebalanceStrategy knows about.
+var RebalanceStrategies = []string{RebalanceRange, RebalanceRoundRobin, RebalanceSticky, RebalanceCooperativeSticky, RebalanceSingleActive}
+
+// NewBalanceStrategy returns the sarama assignor for name.
+//
+//   - range: each topic's partitions split into contiguous ranges, one per member
+//   - roundrobin: all partitions dealt out one by one, the consumer default
+//   - sticky: as balanced as roundrobin, but members keep as many of their partitions as possible
+//   - cooperative-sticky: not supported, sarama only implements the eager protocol
+//   - single-active: all partitions to one member, the others stand by to take over
+//
+// Every strategy sarama offers is eager: all members give up all their
+// partitions before a rebalance and get them back afterwards. sticky keeps
+// the reassignment small but can't avoid that pause; only the incremental
+// cooperative protocol of the Java client can, and sarama has none.
+func NewBalanceStrategy(name string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(name) {
+	case RebalanceRange:
+		return sarama.BalanceStrategyRange, nil
+	case "", RebalanceRoundRobin:
+		return sarama.BalanceStrategyRoundRobin, nil
+	case RebalanceSticky:
+		return sarama.BalanceStrategySticky, nil
+	case RebalanceCooperativeSticky:
+		return nil, fmt.Errorf("%s needs the incremental rebalance protocol, which sarama doesn't implement; use %s for the closest eager equivalent", name, RebalanceSticky)
+	case RebalanceSingleActive:
+		return singleActiveStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported rebalance strategy: %s", name)
+	}
+}
+
+// WithRebalanceStrategy selects the partition assignment strategy of a group
+// consumer by name, see NewBalanceStrategy. Every member of a group has to
+// use the same one.
+func WithRebalanceStrategy(name string) Option {
+	return func(o *options) error {
+		strategy, err := NewBalanceStrategy(name)
+		if err != nil {
+			return err
+		}
+		o.config.Consumer.Group.Rebalance.Strategy = nil
+		o.config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+		o.rebalanceStrategy = strategy.Name()
+		return nil
+	}
+}
+
+// WithGroupInstanceID makes a group consumer a static member (KIP-345)
+// with the given instance ID, unique within the group. A static member that
+// restarts within the session timeout gets its partitions back without a
+// rebalance, and it doesn't leave the group when closed. It raises the
+// protocol version to 2.3, the first one that supports it.
+func WithGroupInstanceID(id string) Option {
+	return func(o *options) error {
+		o.config.Consumer.Group.InstanceId = id
+		if id != "" && !o.config.Version.IsAtLeast(sarama.V2_3_0_0) {
+			o.config.Version = sarama.V2_3_0_0
+		}
+		return nil
+	}
+}
+
+// maxRebalanceEvents bounds the history of a long-running consumer; the
+// oldest events are dropped first.
+const maxRebalanceEvents = 1000
+
+// RebalanceEvent is one Setup or Cleanup of a consumer group session.
+// Assigned and Revoked compare the claims of a setup with those of the
+// previous one, so they show how partitions moved between members.
+type RebalanceEvent struct {
+	Time         time.Time          `json:"time"`
+	Phase        string             `json:"phase"`
+	Strategy     string             `json:"strategy"`
+	GroupID      string             `json:"group_id"`
+	InstanceID   string             `json:"instance_id,omitempty"`
+	MemberID     string             `json:"member_id"`
+	GenerationID int32              `json:"generation_id"`
+	Claims       map[string][]int32 `json:"claims"`
+	Assigned     map[string][]int32 `json:"assigned,omitempty"`
+	Revoked      map[string][]int32 `json:"revoked,omitempty"`
+}
+
+// Movement counts the partitions a setup kept from the previous setup, the
+// ones it gained and the ones it lost. Under the eager protocol the kept
+// partitions were paused during the rebalance all the same.
+func (e RebalanceEvent) Movement() (kept, assigned, revoked int) {
+	return countPartitions(e.Claims) - countPartitions(e.Assigned), countPartitions(e.Assigned), countPartitions(e.Revoked)
+}
+
+// RebalanceHistory records the sessions of a consumer group member. It
+// serves the history as JSON, e.g. at /debug/rebalances, and is safe for
+// concurrent use.
+type RebalanceHistory struct {
+	mu        sync.Mutex
+	events    []RebalanceEvent
+	lastSetup map[string][]int32
+}
+
+func NewRebalanceHistory() *RebalanceHistory {
+	return &RebalanceHistory{}
+}
+
+// WithRebalanceHistory records the rebalances of a group consumer in h
+// instead of a history of its own, so it can be served while the consumer
+// runs.
+func WithRebalanceHistory(h *RebalanceHistory) Option {
+	return func(o *options) error {
+		o.rebalances = h
+		return nil
+	}
+}
+
+// Record completes event, which carries the phase and what identifies the
+// member, with the current state of session, adds it to the history and
+// returns it.
+func (h *RebalanceHistory) Record(event RebalanceEvent, session GroupSession) RebalanceEvent {
+	event.Time = time.Now()
+	event.MemberID = session.MemberID()
+	event.GenerationID = session.GenerationID()
+	event.Claims = sortedClaims(session.Claims())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.Phase == RebalanceSetup {
+		if event.Strategy == RebalanceSingleActive {
+			logLeadership(event, countPartitions(h.lastSetup) > 0, h.lastSetup == nil)
+		}
+		event.Assigned = claimsDiff(event.Claims, h.lastSetup)
+		event.Revoked = claimsDiff(h.lastSetup, event.Claims)
+		h.lastSetup = event.Claims
+	}
+	h.events = append(h.events, event)
+	if len(h.events) > maxRebalanceEvents {
+		h.events = h.events[len(h.events)-maxRebalanceEvents:]
+	}
+	return event
+}
+
+// Events returns the recorded events, oldest first.
+func (h *RebalanceHistory) Events() []RebalanceEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]RebalanceEvent(nil), h.events...)
+}
+
+// CheckAssigned returns an error unless the member holds partitions. A
+// rebalance, between a cleanup and the next setup, only counts as failing
+// once it has lasted longer than grace, so readiness doesn't flap on every
+// short rebalance. A standby of RebalanceSingleActive is ready without
+// partitions.
+func (h *RebalanceHistory) CheckAssigned(grace time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.events) == 0 {
+		return errors.New("not assigned any partitions yet")
+	}
+	last := h.events[len(h.events)-1]
+	if last.Phase == RebalanceCleanup {
+		if since := time.Since(last.Time); since > grace {
+			return fmt.Errorf("rebalancing for %s", since.Round(time.Second))
+		}
+		return nil
+	}
+	if countPartitions(last.Claims) == 0 && last.Strategy != RebalanceSingleActive {
+		return fmt.Errorf("generation %d assigned no partitions to this member", last.GenerationID)
+	}
+	return nil
+}
+
+// LogSummary prints one line per recorded event and the partition movement
+// of all setups together.
+func (h *RebalanceHistory) LogSummary() {
+	events := h.Events()
+	if len(events) == 0 {
+		return
+	}
+
+	var setups, kept, assigned, revoked int
+	for _, e := range events {
+		attrs := []any{"time", e.Time.Format(time.RFC3339), "phase", e.Phase, "strategy", e.Strategy,
+			"group", e.GroupID, "generation", e.GenerationID, "member_id", e.MemberID, "claims", e.Claims}
+		if e.InstanceID != "" {
+			attrs = append(attrs, "instance_id", e.InstanceID)
+		}
+		if e.Phase == RebalanceSetup {
+			k, a, r := e.Movement()
+			setups++
+			kept, assigned, revoked = kept+k, assigned+a, revoked+r
+			attrs = append(attrs, "kept", k, "assigned", e.Assigned, "revoked", e.Revoked)
+		}
+		slog.Info("Rebalance", attrs...)
+	}
+
+	// Every setup after the first one followed a stop-the-world pause of
+	// all the member's partitions, however few of them moved.
+	slog.Info("Rebalance history", "events", len(events), "setups", setups,
+		"partitions_kept", kept, "partitions_assigned", assigned, "partitions_revoked", revoked)
+}
+
+// ServeHTTP writes the history as a JSON array.
+func (h *RebalanceHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Events()); err != nil {
+		slog.Error("Failed to write rebalance history", "error", err)
+	}
+}
+
+func countPartitions(claims map[string][]int32) int {
+	n := 0
+	for _, partitions := range claims {
+		n += len(partitions)
+	}
+	return n
+}
+
+func sortedClaims(claims map[string][]int32) map[string][]int32 {
+	sorted := make(map[string][]int32, len(claims))
+	for topic, partitions := range claims {
+		partitions = append([]int32(nil), partitions...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		sorted[topic] = partitions
+	}
+	return sorted
+}
+
+// claimsDiff returns the partitions in a that are not in b.
+func claimsDiff(a, b map[string][]int32) map[string][]int32 {
+	var diff map[string][]int32
+	for topic, partitions := range a {
+		owned := make(map[int32]bool, len(b[topic]))
+		for _, p := range b[topic] {
+			owned[p] = true
+		}
+		for _, p := range partitions {
+			if owned[p] {
+				continue
+			}
+			if diff == nil {
+				diff = make(map[string][]int32)
+			}
+			diff[topic] = append(diff[topic], p)
+		}
+	}
+	return diff
+}
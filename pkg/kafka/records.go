@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Record is a message with a key and value that are already encoded, as
+// forwarded by a proxy.
+type Record struct {
+	// Key is nil for a message without a key.
+	Key   []byte
+	Value []byte
+	// Partition is nil to let the partitioner pick one.
+	Partition *int32
+}
+
+// RecordProducer sends records to any topic, each one to the partition it
+// names or else to the one the partitioner picks.
+type RecordProducer struct {
+	producer SyncProducer
+	metrics  Metrics
+}
+
+// NewRecordProducer creates a synchronous producer that waits for all
+// in-sync replicas, like NewProducer. WithPartitioner picks the partition
+// of records that don't name one.
+func NewRecordProducer(brokers []string, opts ...Option) (*RecordProducer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Compression = sarama.CompressionSnappy
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+	fallback := config.Producer.Partitioner
+	config.Producer.Partitioner = func(topic string) sarama.Partitioner {
+		return &explicitPartitioner{fallback: fallback(topic)}
+	}
+
+	producer, err := o.newSyncProducer(brokers)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordProducer{producer: producer, metrics: o.metrics}, nil
+}
+
+// SendRecords sends records to topic with a single SendMessages call and
+// returns one Delivery per record in the same order. Failures of single
+// records are reported in Delivery.Err. Records aren't retried beyond
+// sarama's own retries.
+func (p *RecordProducer) SendRecords(topic string, records []Record) []Delivery {
+	msgs := make([]*sarama.ProducerMessage, len(records))
+	for i, record := range records {
+		msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(record.Value)}
+		if record.Key != nil {
+			msg.Key = sarama.ByteEncoder(record.Key)
+		}
+		if record.Partition != nil {
+			msg.Metadata = explicitPartition(*record.Partition)
+		}
+		msgs[i] = msg
+	}
+
+	start := time.Now()
+	err := p.producer.SendMessages(msgs)
+	latency := time.Since(start)
+
+	failures := make(map[*sarama.ProducerMessage]error)
+	var perrs sarama.ProducerErrors
+	if errors.As(err, &perrs) {
+		for _, perr := range perrs {
+			failures[perr.Msg] = perr.Err
+		}
+	} else if err != nil {
+		for _, msg := range msgs {
+			failures[msg] = err
+		}
+	}
+
+	deliveries := make([]Delivery, len(msgs))
+	for i, msg := range msgs {
+		d := Delivery{Key: string(records[i].Key), Latency: latency}
+		if ferr, failed := failures[msg]; failed {
+			d.Err = fmt.Errorf("failed to send message: %w", ferr)
+			p.metrics.SendFailed(topic)
+		} else {
+			d.Partition = msg.Partition
+			d.Offset = msg.Offset
+			p.metrics.MessageSent(topic, msg.Partition, latency)
+		}
+		deliveries[i] = d
+	}
+	return deliveries
+}
+
+func (p *RecordProducer) Close() error {
+	return p.producer.Close()
+}
+
+// ErrInvalidPartition is returned for a record naming a partition the topic
+// doesn't have.
+var ErrInvalidPartition = errors.New("partition does not exist")
+
+// explicitPartition marks a message for a partition chosen by the caller.
+type explicitPartition int32
+
+// explicitPartitioner sends messages marked with an explicitPartition to
+// that partition and leaves the others to fallback.
+type explicitPartitioner struct {
+	fallback sarama.Partitioner
+}
+
+func (p *explicitPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if partition, ok := message.Metadata.(explicitPartition); ok {
+		if partition < 0 || int32(partition) >= numPartitions {
+			return -1, fmt.Errorf("%w: %d of %d", ErrInvalidPartition, partition, numPartitions)
+		}
+		return int32(partition), nil
+	}
+	return p.fallback.Partition(message, numPartitions)
+}
+
+func (p *explicitPartitioner) RequiresConsistency() bool {
+	return p.fallback.RequiresConsistency()
+}
+
+// MessageRequiresConsistency makes sarama offer every partition, not only
+// the available ones, for messages with an explicit partition.
+func (p *explicitPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if _, ok := message.Metadata.(explicitPartition); ok {
+		return true
+	}
+	if dynamic, ok := p.fallback.(sarama.DynamicConsistencyPartitioner); ok {
+		return dynamic.MessageRequiresConsistency(message)
+	}
+	return p.fallback.RequiresConsistency()
+}
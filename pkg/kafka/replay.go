@@ -0,0 +1,332 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// replayBatchSize is the most messages a Replayer sends in one request.
+const replayBatchSize = 500
+
+// replayIdle is how long a Replayer waits for a message before it checks
+// whether a partition has nothing left below its end offset, e.g. because
+// the last offsets hold transaction markers or were compacted away.
+const replayIdle = time.Second
+
+// ParseSpeed parses a replay speed: "max" for as fast as possible, or a
+// positive factor applied to the original timing, such as 1 or 2.5.
+func ParseSpeed(value string) (float64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "max" || value == "" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid speed %q: expected max or a positive factor such as 1", value)
+	}
+	return speed, nil
+}
+
+// ReplayConfig describes what a Replayer copies and how fast.
+type ReplayConfig struct {
+	// From is where to start in every partition.
+	From Position
+	// To is where to stop in every partition; the message at To itself is
+	// not replayed. It is resolved when the replay starts, so messages
+	// produced meanwhile are left out.
+	To Position
+	// Speed is 0 to replay as fast as possible, or the factor applied to
+	// the original time between messages: 1 keeps it, 2 halves it.
+	Speed float64
+	// KeepPartitions sends every message to the partition number it was
+	// read from instead of letting the partitioner pick by key.
+	KeepPartitions bool
+}
+
+// Replayer copies part of a topic's history into another topic, keeping
+// keys and headers. It doesn't join a group or commit offsets.
+type Replayer struct {
+	client   Client
+	consumer Reader
+	producer SyncProducer
+	metrics  Metrics
+}
+
+// NewReplayer connects a Replayer. Only committed messages are read, and
+// messages are produced with acks from all in-sync replicas and one
+// request in flight per broker, so each partition keeps its order.
+// WithPartitioner picks the destination partitions unless
+// ReplayConfig.KeepPartitions is set.
+func NewReplayer(brokers []string, opts ...Option) (*Replayer, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Net.MaxOpenRequests = 1
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replay config: %w", err)
+	}
+	fallback := config.Producer.Partitioner
+	config.Producer.Partitioner = func(topic string) sarama.Partitioner {
+		return &explicitPartitioner{fallback: fallback(topic)}
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := client.NewReader()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := client.NewSyncProducer()
+	if err != nil {
+		consumer.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+
+	return &Replayer{client: client, consumer: consumer, producer: producer, metrics: o.metrics}, nil
+}
+
+// replayPartition is a source partition being replayed.
+type replayPartition struct {
+	partition int32
+	pc        PartitionReader
+	end       int64
+	head      *sarama.ConsumerMessage
+}
+
+// Replay copies the messages of source between config.From and config.To
+// to destination and returns how many it sent. Messages are sent in the
+// order of their timestamps across partitions, and in offset order within
+// one. It returns when every partition is copied, ctx is cancelled or a
+// message can't be sent; being cancelled isn't an error.
+func (r *Replayer) Replay(ctx context.Context, source, destination string, config ReplayConfig) (int, error) {
+	if err := r.client.RefreshMetadata(source, destination); err != nil {
+		return 0, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := r.client.Partitions(source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions of %s: %w", source, err)
+	}
+	if config.KeepPartitions {
+		destPartitions, err := r.client.Partitions(destination)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list partitions of %s: %w", destination, err)
+		}
+		if len(destPartitions) < len(partitions) {
+			return 0, fmt.Errorf("%w: %s has %d partitions, %s only %d", ErrInvalidPartition,
+				source, len(partitions), destination, len(destPartitions))
+		}
+	}
+
+	var active []*replayPartition
+	defer func() {
+		for _, p := range active {
+			p.pc.AsyncClose()
+		}
+	}()
+	for _, partition := range partitions {
+		start, err := resolvePosition(r.client, source, partition, config.From)
+		if err != nil {
+			return 0, err
+		}
+		end, err := resolvePosition(r.client, source, partition, config.To)
+		if err != nil {
+			return 0, err
+		}
+		if start >= end {
+			continue
+		}
+		pc, err := r.consumer.ConsumePartition(source, partition, start)
+		if err != nil {
+			return 0, fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+		slog.Debug("Replaying partition", "topic", source, "partition", partition, "from", start, "to", end)
+		active = append(active, &replayPartition{partition: partition, pc: pc, end: end})
+	}
+
+	// Every partition contributes its next message, and the oldest of them
+	// goes first.
+	if active, err = r.advance(ctx, active, active); err != nil {
+		return 0, nil
+	}
+	var first time.Time
+	for _, p := range active {
+		if first.IsZero() || p.head.Timestamp.Before(first) {
+			first = p.head.Timestamp
+		}
+	}
+
+	started := time.Now()
+	sent := 0
+	var batch []*sarama.ProducerMessage
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := sendCopies(r.producer, r.metrics, destination, batch)
+		if err == nil {
+			sent += len(batch)
+			slog.Debug("Replayed messages", "topic", destination, "messages", sent)
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	for len(active) > 0 {
+		next := active[0]
+		for _, p := range active[1:] {
+			if p.head.Timestamp.Before(next.head.Timestamp) {
+				next = p
+			}
+		}
+		message := next.head
+
+		if config.Speed > 0 {
+			due := started.Add(time.Duration(float64(message.Timestamp.Sub(first)) / config.Speed))
+			if wait := time.Until(due); wait > 0 {
+				if err := flush(); err != nil {
+					return sent, err
+				}
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return sent, nil
+				}
+			}
+		}
+
+		batch = append(batch, copyMessage(destination, message, config.KeepPartitions))
+		if len(batch) >= replayBatchSize {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+
+		next.head = nil
+		if active, err = r.advance(ctx, active, []*replayPartition{next}); err != nil {
+			break
+		}
+	}
+	return sent, flush()
+}
+
+// advance reads the next message of each partition in fill and returns
+// active without the partitions that have nothing left to replay. It only
+// fails when ctx is cancelled.
+func (r *Replayer) advance(ctx context.Context, active, fill []*replayPartition) ([]*replayPartition, error) {
+	done := make(map[*replayPartition]bool)
+	for _, p := range fill {
+		message, err := p.next(ctx)
+		if err != nil {
+			return active, err
+		}
+		if message == nil {
+			done[p] = true
+			p.pc.AsyncClose()
+			continue
+		}
+		p.head = message
+	}
+	if len(done) == 0 {
+		return active, nil
+	}
+	remaining := active[:0]
+	for _, p := range active {
+		if !done[p] {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining, nil
+}
+
+// next returns the partition's next message below its end offset, or nil
+// when there is none.
+func (p *replayPartition) next(ctx context.Context) (*sarama.ConsumerMessage, error) {
+	idle := time.NewTimer(replayIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case message, ok := <-p.pc.Messages():
+			if !ok || message.Offset >= p.end {
+				return nil, nil
+			}
+			return message, nil
+		case <-idle.C:
+			// Offsets below the end that hold no message never arrive.
+			if p.pc.HighWaterMarkOffset() >= p.end {
+				return nil, nil
+			}
+			idle.Reset(replayIdle)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// copyMessage copies message for topic with its key and headers.
+func copyMessage(topic string, message *sarama.ConsumerMessage, keepPartition bool) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(message.Value)}
+	if message.Key != nil {
+		msg.Key = sarama.ByteEncoder(message.Key)
+	}
+	for _, header := range message.Headers {
+		msg.Headers = append(msg.Headers, *header)
+	}
+	if keepPartition {
+		msg.Metadata = explicitPartition(message.Partition)
+	}
+	return msg
+}
+
+// sendCopies sends a batch of copied messages for topic and fails if any of
+// them couldn't be sent.
+func sendCopies(producer SyncProducer, metrics Metrics, topic string, batch []*sarama.ProducerMessage) error {
+	start := time.Now()
+	err := producer.SendMessages(batch)
+	latency := time.Since(start)
+
+	var perrs sarama.ProducerErrors
+	if errors.As(err, &perrs) {
+		for range perrs {
+			metrics.SendFailed(topic)
+		}
+		return fmt.Errorf("failed to send %d of %d messages: %w", len(perrs), len(batch), perrs[0].Err)
+	}
+	if err != nil {
+		metrics.SendFailed(topic)
+		return fmt.Errorf("failed to send messages: %w", err)
+	}
+	for _, msg := range batch {
+		metrics.MessageSent(topic, msg.Partition, latency)
+	}
+	return nil
+}
+
+// Close closes the producer, the consumer and the client.
+func (r *Replayer) Close() error {
+	if err := r.producer.Close(); err != nil {
+		slog.Error("Failed to close replay producer", "error", err)
+	}
+	if err := r.consumer.Close(); err != nil {
+		r.client.Close()
+		return err
+	}
+	return r.client.Close()
+}
@@ -0,0 +1,418 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// electionTimeout and reassignmentTimeout bound how long the controller may
+// take before it answers an election or reassignment request.
+const (
+	electionTimeout     = 30 * time.Second
+	reassignmentTimeout = 60 * time.Second
+)
+
+// PartitionReplicas is where one partition's replicas live and which of them
+// leads.
+type PartitionReplicas struct {
+	Partition int32
+	// Leader is -1 while the partition has no leader.
+	Leader   int32
+	Replicas []int32
+	ISR      []int32
+	Offline  []int32
+	// Adding and Removing are the replicas an ongoing reassignment is
+	// moving the partition to and away from.
+	Adding   []int32
+	Removing []int32
+}
+
+// Preferred is the partition's preferred leader, the first replica, which
+// it leads when its brokers are balanced.
+func (p PartitionReplicas) Preferred() int32 {
+	if len(p.Replicas) == 0 {
+		return -1
+	}
+	return p.Replicas[0]
+}
+
+// UnderReplicated reports whether a replica has fallen out of sync.
+func (p PartitionReplicas) UnderReplicated() bool {
+	return len(p.ISR) < len(p.Replicas)
+}
+
+// Reassigning reports whether a reassignment of the partition is ongoing.
+func (p PartitionReplicas) Reassigning() bool {
+	return len(p.Adding) > 0 || len(p.Removing) > 0
+}
+
+// ElectionResult is the outcome of a preferred leader election for one
+// partition. Err is nil if leadership moved, sarama.ErrElectionNotNeeded if
+// the preferred replica already led.
+type ElectionResult struct {
+	Topic     string
+	Partition int32
+	Err       error
+}
+
+// ReplicaAdmin shows and moves partition leaders and replicas, to
+// demonstrate what happens when a broker fails and comes back.
+type ReplicaAdmin struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// NewReplicaAdmin connects a ReplicaAdmin. Only SaramaBackend has one.
+func NewReplicaAdmin(brokers []string, opts ...Option) (*ReplicaAdmin, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	// Reassignments and elections wait for the controller, which may take
+	// longer than the default to answer.
+	config.Net.ReadTimeout = reassignmentTimeout + 10*time.Second
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := saramaOf(client)
+	if !ok {
+		client.Close()
+		return nil, unsupported("replica admins are", o.backend)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(sc)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	return &ReplicaAdmin{client: sc, admin: admin}, nil
+}
+
+// Describe returns the leader, replicas, ISR and ongoing reassignment of
+// every partition of topic, sorted by partition.
+func (a *ReplicaAdmin) Describe(topic string) ([]PartitionReplicas, error) {
+	return DescribeReplicas(a.admin, topic)
+}
+
+// DescribeReplicas is ReplicaAdmin.Describe for an existing cluster admin.
+func DescribeReplicas(admin sarama.ClusterAdmin, topic string) ([]PartitionReplicas, error) {
+	metadata, err := admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topic %s: %w", topic, err)
+	}
+	if len(metadata) != 1 {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+	if metadata[0].Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("failed to describe topic %s: %w", topic, metadata[0].Err)
+	}
+
+	partitions := make([]PartitionReplicas, len(metadata[0].Partitions))
+	ids := make([]int32, len(partitions))
+	for i, p := range metadata[0].Partitions {
+		partitions[i] = PartitionReplicas{
+			Partition: p.ID,
+			Leader:    p.Leader,
+			Replicas:  p.Replicas,
+			ISR:       p.Isr,
+			Offline:   p.OfflineReplicas,
+		}
+		ids[i] = p.ID
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Partition < partitions[j].Partition })
+
+	reassignments, err := listReassignments(admin, topic, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range partitions {
+		if status := reassignments[p.Partition]; status != nil {
+			partitions[i].Adding = status.AddingReplicas
+			partitions[i].Removing = status.RemovingReplicas
+		}
+	}
+	return partitions, nil
+}
+
+func listReassignments(admin sarama.ClusterAdmin, topic string, partitions []int32) (map[int32]*sarama.PartitionReplicaReassignmentsStatus, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	status, err := admin.ListPartitionReassignments(topic, partitions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reassignments of %s: %w", topic, err)
+	}
+	return status[topic], nil
+}
+
+// ElectPreferredLeaders moves the leadership of the given partitions of
+// topic back to their preferred replicas, as the controller does on its own
+// every few minutes while auto.leader.rebalance.enable is on. Without
+// partitions every partition of topic is elected, and without a topic
+// every partition in the cluster.
+func (a *ReplicaAdmin) ElectPreferredLeaders(ctx context.Context, topic string, partitions []int32) ([]ElectionResult, error) {
+	var w wireWriter
+	w.int8(0) // election type: preferred
+	if topic == "" {
+		w.int32(-1)
+	} else {
+		if len(partitions) == 0 {
+			var err error
+			if partitions, err = a.partitions(topic); err != nil {
+				return nil, err
+			}
+		} else if err := a.checkPartitions(topic, partitions); err != nil {
+			return nil, err
+		}
+		w.int32(1)
+		w.string(topic)
+		w.int32(int32(len(partitions)))
+		for _, partition := range partitions {
+			w.int32(partition)
+		}
+	}
+	w.int32(int32(electionTimeout / time.Millisecond))
+
+	var results []ElectionResult
+	err := withController(ctx, a.client, func(conn *wireConn) error {
+		response, err := conn.roundTrip(apiKeyElectLeaders, 1, false, w.Bytes())
+		if err != nil {
+			return err
+		}
+		r := wireReader{b: response}
+		r.int32() // throttle time
+		if kerr := sarama.KError(r.int16()); r.err == nil && kerr != sarama.ErrNoError {
+			return kerr
+		}
+		for topics := r.arrayLength(); topics > 0 && r.err == nil; topics-- {
+			topic := r.string()
+			for n := r.arrayLength(); n > 0 && r.err == nil; n-- {
+				result := ElectionResult{Topic: topic, Partition: r.int32()}
+				kerr := sarama.KError(r.int16())
+				message := r.nullableString()
+				if kerr != sarama.ErrNoError {
+					result.Err = wireError(kerr, message)
+				}
+				results = append(results, result)
+			}
+		}
+		return r.err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to elect preferred leaders: %w", err)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Topic != results[j].Topic {
+			return results[i].Topic < results[j].Topic
+		}
+		return results[i].Partition < results[j].Partition
+	})
+	return results, nil
+}
+
+// Reassign starts moving the replicas of the partitions in assignment to
+// the brokers given for them; the first broker becomes the preferred
+// leader. It returns once the controller has accepted the reassignment,
+// which then copies the data in the background; WaitForReassignment waits
+// for that.
+func (a *ReplicaAdmin) Reassign(ctx context.Context, topic string, assignment map[int32][]int32) error {
+	partitions := make([]int32, 0, len(assignment))
+	for partition := range assignment {
+		partitions = append(partitions, partition)
+	}
+	slices.Sort(partitions)
+	if err := a.checkPartitions(topic, partitions); err != nil {
+		return err
+	}
+
+	brokers, _, err := a.admin.DescribeCluster()
+	if err != nil {
+		return fmt.Errorf("failed to describe cluster: %w", err)
+	}
+	live := make(map[int32]bool, len(brokers))
+	for _, b := range brokers {
+		live[b.ID()] = true
+	}
+	for _, partition := range partitions {
+		replicas := assignment[partition]
+		if len(replicas) == 0 {
+			return fmt.Errorf("partition %d needs at least one replica", partition)
+		}
+		for i, id := range replicas {
+			if !live[id] {
+				return fmt.Errorf("partition %d: broker %d is not in the cluster", partition, id)
+			}
+			if slices.Contains(replicas[:i], id) {
+				return fmt.Errorf("partition %d: broker %d is listed twice", partition, id)
+			}
+		}
+	}
+
+	return a.alterReassignments(ctx, topic, partitions, assignment)
+}
+
+// CancelReassignment stops the ongoing reassignment of the given partitions
+// of topic, or of all of them, and moves them back to their original
+// replicas.
+func (a *ReplicaAdmin) CancelReassignment(ctx context.Context, topic string, partitions []int32) error {
+	if len(partitions) == 0 {
+		all, err := a.partitions(topic)
+		if err != nil {
+			return err
+		}
+		ongoing, err := listReassignments(a.admin, topic, all)
+		if err != nil {
+			return err
+		}
+		for partition := range ongoing {
+			partitions = append(partitions, partition)
+		}
+		if len(partitions) == 0 {
+			return nil
+		}
+		slices.Sort(partitions)
+	}
+	return a.alterReassignments(ctx, topic, partitions, nil)
+}
+
+// alterReassignments sends AlterPartitionReassignments for partitions,
+// cancelling those without replicas in assignment. sarama's own version
+// covers every partition below the highest one, cancelling those that
+// aren't given, and hides which partition failed.
+func (a *ReplicaAdmin) alterReassignments(ctx context.Context, topic string, partitions []int32, assignment map[int32][]int32) error {
+	var w wireWriter
+	w.int32(int32(reassignmentTimeout / time.Millisecond))
+	w.compactLength(1)
+	w.compactString(topic)
+	w.compactLength(len(partitions))
+	for _, partition := range partitions {
+		w.int32(partition)
+		replicas := assignment[partition]
+		if replicas == nil {
+			w.compactLength(-1)
+		} else {
+			w.compactLength(len(replicas))
+			for _, id := range replicas {
+				w.int32(id)
+			}
+		}
+		w.tags()
+	}
+	w.tags()
+	w.tags()
+
+	err := withController(ctx, a.client, func(conn *wireConn) error {
+		response, err := conn.roundTrip(apiKeyAlterPartitionReassignment, 0, true, w.Bytes())
+		if err != nil {
+			return err
+		}
+		r := wireReader{b: response}
+		r.int32() // throttle time
+		kerr := sarama.KError(r.int16())
+		message := r.compactString()
+		if r.err == nil && kerr != sarama.ErrNoError {
+			return wireError(kerr, message)
+		}
+		var errs []error
+		for topics := r.compactLength(); topics > 0 && r.err == nil; topics-- {
+			r.compactString()
+			for n := r.compactLength(); n > 0 && r.err == nil; n-- {
+				partition := r.int32()
+				kerr := sarama.KError(r.int16())
+				message := r.compactString()
+				r.skipTags()
+				if kerr != sarama.ErrNoError {
+					errs = append(errs, fmt.Errorf("partition %d: %w", partition, wireError(kerr, message)))
+				}
+			}
+			r.skipTags()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reassign partitions of %s: %w", topic, err)
+	}
+	return nil
+}
+
+// WaitForReassignment polls until none of the given partitions of topic, or
+// none of its partitions, is being reassigned, or ctx is done. progress is
+// called with the partitions still moving after every poll.
+func (a *ReplicaAdmin) WaitForReassignment(ctx context.Context, topic string, partitions []int32, progress func(remaining []int32)) error {
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = a.partitions(topic); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		ongoing, err := listReassignments(a.admin, topic, partitions)
+		if err != nil {
+			return err
+		}
+		remaining := make([]int32, 0, len(ongoing))
+		for partition := range ongoing {
+			remaining = append(remaining, partition)
+		}
+		slices.Sort(remaining)
+		if progress != nil {
+			progress(remaining)
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *ReplicaAdmin) partitions(topic string) ([]int32, error) {
+	if err := a.client.RefreshMetadata(topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := a.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+	return partitions, nil
+}
+
+func (a *ReplicaAdmin) checkPartitions(topic string, partitions []int32) error {
+	all, err := a.partitions(topic)
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		if partition < 0 || int(partition) >= len(all) {
+			return fmt.Errorf("%w: %d of %s, which has %d", ErrInvalidPartition, partition, topic, len(all))
+		}
+	}
+	return nil
+}
+
+// Close shuts down the admin, which also closes the underlying client.
+func (a *ReplicaAdmin) Close() error {
+	return a.admin.Close()
+}
@@ -0,0 +1,156 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers added to messages routed to retry topics.
+const (
+	RetryLevelHeader         = "retry-level"
+	RetryOriginalTopicHeader = "retry-original-topic"
+	RetryDueAtHeader         = "retry-due-at"
+	RetryErrorHeader         = "retry-error"
+)
+
+// RetryLevel is one step of the retry-topic ladder, e.g. "5s" routes
+// failures to <topic>-retry-5s and waits five seconds before reprocessing.
+type RetryLevel struct {
+	Name  string
+	Delay time.Duration
+}
+
+// ParseRetryLevels parses a comma-separated list of delays such as "5s,1m,10m".
+func ParseRetryLevels(value string) ([]RetryLevel, error) {
+	var levels []RetryLevel
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		delay, err := time.ParseDuration(part)
+		if err != nil || delay <= 0 {
+			return nil, fmt.Errorf("invalid retry level %q", part)
+		}
+		levels = append(levels, RetryLevel{Name: part, Delay: delay})
+	}
+	return levels, nil
+}
+
+// RetryTopic returns the name of the retry topic for level.
+func RetryTopic(topic string, level RetryLevel) string {
+	return topic + "-retry-" + level.Name
+}
+
+// WithRetryLevels routes messages that fail processing through a ladder of
+// retry topics with increasing delays before they are dead-lettered.
+func WithRetryLevels(levels ...RetryLevel) Option {
+	return func(o *options) error {
+		o.retryLevels = levels
+		if len(levels) > 0 {
+			o.config.Producer.Return.Successes = true
+			o.config.Producer.RequiredAcks = sarama.WaitForAll
+		}
+		return nil
+	}
+}
+
+// retryTopics lists the retry topics a consumer of topic must also subscribe to.
+func (p processor) retryTopics(topic string) []string {
+	topics := make([]string, 0, len(p.retryLevels))
+	for _, level := range p.retryLevels {
+		topics = append(topics, RetryTopic(topic, level))
+	}
+	return topics
+}
+
+// waitUntilDue blocks until a message read from a retry topic is due for
+// reprocessing. Messages in one retry topic share the same delay, so waiting
+// on the head of a partition never holds back a message that is already due.
+func (p processor) waitUntilDue(ctx context.Context, message *sarama.ConsumerMessage) error {
+	dueAt, ok := headerValue(message, RetryDueAtHeader)
+	if !ok {
+		return nil
+	}
+
+	ms, err := strconv.ParseInt(dueAt, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	wait := time.Until(time.UnixMilli(ms))
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nextRetryLevel returns the index of the retry level a failed message
+// should go to next, or false if it has exhausted the ladder.
+func (p processor) nextRetryLevel(message *sarama.ConsumerMessage) (int, bool) {
+	current := 0
+	if value, ok := headerValue(message, RetryLevelHeader); ok {
+		current, _ = strconv.Atoi(value)
+	}
+	if current >= len(p.retryLevels) {
+		return 0, false
+	}
+	return current, true
+}
+
+func (p processor) sendToRetry(message *sarama.ConsumerMessage, cause error, levelIndex int) (string, error) {
+	level := p.retryLevels[levelIndex]
+
+	originalTopic := message.Topic
+	if value, ok := headerValue(message, RetryOriginalTopicHeader); ok {
+		originalTopic = value
+	}
+	retryTopic := RetryTopic(originalTopic, level)
+
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+4)
+	for _, h := range message.Headers {
+		switch string(h.Key) {
+		case RetryLevelHeader, RetryOriginalTopicHeader, RetryDueAtHeader, RetryErrorHeader:
+			continue
+		}
+		headers = append(headers, *h)
+	}
+	headers = append(headers,
+		stringHeader(RetryLevelHeader, strconv.Itoa(levelIndex+1)),
+		stringHeader(RetryOriginalTopicHeader, originalTopic),
+		stringHeader(RetryDueAtHeader, strconv.FormatInt(time.Now().Add(level.Delay).UnixMilli(), 10)),
+		stringHeader(RetryErrorHeader, cause.Error()),
+	)
+
+	err := p.send(&sarama.ProducerMessage{
+		Topic:   retryTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to route message from partition %d offset %d to %s: %w",
+			message.Partition, message.Offset, retryTopic, err)
+	}
+	return retryTopic, nil
+}
+
+func headerValue(message *sarama.ConsumerMessage, key string) (string, bool) {
+	for _, h := range message.Headers {
+		if string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,189 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// SaramaBackend connects to the cluster with sarama. It is the only backend
+// with the features built on sarama's own types: NewClusterAdmin, group
+// administration, replica management, the watermark inspector and the
+// async producer.
+type SaramaBackend struct{}
+
+// Name returns "sarama".
+func (SaramaBackend) Name() string { return "sarama" }
+
+// Connect connects a sarama client to brokers.
+func (SaramaBackend) Connect(brokers []string, config ClientConfig) (Client, error) {
+	saramaConfig, err := config.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(brokers, saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &saramaClient{Client: client}, nil
+}
+
+// saramaClient is a Client over a sarama client. Like sarama's, it takes one
+// consumer group member at most.
+type saramaClient struct {
+	sarama.Client
+}
+
+// saramaOf returns the sarama client under client, for the features only
+// SaramaBackend has.
+func saramaOf(client Client) (sarama.Client, bool) {
+	c, ok := client.(*saramaClient)
+	if !ok {
+		return nil, false
+	}
+	return c.Client, true
+}
+
+func (c *saramaClient) NewSyncProducer() (SyncProducer, error) {
+	return sarama.NewSyncProducerFromClient(c.Client)
+}
+
+func (c *saramaClient) NewConsumerGroup(groupID string) (ConsumerGroup, error) {
+	group, err := sarama.NewConsumerGroupFromClient(groupID, c.Client)
+	if err != nil {
+		return nil, err
+	}
+	return saramaGroup{group}, nil
+}
+
+func (c *saramaClient) NewReader() (Reader, error) {
+	consumer, err := sarama.NewConsumerFromClient(c.Client)
+	if err != nil {
+		return nil, err
+	}
+	return saramaReader{consumer}, nil
+}
+
+func (c *saramaClient) NewAdmin() (Admin, error) {
+	admin, err := sarama.NewClusterAdminFromClient(c.Client)
+	if err != nil {
+		return nil, err
+	}
+	return saramaAdmin{admin}, nil
+}
+
+type saramaGroup struct {
+	sarama.ConsumerGroup
+}
+
+func (g saramaGroup) Consume(ctx context.Context, topics []string, handler GroupHandler) error {
+	return g.ConsumerGroup.Consume(ctx, topics, saramaHandler{handler})
+}
+
+// saramaHandler passes sarama's sessions and claims on to a GroupHandler.
+type saramaHandler struct {
+	handler GroupHandler
+}
+
+func (h saramaHandler) Setup(session sarama.ConsumerGroupSession) error {
+	return h.handler.Setup(session)
+}
+
+func (h saramaHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return h.handler.Cleanup(session)
+}
+
+func (h saramaHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	return h.handler.ConsumeClaim(session, claim)
+}
+
+type saramaReader struct {
+	sarama.Consumer
+}
+
+func (r saramaReader) ConsumePartition(topic string, partition int32, offset int64) (PartitionReader, error) {
+	return r.Consumer.ConsumePartition(topic, partition, offset)
+}
+
+// saramaAdmin is an Admin over a sarama.ClusterAdmin that shares the
+// client, so closing it leaves the client open.
+type saramaAdmin struct {
+	sarama.ClusterAdmin
+}
+
+func (a saramaAdmin) ListTopics() (map[string]TopicDetail, error) {
+	details, err := a.ClusterAdmin.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]TopicDetail, len(details))
+	for name, detail := range details {
+		topics[name] = TopicDetail{Partitions: detail.NumPartitions, ReplicationFactor: detail.ReplicationFactor}
+	}
+	return topics, nil
+}
+
+func (a saramaAdmin) CreateTopic(topic string, detail TopicDetail) error {
+	entries := make(map[string]*string, len(detail.Configs))
+	for name, value := range detail.Configs {
+		value := value
+		entries[name] = &value
+	}
+	err := a.ClusterAdmin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     detail.Partitions,
+		ReplicationFactor: detail.ReplicationFactor,
+		ConfigEntries:     entries,
+	}, false)
+	if errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return fmt.Errorf("%w: %s", ErrTopicExists, topic)
+	}
+	return err
+}
+
+func (a saramaAdmin) TopicConfig(topic, name string) (string, error) {
+	entries, err := a.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: []string{name},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry.Value, nil
+		}
+	}
+	return "", fmt.Errorf("topic %s has no config %s", topic, name)
+}
+
+func (a saramaAdmin) ListGroupOffsets(groupID string, partitions map[string][]int32) (map[string]map[int32]GroupOffset, error) {
+	response, err := a.ListConsumerGroupOffsets(groupID, partitions)
+	if err != nil {
+		return nil, err
+	}
+	if response.Err != sarama.ErrNoError {
+		return nil, response.Err
+	}
+	offsets := make(map[string]map[int32]GroupOffset, len(response.Blocks))
+	for topic, blocks := range response.Blocks {
+		offsets[topic] = make(map[int32]GroupOffset, len(blocks))
+		for partition, block := range blocks {
+			if block.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("partition %d of %s: %w", partition, topic, block.Err)
+			}
+			offsets[topic][partition] = GroupOffset{Offset: block.Offset, Metadata: block.Metadata}
+		}
+	}
+	return offsets, nil
+}
+
+func (a saramaAdmin) Brokers() (int, error) {
+	brokers, _, err := a.DescribeCluster()
+	return len(brokers), err
+}
+
+// Close leaves the shared client open.
+func (a saramaAdmin) Close() error { return nil }
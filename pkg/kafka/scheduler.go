@@ -0,0 +1,424 @@
+package kafka
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers of messages waiting in a scheduler topic, see
+// WithDelayedDelivery and Scheduler.
+const (
+	// DeliverAfterHeader is the time, in RFC 3339, before which the message
+	// isn't delivered to its topic.
+	DeliverAfterHeader = "deliver-after"
+	// DeliverToHeader names the topic the message is delivered to.
+	DeliverToHeader = "deliver-to"
+)
+
+// DefaultSchedulerMaxPending is the default of SchedulerConfig.MaxPending.
+const DefaultSchedulerMaxPending = 10000
+
+// ScheduledTopic returns the name of the scheduler topic that delayed
+// messages for topic wait in by default.
+func ScheduledTopic(topic string) string {
+	return topic + "-scheduled"
+}
+
+// WithDelayedDelivery makes producers send their messages to schedulerTopic,
+// "" meaning ScheduledTopic of their topic, with a DeliverToHeader naming
+// their topic and a DeliverAfterHeader of the send time plus delay. A
+// Scheduler reading schedulerTopic passes them on once they're due. Headers
+// given to Send can set DeliverAfterHeader themselves, to an RFC 3339 time
+// or a duration from the send time such as "90s", which takes precedence
+// over delay; with delay 0 only such messages are delayed and the others go
+// straight to the topic.
+func WithDelayedDelivery(delay time.Duration, schedulerTopic string) Option {
+	return func(o *options) error {
+		if delay < 0 {
+			return fmt.Errorf("delivery delay must not be negative, got %s", delay)
+		}
+		o.delayedDelivery = &delayedDelivery{delay: delay, topic: schedulerTopic}
+		return nil
+	}
+}
+
+// delayedDelivery is the producer side of WithDelayedDelivery. A nil one
+// sends every message straight to its topic.
+type delayedDelivery struct {
+	delay time.Duration
+	topic string
+}
+
+// schedule redirects msg to the scheduler topic if it is to be delivered
+// later.
+func (d *delayedDelivery) schedule(msg *sarama.ProducerMessage) error {
+	if d == nil {
+		return nil
+	}
+	due := time.Time{}
+	if d.delay > 0 {
+		due = time.Now().Add(d.delay)
+	}
+	for i, header := range msg.Headers {
+		if string(header.Key) != DeliverAfterHeader {
+			continue
+		}
+		value := string(header.Value)
+		if delay, err := time.ParseDuration(value); err == nil {
+			due = time.Now().Add(delay)
+		} else if due, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return fmt.Errorf("invalid %s header %q, want an RFC 3339 time or a duration", DeliverAfterHeader, value)
+		}
+		msg.Headers = append(msg.Headers[:i], msg.Headers[i+1:]...)
+		break
+	}
+	if due.IsZero() {
+		return nil
+	}
+
+	topic := d.topic
+	if topic == "" {
+		topic = ScheduledTopic(msg.Topic)
+	}
+	msg.Headers = append(msg.Headers,
+		stringHeader(DeliverAfterHeader, due.UTC().Format(time.RFC3339Nano)),
+		stringHeader(DeliverToHeader, msg.Topic),
+	)
+	msg.Topic = topic
+	return nil
+}
+
+// SchedulerConfig describes what a Scheduler reads.
+type SchedulerConfig struct {
+	// Topics are the scheduler topics.
+	Topics []string
+	// MaxPending is the most messages one partition holds until they're
+	// due; the scheduler stops reading the partition until the first of
+	// them is delivered. 0 means DefaultSchedulerMaxPending.
+	MaxPending int
+}
+
+// Scheduler emulates a delayed queue on Kafka. It consumes scheduler topics
+// in a consumer group, holds every message until its DeliverAfterHeader and
+// then produces it, with the same key, value and headers, to the topic in
+// its DeliverToHeader, where it gets a new timestamp and partition.
+// Messages don't have to be in due order: each partition's are held in a
+// queue by due time, and an offset is only committed once the message and
+// every one before it were delivered. A restart therefore delivers the
+// messages due after the oldest undelivered one again, at least once like
+// any consumer; the message-id header lets consumers with WithDedup drop
+// the repeats.
+type Scheduler struct {
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	groupID           string
+	config            SchedulerConfig
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+	metrics           Metrics
+
+	shutdownTimeout time.Duration
+	processCtx      context.Context
+
+	mu    sync.Mutex
+	stats schedulerStats
+}
+
+type schedulerStats struct {
+	delivered  int
+	invalid    int
+	maxPending int
+	maxLate    time.Duration
+}
+
+// NewScheduler creates a scheduler that reads config.Topics. A new group
+// starts from the oldest messages, so nothing scheduled before the first
+// start is lost. Messages are delivered with acks from all in-sync
+// replicas.
+func NewScheduler(brokers []string, groupID string, config SchedulerConfig, opts ...Option) (*Scheduler, error) {
+	if len(config.Topics) == 0 {
+		return nil, fmt.Errorf("at least one scheduler topic is required")
+	}
+	if config.MaxPending < 0 {
+		return nil, fmt.Errorf("max pending messages must not be negative, got %d", config.MaxPending)
+	}
+	if config.MaxPending == 0 {
+		config.MaxPending = DefaultSchedulerMaxPending
+	}
+
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	po, err := newOptions(producerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := o.newConsumerGroup(brokers, groupID)
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Scheduler{
+		consumer:          consumer,
+		producer:          producer,
+		groupID:           groupID,
+		config:            config,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+		metrics:           o.metrics,
+
+		shutdownTimeout: o.shutdownTimeout,
+	}, nil
+}
+
+// Consume schedules until ctx is cancelled. The messages being delivered at
+// that point get up to the shutdown timeout to be acknowledged; the ones
+// still held are delivered by the next run.
+func (s *Scheduler) Consume(ctx context.Context) error {
+	processCtx, cancel := drainContext(ctx, s.shutdownTimeout)
+	defer cancel()
+	s.processCtx = processCtx
+	defer s.rebalances.LogSummary()
+	defer s.logSummary()
+
+	failures := 0
+	for {
+		if err := s.consumer.Consume(ctx, s.config.Topics, s); err != nil {
+			failures++
+			if werr := s.backoff.wait(ctx, failures, "Scheduler group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (s *Scheduler) Setup(session GroupSession) error {
+	rebalance := s.rebalances.Record(s.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Scheduler setup completed", "topics", s.config.Topics, "group", s.groupID,
+		"strategy", s.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+// Cleanup commits the offsets of the messages delivered so far before the
+// partitions are released.
+func (s *Scheduler) Cleanup(session GroupSession) error {
+	session.Commit()
+	rebalance := s.rebalances.Record(s.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Scheduler cleanup completed", "topics", s.config.Topics, "group", s.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (s *Scheduler) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: s.rebalanceStrategy, GroupID: s.groupID, InstanceID: s.instanceID}
+}
+
+// ConsumeClaim holds the messages of the partition in a queue by due time
+// and delivers each once it's due. Messages that can't be delivered, for
+// lack of a DeliverToHeader or a valid DeliverAfterHeader, are logged and
+// skipped.
+func (s *Scheduler) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	window := newOffsetWatermark(func(message *sarama.ConsumerMessage) { session.MarkMessage(message, "") })
+	defer window.release()
+	var queue dueQueue
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		if err := s.deliverDue(&queue, window); err != nil {
+			return err
+		}
+
+		var due <-chan time.Time
+		if len(queue) > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(queue[0].due))
+			due = timer.C
+		}
+		// A full queue stops reading until its first message is due.
+		messages := claim.Messages()
+		if len(queue) >= s.config.MaxPending {
+			messages = nil
+		}
+
+		select {
+		case message := <-messages:
+			if message == nil || session.Context().Err() != nil {
+				return nil
+			}
+			s.metrics.MessageConsumed(message.Topic, message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
+			if !window.add(message) {
+				continue
+			}
+			scheduled, err := parseScheduled(message)
+			if err != nil {
+				slog.Error("Skipping message that can't be scheduled", "topic", message.Topic,
+					"partition", message.Partition, "offset", message.Offset, "error", err)
+				s.mu.Lock()
+				s.stats.invalid++
+				s.mu.Unlock()
+				window.complete(message.Offset)
+				continue
+			}
+			heap.Push(&queue, scheduled)
+			s.mu.Lock()
+			s.stats.maxPending = max(s.stats.maxPending, len(queue))
+			s.mu.Unlock()
+		case <-due:
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// deliverDue delivers the messages of queue that are due, retrying with
+// the backoff. If the target keeps failing the claim ends, and the next
+// session reads the undelivered messages again.
+func (s *Scheduler) deliverDue(queue *dueQueue, window *offsetWatermark) error {
+	for len(*queue) > 0 && !time.Now().Before((*queue)[0].due) {
+		next := heap.Pop(queue).(*scheduledMessage)
+		message := next.message
+		msg := copyMessage(next.target, message, false)
+		for i, header := range msg.Headers {
+			if string(header.Key) == DeliverToHeader {
+				msg.Headers = append(msg.Headers[:i], msg.Headers[i+1:]...)
+				break
+			}
+		}
+
+		err := s.backoff.Retry(s.processCtx, "Delivering scheduled message", func() error {
+			return sendCopies(s.producer, s.metrics, next.target, []*sarama.ProducerMessage{msg})
+		})
+		if err != nil {
+			slog.Error("Failed to deliver scheduled message", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "deliver_to", next.target, "error", err)
+			return fmt.Errorf("failed to deliver message of %s partition %d: %w", message.Topic, message.Partition, err)
+		}
+		window.complete(message.Offset)
+
+		late := time.Since(next.due)
+		s.mu.Lock()
+		s.stats.delivered++
+		s.stats.maxLate = max(s.stats.maxLate, late)
+		s.mu.Unlock()
+		slog.Debug("Scheduled message delivered", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "key", string(message.Key), "deliver_to", next.target,
+			"target_partition", msg.Partition, "target_offset", msg.Offset, "due", next.due, "late", late)
+	}
+	return nil
+}
+
+// logSummary logs how many messages were delivered, how late the latest
+// one was and the most that waited in one partition.
+func (s *Scheduler) logSummary() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slog.Info("Scheduler summary", "topics", s.config.Topics, "delivered", s.stats.delivered,
+		"invalid", s.stats.invalid, "max_pending", s.stats.maxPending, "max_late", s.stats.maxLate)
+}
+
+// Close stops consuming and closes the producer.
+func (s *Scheduler) Close() error {
+	consumerErr := s.consumer.Close()
+	if err := s.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
+
+// scheduledMessage is a message waiting in a Scheduler until due.
+type scheduledMessage struct {
+	message *sarama.ConsumerMessage
+	target  string
+	due     time.Time
+}
+
+// parseScheduled reads the headers of a message in a scheduler topic. A
+// message without DeliverAfterHeader is due at once.
+func parseScheduled(message *sarama.ConsumerMessage) (*scheduledMessage, error) {
+	target, ok := headerValue(message, DeliverToHeader)
+	if !ok || target == "" {
+		return nil, fmt.Errorf("no %s header", DeliverToHeader)
+	}
+	if target == message.Topic {
+		return nil, fmt.Errorf("%s header names the scheduler topic itself", DeliverToHeader)
+	}
+	scheduled := &scheduledMessage{message: message, target: target}
+	if value, ok := headerValue(message, DeliverAfterHeader); ok {
+		due, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header %q: %w", DeliverAfterHeader, value, err)
+		}
+		scheduled.due = due
+	}
+	return scheduled, nil
+}
+
+// dueQueue is a heap of scheduled messages, the first due first and, among
+// those due at the same time, the lowest offset first.
+type dueQueue []*scheduledMessage
+
+func (q dueQueue) Len() int { return len(q) }
+
+func (q dueQueue) Less(i, j int) bool {
+	if !q[i].due.Equal(q[j].due) {
+		return q[i].due.Before(q[j].due)
+	}
+	return q[i].message.Offset < q[j].message.Offset
+}
+
+func (q dueQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *dueQueue) Push(x any) { *q = append(*q, x.(*scheduledMessage)) }
+
+func (q *dueQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// LatestSchemaVersion is the newest JSON layout of UserEvent. Consumers
+// upcast every older payload to it before handing the event on.
+const LatestSchemaVersion = 3
+
+// UserEventV1 is the original JSON layout, the one UserEvent still uses.
+type UserEventV1 struct {
+	UserID    string                 `json:"user_id"`
+	EventType string                 `json:"event_type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// UserEventV2 adds two optional fields. Readers of v1 ignore them and readers
+// of v2 fill in defaults when they are missing, so the change is both
+// backward and forward compatible.
+type UserEventV2 struct {
+	UserID    string                 `json:"user_id"`
+	EventType string                 `json:"event_type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	SessionID string                 `json:"session_id,omitempty"`
+	Source    string                 `json:"source,omitempty"`
+}
+
+// UserEventV3 renames fields. Old readers can't find user_id or event_type
+// in it any more, so v3 is backward compatible through upcasting but not
+// forward compatible.
+type UserEventV3 struct {
+	ActorID    string                 `json:"actor_id"`
+	Type       string                 `json:"type"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	SessionID  string                 `json:"session_id,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+}
+
+// defaultSource fills in the source field added in v2 for events that don't
+// have one, including every v1 event.
+const defaultSource = "unknown"
+
+// upcastV1 moves the session ID and source out of the data map into their
+// own fields.
+func upcastV1(e UserEventV1) UserEventV2 {
+	v2 := UserEventV2{
+		UserID:    e.UserID,
+		EventType: e.EventType,
+		Timestamp: e.Timestamp,
+	}
+	data := make(map[string]interface{}, len(e.Data))
+	for k, v := range e.Data {
+		switch k {
+		case "session_id":
+			v2.SessionID = fmt.Sprint(v)
+		case "source":
+			v2.Source = fmt.Sprint(v)
+		default:
+			data[k] = v
+		}
+	}
+	if len(data) > 0 {
+		v2.Data = data
+	}
+	return v2
+}
+
+// upcastV2 applies the renames of v3.
+func upcastV2(e UserEventV2) UserEventV3 {
+	return UserEventV3{
+		ActorID:    e.UserID,
+		Type:       e.EventType,
+		OccurredAt: e.Timestamp,
+		Attributes: e.Data,
+		SessionID:  e.SessionID,
+		Source:     e.Source,
+	}
+}
+
+// UserEvent converts the latest layout to the in-memory event every handler
+// works with. The session ID and source go back into Data.
+func (e UserEventV3) UserEvent() UserEvent {
+	event := UserEvent{
+		UserID:    e.ActorID,
+		EventType: e.Type,
+		Timestamp: e.OccurredAt,
+	}
+	if len(e.Attributes) > 0 || e.SessionID != "" || e.Source != "" {
+		event.Data = make(map[string]interface{}, len(e.Attributes)+2)
+		for k, v := range e.Attributes {
+			event.Data[k] = v
+		}
+		if e.SessionID != "" {
+			event.Data["session_id"] = e.SessionID
+		}
+		if e.Source != "" {
+			event.Data["source"] = e.Source
+		}
+	}
+	return event
+}
+
+// encodeVersion writes event in the layout of version.
+func encodeVersion(event UserEvent, version int) (interface{}, error) {
+	v1 := UserEventV1(event)
+	switch version {
+	case 0, 1:
+		return v1, nil
+	case 2:
+		return upcastV1(v1), nil
+	case 3:
+		return upcastV2(upcastV1(v1)), nil
+	default:
+		return nil, fmt.Errorf("unsupported schema version %d (latest is %d)", version, LatestSchemaVersion)
+	}
+}
+
+// decodeVersion reads data in the layout of version and upcasts it step by
+// step to the latest one.
+func decodeVersion(data []byte, version int) (UserEvent, error) {
+	var latest UserEventV3
+	switch version {
+	case 1:
+		var v1 UserEventV1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return UserEvent{}, fmt.Errorf("failed to unmarshal v1 event: %w", err)
+		}
+		latest = upcastV2(upcastV1(v1))
+	case 2:
+		var v2 UserEventV2
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return UserEvent{}, fmt.Errorf("failed to unmarshal v2 event: %w", err)
+		}
+		latest = upcastV2(v2)
+	case 3:
+		if err := json.Unmarshal(data, &latest); err != nil {
+			return UserEvent{}, fmt.Errorf("failed to unmarshal v3 event: %w", err)
+		}
+	default:
+		return UserEvent{}, fmt.Errorf("unsupported schema version %d (this reader knows up to %d)", version, LatestSchemaVersion)
+	}
+
+	// Required fields that come out empty mean the payload was written in
+	// a different layout, e.g. v3 read as v1.
+	if latest.ActorID == "" || latest.Type == "" {
+		return UserEvent{}, fmt.Errorf("event is missing its user or event type when read as v%d, it was probably written with another schema version", version)
+	}
+	if latest.Source == "" {
+		latest.Source = defaultSource
+	}
+	if version < LatestSchemaVersion {
+		slog.Debug("Upcast event", "from_version", version, "to_version", LatestSchemaVersion, "key", latest.ActorID)
+	}
+	return latest.UserEvent(), nil
+}
+
+// parseSchemaVersion parses the schema-version header.
+func parseSchemaVersion(value string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid schema version %q", value)
+	}
+	return version, nil
+}
+
+// WithSchemaReaderVersion makes a consumer read every JSON payload with the
+// layout of version, ignoring the schema-version header, the way a consumer
+// built before newer versions existed would. It shows which schema changes
+// old readers survive. 0, the default, upcasts each payload from the
+// version in its header.
+func WithSchemaReaderVersion(version int) Option {
+	return func(o *options) error {
+		if version < 0 || version > LatestSchemaVersion {
+			return fmt.Errorf("schema reader version must be between 1 and %d, got %d", LatestSchemaVersion, version)
+		}
+		o.readerVersion = version
+		return nil
+	}
+}
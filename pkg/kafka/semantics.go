@@ -0,0 +1,89 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+)
+
+// Delivery semantics of a consumer group.
+const (
+	// DeliveryAtLeastOnce commits a message's offset after processing it.
+	// A crash in between redelivers the message, so it may be processed twice.
+	DeliveryAtLeastOnce = "at-least-once"
+	// DeliveryAtMostOnce commits a message's offset before processing it.
+	// A crash in between loses the message, but it is never processed twice.
+	DeliveryAtMostOnce = "at-most-once"
+)
+
+// errSimulatedCrash ends the group session the way a crash would, without
+// committing the message being handled.
+var errSimulatedCrash = errors.New("simulated crash")
+
+// WithDeliverySemantics chooses whether offsets are committed after
+// (DeliveryAtLeastOnce, the default) or before (DeliveryAtMostOnce)
+// processing. At-most-once commits synchronously for every message, so it
+// can't be combined with a commit strategy other than CommitAuto.
+func WithDeliverySemantics(semantics string) Option {
+	return func(o *options) error {
+		switch semantics = strings.ToLower(strings.TrimSpace(semantics)); semantics {
+		case "", DeliveryAtLeastOnce:
+			o.semantics = DeliveryAtLeastOnce
+		case DeliveryAtMostOnce:
+			o.semantics = DeliveryAtMostOnce
+		default:
+			return fmt.Errorf("unknown delivery semantics %q (want %s or %s)", semantics, DeliveryAtLeastOnce, DeliveryAtMostOnce)
+		}
+		return nil
+	}
+}
+
+// WithSimulatedCrash makes the consumer pretend to crash once, on the
+// after'th message, at the point where the delivery semantics are exposed:
+// between committing and processing for at-most-once, and between
+// processing and committing for at-least-once. The group session ends
+// without committing that message and the consumer rejoins, so the message
+// is either lost or processed twice.
+func WithSimulatedCrash(after int) Option {
+	return func(o *options) error {
+		if after < 0 {
+			return fmt.Errorf("simulated crash position must not be negative, got %d", after)
+		}
+		o.crashAfter = after
+		return nil
+	}
+}
+
+// crashSimulator triggers a single simulated crash after a number of
+// messages.
+type crashSimulator struct {
+	after int64
+	seen  atomic.Int64
+}
+
+func newCrashSimulator(after int) *crashSimulator {
+	if after <= 0 {
+		return nil
+	}
+	return &crashSimulator{after: int64(after)}
+}
+
+// crash reports whether message is the one to crash on, and explains what
+// the crash means for it.
+func (s *crashSimulator) crash(message *sarama.ConsumerMessage, semantics string) bool {
+	if s == nil || s.seen.Add(1) != s.after {
+		return false
+	}
+
+	point, effect := "after processing, before commit", "it will be redelivered and processed again"
+	if semantics == DeliveryAtMostOnce {
+		point, effect = "after commit, before processing", "it will never be processed"
+	}
+	slog.Warn("Simulated crash", "semantics", semantics, "crash_point", point, "effect", effect,
+		"topic", message.Topic, "partition", message.Partition, "offset", message.Offset, "key", string(message.Key))
+	return true
+}
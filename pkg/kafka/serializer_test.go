@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEvent() UserEvent {
+	return UserEvent{
+		UserID:    "user-123",
+		EventType: "purchase",
+		// Nanoseconds and a zone other than UTC must survive the round trip.
+		Timestamp: time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("CET", 3600)),
+		Data: map[string]interface{}{
+			"amount":   49.99,
+			"quantity": float64(2),
+			"currency": "EUR",
+			"gift":     true,
+			"coupon":   nil,
+			"items":    []interface{}{"sku-1", "sku-2"},
+			"shipping": map[string]interface{}{"country": "DE", "express": false},
+		},
+	}
+}
+
+func TestJSONSerializerRoundTrip(t *testing.T) {
+	event := testEvent()
+	data, err := JSONSerializer{}.Serialize(event)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	var decoded UserEvent
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+	if decoded.UserID != event.UserID || decoded.EventType != event.EventType {
+		t.Errorf("decoded user %q, type %q, want %q, %q", decoded.UserID, decoded.EventType, event.UserID, event.EventType)
+	}
+	if !decoded.Timestamp.Equal(event.Timestamp) {
+		t.Errorf("decoded timestamp %s, want %s", decoded.Timestamp, event.Timestamp)
+	}
+	if _, offset := decoded.Timestamp.Zone(); offset != 3600 {
+		t.Errorf("decoded timestamp has offset %ds, want 3600s", offset)
+	}
+	if !reflect.DeepEqual(decoded.Data, event.Data) {
+		t.Errorf("decoded data %#v, want %#v", decoded.Data, event.Data)
+	}
+
+	deserialized, err := JSONSerializer{}.Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if !reflect.DeepEqual(deserialized, decoded) {
+		t.Errorf("Deserialize returned %#v, want %#v", deserialized, decoded)
+	}
+}
+
+func TestJSONSerializerFieldNames(t *testing.T) {
+	data, err := JSONSerializer{}.Serialize(testEvent())
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	for _, name := range []string{"user_id", "event_type", "timestamp", "data"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("serialized event %s has no %q field", data, name)
+		}
+	}
+	var timestamp string
+	if err := json.Unmarshal(fields["timestamp"], &timestamp); err != nil {
+		t.Fatalf("timestamp is not a string: %v", err)
+	}
+	if want := "2024-03-01T12:30:45.123456789+01:00"; timestamp != want {
+		t.Errorf("timestamp %q, want RFC 3339 %q", timestamp, want)
+	}
+}
+
+func TestJSONSerializerOmitsEmptyData(t *testing.T) {
+	event := testEvent()
+	event.Data = nil
+	data, err := JSONSerializer{}.Serialize(event)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if strings.Contains(string(data), `"data"`) {
+		t.Errorf("serialized event %s has a data field", data)
+	}
+	decoded, err := JSONSerializer{}.Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if decoded.Data != nil {
+		t.Errorf("decoded data %#v, want nil", decoded.Data)
+	}
+}
+
+func TestJSONSerializerErrors(t *testing.T) {
+	unencodable := testEvent()
+	unencodable.Data = map[string]interface{}{"ratio": math.NaN()}
+
+	tests := []struct {
+		name       string
+		serializer JSONSerializer
+		event      UserEvent
+		want       string
+	}{
+		{"unsupported version", JSONSerializer{Version: LatestSchemaVersion + 1}, testEvent(), "unsupported schema version"},
+		{"unencodable data", JSONSerializer{}, unencodable, "failed to marshal event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.serializer.Serialize(tt.event)
+			if err == nil {
+				t.Fatalf("Serialize returned %s, want an error", data)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Serialize error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	for _, data := range []string{"", "not json", `{"user_id": 42}`, `["user-123"]`} {
+		if _, err := (JSONSerializer{}).Deserialize([]byte(data)); err == nil {
+			t.Errorf("Deserialize(%q) succeeded, want an error", data)
+		} else if !strings.Contains(err.Error(), "failed to unmarshal event") {
+			t.Errorf("Deserialize(%q) error %q, want it to contain %q", data, err, "failed to unmarshal event")
+		}
+	}
+}
+
+func TestJSONSerializerVersionsRoundTrip(t *testing.T) {
+	event := testEvent()
+	event.Data = map[string]interface{}{"page": "/checkout"}
+	for version := 1; version <= LatestSchemaVersion; version++ {
+		serializer := JSONSerializer{Version: version}
+		data, err := serializer.Serialize(event)
+		if err != nil {
+			t.Fatalf("v%d Serialize: %v", version, err)
+		}
+		decoded, err := serializer.DeserializeVersion(data, serializer.SchemaVersion())
+		if err != nil {
+			t.Fatalf("v%d DeserializeVersion: %v", version, err)
+		}
+		if decoded.UserID != event.UserID || decoded.EventType != event.EventType || !decoded.Timestamp.Equal(event.Timestamp) {
+			t.Errorf("v%d decoded %+v, want %+v", version, decoded, event)
+		}
+		if decoded.Data["page"] != "/checkout" {
+			t.Errorf("v%d decoded data %#v, want page /checkout", version, decoded.Data)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SessionWindows describes how a Sessionizer groups events into sessions: a
+// user's session ends once Gap passes without an event of theirs. Events
+// may arrive up to Lateness behind the newest event time seen before the
+// sessions they belong to close.
+type SessionWindows struct {
+	Gap      time.Duration
+	Lateness time.Duration
+}
+
+// DefaultSessionWindows end sessions after thirty minutes of inactivity and
+// wait ten seconds for late events.
+var DefaultSessionWindows = SessionWindows{Gap: 30 * time.Minute, Lateness: 10 * time.Second}
+
+// WithSessionWindows makes a Sessionizer group events into w.
+func WithSessionWindows(w SessionWindows) Option {
+	return func(o *options) error {
+		if w.Gap <= 0 || w.Lateness < 0 {
+			return fmt.Errorf("invalid session windows: gap %s, lateness %s", w.Gap, w.Lateness)
+		}
+		o.sessionWindows = w
+		return nil
+	}
+}
+
+// SessionSummary describes one closed session of a user. Summaries are
+// published keyed by user ID.
+type SessionSummary struct {
+	UserID          string           `json:"user_id"`
+	SessionStart    time.Time        `json:"session_start"`
+	SessionEnd      time.Time        `json:"session_end"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Events          int64            `json:"events"`
+	EventCounts     map[string]int64 `json:"event_counts"`
+	Purchases       int64            `json:"purchases"`
+	PurchaseTotal   float64          `json:"purchase_total"`
+}
+
+// Sessionizer groups each user's events into sessions separated by an
+// inactivity gap and publishes a summary of each session to the output
+// topic when it closes. Event time is the timestamp in the payload, or the
+// record timestamp for events without one, and an event that arrives out of
+// order extends or merges the sessions it falls between. Each partition has
+// a watermark trailing the newest event time by the allowed lateness; a
+// session closes when the watermark passes its last event plus the gap,
+// and events that would only belong to closed sessions are dropped as late.
+// Sessions are closed once a second, and a partition that receives nothing
+// for a gap plus the lateness moves its watermark on by the wall clock.
+//
+// Open sessions only live in memory. The committed offset stays at the
+// first event of the oldest open session and carries the watermark of the
+// last close and the offset read up to as metadata, so after a restart or
+// rebalance the sessions are built again from the log, and those that had
+// closed already are dropped instead of published again. A crash between
+// publishing and committing publishes those sessions again.
+type Sessionizer struct {
+	decoder
+	client            Client
+	admin             Admin
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	inputTopic        string
+	outputTopic       string
+	groupID           string
+	windows           SessionWindows
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+}
+
+// NewSessionizer creates a sessionizer from inputTopic to outputTopic. The
+// input has to be keyed by user ID, so that each user's events stay on one
+// partition.
+func NewSessionizer(brokers []string, inputTopic, outputTopic, groupID string, opts ...Option) (*Sessionizer, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	po, err := newOptions(producerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	consumer, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		admin.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		consumer.Close()
+		admin.Close()
+		client.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Sessionizer{
+		decoder:           o.decoder(),
+		client:            client,
+		admin:             admin,
+		consumer:          consumer,
+		producer:          producer,
+		inputTopic:        inputTopic,
+		outputTopic:       outputTopic,
+		groupID:           groupID,
+		windows:           o.sessionWindows,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+	}, nil
+}
+
+// Consume runs the sessionizer until ctx is cancelled. Sessions still open
+// then are built again by the next session.
+func (s *Sessionizer) Consume(ctx context.Context) error {
+	defer s.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := s.consumer.Consume(ctx, []string{s.inputTopic}, s); err != nil {
+			failures++
+			if werr := s.backoff.wait(ctx, failures, "Sessionizer group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (s *Sessionizer) Setup(session GroupSession) error {
+	rebalance := s.rebalances.Record(s.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Sessionizer setup completed", "input_topic", s.inputTopic, "output_topic", s.outputTopic, "group", s.groupID,
+		"strategy", s.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+func (s *Sessionizer) Cleanup(session GroupSession) error {
+	rebalance := s.rebalances.Record(s.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Sessionizer cleanup completed", "input_topic", s.inputTopic, "output_topic", s.outputTopic, "group", s.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (s *Sessionizer) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: s.rebalanceStrategy, GroupID: s.groupID, InstanceID: s.instanceID}
+}
+
+func (s *Sessionizer) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	state, err := s.resume(claim.Partition())
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(windowTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				s.mark(session, state)
+				return nil
+			}
+			s.add(state, message)
+
+		case <-ticker.C:
+			if state.open > 0 && time.Since(state.lastRead) >= s.windows.Gap+s.windows.Lateness {
+				if idle := time.Now().Add(-s.windows.Lateness); idle.After(state.watermark) {
+					state.watermark = idle
+				}
+			}
+			if err := s.close(session.Context(), state); err != nil {
+				return err
+			}
+			s.mark(session, state)
+
+		case <-session.Context().Done():
+			s.mark(session, state)
+			return nil
+		}
+	}
+}
+
+// userSession is an open session of one user.
+type userSession struct {
+	start, end    time.Time
+	events        int64
+	counts        map[string]int64
+	purchases     int64
+	purchaseTotal float64
+	firstOffset   int64
+}
+
+// merge adds the events of other to us.
+func (us *userSession) merge(other *userSession) {
+	if other.start.Before(us.start) {
+		us.start = other.start
+	}
+	if other.end.After(us.end) {
+		us.end = other.end
+	}
+	us.events += other.events
+	for eventType, count := range other.counts {
+		us.counts[eventType] += count
+	}
+	us.purchases += other.purchases
+	us.purchaseTotal += other.purchaseTotal
+	if other.firstOffset < us.firstOffset {
+		us.firstOffset = other.firstOffset
+	}
+}
+
+// sessionState holds the open sessions of one partition, oldest first per
+// user.
+type sessionState struct {
+	partition int32
+	watermark time.Time
+	// closed is the watermark of the last close: every session that ended a
+	// gap before it has been published.
+	closed time.Time
+	users  map[string][]*userSession
+	open   int
+	next   int64
+	// resumeAt is the offset the last session had read up to: events
+	// before it rebuild sessions, some of which had closed already.
+	resumeAt int64
+	late     int
+	lastRead time.Time
+}
+
+// resume picks up the watermark and the offset read up to that the last
+// session of partition committed.
+func (s *Sessionizer) resume(partition int32) (*sessionState, error) {
+	state := &sessionState{partition: partition, users: make(map[string][]*userSession), lastRead: time.Now()}
+
+	committed, err := s.admin.ListGroupOffsets(s.groupID, map[string][]int32{s.inputTopic: {partition}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offset for partition %d: %w", partition, err)
+	}
+	block, ok := committed[s.inputTopic][partition]
+	if !ok || block.Offset < 0 || block.Metadata == "" {
+		return state, nil
+	}
+	var metadata replayMetadata
+	if err := json.Unmarshal([]byte(block.Metadata), &metadata); err != nil {
+		slog.Warn("Ignoring invalid session metadata", "topic", s.inputTopic, "partition", partition,
+			"metadata", block.Metadata, "error", err)
+		return state, nil
+	}
+	state.watermark = metadata.Watermark
+	state.closed = metadata.Watermark
+	state.resumeAt = metadata.Next
+	slog.Info("Sessions resumed", "topic", s.inputTopic, "partition", partition, "offset", block.Offset,
+		"watermark", metadata.Watermark, "replay_until", metadata.Next)
+	return state, nil
+}
+
+// add puts message into its user's session, merging the open sessions it
+// falls within a gap of.
+func (s *Sessionizer) add(state *sessionState, message *sarama.ConsumerMessage) {
+	state.next = message.Offset + 1
+	state.lastRead = time.Now()
+
+	event, err := s.DecodeEvent(message)
+	if err != nil || event.UserID == "" {
+		slog.Warn("Skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		return
+	}
+
+	at := event.Timestamp
+	if at.IsZero() {
+		at = message.Timestamp
+	}
+
+	current := &userSession{start: at, end: at, events: 1, counts: map[string]int64{event.EventType: 1}, firstOffset: message.Offset}
+	if event.EventType == eventPurchase {
+		amount, err := purchaseAmount(event)
+		if err != nil {
+			slog.Warn("Purchase without a valid amount", "topic", message.Topic, "partition", message.Partition,
+				"offset", message.Offset, "user_id", event.UserID, "error", err)
+		}
+		current.purchases = 1
+		current.purchaseTotal = amount
+	}
+
+	var kept []*userSession
+	merged := false
+	for _, open := range state.users[event.UserID] {
+		if at.Before(open.start.Add(-s.windows.Gap)) || at.After(open.end.Add(s.windows.Gap)) {
+			kept = append(kept, open)
+			continue
+		}
+		current.merge(open)
+		state.open--
+		merged = true
+	}
+
+	// An event that starts a session of its own is late once that session
+	// would have closed already. Replayed events rebuild their sessions
+	// whether or not they closed, and close sorts them out.
+	replay := message.Offset < state.resumeAt
+	if !merged && !replay && !at.Add(s.windows.Gap).After(state.watermark) {
+		state.late++
+		slog.Debug("Dropping late event", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "user_id", event.UserID, "event_time", at, "watermark", state.watermark)
+		return
+	}
+
+	kept = append(kept, current)
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start.Before(kept[j].start) })
+	state.users[event.UserID] = kept
+	state.open++
+
+	if watermark := at.Add(-s.windows.Lateness); watermark.After(state.watermark) {
+		state.watermark = watermark
+	}
+}
+
+// close publishes the sessions the watermark has passed the gap after,
+// except for rebuilt ones a previous session published already.
+func (s *Sessionizer) close(ctx context.Context, state *sessionState) error {
+	var closed []SessionSummary
+	for user, sessions := range state.users {
+		var open []*userSession
+		for _, us := range sessions {
+			closesAt := us.end.Add(s.windows.Gap)
+			if closesAt.After(state.watermark) {
+				open = append(open, us)
+				continue
+			}
+			state.open--
+			if closesAt.After(state.closed) {
+				closed = append(closed, us.summary(user))
+			}
+		}
+		if len(open) == 0 {
+			delete(state.users, user)
+		} else {
+			state.users[user] = open
+		}
+	}
+	if len(closed) == 0 {
+		state.closed = state.watermark
+		return nil
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].SessionEnd.Before(closed[j].SessionEnd) })
+
+	if err := s.publish(ctx, closed); err != nil {
+		return err
+	}
+	state.closed = state.watermark
+
+	var events int64
+	for _, summary := range closed {
+		events += summary.Events
+	}
+	slog.Info("Sessions closed", "topic", s.inputTopic, "partition", state.partition, "sessions", len(closed),
+		"events", events, "open", state.open, "late", state.late, "watermark", state.watermark)
+	state.late = 0
+	return nil
+}
+
+func (us *userSession) summary(user string) SessionSummary {
+	return SessionSummary{
+		UserID:          user,
+		SessionStart:    us.start.UTC(),
+		SessionEnd:      us.end.UTC(),
+		DurationSeconds: us.end.Sub(us.start).Seconds(),
+		Events:          us.events,
+		EventCounts:     us.counts,
+		Purchases:       us.purchases,
+		PurchaseTotal:   math.Round(us.purchaseTotal*100) / 100,
+	}
+}
+
+// publish sends summaries, keyed by user ID.
+func (s *Sessionizer) publish(ctx context.Context, summaries []SessionSummary) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(summaries))
+	for _, summary := range summaries {
+		value, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic:   s.outputTopic,
+			Key:     sarama.StringEncoder(summary.UserID),
+			Value:   sarama.ByteEncoder(value),
+			Headers: []sarama.RecordHeader{stringHeader(ContentTypeHeader, JSONSerializer{}.ContentType())},
+		})
+		slog.Debug("Session summary", "user_id", summary.UserID, "session_start", summary.SessionStart,
+			"duration_seconds", summary.DurationSeconds, "events", summary.Events, "purchase_total", summary.PurchaseTotal)
+	}
+
+	err := s.backoff.Retry(ctx, "Publishing session summaries", func() error {
+		batch := make([]*sarama.ProducerMessage, len(messages))
+		for i, msg := range messages {
+			batch[i] = resendable(msg)
+		}
+		return s.producer.SendMessages(batch)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %d session summaries: %w", len(summaries), err)
+	}
+	return nil
+}
+
+// mark marks the offset of the first event of the oldest open session,
+// together with the watermark of the last close and the offset read up to.
+func (s *Sessionizer) mark(session GroupSession, state *sessionState) {
+	if state.next == 0 {
+		return
+	}
+	offset := state.next
+	for _, sessions := range state.users {
+		for _, us := range sessions {
+			if us.firstOffset < offset {
+				offset = us.firstOffset
+			}
+		}
+	}
+	next := state.next
+	if state.resumeAt > next {
+		next = state.resumeAt
+	}
+	metadata, err := json.Marshal(replayMetadata{Watermark: state.closed.UTC(), Next: next})
+	if err != nil {
+		slog.Error("Failed to encode session metadata", "partition", state.partition, "error", err)
+		return
+	}
+	session.MarkOffset(s.inputTopic, state.partition, offset, string(metadata))
+}
+
+func (s *Sessionizer) Close() error {
+	consumerErr := s.consumer.Close()
+	if err := s.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := s.admin.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := s.client.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
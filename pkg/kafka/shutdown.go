@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// WithShutdownTimeout bounds how long a message that is being processed when
+// Consume's context is cancelled may keep running. No new messages are
+// picked up once shutdown starts.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout <= 0 {
+			return fmt.Errorf("shutdown timeout must be positive, got %s", timeout)
+		}
+		o.shutdownTimeout = timeout
+		return nil
+	}
+}
+
+// drainContext returns a context for processing messages that outlives ctx
+// by at most timeout, so in-flight work can finish during shutdown instead
+// of being cut off halfway.
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"bytes"
+	"log/slog"
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// RebalanceSingleActive assigns every partition to one member of the group,
+// the active consumer, while the others stay connected as hot standbys.
+const RebalanceSingleActive = "single-active"
+
+// activeMarker is the user data the active member gets with its assignment
+// and sends back when it joins the next generation, so it keeps the role.
+var activeMarker = []byte("active")
+
+// singleActiveStrategy elects the active consumer through the group
+// membership itself: the group leader's Plan gives all partitions to one
+// member, preferring the one that was active in the previous generation so
+// a standby joining doesn't move them. When the active member leaves or its
+// session times out, the next rebalance hands everything to a standby.
+type singleActiveStrategy struct{}
+
+func (singleActiveStrategy) Name() string { return RebalanceSingleActive }
+
+// Plan assigns each topic to the member that was active before, or else the
+// one with the lowest member ID, among the members subscribed to it. Every
+// subscribed member is in the plan, the standbys with no partitions, since
+// sarama only calls AssignmentData for the members in it.
+func (singleActiveStrategy) Plan(members map[string]sarama.ConsumerGroupMemberMetadata, topics map[string][]int32) (sarama.BalanceStrategyPlan, error) {
+	plan := make(sarama.BalanceStrategyPlan)
+	for topic, partitions := range topics {
+		var candidates []string
+		for memberID, meta := range members {
+			for _, t := range meta.Topics {
+				if t == topic {
+					candidates = append(candidates, memberID)
+					if plan[memberID] == nil {
+						plan[memberID] = make(map[string][]int32)
+					}
+					break
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a := bytes.Equal(members[candidates[i]].UserData, activeMarker)
+			b := bytes.Equal(members[candidates[j]].UserData, activeMarker)
+			if a != b {
+				return a
+			}
+			return candidates[i] < candidates[j]
+		})
+		plan.Add(candidates[0], topic, partitions...)
+	}
+	return plan, nil
+}
+
+// AssignmentData marks the member that got partitions as the active one.
+// Standbys get no user data, so a member that lost the active role stops
+// claiming it when it joins the next generation.
+func (singleActiveStrategy) AssignmentData(memberID string, topics map[string][]int32, generationID int32) ([]byte, error) {
+	if countPartitions(topics) == 0 {
+		return nil, nil
+	}
+	return activeMarker, nil
+}
+
+// logLeadership logs the setup event of a single-active member when it
+// takes over, or gives up, the active role; wasActive tells whether the
+// previous setup held partitions, first that there was none. Taking over
+// from a standby is a warning, since it means the active member is gone.
+func logLeadership(event RebalanceEvent, wasActive, first bool) {
+	active := countPartitions(event.Claims) > 0
+	if active == wasActive && !first {
+		return
+	}
+	attrs := []any{"group", event.GroupID, "member_id", event.MemberID, "generation", event.GenerationID}
+	if event.InstanceID != "" {
+		attrs = append(attrs, "instance_id", event.InstanceID)
+	}
+	switch {
+	case active && first:
+		slog.Info("Became the active consumer", append(attrs, "claims", event.Claims)...)
+	case active:
+		slog.Warn("Taking over as the active consumer", append(attrs, "claims", event.Claims)...)
+	case wasActive:
+		slog.Warn("Lost the active role, standing by", attrs...)
+	default:
+		slog.Info("Standing by, another member is the active consumer", attrs...)
+	}
+}
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestSingleActivePlan(t *testing.T) {
+	strategy := singleActiveStrategy{}
+	members := map[string]sarama.ConsumerGroupMemberMetadata{
+		"member-a": {Topics: []string{"events"}},
+		"member-b": {Topics: []string{"events"}, UserData: activeMarker},
+		"member-c": {Topics: []string{"events"}},
+		"member-d": {Topics: []string{"audit"}},
+	}
+	plan, err := strategy.Plan(members, map[string][]int32{"events": {0, 1, 2}})
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+
+	want := sarama.BalanceStrategyPlan{
+		"member-a": {},
+		"member-b": {"events": {0, 1, 2}},
+		"member-c": {},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Fatalf("Plan() = %v, want %v", plan, want)
+	}
+
+	for memberID, topics := range plan {
+		data, err := strategy.AssignmentData(memberID, topics, 2)
+		if err != nil {
+			t.Fatalf("AssignmentData(%s): %v", memberID, err)
+		}
+		if active := memberID == "member-b"; active != (data != nil) {
+			t.Errorf("AssignmentData(%s) = %q, want the active marker only for the active member", memberID, data)
+		}
+	}
+}
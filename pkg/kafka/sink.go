@@ -0,0 +1,312 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SinkRecord is a consumed message as a sink stores it. Value holds the
+// decoded event, or the raw value if it isn't one: as is if it is JSON,
+// otherwise as a string. A tombstone, a message without a value, has the
+// value null.
+type SinkRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     json.RawMessage   `json:"value"`
+	Tombstone bool              `json:"tombstone,omitempty"`
+}
+
+// Sink stores batches of records outside Kafka. Write must only return nil
+// once the records are durable, since the offsets are committed after it.
+// A batch may be written again after a crash or rebalance, so sinks should
+// tolerate records they already have. Sinks must be safe for concurrent
+// use; every partition writes its own batches.
+type Sink interface {
+	Write(ctx context.Context, records []SinkRecord) error
+	Close() error
+}
+
+// WithSinkBatch makes a SinkConsumer write a partition's batch once it holds
+// messages records or bytes bytes of values, or interval after its first
+// record, whichever comes first.
+func WithSinkBatch(messages, bytes int, interval time.Duration) Option {
+	return func(o *options) error {
+		if messages < 1 || bytes < 1 || interval <= 0 {
+			return fmt.Errorf("invalid sink batch: %d messages, %d bytes, interval %s", messages, bytes, interval)
+		}
+		o.sinkBatchMessages = messages
+		o.sinkBatchBytes = bytes
+		o.sinkFlushInterval = interval
+		return nil
+	}
+}
+
+// SinkConsumer writes consumed messages to a Sink in batches, one partition
+// at a time, and only marks a batch's offsets once the sink has stored it.
+// A crash loses no message, but may write the last batches again.
+type SinkConsumer struct {
+	decoder
+	consumer          ConsumerGroup
+	sink              Sink
+	checkpoints       CheckpointStore
+	topic             string
+	groupID           string
+	batchMessages     int
+	batchBytes        int
+	flushInterval     time.Duration
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+
+	shutdownTimeout time.Duration
+	processCtx      context.Context
+}
+
+// NewSinkConsumer creates a consumer writing topic to sink.
+func NewSinkConsumer(brokers []string, topic, groupID string, sink Sink, opts ...Option) (*SinkConsumer, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	consumer, err := o.newConsumerGroup(brokers, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &SinkConsumer{
+		decoder:           o.decoder(),
+		consumer:          consumer,
+		sink:              sink,
+		checkpoints:       o.checkpoints,
+		topic:             topic,
+		groupID:           groupID,
+		batchMessages:     o.sinkBatchMessages,
+		batchBytes:        o.sinkBatchBytes,
+		flushInterval:     o.sinkFlushInterval,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        config.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+
+		shutdownTimeout: o.shutdownTimeout,
+	}, nil
+}
+
+// Consume runs the consumer until ctx is cancelled. The batches open at that
+// point get up to the shutdown timeout to be written.
+func (c *SinkConsumer) Consume(ctx context.Context) error {
+	processCtx, cancel := drainContext(ctx, c.shutdownTimeout)
+	defer cancel()
+	c.processCtx = processCtx
+	defer c.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := c.consumer.Consume(ctx, []string{c.topic}, c); err != nil {
+			failures++
+			if werr := c.backoff.wait(ctx, failures, "Sink group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (c *SinkConsumer) Setup(session GroupSession) error {
+	if c.checkpoints != nil {
+		if err := resumeFromCheckpoints(session, c.checkpoints, c.groupID, c.topic); err != nil {
+			return err
+		}
+	}
+	rebalance := c.rebalances.Record(c.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Sink consumer setup completed", "topic", c.topic, "group", c.groupID,
+		"strategy", c.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+// Cleanup commits the offsets of the batches written last before the
+// partitions are released. With a CheckpointStore they are already saved.
+func (c *SinkConsumer) Cleanup(session GroupSession) error {
+	if c.checkpoints == nil {
+		session.Commit()
+	}
+	rebalance := c.rebalances.Record(c.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Sink consumer cleanup completed", "topic", c.topic, "group", c.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (c *SinkConsumer) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: c.rebalanceStrategy, GroupID: c.groupID, InstanceID: c.instanceID}
+}
+
+func (c *SinkConsumer) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	batch := &sinkBatch{partition: claim.Partition()}
+
+	timer := time.NewTimer(c.flushInterval)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return c.flush(session, batch)
+			}
+			if len(batch.records) == 0 {
+				timer.Reset(c.flushInterval)
+			}
+			batch.add(c.record(message), message)
+			if len(batch.records) >= c.batchMessages || batch.bytes >= c.batchBytes {
+				if !timer.Stop() {
+					// The timer fired while the message was added.
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				if err := c.flush(session, batch); err != nil {
+					return err
+				}
+			}
+
+		case <-timer.C:
+			if err := c.flush(session, batch); err != nil {
+				return err
+			}
+
+		case <-session.Context().Done():
+			return c.flush(session, batch)
+		}
+	}
+}
+
+// sinkBatch collects the records of one partition until they are written.
+type sinkBatch struct {
+	partition int32
+	records   []SinkRecord
+	bytes     int
+	last      *sarama.ConsumerMessage
+	started   time.Time
+}
+
+func (b *sinkBatch) add(record SinkRecord, message *sarama.ConsumerMessage) {
+	if len(b.records) == 0 {
+		b.started = time.Now()
+	}
+	b.records = append(b.records, record)
+	b.bytes += len(record.Value)
+	b.last = message
+}
+
+// record converts message for the sink.
+func (c *SinkConsumer) record(message *sarama.ConsumerMessage) SinkRecord {
+	record := SinkRecord{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Timestamp: message.Timestamp,
+		Headers:   MessageHeaders(message),
+	}
+	record.Value = c.jsonValue(message)
+	record.Tombstone = message.Value == nil
+	return record
+}
+
+// flush writes batch, retrying according to the backoff, and marks its last
+// offset, or saves it as the checkpoint, once the sink has stored it. If the
+// sink or the checkpoint store keeps failing the claim ends, and the next
+// session starts again from the last written batch.
+func (c *SinkConsumer) flush(session GroupSession, batch *sinkBatch) error {
+	if len(batch.records) == 0 {
+		return nil
+	}
+
+	err := c.backoff.Retry(c.processCtx, "Writing to sink", func() error {
+		return c.sink.Write(c.processCtx, batch.records)
+	})
+	if err != nil {
+		slog.Error("Failed to write batch", "topic", c.topic, "partition", batch.partition,
+			"first_offset", batch.records[0].Offset, "records", len(batch.records), "error", err)
+		return fmt.Errorf("failed to write batch of partition %d: %w", batch.partition, err)
+	}
+
+	if err := c.checkpoint(batch); err != nil {
+		return err
+	}
+	if c.checkpoints == nil {
+		session.MarkMessage(batch.last, "")
+	}
+	slog.Info("Batch written", "topic", c.topic, "partition", batch.partition,
+		"first_offset", batch.records[0].Offset, "last_offset", batch.last.Offset, "records", len(batch.records),
+		"bytes", batch.bytes, "age", time.Since(batch.started).Round(time.Millisecond))
+
+	batch.records = nil
+	batch.bytes = 0
+	batch.last = nil
+	return nil
+}
+
+// checkpoint saves the offset after batch in the checkpoint store, if there
+// is one, retrying according to the backoff.
+func (c *SinkConsumer) checkpoint(batch *sinkBatch) error {
+	if c.checkpoints == nil {
+		return nil
+	}
+	next := batch.last.Offset + 1
+	err := c.backoff.Retry(c.processCtx, "Saving checkpoint", func() error {
+		return c.checkpoints.Save(c.processCtx, c.groupID, c.topic, batch.partition, next)
+	})
+	if err != nil {
+		slog.Error("Failed to save checkpoint", "topic", c.topic, "partition", batch.partition, "offset", next,
+			"error", err)
+		return fmt.Errorf("failed to save checkpoint of partition %d: %w", batch.partition, err)
+	}
+	slog.Debug("Checkpoint saved", "topic", c.topic, "partition", batch.partition, "offset", next)
+	return nil
+}
+
+// Close stops consuming and closes the sink and the checkpoint store.
+func (c *SinkConsumer) Close() error {
+	consumerErr := c.consumer.Close()
+	if err := c.sink.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if c.checkpoints != nil {
+		if err := c.checkpoints.Close(); err != nil && consumerErr == nil {
+			consumerErr = err
+		}
+	}
+	return consumerErr
+}
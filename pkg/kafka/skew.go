@@ -0,0 +1,304 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSkewThreshold flags a partition with half again as many messages
+// or bytes as the average partition.
+const DefaultSkewThreshold = 1.5
+
+// maxHotKeys caps the keys listed for each hot partition.
+const maxHotKeys = 3
+
+// PartitionSkew describes how unevenly the messages a PartitionTracker
+// recorded are spread over the partitions.
+type PartitionSkew struct {
+	Topic string
+	// Partitions is the number of partitions the average is taken over,
+	// Keys the number of distinct keys.
+	Partitions int
+	Keys       int
+	Messages   int
+	Bytes      int64
+	// MessageSkew and ByteSkew are the busiest partition's messages and
+	// bytes over the average; 1 is perfectly even.
+	MessageSkew float64
+	ByteSkew    float64
+	// Fairness is Jain's fairness index of the messages per partition, see
+	// Heatmap.
+	Fairness float64
+	// Load lists every partition that got messages, by partition number.
+	Load []PartitionLoad
+	// Hot lists the partitions above the threshold in messages or bytes,
+	// busiest first.
+	Hot []PartitionLoad
+}
+
+// PartitionLoad is what one partition got, and its ratio to the average.
+type PartitionLoad struct {
+	Partition    int32
+	Messages     int
+	Bytes        int64
+	MessageRatio float64
+	ByteRatio    float64
+	// TopKeys are the keys with the most messages on the partition, busiest
+	// first. Only hot partitions list them.
+	TopKeys []KeyCount
+}
+
+// KeyCount is the number of messages of one key.
+type KeyCount struct {
+	Key      string
+	Messages int
+}
+
+// Skew measures the skew of the recorded messages over partitions
+// partitions, idle ones included; 0 only counts the partitions that got
+// messages. Partitions whose messages or bytes are more than threshold
+// times the average are hot.
+func (t *PartitionTracker) Skew(partitions int, threshold float64) PartitionSkew {
+	t.mu.Lock()
+	counts := make(map[int32]int)
+	keys := make(map[int32]map[string]int)
+	for key, seen := range t.partitions {
+		for _, p := range seen {
+			counts[p]++
+			if keys[p] == nil {
+				keys[p] = make(map[string]int)
+			}
+			keys[p][key]++
+		}
+	}
+	bytes := make(map[int32]int64, len(t.bytes))
+	for p, n := range t.bytes {
+		bytes[p] = n
+	}
+	s := PartitionSkew{Topic: t.topic, Keys: len(t.partitions)}
+	t.mu.Unlock()
+
+	s.Partitions = max(partitions, len(counts))
+	if s.Partitions == 0 {
+		return s
+	}
+	var busiest int
+	var largest int64
+	for p, n := range counts {
+		s.Messages += n
+		s.Bytes += bytes[p]
+		busiest = max(busiest, n)
+		largest = max(largest, bytes[p])
+	}
+	meanMessages := float64(s.Messages) / float64(s.Partitions)
+	meanBytes := float64(s.Bytes) / float64(s.Partitions)
+	s.MessageSkew = skewRatio(float64(busiest), meanMessages)
+	s.ByteSkew = skewRatio(float64(largest), meanBytes)
+	s.Fairness = jainFairness(counts, s.Partitions)
+
+	for p, n := range counts {
+		s.Load = append(s.Load, PartitionLoad{
+			Partition:    p,
+			Messages:     n,
+			Bytes:        bytes[p],
+			MessageRatio: skewRatio(float64(n), meanMessages),
+			ByteRatio:    skewRatio(float64(bytes[p]), meanBytes),
+		})
+	}
+	sort.Slice(s.Load, func(i, j int) bool { return s.Load[i].Partition < s.Load[j].Partition })
+	if s.Partitions < 2 || threshold <= 0 {
+		return s
+	}
+
+	for _, hot := range s.Load {
+		if hot.MessageRatio <= threshold && hot.ByteRatio <= threshold {
+			continue
+		}
+		for key, n := range keys[hot.Partition] {
+			hot.TopKeys = append(hot.TopKeys, KeyCount{Key: key, Messages: n})
+		}
+		sort.Slice(hot.TopKeys, func(i, j int) bool {
+			if hot.TopKeys[i].Messages != hot.TopKeys[j].Messages {
+				return hot.TopKeys[i].Messages > hot.TopKeys[j].Messages
+			}
+			return hot.TopKeys[i].Key < hot.TopKeys[j].Key
+		})
+		hot.TopKeys = hot.TopKeys[:min(len(hot.TopKeys), maxHotKeys)]
+		s.Hot = append(s.Hot, hot)
+	}
+	sort.Slice(s.Hot, func(i, j int) bool {
+		if s.Hot[i].Messages != s.Hot[j].Messages {
+			return s.Hot[i].Messages > s.Hot[j].Messages
+		}
+		return s.Hot[i].Partition < s.Hot[j].Partition
+	})
+	return s
+}
+
+// skewRatio returns n over mean, rounded to two decimals.
+func skewRatio(n, mean float64) float64 {
+	if mean == 0 {
+		return 0
+	}
+	return math.Round(n/mean*100) / 100
+}
+
+// Suggestions explains the hot partitions and what change to the key space
+// would spread them.
+func (s PartitionSkew) Suggestions() []string {
+	if len(s.Hot) == 0 {
+		return nil
+	}
+	var notes []string
+	if s.Keys > 0 && s.Keys < s.Partitions {
+		notes = append(notes, fmt.Sprintf("Only %d keys for %d partitions, so some partitions can't get any; key by something with more values, e.g. the session_id or composite key strategy, or add users",
+			s.Keys, s.Partitions))
+	}
+	shared := false
+	for _, hot := range s.Hot {
+		top := hot.TopKeys[0]
+		switch {
+		case top.Key == "":
+		case top.Messages*2 >= hot.Messages:
+			notes = append(notes, fmt.Sprintf("Key %s carries %d%% of partition %d's messages; if they don't have to stay in order, split it, e.g. with a composite key or a suffix",
+				top.Key, top.Messages*100/hot.Messages, hot.Partition))
+		case hot.MessageRatio <= 1:
+		default:
+			shared = true
+		}
+		if hot.ByteRatio > hot.MessageRatio*1.5 {
+			notes = append(notes, fmt.Sprintf("Partition %d gets larger messages than the others, check the payloads of its keys %s",
+				hot.Partition, hotKeyNames(hot.TopKeys)))
+		}
+	}
+	if shared && s.Keys >= s.Partitions {
+		notes = append(notes, "Several busy keys hash to the same partition; more partitions, or another partitioner such as murmur2, spread them differently")
+	}
+	return notes
+}
+
+func hotKeyNames(keys []KeyCount) string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.Key
+		if k.Key == "" {
+			names[i] = nullKeyLabel
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Log logs the skew, a warning for each hot partition and the suggestions.
+func (s PartitionSkew) Log() {
+	var scope []any
+	if s.Topic != "" {
+		scope = []any{"topic", s.Topic}
+	}
+	slog.Info("Partition skew", append(scope, "partitions", s.Partitions, "keys", s.Keys, "messages", s.Messages,
+		"bytes", s.Bytes, "message_skew", s.MessageSkew, "byte_skew", s.ByteSkew, "fairness", s.Fairness,
+		"hot_partitions", len(s.Hot))...)
+	for _, hot := range s.Hot {
+		slog.Warn("Hot partition", append(scope, "partition", hot.Partition, "messages", hot.Messages, "bytes", hot.Bytes,
+			"message_ratio", hot.MessageRatio, "byte_ratio", hot.ByteRatio, "top_keys", hotKeyNames(hot.TopKeys))...)
+	}
+	for _, note := range s.Suggestions() {
+		slog.Info("Partition skew suggestion", append(scope, "note", note)...)
+	}
+}
+
+// WithSkewDetection makes consumers measure the skew of the partitions they
+// read every interval, report the ratio of each partition to Metrics and
+// log the hot ones, those with more than threshold times the average
+// messages or bytes, whenever they change and once more when they stop. A
+// group member only sees the partitions it holds.
+func WithSkewDetection(threshold float64, interval time.Duration) Option {
+	return func(o *options) error {
+		if threshold <= 1 {
+			return fmt.Errorf("skew threshold must be above 1, got %g", threshold)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("skew check interval must be positive, got %s", interval)
+		}
+		o.skewThreshold = threshold
+		o.skewInterval = interval
+		return nil
+	}
+}
+
+// skewDetector is the consumer side of WithSkewDetection. A nil detector
+// does nothing.
+type skewDetector struct {
+	threshold float64
+	interval  time.Duration
+	trackers  func() []*PartitionTracker
+	metrics   Metrics
+
+	// hot holds the hot partitions last logged per topic.
+	hot map[string]string
+}
+
+func newSkewDetector(o *options, trackers func() []*PartitionTracker) *skewDetector {
+	if o.skewThreshold == 0 {
+		return nil
+	}
+	return &skewDetector{
+		threshold: o.skewThreshold,
+		interval:  o.skewInterval,
+		trackers:  trackers,
+		metrics:   o.metrics,
+		hot:       make(map[string]string),
+	}
+}
+
+// run checks the skew every interval until ctx is done.
+func (d *skewDetector) run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check reports the ratio of every partition to the metrics and logs the
+// skew of a topic when its hot partitions changed.
+func (d *skewDetector) check() {
+	for _, t := range d.trackers() {
+		s := t.Skew(0, d.threshold)
+		for _, load := range s.Load {
+			d.metrics.PartitionSkew(s.Topic, load.Partition, load.MessageRatio, load.ByteRatio)
+		}
+		var hot []string
+		for _, h := range s.Hot {
+			hot = append(hot, fmt.Sprint(h.Partition))
+		}
+		if signature := strings.Join(hot, ","); signature != d.hot[s.Topic] {
+			d.hot[s.Topic] = signature
+			s.Log()
+		}
+	}
+}
+
+// logSummary logs the skew of every topic.
+func (d *skewDetector) logSummary() {
+	if d == nil {
+		return
+	}
+	for _, t := range d.trackers() {
+		if t.Len() > 0 {
+			t.Skew(0, d.threshold).Log()
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Event types a StreamJoiner joins.
+const (
+	eventPageView = "page_view"
+	eventPurchase = "purchase"
+)
+
+// JoinWindow describes which page views a StreamJoiner attributes a
+// purchase to: those of the same user up to Size before it. Events may
+// arrive up to Grace behind the newest event time seen before they are
+// dropped as late. A partition buffers at most MaxBuffered events; beyond
+// that the oldest are evicted before their window is over.
+type JoinWindow struct {
+	Size        time.Duration
+	Grace       time.Duration
+	MaxBuffered int
+}
+
+// DefaultJoinWindow attributes purchases to the page views of the ten
+// minutes before them and waits thirty seconds for late events.
+var DefaultJoinWindow = JoinWindow{Size: 10 * time.Minute, Grace: 30 * time.Second, MaxBuffered: 10000}
+
+// WithJoinWindow makes a StreamJoiner join within w.
+func WithJoinWindow(w JoinWindow) Option {
+	return func(o *options) error {
+		if w.Size <= 0 || w.Grace < 0 || w.MaxBuffered <= 0 {
+			return fmt.Errorf("invalid join window: size %s, grace %s, max buffered %d", w.Size, w.Grace, w.MaxBuffered)
+		}
+		o.joinWindow = w
+		return nil
+	}
+}
+
+// Attribution pairs a purchase with a page view of the same user that
+// happened within the join window before it. A purchase gets one
+// attribution per page view it joins.
+type Attribution struct {
+	UserID        string    `json:"user_id"`
+	PageView      UserEvent `json:"page_view"`
+	Purchase      UserEvent `json:"purchase"`
+	SecondsBefore float64   `json:"seconds_before"`
+}
+
+// StreamJoiner joins the page views and purchases of one topic by user and
+// publishes an Attribution for every page view that happened within the
+// window size before a purchase. Event time is the timestamp in the
+// payload, or the record timestamp for events without one, so a page view
+// that arrives after its purchase still joins it. Each partition has a
+// watermark trailing the newest event time by the grace period: events
+// behind it are dropped as late, and buffered events that can no longer
+// join anything are evicted once a second. A partition that receives
+// nothing for a window size plus the grace period moves its watermark on by
+// the wall clock.
+//
+// The join buffers only live in memory. The committed offset stays at the
+// oldest buffered event and carries the watermark and the offset read up
+// to as metadata, so after a restart or rebalance the buffers are filled
+// again from the log without publishing the attributions already
+// published. A crash between publishing and committing publishes those
+// attributions again.
+type StreamJoiner struct {
+	decoder
+	client            Client
+	admin             Admin
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	inputTopic        string
+	outputTopic       string
+	groupID           string
+	window            JoinWindow
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+}
+
+// NewStreamJoiner creates a joiner from inputTopic to outputTopic. The input
+// has to be keyed by user ID, so that the page views and purchases of a
+// user meet on one partition.
+func NewStreamJoiner(brokers []string, inputTopic, outputTopic, groupID string, opts ...Option) (*StreamJoiner, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	po, err := newOptions(producerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	consumer, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		admin.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		consumer.Close()
+		admin.Close()
+		client.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &StreamJoiner{
+		decoder:           o.decoder(),
+		client:            client,
+		admin:             admin,
+		consumer:          consumer,
+		producer:          producer,
+		inputTopic:        inputTopic,
+		outputTopic:       outputTopic,
+		groupID:           groupID,
+		window:            o.joinWindow,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+	}, nil
+}
+
+// Consume runs the joiner until ctx is cancelled. The buffers are filled
+// again by the next session.
+func (j *StreamJoiner) Consume(ctx context.Context) error {
+	defer j.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := j.consumer.Consume(ctx, []string{j.inputTopic}, j); err != nil {
+			failures++
+			if werr := j.backoff.wait(ctx, failures, "Stream joiner group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (j *StreamJoiner) Setup(session GroupSession) error {
+	rebalance := j.rebalances.Record(j.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Stream joiner setup completed", "input_topic", j.inputTopic, "output_topic", j.outputTopic, "group", j.groupID,
+		"strategy", j.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+func (j *StreamJoiner) Cleanup(session GroupSession) error {
+	rebalance := j.rebalances.Record(j.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Stream joiner cleanup completed", "input_topic", j.inputTopic, "output_topic", j.outputTopic, "group", j.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (j *StreamJoiner) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: j.rebalanceStrategy, GroupID: j.groupID, InstanceID: j.instanceID}
+}
+
+func (j *StreamJoiner) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	state, err := j.resume(claim.Partition())
+	if err != nil {
+		return err
+	}
+	defer j.logSummary(state)
+
+	ticker := time.NewTicker(windowTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				j.mark(session, state)
+				return nil
+			}
+			if err := j.add(session.Context(), state, message); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			if state.buffered > 0 && time.Since(state.lastRead) >= j.window.Size+j.window.Grace {
+				if idle := time.Now().Add(-j.window.Grace); idle.After(state.watermark) {
+					state.watermark = idle
+				}
+			}
+			j.evict(state)
+			j.mark(session, state)
+
+		case <-session.Context().Done():
+			j.mark(session, state)
+			return nil
+		}
+	}
+}
+
+// joinRecord is a buffered page view or purchase.
+type joinRecord struct {
+	event  UserEvent
+	at     time.Time
+	offset int64
+	// matched is set on purchases once they joined a page view.
+	matched bool
+}
+
+// joinBuffer holds the buffered events of one user.
+type joinBuffer struct {
+	views     []*joinRecord
+	purchases []*joinRecord
+}
+
+// joinState holds the join buffers of one partition.
+type joinState struct {
+	partition int32
+	watermark time.Time
+	users     map[string]*joinBuffer
+	buffered  int
+	next      int64
+	// resumeAt is the offset the last session had read up to: events
+	// before it only fill the buffers again.
+	resumeAt int64
+	lastRead time.Time
+
+	attributions, late, evicted, unattributed int
+}
+
+// replayMetadata is the offset metadata a StreamJoiner or Sessionizer
+// commits: its watermark and the offset it had read up to.
+type replayMetadata struct {
+	Watermark time.Time `json:"watermark"`
+	Next      int64     `json:"next"`
+}
+
+// resume picks up the watermark and the offset read up to that the last
+// session of partition committed.
+func (j *StreamJoiner) resume(partition int32) (*joinState, error) {
+	state := &joinState{partition: partition, users: make(map[string]*joinBuffer), lastRead: time.Now()}
+
+	committed, err := j.admin.ListGroupOffsets(j.groupID, map[string][]int32{j.inputTopic: {partition}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offset for partition %d: %w", partition, err)
+	}
+	block, ok := committed[j.inputTopic][partition]
+	if !ok || block.Offset < 0 || block.Metadata == "" {
+		return state, nil
+	}
+	var metadata replayMetadata
+	if err := json.Unmarshal([]byte(block.Metadata), &metadata); err != nil {
+		slog.Warn("Ignoring invalid join metadata", "topic", j.inputTopic, "partition", partition,
+			"metadata", block.Metadata, "error", err)
+		return state, nil
+	}
+	state.watermark = metadata.Watermark
+	state.resumeAt = metadata.Next
+	slog.Info("Join resumed", "topic", j.inputTopic, "partition", partition, "offset", block.Offset,
+		"watermark", metadata.Watermark, "replay_until", metadata.Next)
+	return state, nil
+}
+
+// add buffers message if it is a page view or purchase and publishes the
+// attributions it completes.
+func (j *StreamJoiner) add(ctx context.Context, state *joinState, message *sarama.ConsumerMessage) error {
+	state.next = message.Offset + 1
+	state.lastRead = time.Now()
+
+	event, err := j.DecodeEvent(message)
+	if err != nil || event.UserID == "" {
+		slog.Warn("Skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		return nil
+	}
+	if event.EventType != eventPageView && event.EventType != eventPurchase {
+		return nil
+	}
+
+	at := event.Timestamp
+	if at.IsZero() {
+		at = message.Timestamp
+	}
+
+	// Events the last session read were joined already; replaying them
+	// only rebuilds the buffers, which evict drops the stale ones from.
+	replay := message.Offset < state.resumeAt
+	if !replay && at.Before(state.watermark) {
+		state.late++
+		slog.Debug("Dropping late event", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "user_id", event.UserID, "event_type", event.EventType,
+			"event_time", at, "watermark", state.watermark)
+		return nil
+	}
+
+	buffer, ok := state.users[event.UserID]
+	if !ok {
+		buffer = &joinBuffer{}
+		state.users[event.UserID] = buffer
+	}
+	record := &joinRecord{event: event, at: at, offset: message.Offset}
+
+	var attributions []Attribution
+	if event.EventType == eventPageView {
+		buffer.views = append(buffer.views, record)
+		for _, purchase := range buffer.purchases {
+			if j.joins(record, purchase) {
+				purchase.matched = true
+				attributions = append(attributions, attribution(record, purchase))
+			}
+		}
+	} else {
+		buffer.purchases = append(buffer.purchases, record)
+		for _, view := range buffer.views {
+			if j.joins(view, record) {
+				record.matched = true
+				attributions = append(attributions, attribution(view, record))
+			}
+		}
+	}
+	state.buffered++
+
+	if watermark := at.Add(-j.window.Grace); watermark.After(state.watermark) {
+		state.watermark = watermark
+	}
+	if state.buffered > j.window.MaxBuffered {
+		j.evictOldest(state)
+	}
+
+	if replay || len(attributions) == 0 {
+		return nil
+	}
+	return j.publish(ctx, state, message, attributions)
+}
+
+// joins reports whether view happened within the window before purchase.
+func (j *StreamJoiner) joins(view, purchase *joinRecord) bool {
+	return !purchase.at.Before(view.at) && !purchase.at.After(view.at.Add(j.window.Size))
+}
+
+func attribution(view, purchase *joinRecord) Attribution {
+	return Attribution{
+		UserID:        purchase.event.UserID,
+		PageView:      view.event,
+		Purchase:      purchase.event,
+		SecondsBefore: purchase.at.Sub(view.at).Seconds(),
+	}
+}
+
+// evict drops the page views whose window the watermark has passed and the
+// purchases behind the watermark, which no page view still to come can
+// join.
+func (j *StreamJoiner) evict(state *joinState) {
+	for user, buffer := range state.users {
+		views := buffer.views[:0]
+		for _, view := range buffer.views {
+			if view.at.Add(j.window.Size).Before(state.watermark) {
+				state.buffered--
+				continue
+			}
+			views = append(views, view)
+		}
+		buffer.views = views
+
+		purchases := buffer.purchases[:0]
+		for _, purchase := range buffer.purchases {
+			if purchase.at.Before(state.watermark) {
+				state.buffered--
+				if !purchase.matched {
+					state.unattributed++
+				}
+				continue
+			}
+			purchases = append(purchases, purchase)
+		}
+		buffer.purchases = purchases
+
+		if len(buffer.views) == 0 && len(buffer.purchases) == 0 {
+			delete(state.users, user)
+		}
+	}
+}
+
+// evictOldest drops the buffered event with the oldest event time to keep
+// the buffers of state within MaxBuffered.
+func (j *StreamJoiner) evictOldest(state *joinState) {
+	var (
+		oldest     *joinRecord
+		oldestUser string
+	)
+	for user, buffer := range state.users {
+		for _, records := range [][]*joinRecord{buffer.views, buffer.purchases} {
+			for _, record := range records {
+				if oldest == nil || record.at.Before(oldest.at) {
+					oldest, oldestUser = record, user
+				}
+			}
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	buffer := state.users[oldestUser]
+	buffer.views = removeJoinRecord(buffer.views, oldest)
+	buffer.purchases = removeJoinRecord(buffer.purchases, oldest)
+	if len(buffer.views) == 0 && len(buffer.purchases) == 0 {
+		delete(state.users, oldestUser)
+	}
+	state.buffered--
+	state.evicted++
+	if oldest.event.EventType == eventPurchase && !oldest.matched {
+		state.unattributed++
+	}
+	slog.Debug("Join buffer full, evicted oldest event", "topic", j.inputTopic, "partition", state.partition,
+		"offset", oldest.offset, "user_id", oldestUser, "event_type", oldest.event.EventType, "event_time", oldest.at)
+}
+
+func removeJoinRecord(records []*joinRecord, record *joinRecord) []*joinRecord {
+	for i, r := range records {
+		if r == record {
+			return append(records[:i], records[i+1:]...)
+		}
+	}
+	return records
+}
+
+// mark marks the offset of the oldest buffered event, together with the
+// watermark and the offset read up to.
+func (j *StreamJoiner) mark(session GroupSession, state *joinState) {
+	if state.next == 0 {
+		return
+	}
+	offset := state.next
+	for _, buffer := range state.users {
+		for _, records := range [][]*joinRecord{buffer.views, buffer.purchases} {
+			for _, record := range records {
+				if record.offset < offset {
+					offset = record.offset
+				}
+			}
+		}
+	}
+
+	next := state.next
+	if state.resumeAt > next {
+		next = state.resumeAt
+	}
+	metadata, err := json.Marshal(replayMetadata{Watermark: state.watermark.UTC(), Next: next})
+	if err != nil {
+		slog.Error("Failed to encode join metadata", "partition", state.partition, "error", err)
+		return
+	}
+	session.MarkOffset(j.inputTopic, state.partition, offset, string(metadata))
+}
+
+// publish sends the attributions completed by message, keyed by user ID.
+func (j *StreamJoiner) publish(ctx context.Context, state *joinState, message *sarama.ConsumerMessage, attributions []Attribution) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(attributions))
+	for _, a := range attributions {
+		value, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic:   j.outputTopic,
+			Key:     sarama.StringEncoder(a.UserID),
+			Value:   sarama.ByteEncoder(value),
+			Headers: []sarama.RecordHeader{stringHeader(ContentTypeHeader, JSONSerializer{}.ContentType())},
+		})
+	}
+
+	err := j.backoff.Retry(ctx, "Publishing attributions", func() error {
+		batch := make([]*sarama.ProducerMessage, len(messages))
+		for i, msg := range messages {
+			batch[i] = resendable(msg)
+		}
+		return j.producer.SendMessages(batch)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish attributions of offset %d: %w", message.Offset, err)
+	}
+
+	state.attributions += len(attributions)
+	slog.Info("Purchase attributed", "topic", message.Topic, "partition", message.Partition,
+		"offset", message.Offset, "user_id", attributions[0].UserID, "attributions", len(attributions))
+	return nil
+}
+
+func (j *StreamJoiner) logSummary(state *joinState) {
+	slog.Info("Stream join summary", "topic", j.inputTopic, "partition", state.partition,
+		"attributions", state.attributions, "unattributed_purchases", state.unattributed,
+		"late", state.late, "evicted", state.evicted, "buffered", state.buffered, "watermark", state.watermark)
+}
+
+func (j *StreamJoiner) Close() error {
+	consumerErr := j.consumer.Close()
+	if err := j.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := j.admin.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := j.client.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
@@ -0,0 +1,353 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TableEntry is the latest value of one key of a Table, with the message it
+// came from.
+type TableEntry struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Table is an in-memory materialized view of a compacted topic, like a Kafka
+// Streams KTable: the latest value of every key, where a tombstone removes
+// the key. Run restores it by replaying every partition from its oldest
+// offset and then keeps it up to date. It doesn't join a consumer group or
+// commit offsets, so every instance holds the whole topic.
+type Table struct {
+	decoder
+	client   Client
+	consumer Reader
+	topic    string
+
+	mu       sync.RWMutex
+	entries  map[string]TableEntry
+	restored chan struct{}
+	// updated is closed and replaced whenever an entry is stored.
+	updated chan struct{}
+}
+
+// NewTable connects a Table of topic. Values are decoded like SinkRecord
+// values: events as JSON, other JSON as is and anything else as a string.
+func NewTable(brokers []string, topic string, opts ...Option) (*Table, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := client.NewReader()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	return &Table{
+		decoder:  o.decoder(),
+		client:   client,
+		consumer: consumer,
+		topic:    topic,
+		entries:  make(map[string]TableEntry),
+		restored: make(chan struct{}),
+		updated:  make(chan struct{}),
+	}, nil
+}
+
+// Run reads every partition from its oldest offset, marks the table
+// restored once it has caught up with the log-end offsets it found at the
+// start, and applies new messages until ctx is cancelled.
+func (t *Table) Run(ctx context.Context) error {
+	if err := t.client.RefreshMetadata(t.topic); err != nil {
+		return fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := t.client.Partitions(t.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	// The partition readers stop on cancel before Run waits for them.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messages := make(chan *sarama.ConsumerMessage)
+	restoring := make(map[int32]int64)
+	for _, partition := range partitions {
+		oldest, err := t.client.GetOffset(t.topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch oldest offset for partition %d: %w", partition, err)
+		}
+		end, err := t.client.GetOffset(t.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch log-end offset for partition %d: %w", partition, err)
+		}
+		if end > oldest {
+			restoring[partition] = end
+		}
+
+		pc, err := t.consumer.ConsumePartition(t.topic, partition, oldest)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer pc.Close()
+			for {
+				select {
+				case message, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					select {
+					case messages <- message:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	records := 0
+	var idle <-chan time.Time
+	if len(restoring) == 0 {
+		t.markRestored(records, start)
+	} else {
+		slog.Info("Restoring table", "topic", t.topic, "partitions", len(restoring))
+		idle = time.After(restoreIdle)
+	}
+
+	for {
+		select {
+		case message := <-messages:
+			t.apply(message)
+			if idle == nil {
+				continue
+			}
+			records++
+			if end, ok := restoring[message.Partition]; ok && message.Offset+1 >= end {
+				delete(restoring, message.Partition)
+			}
+			if len(restoring) == 0 {
+				t.markRestored(records, start)
+				idle = nil
+				continue
+			}
+			idle = time.After(restoreIdle)
+
+		case <-idle:
+			// Transaction markers and aborted messages take up offsets
+			// but are never delivered.
+			t.markRestored(records, start)
+			idle = nil
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// apply stores message as the latest value of its key, or removes the key
+// if it is a tombstone.
+func (t *Table) apply(message *sarama.ConsumerMessage) {
+	if message.Key == nil {
+		slog.Warn("Skipping message without a key", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset)
+		return
+	}
+	key := string(message.Key)
+
+	if message.Value == nil {
+		t.mu.Lock()
+		delete(t.entries, key)
+		t.mu.Unlock()
+		return
+	}
+
+	entry := TableEntry{
+		Key:       key,
+		Value:     t.jsonValue(message),
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+	t.mu.Lock()
+	t.entries[key] = entry
+	close(t.updated)
+	t.updated = make(chan struct{})
+	t.mu.Unlock()
+}
+
+func (t *Table) markRestored(records int, start time.Time) {
+	close(t.restored)
+	slog.Info("Table restored", "topic", t.topic, "records", records, "keys", t.Len(),
+		"took", time.Since(start).Round(time.Millisecond))
+}
+
+// Restored reports whether the table has caught up with the topic as it was
+// when Run started.
+func (t *Table) Restored() bool {
+	select {
+	case <-t.restored:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitRestored blocks until the table is restored or ctx is done.
+func (t *Table) WaitRestored(ctx context.Context) error {
+	select {
+	case <-t.restored:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get returns the latest value of key.
+func (t *Table) Get(key string) (TableEntry, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entry, ok := t.entries[key]
+	return entry, ok
+}
+
+// Await returns the latest value of key, waiting up to timeout for the key
+// to arrive if the table doesn't hold it yet.
+func (t *Table) Await(ctx context.Context, key string, timeout time.Duration) (TableEntry, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		t.mu.RLock()
+		entry, ok := t.entries[key]
+		updated := t.updated
+		t.mu.RUnlock()
+		if ok {
+			return entry, true
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return TableEntry{}, false
+		case <-ctx.Done():
+			return TableEntry{}, false
+		}
+	}
+}
+
+// Len returns the number of keys.
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.entries)
+}
+
+// Snapshot returns every entry at one point in time, sorted by key.
+func (t *Table) Snapshot() []TableEntry {
+	t.mu.RLock()
+	entries := make([]TableEntry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		entries = append(entries, entry)
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// tableSnapshot is the body of GET /state.
+type tableSnapshot struct {
+	Topic   string       `json:"topic"`
+	Keys    int          `json:"keys"`
+	Entries []TableEntry `json:"entries"`
+}
+
+// Handler serves GET /state/{key} with the entry of key, or 404 if the
+// table doesn't hold it, and GET /state with a snapshot of every entry.
+// Both answer 503 until the table is restored.
+func (t *Table) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		if !t.queryable(w, r) {
+			return
+		}
+		entries := t.Snapshot()
+		writeTableJSON(w, http.StatusOK, tableSnapshot{Topic: t.topic, Keys: len(entries), Entries: entries})
+	})
+	mux.HandleFunc("/state/", func(w http.ResponseWriter, r *http.Request) {
+		if !t.queryable(w, r) {
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/state/")
+		entry, ok := t.Get(key)
+		if !ok {
+			writeTableJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("key %q not found", key)})
+			return
+		}
+		writeTableJSON(w, http.StatusOK, entry)
+	})
+	return mux
+}
+
+// queryable answers requests that can't be served yet and reports whether
+// r may be answered.
+func (t *Table) queryable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeTableJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return false
+	}
+	if !t.Restored() {
+		writeTableJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "table is restoring"})
+		return false
+	}
+	return true
+}
+
+func writeTableJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to write state response", "error", err)
+	}
+}
+
+// Close closes the consumer and the client.
+func (t *Table) Close() error {
+	if err := t.consumer.Close(); err != nil {
+		t.client.Close()
+		return err
+	}
+	return t.client.Close()
+}
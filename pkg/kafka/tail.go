@@ -0,0 +1,202 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Tailer reads the end of a topic like tail -f: the last messages of every
+// partition, then new ones as they arrive. It doesn't join a group or
+// commit offsets.
+type Tailer struct {
+	decoder
+	client   Client
+	consumer Reader
+}
+
+// NewTailer connects a Tailer. WithDeserializers lets DecodeEvent read
+// Avro and Protobuf values.
+func NewTailer(brokers []string, opts ...Option) (*Tailer, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := client.NewReader()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	return &Tailer{decoder: o.decoder(), client: client, consumer: consumer}, nil
+}
+
+// Tail calls fn with up to last messages of every partition of topic, then
+// with every new message until ctx is cancelled. fn is never called
+// concurrently; messages of different partitions interleave. Claim checks
+// are fetched and values decrypted, see WithLargeMessages and
+// WithEncryption; those that can't be are passed on as they are, and so are
+// the chunks of chunked messages.
+func (t *Tailer) Tail(ctx context.Context, topic string, last int64, fn func(*Message)) error {
+	if err := t.client.RefreshMetadata(topic); err != nil {
+		return fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := t.client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	// The partition readers stop on cancel before Tail waits for them.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messages := make(chan *sarama.ConsumerMessage)
+
+	for _, partition := range partitions {
+		start, err := t.startOffset(topic, partition, last)
+		if err != nil {
+			return err
+		}
+		pc, err := t.consumer.ConsumePartition(topic, partition, start)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d: %w", partition, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer pc.Close()
+			for {
+				select {
+				case message, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					select {
+					case messages <- message:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case message := <-messages:
+			msg, _ := t.resolved(message)
+			fn(msg)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// ReadAll returns every message topic holds up to its current end, by
+// partition and then offset, without waiting for new ones. Offsets that
+// compaction or retention removed are simply missing. Values are resolved
+// like Tail's.
+func (t *Tailer) ReadAll(ctx context.Context, topic string) ([]*Message, error) {
+	if err := t.client.RefreshMetadata(topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := t.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	var all []*Message
+	for _, partition := range partitions {
+		messages, err := t.readPartition(ctx, topic, partition)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, messages...)
+	}
+	return all, nil
+}
+
+// readPartition reads a partition from its oldest offset to its log-end
+// offset at the time of the call.
+func (t *Tailer) readPartition(ctx context.Context, topic string, partition int32) ([]*Message, error) {
+	end, err := t.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch log-end offset for partition %d: %w", partition, err)
+	}
+	oldest, err := t.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oldest offset for partition %d: %w", partition, err)
+	}
+	if oldest >= end {
+		return nil, nil
+	}
+	pc, err := t.consumer.ConsumePartition(topic, partition, oldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume partition %d: %w", partition, err)
+	}
+	defer pc.Close()
+
+	var messages []*Message
+	for {
+		select {
+		case message, ok := <-pc.Messages():
+			if !ok || message.Offset >= end {
+				return messages, nil
+			}
+			msg, _ := t.resolved(message)
+			messages = append(messages, msg)
+			if message.Offset == end-1 {
+				return messages, nil
+			}
+		case <-time.After(replayIdle):
+			// The last offsets may hold transaction markers, which never
+			// arrive.
+			return messages, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// startOffset returns the offset last messages before the end of a
+// partition, or its oldest offset if it holds fewer.
+func (t *Tailer) startOffset(topic string, partition int32, last int64) (int64, error) {
+	newest, err := t.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch log-end offset for partition %d: %w", partition, err)
+	}
+	oldest, err := t.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch oldest offset for partition %d: %w", partition, err)
+	}
+	if newest-last > oldest {
+		return newest - last, nil
+	}
+	return oldest, nil
+}
+
+// Close closes the consumer and the client.
+func (t *Tailer) Close() error {
+	if err := t.consumer.Close(); err != nil {
+		t.client.Close()
+		return err
+	}
+	return t.client.Close()
+}
@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Values of WithRecordTimestamps.
+const (
+	// RecordTimestampSend leaves the record timestamp to the producer, which
+	// uses the time the message is sent.
+	RecordTimestampSend = "send"
+	// RecordTimestampEvent uses the event's own Timestamp, its event time.
+	RecordTimestampEvent = "event"
+)
+
+// RecordTimestampSources lists the values WithRecordTimestamps accepts.
+var RecordTimestampSources = []string{RecordTimestampSend, RecordTimestampEvent}
+
+// WithRecordTimestamps sets where the record timestamp of events comes from,
+// see RecordTimestampSources. Topics with message.timestamp.type
+// LogAppendTime replace it with the time the broker appended the record
+// either way.
+func WithRecordTimestamps(source string) Option {
+	return func(o *options) error {
+		switch source {
+		case RecordTimestampSend:
+			o.recordTimestamp = nil
+		case RecordTimestampEvent:
+			o.recordTimestamp = func(event UserEvent) time.Time { return event.Timestamp }
+		default:
+			return fmt.Errorf("unknown record timestamp source %q (want %s or %s)", source, RecordTimestampSend, RecordTimestampEvent)
+		}
+		return nil
+	}
+}
+
+// WithRecordTimestamp gives the records of every event the timestamp t,
+// e.g. to backfill a day.
+func WithRecordTimestamp(t time.Time) Option {
+	return func(o *options) error {
+		if t.IsZero() {
+			return fmt.Errorf("record timestamp must be set")
+		}
+		o.recordTimestamp = func(UserEvent) time.Time { return t }
+		return nil
+	}
+}
+
+// recordTimestamp returns the record timestamp of event. Zero leaves it to
+// sarama, which uses the send time.
+func recordTimestamp(timestamp func(UserEvent) time.Time, event UserEvent) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+	return timestamp(event)
+}
+
+// WithTimestampView makes consumers compare the three times of every
+// message: the event time in the payload, the record timestamp and the
+// produced-at header. They log them with each message, unless
+// WithoutMessageLog, and per partition when they stop, including how many
+// messages came in earlier in event time than the message before them. The
+// record timestamp is the producer's create time, or the time the broker
+// appended the record on LogAppendTime topics.
+func WithTimestampView() Option {
+	return func(o *options) error {
+		o.timestampView = true
+		return nil
+	}
+}
+
+// timestampView is the consumer side of WithTimestampView. A nil view does
+// nothing.
+type timestampView struct {
+	decoder decoder
+	quiet   bool
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*timestampStats
+}
+
+type timestampStats struct {
+	messages int
+	// fromEvent counts the records stamped with their event time.
+	fromEvent  int
+	outOfOrder int
+	lastEvent  time.Time
+	// minSkew and maxSkew bound the record timestamp minus the event time.
+	minSkew, maxSkew time.Duration
+}
+
+func newTimestampView(o *options) *timestampView {
+	if !o.timestampView {
+		return nil
+	}
+	return &timestampView{decoder: o.decoder(), quiet: o.quiet, partitions: make(map[partitionKey]*timestampStats)}
+}
+
+// observe compares the times of message and logs them.
+func (v *timestampView) observe(message *sarama.ConsumerMessage) {
+	if v == nil {
+		return
+	}
+	attrs := []any{"topic", message.Topic, "partition", message.Partition, "offset", message.Offset,
+		"key", string(message.Key), "record_time", message.Timestamp}
+	if value, ok := headerValue(message, ProducedAtHeader); ok {
+		if producedAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			attrs = append(attrs, "produced_at", producedAt, "record_minus_produced", message.Timestamp.Sub(producedAt))
+		}
+	}
+
+	event, err := v.decoder.DecodeEvent(message)
+	if err != nil || event.Timestamp.IsZero() {
+		if !v.quiet {
+			slog.Info("Message timestamps", attrs...)
+		}
+		return
+	}
+	// Record timestamps only keep milliseconds.
+	eventTime := event.Timestamp.Truncate(time.Millisecond)
+	skew := message.Timestamp.Sub(eventTime)
+	fromEvent := message.Timestamp.Equal(eventTime)
+
+	v.mu.Lock()
+	tp := partitionKey{message.Topic, message.Partition}
+	s, ok := v.partitions[tp]
+	if !ok {
+		s = &timestampStats{minSkew: skew, maxSkew: skew}
+		v.partitions[tp] = s
+	}
+	s.messages++
+	if fromEvent {
+		s.fromEvent++
+	}
+	outOfOrder := event.Timestamp.Before(s.lastEvent)
+	if outOfOrder {
+		s.outOfOrder++
+	} else {
+		s.lastEvent = event.Timestamp
+	}
+	s.minSkew = min(s.minSkew, skew)
+	s.maxSkew = max(s.maxSkew, skew)
+	v.mu.Unlock()
+
+	if !v.quiet {
+		slog.Info("Message timestamps", append(attrs, "event_time", event.Timestamp, "record_minus_event", skew,
+			"record_from_event", fromEvent, "event_out_of_order", outOfOrder)...)
+	}
+}
+
+// log logs the stats of every partition, sorted by topic and partition.
+func (v *timestampView) log() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]partitionKey, 0, len(v.partitions))
+	for tp := range v.partitions {
+		keys = append(keys, tp)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+	for _, tp := range keys {
+		s := v.partitions[tp]
+		slog.Info("Timestamp summary", "topic", tp.topic, "partition", tp.partition, "messages", s.messages,
+			"record_from_event", s.fromEvent, "event_out_of_order", s.outOfOrder,
+			"min_record_minus_event", s.minSkew, "max_record_minus_event", s.maxSkew)
+	}
+}
@@ -0,0 +1,69 @@
+package kafka
+
+import "fmt"
+
+// WithTransactionalID enables idempotence and transactions on a producer.
+// Messages sent between BeginTxn and CommitTxn become visible to
+// read_committed consumers atomically, or not at all if the transaction is aborted.
+func WithTransactionalID(transactionalID string) Option {
+	return func(o *options) error {
+		if err := WithIdempotence()(o); err != nil {
+			return err
+		}
+		o.config.Producer.Transaction.ID = transactionalID
+		return nil
+	}
+}
+
+// BeginTxn starts a transaction on a transactional producer.
+func (p *Producer) BeginTxn() error {
+	if err := p.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return nil
+}
+
+// CommitTxn commits the current transaction.
+func (p *Producer) CommitTxn() error {
+	if err := p.producer.CommitTxn(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AbortTxn aborts the current transaction, discarding every message sent in it.
+func (p *Producer) AbortTxn() error {
+	if err := p.producer.AbortTxn(); err != nil {
+		return fmt.Errorf("failed to abort transaction: %w", err)
+	}
+	return nil
+}
+
+// IsTransactional reports whether the producer was created with WithTransactionalID.
+func (p *Producer) IsTransactional() bool {
+	return p.producer.IsTransactional()
+}
+
+// InTransaction runs fn inside a transaction. The transaction is committed
+// if fn succeeds and aborted if fn or the commit fails.
+func (p *Producer) InTransaction(fn func() error) error {
+	if err := p.BeginTxn(); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return abortWith(p.producer, err)
+	}
+
+	if err := p.producer.CommitTxn(); err != nil {
+		return abortWith(p.producer, fmt.Errorf("failed to commit transaction: %w", err))
+	}
+	return nil
+}
+
+func abortWith(producer SyncProducer, cause error) error {
+	if err := producer.AbortTxn(); err != nil {
+		return fmt.Errorf("%w (abort also failed: %w)", cause, err)
+	}
+	return cause
+}
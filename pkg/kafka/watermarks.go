@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// PartitionWatermarks are the offsets that bound a partition's log at one
+// point in time: retention moves LogStart up, producers move HighWatermark
+// up once every in-sync replica has a message, and LastStable trails the
+// high watermark while a transaction is open.
+type PartitionWatermarks struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Leader    int32  `json:"leader"`
+	// LogStart is the oldest offset still retained.
+	LogStart int64 `json:"log_start"`
+	// HighWatermark is the offset after the last message read_uncommitted
+	// consumers can see.
+	HighWatermark int64 `json:"high_watermark"`
+	// LastStable is the offset after the last message read_committed
+	// consumers can see.
+	LastStable int64     `json:"last_stable"`
+	Time       time.Time `json:"time"`
+}
+
+// Retained is the number of offsets between the log start and the high
+// watermark.
+func (w PartitionWatermarks) Retained() int64 {
+	return w.HighWatermark - w.LogStart
+}
+
+// Unstable is the number of offsets read_committed consumers can't see yet
+// because a transaction covering them is still open.
+func (w PartitionWatermarks) Unstable() int64 {
+	return w.HighWatermark - w.LastStable
+}
+
+// WatermarkInspector reads the log start offset, high watermark and last
+// stable offset of every partition of a topic.
+type WatermarkInspector struct {
+	client sarama.Client
+	topic  string
+}
+
+// NewWatermarkInspector connects an inspector for topic. Only SaramaBackend
+// has one.
+func NewWatermarkInspector(brokers []string, topic string, opts ...Option) (*WatermarkInspector, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+
+	o, err := newOptions(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inspector config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := saramaOf(client)
+	if !ok {
+		client.Close()
+		return nil, unsupported("watermark inspectors are", o.backend)
+	}
+	return &WatermarkInspector{client: sc, topic: topic}, nil
+}
+
+// Watermarks returns the current watermarks of every partition, sorted by
+// partition. Each partition leader is asked once per kind of offset, so all
+// partitions of a broker are read at nearly the same moment.
+func (i *WatermarkInspector) Watermarks() ([]PartitionWatermarks, error) {
+	if err := i.client.RefreshMetadata(i.topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	partitions, err := i.client.Partitions(i.topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", i.topic, err)
+	}
+
+	byLeader := make(map[*sarama.Broker][]int32)
+	for _, partition := range partitions {
+		leader, err := i.client.Leader(i.topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the leader of partition %d: %w", partition, err)
+		}
+		byLeader[leader] = append(byLeader[leader], partition)
+	}
+
+	watermarks := make([]PartitionWatermarks, 0, len(partitions))
+	for leader, partitions := range byLeader {
+		logStart, err := i.offsets(leader, partitions, sarama.OffsetOldest, sarama.ReadUncommitted)
+		if err != nil {
+			return nil, err
+		}
+		highWatermark, err := i.offsets(leader, partitions, sarama.OffsetNewest, sarama.ReadUncommitted)
+		if err != nil {
+			return nil, err
+		}
+		lastStable, err := i.offsets(leader, partitions, sarama.OffsetNewest, sarama.ReadCommitted)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		for _, partition := range partitions {
+			watermarks = append(watermarks, PartitionWatermarks{
+				Topic:         i.topic,
+				Partition:     partition,
+				Leader:        leader.ID(),
+				LogStart:      logStart[partition],
+				HighWatermark: highWatermark[partition],
+				LastStable:    lastStable[partition],
+				Time:          now,
+			})
+		}
+	}
+
+	sort.Slice(watermarks, func(a, b int) bool { return watermarks[a].Partition < watermarks[b].Partition })
+	return watermarks, nil
+}
+
+// offsets asks leader for the oldest or newest offset of partitions. For the
+// newest offset, read_uncommitted returns the high watermark and
+// read_committed the last stable offset.
+func (i *WatermarkInspector) offsets(leader *sarama.Broker, partitions []int32, position int64, isolation sarama.IsolationLevel) (map[int32]int64, error) {
+	request := &sarama.OffsetRequest{Version: 2, IsolationLevel: isolation}
+	for _, partition := range partitions {
+		request.AddBlock(i.topic, partition, position, 1)
+	}
+
+	response, err := leader.GetAvailableOffsets(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets from broker %d: %w", leader.ID(), err)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		block := response.GetBlock(i.topic, partition)
+		if block == nil {
+			return nil, fmt.Errorf("broker %d returned no offset for partition %d", leader.ID(), partition)
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to fetch offset of partition %d: %w", partition, block.Err)
+		}
+		offsets[partition] = block.Offset
+	}
+	return offsets, nil
+}
+
+// Close closes the underlying client.
+func (i *WatermarkInspector) Close() error {
+	return i.client.Close()
+}
@@ -0,0 +1,424 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// windowTick is how often a Windower checks for idle partitions.
+const windowTick = time.Second
+
+// Windows describes the event-time windows a Windower counts in. Windows of
+// Size start every Slide, aligned to the wall clock: a Slide equal to Size
+// gives tumbling windows, a shorter one sliding windows that overlap, so
+// that each event counts in Size/Slide of them. Events may arrive up to
+// Lateness behind the newest event time seen before their windows close.
+type Windows struct {
+	Size     time.Duration
+	Slide    time.Duration
+	Lateness time.Duration
+}
+
+// DefaultWindows are tumbling one-minute windows that wait ten seconds for
+// late events.
+var DefaultWindows = Windows{Size: time.Minute, Slide: time.Minute, Lateness: 10 * time.Second}
+
+// WithWindows makes a Windower count in w. A zero Slide means tumbling
+// windows.
+func WithWindows(w Windows) Option {
+	return func(o *options) error {
+		if w.Slide == 0 {
+			w.Slide = w.Size
+		}
+		if w.Size <= 0 || w.Slide < 0 || w.Slide > w.Size || w.Lateness < 0 {
+			return fmt.Errorf("invalid windows: size %s, slide %s, lateness %s", w.Size, w.Slide, w.Lateness)
+		}
+		o.windows = w
+		return nil
+	}
+}
+
+// Tumbling reports whether the windows don't overlap.
+func (w Windows) Tumbling() bool {
+	return w.Slide == w.Size
+}
+
+// starts returns the starts of the windows containing t, newest first.
+func (w Windows) starts(t time.Time) []time.Time {
+	var starts []time.Time
+	for start := t.Truncate(w.Slide); start.Add(w.Size).After(t); start = start.Add(-w.Slide) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// WindowResult is the number of events of one user in one window. Results
+// are published keyed by user ID once the window closes.
+type WindowResult struct {
+	UserID      string    `json:"user_id"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Events      int64     `json:"events"`
+}
+
+// Windower counts events per user in event-time windows and publishes the
+// counts of each window to the output topic when it closes. Event time is
+// the timestamp in the payload, or the record timestamp for events without
+// one. Each partition has a watermark trailing the newest event time by the
+// allowed lateness; a window closes when the watermark passes its end, and
+// events whose windows have all closed are dropped as late. A partition that
+// receives nothing for a window size plus the lateness moves its watermark
+// on by the wall clock, so that the last windows close once producers stop.
+//
+// Open windows only live in memory. The committed offset stays at the first
+// event of the oldest open window and carries the watermark as metadata, so
+// after a restart or rebalance the open windows are counted again from the
+// log while events of windows already published are skipped. A crash
+// between publishing and committing publishes those windows again, with the
+// same counts.
+type Windower struct {
+	decoder
+	client            Client
+	admin             Admin
+	consumer          ConsumerGroup
+	producer          SyncProducer
+	inputTopic        string
+	outputTopic       string
+	groupID           string
+	windows           Windows
+	rebalances        *RebalanceHistory
+	rebalanceStrategy string
+	instanceID        string
+	backoff           Backoff
+}
+
+// NewWindower creates a windower from inputTopic to outputTopic. The input
+// has to be keyed by user ID, so that each user's events, and the watermark
+// they advance, stay on one partition.
+func NewWindower(brokers []string, inputTopic, outputTopic, groupID string, opts ...Option) (*Windower, error) {
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = sarama.V2_5_0_0
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	o, err := newOptions(consumerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %w", err)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 5
+	po, err := newOptions(producerConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	client, err := o.connect(brokers)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := client.NewAdmin()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	consumer, err := client.NewConsumerGroup(groupID)
+	if err != nil {
+		admin.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := po.newSyncProducer(brokers)
+	if err != nil {
+		consumer.Close()
+		admin.Close()
+		client.Close()
+		return nil, err
+	}
+
+	rebalances := o.rebalances
+	if rebalances == nil {
+		rebalances = NewRebalanceHistory()
+	}
+
+	return &Windower{
+		decoder:           o.decoder(),
+		client:            client,
+		admin:             admin,
+		consumer:          consumer,
+		producer:          producer,
+		inputTopic:        inputTopic,
+		outputTopic:       outputTopic,
+		groupID:           groupID,
+		windows:           o.windows,
+		rebalances:        rebalances,
+		rebalanceStrategy: o.rebalanceStrategy,
+		instanceID:        consumerConfig.Consumer.Group.InstanceId,
+		backoff:           o.backoff,
+	}, nil
+}
+
+// Consume runs the windower until ctx is cancelled. Windows still open then
+// are counted again by the next session.
+func (w *Windower) Consume(ctx context.Context) error {
+	defer w.rebalances.LogSummary()
+
+	failures := 0
+	for {
+		if err := w.consumer.Consume(ctx, []string{w.inputTopic}, w); err != nil {
+			failures++
+			if werr := w.backoff.wait(ctx, failures, "Windower group session", err); werr != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("error from consumer: %w", err)
+			}
+			continue
+		}
+		failures = 0
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (w *Windower) Setup(session GroupSession) error {
+	rebalance := w.rebalances.Record(w.rebalanceEvent(RebalanceSetup), session)
+	slog.Info("Windower setup completed", "input_topic", w.inputTopic, "output_topic", w.outputTopic, "group", w.groupID,
+		"strategy", w.rebalanceStrategy, "generation", rebalance.GenerationID, "member_id", rebalance.MemberID,
+		"claims", rebalance.Claims, "assigned", rebalance.Assigned, "revoked", rebalance.Revoked)
+	return nil
+}
+
+func (w *Windower) Cleanup(session GroupSession) error {
+	rebalance := w.rebalances.Record(w.rebalanceEvent(RebalanceCleanup), session)
+	slog.Info("Windower cleanup completed", "input_topic", w.inputTopic, "output_topic", w.outputTopic, "group", w.groupID,
+		"generation", rebalance.GenerationID, "member_id", rebalance.MemberID, "released", countPartitions(rebalance.Claims))
+	return nil
+}
+
+func (w *Windower) rebalanceEvent(phase string) RebalanceEvent {
+	return RebalanceEvent{Phase: phase, Strategy: w.rebalanceStrategy, GroupID: w.groupID, InstanceID: w.instanceID}
+}
+
+func (w *Windower) ConsumeClaim(session GroupSession, claim GroupClaim) error {
+	state, err := w.resume(claim.Partition())
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(windowTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return nil
+			}
+			w.add(state, message)
+			if err := w.close(session, state); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			if len(state.open) == 0 || time.Since(state.lastRead) < w.windows.Size+w.windows.Lateness {
+				continue
+			}
+			if idle := time.Now().Add(-w.windows.Lateness); idle.After(state.watermark) {
+				state.watermark = idle
+			}
+			if err := w.close(session, state); err != nil {
+				return err
+			}
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// openWindow counts the events of one window that hasn't closed yet.
+type openWindow struct {
+	start       time.Time
+	counts      map[string]int64
+	firstOffset int64
+}
+
+// windowState holds the open windows of one partition.
+type windowState struct {
+	partition int32
+	watermark time.Time
+	open      map[int64]*openWindow
+	next      int64
+	late      int
+	lastRead  time.Time
+}
+
+// resume picks up the watermark the last session of partition committed.
+func (w *Windower) resume(partition int32) (*windowState, error) {
+	state := &windowState{partition: partition, open: make(map[int64]*openWindow), lastRead: time.Now()}
+
+	committed, err := w.admin.ListGroupOffsets(w.groupID, map[string][]int32{w.inputTopic: {partition}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offset for partition %d: %w", partition, err)
+	}
+	block, ok := committed[w.inputTopic][partition]
+	if !ok || block.Offset < 0 || block.Metadata == "" {
+		return state, nil
+	}
+	watermark, err := time.Parse(time.RFC3339Nano, block.Metadata)
+	if err != nil {
+		slog.Warn("Ignoring invalid watermark", "topic", w.inputTopic, "partition", partition,
+			"metadata", block.Metadata, "error", err)
+		return state, nil
+	}
+	state.watermark = watermark
+	slog.Info("Windows resumed", "topic", w.inputTopic, "partition", partition, "offset", block.Offset,
+		"watermark", watermark)
+	return state, nil
+}
+
+// add counts message in the open windows it falls into.
+func (w *Windower) add(state *windowState, message *sarama.ConsumerMessage) {
+	state.next = message.Offset + 1
+	state.lastRead = time.Now()
+
+	event, err := w.DecodeEvent(message)
+	if err != nil || event.UserID == "" {
+		slog.Warn("Skipping message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		return
+	}
+
+	at := event.Timestamp
+	if at.IsZero() {
+		at = message.Timestamp
+	}
+
+	starts := w.windows.starts(at)
+	if !starts[0].Add(w.windows.Size).After(state.watermark) {
+		state.late++
+		slog.Debug("Dropping late event", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "user_id", event.UserID, "event_time", at, "watermark", state.watermark)
+		return
+	}
+
+	for _, start := range starts {
+		// Of overlapping windows, the older ones may have closed already.
+		if !start.Add(w.windows.Size).After(state.watermark) {
+			break
+		}
+		window, ok := state.open[start.UnixNano()]
+		if !ok {
+			window = &openWindow{start: start, counts: make(map[string]int64), firstOffset: message.Offset}
+			state.open[start.UnixNano()] = window
+		}
+		window.counts[event.UserID]++
+	}
+
+	if watermark := at.Add(-w.windows.Lateness); watermark.After(state.watermark) {
+		state.watermark = watermark
+	}
+}
+
+// close publishes the windows the watermark has passed and marks the offset
+// of the oldest event still needed, together with the watermark.
+func (w *Windower) close(session GroupSession, state *windowState) error {
+	var closed []*openWindow
+	for _, window := range state.open {
+		if !window.start.Add(w.windows.Size).After(state.watermark) {
+			closed = append(closed, window)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].start.Before(closed[j].start) })
+
+	for _, window := range closed {
+		if err := w.publish(session.Context(), state, window); err != nil {
+			return err
+		}
+		delete(state.open, window.start.UnixNano())
+	}
+
+	offset := state.next
+	for _, window := range state.open {
+		if window.firstOffset < offset {
+			offset = window.firstOffset
+		}
+	}
+	session.MarkOffset(w.inputTopic, state.partition, offset, state.watermark.UTC().Format(time.RFC3339Nano))
+	return nil
+}
+
+// publish sends the counts of window, one result per user.
+func (w *Windower) publish(ctx context.Context, state *windowState, window *openWindow) error {
+	end := window.start.Add(w.windows.Size)
+
+	users := make([]string, 0, len(window.counts))
+	for user := range window.counts {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var events int64
+	messages := make([]*sarama.ProducerMessage, 0, len(users))
+	for _, user := range users {
+		result := WindowResult{UserID: user, WindowStart: window.start.UTC(), WindowEnd: end.UTC(), Events: window.counts[user]}
+		value, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic:   w.outputTopic,
+			Key:     sarama.StringEncoder(user),
+			Value:   sarama.ByteEncoder(value),
+			Headers: []sarama.RecordHeader{stringHeader(ContentTypeHeader, JSONSerializer{}.ContentType())},
+		})
+		events += result.Events
+		slog.Debug("Window result", "user_id", user, "window_start", result.WindowStart, "events", result.Events)
+	}
+
+	err := w.backoff.Retry(ctx, "Publishing window results", func() error {
+		batch := make([]*sarama.ProducerMessage, len(messages))
+		for i, msg := range messages {
+			batch[i] = resendable(msg)
+		}
+		return w.producer.SendMessages(batch)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish window %s: %w", window.start.UTC().Format(time.RFC3339), err)
+	}
+
+	slog.Info("Window closed", "topic", w.inputTopic, "partition", state.partition,
+		"window_start", window.start.UTC(), "window_end", end.UTC(), "users", len(users), "events", events,
+		"late", state.late)
+	state.late = 0
+	return nil
+}
+
+func (w *Windower) Close() error {
+	consumerErr := w.consumer.Close()
+	if err := w.producer.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := w.admin.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	if err := w.client.Close(); err != nil && consumerErr == nil {
+		consumerErr = err
+	}
+	return consumerErr
+}
@@ -0,0 +1,340 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// API keys of the requests sarama doesn't send, or doesn't send the way the
+// admin commands need.
+const (
+	apiKeySaslHandshake              int16 = 17
+	apiKeySaslAuthenticate           int16 = 36
+	apiKeyElectLeaders               int16 = 43
+	apiKeyAlterPartitionReassignment int16 = 45
+)
+
+// errWireShort is returned when a response ends before all of its fields.
+var errWireShort = errors.New("kafka response truncated")
+
+// wireConn is a bare connection to one broker for requests sarama v1.38
+// lacks. It speaks just enough of the protocol for them: framing, request
+// headers v1 and v2, TLS and SASL PLAIN and SCRAM as configured in the
+// sarama config.
+type wireConn struct {
+	conn          net.Conn
+	clientID      string
+	timeout       time.Duration
+	correlationID int32
+}
+
+// dialWire connects to addr with the network, proxy, TLS and SASL settings
+// of config.
+func dialWire(addr string, config *sarama.Config) (*wireConn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if config.Net.Proxy.Enable {
+		conn, err = config.Net.Proxy.Dialer.Dial("tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{Timeout: config.Net.DialTimeout, KeepAlive: config.Net.KeepAlive}).Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if config.Net.TLS.Enable {
+		tlsConfig := &tls.Config{}
+		if config.Net.TLS.Config != nil {
+			tlsConfig = config.Net.TLS.Config.Clone()
+		}
+		if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c := &wireConn{conn: conn, clientID: config.ClientID, timeout: config.Net.ReadTimeout}
+	if config.Net.SASL.Enable {
+		if err := c.authenticate(config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate with %s: %w", addr, err)
+		}
+	}
+	return c, nil
+}
+
+// authenticate runs the SASL handshake and exchange sarama would run.
+func (c *wireConn) authenticate(config *sarama.Config) error {
+	sasl := config.Net.SASL
+	var w wireWriter
+	w.string(string(sasl.Mechanism))
+	response, err := c.roundTrip(apiKeySaslHandshake, 1, false, w.Bytes())
+	if err != nil {
+		return err
+	}
+	r := wireReader{b: response}
+	if kerr := sarama.KError(r.int16()); r.err == nil && kerr != sarama.ErrNoError {
+		return fmt.Errorf("SASL handshake for %s failed: %w", sasl.Mechanism, kerr)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	switch sasl.Mechanism {
+	case sarama.SASLTypePlaintext:
+		_, err := c.saslAuthenticate([]byte(sasl.AuthIdentity + "\x00" + sasl.User + "\x00" + sasl.Password))
+		return err
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		client := sasl.SCRAMClientGeneratorFunc()
+		if err := client.Begin(sasl.User, sasl.Password, sasl.SCRAMAuthzID); err != nil {
+			return fmt.Errorf("failed to start SCRAM exchange: %w", err)
+		}
+		message, err := client.Step("")
+		for err == nil && !client.Done() {
+			var challenge []byte
+			if challenge, err = c.saslAuthenticate([]byte(message)); err == nil {
+				message, err = client.Step(string(challenge))
+			}
+		}
+		return err
+	default:
+		return fmt.Errorf("unsupported SASL mechanism: %s", sasl.Mechanism)
+	}
+}
+
+func (c *wireConn) saslAuthenticate(authBytes []byte) ([]byte, error) {
+	var w wireWriter
+	w.bytes(authBytes)
+	response, err := c.roundTrip(apiKeySaslAuthenticate, 0, false, w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	r := wireReader{b: response}
+	kerr := sarama.KError(r.int16())
+	message := r.nullableString()
+	challenge := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if kerr != sarama.ErrNoError {
+		return nil, wireError(kerr, message)
+	}
+	return challenge, nil
+}
+
+// roundTrip sends one request and returns the response body after its
+// header. flexible selects request header v2 and response header v1, which
+// the flexible versions of a request use.
+func (c *wireConn) roundTrip(apiKey, version int16, flexible bool, body []byte) ([]byte, error) {
+	c.correlationID++
+
+	var w wireWriter
+	w.int32(0) // size, filled in below
+	w.int16(apiKey)
+	w.int16(version)
+	w.int32(c.correlationID)
+	w.string(c.clientID)
+	if flexible {
+		w.tags()
+	}
+	w.Write(body)
+	request := w.Bytes()
+	binary.BigEndian.PutUint32(request, uint32(len(request)-4))
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	response := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	r := wireReader{b: response}
+	if id := r.int32(); r.err == nil && id != c.correlationID {
+		return nil, fmt.Errorf("response has correlation ID %d, expected %d", id, c.correlationID)
+	}
+	if flexible {
+		r.skipTags()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.b, nil
+}
+
+func (c *wireConn) Close() error {
+	return c.conn.Close()
+}
+
+// withController runs fn on a fresh connection to the cluster's controller,
+// which ElectLeaders and AlterPartitionReassignments have to be sent to.
+func withController(ctx context.Context, client sarama.Client, fn func(*wireConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	controller, err := client.Controller()
+	if err != nil {
+		return fmt.Errorf("failed to find the controller: %w", err)
+	}
+	conn, err := dialWire(controller.Addr(), client.Config())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// A cancelled ctx interrupts a request that is waiting for the
+	// controller.
+	stop := context.AfterFunc(ctx, func() { conn.conn.SetDeadline(time.Now()) })
+	defer stop()
+	return fn(conn)
+}
+
+// wireError combines an error code with the message the broker sent with
+// it.
+func wireError(kerr sarama.KError, message string) error {
+	if message == "" {
+		return kerr
+	}
+	return fmt.Errorf("%w: %s", kerr, message)
+}
+
+// wireWriter encodes request fields.
+type wireWriter struct {
+	bytes.Buffer
+}
+
+func (w *wireWriter) int8(v int8) { w.WriteByte(byte(v)) }
+
+func (w *wireWriter) int16(v int16) { w.Write(binary.BigEndian.AppendUint16(nil, uint16(v))) }
+
+func (w *wireWriter) int32(v int32) { w.Write(binary.BigEndian.AppendUint32(nil, uint32(v))) }
+
+func (w *wireWriter) uvarint(v uint64) { w.Write(binary.AppendUvarint(nil, v)) }
+
+func (w *wireWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *wireWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.Write(b)
+}
+
+func (w *wireWriter) compactString(s string) {
+	w.uvarint(uint64(len(s)) + 1)
+	w.WriteString(s)
+}
+
+// compactLength writes the length of a compact array, -1 for null.
+func (w *wireWriter) compactLength(n int) { w.uvarint(uint64(n + 1)) }
+
+// tags writes an empty tagged field section.
+func (w *wireWriter) tags() { w.uvarint(0) }
+
+// wireReader decodes response fields. The first error sticks and makes
+// every later read return zero values.
+type wireReader struct {
+	b   []byte
+	err error
+}
+
+func (r *wireReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = errWireShort
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *wireReader) int16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *wireReader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *wireReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errWireShort
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *wireReader) string() string { return string(r.take(int(r.int16()))) }
+
+// nullableString reads a string that may be null, which reads as "".
+func (r *wireReader) nullableString() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+func (r *wireReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return r.take(int(n))
+}
+
+// arrayLength reads the length of an array, 0 for null.
+func (r *wireReader) arrayLength() int { return max(int(r.int32()), 0) }
+
+// compactString reads a compact string that may be null, which reads as "".
+func (r *wireReader) compactString() string {
+	n := int(r.uvarint())
+	if n == 0 {
+		return ""
+	}
+	return string(r.take(n - 1))
+}
+
+// compactLength reads the length of a compact array, 0 for null.
+func (r *wireReader) compactLength() int { return max(int(r.uvarint())-1, 0) }
+
+// skipTags skips a tagged field section.
+func (r *wireReader) skipTags() {
+	for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+		r.uvarint()
+		r.take(int(r.uvarint()))
+	}
+}
@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// workerQueueSize is how many messages each worker can have queued before
+// dispatching blocks.
+const workerQueueSize = 16
+
+// WithConcurrency processes each partition's messages on n workers instead
+// of one at a time. Messages with the same key always go to the same worker,
+// so per-key order is preserved, and an offset is only marked once every
+// earlier offset of the partition has been processed.
+func WithConcurrency(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			n = 1
+		}
+		o.concurrency = n
+		return nil
+	}
+}
+
+// workerPool processes the messages of one claim concurrently.
+type workerPool struct {
+	queues  []chan *sarama.ConsumerMessage
+	wg      sync.WaitGroup
+	offsets *offsetWatermark
+
+	failOnce sync.Once
+	failed   chan struct{}
+	err      error
+}
+
+func newWorkerPool(n int, process func(*sarama.ConsumerMessage) error, mark func(*sarama.ConsumerMessage)) *workerPool {
+	p := &workerPool{
+		queues:  make([]chan *sarama.ConsumerMessage, n),
+		offsets: newOffsetWatermark(mark),
+		failed:  make(chan struct{}),
+	}
+
+	for i := range p.queues {
+		queue := make(chan *sarama.ConsumerMessage, workerQueueSize)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for message := range queue {
+				// After a failure the claim is ending; anything processed
+				// now could not be marked and would run again on redelivery.
+				select {
+				case <-p.failed:
+					continue
+				default:
+				}
+
+				if err := process(message); err != nil {
+					p.fail(err)
+					continue
+				}
+				p.offsets.complete(message.Offset)
+			}
+		}()
+	}
+
+	return p
+}
+
+// dispatch queues message on the worker owning its key. It returns false
+// once a message has failed, after which nothing more should be dispatched.
+func (p *workerPool) dispatch(message *sarama.ConsumerMessage) bool {
+	if !p.offsets.add(message) {
+		return true
+	}
+
+	queue := p.queues[int(toPositive(murmur2(message.Key)))%len(p.queues)]
+	select {
+	case queue <- message:
+		return true
+	case <-p.failed:
+		return false
+	}
+}
+
+// skip counts message as processed without handling it. Its offset is
+// marked once every message dispatched before it has been processed.
+func (p *workerPool) skip(message *sarama.ConsumerMessage) {
+	if p.offsets.add(message) {
+		p.offsets.complete(message.Offset)
+	}
+}
+
+func (p *workerPool) fail(err error) {
+	p.failOnce.Do(func() {
+		p.err = err
+		close(p.failed)
+	})
+}
+
+// close waits for the queued messages to be processed and returns the first
+// processing error, if any. Offsets still waiting for an earlier one, such
+// as those behind a failed message, are left unmarked for redelivery.
+func (p *workerPool) close() error {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+	p.offsets.release()
+	return p.err
+}
@@ -0,0 +1,43 @@
+package testkafka_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+	"kafka-hwsw/pkg/testkafka"
+)
+
+// The franz-go backend partitions keys like sarama and commits where the
+// group resumes, so a group can switch backends between deployments.
+func TestFranzBackendSharesGroupWithSarama(t *testing.T) {
+	k := testkafka.Start(t)
+	k.CreateTopic(t, "orders", 3)
+	franz := kafka.WithBackend(kafka.FranzBackend{})
+
+	producer := k.NewProducer(t, "orders", franz)
+	var first []testkafka.Record
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("user-%d", i%4)
+		viaFranz := testkafka.SendMessage(t, producer, key, strconv.Itoa(2*i))
+		viaSarama := k.Produce(t, "orders", key, strconv.Itoa(2*i+1))
+		if viaFranz.Partition != viaSarama.Partition {
+			t.Fatalf("%s went to partition %d with franz-go and %d with sarama", key, viaFranz.Partition, viaSarama.Partition)
+		}
+		first = append(first, viaFranz, viaSarama)
+	}
+
+	got := k.Consume(t, "orders", "franz-test", len(first), 30*time.Second, franz,
+		kafka.WithCommitStrategy(kafka.CommitStrategy{Mode: kafka.CommitBatch, Every: 1}))
+	testkafka.AssertDelivered(t, first, got)
+	testkafka.AssertKeyOrder(t, first, got)
+
+	var second []testkafka.Record
+	for i := 24; i < 30; i++ {
+		second = append(second, k.Produce(t, "orders", fmt.Sprintf("user-%d", i%4), strconv.Itoa(i)))
+	}
+	got = k.Resume(t, "orders", "franz-test", len(second), 30*time.Second)
+	testkafka.AssertDelivered(t, second, got)
+}
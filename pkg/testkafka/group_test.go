@@ -0,0 +1,89 @@
+package testkafka_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+	"kafka-hwsw/pkg/testkafka"
+)
+
+func TestGroupResumesFromCommittedOffsets(t *testing.T) {
+	k := testkafka.Start(t)
+	k.CreateTopic(t, "orders", 3)
+
+	var first []testkafka.Record
+	for i := 0; i < 12; i++ {
+		first = append(first, k.Produce(t, "orders", fmt.Sprintf("user-%d", i%4), strconv.Itoa(i)))
+	}
+	got := k.Consume(t, "orders", "resume-test", len(first), 30*time.Second,
+		kafka.WithCommitStrategy(kafka.CommitStrategy{Mode: kafka.CommitBatch, Every: 1}))
+	testkafka.AssertDelivered(t, first, got)
+
+	// The next member of the group picks up after the committed offsets and
+	// never sees the first messages again.
+	var second []testkafka.Record
+	for i := 12; i < 18; i++ {
+		second = append(second, k.Produce(t, "orders", fmt.Sprintf("user-%d", i%4), strconv.Itoa(i)))
+	}
+	got = k.Resume(t, "orders", "resume-test", len(second), 30*time.Second)
+	testkafka.AssertDelivered(t, second, got)
+}
+
+func TestRedeliveryAfterRebalance(t *testing.T) {
+	k := testkafka.Start(t)
+	k.CreateTopic(t, "payments", 1)
+
+	var sent []testkafka.Record
+	for i := 0; i < 5; i++ {
+		sent = append(sent, k.Produce(t, "payments", "user-1", strconv.Itoa(i)))
+	}
+
+	// The first member handles offsets 0 and 1 and is still busy with 2
+	// when it leaves the group, so 2 was never committed.
+	busy := make(chan struct{})
+	stuck := kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		if message.Offset < 2 {
+			return nil
+		}
+		if message.Offset == 2 {
+			close(busy)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	first, err := kafka.NewConsumer(k.Brokers(), "payments", "rebalance-test", kafka.WithHandler(stuck),
+		kafka.WithMaxRetries(0), kafka.WithShutdownTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	defer first.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- first.Consume(ctx) }()
+
+	select {
+	case <-busy:
+	case err := <-stopped:
+		t.Fatalf("first member stopped: %v", err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("first member didn't get offset 2 within 30s")
+	}
+
+	// The second member joining and the first one leaving hand the partition
+	// over, and offset 2 is delivered again.
+	go func() {
+		time.Sleep(time.Second)
+		cancel()
+	}()
+	got := k.Resume(t, "payments", "rebalance-test", 3, 60*time.Second)
+	<-stopped
+	if got[0].Offset != 2 {
+		t.Errorf("second member started at offset %d, want the uncommitted offset 2", got[0].Offset)
+	}
+	testkafka.AssertDelivered(t, sent[2:], got)
+}
@@ -0,0 +1,384 @@
+// Package testkafka runs a throwaway single-broker Kafka with
+// testcontainers-go for end-to-end tests of code built on pkg/kafka, with
+// helpers to produce, consume and check where messages landed:
+//
+//	func TestOrders(t *testing.T) {
+//		k := testkafka.Start(t)
+//		k.CreateTopic(t, "orders", 3)
+//		sent := k.Produce(t, "orders", "user-1", "created")
+//		got := k.Consume(t, "orders", "orders-test", 1, 30*time.Second)
+//		testkafka.AssertDelivered(t, []testkafka.Record{sent}, got)
+//	}
+//
+// Tests are skipped when there is no Docker daemon, so the suite still
+// passes on machines without one.
+package testkafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/testcontainers/testcontainers-go"
+	tckafka "github.com/testcontainers/testcontainers-go/modules/kafka"
+
+	"kafka-hwsw/pkg/kafka"
+)
+
+// StartTimeout is how long Start waits for the broker to answer, including
+// pulling the image on first use.
+var StartTimeout = 3 * time.Minute
+
+// DefaultImage is the broker image Start runs unless TESTKAFKA_IMAGE names
+// another. The testcontainers Kafka module runs KRaft images of the
+// confluent-local family.
+const DefaultImage = "confluentinc/confluent-local:7.6.1"
+
+// Kafka is a running broker. Its container is removed when the test that
+// started it finishes.
+type Kafka struct {
+	container *tckafka.KafkaContainer
+	brokers   []string
+	admin     sarama.ClusterAdmin
+
+	mu        sync.Mutex
+	producers map[string]*kafka.Producer
+}
+
+// Record is a produced message and where it was written.
+type Record struct {
+	Key       string
+	Value     string
+	Partition int32
+	Offset    int64
+}
+
+// Start runs a broker for t in a container of testcontainers-go, on a port
+// Docker picks. TESTKAFKA_IMAGE overrides DefaultImage. t is skipped if
+// Docker isn't available.
+func Start(t testing.TB) *Kafka {
+	t.Helper()
+	skipWithoutDocker(t)
+
+	image := os.Getenv("TESTKAFKA_IMAGE")
+	if image == "" {
+		image = DefaultImage
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), StartTimeout)
+	defer cancel()
+
+	container, err := tckafka.Run(ctx, image,
+		testcontainers.WithLogger(testcontainers.TestLogger(t)),
+		tckafka.WithClusterID("testkafka-"+randomSuffix()),
+	)
+	k := &Kafka{container: container, producers: make(map[string]*kafka.Producer)}
+	t.Cleanup(func() {
+		k.mu.Lock()
+		for _, producer := range k.producers {
+			producer.Close()
+		}
+		k.mu.Unlock()
+		if k.admin != nil {
+			k.admin.Close()
+		}
+		if k.container == nil {
+			return
+		}
+		if err := k.container.Terminate(context.Background()); err != nil {
+			t.Logf("testkafka: failed to remove broker: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("testkafka: failed to start broker: %v", err)
+	}
+
+	if k.brokers, err = container.Brokers(ctx); err != nil {
+		t.Fatalf("testkafka: failed to get the broker address: %v", err)
+	}
+	if k.admin, err = waitReady(ctx, k.brokers); err != nil {
+		t.Fatalf("testkafka: broker didn't become ready within %s: %v", StartTimeout, err)
+	}
+	return k
+}
+
+// skipWithoutDocker skips t unless a Docker daemon answers.
+func skipWithoutDocker(t testing.TB) {
+	t.Helper()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err == nil {
+		defer provider.Close()
+		err = provider.Health(context.Background())
+	}
+	if err != nil {
+		t.Skipf("testkafka: Docker not available: %v", err)
+	}
+}
+
+// waitReady connects a cluster admin to brokers once the broker answers
+// metadata requests, retrying until ctx is done.
+func waitReady(ctx context.Context, brokers []string) (sarama.ClusterAdmin, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	config.Metadata.Retry.Max = 0
+	config.Net.DialTimeout = 2 * time.Second
+	config.Net.ReadTimeout = 5 * time.Second
+	for {
+		admin, err := sarama.NewClusterAdmin(brokers, config)
+		if err == nil {
+			if _, _, err = admin.DescribeCluster(); err == nil {
+				return admin, nil
+			}
+			admin.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Brokers returns the address to connect to, for NewProducer, NewConsumer
+// and the other constructors of pkg/kafka.
+func (k *Kafka) Brokers() []string {
+	return k.brokers
+}
+
+// Admin returns a cluster admin connected to the broker. It is closed with
+// the broker.
+func (k *Kafka) Admin() sarama.ClusterAdmin {
+	return k.admin
+}
+
+// CreateTopic creates topic with partitions partitions.
+func (k *Kafka) CreateTopic(t testing.TB, topic string, partitions int32) {
+	t.Helper()
+	admin, err := kafka.NewAdmin(k.brokers)
+	if err != nil {
+		t.Fatalf("testkafka: %v", err)
+	}
+	defer admin.Close()
+	if _, err := kafka.EnsureTopic(admin, topic, partitions, 1); err != nil {
+		t.Fatalf("testkafka: %v", err)
+	}
+}
+
+// NewProducer connects a kafka.Producer for topic with opts, closed when t
+// finishes.
+func (k *Kafka) NewProducer(t testing.TB, topic string, opts ...kafka.Option) *kafka.Producer {
+	t.Helper()
+	producer, err := kafka.NewProducer(k.Brokers(), topic, opts...)
+	if err != nil {
+		t.Fatalf("testkafka: failed to create producer: %v", err)
+	}
+	t.Cleanup(func() { producer.Close() })
+	return producer
+}
+
+// Produce sends a message with the default producer settings and returns
+// where it was written.
+func (k *Kafka) Produce(t testing.TB, topic, key, value string) Record {
+	t.Helper()
+	producer, err := k.producer(topic)
+	if err != nil {
+		t.Fatalf("testkafka: failed to create producer: %v", err)
+	}
+	return SendMessage(t, producer, key, value)
+}
+
+func (k *Kafka) producer(topic string) (*kafka.Producer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if producer, ok := k.producers[topic]; ok {
+		return producer, nil
+	}
+	producer, err := kafka.NewProducer(k.Brokers(), topic)
+	if err != nil {
+		return nil, err
+	}
+	k.producers[topic] = producer
+	return producer, nil
+}
+
+// SendMessage sends a message with producer, failing t if it isn't
+// acknowledged.
+func SendMessage(t testing.TB, producer *kafka.Producer, key, value string) Record {
+	t.Helper()
+	partition, offset, err := producer.SendMessage(key, value)
+	if err != nil {
+		t.Fatalf("testkafka: failed to produce to %s: %v", producer.Topic(), err)
+	}
+	return Record{Key: key, Value: value, Partition: partition, Offset: offset}
+}
+
+// Consume runs a kafka.Consumer in group from the beginning of topic until
+// it has handled n distinct messages, and returns them ordered by partition
+// and offset. opts are added to the consumer's, so tests can exercise
+// handlers, retries or commit strategies end to end; a WithHandler among
+// them is replaced. t fails if fewer than n messages arrive within timeout.
+func (k *Kafka) Consume(t testing.TB, topic, group string, n int, timeout time.Duration, opts ...kafka.Option) []*kafka.Message {
+	t.Helper()
+	opts = append([]kafka.Option{kafka.WithStartFromBeginning()}, opts...)
+	return k.consume(t, topic, group, n, timeout, opts)
+}
+
+// Resume is like Consume but starts from the offsets group committed, so a
+// test can check where an earlier consumer of the group left off.
+func (k *Kafka) Resume(t testing.TB, topic, group string, n int, timeout time.Duration, opts ...kafka.Option) []*kafka.Message {
+	t.Helper()
+	return k.consume(t, topic, group, n, timeout, opts)
+}
+
+func (k *Kafka) consume(t testing.TB, topic, group string, n int, timeout time.Duration, opts []kafka.Option) []*kafka.Message {
+	t.Helper()
+
+	var mu sync.Mutex
+	seen := make(map[string]*kafka.Message)
+	done := make(chan struct{})
+	collect := kafka.HandlerFunc(func(ctx context.Context, message *kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// Redeliveries after a rebalance count once.
+		id := fmt.Sprintf("%s/%d/%d", message.Topic, message.Partition, message.Offset)
+		if _, ok := seen[id]; ok || len(seen) >= n {
+			return nil
+		}
+		seen[id] = message
+		if len(seen) == n {
+			close(done)
+		}
+		return nil
+	})
+
+	opts = append(opts[:len(opts):len(opts)], kafka.WithHandler(collect))
+	consumer, err := kafka.NewConsumer(k.Brokers(), topic, group, opts...)
+	if err != nil {
+		t.Fatalf("testkafka: failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- consumer.Consume(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	case err := <-result:
+		cancel()
+		t.Fatalf("testkafka: consumer stopped: %v", err)
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("testkafka: consumer failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	messages := make([]*kafka.Message, 0, len(seen))
+	for _, message := range seen {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(a, b int) bool {
+		if messages[a].Partition != messages[b].Partition {
+			return messages[a].Partition < messages[b].Partition
+		}
+		return messages[a].Offset < messages[b].Offset
+	})
+	if len(messages) < n {
+		t.Fatalf("testkafka: consumed %d of %d messages from %s within %s", len(messages), n, topic, timeout)
+	}
+	return messages
+}
+
+// AssertDelivered checks that every produced record was consumed from the
+// partition and offset it was written to, with the same key and value.
+func AssertDelivered(t testing.TB, produced []Record, consumed []*kafka.Message) {
+	t.Helper()
+	type position struct {
+		partition int32
+		offset    int64
+	}
+	byPosition := make(map[position]*kafka.Message, len(consumed))
+	for _, message := range consumed {
+		byPosition[position{message.Partition, message.Offset}] = message
+	}
+	for _, record := range produced {
+		message, ok := byPosition[position{record.Partition, record.Offset}]
+		switch {
+		case !ok:
+			t.Errorf("testkafka: key %s at partition %d offset %d wasn't consumed", record.Key, record.Partition, record.Offset)
+		case string(message.Key) != record.Key || string(message.Value) != record.Value:
+			t.Errorf("testkafka: partition %d offset %d: consumed %s=%s, produced %s=%s",
+				record.Partition, record.Offset, message.Key, message.Value, record.Key, record.Value)
+		}
+	}
+}
+
+// AssertPartitionAffinity checks that all messages with the same key are in
+// the same partition, which per-key ordering depends on, and returns the
+// partition of each key. Messages without a key are ignored.
+func AssertPartitionAffinity(t testing.TB, messages []*kafka.Message) map[string]int32 {
+	t.Helper()
+	partitions := make(map[string]map[int32]bool)
+	for _, message := range messages {
+		if len(message.Key) == 0 {
+			continue
+		}
+		key := string(message.Key)
+		if partitions[key] == nil {
+			partitions[key] = make(map[int32]bool)
+		}
+		partitions[key][message.Partition] = true
+	}
+
+	affinity := make(map[string]int32, len(partitions))
+	for key, seen := range partitions {
+		list := make([]string, 0, len(seen))
+		for partition := range seen {
+			affinity[key] = partition
+			list = append(list, fmt.Sprint(partition))
+		}
+		if len(seen) > 1 {
+			sort.Strings(list)
+			t.Errorf("testkafka: key %s is spread over partitions %s", key, strings.Join(list, ", "))
+		}
+	}
+	return affinity
+}
+
+// AssertKeyOrder checks that the messages of every key were consumed in the
+// order they were produced.
+func AssertKeyOrder(t testing.TB, produced []Record, consumed []*kafka.Message) {
+	t.Helper()
+	want := make(map[string][]string)
+	for _, record := range produced {
+		want[record.Key] = append(want[record.Key], record.Value)
+	}
+	// Consume returns messages ordered by partition and offset, which is the
+	// order of each key once affinity holds.
+	got := make(map[string][]string)
+	for _, message := range consumed {
+		got[string(message.Key)] = append(got[string(message.Key)], string(message.Value))
+	}
+	for key, values := range want {
+		if strings.Join(got[key], "\x00") != strings.Join(values, "\x00") {
+			t.Errorf("testkafka: key %s consumed as %q, produced as %q", key, got[key], values)
+		}
+	}
+}
+
+// randomSuffix tells the clusters of parallel tests and packages apart.
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,27 @@
+package testkafka_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"kafka-hwsw/pkg/kafka"
+	"kafka-hwsw/pkg/testkafka"
+)
+
+func TestKeysStayOnTheirPartition(t *testing.T) {
+	k := testkafka.Start(t)
+	k.CreateTopic(t, "user-events", 3)
+
+	producer := k.NewProducer(t, "user-events", kafka.WithPartitioner("hash"))
+	var sent []testkafka.Record
+	for i := 0; i < 30; i++ {
+		sent = append(sent, testkafka.SendMessage(t, producer, fmt.Sprintf("user-%d", i%5), strconv.Itoa(i)))
+	}
+
+	got := k.Consume(t, "user-events", "affinity-test", len(sent), 30*time.Second)
+	testkafka.AssertDelivered(t, sent, got)
+	testkafka.AssertPartitionAffinity(t, got)
+	testkafka.AssertKeyOrder(t, sent, got)
+}